@@ -0,0 +1,80 @@
+package main
+
+import (
+	"evo/internal/alternates"
+	"evo/internal/repo"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var alternatesCmd = &cobra.Command{
+		Use:   "alternates",
+		Short: "List, add and remove repositories this one borrows content from",
+		Long: `A repository can borrow from others on the same machine, such as a shared
+store that CI checkouts are cloned from (see "evo clone --shared"): LFS
+chunks, blobs, sealed op log segments and commit files it doesn't hold
+itself are read from theirs. Nothing is ever written to an alternate.
+Without a subcommand, lists the data directories borrowed from.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			alts, err := repo.Alternates(c.Repo)
+			if err != nil {
+				return err
+			}
+			if alts == nil {
+				alts = []string{}
+			}
+			return c.Emit(alts, func() {
+				for _, a := range alts {
+					c.Printf("%s\n", a)
+				}
+			})
+		},
+	}
+
+	var addCmd = &cobra.Command{
+		Use:   "add <repository>",
+		Short: "Borrow content from another repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			dir, err := alternates.Add(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			return c.Done(map[string]any{"dir": dir}, "Borrowing from %s\n", dir)
+		},
+	}
+
+	var removeCmd = &cobra.Command{
+		Use:   "remove <repository>",
+		Short: "Copy in what is borrowed, then stop borrowing from a repository",
+		Long: `Copies into this repository the op log segments, commit files and LFS chunks
+it reads from its alternates, then removes the repository from the list, so
+it can be moved or deleted without this one losing content.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			res, err := alternates.Remove(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			return c.Done(res, "Stopped borrowing from %s; copied %d segment(s), %d commit(s), %d chunk(s)\n",
+				res.Dir, res.Segments, res.Commits, res.Chunks)
+		},
+	}
+
+	alternatesCmd.AddCommand(addCmd, removeCmd)
+	rootCmd.AddCommand(alternatesCmd)
+}
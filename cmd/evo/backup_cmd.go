@@ -0,0 +1,88 @@
+package main
+
+import (
+	"evo/internal/backup"
+	"evo/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var full bool
+	var snapshot string
+
+	var backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Snapshot the repository into a backup directory and restore it",
+	}
+
+	var createCmd = &cobra.Command{
+		Use:   "create <dest>",
+		Short: "Snapshot .evo into the backup directory dest",
+		Long: `Copies a consistent snapshot of .evo, LFS chunks included, into dest.
+Maintenance is paused and the LFS store locked while the snapshot is taken.
+dest keeps each file once by content, so after the first snapshot only files
+changed since the last one are copied; --full reads every file again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			res, err := backup.Create(c.Repo, args[0], full)
+			if err != nil {
+				return err
+			}
+			return c.Done(res, "Created snapshot %s: %d files, %s; copied %d new files, %s\n",
+				res.Snapshot, res.Files, util.HumanBytes(res.Bytes), res.Copied, util.HumanBytes(res.Copy))
+		},
+	}
+	createCmd.Flags().BoolVar(&full, "full", false, "Read every file instead of trusting size and mtime")
+
+	var listCmd = &cobra.Command{
+		Use:   "list <dest>",
+		Short: "List the snapshots in a backup directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
+			names, err := backup.Snapshots(args[0])
+			if err != nil {
+				return err
+			}
+			if names == nil {
+				names = []string{}
+			}
+			return c.Emit(names, func() {
+				for _, n := range names {
+					c.Printf("%s\n", n)
+				}
+			})
+		},
+	}
+
+	var restoreCmd = &cobra.Command{
+		Use:   "restore <dest> [<dir>]",
+		Short: "Recreate a repository from a backup",
+		Long: `Restores .evo in dir (default: the working directory) from the latest
+snapshot in dest, or the one named by --snapshot. dir must not hold a
+repository already. Only .evo is restored, not the working tree.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
+			target := "."
+			if len(args) == 2 {
+				target = args[1]
+			}
+			m, err := backup.Restore(args[0], snapshot, target)
+			if err != nil {
+				return err
+			}
+			return c.Done(map[string]any{"snapshot": m.Name, "files": len(m.Files), "dir": target},
+				"Restored snapshot %s (%d files) into %s\n", m.Name, len(m.Files), target)
+		},
+	}
+	restoreCmd.Flags().StringVar(&snapshot, "snapshot", "", "Snapshot to restore (default: the latest)")
+
+	backupCmd.AddCommand(createCmd, listCmd, restoreCmd)
+	rootCmd.AddCommand(backupCmd)
+}
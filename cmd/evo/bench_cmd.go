@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"evo/internal/bench"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	o := bench.DefaultOptions
+	var keep bool
+	var baselinePath string
+	var tolerance float64
+	var benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Time status, commit, merge and checkout on a synthetic repository",
+		Long: `Generates a repository of --files files of --lines lines each, with a history of
+--commits commits, in a temporary directory, then times each operation --runs
+times: status, a commit editing a tenth of the files, merging the history
+after the first commit into a new stream, checking out the first commit and
+checking out the newest again. The same --seed generates the same repository.
+
+Save the output of --json and pass it as --baseline to a later run to compare:
+operations whose median got slower than the baseline by more than
+--tolerance make the command fail. It runs outside any repository.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
+			var baseline *bench.Result
+			if baselinePath != "" {
+				data, err := os.ReadFile(baselinePath)
+				if err != nil {
+					return fmt.Errorf("failed to read baseline: %w", err)
+				}
+				if err := json.Unmarshal(data, &baseline); err != nil {
+					return fmt.Errorf("failed to parse baseline %s: %w", baselinePath, err)
+				}
+			}
+			r, dir, err := bench.Run(o, keep)
+			if err != nil {
+				return err
+			}
+			if err := c.Emit(r, func() {
+				c.Printf("%s", r.Format(baseline))
+			}); err != nil {
+				return err
+			}
+			if dir != "" {
+				c.Infof("Kept the repository in %s\n", dir)
+			}
+			if baseline == nil {
+				return nil
+			}
+			regs := r.Compare(baseline, tolerance)
+			for _, reg := range regs {
+				c.Warnf("%s: median %.2fms, baseline %.2fms\n", reg.Name, reg.Median*1000, reg.Baseline*1000)
+			}
+			if len(regs) > 0 {
+				return fmt.Errorf("found %d regression(s) over %.0f%%", len(regs), tolerance*100)
+			}
+			return nil
+		},
+	}
+	benchCmd.Flags().IntVar(&o.Files, "files", o.Files, "Files in the generated repository")
+	benchCmd.Flags().IntVar(&o.Lines, "lines", o.Lines, "Lines per file")
+	benchCmd.Flags().IntVar(&o.Commits, "commits", o.Commits, "Commits of the generated history")
+	benchCmd.Flags().IntVar(&o.Runs, "runs", o.Runs, "Timed runs of each operation")
+	benchCmd.Flags().Int64Var(&o.Seed, "seed", o.Seed, "Seed of the generated content")
+	benchCmd.Flags().BoolVar(&keep, "keep", false, "Keep the generated repository and print where it is")
+	benchCmd.Flags().StringVar(&baselinePath, "baseline", "", "JSON output of an earlier run to compare with")
+	benchCmd.Flags().Float64Var(&tolerance, "tolerance", 0.2, "Slowdown against --baseline reported as a regression (0.2 for 20%)")
+	rootCmd.AddCommand(benchCmd)
+}
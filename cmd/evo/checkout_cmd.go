@@ -0,0 +1,83 @@
+package main
+
+import (
+	"evo/internal/checkout"
+	"evo/internal/commits"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var force bool
+
+	var checkoutCmd = &cobra.Command{
+		Use:   "checkout <stream|commit-ish>",
+		Short: "Check out a stream, or a past commit with HEAD detached",
+		Long: `Given a stream, makes it current and rewrites the tracked files from its
+newest state. Given any other commit-ish, writes the tracked files as of that
+commit without switching streams and records the commit in HEAD: the working
+tree is then a read-only view, and commit refuses to run until you check out
+a stream again or branch with 'evo stream create <name> --from <commit>'.
+
+Uncommitted changes are not overwritten unless --force is given.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeStreams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			names, err := streams.ListStreams(c.Repo)
+			if err != nil {
+				return err
+			}
+			stream := ""
+			for _, n := range names {
+				if n == args[0] {
+					stream = n
+				}
+			}
+			var commitID string
+			if stream == "" {
+				commit, found, err := revparse.Resolve(c.Repo, args[0])
+				if err != nil {
+					return err
+				}
+				stream = found
+				idx, err := commits.LoadIndex(c.Repo)
+				if err != nil {
+					return err
+				}
+				es, err := idx.Stream(stream)
+				if err != nil {
+					return err
+				}
+				// the newest commit of a stream is the stream itself
+				if len(es) == 0 || es[len(es)-1].ID != commit.ID {
+					commitID = commit.ID
+				}
+			}
+			var res *checkout.Result
+			if commitID != "" {
+				res, err = checkout.Detach(c.Repo, stream, commitID, force)
+			} else {
+				res, err = checkout.Attach(c.Repo, stream, force)
+			}
+			if err == checkout.ErrDirty {
+				return fmt.Errorf("%w; commit them or use --force to discard them", err)
+			}
+			if err != nil {
+				return err
+			}
+			if commitID != "" {
+				return c.Done(res, "HEAD is now detached at %s on stream %s (%d file(s) written, %d removed)\n", commitID, stream, len(res.Written), len(res.Removed))
+			}
+			return c.Done(res, "Checked out stream %s (%d file(s) written, %d removed)\n", stream, len(res.Written), len(res.Removed))
+		},
+	}
+	checkoutCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite uncommitted changes")
+	rootCmd.AddCommand(checkoutCmd)
+}
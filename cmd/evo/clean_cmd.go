@@ -0,0 +1,54 @@
+package main
+
+import (
+	"evo/internal/worktree"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts worktree.CleanOptions
+	var force bool
+
+	var cleanCmd = &cobra.Command{
+		Use:   "clean [-n | -f] [-d] [-x]",
+		Short: "Remove untracked files from the working tree",
+		Long: `Removes the files of the working tree that aren't tracked, such as those
+left out with 'evo rm --cached' or created since the last commit. Files
+matched by .evo-ignore are kept unless -x is given. Directories holding no
+tracked file are only removed with -d, and never when they hold another
+repository.
+
+As removed files can't be brought back, clean needs -f; -n lists what
+would be removed instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !force && !opts.DryRun {
+				return fmt.Errorf("refusing to clean without -f; use -n to see what would be removed")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			removed, err := worktree.Clean(c.Repo, opts)
+			if err != nil {
+				return err
+			}
+			verb := "Removing"
+			if opts.DryRun {
+				verb = "Would remove"
+			}
+			return c.Emit(map[string]any{"removed": removed, "dryRun": opts.DryRun}, func() {
+				for _, p := range removed {
+					c.Printf("%s %s\n", verb, p)
+				}
+			})
+		},
+	}
+	cleanCmd.Flags().BoolVarP(&opts.DryRun, "dry-run", "n", false, "Only list what would be removed")
+	cleanCmd.Flags().BoolVarP(&force, "force", "f", false, "Remove the files")
+	cleanCmd.Flags().BoolVarP(&opts.Dirs, "dirs", "d", false, "Also remove untracked directories")
+	cleanCmd.Flags().BoolVarP(&opts.Ignored, "ignored", "x", false, "Also remove files matched by .evo-ignore")
+	rootCmd.AddCommand(cleanCmd)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"evo/internal/clone"
+	"evo/internal/util"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts clone.Options
+	var limitRate string
+	var jobs int
+
+	var cloneCmd = &cobra.Command{
+		Use:   "clone <source> [<dir>]",
+		Short: "Copy a repository on this machine into a new directory",
+		Long: `Copies the repository at source, a path, into dir (default: the base name of
+source in the working directory) and checks out the stream source has checked
+out. The clone gets a node identity of its own; staged ops, the undo journal
+and other state of the source's working tree are left behind.
+
+--local hard-links the files nothing changes in place, LFS chunks, blobs,
+sealed op log segments and commit files, when both directories are on one
+filesystem, and
+reflinks the rest where the filesystem supports it (Btrfs, XFS, APFS), so a
+clone takes little space of its own. Without it every file is copied.
+
+--shared takes none of these at all: the clone lists source in
+.evo/alternates and reads them from there. Source must then stay in place,
+and pruning chunks or blobs in it can leave the clone without content it
+needs; "evo alternates remove" copies in what the clone borrows.
+
+Files are copied --jobs at a time, at no more than --limit-rate bytes per
+second altogether.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
+			src := args[0]
+			dir := filepath.Base(strings.TrimRight(src, `/\`))
+			if len(args) == 2 {
+				dir = args[1]
+			}
+			t, err := transferOptions(cmd, "", jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			opts.Transfer = t
+			ctx, stop := interruptible()
+			defer stop()
+			res, err := clone.Clone(c.progress(ctx), src, dir, opts)
+			if err != nil {
+				return err
+			}
+			how := "copied " + util.HumanBytes(res.Copied)
+			if opts.Local {
+				how += fmt.Sprintf(", %d file(s) linked, %d reflinked", res.Linked, res.Reflinked)
+			}
+			if res.Shared {
+				how += ", objects shared with " + src
+			}
+			return c.Done(res, "Cloned %s into %s on stream %s (%s)\n", src, dir, res.Stream, how)
+		},
+	}
+	cloneCmd.Flags().BoolVar(&opts.Local, "local", false, "Hard-link and reflink files instead of copying them")
+	cloneCmd.Flags().BoolVar(&opts.Shared, "shared", false, "Borrow the source's chunks, blobs, sealed segments and commits through .evo/alternates")
+	cloneCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Files to copy at once (default: transfer.jobs)")
+	cloneCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Bytes per second to limit copying to, e.g. 500k (default: transfer.limitRate)")
+	rootCmd.AddCommand(cloneCmd)
+}
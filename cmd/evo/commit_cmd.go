@@ -1,61 +1,350 @@
 package main
 
 import (
+	"encoding/json"
 	"evo/internal/commits"
-	"evo/internal/config"
+	"evo/internal/guard"
+	"evo/internal/identity"
 	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/issues"
+	"evo/internal/journal"
+	"evo/internal/ops"
 	"evo/internal/repo"
-	"evo/internal/streams"
+	"evo/internal/textenc"
+	"evo/internal/trailers"
 	"evo/internal/types"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	commitMsg  string
-	commitSign bool
+	commitMsg    string
+	commitSign   bool
+	commitAuthor string
+	commitForce  bool
+
+	commitNoVerify bool
+	commitAmendOps string
+
+	commitCoAuthors []string
+	commitReviewers []string
+	commitIssues    []string
+	commitCloses    []string
+	commitTrailers  []string
 )
 
+// commitFlagTrailers builds the trailers given with --co-author, --reviewed-by,
+// --issue and --trailer
+func commitFlagTrailers() ([]types.Trailer, error) {
+	var ts []types.Trailer
+	add := func(key string, values []string) error {
+		for _, v := range values {
+			t, err := trailers.New(key, v)
+			if err != nil {
+				return err
+			}
+			ts = append(ts, t)
+		}
+		return nil
+	}
+	if err := add(trailers.CoAuthoredBy, commitCoAuthors); err != nil {
+		return nil, err
+	}
+	if err := add(trailers.ReviewedBy, commitReviewers); err != nil {
+		return nil, err
+	}
+	if err := add(trailers.Refs, commitIssues); err != nil {
+		return nil, err
+	}
+	if err := add(trailers.Closes, commitCloses); err != nil {
+		return nil, err
+	}
+	for _, raw := range commitTrailers {
+		t, err := trailers.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
 func init() {
 	var commitCmd = &cobra.Command{
 		Use:   "commit",
 		Short: "Group new CRDT ops into a commit, optionally signed",
-		Long: `Collect newly added CRDT ops (including old content for updates) into a single commit
-with a message and optional Ed25519 signature, if configured.`,
+		Long: `Record the changes in the working tree as CRDT ops, then collect every op not yet
+in a commit (including old content for updates), together with staged ops, into a
+single commit with a message and optional Ed25519 signature, if configured. Fails
+with "nothing to commit" when there are no new ops, no renames staged by
+"evo mv", which are recorded as Renamed trailers, and no file whose text
+encoding changed, recorded as Encoding trailers.
+
+Trailers such as Co-authored-by come from the flags below, from a last
+paragraph of "Key: value" lines in the message, and from the executable
+.evo/hooks/commit-trailers if present. They are covered by the signature.
+Closes, Fixes and Resolves trailers close the evo issues they name.
+
+The guards in the config (guard.maxFileSize, guard.forbidden, guard.secrets)
+are checked against what the commit leaves in the files it touches; a commit
+failing them is refused. Its changes stay recorded in the op log: fix them,
+with "evo rm" for files that shouldn't be tracked, and commit again, run
+"evo undo" to drop them from the op log, or skip the guards with --no-verify.
+
+--amend-ops adds changes a tool generated to the commit: JSON Lines such as
+{"op":"update","path":"main.go","line":3,"content":"..."}, with op insert,
+update or delete and lines counted from 1 as the ops before leave the file.
+They are checked against the files after the working tree is recorded, and
+none is applied if one doesn't fit.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if commitMsg == "" {
 				return fmt.Errorf("use -m to specify a commit message")
 			}
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			stream, err := streams.CurrentStream(rp)
+			rp := c.Repo
+			head, err := repo.ReadHead(rp)
 			if err != nil {
 				return err
 			}
+			stream := head.Stream
+			if head.Detached != "" {
+				if !commitForce {
+					return fmt.Errorf("refusing to commit with HEAD detached at %s; use 'evo stream create <name> --from %s' to branch, or --force", head.Detached, head.Detached)
+				}
+				c.Warnf("HEAD is detached at %s; committing records the checked-out files on top of the newest commit of %s, undoing later changes to them\n", head.Detached, stream)
+			}
 			// update index
 			if err := index.UpdateIndex(rp); err != nil {
 				return err
 			}
-			name, _ := config.GetConfigValue(rp, "user.name")
-			email, _ := config.GetConfigValue(rp, "user.email")
-			if name == "" {
-				name = "EvoUser"
+			author, err := identity.ForCommit(rp, commitAuthor)
+			if err != nil {
+				return err
 			}
-			if email == "" {
-				email = "user@evo"
+			msg, ts := trailers.Split(commitMsg)
+			flagged, err := commitFlagTrailers()
+			if err != nil {
+				return err
 			}
-			cid, err := commits.CreateCommit(rp, stream, commitMsg, name, email, []types.ExtendedOp{}, commitSign)
+			hooked, err := trailers.RunHook(rp, stream, msg, author)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Created commit %s in stream %s\n", cid.ID, stream)
-			return nil
+			ts = trailers.Merge(ts, append(flagged, hooked...)...)
+			ctx, stop := interruptible()
+			defer stop()
+			return journaled(rp, "commit", commitMsg, func(rec *journal.Recorder) error {
+				for _, name := range []string{stream + ".json", stream + ".renames.json"} {
+					if err := rec.TrackFile(filepath.Join(repo.EvoDir, "staged", name)); err != nil {
+						return err
+					}
+				}
+				staged, err := commits.LoadStaged(rp, stream)
+				if err != nil {
+					return err
+				}
+				renames, err := commits.LoadStagedRenames(rp, stream)
+				if err != nil {
+					return err
+				}
+				for _, r := range renames {
+					ts = trailers.Merge(ts, types.Trailer{Key: trailers.Renamed, Value: r.From + " -> " + r.To})
+				}
+				if err := trackOpFiles(rec, rp, staged); err != nil {
+					return err
+				}
+				if err := rec.TrackFile(filepath.Join(repo.EvoDir, "encodings")); err != nil {
+					return err
+				}
+				before, err := encodings(rp, stream)
+				if err != nil {
+					return err
+				}
+				// record working tree edits before staged ops rewrite the files
+				changed, err := ingest.IngestLocalChanges(c.progress(ctx), rp, stream)
+				if err != nil {
+					return fmt.Errorf("failed to record working tree changes: %w", err)
+				}
+				warnLocked(c, changed)
+				recoded, err := encodingTrailers(rp, before)
+				if err != nil {
+					return err
+				}
+				ts = trailers.Merge(ts, recoded...)
+				// staged ops (e.g. from revert --no-commit) are not in the op log yet
+				if err := commits.ApplyOps(rp, stream, staged); err != nil {
+					return err
+				}
+				if commitAmendOps != "" {
+					if err := amendOps(rec, rp, stream, commitAmendOps); err != nil {
+						return err
+					}
+				}
+				eops, err := commits.GatherNewOps(rp, stream)
+				if err != nil {
+					return err
+				}
+				if len(eops) == 0 && len(renames) == 0 && len(recoded) == 0 {
+					return fmt.Errorf("nothing to commit")
+				}
+				if !commitNoVerify {
+					if err := checkGuards(c, rp, stream, eops); err != nil {
+						return err
+					}
+				}
+				cid, err := commits.CreateCommitWithTrailers(rp, stream, msg, author.Name, author.Email, ts, eops, commitSign)
+				if err != nil {
+					return err
+				}
+				if err := commits.ClearStaged(rp, stream); err != nil {
+					return err
+				}
+				if err := commits.ClearStagedRenames(rp, stream); err != nil {
+					return err
+				}
+				if head.Detached != "" {
+					// the working tree is the stream's newest state again
+					if err := repo.WriteHead(rp, repo.Head{Stream: stream}); err != nil {
+						return err
+					}
+				}
+				closed, err := issues.ApplyTrailers(rp, cid)
+				if err != nil {
+					return fmt.Errorf("failed to update issues: %w", err)
+				}
+				if err := c.Done(map[string]any{"id": cid.ID, "stream": stream, "ops": len(cid.Operations), "closedIssues": closed}, "Created commit %s in stream %s\n", cid.ID, stream); err != nil {
+					return err
+				}
+				for _, id := range closed {
+					c.Infof("Closed issue %s\n", id[:8])
+				}
+				return nil
+			})
 		},
 	}
 	commitCmd.Flags().StringVarP(&commitMsg, "message", "m", "", "Commit message")
+	commitCmd.Flags().StringVar(&commitAuthor, "author", "", "Override the author, as \"Name <email>\"")
+	commitCmd.Flags().StringArrayVar(&commitCoAuthors, "co-author", nil, "Add a Co-authored-by trailer (\"Name <email>\", repeatable)")
+	commitCmd.Flags().StringArrayVar(&commitReviewers, "reviewed-by", nil, "Add a Reviewed-by trailer (\"Name <email>\", repeatable)")
+	commitCmd.Flags().StringArrayVar(&commitIssues, "issue", nil, "Add a Refs trailer referencing an issue (repeatable)")
+	commitCmd.Flags().StringArrayVar(&commitCloses, "closes", nil, "Add a Closes trailer that closes the issue when committed (repeatable)")
+	commitCmd.Flags().StringArrayVar(&commitTrailers, "trailer", nil, "Add a \"Key: value\" trailer (repeatable)")
 	commitCmd.Flags().BoolVar(&commitSign, "sign", false, "Sign commit using Ed25519 if configured")
+	commitCmd.Flags().BoolVar(&commitForce, "force", false, "Commit even with HEAD detached")
+	commitCmd.Flags().BoolVar(&commitNoVerify, "no-verify", false, "Skip the commit guards")
+	commitCmd.Flags().StringVar(&commitAmendOps, "amend-ops", "", "Add the ops in this JSON Lines file (- for standard input) to the commit")
 	rootCmd.AddCommand(commitCmd)
 }
+
+// amendOps applies the ops described in the JSON Lines file at path, "-"
+// for standard input, tracking the files they touch for undo
+func amendOps(rec *journal.Recorder, rp, stream, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := commits.NewBuilder(rp, stream)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var s commits.OpSpec
+		if err := dec.Decode(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read op %d of %s: %w", n, path, err)
+		}
+		if err := b.AddSpec(s); err != nil {
+			return fmt.Errorf("op %d of %s: %w", n, path, err)
+		}
+		// nothing is written before Apply
+		if err := rec.TrackFile(filepath.FromSlash(s.Path)); err != nil {
+			return err
+		}
+	}
+	_, err = b.Apply()
+	return err
+}
+
+// encodings returns the recorded encoding of every tracked file the stream
+// has an op log for
+func encodings(rp, stream string) (map[string]textenc.Encoding, error) {
+	recorded, err := index.LoadEncodings(rp)
+	if err != nil {
+		return nil, err
+	}
+	_, id2p, err := index.LoadIndex(rp)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]textenc.Encoding)
+	for fid := range id2p {
+		if ops.LogSize(filepath.Join(repo.Dir(rp), "ops", stream, fid+".bin")) == 0 {
+			continue
+		}
+		out[fid] = textenc.UTF8
+		if e, ok := recorded[fid]; ok {
+			out[fid] = e
+		}
+	}
+	return out, nil
+}
+
+// encodingTrailers describes the files ingest found in another encoding than
+// before, as Encoding trailers. New files have no trailer.
+func encodingTrailers(rp string, before map[string]textenc.Encoding) ([]types.Trailer, error) {
+	after, err := index.LoadEncodings(rp)
+	if err != nil {
+		return nil, err
+	}
+	_, id2p, err := index.LoadIndex(rp)
+	if err != nil {
+		return nil, err
+	}
+	var out []types.Trailer
+	for fid, from := range before {
+		to, ok := after[fid]
+		if !ok {
+			to = textenc.UTF8
+		}
+		if p, tracked := id2p[fid]; tracked && from != to {
+			out = append(out, types.Trailer{Key: trailers.Encoding, Value: fmt.Sprintf("%s %s -> %s", p, from, to)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Value < out[j].Value })
+	return out, nil
+}
+
+// checkGuards refuses a commit of eops that fails the stream's guards
+func checkGuards(c *cmdContext, rp, stream string, eops []types.ExtendedOp) error {
+	g, err := guard.Load(rp, stream)
+	if err != nil {
+		return fmt.Errorf("failed to load guards: %w", err)
+	}
+	violations, err := g.Check(rp, stream, eops, true)
+	if err != nil {
+		return fmt.Errorf("failed to check guards: %w", err)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		c.Warnf("%s\n", v)
+	}
+	return fmt.Errorf("commit rejected by %d guard violation(s); fix them, or use --no-verify to commit anyway", len(violations))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/streams"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+func init() {
+	var completionCmd = &cobra.Command{
+		Use:   "completion <bash|zsh|fish|powershell>",
+		Short: "Generate a shell completion script",
+		Long: `Prints a completion script for the given shell. For example:
+
+  evo completion bash > /etc/bash_completion.d/evo
+  evo completion zsh > "${fpath[1]}/_evo"
+  evo completion fish > ~/.config/fish/completions/evo.fish
+  evo completion powershell | Out-String | Invoke-Expression
+
+Stream names, commit IDs and config keys are completed from the repository.`,
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return rootCmd.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				return rootCmd.GenZshCompletion(os.Stdout)
+			case "fish":
+				return rootCmd.GenFishCompletion(os.Stdout, true)
+			default:
+				return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+			}
+		},
+	}
+
+	var docsCmd = &cobra.Command{
+		Use:   "docs",
+		Short: "Generate documentation",
+	}
+	var manCmd = &cobra.Command{
+		Use:   "man [dir]",
+		Short: "Write man pages for every command to dir (default ./man)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "man"
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			header := &doc.GenManHeader{Title: "EVO", Section: "1", Source: "Evo"}
+			rootCmd.DisableAutoGenTag = true
+			if err := doc.GenManTree(rootCmd, header, dir); err != nil {
+				return fmt.Errorf("failed to generate man pages: %w", err)
+			}
+			return baseContext().Done(map[string]string{"dir": dir}, "Wrote man pages to %s\n", dir)
+		},
+	}
+	docsCmd.AddCommand(manCmd)
+
+	rootCmd.AddCommand(completionCmd, docsCmd)
+}
+
+// completeStreams completes stream names of the repository in the working directory
+func completeStreams(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c, err := newContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names, err := streams.ListStreams(c.Repo)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var out []string
+	for _, n := range names {
+		if strings.HasPrefix(n, toComplete) {
+			out = append(out, n)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// commitCandidates returns IDs of commits in the given streams (all streams if
+// none) that start with prefix, each described by its message
+func commitCandidates(prefix string, only ...string) []string {
+	c, err := newContext()
+	if err != nil {
+		return nil
+	}
+	rp := c.Repo
+	names := only
+	if len(names) == 0 {
+		if names, err = streams.ListStreams(rp); err != nil {
+			return nil
+		}
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range names {
+		cs, err := commits.ListCommits(rp, s)
+		if err != nil {
+			continue
+		}
+		for i := len(cs) - 1; i >= 0; i-- {
+			c := cs[i]
+			if seen[c.ID] || !strings.HasPrefix(c.ID, prefix) {
+				continue
+			}
+			seen[c.ID] = true
+			msg, _, _ := strings.Cut(c.Message, "\n")
+			out = append(out, c.ID+"\t"+msg)
+		}
+	}
+	return out
+}
+
+// completeCurrentCommits completes IDs of commits in the checked-out stream
+func completeCurrentCommits(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c, err := newContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cur, err := streams.CurrentStream(c.Repo)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return commitCandidates(toComplete, cur), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeConfigKeys completes known config keys and keys set in the repository
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	rp := ""
+	if c, err := newContext(); err == nil {
+		rp = c.Repo
+	}
+	var out []string
+	for _, k := range config.Keys(rp) {
+		if strings.HasPrefix(k, toComplete) {
+			out = append(out, k)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
@@ -2,71 +2,202 @@ package main
 
 import (
 	"evo/internal/config"
-	"evo/internal/repo"
+	"evo/internal/everrors"
+	"evo/internal/log"
 	"fmt"
 
 	"github.com/spf13/cobra"
 )
 
-var cfgGlobal bool
+var (
+	cfgGlobal     bool
+	cfgSystem     bool
+	cfgShowOrigin bool
+	cfgAll        bool
+	cfgStream     string
+)
+
+// configScope picks the layer --global/--system select, repo by default
+func configScope() (config.Scope, error) {
+	switch {
+	case cfgGlobal && cfgSystem:
+		return 0, fmt.Errorf("--global and --system are mutually exclusive")
+	case cfgGlobal:
+		return config.ScopeGlobal, nil
+	case cfgSystem:
+		return config.ScopeSystem, nil
+	}
+	return config.ScopeRepo, nil
+}
+
+// configContext returns the command context and the repo it runs in, if any
+func configContext() (*cmdContext, string) {
+	if c, err := newContext(); err == nil {
+		return c, c.Repo
+	}
+	return baseContext(), ""
+}
+
+// loadConfig loads the config as the current stream, or --stream, sees it
+func loadConfig(rp string) (*config.Config, error) {
+	if cfgStream != "" {
+		return config.LoadForStream(rp, cfgStream)
+	}
+	return config.Load(rp)
+}
+
+// configOrigin names the layer of e, and its stream section if any
+func configOrigin(e config.Entry) string {
+	if e.Stream != "" {
+		return e.Scope + ":" + e.Stream
+	}
+	return e.Scope
+}
 
 func init() {
 	var setCmd = &cobra.Command{
-		Use:   "set <key> <value>",
-		Short: "Set a config key (repo-level by default, or --global)",
+		Use:               "set <key> <value>",
+		Short:             "Set a config key (repo-level by default, or --global/--system)",
+		ValidArgsFunction: completeConfigKeys,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 2 {
-				return fmt.Errorf("usage: evo config set <key> <value>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo config set <key> <value>")
 			}
 			key, val := args[0], args[1]
+			scope, err := configScope()
+			if err != nil {
+				return err
+			}
+			c, rp := configContext()
+			if rp == "" && scope == config.ScopeRepo {
+				// outside a repository, fall back to global
+				scope = config.ScopeGlobal
+			}
+			if _, known := config.Spec(key); !known {
+				log.For("config").Warn("setting a key evo does not know", "key", key)
+			}
+			if err := config.Set(rp, scope, key, val); err != nil {
+				return err
+			}
+			return c.Done(map[string]string{"key": key, "value": val, "scope": scope.String()}, "")
+		},
+	}
 
-			if cfgGlobal {
-				return config.SetGlobalConfigValue(key, val)
+	var unsetCmd = &cobra.Command{
+		Use:               "unset <key>",
+		Short:             "Remove a config key (repo-level by default, or --global/--system)",
+		ValidArgsFunction: completeConfigKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo config unset <key>")
+			}
+			scope, err := configScope()
+			if err != nil {
+				return err
+			}
+			c, rp := configContext()
+			if rp == "" && scope == config.ScopeRepo {
+				scope = config.ScopeGlobal
 			}
-			rp, err := repo.FindRepoRoot(".")
+			found, err := config.Unset(rp, scope, args[0])
 			if err != nil {
-				// fallback to global
-				return config.SetGlobalConfigValue(key, val)
+				return err
 			}
-			return config.SetRepoConfigValue(rp, key, val)
+			if !found {
+				return fmt.Errorf("%s is not set in the %s config", args[0], scope)
+			}
+			return c.Done(map[string]string{"unset": args[0], "scope": scope.String()}, "")
 		},
 	}
-	setCmd.Flags().BoolVar(&cfgGlobal, "global", false, "Set global config instead of repo-level")
 
 	var getCmd = &cobra.Command{
-		Use:   "get <key>",
-		Short: "Get a config value (repo-level overrides global)",
+		Use:               "get <key>",
+		Short:             "Get a config value (repo overrides global overrides system)",
+		ValidArgsFunction: completeConfigKeys,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("usage: evo config get <key>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo config get <key>")
 			}
 			key := args[0]
-			rp, err := repo.FindRepoRoot(".")
-			var val string
+			c, rp := configContext()
+			cfg, err := loadConfig(rp)
 			if err != nil {
-				// fallback global
-				val, err = config.GetConfigValue("", key)
-			} else {
-				val, err = config.GetConfigValue(rp, key)
+				return err
 			}
-			if err != nil {
-				fmt.Println("Error:", err)
+			e, ok := cfg.Lookup(key)
+			if c.JSON {
+				return c.Emit(map[string]any{"key": key, "value": e.Value, "set": ok, "scope": e.Scope, "stream": e.Stream}, nil)
+			}
+			if !ok {
+				c.Infof("No value found for key: %s\n", key)
 				return nil
 			}
-			if val == "" {
-				fmt.Printf("No value found for key: %s\n", key)
+			if cfgShowOrigin {
+				c.Printf("%s\t%s\n", configOrigin(e), e.Value)
 			} else {
-				fmt.Println(val)
+				c.Printf("%s\n", e.Value)
 			}
 			return nil
 		},
 	}
+	getCmd.Flags().BoolVar(&cfgShowOrigin, "show-origin", false, "Show which layer the value comes from")
+	getCmd.Flags().StringVar(&cfgStream, "stream", "", "Resolve stream sections for this stream instead of the current one")
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List effective config values",
+		Long: `Lists every key set in the system, global and repo config, with the value that
+wins. With --all, known keys that are not set are listed with their defaults.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, rp := configContext()
+			cfg, err := loadConfig(rp)
+			if err != nil {
+				return err
+			}
+			entries := cfg.List()
+			if cfgAll {
+				for _, k := range config.Keys("") {
+					if _, ok := cfg.Lookup(k); ok {
+						continue
+					}
+					spec, _ := config.Spec(k)
+					entries = append(entries, config.Entry{Key: k, Value: spec.Default, Scope: "default"})
+				}
+			}
+			for _, err := range cfg.Check() {
+				log.For("config").Warn("invalid config value", "err", err)
+			}
+			return c.Emit(entries, func() {
+				for _, e := range entries {
+					if cfgShowOrigin || cfgAll {
+						c.Printf("%-12s %s=%s\n", configOrigin(e), e.Key, e.Value)
+					} else {
+						c.Printf("%s=%s\n", e.Key, e.Value)
+					}
+				}
+			})
+		},
+	}
+	listCmd.Flags().BoolVar(&cfgShowOrigin, "show-origin", false, "Show which layer each value comes from")
+	listCmd.Flags().BoolVar(&cfgAll, "all", false, "Include known keys that are not set, with their defaults")
+	listCmd.Flags().StringVar(&cfgStream, "stream", "", "Resolve stream sections for this stream instead of the current one")
 
 	var configCmd = &cobra.Command{
 		Use:   "config",
 		Short: "Manage Evo configuration",
+		Long: `Config is read from three layers, each overriding the one before: system
+(/etc/evo/config.toml), global (~/.config/evo/config.toml) and repo
+(.evo/config/config.toml). Known keys are type checked when set.
+
+A [stream.<name>] section overrides keys while <name> is the current stream,
+and [includeIf."repo:<path>"] or [includeIf."stream:<glob>"] sections with a
+path key pull in another file when the condition holds.`,
+	}
+	for _, cmd := range []*cobra.Command{setCmd, unsetCmd} {
+		cmd.Flags().BoolVar(&cfgGlobal, "global", false, "Use the global config instead of the repo config")
+		cmd.Flags().BoolVar(&cfgSystem, "system", false, "Use the system config instead of the repo config")
 	}
 
-	configCmd.AddCommand(setCmd, getCmd)
+	configCmd.AddCommand(setCmd, unsetCmd, getCmd, listCmd)
 	rootCmd.AddCommand(configCmd)
 }
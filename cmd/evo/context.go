@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"evo/internal/platform"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"os"
+)
+
+// globalFlags holds the persistent flags of the root command
+var globalFlags struct {
+	repo    string
+	quiet   bool
+	noColor bool
+	json    bool
+}
+
+// cmdContext is the setup shared by every subcommand: the repository it works
+// on and how it should print
+type cmdContext struct {
+	Repo  string // repository root; empty for commands that run outside one
+	Quiet bool   // suppress informational messages
+	JSON  bool   // print machine-readable output instead of text
+	color bool
+	out   io.Writer
+}
+
+// newContext resolves the repository from --repo, or by searching upward from
+// the working directory
+func newContext() (*cmdContext, error) {
+	c := baseContext()
+	start := "."
+	if globalFlags.repo != "" {
+		start = globalFlags.repo
+	}
+	rp, err := repo.FindRepoRoot(start)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.CheckVersion(rp); err != nil {
+		return nil, err
+	}
+	c.Repo = rp
+	return c, nil
+}
+
+// baseContext is a context for commands that do not need a repository
+func baseContext() *cmdContext {
+	c := &cmdContext{
+		Quiet: globalFlags.quiet,
+		JSON:  globalFlags.json,
+		out:   os.Stdout,
+	}
+	c.color = !globalFlags.noColor && !c.JSON && os.Getenv("NO_COLOR") == "" && platform.IsTerminal(os.Stdout)
+	return c
+}
+
+// progress returns ctx with progress bars drawn to stderr, if it is a
+// terminal and neither --quiet nor --json is given
+func (c *cmdContext) progress(ctx context.Context) context.Context {
+	if c.Quiet || c.JSON || !platform.IsTerminal(os.Stderr) {
+		return ctx
+	}
+	return progress.With(ctx, progress.NewBar(os.Stderr))
+}
+
+// Infof prints a message unless --quiet or --json is given
+func (c *cmdContext) Infof(format string, args ...any) {
+	if c.Quiet || c.JSON {
+		return
+	}
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// Warnf prints a warning to stderr unless --quiet is given
+func (c *cmdContext) Warnf(format string, args ...any) {
+	if c.Quiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: "+format, args...)
+}
+
+// Printf prints command output; it is suppressed only by --json
+func (c *cmdContext) Printf(format string, args ...any) {
+	if c.JSON {
+		return
+	}
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// Emit prints v as JSON with --json and calls text otherwise
+func (c *cmdContext) Emit(v any, text func()) error {
+	if !c.JSON {
+		text()
+		return nil
+	}
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// Color wraps s in an ANSI color unless color output is off
+func (c *cmdContext) Color(code, s string) string {
+	if !c.color {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// Done reports the outcome of a command: v as JSON with --json, otherwise the
+// message unless --quiet is given
+func (c *cmdContext) Done(v any, format string, args ...any) error {
+	if c.JSON {
+		return c.Emit(v, nil)
+	}
+	c.Infof(format, args...)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"evo/internal/maintenance"
+	"evo/internal/platform"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var poll time.Duration
+	var daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Run background maintenance for this repository",
+		Long: `Runs in the foreground until interrupted, checking periodically whether
+maintenance is due. A run starts when scheduled maintenance is enabled and its
+interval has passed ('evo maintenance enable'), or when the repository grows past
+maintenance.auto.ops total ops or maintenance.auto.bytes on disk (0 disables a trigger).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			stop := make(chan struct{})
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sig
+				close(stop)
+			}()
+			c.Infof("Evo daemon started for %s (pid %d)\n", rp, os.Getpid())
+			return maintenance.Daemon(rp, poll, stop, func(reason string, res *maintenance.Result, err error) {
+				if reason != "" {
+					c.Infof("Maintenance triggered: %s\n", reason)
+				}
+				if res != nil {
+					c.Emit(res, func() { printResult(c, res) })
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+			})
+		},
+	}
+	daemonCmd.Flags().DurationVar(&poll, "poll", 5*time.Minute, "How often to check whether maintenance is due")
+
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show whether a daemon is running for this repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			pid := maintenance.DaemonPID(rp)
+			return c.Emit(map[string]any{"running": pid != 0, "pid": pid}, func() {
+				if pid != 0 {
+					c.Printf("Daemon running (pid %d)\n", pid)
+				} else {
+					c.Printf("Daemon not running\n")
+				}
+			})
+		},
+	}
+
+	var stopCmd = &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the daemon running for this repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			pid := maintenance.DaemonPID(rp)
+			if pid == 0 {
+				return fmt.Errorf("no daemon running")
+			}
+			if err := platform.Terminate(pid); err != nil {
+				return fmt.Errorf("failed to stop daemon: %w", err)
+			}
+			return c.Done(map[string]int{"stopped": pid}, "Stopped daemon (pid %d)\n", pid)
+		},
+	}
+
+	daemonCmd.AddCommand(statusCmd, stopCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"evo/internal/revparse"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var always, long bool
+	var match string
+	var describeCmd = &cobra.Command{
+		Use:   "describe [commit-ish]",
+		Short: "Name a commit relative to the nearest tag",
+		Long: `Names a commit (HEAD by default) after the nearest tag at or before it in its
+stream: the tag itself for a tagged commit, otherwise <tag>-<N>-g<abbrev> for
+the commit N commits after it, as used to stamp build versions.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			spec := "HEAD"
+			if len(args) > 0 {
+				spec = args[0]
+			}
+			r, err := revparse.New(c.Repo)
+			if err != nil {
+				return err
+			}
+			d, err := r.Describe(spec, match)
+			if err != nil {
+				return err
+			}
+			if d.Tag == "" && !always {
+				return fmt.Errorf("no tag can describe %s; use --always to fall back to the abbreviated ID", d.ID)
+			}
+			name := d.Format(long)
+			return c.Emit(struct {
+				Name string `json:"name"`
+				*revparse.Description
+			}{name, d}, func() { c.Printf("%s\n", name) })
+		},
+	}
+	describeCmd.Flags().BoolVar(&always, "always", false, "Show the abbreviated ID when no tag precedes the commit")
+	describeCmd.Flags().BoolVar(&long, "long", false, "Use the long form even for a tagged commit")
+	describeCmd.Flags().StringVar(&match, "match", "", "Only consider tags matching this glob pattern")
+	rootCmd.AddCommand(describeCmd)
+}
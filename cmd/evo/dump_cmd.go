@@ -0,0 +1,85 @@
+package main
+
+import (
+	"evo/internal/dump"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var output string
+	var dumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Write the repository's logical state as canonical JSON",
+		Long: `Writes HEAD, the node identity, the index and every stream with its origin,
+commits and op logs as one JSON document, to standard output or --output.
+The same state always gives the same bytes, so dumps can be diffed, kept as
+golden files or attached to bug reports; evo load builds a repository from
+one. Caches, staged changes and the content of large files are left out.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			d, err := dump.Create(c.Repo)
+			if err != nil {
+				return err
+			}
+			if output == "" || output == "-" {
+				return d.Write(os.Stdout)
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			if err := d.Write(f); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			return c.Done(map[string]any{"output": output, "streams": len(d.Streams)}, "Dumped %d streams to %s\n", len(d.Streams), output)
+		},
+	}
+	dumpCmd.Flags().StringVarP(&output, "output", "o", "", "File to write instead of standard output")
+	rootCmd.AddCommand(dumpCmd)
+
+	var loadCmd = &cobra.Command{
+		Use:   "load <dump-file> [directory]",
+		Short: "Build a repository from an evo dump",
+		Long: `Creates a repository in directory, or the current one, from a file written by
+evo dump ("-" reads standard input), and checks out its HEAD. The directory
+must not be a repository yet.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			d, err := dump.Read(r)
+			if err != nil {
+				return err
+			}
+			target := "."
+			if len(args) > 1 {
+				target = args[1]
+			}
+			if err := dump.Load(target, d); err != nil {
+				return fmt.Errorf("failed to load dump: %w", err)
+			}
+			return c.Done(map[string]any{"path": target, "streams": len(d.Streams)}, "Loaded %d streams into %s\n", len(d.Streams), target)
+		},
+	}
+	rootCmd.AddCommand(loadCmd)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"evo/internal/checkout"
+	"evo/internal/exchange"
+	"evo/internal/merge"
+	"evo/internal/remotes"
+	"evo/internal/streams"
+	"evo/internal/transfer"
+	"evo/internal/types"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// exchangeStreams returns the streams to exchange with their names on the
+// other side: those the arguments select, else those the remote's refspecs
+// select, else the current stream. names lists the streams patterns are
+// matched against. single reports one stream named outright, whose result
+// is printed alone.
+func exchangeStreams(rp string, args []string, specs []remotes.Refspec, names func() ([]string, error)) (ms []remotes.Mapping, single bool, err error) {
+	if len(args) > 0 {
+		specs = nil
+		for _, arg := range args {
+			rs, err := remotes.ParseRefspec(arg)
+			if err != nil {
+				return nil, false, err
+			}
+			specs = append(specs, rs)
+		}
+		single = len(specs) == 1 && !specs[0].Wildcard()
+	} else if len(specs) == 0 {
+		cur, err := streams.CurrentStream(rp)
+		if err != nil {
+			return nil, false, err
+		}
+		return []remotes.Mapping{{From: cur, To: cur}}, true, nil
+	}
+
+	var all []string
+	for _, rs := range specs {
+		if rs.Wildcard() {
+			if all, err = names(); err != nil {
+				return nil, false, err
+			}
+			break
+		}
+	}
+	for _, rs := range specs {
+		if !rs.Wildcard() {
+			continue
+		}
+		if ms := remotes.Select([]remotes.Refspec{rs}, all); len(ms) == 0 {
+			return nil, false, fmt.Errorf("no stream matches %s", rs.Src)
+		}
+	}
+	return remotes.Select(specs, all), single, nil
+}
+
+// exchangedTo names where a stream went or came from, with the remote's
+// name for it if another
+func exchangedTo(res *exchange.Result) string {
+	if res.RemoteStream != "" {
+		return fmt.Sprintf("%s as %s", res.Remote, res.RemoteStream)
+	}
+	return res.Remote
+}
+
+// syncReviews exchanges reviews with the remote along with the streams.
+// A remote that can't take them doesn't fail the push or pull.
+func syncReviews(c *cmdContext, r *remotes.Remote) {
+	pulled, pushed, err := exchange.SyncReviews(c.Repo, r)
+	if err != nil {
+		c.Warnf("failed to sync reviews with %s: %v\n", r.Name, err)
+		return
+	}
+	if pulled > 0 || pushed > 0 {
+		c.Infof("Pulled %d and pushed %d review change(s)\n", pulled, pushed)
+	}
+}
+
+func init() {
+	var remoteName, limitRate string
+	var jobs int
+
+	var pushCmd = &cobra.Command{
+		Use:   "push [<stream>[:<remote stream>]...]",
+		Short: "Send streams' commits to a remote (default: the current stream)",
+		Long: `Sends the commits of the streams to the remote, which applies those it lacks
+after checking them against its receive policy.
+
+A stream may be a pattern such as 'feature-*', selecting every local stream
+it matches. ':<remote stream>' pushes to a stream named otherwise on the
+remote, and 'feature-*:team-*' renames a whole namespace. Without streams,
+the remote's remote.<name>.push refspecs are pushed if it has them, else the
+current stream. A stream the remote rejects doesn't stop the others.
+Reviews are exchanged with the remote too.
+
+Streams are pushed --jobs at a time, at no more than --limit-rate bytes per
+second altogether.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := requireRemote(c.Repo, remoteName)
+			if err != nil {
+				return err
+			}
+			opts, err := transferOptions(cmd, c.Repo, jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			opts.Throttle(r)
+			ctx := c.progress(context.Background())
+			ms, single, err := exchangeStreams(c.Repo, args, r.Push, func() ([]string, error) {
+				return streams.ListStreams(c.Repo)
+			})
+			if err != nil {
+				return err
+			}
+			if single {
+				res, err := exchange.PushTo(ctx, c.Repo, r, ms[0].From, ms[0].To)
+				if err != nil {
+					return err
+				}
+				syncReviews(c, r)
+				return c.Done(res, "Pushed %d commit(s) of %s to %s\n", len(res.Commits), res.Stream, exchangedTo(res))
+			}
+			// streams go --jobs at once, and are reported in order
+			pushed := make([]*exchange.Result, len(ms))
+			failed := make([]error, len(ms))
+			transfer.Each(opts, indices(len(ms)), func(i int) error {
+				if pushed[i], failed[i] = exchange.PushTo(ctx, c.Repo, r, ms[i].From, ms[i].To); failed[i] != nil {
+					failed[i] = fmt.Errorf("failed to push %s: %w", ms[i].From, failed[i])
+				}
+				return nil
+			})
+			results := []*exchange.Result{}
+			var errs []error
+			for i := range ms {
+				if failed[i] != nil {
+					errs = append(errs, failed[i])
+					continue
+				}
+				results = append(results, pushed[i])
+			}
+			syncReviews(c, r)
+			if err := c.Emit(results, func() {
+				for _, res := range results {
+					c.Infof("Pushed %d commit(s) of %s to %s\n", len(res.Commits), res.Stream, exchangedTo(res))
+				}
+			}); err != nil {
+				return err
+			}
+			return errors.Join(errs...)
+		},
+	}
+
+	var apply bool
+	var pullCmd = &cobra.Command{
+		Use:   "pull [<remote stream>[:<stream>]...]",
+		Short: "Merge streams' commits from a remote (default: the current stream)",
+		Long: `Fetches the remote's history of the streams and merges the commits the local
+streams lack, as 'evo stream merge' would, creating them if needed. Lines the
+pulled commits edit that a stream edited too are listed with how they were
+resolved.
+
+A stream may be a pattern such as 'feature-*', selecting every stream of the
+remote it matches. ':<stream>' merges into a local stream named otherwise,
+and 'feature-*:upstream-*' renames a whole namespace. Without streams, the
+remote's remote.<name>.pull refspecs are pulled if it has them, else the
+current stream. A stream that fails doesn't stop the others. Reviews are
+exchanged with the remote too. Streams are fetched --jobs at a time, at no
+more than --limit-rate bytes per second altogether, and merged one by one.
+
+--apply then rewrites the files of the working tree the pulled commits
+change, so there is no merge step left. It needs the current stream to be
+among those pulled and the working tree to have no uncommitted changes.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := requireRemote(c.Repo, remoteName)
+			if err != nil {
+				return err
+			}
+			opts, err := transferOptions(cmd, c.Repo, jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			opts.Throttle(r)
+			ctx := c.progress(context.Background())
+			ms, single, err := exchangeStreams(c.Repo, args, r.Pull, func() ([]string, error) {
+				return exchange.RemoteStreams(r)
+			})
+			if err != nil {
+				return err
+			}
+			// the tree follows the current stream alone, so --apply checks it
+			// before anything is pulled
+			var cur string
+			if apply {
+				if single {
+					cur = ms[0].To
+				} else if cur, err = streams.CurrentStream(c.Repo); err != nil {
+					return err
+				}
+				found := false
+				for _, m := range ms {
+					found = found || m.To == cur
+				}
+				if !found {
+					return fmt.Errorf("--apply needs the current stream %s among those pulled", cur)
+				}
+				if err := checkout.CanRefresh(c.Repo, cur); errors.Is(err, checkout.ErrDirty) {
+					return fmt.Errorf("%w; commit them before pulling with --apply", err)
+				} else if err != nil {
+					return err
+				}
+			}
+
+			type pulled struct {
+				*exchange.Result
+				Tree *checkout.Result `json:"tree,omitempty"`
+			}
+			// streams are fetched --jobs at once, then merged in order
+			fetched := make([][]types.Commit, len(ms))
+			failed := make([]error, len(ms))
+			transfer.Each(opts, indices(len(ms)), func(i int) error {
+				fetched[i], failed[i] = exchange.Fetch(ctx, c.Repo, r, ms[i].From)
+				return nil
+			})
+			results := []pulled{}
+			var errs []error
+			unnamed := 0
+			for i, m := range ms {
+				err := failed[i]
+				var res *exchange.Result
+				if err == nil {
+					res, err = exchange.Apply(ctx, c.Repo, r.Name, m.To, fetched[i])
+				}
+				if err == nil && m.From != m.To {
+					res.RemoteStream = m.From
+				}
+				if err != nil {
+					if single {
+						return err
+					}
+					errs = append(errs, fmt.Errorf("failed to pull %s: %w", m.From, err))
+					continue
+				}
+				out := pulled{Result: res}
+				if apply && m.To == cur {
+					if out.Tree, err = checkout.Refresh(c.Repo, cur, res.Files); err != nil {
+						return err
+					}
+					unnamed += len(out.Tree.Unnamed)
+				}
+				results = append(results, out)
+			}
+			text := func() {
+				for _, out := range results {
+					c.Printf("Pulled %d commit(s) of %s from %s\n", len(out.Commits), out.Stream, exchangedTo(out.Result))
+					if t := out.Tree; t != nil {
+						c.Printf("Updated %d file(s), removed %d\n", len(t.Written), len(t.Removed))
+						for _, path := range t.Skipped {
+							c.Warnf("%s: large file content isn't stored; run 'evo transfer pull'\n", path)
+						}
+						for _, fid := range t.Unnamed {
+							c.Warnf("file %s: no path is known for it, or another file holds its path; not written\n", fid)
+						}
+					}
+					printConflicts(c, out.Conflicts)
+				}
+			}
+			syncReviews(c, r)
+			if unnamed > 0 {
+				errs = append(errs, fmt.Errorf("%d pulled file(s) were not written to the working tree", unnamed))
+			}
+			if single {
+				if err := c.Emit(results[0], text); err != nil {
+					return err
+				}
+				return errors.Join(errs...)
+			}
+			if err := c.Emit(results, text); err != nil {
+				return err
+			}
+			return errors.Join(errs...)
+		},
+	}
+	pullCmd.Flags().BoolVar(&apply, "apply", false, "Write the files the pulled commits change into the working tree")
+
+	pushCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to push to (default: origin or the only remote)")
+	pullCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to pull from (default: origin or the only remote)")
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd} {
+		cmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+		cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Streams to exchange at once (default: transfer.jobs)")
+		cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Bytes per second to limit the exchange to, e.g. 500k (default: transfer.limitRate)")
+		rootCmd.AddCommand(cmd)
+	}
+}
+
+// indices returns 0 to n-1, for running work on the items of a slice at once
+// while keeping each one's result in its place
+func indices(n int) []int {
+	is := make([]int, n)
+	for i := range is {
+		is[i] = i
+	}
+	return is
+}
+
+// printConflicts lists lines both sides edited with how they were resolved
+func printConflicts(c *cmdContext, conflicts []merge.Conflict) {
+	if len(conflicts) == 0 {
+		return
+	}
+	c.Printf("%s\n", c.Color(colorYellow, fmt.Sprintf("Resolved %d conflicting line edit(s):", len(conflicts))))
+	for _, cf := range conflicts {
+		where := cf.Path
+		if where == "" {
+			where = cf.FileID
+		}
+		ours, theirs := strconv.Quote(cf.Ours), strconv.Quote(cf.Theirs)
+		if cf.OursDeleted {
+			ours = "(deleted)"
+		}
+		if cf.TheirsDeleted {
+			theirs = "(deleted)"
+		}
+		c.Printf("  %s by %s: ours %s, theirs %s\n", where, cf.Driver, ours, theirs)
+	}
+}
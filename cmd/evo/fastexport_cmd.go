@@ -0,0 +1,31 @@
+package main
+
+import (
+	"evo/internal/interop"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var fastExportCmd = &cobra.Command{
+		Use:   "fast-export [stream...]",
+		Short: "Write history as a git fast-import stream",
+		Long: `Writes the named streams, or all of them, to standard output in the format
+git fast-import reads, so the history can be loaded into git:
+
+  evo fast-export | (cd ../repo.git && git fast-import)
+
+Each stream becomes a linear branch refs/heads/<stream>, and tags pointing at
+exported commits become git tags. Large files are exported as their LFS
+pointers.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			return interop.FastExport(c.Repo, os.Stdout, args)
+		},
+	}
+	rootCmd.AddCommand(fastExportCmd)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"evo/internal/interop"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var fastImportCmd = &cobra.Command{
+		Use:   "fast-import",
+		Short: "Read history from a git fast-import stream",
+		Long: `Reads a git fast-import stream from standard input into a repository with an
+empty working tree:
+
+  git fast-export --all | evo fast-import
+
+Each branch refs/heads/<name> becomes the stream <name>, with "/" replaced by
+"-", and each tag an evo tag. The streams must not have commits yet.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			res, err := interop.FastImport(c.Repo, os.Stdin)
+			if err != nil {
+				return err
+			}
+			return c.Done(res, "Imported %d commits into %s, %d tags\n",
+				res.Commits, strings.Join(res.Streams, ", "), len(res.Tags))
+		},
+	}
+	rootCmd.AddCommand(fastImportCmd)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"evo/internal/everrors"
+	"evo/internal/fsck"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var fsckCmd = &cobra.Command{
+		Use:   "fsck",
+		Short: "Check op logs and op stores for corruption",
+		Long: `Reads every op log and op store of the repository, checking sealed segments
+against their manifest and each record against its checksum. Damaged records
+are listed with their byte offset; repack leaves such files alone. The ops of
+each log are then applied to a document whose invariants are checked, so a
+log that builds a broken document is reported too.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := fsck.Check(c.Repo)
+			if err != nil {
+				return err
+			}
+			err = c.Emit(r, func() {
+				for _, p := range r.Problems {
+					c.Printf("%s\n", c.Color(colorRed, p.String()))
+				}
+				c.Infof("Checked %d op logs and %d op stores\n", r.Logs, r.Stores)
+			})
+			if err != nil {
+				return err
+			}
+			if len(r.Problems) > 0 {
+				return everrors.Errorf(everrors.ErrCorrupt, "found %d problems", len(r.Problems))
+			}
+			return nil
+		},
+	}
+	rootCmd.AddCommand(fsckCmd)
+}
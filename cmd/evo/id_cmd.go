@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"evo/internal/config"
+	"evo/internal/everrors"
+	"evo/internal/identity"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// prompt asks for a value on stdin, returning def for an empty answer
+func prompt(in *bufio.Reader, label, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func init() {
+	var idCmd = &cobra.Command{
+		Use:   "id",
+		Short: "Manage the author identity recorded in commits",
+		Long: `Commits record an author from user.name and user.email, or from a named
+profile (identity.<profile>.name/email) selected per repo with 'evo id use'.
+Without either, commit refuses to run unless user.requireIdentity is false.`,
+	}
+
+	var name, email, profile string
+	var local, use bool
+	var setupCmd = &cobra.Command{
+		Use:   "setup",
+		Short: "Configure an author identity, prompting for missing values",
+		Long: `Asks for a name and email and stores them as user.name/user.email in the
+global config (the repo config with --local), or as a profile with --profile.
+--name and --email skip the prompts.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, rp := configContext()
+			scope := config.ScopeGlobal
+			if local {
+				if rp == "" {
+					return fmt.Errorf("--local needs an evo repository")
+				}
+				scope = config.ScopeRepo
+			}
+			cur, _ := identity.Current(rp)
+			in := bufio.NewReader(os.Stdin)
+			var err error
+			if name == "" {
+				if name, err = prompt(in, "Name", cur.Name); err != nil {
+					return err
+				}
+			}
+			if email == "" {
+				if email, err = prompt(in, "Email", cur.Email); err != nil {
+					return err
+				}
+			}
+			id := identity.Identity{Name: name, Email: email, Profile: profile, Source: scope.String()}
+			if err := identity.Validate(name, email); err != nil {
+				return err
+			}
+			if profile == "" {
+				if err := config.Set(rp, scope, "user.name", name); err != nil {
+					return err
+				}
+				if err := config.Set(rp, scope, "user.email", email); err != nil {
+					return err
+				}
+				return c.Done(id, "Identity set to %s in the %s config\n", id, scope)
+			}
+			if err := identity.SaveProfile(rp, scope, profile, id); err != nil {
+				return err
+			}
+			if use {
+				if rp == "" {
+					return fmt.Errorf("--use needs an evo repository")
+				}
+				if err := identity.Use(rp, profile); err != nil {
+					return err
+				}
+				return c.Done(id, "Saved profile %s (%s) and selected it for this repository\n", profile, id)
+			}
+			return c.Done(id, "Saved profile %s (%s)\n", profile, id)
+		},
+	}
+	setupCmd.Flags().StringVar(&name, "name", "", "Author name")
+	setupCmd.Flags().StringVar(&email, "email", "", "Author email")
+	setupCmd.Flags().StringVar(&profile, "profile", "", "Save as a named profile instead of user.name/user.email")
+	setupCmd.Flags().BoolVar(&local, "local", false, "Store in the repo config instead of the global config")
+	setupCmd.Flags().BoolVar(&use, "use", false, "Select the new profile for this repository")
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List identity profiles",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, rp := configContext()
+			ps, err := identity.Profiles(rp)
+			if err != nil {
+				return err
+			}
+			cur, _ := identity.Current(rp)
+			return c.Emit(ps, func() {
+				for _, p := range ps {
+					mark := " "
+					if p.Profile == cur.Profile {
+						mark = "*"
+					}
+					c.Printf("%s %-12s %s\n", mark, p.Profile, p)
+				}
+			})
+		},
+	}
+
+	var useCmd = &cobra.Command{
+		Use:   "use <profile>",
+		Short: "Select an identity profile for this repository",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			_, rp := configContext()
+			ps, _ := identity.Profiles(rp)
+			var names []string
+			for _, p := range ps {
+				names = append(names, p.Profile)
+			}
+			return names, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo id use <profile>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			if err := identity.Use(c.Repo, args[0]); err != nil {
+				return err
+			}
+			return c.Done(map[string]string{"profile": args[0]}, "Using identity profile %s\n", args[0])
+		},
+	}
+
+	var whoamiCmd = &cobra.Command{
+		Use:   "whoami",
+		Short: "Show the author identity commits will record",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, rp := configContext()
+			id, err := identity.Current(rp)
+			if err != nil {
+				return err
+			}
+			return c.Emit(id, func() {
+				c.Printf("%s\n", id)
+				if id.Profile != "" {
+					c.Infof("profile %s, selected in the %s config\n", id.Profile, id.Source)
+				} else {
+					c.Infof("from the %s config\n", id.Source)
+				}
+			})
+		},
+	}
+
+	idCmd.AddCommand(setupCmd, listCmd, useCmd)
+	rootCmd.AddCommand(idCmd, whoamiCmd)
+}
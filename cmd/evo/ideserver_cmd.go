@@ -0,0 +1,35 @@
+package main
+
+import (
+	"evo/internal/ide"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var ideServerCmd = &cobra.Command{
+		Use:   "ide-server",
+		Short: "Serve editor plugins over JSON-RPC on stdin and stdout",
+		Long: `Runs until stdin closes or an exit notification arrives, answering JSON-RPC
+2.0 requests framed with Content-Length headers as in the Language Server
+Protocol. Editor plugins use it to show change gutters, blame and the current
+stream without starting evo for every query:
+
+  evo/status                     the working tree status
+  evo/stream                     the current stream, its newest commit and all streams
+  evo/diff  {path, text?}        changed line ranges against the stream
+  evo/blame {path, line?, text?} the commit that wrote each line
+
+text is the editor's unsaved content of the file, if it differs from disk.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			return ide.NewServer(c.Repo, os.Stdout).Serve(os.Stdin)
+		},
+	}
+	rootCmd.AddCommand(ideServerCmd)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"evo/internal/interop"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var authorsFile string
+	var streamMaps []string
+	var importCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Import history from Subversion or Mercurial",
+		Long: `Imports the revisions of another repository into streams. Running the same
+import again continues after the last revision imported, so a mirror can be
+kept up to date; the working tree must be empty or hold the current stream.
+
+--authors names a file mapping the source's authors, one "user = Name <email>"
+per line as git svn reads them; authors missing from it stop the import.
+--stream maps a branch to a stream (an empty stream skips the branch). By
+default the trunk or default branch becomes main and other branches streams
+of their name, with "/" replaced by "-".`,
+	}
+	importCmd.PersistentFlags().StringVar(&authorsFile, "authors", "", "File mapping source authors to \"Name <email>\"")
+	importCmd.PersistentFlags().StringArrayVar(&streamMaps, "stream", nil, "Map a branch to a stream as <branch>=<stream> (repeatable)")
+
+	runImport := func(src interop.Source) error {
+		c, err := newContext()
+		if err != nil {
+			return err
+		}
+		opts := interop.ImportOptions{Streams: make(map[string]string)}
+		for _, m := range streamMaps {
+			branch, stream, ok := strings.Cut(m, "=")
+			if !ok || branch == "" {
+				return fmt.Errorf("invalid stream mapping %q: expected <branch>=<stream>", m)
+			}
+			opts.Streams[branch] = stream
+		}
+		if authorsFile != "" {
+			if opts.Authors, err = interop.LoadAuthors(authorsFile); err != nil {
+				return err
+			}
+		}
+		res, err := interop.Import(c.Repo, src, opts)
+		if err != nil {
+			return err
+		}
+		if res.Commits == 0 && len(res.Tags) == 0 {
+			return c.Done(res, "Already up to date\n")
+		}
+		return c.Done(res, "Imported %d commits into %s, %d tags\n",
+			res.Commits, strings.Join(res.Streams, ", "), len(res.Tags))
+	}
+
+	var trunk, branches, tags string
+	var noLayout bool
+	var svnCmd = &cobra.Command{
+		Use:   "svn <url>",
+		Short: "Import a Subversion repository",
+		Long: `Imports a Subversion repository through the svn command. The trunk, branches
+and tags directories are found under the URL; with --no-layout the whole URL
+is imported as the trunk. Tags point at the last commit of the branch they
+were copied from.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src := interop.NewSVN(args[0])
+			src.TrunkDir, src.BranchesDir, src.TagsDir = trunk, branches, tags
+			if noLayout {
+				src.TrunkDir, src.BranchesDir, src.TagsDir = "", "", ""
+			}
+			return runImport(src)
+		},
+	}
+	svnCmd.Flags().StringVar(&trunk, "trunk", "trunk", "Directory of the trunk")
+	svnCmd.Flags().StringVar(&branches, "branches", "branches", "Directory holding branches")
+	svnCmd.Flags().StringVar(&tags, "tags", "tags", "Directory holding tags")
+	svnCmd.Flags().BoolVar(&noLayout, "no-layout", false, "Import the whole URL as the trunk")
+
+	var hgCmd = &cobra.Command{
+		Use:   "hg <path>",
+		Short: "Import a Mercurial repository",
+		Long: `Imports a local Mercurial repository through the hg command. Named branches
+become streams, and tags point at the commits of their changesets.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			return runImport(&interop.Hg{Path: path})
+		},
+	}
+
+	importCmd.AddCommand(svnCmd, hgCmd)
+	rootCmd.AddCommand(importCmd)
+}
@@ -1,29 +1,89 @@
 package main
 
 import (
+	"evo/internal/config"
 	"evo/internal/repo"
+	"evo/internal/template"
 	"fmt"
 
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	var tmplSrc, defaultStream string
+	var bare bool
+
 	var initCmd = &cobra.Command{
 		Use:   "init [path]",
 		Short: "Initialize a new Evo repository",
-		Long: `Creates a .evo directory with default stream "main", config folder, index for stable file IDs,
-and other structures needed for CRDT-based version control.`,
+		Long: `Creates a .evo directory with a default stream ("main" unless --default-stream
+or init.defaultStream says otherwise), config folder, index for stable file IDs,
+and other structures needed for CRDT-based version control.
+
+--template (or init.template) seeds the repository from a directory, or a
+.tar.gz of one given as a path or http(s) URL, holding any of .evo-ignore,
+.evo-attributes, config.toml, hooks/ and a streams file listing streams to
+create, the first of which is checked out.
+
+--bare creates a repository without a working tree, for servers: the
+directory holds what .evo otherwise would. Commands that read the working
+tree, such as status and commit, refuse to run in it.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			c := baseContext()
 			path := "."
+			if globalFlags.repo != "" {
+				path = globalFlags.repo
+			}
 			if len(args) > 0 {
 				path = args[0]
 			}
-			if err := repo.InitRepo(path); err != nil {
+			cfg, err := config.Load("")
+			if err != nil {
 				return err
 			}
-			fmt.Println("Initialized Evo repository at", path)
-			return nil
+			if !cmd.Flags().Changed("template") {
+				tmplSrc = cfg.String("init.template")
+			}
+			opts := repo.InitOptions{Bare: bare}
+			var tmpl *template.Template
+			if tmplSrc != "" {
+				if tmpl, err = template.Open(tmplSrc); err != nil {
+					return err
+				}
+				defer tmpl.Close()
+				if opts.Streams, err = tmpl.Streams(); err != nil {
+					return err
+				}
+			}
+			switch {
+			case cmd.Flags().Changed("default-stream"):
+				opts.DefaultStream = defaultStream
+			case len(opts.Streams) > 0:
+				opts.DefaultStream = opts.Streams[0]
+			default:
+				opts.DefaultStream = cfg.String("init.defaultStream")
+			}
+			if err := repo.Init(path, opts); err != nil {
+				return err
+			}
+			res := map[string]any{"path": path, "stream": opts.DefaultStream, "bare": bare}
+			if tmpl != nil {
+				files, err := tmpl.Apply(path)
+				if err != nil {
+					return fmt.Errorf("initialized %s but failed to apply the template: %w", path, err)
+				}
+				res["template"] = tmplSrc
+				res["files"] = files
+			}
+			kind := "Evo repository"
+			if bare {
+				kind = "bare Evo repository"
+			}
+			return c.Done(res, "Initialized %s at %s on stream %s\n", kind, path, opts.DefaultStream)
 		},
 	}
+	initCmd.Flags().StringVar(&tmplSrc, "template", "", "Directory, .tar.gz or URL of a template to seed the repository from")
+	initCmd.Flags().StringVar(&defaultStream, "default-stream", "", "Stream to start on (default: init.defaultStream, or main)")
+	initCmd.Flags().BoolVar(&bare, "bare", false, "Create a repository without a working tree")
 	rootCmd.AddCommand(initCmd)
 }
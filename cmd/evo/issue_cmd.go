@@ -0,0 +1,252 @@
+package main
+
+import (
+	"evo/internal/everrors"
+	"evo/internal/identity"
+	"evo/internal/issues"
+	"evo/internal/repo"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// issueAuthor returns the configured identity as an issue author
+func issueAuthor(rp string) (issues.Author, error) {
+	id, err := identity.ForCommit(rp, "")
+	if err != nil {
+		return issues.Author{}, err
+	}
+	return issues.Author{Name: id.Name, Email: id.Email}, nil
+}
+
+// completeIssues completes open issue IDs
+func completeIssues(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	c, err := newContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	all, _ := issues.List(c.Repo)
+	var out []string
+	for _, is := range all {
+		if is.State == issues.StateOpen && strings.HasPrefix(is.ID, toComplete) {
+			out = append(out, is.ID[:8]+"\t"+is.Title)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	var issueCmd = &cobra.Command{
+		Use:   "issue",
+		Short: "Track issues inside the repository",
+		Long: `A lightweight issue tracker stored in .evo/issues. Issues are logs of
+changes that merge across copies of the repository like code does.
+Commits with a "Closes: <issue>" trailer (or --closes) close the issue.`,
+	}
+
+	var body string
+	var createCmd = &cobra.Command{
+		Use:   "create <title>",
+		Short: "Open a new issue",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo issue create <title> [-m <description>]")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			who, err := issueAuthor(c.Repo)
+			if err != nil {
+				return err
+			}
+			is, err := issues.Create(c.Repo, strings.Join(args, " "), body, who)
+			if err != nil {
+				return err
+			}
+			return c.Done(is, "Created issue %s: %s\n", is.ID[:8], is.Title)
+		},
+	}
+	createCmd.Flags().StringVarP(&body, "message", "m", "", "Issue description")
+
+	var all, closedOnly bool
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List open issues",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			list, err := issues.List(c.Repo)
+			if err != nil {
+				return err
+			}
+			out := []*issues.Issue{}
+			for _, is := range list {
+				switch {
+				case all:
+				case closedOnly && is.State != issues.StateClosed:
+					continue
+				case !closedOnly && is.State != issues.StateOpen:
+					continue
+				}
+				out = append(out, is)
+			}
+			return c.Emit(out, func() {
+				for _, is := range out {
+					state := c.Color(colorGreen, is.State)
+					if is.State == issues.StateClosed {
+						state = c.Color(colorRed, is.State)
+					}
+					c.Printf("%s %-6s %s\n", c.Color(colorYellow, is.ID[:8]), state, is.Title)
+				}
+			})
+		},
+	}
+	listCmd.Flags().BoolVarP(&all, "all", "a", false, "List open and closed issues")
+	listCmd.Flags().BoolVar(&closedOnly, "closed", false, "List closed issues")
+
+	var showCmd = &cobra.Command{
+		Use:               "show <issue>",
+		Short:             "Show an issue with its comments and linked commits",
+		ValidArgsFunction: completeIssues,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo issue show <issue>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			id, err := issues.Resolve(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			is, err := issues.Get(c.Repo, id)
+			if err != nil {
+				return err
+			}
+			return c.Emit(is, func() {
+				c.Printf("%s %s\n", c.Color(colorYellow, "issue "+is.ID), is.State)
+				c.Printf("Author: %s <%s>\nDate:   %s\n\n    %s\n", is.AuthorName, is.AuthorEmail, is.Created.Local().Format(time.RFC1123), is.Title)
+				if is.Body != "" {
+					c.Printf("\n")
+					for _, line := range strings.Split(is.Body, "\n") {
+						c.Printf("    %s\n", line)
+					}
+				}
+				for _, cm := range is.Comments {
+					c.Printf("\n%s <%s> on %s:\n", cm.AuthorName, cm.AuthorEmail, cm.Timestamp.Local().Format(time.RFC1123))
+					for _, line := range strings.Split(cm.Text, "\n") {
+						c.Printf("    %s\n", line)
+					}
+				}
+				if len(is.Commits) > 0 {
+					c.Printf("\nCommits:\n")
+					for _, id := range is.Commits {
+						c.Printf("    %s\n", id)
+					}
+				}
+			})
+		},
+	}
+
+	var comment string
+	setState := func(state, verb string) *cobra.Command {
+		return &cobra.Command{
+			Use:               verb + " <issue>",
+			Short:             strings.ToUpper(verb[:1]) + verb[1:] + " an issue, optionally with a comment",
+			ValidArgsFunction: completeIssues,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if len(args) < 1 {
+					return everrors.Errorf(everrors.ErrUsage, "usage: evo issue %s <issue>", verb)
+				}
+				c, err := newContext()
+				if err != nil {
+					return err
+				}
+				id, err := issues.Resolve(c.Repo, args[0])
+				if err != nil {
+					return err
+				}
+				who, err := issueAuthor(c.Repo)
+				if err != nil {
+					return err
+				}
+				if comment != "" {
+					if err := issues.AddComment(c.Repo, id, comment, who); err != nil {
+						return err
+					}
+				}
+				if err := issues.SetState(c.Repo, id, state, "", who); err != nil {
+					return err
+				}
+				return c.Done(map[string]string{"id": id, "state": state}, "Issue %s is %s\n", id[:8], state)
+			},
+		}
+	}
+	closeCmd := setState(issues.StateClosed, "close")
+	reopenCmd := setState(issues.StateOpen, "reopen")
+	closeCmd.Flags().StringVarP(&comment, "message", "m", "", "Comment to add")
+	reopenCmd.Flags().StringVarP(&comment, "message", "m", "", "Comment to add")
+
+	var commentCmd = &cobra.Command{
+		Use:               "comment <issue> -m <text>",
+		Short:             "Comment on an issue",
+		ValidArgsFunction: completeIssues,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || comment == "" {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo issue comment <issue> -m <text>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			id, err := issues.Resolve(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			who, err := issueAuthor(c.Repo)
+			if err != nil {
+				return err
+			}
+			if err := issues.AddComment(c.Repo, id, comment, who); err != nil {
+				return err
+			}
+			return c.Done(map[string]string{"id": id}, "Commented on issue %s\n", id[:8])
+		},
+	}
+	commentCmd.Flags().StringVarP(&comment, "message", "m", "", "Comment text")
+
+	var syncCmd = &cobra.Command{
+		Use:   "sync <repo-path>",
+		Short: "Exchange issues with another local repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo issue sync <repo-path>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			other, err := repo.FindRepoRoot(args[0])
+			if err != nil {
+				return fmt.Errorf("not an evo repository: %s", args[0])
+			}
+			pulled, pushed, err := issues.Sync(c.Repo, other)
+			if err != nil {
+				return err
+			}
+			return c.Done(map[string]int{"pulled": pulled, "pushed": pushed}, "Pulled %d and pushed %d issue change(s)\n", pulled, pushed)
+		},
+	}
+
+	issueCmd.AddCommand(createCmd, listCmd, showCmd, closeCmd, reopenCmd, commentCmd, syncCmd)
+	rootCmd.AddCommand(issueCmd)
+}
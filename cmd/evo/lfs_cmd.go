@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"evo/internal/everrors"
+	"evo/internal/lfs"
+	"evo/internal/transfer"
+	"evo/internal/util"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var lfsCmd = &cobra.Command{
+		Use:   "lfs",
+		Short: "Report on and prune the large file store",
+		Long: `Large files are kept as chunks in the LFS store. Content no file points to
+any more, as a large file's previous version, is deleted right away unless
+the policy keeps it:
+- lfs.maxUnreferencedAge: how long it is kept
+- lfs.retention.<ext>: the same for content last held by a .<ext> file
+- lfs.quota: bytes of chunks the store may hold; pruning evicts the oldest
+  unreferenced content first to stay under it
+Content a file points to is never pruned, whatever the quota.`,
+	}
+
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show what the LFS store holds against its quota",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			u, err := lfs.NewStore(c.Repo).Usage()
+			if err != nil {
+				return err
+			}
+			return c.Emit(u, func() { printUsage(c, u) })
+		},
+	}
+
+	var dryRun bool
+	var pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Delete what the policy no longer keeps and chunks no content uses",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			gc := lfs.NewGarbageCollector(lfs.NewStore(c.Repo))
+			run := gc.Run
+			if dryRun {
+				run = gc.Plan
+			}
+			res, err := run(c.progress(context.Background()))
+			if err != nil {
+				return err
+			}
+			verb := "Pruned"
+			if dryRun {
+				verb = "Would prune"
+			}
+			if err := c.Done(res, "%s %d chunk(s) (%s): %d object(s) expired, %d evicted for the quota\n",
+				verb, res.Removed, util.HumanBytes(res.Freed), res.Expired, res.Evicted); err != nil {
+				return err
+			}
+			if res.Overflow {
+				c.Warnf("the store holds %s of content files point to, past its quota of %s\n",
+					util.HumanBytes(res.Stored), util.HumanBytes(res.Quota))
+			}
+			return nil
+		},
+	}
+	pruneCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only report what would be deleted")
+
+	var repair bool
+	var remoteName string
+	var fsckCmd = &cobra.Command{
+		Use:   "fsck",
+		Short: "Check LFS chunks and objects for damage, and repair it from a remote",
+		Long: `Re-hashes every chunk of the LFS store and checks that the chunks of each
+stored content add up to its size and hash, and that every large file points
+to stored content. Missing and corrupt chunks are listed with the files they
+break.
+
+--repair deletes corrupt chunks and fetches them, and missing ones, from
+--remote (default: origin or the only remote), then checks again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			store := lfs.NewStore(c.Repo)
+			r, err := store.Check()
+			if err != nil {
+				return err
+			}
+			res := struct {
+				*lfs.CheckReport
+				Fetched []string `json:"fetched,omitempty"`
+			}{CheckReport: r}
+			if lost := r.Lost(); repair && len(lost) > 0 {
+				remote, err := lockRemote(c.Repo, remoteName, false)
+				if err != nil {
+					return err
+				}
+				if remote == nil {
+					return fmt.Errorf("%d chunk(s) are lost and no remote is configured to fetch them from; set remote.origin.url", len(lost))
+				}
+				opts, err := transferOptions(cmd, c.Repo, 0, "")
+				if err != nil {
+					return err
+				}
+				for _, d := range r.Damage {
+					if d.Kind == lfs.DamageCorrupt && d.Size > 0 {
+						if err := store.RemoveChunk(d.Hash); err != nil {
+							return fmt.Errorf("failed to remove corrupt chunk %s: %w", d.Hash, err)
+						}
+					}
+				}
+				res.Fetched, err = transfer.FetchChunks(c.Repo, remote, lost, opts)
+				if err != nil {
+					c.Warnf("%v\n", err)
+				}
+				c.Infof("Fetched %d of %d lost chunk(s) from %s\n", len(res.Fetched), len(lost), remote.Name)
+				if res.CheckReport, err = store.Check(); err != nil {
+					return err
+				}
+			}
+			err = c.Emit(res, func() {
+				for _, d := range res.Damage {
+					c.Printf("%s\n", c.Color(colorRed, d.String()))
+				}
+				c.Infof("Checked %d objects and %d chunks (%s)\n", res.Objects, res.Chunks, util.HumanBytes(res.Bytes))
+			})
+			if err != nil {
+				return err
+			}
+			if n := len(res.Damage); n > 0 {
+				if !repair && len(res.Lost()) > 0 {
+					return everrors.Errorf(everrors.ErrCorrupt, "found %d problems (run 'evo lfs fsck --repair' to fetch lost chunks)", n)
+				}
+				return everrors.Errorf(everrors.ErrCorrupt, "found %d problems", n)
+			}
+			return nil
+		},
+	}
+	fsckCmd.Flags().BoolVar(&repair, "repair", false, "Fetch missing and corrupt chunks from a remote")
+	fsckCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to fetch from (default: origin or the only remote)")
+	fsckCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+
+	var top int
+	var dedupCmd = &cobra.Command{
+		Use:   "dedup-report",
+		Short: "Show how much chunking dedupes the content files point to",
+		Long: `Compares the size of the content large files point to with that of the
+distinct chunks holding it, overall and per extension, and lists the groups
+of files sharing chunks that save the most: files with the same content, or
+with parts of it in common.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := lfs.NewStore(c.Repo).Dedup(top)
+			if err != nil {
+				return err
+			}
+			return c.Emit(r, func() { printDedup(c, r) })
+		},
+	}
+	dedupCmd.Flags().IntVar(&top, "top", 10, "Groups of files sharing chunks to list (0 for all)")
+
+	lfsCmd.AddCommand(statusCmd, pruneCmd, fsckCmd, dedupCmd)
+	rootCmd.AddCommand(lfsCmd)
+}
+
+func printUsage(c *cmdContext, u *lfs.Usage) {
+	c.Printf("Files:        %d (%d distinct contents, %d unreferenced)\n", u.Files, u.Objects, u.Unreferenced)
+	c.Printf("Chunks:       %d (%s)\n", u.Chunks, util.HumanBytes(u.Stored))
+	c.Printf("  referenced: %s\n", util.HumanBytes(u.Referenced))
+	c.Printf("  retained:   %s\n", util.HumanBytes(u.Retained))
+	c.Printf("  orphaned:   %s\n", util.HumanBytes(u.Orphaned))
+	p := u.Policy
+	if p.Quota > 0 {
+		c.Printf("Quota:        %s of %s (%.0f%%)\n", util.HumanBytes(u.Stored), util.HumanBytes(p.Quota),
+			float64(u.Stored)*100/float64(p.Quota))
+	} else {
+		c.Printf("Quota:        none\n")
+	}
+	c.Printf("Retention:    %s\n", p.MaxAge)
+	exts := make([]string, 0, len(p.Retention))
+	for ext := range p.Retention {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		c.Printf("  .%-10s %s\n", ext, p.Retention[ext])
+	}
+}
+
+func printDedup(c *cmdContext, r *lfs.DedupReport) {
+	c.Printf("Files:    %d (%s of content)\n", r.Files, util.HumanBytes(r.Logical))
+	c.Printf("Chunks:   %d of up to %s (%s, %s stored)\n", r.Chunks, util.HumanBytes(r.ChunkSize),
+		util.HumanBytes(r.Physical), util.HumanBytes(r.Stored))
+	c.Printf("Dedup:    %.2fx, saving %s\n", r.Ratio, util.HumanBytes(r.Logical-r.Physical))
+	if len(r.Extensions) > 0 {
+		c.Printf("\n%-12s %6s %12s %12s %7s\n", "extension", "files", "content", "chunks", "ratio")
+		for _, e := range r.Extensions {
+			ext := "." + e.Ext
+			if e.Ext == "" {
+				ext = "(none)"
+			}
+			c.Printf("%-12s %6d %12s %12s %6.2fx\n", ext, e.Files, util.HumanBytes(e.Logical), util.HumanBytes(e.Physical), e.Ratio)
+		}
+	}
+	if len(r.Groups) > 0 {
+		c.Printf("\nFiles sharing chunks:\n")
+		for _, g := range r.Groups {
+			what := fmt.Sprintf("%d chunk(s), %s", g.Chunks, util.HumanBytes(g.Shared))
+			if g.Identical {
+				what = "identical"
+			}
+			c.Printf("  %10s saved  %-24s %s\n", util.HumanBytes(g.Saved), what, strings.Join(g.Files, ", "))
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"evo/internal/identity"
+	"evo/internal/locks"
+	"evo/internal/remotes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// repoRelative returns a path given on the command line relative to the
+// repository root, with forward slashes
+func repoRelative(rp, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(rp)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the repository", path)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// insecureRemote is set by the --insecure flag of commands talking to remotes
+var insecureRemote bool
+
+// getRemote returns the remote called name, trusting any certificate with
+// --insecure
+func getRemote(rp, name string) (*remotes.Remote, error) {
+	r, err := remotes.Get(rp, name)
+	if r != nil && insecureRemote {
+		r.Insecure = true
+	}
+	return r, err
+}
+
+// lockRemote returns the remote named by --remote, the default remote, or
+// nil with --local or when no remote is configured
+func lockRemote(rp, name string, local bool) (*remotes.Remote, error) {
+	if local {
+		return nil, nil
+	}
+	if name != "" {
+		return getRemote(rp, name)
+	}
+	r, err := remotes.Default(rp)
+	if r != nil && insecureRemote {
+		r.Insecure = true
+	}
+	return r, err
+}
+
+// requireRemote returns the remote named by --remote or the default remote,
+// failing when none is configured
+func requireRemote(rp, name string) (*remotes.Remote, error) {
+	r, err := lockRemote(rp, name, false)
+	if err == nil && r == nil {
+		err = fmt.Errorf("no remote configured; set remote.origin.url")
+	}
+	return r, err
+}
+
+// warnLocked warns about changed paths that someone else has locked
+func warnLocked(c *cmdContext, paths []string) {
+	id, err := identity.Current(c.Repo)
+	if err != nil {
+		return
+	}
+	held, err := locks.HeldByOthers(c.Repo, paths, id.Email)
+	if err != nil {
+		c.Warnf("failed to check locks: %v\n", err)
+		return
+	}
+	for _, l := range held {
+		c.Warnf("%v\n", &locks.HeldError{Lock: l})
+	}
+}
+
+func init() {
+	var remoteName string
+	var local, force bool
+
+	var lockCmd = &cobra.Command{
+		Use:   "lock <path>...",
+		Short: "Lock files that can't be merged, such as binary assets",
+		Long: `Records an advisory lock on each file in your name, on the default remote
+(origin, or the only remote configured) unless --local is given. Locks don't
+stop anyone: status and commit warn about changes to files someone else has
+locked. Locking a file someone else holds fails.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			id, err := identity.Current(c.Repo)
+			if err != nil {
+				return err
+			}
+			r, err := lockRemote(c.Repo, remoteName, local)
+			if err != nil {
+				return err
+			}
+			var taken []locks.Lock
+			for _, arg := range args {
+				rel, err := repoRelative(c.Repo, arg)
+				if err != nil {
+					return err
+				}
+				l := locks.Lock{Path: rel, Name: id.Name, Email: id.Email, Created: time.Now().UTC()}
+				if r != nil {
+					if l, err = locks.AcquireRemote(r, l); err != nil {
+						return err
+					}
+				}
+				if l, err = locks.Acquire(c.Repo, l); err != nil {
+					return err
+				}
+				taken = append(taken, l)
+				if r != nil {
+					c.Infof("Locked %s on %s\n", rel, r.Name)
+				} else {
+					c.Infof("Locked %s\n", rel)
+				}
+			}
+			return c.Emit(taken, func() {})
+		},
+	}
+	lockCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to lock on (default: origin or the only remote)")
+	lockCmd.Flags().BoolVar(&local, "local", false, "Only record the lock in this repository")
+	lockCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+	rootCmd.AddCommand(lockCmd)
+
+	var unlockCmd = &cobra.Command{
+		Use:   "unlock <path>...",
+		Short: "Release file locks",
+		Long: `Releases your locks on the files, on the default remote unless --local is
+given. --force releases locks held by someone else.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			id, err := identity.Current(c.Repo)
+			if err != nil {
+				return err
+			}
+			r, err := lockRemote(c.Repo, remoteName, local)
+			if err != nil {
+				return err
+			}
+			var released []locks.Lock
+			for _, arg := range args {
+				rel, err := repoRelative(c.Repo, arg)
+				if err != nil {
+					return err
+				}
+				var l locks.Lock
+				if r != nil {
+					if l, err = locks.ReleaseRemote(r, rel, id.Email, force); err != nil {
+						return err
+					}
+				}
+				// the local record may be gone already
+				if held, err := locks.Release(c.Repo, rel, id.Email, force || r != nil); err == nil {
+					l = held
+				} else if r == nil || !errors.Is(err, locks.ErrNotLocked) {
+					return err
+				}
+				released = append(released, l)
+				c.Infof("Unlocked %s\n", rel)
+			}
+			return c.Emit(released, func() {})
+		},
+	}
+	unlockCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to unlock on (default: origin or the only remote)")
+	unlockCmd.Flags().BoolVar(&local, "local", false, "Only remove the lock from this repository")
+	unlockCmd.Flags().BoolVar(&force, "force", false, "Release locks held by someone else")
+	unlockCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+	rootCmd.AddCommand(unlockCmd)
+
+	var locksCmd = &cobra.Command{
+		Use:   "locks",
+		Short: "List file locks",
+		Long: `Lists the locks held on the default remote, which are remembered for status
+and commit warnings, and those recorded only in this repository. With
+--local, or when the remote can't be reached, the remembered locks are shown.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := lockRemote(c.Repo, remoteName, local)
+			if err != nil {
+				return err
+			}
+			if r != nil {
+				ls, err := locks.ListRemote(r)
+				if err != nil {
+					c.Warnf("showing remembered locks: %v\n", err)
+				} else if err := locks.Replace(c.Repo, r.Name, ls); err != nil {
+					return err
+				}
+			}
+			ls, err := locks.List(c.Repo)
+			if err != nil {
+				return err
+			}
+			if ls == nil {
+				ls = []locks.Lock{}
+			}
+			return c.Emit(ls, func() {
+				if len(ls) == 0 {
+					c.Infof("No locks\n")
+					return
+				}
+				for _, l := range ls {
+					where := "local"
+					if l.Remote != "" {
+						where = l.Remote
+					}
+					c.Printf("%-40s %-30s %s  %s\n", l.Path, l.Owner(), l.Created.Local().Format("2006-01-02 15:04"), where)
+				}
+			})
+		},
+	}
+	locksCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to list (default: origin or the only remote)")
+	locksCmd.Flags().BoolVar(&local, "local", false, "Don't contact the remote")
+	locksCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+	rootCmd.AddCommand(locksCmd)
+}
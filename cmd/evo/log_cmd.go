@@ -3,55 +3,280 @@ package main
 import (
 	"evo/internal/commits"
 	"evo/internal/config"
+	"evo/internal/everrors"
+	"evo/internal/graph"
 	"evo/internal/repo"
+	"evo/internal/revparse"
 	"evo/internal/signing"
 	"evo/internal/streams"
+	"evo/internal/types"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	var oneline, count, graphView bool
+	var maxCount int
+	var since, until, author, grep, stream string
 	var logCmd = &cobra.Command{
 		Use:   "log",
 		Short: "Show commit history for the current stream",
+		Long: `Show commit history for the current stream, or another with --stream.
+
+Filters combine: a commit is shown only if it matches all of them. Dates,
+authors and limits are decided from the commit index, so only the commits
+shown (or, with --grep, searched) are read.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			stream, err := streams.CurrentStream(rp)
+			rp := c.Repo
+			if stream == "" {
+				if stream, err = streams.CurrentStream(rp); err != nil {
+					return err
+				}
+			} else if _, err := os.Stat(filepath.Join(repo.Dir(rp), "streams", stream)); os.IsNotExist(err) {
+				return everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", stream)
+			}
+			cfg, err := config.Load(rp)
+			if err != nil {
+				return err
+			}
+			doVerify, err := cfg.Bool("verifySignatures")
 			if err != nil {
 				return err
 			}
-			verifyStr, _ := config.GetConfigValue(rp, "verifySignatures")
-			doVerify := (verifyStr == "true")
 
-			cc, err := commits.ListCommits(rp, stream)
+			opts := commits.IterOptions{Author: author, Verify: true}
+			now := time.Now()
+			if since != "" {
+				if opts.Since, err = commits.ParseTime(since, now); err != nil {
+					return err
+				}
+			}
+			if until != "" {
+				if opts.Until, err = commits.ParseTime(until, now); err != nil {
+					return err
+				}
+			}
+			if grep != "" {
+				if opts.Grep, err = regexp.Compile(grep); err != nil {
+					return fmt.Errorf("invalid --grep pattern: %w", err)
+				}
+			}
+			if graphView {
+				if c.JSON || count || cmd.Flags().Changed("stream") {
+					return fmt.Errorf("--graph shows every stream and cannot be combined with --json, --count or --stream")
+				}
+				return printGraph(c, rp, stream, opts, maxCount)
+			}
+			if count {
+				n, err := commits.CountCommits(rp, stream, opts)
+				if err != nil {
+					return err
+				}
+				if maxCount > 0 && n > maxCount {
+					n = maxCount
+				}
+				return c.Emit(map[string]int{"count": n}, func() { c.Printf("%d\n", n) })
+			}
+			// the full log and JSON run oldest first, --oneline newest first
+			newestFirst := oneline && !c.JSON
+			var rev *revparse.Resolver
+			if newestFirst {
+				if rev, err = revparse.NewInStream(rp, stream); err != nil {
+					return err
+				}
+			}
+			show := func(cm *types.Commit) {
+				if newestFirst {
+					msg, _, _ := strings.Cut(cm.Message, "\n")
+					c.Printf("%s %s\n", c.Color(colorYellow, rev.Abbrev(cm.ID)), msg)
+					return
+				}
+				printCommit(c, rp, cm, doVerify)
+				c.Printf("\n")
+			}
+
+			// without a limit, commits are printed as they are read; with one,
+			// the newest maxCount are read and printed in order
+			var cc []*types.Commit
+			shown := 0
+			opts.Reverse = newestFirst || maxCount > 0
+			err = commits.ForEachCommit(rp, stream, opts, func(cm *types.Commit) error {
+				shown++
+				if c.JSON || (maxCount > 0 && !newestFirst) {
+					cc = append(cc, cm)
+				} else {
+					show(cm)
+				}
+				if shown == maxCount {
+					return commits.SkipAll
+				}
+				return nil
+			})
 			if err != nil {
 				return err
 			}
-			if len(cc) == 0 {
-				fmt.Println("No commits found in this stream.")
+			if opts.Reverse && !newestFirst {
+				slices.Reverse(cc)
+			}
+			if c.JSON {
+				out := []logEntry{}
+				for _, cm := range cc {
+					out = append(out, newLogEntry(rp, cm, doVerify))
+				}
+				return c.Emit(out, nil)
+			}
+			if shown == 0 {
+				if opts.Since.IsZero() && opts.Until.IsZero() && author == "" && grep == "" {
+					c.Infof("No commits found in this stream.\n")
+				} else {
+					c.Infof("No commits match.\n")
+				}
 				return nil
 			}
-			for _, c := range cc {
-				ver := ""
-				if c.Signature != "" && doVerify {
-					valid, err := signing.VerifyCommit(&c, rp)
-					if err != nil {
-						ver = " (error: " + err.Error() + ")"
-					} else if valid {
-						ver = " (verified)"
-					} else {
-						ver = " (INVALID!)"
-					}
-				}
-				fmt.Printf("commit %s%s\nAuthor: %s <%s>\nDate:   %s\n\n    %s\n\n",
-					c.ID, ver, c.AuthorName, c.AuthorEmail, c.Timestamp.Local(), c.Message)
+			for _, cm := range cc {
+				show(cm)
 			}
 			return nil
 		},
 	}
+	logCmd.Flags().IntVarP(&maxCount, "max-count", "n", 0, "Show at most this many commits, the newest")
+	logCmd.Flags().StringVar(&since, "since", "", "Show commits made after a date (2006-01-02), a date and time, or an age such as 2w or 36h")
+	logCmd.Flags().StringVar(&until, "until", "", "Show commits made before a date, a date and time, or an age")
+	logCmd.Flags().StringVar(&author, "author", "", "Show commits whose author (\"Name <email>\") contains this text, ignoring case")
+	logCmd.Flags().StringVar(&grep, "grep", "", "Show commits whose message matches this regular expression")
+	logCmd.Flags().StringVar(&stream, "stream", "", "Show the history of this stream instead of the current one")
+	logCmd.Flags().BoolVar(&count, "count", false, "Print the number of matching commits instead of the commits")
+	logCmd.Flags().BoolVar(&graphView, "graph", false, "Draw the commits of every stream as lanes, showing merged commits in each stream holding them")
+	logCmd.Flags().BoolVar(&oneline, "oneline", false, "Show each commit as an abbreviated ID and the first line of its message, newest first")
 	rootCmd.AddCommand(logCmd)
 }
+
+// laneColors are the ANSI colors of graph lanes, repeated past the sixth
+var laneColors = []string{"31", "32", "33", "34", "35", "36"}
+
+// printGraph prints the commits of all streams, newest first, with the
+// current stream in the first lane
+func printGraph(c *cmdContext, rp, current string, opts commits.IterOptions, maxCount int) error {
+	names, err := streams.ListStreams(rp)
+	if err != nil {
+		return err
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == current) != (names[j] == current) {
+			return names[i] == current
+		}
+		return names[i] < names[j]
+	})
+	idx, err := commits.LoadIndex(rp)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string][]commits.IndexEntry)
+	for _, s := range names {
+		es, err := idx.Stream(s)
+		if err != nil {
+			return err
+		}
+		for i := range es {
+			if opts.Selects(&es[i]) {
+				entries[s] = append(entries[s], es[i])
+			}
+		}
+	}
+	idx.Save()
+	rev, err := revparse.NewInStream(rp, current)
+	if err != nil {
+		return err
+	}
+
+	g := graph.Build(names, entries)
+	paint := func(lane int, s string) string {
+		return c.Color(laneColors[lane%len(laneColors)], s)
+	}
+	shown := 0
+	for i := range g.Rows {
+		row := &g.Rows[i]
+		cm, err := commits.LoadCommit(rp, row.Stream(names), row.ID)
+		if err != nil {
+			return err
+		}
+		if opts.Grep != nil && !opts.Grep.MatchString(cm.Message) {
+			continue
+		}
+		msg, _, _ := strings.Cut(cm.Message, "\n")
+		deco := ""
+		if len(row.Heads) > 0 {
+			deco = " " + c.Color(colorGreen, "("+strings.Join(row.Heads, ", ")+")")
+		}
+		if cm.PickedFrom != "" {
+			msg += " (picked from " + rev.Abbrev(cm.PickedFrom) + ")"
+		}
+		c.Printf("%s %s%s %s\n", g.Line(i, paint), c.Color(colorYellow, rev.Abbrev(cm.ID)), deco, msg)
+		if shown++; shown == maxCount {
+			break
+		}
+	}
+	if shown == 0 {
+		c.Infof("No commits match.\n")
+	}
+	return nil
+}
+
+// logEntry is the JSON form of a commit in log and show
+type logEntry struct {
+	ID          string          `json:"id"`
+	Stream      string          `json:"stream"`
+	Message     string          `json:"message"`
+	AuthorName  string          `json:"authorName"`
+	AuthorEmail string          `json:"authorEmail"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Ops         int             `json:"ops"`
+	Signed      bool            `json:"signed"`
+	Verified    *bool           `json:"verified,omitempty"`
+	Trailers    []types.Trailer `json:"trailers,omitempty"`
+}
+
+func newLogEntry(rp string, cm *types.Commit, verify bool) logEntry {
+	e := logEntry{cm.ID, cm.Stream, cm.Message, cm.AuthorName, cm.AuthorEmail, cm.Timestamp, len(cm.Operations), cm.Signature != "", nil, cm.Trailers}
+	if e.Signed && verify {
+		valid, err := signing.VerifyCommit(cm, rp)
+		valid = valid && err == nil
+		e.Verified = &valid
+	}
+	return e
+}
+
+// printCommit prints the header, message and trailers of a commit
+func printCommit(c *cmdContext, rp string, cm *types.Commit, verify bool) {
+	ver := ""
+	if cm.Signature != "" && verify {
+		valid, err := signing.VerifyCommit(cm, rp)
+		if err != nil {
+			ver = c.Color(colorRed, " (error: "+err.Error()+")")
+		} else if valid {
+			ver = c.Color(colorGreen, " (verified)")
+		} else {
+			ver = c.Color(colorRed, " (INVALID!)")
+		}
+	}
+	c.Printf("%s%s\nAuthor: %s <%s>\nDate:   %s\n\n    %s\n",
+		c.Color(colorYellow, "commit "+cm.ID), ver, cm.AuthorName, cm.AuthorEmail, cm.Timestamp.Local(), cm.Message)
+	if len(cm.Trailers) > 0 {
+		c.Printf("\n")
+		for _, t := range cm.Trailers {
+			c.Printf("    %s: %s\n", t.Key, t.Value)
+		}
+	}
+}
@@ -1,5 +1,36 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
 func main() {
 	Execute()
 }
+
+// interruptible returns a context cancelled by the first Ctrl-C or SIGTERM,
+// for commands that roll back what they wrote when cancelled rather than
+// leave it half done. A second signal kills the process as usual. stop ends
+// the handling.
+func interruptible() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			fmt.Fprintln(os.Stderr, "Interrupted; rolling back (interrupt again to quit now)")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"evo/internal/maintenance"
+	"evo/internal/util"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var maintenanceCmd = &cobra.Command{
+		Use:   "maintenance",
+		Short: "Compact op logs, prune tombstones and collect garbage",
+		Long: `Runs repository housekeeping on demand or on a schedule:
+- repack: rewrite old op logs in the current format, drop partial records
+- compact: collapse op logs that reached the compaction threshold
+- prune: drop expired tombstones
+- lfs-gc: remove large file content the LFS policy no longer keeps (see evo lfs)
+- blob-gc: remove stored file contents no stream is in sync with`,
+	}
+
+	var tasks []string
+	var schedule bool
+	var interval time.Duration
+	var runCmd = &cobra.Command{
+		Use:   "run",
+		Short: "Run maintenance tasks now, or repeatedly with --schedule",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			var ts []maintenance.Task
+			for _, name := range tasks {
+				t, err := maintenance.ParseTask(name)
+				if err != nil {
+					return err
+				}
+				ts = append(ts, t)
+			}
+
+			if !schedule {
+				ctx, stop := interruptible()
+				defer stop()
+				res, err := maintenance.Run(c.progress(ctx), rp, ts)
+				if res != nil {
+					if err := c.Emit(res, func() { printResult(c, res) }); err != nil {
+						return err
+					}
+				}
+				return err
+			}
+
+			if !cmd.Flags().Changed("interval") {
+				st, err := maintenance.LoadState(rp)
+				if err != nil {
+					return err
+				}
+				if interval, err = st.ScheduleInterval(); err != nil {
+					return err
+				}
+			}
+			stop := make(chan struct{})
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sig
+				close(stop)
+			}()
+			c.Infof("Running maintenance every %s (Ctrl-C to stop)\n", interval)
+			return maintenance.Schedule(rp, ts, interval, stop, func(res *maintenance.Result, err error) {
+				if res != nil {
+					c.Emit(res, func() { printResult(c, res) })
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+			})
+		},
+	}
+	runCmd.Flags().StringSliceVar(&tasks, "task", nil, "Tasks to run (repack, compact, prune, lfs-gc, blob-gc); default all")
+	runCmd.Flags().BoolVar(&schedule, "schedule", false, "Keep running tasks at the configured interval")
+	runCmd.Flags().DurationVar(&interval, "interval", maintenance.DefaultInterval, "Time between scheduled runs")
+
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show maintenance settings, the last run and current sizes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			st, err := maintenance.LoadState(rp)
+			if err != nil {
+				return err
+			}
+			iv, err := st.ScheduleInterval()
+			if err != nil {
+				return err
+			}
+			cur, err := maintenance.CollectStats(rp)
+			if err != nil {
+				return fmt.Errorf("failed to collect stats: %w", err)
+			}
+			out := struct {
+				*maintenance.State
+				Current *maintenance.Stats `json:"current"`
+			}{st, cur}
+			return c.Emit(out, func() {
+				if st.Enabled {
+					c.Printf("Scheduled maintenance: enabled, every %s\n", iv)
+				} else {
+					c.Printf("Scheduled maintenance: disabled\n")
+				}
+				if st.LastRun == nil {
+					c.Printf("Last run: never\n")
+				} else {
+					c.Printf("Last run: %s (%s)\n", st.LastRun.Started.Local().Format(time.RFC1123), st.LastRun.Duration.Round(time.Millisecond))
+					if st.LastRun.Error != "" {
+						c.Printf("Last error: %s\n", c.Color(colorRed, st.LastRun.Error))
+					}
+					if st.Enabled && st.Due(time.Now(), iv) {
+						c.Printf("Next run: due now\n")
+					} else if st.Enabled {
+						c.Printf("Next run: %s\n", st.LastRun.Started.Add(iv).Local().Format(time.RFC1123))
+					}
+				}
+				c.Printf("\n")
+				printStats(c, cur)
+			})
+		},
+	}
+
+	var enableInterval time.Duration
+	var enableCmd = &cobra.Command{
+		Use:   "enable",
+		Short: "Enable scheduled maintenance for this repository",
+		Long: `Marks the repository for scheduled maintenance. Scheduled runs are made by
+'evo maintenance run --schedule', which uses the interval set here.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			if enableInterval <= 0 {
+				return fmt.Errorf("interval must be positive")
+			}
+			st, err := maintenance.LoadState(rp)
+			if err != nil {
+				return err
+			}
+			st.Enabled = true
+			st.Interval = enableInterval.String()
+			if err := st.Save(rp); err != nil {
+				return fmt.Errorf("failed to save maintenance state: %w", err)
+			}
+			return c.Done(st, "Scheduled maintenance enabled, every %s\n", enableInterval)
+		},
+	}
+	enableCmd.Flags().DurationVar(&enableInterval, "interval", maintenance.DefaultInterval, "Time between scheduled runs")
+
+	var disableCmd = &cobra.Command{
+		Use:   "disable",
+		Short: "Disable scheduled maintenance for this repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			st, err := maintenance.LoadState(rp)
+			if err != nil {
+				return err
+			}
+			st.Enabled = false
+			if err := st.Save(rp); err != nil {
+				return fmt.Errorf("failed to save maintenance state: %w", err)
+			}
+			return c.Done(st, "Scheduled maintenance disabled\n")
+		},
+	}
+
+	maintenanceCmd.AddCommand(runCmd, statusCmd, enableCmd, disableCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
+
+func printResult(c *cmdContext, res *maintenance.Result) {
+	c.Printf("Ran %v in %s\n", res.Tasks, res.Duration.Round(time.Millisecond))
+	if res.Before == nil || res.After == nil {
+		return
+	}
+	b, a := res.Before, res.After
+	c.Printf("%-12s %12s %12s\n", "", "before", "after")
+	c.Printf("%-12s %12d %12d\n", "op logs", b.OpLogs, a.OpLogs)
+	c.Printf("%-12s %12d %12d\n", "ops", b.Ops, a.Ops)
+	c.Printf("%-12s %12s %12s\n", "op bytes", util.HumanBytes(b.OpBytes), util.HumanBytes(a.OpBytes))
+	c.Printf("%-12s %12d %12d\n", "stored ops", b.StoredOps, a.StoredOps)
+	c.Printf("%-12s %12s %12s\n", "store bytes", util.HumanBytes(b.StoreBytes), util.HumanBytes(a.StoreBytes))
+	c.Printf("%-12s %12d %12d\n", "commits", b.Commits, a.Commits)
+	c.Printf("%-12s %12s %12s\n", "commit bytes", util.HumanBytes(b.CommitBytes), util.HumanBytes(a.CommitBytes))
+	c.Printf("%-12s %12d %12d\n", "lfs chunks", b.LFSChunks, a.LFSChunks)
+	c.Printf("%-12s %12s %12s\n", "lfs bytes", util.HumanBytes(b.LFSBytes), util.HumanBytes(a.LFSBytes))
+	c.Printf("%-12s %12d %12d\n", "blobs", b.Blobs, a.Blobs)
+	c.Printf("%-12s %12s %12s\n", "blob bytes", util.HumanBytes(b.BlobBytes), util.HumanBytes(a.BlobBytes))
+}
+
+func printStats(c *cmdContext, s *maintenance.Stats) {
+	c.Printf("Op logs:  %d (%d ops, %s)\n", s.OpLogs, s.Ops, util.HumanBytes(s.OpBytes))
+	c.Printf("Op store: %d ops (%s)\n", s.StoredOps, util.HumanBytes(s.StoreBytes))
+	c.Printf("Commits:  %d (%s)\n", s.Commits, util.HumanBytes(s.CommitBytes))
+	c.Printf("LFS:      %d chunks (%s)\n", s.LFSChunks, util.HumanBytes(s.LFSBytes))
+	c.Printf("Blobs:    %d (%s)\n", s.Blobs, util.HumanBytes(s.BlobBytes))
+}
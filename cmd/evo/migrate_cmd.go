@@ -0,0 +1,63 @@
+package main
+
+import (
+	"evo/internal/migrate"
+	"evo/internal/repo"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var dryRun, noBackup bool
+
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the repository to the current format",
+		Long: `Upgrades a repository written by an older evo to the format of this one,
+recorded in .evo/version. .evo is first copied to .evo/backups/ unless
+--no-backup is given; --dry-run lists the upgrades without running them.
+Repositories in a newer format than this evo's are refused by every command.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				pending, err := migrate.Pending(c.Repo)
+				if err != nil {
+					return err
+				}
+				if pending == nil {
+					pending = []migrate.Migration{}
+				}
+				return c.Emit(pending, func() {
+					if len(pending) == 0 {
+						c.Infof("Repository is at format version %d, nothing to do\n", repo.FormatVersion)
+						return
+					}
+					for _, m := range pending {
+						c.Printf("%d -> %d: %s\n", m.From, m.From+1, m.Description)
+					}
+				})
+			}
+			res, err := migrate.Run(c.Repo, noBackup)
+			if err != nil {
+				return err
+			}
+			if len(res.Applied) == 0 {
+				return c.Done(res, "Repository is at format version %d, nothing to do\n", res.To)
+			}
+			for _, m := range res.Applied {
+				c.Infof("%d -> %d: %s\n", m.From, m.From+1, m.Description)
+			}
+			if res.Backup != "" {
+				c.Infof("Backup of the old repository: %s\n", res.Backup)
+			}
+			return c.Done(res, "Migrated from format version %d to %d\n", res.From, res.To)
+		},
+	}
+	migrateCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "List the upgrades without running them")
+	migrateCmd.Flags().BoolVar(&noBackup, "no-backup", false, "Don't copy .evo aside first")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"evo/internal/exchange"
+	"evo/internal/multi"
+	"evo/internal/repo"
+	"evo/internal/status"
+	"evo/internal/streams"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// multiStatus summarizes the status of a repository in a line, followed by
+// the changed files
+func multiStatus(r multi.Repo) (any, string, error) {
+	st, err := status.GetStatus(r.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(st.Files) == 0 && st.StagedOps == 0 {
+		return st, fmt.Sprintf("%s: clean\n", st.CurrentStream), nil
+	}
+	counts := map[string]int{}
+	for _, f := range st.Files {
+		counts[f.Status]++
+	}
+	var parts []string
+	for _, s := range []string{"modified", "new", "deleted", "renamed"} {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	if st.StagedOps > 0 {
+		parts = append(parts, fmt.Sprintf("%d staged op(s)", st.StagedOps))
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s\n", st.CurrentStream, strings.Join(parts, ", "))
+	for _, f := range st.Files {
+		if f.OldPath != "" {
+			fmt.Fprintf(&sb, "  %-9s %s -> %s\n", f.Status+":", f.OldPath, f.Path)
+		} else {
+			fmt.Fprintf(&sb, "  %-9s %s\n", f.Status+":", f.Path)
+		}
+	}
+	return st, sb.String(), nil
+}
+
+// multiExchange pushes or pulls the current stream of a repository
+func multiExchange(remoteName string, push bool) multi.Op {
+	return func(r multi.Repo) (any, string, error) {
+		stream, err := streams.CurrentStream(r.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		rem, err := requireRemote(r.Path, remoteName)
+		if err != nil {
+			return nil, "", err
+		}
+		if push {
+			res, err := exchange.Push(context.Background(), r.Path, rem, stream)
+			if err != nil {
+				return nil, "", err
+			}
+			return res, fmt.Sprintf("pushed %d commit(s) of %s to %s\n", len(res.Commits), stream, rem.Name), nil
+		}
+		res, err := exchange.Pull(context.Background(), r.Path, rem, stream)
+		if err != nil {
+			return nil, "", err
+		}
+		return res, fmt.Sprintf("pulled %d commit(s) of %s from %s\n", len(res.Commits), stream, rem.Name), nil
+	}
+}
+
+func init() {
+	var root, manifest, remoteName string
+	var jobs int
+
+	var multiCmd = &cobra.Command{
+		Use:   "multi",
+		Short: "Run status, pull or push over many repositories",
+		Long: `Runs an operation over every repository under --root, or those listed in
+--manifest (one path per line, relative to the manifest; # starts a comment).
+Repositories are handled --jobs at a time and each one's output is printed
+as a block of lines prefixed with its path, in order.`,
+	}
+
+	run := func(op multi.Op) error {
+		c := baseContext()
+		var repos []multi.Repo
+		var err error
+		if manifest != "" {
+			repos, err = multi.ReadManifest(manifest)
+		} else {
+			repos, err = multi.Discover(root)
+		}
+		if err != nil {
+			return err
+		}
+		if len(repos) == 0 {
+			return c.Done([]*multi.Result{}, "No repositories found.\n")
+		}
+		versioned := func(r multi.Repo) (any, string, error) {
+			if err := repo.CheckVersion(r.Path); err != nil {
+				return nil, "", err
+			}
+			return op(r)
+		}
+		var results []*multi.Result
+		failed := multi.Run(repos, jobs, versioned, func(res *multi.Result) {
+			results = append(results, res)
+			if res.Err != nil {
+				fmt.Fprint(os.Stderr, multi.Prefix(res.Name, "error: "+res.Error))
+				return
+			}
+			c.Printf("%s", multi.Prefix(res.Name, res.Output))
+		})
+		if err := c.Emit(results, func() {}); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d repositories failed", failed, len(repos))
+		}
+		return nil
+	}
+
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the working tree status of each repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(multiStatus)
+		},
+	}
+
+	var pullCmd = &cobra.Command{
+		Use:   "pull",
+		Short: "Pull the current stream of each repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(multiExchange(remoteName, false))
+		},
+	}
+
+	var pushCmd = &cobra.Command{
+		Use:   "push",
+		Short: "Push the current stream of each repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(multiExchange(remoteName, true))
+		},
+	}
+
+	multiCmd.PersistentFlags().StringVar(&root, "root", ".", "Directory to search for repositories")
+	multiCmd.PersistentFlags().StringVar(&manifest, "manifest", "", "File listing the repositories, instead of searching --root")
+	multiCmd.PersistentFlags().IntVarP(&jobs, "jobs", "j", 4, "Number of repositories to handle at once")
+	for _, cmd := range []*cobra.Command{pullCmd, pushCmd} {
+		cmd.Flags().StringVar(&remoteName, "remote", "", "Remote of each repository to use (default: origin or the only remote)")
+		cmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+	}
+	multiCmd.AddCommand(statusCmd, pullCmd, pushCmd)
+	rootCmd.AddCommand(multiCmd)
+}
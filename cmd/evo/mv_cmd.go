@@ -0,0 +1,49 @@
+package main
+
+import (
+	"evo/internal/worktree"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var mvCmd = &cobra.Command{
+		Use:   "mv <src>... <dst>",
+		Short: "Move or rename tracked files, keeping their history",
+		Long: `Moves files or directories in the working tree and the index. A moved file
+keeps its file ID, so its history and ops follow it instead of it showing
+up as one file deleted and another added. The renames are recorded as
+Renamed trailers of the next commit.
+
+With several sources, or when <dst> is an existing directory, each source
+moves into <dst> under its own name. A file already moved by hand can be
+recorded the same way: only the index is updated.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			stream, err := attachedStream(c.Repo)
+			if err != nil {
+				return err
+			}
+			paths := make([]string, len(args))
+			for i, a := range args {
+				if paths[i], err = pathOrRoot(c.Repo, a); err != nil {
+					return err
+				}
+			}
+			renames, err := worktree.Move(c.Repo, stream, paths[:len(paths)-1], paths[len(paths)-1])
+			if err != nil {
+				return err
+			}
+			return c.Emit(renames, func() {
+				for _, r := range renames {
+					c.Printf("%s -> %s\n", r.From, r.To)
+				}
+			})
+		},
+	}
+	rootCmd.AddCommand(mvCmd)
+}
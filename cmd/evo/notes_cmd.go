@@ -0,0 +1,202 @@
+package main
+
+import (
+	"evo/internal/everrors"
+	"evo/internal/identity"
+	"evo/internal/notes"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// printNotes prints the notes of a commit below its message
+func printNotes(c *cmdContext, ns []notes.Note) {
+	for _, n := range ns {
+		c.Printf("\nNotes (%s, %s <%s>, %s):\n", n.Namespace, n.AuthorName, n.AuthorEmail, n.Timestamp.Local().Format(time.RFC1123))
+		for _, line := range strings.Split(n.Text, "\n") {
+			c.Printf("    %s\n", line)
+		}
+	}
+}
+
+// noteTarget resolves the commit-ish in args[0], or HEAD, to a commit ID
+func noteTarget(rp string, args []string) (string, error) {
+	spec := "HEAD"
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	cm, _, err := revparse.Resolve(rp, spec)
+	if err != nil {
+		return "", err
+	}
+	return cm.ID, nil
+}
+
+func init() {
+	var ns string
+	var notesCmd = &cobra.Command{
+		Use:   "notes",
+		Short: "Attach mutable annotations to existing commits",
+		Long: `Notes annotate commits (build results, review comments) without changing
+them: they are stored outside the signed commit body in .evo/notes and can be
+added or removed at any time. --ns groups notes into namespaces.`,
+	}
+	notesCmd.PersistentFlags().StringVar(&ns, "ns", notes.DefaultNamespace, "Notes namespace")
+
+	completeCommit := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return commitCandidates(toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var message, author string
+	var addCmd = &cobra.Command{
+		Use:               "add [commit-ish] -m <text>",
+		Short:             "Add a note to a commit (HEAD by default)",
+		ValidArgsFunction: completeCommit,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if message == "" {
+				return fmt.Errorf("use -m to give the note text")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			id, err := noteTarget(rp, args)
+			if err != nil {
+				return err
+			}
+			who, err := identity.ForCommit(rp, author)
+			if err != nil {
+				return err
+			}
+			n, err := notes.Add(rp, ns, id, who.Name, who.Email, message)
+			if err != nil {
+				return err
+			}
+			return c.Done(n, "Added note %s to commit %s\n", n.ID[:8], id)
+		},
+	}
+	addCmd.Flags().StringVarP(&message, "message", "m", "", "Note text")
+	addCmd.Flags().StringVar(&author, "author", "", "Override the note author, as \"Name <email>\"")
+
+	var showCmd = &cobra.Command{
+		Use:               "show [commit-ish]",
+		Short:             "Show the notes of a commit (HEAD by default)",
+		ValidArgsFunction: completeCommit,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			id, err := noteTarget(rp, args)
+			if err != nil {
+				return err
+			}
+			list, err := notes.List(rp, ns, id)
+			if err != nil {
+				return err
+			}
+			if list == nil {
+				list = []notes.Note{}
+			}
+			return c.Emit(list, func() {
+				if len(list) == 0 {
+					c.Infof("No notes on commit %s\n", id)
+					return
+				}
+				for _, n := range list {
+					c.Printf("%s %s <%s> %s\n", c.Color(colorYellow, n.ID[:8]), n.AuthorName, n.AuthorEmail, n.Timestamp.Local().Format(time.RFC1123))
+					for _, line := range strings.Split(n.Text, "\n") {
+						c.Printf("    %s\n", line)
+					}
+				}
+			})
+		},
+	}
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List commits that have notes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			counts, err := notes.Annotated(c.Repo, ns)
+			if err != nil {
+				return err
+			}
+			ids := make([]string, 0, len(counts))
+			for id := range counts {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			return c.Emit(counts, func() {
+				for _, id := range ids {
+					c.Printf("%s %d\n", id, counts[id])
+				}
+			})
+		},
+	}
+
+	var removeCmd = &cobra.Command{
+		Use:               "remove <commit-ish> <note-id>",
+		Short:             "Remove a note from a commit",
+		ValidArgsFunction: completeCommit,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo notes remove <commit-ish> <note-id>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			id, err := noteTarget(c.Repo, args)
+			if err != nil {
+				return err
+			}
+			n, err := notes.Remove(c.Repo, ns, id, args[1])
+			if err != nil {
+				return err
+			}
+			return c.Done(n, "Removed note %s from commit %s\n", n.ID[:8], id)
+		},
+	}
+
+	var syncCmd = &cobra.Command{
+		Use:   "sync <repo-path>",
+		Short: "Exchange notes with another local repository",
+		Long: `Merges the notes of both repositories so each ends up with all of them.
+Notes removed on either side are removed on both.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo notes sync <repo-path>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			other, err := repo.FindRepoRoot(args[0])
+			if err != nil {
+				return fmt.Errorf("not an evo repository: %s", args[0])
+			}
+			pulled, pushed, err := notes.Sync(c.Repo, other)
+			if err != nil {
+				return err
+			}
+			return c.Done(map[string]int{"pulled": pulled, "pushed": pushed}, "Pulled %d and pushed %d note change(s)\n", pulled, pushed)
+		},
+	}
+
+	notesCmd.AddCommand(addCmd, showCmd, listCmd, removeCmd, syncCmd)
+	rootCmd.AddCommand(notesCmd)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"evo/internal/index"
+	"evo/internal/streams"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opsCmd = &cobra.Command{
+		Use:   "ops",
+		Short: "Export and import op logs as JSON Lines",
+	}
+
+	var exportCmd = &cobra.Command{
+		Use:   "export <stream> [file-id|path]",
+		Short: "Write a stream's ops as JSON Lines",
+		Long: `Writes the ops of every op log of a stream, or of one file given by its ID or
+tracked path, to standard output as JSON Lines: one object per op with its
+file, type (insert, update or delete), Lamport time, node, line, origin
+("start" for the top of the file), content, timestamp and vector clock, in
+log order. Files the index knows also carry their path.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			fid := ""
+			if len(args) > 1 {
+				fid = args[1]
+				if _, err := uuid.Parse(fid); err != nil {
+					if fid, err = index.LookupFileID(c.Repo, filepath.ToSlash(args[1])); err != nil {
+						return err
+					}
+				}
+			}
+			_, err = streams.ExportOps(c.Repo, args[0], fid, os.Stdout)
+			return err
+		},
+	}
+
+	var importCmd = &cobra.Command{
+		Use:   "import <stream> [file|-]",
+		Short: "Append ops from JSON Lines to a stream's op logs",
+		Long: `Reads ops as evo ops export writes them, from a file or standard input, and
+appends those a log doesn't have yet to the stream's op logs. The ops show up
+as uncommitted changes until a commit records them; if the stream is checked
+out, the files they touch are rewritten.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			var r io.Reader = os.Stdin
+			if len(args) > 1 && args[1] != "-" {
+				f, err := os.Open(args[1])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			res, err := streams.ImportOps(c.Repo, args[0], r)
+			if err != nil {
+				return err
+			}
+			return c.Done(res, "Imported %d ops into %s (%d already there)\n", res.Added, res.Stream, res.Skipped)
+		},
+	}
+	opsCmd.AddCommand(exportCmd, importCmd)
+	rootCmd.AddCommand(opsCmd)
+}
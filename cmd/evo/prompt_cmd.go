@@ -0,0 +1,32 @@
+package main
+
+import (
+	shellprompt "evo/internal/prompt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var promptCmd = &cobra.Command{
+		Use:   "prompt",
+		Short: "Print a one-line summary for shell prompts",
+		Long: `Prints the current stream, the commits it is ahead (↑) and behind (↓) of its
+upstream, and * when the working tree has uncommitted changes, e.g. "main ↑2 *".
+The upstream is the stream, or <remote>/<stream>, named by the upstream key of
+its [stream.<name>] config section. Results are cached under .evo, so it is fast enough for PS1 or
+a starship custom module; use --json for the fields.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			info, err := shellprompt.Get(c.Repo)
+			if err != nil {
+				return err
+			}
+			return c.Emit(info, func() { c.Printf("%s\n", info) })
+		},
+	}
+	rootCmd.AddCommand(promptCmd)
+}
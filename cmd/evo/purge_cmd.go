@@ -0,0 +1,105 @@
+package main
+
+import (
+	"evo/internal/purge"
+	"evo/internal/repo"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var paths []string
+	var pattern, replace string
+	var dryRun, force bool
+
+	var purgeCmd = &cobra.Command{
+		Use:   "purge (--path <path> | --pattern <regex>)... [-n | -f]",
+		Short: "Remove files or secrets from all history",
+		Long: `Rewrites the history of every stream to take content out of it for good,
+as when a secret or a huge file was committed by mistake.
+
+--path drops a file from every stream: its ops, its large-file content and
+its index entry. A directory drops every file under it, and a file ID names
+a file no longer tracked. The file stays in the working tree, untracked.
+
+--pattern replaces the text matching a regular expression with --replace
+in every line of every file, in the op logs, the commits and the working
+tree.
+
+Commits whose ops change get new IDs and lose their signatures; tags,
+stream bases, notes, picks and remote-tracking records are moved to the
+new IDs, and the mapping is printed. The undo journal is cleared. Other
+clones and remotes keep the old history until they are replaced.
+
+As purged content can't be brought back, purge needs -f; -n reports what
+would change instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !force && !dryRun {
+				return fmt.Errorf("refusing to purge without -f; use -n to see what would change")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			opts := purge.Options{Replace: replace, DryRun: dryRun}
+			for _, p := range paths {
+				if _, err := uuid.Parse(p); err == nil || repo.IsBare(c.Repo) {
+					opts.Paths = append(opts.Paths, p)
+					continue
+				}
+				rel, err := pathOrRoot(c.Repo, p)
+				if err != nil {
+					return err
+				}
+				opts.Paths = append(opts.Paths, rel)
+			}
+			if pattern != "" {
+				if opts.Pattern, err = regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid pattern: %w", err)
+				}
+			}
+			res, err := purge.Purge(c.Repo, opts)
+			if err != nil {
+				return err
+			}
+			return c.Emit(res, func() {
+				verb, redacted := "Purged", "Redacted"
+				if dryRun {
+					verb, redacted = "Would purge", "Would redact"
+				}
+				for _, f := range res.Files {
+					name := f.Path
+					if name == "" {
+						name = f.ID
+					}
+					c.Printf("%s %s\n", verb, name)
+				}
+				if opts.Pattern != nil {
+					c.Printf("%s %d op(s) matching %s\n", redacted, res.Redacted, pattern)
+				}
+				olds := make([]string, 0, len(res.Commits))
+				for old := range res.Commits {
+					olds = append(olds, old)
+				}
+				sort.Strings(olds)
+				for _, old := range olds {
+					c.Printf("%s -> %s\n", old, res.Commits[old])
+				}
+				if len(res.Commits) > 0 && !dryRun {
+					c.Infof("%d commit(s) rewritten; other clones and remotes still have the old history\n", len(res.Commits))
+				}
+			})
+		},
+	}
+	purgeCmd.Flags().StringArrayVar(&paths, "path", nil, "File, directory or file ID to drop from history (repeatable)")
+	purgeCmd.Flags().StringVar(&pattern, "pattern", "", "Regular expression of text to redact")
+	purgeCmd.Flags().StringVar(&replace, "replace", "[REDACTED]", "What redacted text becomes")
+	purgeCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only report what would change")
+	purgeCmd.Flags().BoolVarP(&force, "force", "f", false, "Rewrite history")
+	rootCmd.AddCommand(purgeCmd)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"evo/internal/checkout"
+	"evo/internal/revparse"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// pathOrRoot is repoRelative that also accepts the repository root, as "."
+func pathOrRoot(rp, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if root, err := filepath.Abs(rp); err == nil && abs == root {
+		return ".", nil
+	}
+	return repoRelative(rp, path)
+}
+
+func init() {
+	var from string
+
+	var restoreCmd = &cobra.Command{
+		Use:   "restore <path>... [--from <commit-ish>]",
+		Short: "Restore files in the working tree from a commit",
+		Long: `Writes the files at or under each path as they were at a commit or the
+newest commit of a stream (default: HEAD), removing tracked files the commit
+didn't have; files deleted since are tracked again. HEAD doesn't move: the
+restored content shows up as uncommitted changes, ready to commit. Large files come from the LFS store,
+which keeps one version of each; pull missing ones with 'evo transfer pull'.
+
+Uncommitted changes to the restored files are overwritten.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			commit, stream, err := revparse.Resolve(c.Repo, from)
+			if err != nil {
+				return err
+			}
+			paths := make([]string, len(args))
+			for i, a := range args {
+				if paths[i], err = pathOrRoot(c.Repo, a); err != nil {
+					return err
+				}
+			}
+			res, err := checkout.Restore(c.Repo, stream, commit.ID, paths)
+			if err != nil {
+				return err
+			}
+			return c.Done(res, "Restored %d file(s) from %s of %s, removed %d\n", len(res.Written), commit.ID, stream, len(res.Removed))
+		},
+	}
+	restoreCmd.Flags().StringVar(&from, "from", "HEAD", "Commit or stream to restore from")
+	rootCmd.AddCommand(restoreCmd)
+}
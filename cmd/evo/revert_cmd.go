@@ -2,38 +2,128 @@ package main
 
 import (
 	"evo/internal/commits"
+	"evo/internal/everrors"
+	"evo/internal/journal"
 	"evo/internal/repo"
+	"evo/internal/revparse"
 	"evo/internal/streams"
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	revertNoCommit bool
+	revertAbort    bool
+)
+
 func init() {
 	var revertCmd = &cobra.Command{
-		Use:   "revert <commit-id>",
-		Short: "Revert the specified commit by generating inverse ops",
-		Long:  `This properly restores old lines if the commit performed updates, removing inserted lines, etc.`,
+		Use:   "revert <commit-ish|from..to>...",
+		Short: "Revert the specified commits by generating inverse ops",
+		Long: `This properly restores old lines if the commit performed updates, removing inserted lines, etc.
+
+Commits may be named by ID prefix, tag, HEAD or stream~N. Several commits and ranges may be given; a range from..to covers the commits after
+"from" up to and including "to". Commits are reverted newest first, one revert commit
+each. With --no-commit the inverse ops are staged instead, to be reviewed and recorded
+by the next "evo commit" (or discarded with --abort).`,
+		ValidArgsFunction: completeCurrentCommits,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) < 1 {
-				return fmt.Errorf("usage: evo revert <commit-id>")
-			}
-			commitID := args[0]
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
+			rp := c.Repo
 			str, err := streams.CurrentStream(rp)
 			if err != nil {
 				return err
 			}
-			newC, err := commits.RevertCommit(rp, str, commitID)
+			stagedFile := filepath.Join(repo.EvoDir, "staged", str+".json")
+			if revertAbort {
+				return journaled(rp, "revert", "abort staged revert", func(rec *journal.Recorder) error {
+					if err := rec.TrackFile(stagedFile); err != nil {
+						return err
+					}
+					if err := commits.ClearStaged(rp, str); err != nil {
+						return err
+					}
+					return c.Done(map[string]bool{"aborted": true}, "Discarded staged operations\n")
+				})
+			}
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo revert <commit-id|from..to>...")
+			}
+			args, err = resolveRevertSpecs(rp, str, args)
 			if err != nil {
-				return fmt.Errorf("failed to revert commit: %w", err)
+				return err
 			}
-			fmt.Printf("Created revert commit %s\n", newC.ID)
-			return nil
+			desc := "revert " + strings.Join(args, " ")
+			return journaled(rp, "revert", desc, func(rec *journal.Recorder) error {
+				if revertNoCommit {
+					if err := rec.TrackFile(stagedFile); err != nil {
+						return err
+					}
+					n, err := commits.StageRevert(rp, str, args)
+					if err != nil {
+						return fmt.Errorf("failed to revert commit: %w", err)
+					}
+					return c.Done(map[string]int{"staged": n}, "Staged %d inverse operation(s); run 'evo commit' to record them\n", n)
+				}
+				targets, err := commits.ResolveRevertTargets(rp, str, args)
+				if err != nil {
+					return fmt.Errorf("failed to revert commit: %w", err)
+				}
+				for _, t := range targets {
+					if err := trackOpFiles(rec, rp, t.Operations); err != nil {
+						return err
+					}
+				}
+				created, err := commits.RevertCommits(rp, str, args)
+				ids := []string{}
+				for _, newC := range created {
+					ids = append(ids, newC.ID)
+					c.Infof("Created revert commit %s\n", newC.ID)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to revert commit: %w", err)
+				}
+				return c.Emit(map[string][]string{"created": ids}, func() {})
+			})
 		},
 	}
+	revertCmd.Flags().BoolVarP(&revertNoCommit, "no-commit", "n", false, "Stage the inverse ops without committing")
+	revertCmd.Flags().BoolVar(&revertAbort, "abort", false, "Discard ops staged by a previous --no-commit revert")
 	rootCmd.AddCommand(revertCmd)
 }
+
+// resolveRevertSpecs replaces the commit-ishes in revert arguments, including
+// both ends of from..to ranges, with full commit IDs
+func resolveRevertSpecs(rp, stream string, specs []string) ([]string, error) {
+	r, err := revparse.NewInStream(rp, stream)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if from, to, ok := strings.Cut(spec, ".."); ok {
+			fromID, err := r.ResolveID(from)
+			if err != nil {
+				return nil, err
+			}
+			toID, err := r.ResolveID(to)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, fromID+".."+toID)
+			continue
+		}
+		id, err := r.ResolveID(spec)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
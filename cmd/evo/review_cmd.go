@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/hex"
+	"evo/internal/everrors"
+	"evo/internal/journal"
+	"evo/internal/merge"
+	"evo/internal/repo"
+	"evo/internal/review"
+	"evo/internal/revparse"
+	"evo/internal/signing"
+	"evo/internal/streams"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// completeReviews completes open review IDs
+func completeReviews(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	c, err := newContext()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	all, _ := review.List(c.Repo)
+	var out []string
+	for _, r := range all {
+		if r.State == review.StateOpen && strings.HasPrefix(r.ID, toComplete) {
+			out = append(out, r.ID[:8]+"\t"+r.Title)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// loadReview resolves an ID prefix and loads the review
+func loadReview(rp, prefix string) (*review.Review, error) {
+	id, err := review.Resolve(rp, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return review.Get(rp, id)
+}
+
+func init() {
+	var reviewCmd = &cobra.Command{
+		Use:   "review",
+		Short: "Review merges of one stream into another",
+		Long: `Reviews are stored in .evo/reviews and merge across copies of the repository.
+Comments can be anchored to a line of the source stream and follow that line
+as the file changes. Approvals are signed with signing.keyPath and cover the
+source stream's head at the time; 'evo review merge' needs
+review.requiredApprovals of them for the current head. Only approvals made
+with a key added by 'evo review trust' under its owner's email count, and
+never the review author's own.`,
+	}
+
+	var title string
+	var openCmd = &cobra.Command{
+		Use:   "open <source> [target]",
+		Short: "Open a review of merging source into target (the current stream by default)",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeStreams(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review open <source> [target]")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			target := ""
+			if len(args) > 1 {
+				target = args[1]
+			} else if target, err = streams.CurrentStream(rp); err != nil {
+				return err
+			}
+			who, err := issueAuthor(rp)
+			if err != nil {
+				return err
+			}
+			r, err := review.Open(rp, title, args[0], target, who)
+			if err != nil {
+				return err
+			}
+			return c.Done(r, "Opened review %s: %s\n", r.ID[:8], r.Title)
+		},
+	}
+	openCmd.Flags().StringVarP(&title, "title", "t", "", "Review title")
+
+	var all bool
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List open reviews",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			list, err := review.List(c.Repo)
+			if err != nil {
+				return err
+			}
+			out := []*review.Review{}
+			for _, r := range list {
+				if all || r.State == review.StateOpen {
+					out = append(out, r)
+				}
+			}
+			return c.Emit(out, func() {
+				for _, r := range out {
+					c.Printf("%s %-6s %s -> %s  %s\n", c.Color(colorYellow, r.ID[:8]), r.State, r.Source, r.Target, r.Title)
+				}
+			})
+		},
+	}
+	listCmd.Flags().BoolVarP(&all, "all", "a", false, "Include merged and closed reviews")
+
+	var showCmd = &cobra.Command{
+		Use:               "show <review>",
+		Short:             "Show a review's commits, comments and approvals",
+		ValidArgsFunction: completeReviews,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review show <review>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			r, err := loadReview(rp, args[0])
+			if err != nil {
+				return err
+			}
+			head, err := review.Head(rp, r.Source)
+			if err != nil {
+				return err
+			}
+			diff, err := review.Diff(rp, r)
+			if err != nil {
+				return err
+			}
+			rev, err := revparse.NewInStream(rp, r.Source)
+			if err != nil {
+				return err
+			}
+			reviewers, err := review.LoadReviewers(rp)
+			if err != nil {
+				return err
+			}
+			ids := []string{}
+			for _, cm := range diff {
+				ids = append(ids, cm.ID)
+			}
+			return c.Emit(struct {
+				*review.Review
+				Head    string   `json:"head"`
+				Commits []string `json:"commits"`
+			}{r, head, ids}, func() {
+				c.Printf("%s %s\n", c.Color(colorYellow, "review "+r.ID), r.State)
+				c.Printf("Author: %s <%s>\nDate:   %s\nMerge:  %s -> %s\n\n    %s\n",
+					r.AuthorName, r.AuthorEmail, r.Created.Local().Format(time.RFC1123), r.Source, r.Target, r.Title)
+				c.Printf("\nCommits (%d):\n", len(diff))
+				for _, cm := range diff {
+					msg, _, _ := strings.Cut(cm.Message, "\n")
+					c.Printf("    %s %s\n", c.Color(colorYellow, rev.Abbrev(cm.ID)), msg)
+				}
+				for _, cm := range r.Comments {
+					c.Printf("\n%s <%s> on %s", cm.AuthorName, cm.AuthorEmail, cm.Timestamp.Local().Format(time.RFC1123))
+					if a := cm.Anchor; a != nil {
+						if line, content, ok := review.Locate(rp, r.Source, a); ok {
+							c.Printf(" at %s:%d\n    > %s\n", a.Path, line, content)
+						} else {
+							c.Printf(" at %s:%d %s\n    > %s\n", a.Path, a.Line, c.Color(colorRed, "(line deleted)"), a.Content)
+						}
+					} else {
+						c.Printf(":\n")
+					}
+					for _, line := range strings.Split(cm.Text, "\n") {
+						c.Printf("    %s\n", line)
+					}
+				}
+				if len(r.Approvals) > 0 {
+					c.Printf("\nApprovals:\n")
+					for _, a := range r.Approvals {
+						status := c.Color(colorGreen, "valid")
+						if err := r.Verify(a, reviewers); err != nil {
+							status = c.Color(colorRed, "INVALID: "+err.Error())
+						} else if a.Head != head {
+							status = c.Color(colorYellow, "stale")
+						}
+						c.Printf("    %s <%s> (%s)\n", a.AuthorName, a.AuthorEmail, status)
+					}
+				}
+			})
+		},
+	}
+
+	var message, file string
+	var line int
+	var commentCmd = &cobra.Command{
+		Use:               "comment <review> -m <text> [--file <path> --line <n>]",
+		Short:             "Comment on a review, optionally on a line of the source stream",
+		ValidArgsFunction: completeReviews,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || message == "" {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review comment <review> -m <text> [--file <path> --line <n>]")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			r, err := loadReview(rp, args[0])
+			if err != nil {
+				return err
+			}
+			var anchor *review.Anchor
+			if file != "" {
+				if anchor, err = review.AnchorAt(rp, r.Source, file, line); err != nil {
+					return err
+				}
+			}
+			who, err := issueAuthor(rp)
+			if err != nil {
+				return err
+			}
+			if err := review.AddComment(rp, r.ID, message, anchor, who); err != nil {
+				return err
+			}
+			return c.Done(map[string]any{"id": r.ID, "anchor": anchor}, "Commented on review %s\n", r.ID[:8])
+		},
+	}
+	commentCmd.Flags().StringVarP(&message, "message", "m", "", "Comment text")
+	commentCmd.Flags().StringVar(&file, "file", "", "File to comment on")
+	commentCmd.Flags().IntVar(&line, "line", 1, "Line of --file to comment on")
+
+	var approveCmd = &cobra.Command{
+		Use:               "approve <review> [-m <comment>]",
+		Short:             "Sign an approval of the source stream's current head",
+		ValidArgsFunction: completeReviews,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review approve <review>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			r, err := loadReview(rp, args[0])
+			if err != nil {
+				return err
+			}
+			who, err := issueAuthor(rp)
+			if err != nil {
+				return err
+			}
+			a, err := review.Approve(rp, r.ID, message, who)
+			if err != nil {
+				return err
+			}
+			return c.Done(a, "Approved review %s at %s\n", r.ID[:8], a.Head)
+		},
+	}
+	approveCmd.Flags().StringVarP(&message, "message", "m", "", "Approval comment")
+
+	var force bool
+	var strategy string
+	var mergeCmd = &cobra.Command{
+		Use:               "merge <review>",
+		Short:             "Merge an approved review's source stream into its target",
+		ValidArgsFunction: completeReviews,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review merge <review>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			r, err := loadReview(rp, args[0])
+			if err != nil {
+				return err
+			}
+			if r.State != review.StateOpen {
+				return fmt.Errorf("review %s is %s", r.ID[:8], r.State)
+			}
+			st, err := merge.ParseStrategy(strategy)
+			if err != nil {
+				return err
+			}
+			if !force {
+				if err := review.CheckApprovals(rp, r); err != nil {
+					return fmt.Errorf("%w (use --force to merge anyway)", err)
+				}
+			}
+			who, err := issueAuthor(rp)
+			if err != nil {
+				return err
+			}
+			desc := fmt.Sprintf("review merge %s: %s into %s", r.ID[:8], r.Source, r.Target)
+			ctx, stop := interruptible()
+			defer stop()
+			return journaled(rp, "merge", desc, func(rec *journal.Recorder) error {
+				if err := mergeInto(ctx, c, rec, r.Source, r.Target, st); err != nil {
+					return err
+				}
+				if err := review.SetState(rp, r.ID, review.StateMerged, who); err != nil {
+					return err
+				}
+				return c.Done(map[string]string{"id": r.ID, "source": r.Source, "target": r.Target}, "Merged review %s: %s into %s\n", r.ID[:8], r.Source, r.Target)
+			})
+		},
+	}
+	mergeCmd.Flags().BoolVar(&force, "force", false, "Merge without the required approvals")
+	mergeCmd.Flags().StringVarP(&strategy, "strategy", "s", "crdt", "Conflict strategy: crdt, ours, theirs or union")
+
+	var closeCmd = &cobra.Command{
+		Use:               "close <review>",
+		Short:             "Close a review without merging",
+		ValidArgsFunction: completeReviews,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review close <review>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := loadReview(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			who, err := issueAuthor(c.Repo)
+			if err != nil {
+				return err
+			}
+			if err := review.SetState(c.Repo, r.ID, review.StateClosed, who); err != nil {
+				return err
+			}
+			return c.Done(map[string]string{"id": r.ID, "state": review.StateClosed}, "Closed review %s\n", r.ID[:8])
+		},
+	}
+
+	var syncCmd = &cobra.Command{
+		Use:   "sync <repo-path>",
+		Short: "Exchange reviews with another local repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review sync <repo-path>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			other, err := repo.FindRepoRoot(args[0])
+			if err != nil {
+				return fmt.Errorf("not an evo repository: %s", args[0])
+			}
+			pulled, pushed, err := review.Sync(c.Repo, other)
+			if err != nil {
+				return err
+			}
+			return c.Done(map[string]int{"pulled": pulled, "pushed": pushed}, "Pulled %d and pushed %d review change(s)\n", pulled, pushed)
+		},
+	}
+
+	var trustCmd = &cobra.Command{
+		Use:   "trust <email> [key.pub]",
+		Short: "Trust a reviewer's public key (your signing key by default) for approvals",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo review trust <email> [key.pub]")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			var pub []byte
+			if len(args) == 2 {
+				if pub, err = os.ReadFile(args[1]); err != nil {
+					return fmt.Errorf("failed to read public key: %w", err)
+				}
+			} else {
+				kp, err := signing.LoadKeyPair(c.Repo)
+				if err != nil {
+					return err
+				}
+				pub = kp.PublicKey
+			}
+			if err := review.Trust(c.Repo, args[0], pub); err != nil {
+				return err
+			}
+			return c.Done(map[string]string{"email": args[0], "publicKey": hex.EncodeToString(pub)}, "Trusted %s's key for review approvals\n", args[0])
+		},
+	}
+
+	reviewCmd.AddCommand(openCmd, listCmd, showCmd, commentCmd, approveCmd, mergeCmd, closeCmd, syncCmd, trustCmd)
+	rootCmd.AddCommand(reviewCmd)
+}
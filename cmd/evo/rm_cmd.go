@@ -0,0 +1,68 @@
+package main
+
+import (
+	"evo/internal/repo"
+	"evo/internal/worktree"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// attachedStream returns the current stream, refusing to change files of a
+// detached working tree
+func attachedStream(rp string) (string, error) {
+	head, err := repo.ReadHead(rp)
+	if err != nil {
+		return "", err
+	}
+	if head.Detached != "" {
+		return "", fmt.Errorf("HEAD is detached at %s; run 'evo checkout %s' first", head.Detached, head.Stream)
+	}
+	return head.Stream, nil
+}
+
+func init() {
+	var opts worktree.RemoveOptions
+
+	var rmCmd = &cobra.Command{
+		Use:   "rm <path>... [--cached] [-r] [-f]",
+		Short: "Remove tracked files and record their deletion",
+		Long: `Stops tracking the files at each path, records the deletion of their content
+as ops for the next commit, and deletes them from the working tree. With
+--cached the files stay on disk as untracked files, left out of the index
+until they are deleted.
+
+Directories need -r. Files with uncommitted changes are refused unless -f
+is given.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			stream, err := attachedStream(c.Repo)
+			if err != nil {
+				return err
+			}
+			paths := make([]string, len(args))
+			for i, a := range args {
+				if paths[i], err = pathOrRoot(c.Repo, a); err != nil {
+					return err
+				}
+			}
+			removed, err := worktree.Remove(c.Repo, stream, paths, opts)
+			if err != nil {
+				return err
+			}
+			return c.Emit(map[string]any{"removed": removed, "cached": opts.Cached}, func() {
+				for _, p := range removed {
+					c.Printf("rm %s\n", p)
+				}
+			})
+		},
+	}
+	rmCmd.Flags().BoolVar(&opts.Cached, "cached", false, "Keep the files in the working tree, untracked")
+	rmCmd.Flags().BoolVarP(&opts.Recursive, "recursive", "r", false, "Remove directories")
+	rmCmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "Remove files with uncommitted changes")
+	rootCmd.AddCommand(rmCmd)
+}
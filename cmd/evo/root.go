@@ -1,23 +1,141 @@
 package main
 
 import (
+	"encoding/json"
+	"evo/internal/everrors"
+	"evo/internal/log"
+	"evo/internal/profile"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	verbosity  int
+	logFormat  string
+	profileDir string
+
+	// session is the profile --profile is recording
+	session *profile.Session
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "evo",
 	Short: "Evo (🌿) - next-generation CRDT-based version control",
 	Long: `Evo is a production-ready version control system that uses named streams,
-line-based CRDT (with RGA for reordering), stable file IDs, commit signing, and large file support.`,
+line-based CRDT (with RGA for reordering), stable file IDs, commit signing, and large file support.
+
+Set EVO_TRACE=1 (or a comma separated list of subsystems such as commits,ops,lfs)
+to trace what evo does.
+
+--profile <dir> writes a CPU profile, a heap profile and the time spent walking
+the working tree, hashing, applying ops and reading and writing files to
+<dir>, to attach to a performance bug report. Nothing leaves the machine.
+
+Exit status is 0 on success and 1 for most errors. Errors scripts may want
+to tell apart have codes of their own:
+
+  2  invalid usage: an unknown flag or the wrong number of arguments
+  3  not in an evo repository
+  4  no such stream, commit or revision
+  5  the stream to create already exists
+  6  something is in the way: uncommitted changes, a lock someone else
+     holds, maintenance in progress
+  7  corrupt data: fsck found problems, content doesn't match its hash
+130  interrupted: commit, merge, clone and maintenance runs stop at Ctrl-C
+     and take back what they wrote
+
+With --json, errors are printed to stderr as {"error": "...", "code": "..."},
+the code being usage, not_a_repo, not_found, stream_exists, conflict,
+corrupt, interrupted or error.`,
+	// Execute prints errors itself, as JSON with --json
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if globalFlags.json || globalFlags.quiet {
+			cmd.SilenceUsage = true
+		}
+		format, ok := log.ParseFormat(logFormat)
+		if !ok {
+			return fmt.Errorf("invalid --log-format %q (expected text or json)", logFormat)
+		}
+		level := slog.LevelWarn
+		switch {
+		case globalFlags.quiet:
+			level = slog.LevelError
+		case verbosity >= 2:
+			level = slog.LevelDebug
+		case verbosity == 1:
+			level = slog.LevelInfo
+		}
+		log.Setup(log.Options{Level: level, Format: format, Trace: os.Getenv("EVO_TRACE")})
+		if profileDir != "" {
+			s, err := profile.Start(profileDir, cmd.CommandPath())
+			if err != nil {
+				return fmt.Errorf("failed to start profiling: %w", err)
+			}
+			session = s
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Log more (-v for info, -vv for debug)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Log output format: text or json")
+	rootCmd.PersistentFlags().StringVarP(&globalFlags.repo, "repo", "C", "", "Path to the repository (default: search upward from the working directory)")
+	rootCmd.PersistentFlags().BoolVarP(&globalFlags.quiet, "quiet", "q", false, "Only print errors and requested data")
+	rootCmd.PersistentFlags().BoolVar(&globalFlags.noColor, "no-color", false, "Disable colored output (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().BoolVar(&globalFlags.json, "json", false, "Print machine-readable JSON output")
+	rootCmd.PersistentFlags().StringVar(&profileDir, "profile", "", "Write CPU and heap profiles and a timing breakdown of the command to this directory")
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return everrors.Errorf(everrors.ErrUsage, "%w", err)
+	})
+}
+
+// markUsage makes the errors of argument checks everrors.ErrUsage, for cmd
+// and its subcommands
+func markUsage(cmd *cobra.Command) {
+	if check := cmd.Args; check != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := check(cmd, args); err != nil {
+				return everrors.Errorf(everrors.ErrUsage, "%w", err)
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markUsage(sub)
+	}
 }
 
 // Execute runs the CLI
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	markUsage(rootCmd)
+	err := rootCmd.Execute()
+	if session != nil {
+		stopProfile()
+	}
+	if err != nil {
+		if globalFlags.json {
+			json.NewEncoder(os.Stderr).Encode(map[string]string{"error": err.Error(), "code": everrors.Code(err)})
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(everrors.ExitCode(err))
+	}
+}
+
+// stopProfile writes the profile of the command that ran. Failing to is
+// reported but doesn't change the command's outcome.
+func stopProfile() {
+	r, err := session.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to write profile:", err)
+		return
+	}
+	if !globalFlags.quiet && !globalFlags.json {
+		fmt.Fprintf(os.Stderr, "Profile written to %s (%s)\n", session.Dir(), r.Summary())
 	}
 }
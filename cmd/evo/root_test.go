@@ -0,0 +1,35 @@
+package main
+
+import (
+	"evo/internal/everrors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var markOnce = sync.OnceFunc(func() { markUsage(rootCmd) })
+
+// run runs the CLI with args as Execute does, returning its error
+func run(t *testing.T, args ...string) error {
+	t.Helper()
+	markOnce()
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	t.Cleanup(func() { rootCmd.SetArgs(nil) })
+	return rootCmd.Execute()
+}
+
+func TestUsageExitCode(t *testing.T) {
+	// arguments a command checks itself are usage errors, as cobra's are
+	err := run(t, "-C", t.TempDir(), "stream", "merge", "feature")
+	assert.ErrorIs(t, err, everrors.ErrUsage)
+	assert.ErrorContains(t, err, "usage: evo stream merge <source> <target>")
+	assert.Equal(t, everrors.ExitUsage, everrors.ExitCode(err))
+	assert.Equal(t, "usage", everrors.Code(err))
+
+	err = run(t, "-C", t.TempDir(), "status", "--no-such-flag")
+	assert.Equal(t, everrors.ExitUsage, everrors.ExitCode(err))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"evo/internal/mirror"
+	"evo/internal/remotes"
+	"evo/internal/server"
+	"evo/internal/transfer"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var addr, mirrorOf string
+	var mirrorInterval time.Duration
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve this repository over HTTP",
+		Long: `Serves the repository until interrupted. Browsing http://<addr>/ shows a
+read-only web UI of streams, commits, diffs, files at any commit and blame.
+Clients push a stream's history with POST /push/<stream>; the server applies
+the commits it lacks. Tools can use the JSON API under /api/v1 (see DESIGN.md).
+
+Every push is checked against the receive policy of its stream first:
+  receive.protected          reject all pushes (set it in [stream.<name>])
+  receive.allowReviewedMerges
+                             let API merges of reviews approved by trusted
+                             reviewers into a protected stream
+  receive.requireSignatures  every commit needs a signature by a key in
+                             receive.trustedKeys (a directory of .pub files)
+  receive.maxCommitSize      largest accepted commit (0 disables)
+  .evo/hooks/pre-receive     and receive.hook: executables that get the new
+                             commits as JSON lines on stdin and EVO_STREAM in
+                             the environment; a non-zero exit refuses the
+                             push with the hook's stderr as the reason
+
+Pushes and API merges are posted to every webhook.<name>.url, signed with
+webhook.<name>.secret and retried with backoff when the receiver fails.
+
+--mirror-of replicates another server into the repository while serving it,
+every --mirror-interval: the content of its large files and the commits of
+its streams, merged as 'evo pull' merges them. Pushes to the mirror are
+merged with what it replicates but don't reach the other server.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			srv := server.New(c.Repo)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var mirrored sync.WaitGroup
+			if mirrorOf != "" {
+				if mirrorInterval <= 0 {
+					return fmt.Errorf("--mirror-interval must be positive")
+				}
+				origin, err := mirrorRemote(c.Repo, mirrorOf)
+				if err != nil {
+					return err
+				}
+				opts, err := transfer.LoadOptions(c.Repo)
+				if err != nil {
+					return err
+				}
+				mirrored.Add(1)
+				go func() {
+					defer mirrored.Done()
+					mirror.Run(ctx, c.Repo, origin, mirrorInterval, &mirror.Options{Transfer: opts, Lock: srv})
+				}()
+				c.Infof("Mirroring %s every %s\n", origin.URL, mirrorInterval)
+			}
+			hs := &http.Server{Addr: addr, Handler: srv}
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sig
+				hs.Shutdown(context.Background())
+			}()
+			c.Infof("Serving %s on http://%s\n", c.Repo, addr)
+			if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			cancel()
+			mirrored.Wait()
+			// let webhook deliveries of the last pushes finish
+			srv.Wait()
+			return nil
+		},
+	}
+	serveCmd.Flags().StringVar(&addr, "addr", "127.0.0.1:7850", "Address to listen on")
+	serveCmd.Flags().StringVar(&mirrorOf, "mirror-of", "", "Replicate the server at this URL, or this remote, into the repository")
+	serveCmd.Flags().DurationVar(&mirrorInterval, "mirror-interval", time.Minute, "How often to replicate with --mirror-of")
+	serveCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the --mirror-of server")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// mirrorRemote returns the remote --mirror-of names, or one for the URL it
+// gives
+func mirrorRemote(rp, spec string) (*remotes.Remote, error) {
+	if !strings.Contains(spec, "://") {
+		return getRemote(rp, spec)
+	}
+	return &remotes.Remote{Name: "mirror", URL: strings.TrimSuffix(spec, "/"), Insecure: insecureRemote}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"evo/internal/config"
+	"evo/internal/history"
+	"evo/internal/notes"
+	"evo/internal/revparse"
+
+	"github.com/spf13/cobra"
+)
+
+// commitNotes returns the notes of a commit in every namespace
+func commitNotes(rp, id string) ([]notes.Note, error) {
+	nss, err := notes.Namespaces(rp)
+	if err != nil {
+		return nil, err
+	}
+	var out []notes.Note
+	for _, ns := range nss {
+		list, err := notes.List(rp, ns, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list...)
+	}
+	return out, nil
+}
+
+func init() {
+	var showNotes bool
+	var showCmd = &cobra.Command{
+		Use:   "show [commit-ish]",
+		Short: "Show a commit's metadata, trailers and line changes",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return commitCandidates(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			spec := "HEAD"
+			if len(args) > 0 {
+				spec = args[0]
+			}
+			cm, _, err := revparse.Resolve(rp, spec)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(rp)
+			if err != nil {
+				return err
+			}
+			doVerify, err := cfg.Bool("verifySignatures")
+			if err != nil {
+				return err
+			}
+			var annotations []notes.Note
+			if showNotes {
+				if annotations, err = commitNotes(rp, cm.ID); err != nil {
+					return err
+				}
+			}
+			if c.JSON {
+				return c.Emit(struct {
+					logEntry
+					Notes []notes.Note `json:"notes,omitempty"`
+				}{newLogEntry(rp, cm, doVerify), annotations}, nil)
+			}
+			printCommit(c, rp, cm, doVerify)
+			printNotes(c, annotations)
+
+			changes, err := history.Changes(rp, cm)
+			if err != nil {
+				return err
+			}
+			for _, fc := range changes {
+				c.Printf("\n%s\n", fc.Path)
+				for _, ch := range fc.Changes {
+					color := colorGreen
+					if ch.Kind == "-" {
+						color = colorRed
+					}
+					c.Printf("%s\n", c.Color(color, ch.Kind+" "+ch.Content))
+				}
+			}
+			return nil
+		},
+	}
+	showCmd.Flags().BoolVar(&showNotes, "notes", true, "Show notes attached to the commit")
+	rootCmd.AddCommand(showCmd)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"evo/internal/stats"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var top int
+	var sizerCmd = &cobra.Command{
+		Use:   "sizer",
+		Short: "Find what takes space in the repository",
+		Long: `Breaks down what the repository takes on disk: each area of .evo, the
+files whose history takes the most, counting their op logs in every stream
+and their shared op store, the largest large files, the streams whose op
+logs and commits take the most, and the share of lines that are deleted.
+
+It then suggests how to reclaim space: storing files with a big history as
+large files, running maintenance to compact logs that are mostly deleted
+lines, and purging big files that are no longer tracked.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := stats.Size(c.Repo)
+			if err != nil {
+				return fmt.Errorf("failed to measure repository: %w", err)
+			}
+			return c.Emit(r, func() {
+				c.Printf("%s", r.Format(top))
+			})
+		},
+	}
+	sizerCmd.Flags().IntVar(&top, "top", 10, "Number of entries to list of each kind (0 for all)")
+	rootCmd.AddCommand(sizerCmd)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"evo/internal/stats"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var top int
+	var statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show repository metrics",
+		Long: `Shows commit counts per stream and author, op counts per file, large file
+storage with its deduplication ratio, and what the last maintenance run saved.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			r, err := stats.Compute(rp)
+			if err != nil {
+				return fmt.Errorf("failed to compute stats: %w", err)
+			}
+			return c.Emit(r, func() {
+				c.Printf("%s", r.Format(top))
+			})
+		},
+	}
+	statsCmd.Flags().IntVar(&top, "top", 10, "Number of files to list (0 for all)")
+	rootCmd.AddCommand(statsCmd)
+}
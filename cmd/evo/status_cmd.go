@@ -1,7 +1,6 @@
 package main
 
 import (
-	"evo/internal/repo"
 	"evo/internal/status"
 	"fmt"
 
@@ -9,29 +8,55 @@ import (
 )
 
 func init() {
+	var verify bool
 	var statusCmd = &cobra.Command{
-		Use:   "status",
+		Use:   "status [--verify]",
 		Short: "Show the working tree status",
 		Long: `Shows the status of files in the working directory:
 - New (untracked) files
 - Modified files
 - Deleted files
 - Renamed files
-Respects .evo-ignore patterns for excluding files.`,
+Respects .evo-ignore patterns for excluding files.
+
+With --verify, re-reads every tracked file and compares it with the index,
+the hashes ingest remembers and the stream's op log as materialized, then
+checks the status above against the result. Files differing from their op
+log are listed as modified; anything else is reported as a divergence and
+makes the command fail.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
 
-			st, err := status.GetStatus(rp)
+			if verify {
+				v, err := status.Verify(c.Repo)
+				if err != nil {
+					return fmt.Errorf("failed to verify working tree: %w", err)
+				}
+				err = c.Emit(v, func() {
+					c.Printf("%s", status.FormatVerification(v))
+				})
+				if err != nil {
+					return err
+				}
+				if len(v.Divergences) > 0 {
+					return fmt.Errorf("found %d divergences", len(v.Divergences))
+				}
+				return nil
+			}
+
+			st, err := status.GetStatus(c.Repo)
 			if err != nil {
 				return fmt.Errorf("failed to get status: %w", err)
 			}
 
-			fmt.Print(status.FormatStatus(st))
-			return nil
+			return c.Emit(st, func() {
+				c.Printf("%s", status.FormatStatus(st))
+			})
 		},
 	}
+	statusCmd.Flags().BoolVar(&verify, "verify", false, "Check the working tree against the index, file hashes and op logs")
 	rootCmd.AddCommand(statusCmd)
 }
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"evo/internal/checkout"
+	"evo/internal/everrors"
+	"evo/internal/exchange"
+	"evo/internal/index"
+	"evo/internal/journal"
+	"evo/internal/merge"
 	"evo/internal/repo"
+	"evo/internal/revparse"
 	"evo/internal/streams"
+	"evo/internal/tracking"
 	"fmt"
+	"slices"
 
 	"github.com/spf13/cobra"
 )
 
+var mergeStrategy string
+
 func init() {
 	var streamCmd = &cobra.Command{
 		Use:   "stream",
@@ -15,41 +28,76 @@ func init() {
 		Long:  "Create, switch, list, merge, or cherry-pick commits in named streams.",
 	}
 
+	var createFrom string
 	var createCmd = &cobra.Command{
 		Use:   "create <name>",
 		Short: "Create a new stream",
+		Long: `Creates an empty stream or, with --from, one holding the history of another
+stream, or of a commit's stream up to and including that commit, so it starts
+from its content. The origin is recorded and shown by 'evo stream list'.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("usage: evo stream create <name>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo stream create <name>")
 			}
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			if err := streams.CreateStream(rp, args[0]); err != nil {
+			rp := c.Repo
+			if createFrom == "" {
+				if err := streams.CreateStream(rp, args[0]); err != nil {
+					return err
+				}
+				return c.Done(map[string]string{"created": args[0]}, "Created stream: %s\n", args[0])
+			}
+			// a stream name takes all of its history, even when it has none
+			source, commitID := createFrom, ""
+			if names, err := streams.ListStreams(rp); err != nil {
+				return err
+			} else if !slices.Contains(names, createFrom) {
+				from, found, err := revparse.Resolve(rp, createFrom)
+				if err != nil {
+					return err
+				}
+				source, commitID = found, from.ID
+			}
+			if err := streams.CreateStreamFrom(rp, args[0], source, commitID); err != nil {
 				return err
 			}
-			fmt.Println("Created stream:", args[0])
-			return nil
+			origin, err := streams.StreamOrigin(rp, args[0])
+			if err != nil {
+				return err
+			}
+			if origin.Commit == "" {
+				return c.Done(map[string]any{"created": args[0], "origin": origin}, "Created stream: %s from %s (no commits)\n", args[0], source)
+			}
+			return c.Done(map[string]any{"created": args[0], "origin": origin}, "Created stream: %s from %s of %s\n", args[0], origin.Commit, source)
 		},
 	}
+	createCmd.Flags().StringVar(&createFrom, "from", "", "Stream or commit whose history the new stream starts with")
 
 	var switchCmd = &cobra.Command{
 		Use:   "switch <name>",
 		Short: "Switch to another stream locally",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeStreams(cmd, args, toComplete)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("usage: evo stream switch <name>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo stream switch <name>")
 			}
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
+			rp := c.Repo
 			if err := streams.SwitchStream(rp, args[0]); err != nil {
 				return err
 			}
-			fmt.Println("Switched to stream:", args[0])
-			return nil
+			return c.Done(map[string]string{"stream": args[0]}, "Switched to stream: %s\n", args[0])
 		},
 	}
 
@@ -57,64 +105,269 @@ func init() {
 		Use:   "list",
 		Short: "List named streams",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
+			rp := c.Repo
 			ss, err := streams.ListStreams(rp)
 			if err != nil {
 				return err
 			}
 			cur, _ := streams.CurrentStream(rp)
+			type entry struct {
+				Name    string          `json:"name"`
+				Current bool            `json:"current"`
+				Origin  *streams.Origin `json:"origin,omitempty"`
+			}
+			out := []entry{}
 			for _, s := range ss {
-				prefix := "  "
-				if s == cur {
-					prefix = "* "
+				origin, err := streams.StreamOrigin(rp, s)
+				if err != nil {
+					return err
 				}
-				fmt.Println(prefix + s)
+				out = append(out, entry{s, s == cur, origin})
 			}
-			return nil
+			return c.Emit(out, func() {
+				// only used to abbreviate origin commits
+				r, _ := revparse.New(rp)
+				for _, e := range out {
+					line := "  " + e.Name
+					if e.Current {
+						line = "* " + c.Color(colorGreen, e.Name)
+					}
+					if e.Origin != nil && e.Origin.Commit != "" {
+						id := e.Origin.Commit
+						if r != nil {
+							id = r.Abbrev(id)
+						}
+						line += fmt.Sprintf(" (from %s at %s)", e.Origin.Stream, id)
+					} else if e.Origin != nil {
+						line += fmt.Sprintf(" (from %s)", e.Origin.Stream)
+					}
+					c.Printf("%s\n", line)
+				}
+			})
 		},
 	}
 
 	var mergeCmd = &cobra.Command{
 		Use:   "merge <source> <target>",
 		Short: "Merge all commits from source stream into target stream",
+		Long: `Merge all commits from source stream into target stream.
+
+Lines edited in both streams are resolved with --strategy (crdt, ours, theirs
+or union). Paths can override the strategy in .evo-attributes, e.g.
+"CHANGELOG.md merge=union", or name a custom driver configured with
+merge.<name>.driver. Files tracked by character or word ("*.md crdt=word")
+only conflict when the same fragment is edited on both sides.
+
+Merging into the current stream writes the files the merge changes into the
+working tree, unless it has uncommitted changes.`,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeStreams(cmd, args, toComplete)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 2 {
-				return fmt.Errorf("usage: evo stream merge <source> <target>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo stream merge <source> <target>")
 			}
-			rp, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			if err := streams.MergeStreams(rp, args[0], args[1]); err != nil {
+			rp := c.Repo
+			strategy, err := merge.ParseStrategy(mergeStrategy)
+			if err != nil {
 				return err
 			}
-			fmt.Printf("Merged all missing commits from '%s' into '%s'\n", args[0], args[1])
-			return nil
+			desc := fmt.Sprintf("merge %s into %s", args[0], args[1])
+			ctx, stop := interruptible()
+			defer stop()
+			return journaled(rp, "merge", desc, func(rec *journal.Recorder) error {
+				if err := mergeInto(ctx, c, rec, args[0], args[1], strategy); err != nil {
+					return err
+				}
+				return c.Done(map[string]string{"source": args[0], "target": args[1]}, "Merged all missing commits from '%s' into '%s'\n", args[0], args[1])
+			})
 		},
 	}
 
 	var cherryPickCmd = &cobra.Command{
-		Use:   "cherry-pick <commit-id> <target-stream>",
+		Use:   "cherry-pick <commit-ish> <target-stream>",
 		Short: "Replicate only one commit's ops into the target stream",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			switch len(args) {
+			case 0:
+				return commitCandidates(toComplete), cobra.ShellCompDirectiveNoFileComp
+			case 1:
+				return completeStreams(cmd, args, toComplete)
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 2 {
-				return fmt.Errorf("usage: evo stream cherry-pick <commit-id> <target-stream>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo stream cherry-pick <commit-ish> <target-stream>")
+			}
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			rev, err := revparse.New(rp)
+			if err != nil {
+				return err
+			}
+			if args[0], err = rev.ResolveID(args[0]); err != nil {
+				return err
 			}
-			rp, err := repo.FindRepoRoot(".")
+			desc := fmt.Sprintf("cherry-pick %s into %s", args[0], args[1])
+			return journaled(rp, "cherry-pick", desc, func(rec *journal.Recorder) error {
+				if err := streams.CherryPick(rp, args[0], args[1]); err != nil {
+					if errors.Is(err, streams.ErrAlreadyPicked) {
+						return c.Done(map[string]any{"commit": args[0], "target": args[1], "picked": false}, "Commit %s is already in stream %s, skipping\n", args[0], args[1])
+					}
+					return err
+				}
+				return c.Done(map[string]any{"commit": args[0], "target": args[1], "picked": true}, "Cherry-picked commit %s into stream %s\n", args[0], args[1])
+			})
+		},
+	}
+
+	var fetch bool
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show how far each stream is ahead of and behind its upstream",
+		Long: `Lists every stream with the number of commits it has that its upstream lacks
+(↑) and the number it lacks (↓). The upstream is the upstream key of the
+stream's [stream.<name>] config section: another stream, or <remote>/<stream>
+for a stream of a remote as push and pull last saw it. --fetch asks the
+remotes for their streams first. Counts are cached until a stream moves.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			if err := streams.CherryPick(rp, args[0], args[1]); err != nil {
+			rp := c.Repo
+			if fetch {
+				if err := fetchUpstreams(c); err != nil {
+					return err
+				}
+			}
+			sts, err := tracking.All(rp)
+			if err != nil {
 				return err
 			}
-			fmt.Printf("Cherry-picked commit %s into stream %s\n", args[0], args[1])
-			return nil
+			cur, _ := streams.CurrentStream(rp)
+			return c.Emit(sts, func() {
+				width, upWidth := 0, 0
+				for _, st := range sts {
+					width = max(width, len(st.Stream))
+					upWidth = max(upWidth, len(st.Upstream))
+				}
+				for _, st := range sts {
+					mark, name := "  ", fmt.Sprintf("%-*s", width, st.Stream)
+					if st.Stream == cur {
+						mark, name = "* ", c.Color(colorGreen, name)
+					}
+					up := fmt.Sprintf("%-*s", upWidth, st.Upstream)
+					switch {
+					case st.Upstream == "":
+						c.Printf("%s%s  (no upstream)\n", mark, name)
+					case st.Error != "":
+						c.Printf("%s%s  %s  %s\n", mark, name, up, c.Color(colorRed, st.Error))
+					case st.Ahead == 0 && st.Behind == 0:
+						c.Printf("%s%s  %s  up to date\n", mark, name, up)
+					default:
+						c.Printf("%s%s  %s  ↑%d ↓%d\n", mark, name, up, st.Ahead, st.Behind)
+					}
+				}
+			})
 		},
 	}
+	statusCmd.Flags().BoolVar(&fetch, "fetch", false, "Fetch the streams of remote upstreams first")
+	statusCmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
 
-	streamCmd.AddCommand(createCmd, switchCmd, listCmd, mergeCmd, cherryPickCmd)
+	mergeCmd.Flags().StringVarP(&mergeStrategy, "strategy", "s", "crdt", "Conflict strategy: crdt, ours, theirs or union")
+
+	streamCmd.AddCommand(createCmd, switchCmd, listCmd, mergeCmd, cherryPickCmd, statusCmd)
 	rootCmd.AddCommand(streamCmd)
 }
+
+// fetchUpstreams records the streams of the remotes that streams track,
+// warning about those that can't be fetched
+func fetchUpstreams(c *cmdContext) error {
+	names, err := streams.ListStreams(c.Repo)
+	if err != nil {
+		return err
+	}
+	fetched := make(map[string]bool)
+	for _, name := range names {
+		up, err := tracking.Upstream(c.Repo, name)
+		if err != nil {
+			return err
+		}
+		remote, stream, ok := tracking.SplitRemote(up)
+		if !ok || fetched[up] {
+			continue
+		}
+		fetched[up] = true
+		r, err := requireRemote(c.Repo, remote)
+		if err == nil {
+			_, err = exchange.Fetch(context.Background(), c.Repo, r, stream)
+		}
+		if err != nil {
+			c.Warnf("failed to fetch %s: %v\n", up, err)
+		}
+	}
+	return nil
+}
+
+// mergeInto merges source into target for a journaled command. A merge into
+// the current stream writes the files it changes into the working tree, as
+// pull --apply does, so the tree keeps matching the stream; a tree with
+// uncommitted changes is left as it is, with a warning.
+func mergeInto(ctx context.Context, c *cmdContext, rec *journal.Recorder, source, target string, strategy merge.Strategy) error {
+	cur, err := streams.CurrentStream(c.Repo)
+	if err != nil {
+		return err
+	}
+	refresh := false
+	if target == cur && !repo.IsBare(c.Repo) {
+		err := checkout.CanRefresh(c.Repo, cur)
+		if errors.Is(err, checkout.ErrDirty) {
+			c.Warnf("the working tree has uncommitted changes, so the files the merge changes aren't written to it\n")
+		}
+		refresh = err == nil
+	}
+	merged, err := streams.MergeReport(ctx, c.Repo, source, target, strategy)
+	if err != nil || !refresh || len(merged.Files) == 0 {
+		return err
+	}
+	_, id2path, err := index.LoadIndex(c.Repo)
+	if err != nil {
+		return err
+	}
+	for _, fid := range merged.Files {
+		if p, ok := id2path[fid]; ok {
+			if err := rec.TrackFile(p); err != nil {
+				return err
+			}
+		}
+	}
+	tree, err := checkout.Refresh(c.Repo, cur, merged.Files)
+	if err != nil {
+		return err
+	}
+	c.Infof("Updated %d file(s), removed %d\n", len(tree.Written), len(tree.Removed))
+	for _, path := range tree.Skipped {
+		c.Warnf("%s: large file content isn't stored; run 'evo transfer pull'\n", path)
+	}
+	for _, fid := range tree.Unnamed {
+		c.Warnf("file %s: no path is known for it, or another file holds its path; not written\n", fid)
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"evo/internal/repo"
-	"fmt"
+	"evo/internal/everrors"
+	"evo/internal/log"
 
 	"github.com/spf13/cobra"
 )
@@ -15,15 +15,16 @@ func init() {
 to the remote. Requires a future Evo server implementation for full functionality.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("usage: evo sync <remote-url>")
+				return everrors.Errorf(everrors.ErrUsage, "usage: evo sync <remote-url>")
 			}
 			remote := args[0]
-			_, err := repo.FindRepoRoot(".")
+			c, err := newContext()
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Sync with %s is not yet implemented.\n", remote)
-			return nil
+			rp := c.Repo
+			log.For("sync").Debug("sync requested", "repo", rp, "remote", remote)
+			return c.Done(map[string]any{"remote": remote, "synced": false}, "Sync with %s is not yet implemented.\n", remote)
 		},
 	}
 	rootCmd.AddCommand(syncCmd)
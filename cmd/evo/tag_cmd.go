@@ -0,0 +1,63 @@
+package main
+
+import (
+	"evo/internal/revparse"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var tagDelete bool
+	var tagCmd = &cobra.Command{
+		Use:   "tag [name [commit-ish]]",
+		Short: "List, create or delete tags",
+		Long: `Without arguments, lists tags. With a name, points the tag at the given
+commit (HEAD by default), replacing any tag of that name. With -d, deletes it.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				tags, err := revparse.Tags(c.Repo)
+				if err != nil {
+					return err
+				}
+				names := make([]string, 0, len(tags))
+				for n := range tags {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				return c.Emit(tags, func() {
+					for _, n := range names {
+						c.Printf("%s\n", n)
+					}
+				})
+			}
+			name := args[0]
+			if tagDelete {
+				if err := revparse.DeleteTag(c.Repo, name); err != nil {
+					return fmt.Errorf("failed to delete tag %s: %w", name, err)
+				}
+				return c.Done(map[string]string{"deleted": name}, "Deleted tag %s\n", name)
+			}
+			spec := "HEAD"
+			if len(args) > 1 {
+				spec = args[1]
+			}
+			commit, _, err := revparse.Resolve(c.Repo, spec)
+			if err != nil {
+				return err
+			}
+			if err := revparse.WriteTag(c.Repo, name, commit.ID); err != nil {
+				return fmt.Errorf("failed to write tag %s: %w", name, err)
+			}
+			return c.Done(map[string]string{"tag": name, "commit": commit.ID}, "Tagged %s as %s\n", commit.ID, name)
+		},
+	}
+	tagCmd.Flags().BoolVarP(&tagDelete, "delete", "d", false, "Delete the tag")
+	rootCmd.AddCommand(tagCmd)
+}
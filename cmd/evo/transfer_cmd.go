@@ -0,0 +1,196 @@
+package main
+
+import (
+	"evo/internal/config"
+	"evo/internal/lfs"
+	"evo/internal/remotes"
+	"evo/internal/transfer"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// transferOptions returns the configured transfer options with the flags
+// given on the command line applied
+func transferOptions(cmd *cobra.Command, rp string, jobs int, limitRate string) (*transfer.Options, error) {
+	opts, err := transfer.LoadOptions(rp)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Flags().Changed("jobs") {
+		if jobs < 1 {
+			return nil, fmt.Errorf("--jobs must be at least 1")
+		}
+		opts.Jobs = jobs
+	}
+	if cmd.Flags().Changed("limit-rate") {
+		rate, err := config.ParseSize(limitRate)
+		if err != nil {
+			return nil, fmt.Errorf("--limit-rate: %w", err)
+		}
+		opts.Limiter = transfer.NewLimiter(rate)
+	}
+	return opts, nil
+}
+
+func init() {
+	var remoteName, limitRate string
+	var jobs int
+
+	var transferCmd = &cobra.Command{
+		Use:   "transfer",
+		Short: "Move large files between the LFS store and a remote",
+		Long: `Pushes and pulls large files chunk by chunk. A transfer that is cut off is
+recorded with the chunks it has moved, and 'evo transfer resume' continues it
+with the chunks still missing. Chunks move --jobs at a time, at no more
+than --limit-rate bytes per second altogether.`,
+	}
+
+	var pushCmd = &cobra.Command{
+		Use:   "push [<file-id>...]",
+		Short: "Send large files to a remote (default: every stored file)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := requireRemote(c.Repo, remoteName)
+			if err != nil {
+				return err
+			}
+			opts, err := transferOptions(cmd, c.Repo, jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				infos, err := lfs.NewStore(c.Repo).Files()
+				if err != nil {
+					return err
+				}
+				for _, info := range infos {
+					args = append(args, info.ID)
+				}
+			}
+			var done []*transfer.State
+			for _, id := range args {
+				st, err := transfer.PushFile(c.Repo, r, id, opts)
+				if err != nil {
+					return fmt.Errorf("%w (run 'evo transfer resume' to continue)", err)
+				}
+				done = append(done, st)
+				c.Infof("Pushed %s (%d bytes) to %s\n", id, st.Size, r.Name)
+			}
+			return c.Emit(done, func() {})
+		},
+	}
+
+	var pullCmd = &cobra.Command{
+		Use:   "pull [<file-id>...]",
+		Short: "Fetch large files from a remote (default: every file not stored here)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			r, err := requireRemote(c.Repo, remoteName)
+			if err != nil {
+				return err
+			}
+			opts, err := transferOptions(cmd, c.Repo, jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				var infos []lfs.FileInfo
+				if err := r.Do(http.MethodGet, "/api/v1/lfs/files", nil, &infos); err != nil {
+					return err
+				}
+				store := lfs.NewStore(c.Repo)
+				for _, info := range infos {
+					if have, err := store.Info(info.ID); err != nil || have.ContentHash != info.ContentHash {
+						args = append(args, info.ID)
+					}
+				}
+			}
+			var done []*transfer.State
+			for _, id := range args {
+				st, err := transfer.PullFile(c.Repo, r, id, opts)
+				if err != nil {
+					return fmt.Errorf("%w (run 'evo transfer resume' to continue)", err)
+				}
+				done = append(done, st)
+				c.Infof("Pulled %s (%d bytes) from %s\n", id, st.Size, r.Name)
+			}
+			return c.Emit(done, func() {})
+		},
+	}
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List transfers that haven't completed",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			pending, err := transfer.List(c.Repo)
+			if err != nil {
+				return err
+			}
+			if pending == nil {
+				pending = []transfer.State{}
+			}
+			return c.Emit(pending, func() {
+				if len(pending) == 0 {
+					c.Infof("No transfers in progress\n")
+					return
+				}
+				for _, st := range pending {
+					c.Printf("%-4s %s %s  %d/%d chunks  %d bytes  since %s\n", st.Direction, st.Remote, st.ID,
+						st.Done, st.Chunks, st.Size, st.Started.Local().Format("2006-01-02 15:04"))
+				}
+			})
+		},
+	}
+
+	var resumeCmd = &cobra.Command{
+		Use:   "resume",
+		Short: "Continue the transfers that were cut off",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			opts, err := transferOptions(cmd, c.Repo, jobs, limitRate)
+			if err != nil {
+				return err
+			}
+			done, err := transfer.Resume(c.Repo, opts, func(name string) (*remotes.Remote, error) {
+				return getRemote(c.Repo, name)
+			})
+			for _, st := range done {
+				c.Infof("Completed %s of %s with %s\n", st.Direction, st.ID, st.Remote)
+			}
+			if err != nil {
+				return err
+			}
+			if done == nil {
+				done = []transfer.State{}
+			}
+			return c.Done(done, "%d transfers completed\n", len(done))
+		},
+	}
+
+	pushCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to push to (default: origin or the only remote)")
+	pullCmd.Flags().StringVar(&remoteName, "remote", "", "Remote to pull from (default: origin or the only remote)")
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, resumeCmd} {
+		cmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "Chunks to move at once (default: transfer.jobs)")
+		cmd.Flags().BoolVar(&insecureRemote, "insecure", false, "Don't verify the TLS certificate of the remote")
+		cmd.Flags().StringVar(&limitRate, "limit-rate", "", "Bytes per second to limit the transfer to, e.g. 500k (default: transfer.limitRate)")
+	}
+	transferCmd.AddCommand(pushCmd, pullCmd, listCmd, resumeCmd)
+	rootCmd.AddCommand(transferCmd)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"evo/internal/index"
+	"evo/internal/journal"
+	"evo/internal/types"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var undoList bool
+
+// journaled runs a repo-mutating command and records it so `evo undo` can
+// reverse it
+func journaled(rp, kind, desc string, fn func(rec *journal.Recorder) error) error {
+	rec, err := journal.Begin(rp, kind, desc)
+	if err != nil {
+		return fmt.Errorf("failed to start journal entry: %w", err)
+	}
+	if err := fn(rec); err != nil {
+		// record partial effects so they can still be undone
+		rec.Finish()
+		return err
+	}
+	return rec.Finish()
+}
+
+// trackOpFiles records the working tree files touched by ops before they are applied
+func trackOpFiles(rec *journal.Recorder, rp string, eops []types.ExtendedOp) error {
+	_, id2path, err := index.LoadIndex(rp)
+	if err != nil {
+		return err
+	}
+	for _, eop := range eops {
+		if p, ok := id2path[eop.Op.FileID.String()]; ok {
+			if err := rec.TrackFile(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	var undoCmd = &cobra.Command{
+		Use:   "undo [n]",
+		Short: "Undo the last n repo-mutating commands (default 1)",
+		Long: `Roll back the most recent commits, merges, cherry-picks and reverts recorded in
+the operation journal. Op logs are truncated to their prior size, commits written
+by the command are removed and HEAD is restored. Use --list to see the journal.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			rp := c.Repo
+			if undoList {
+				entries, err := journal.List(rp)
+				if err != nil {
+					return err
+				}
+				if c.JSON {
+					type entry struct {
+						Kind        string    `json:"kind"`
+						Description string    `json:"description"`
+						Timestamp   time.Time `json:"timestamp"`
+					}
+					out := []entry{}
+					for i := len(entries) - 1; i >= 0; i-- {
+						out = append(out, entry{entries[i].Kind, entries[i].Description, entries[i].Timestamp})
+					}
+					return c.Emit(out, nil)
+				}
+				if len(entries) == 0 {
+					c.Infof("Journal is empty.\n")
+					return nil
+				}
+				for i := len(entries) - 1; i >= 0; i-- {
+					e := entries[i]
+					c.Printf("%d  %-11s %s  %s\n", len(entries)-i, e.Kind, e.Timestamp.Local().Format("2006-01-02 15:04:05"), e.Description)
+				}
+				return nil
+			}
+			n := 1
+			if len(args) > 0 {
+				n, err = strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					return fmt.Errorf("invalid count: %s", args[0])
+				}
+			}
+			undone, err := journal.Undo(rp, n)
+			descs := []string{}
+			for _, e := range undone {
+				descs = append(descs, e.Kind+": "+e.Description)
+				c.Infof("Undid %s: %s\n", e.Kind, e.Description)
+			}
+			if err != nil {
+				return err
+			}
+			return c.Emit(map[string][]string{"undone": descs}, func() {})
+		},
+	}
+	undoCmd.Flags().BoolVar(&undoList, "list", false, "List journaled operations, newest first")
+	rootCmd.AddCommand(undoCmd)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"evo/internal/history"
+	"evo/internal/index"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var whyCmd = &cobra.Command{
+		Use:   "why <file> <line>",
+		Short: "Show every operation that shaped a line",
+		Long: `Follows a line of a file, by its number in the current stream, back through
+the CRDT ops on its line ID: the insert that created it and every update
+since, each with its commit, author, the node that made it and the streams
+it has travelled to. Unlike blame, which names the last commit to
+write a line, this is the line's whole history, across merges and picks.
+
+Line numbers are those of the file as last recorded, which uncommitted
+edits may have shifted.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newContext()
+			if err != nil {
+				return err
+			}
+			line, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid line number %q", args[1])
+			}
+			rel, err := repoRelative(c.Repo, args[0])
+			if err != nil {
+				return err
+			}
+			fid, err := index.LookupFileID(c.Repo, rel)
+			if err != nil {
+				return err
+			}
+			head, err := repo.ReadHead(c.Repo)
+			if err != nil {
+				return err
+			}
+			p, err := history.Why(c.Repo, head.Stream, fid, line)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			rev, err := revparse.NewInStream(c.Repo, head.Stream)
+			if err != nil {
+				return err
+			}
+			return c.Emit(p, func() {
+				c.Printf("%s:%d %s\n", rel, line, p.Content)
+				for _, s := range p.Steps {
+					commit := "(uncommitted)"
+					if s.Commit != "" {
+						commit = rev.Abbrev(s.Commit)
+					}
+					c.Printf("\n%s %s", c.Color(colorYellow, commit), s.Type)
+					switch {
+					case s.Type == "update" && s.OldContent != "":
+						c.Printf(" %q -> %q\n", s.OldContent, s.Content)
+					case s.Content != "":
+						c.Printf(" %q\n", s.Content)
+					default:
+						c.Printf("\n")
+					}
+					if s.Author != "" {
+						c.Printf("    Author:  %s\n", s.Author)
+					}
+					c.Printf("    Date:    %s\n", s.Time.Local())
+					if s.Message != "" {
+						msg, _, _ := strings.Cut(s.Message, "\n")
+						c.Printf("    Message: %s\n", msg)
+					}
+					if s.PickedFrom != "" {
+						c.Printf("    Picked from %s\n", rev.Abbrev(s.PickedFrom))
+					}
+					c.Printf("    Node:    %s\n", s.Node)
+					if len(s.Streams) > 0 {
+						c.Printf("    Streams: %s\n", strings.Join(s.Streams, ", "))
+					}
+				}
+			})
+		},
+	}
+	rootCmd.AddCommand(whyCmd)
+}
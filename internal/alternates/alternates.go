@@ -0,0 +1,111 @@
+// Package alternates manages the repositories a repository borrows from, as
+// listed in .evo/alternates (see repo.Alternates). Adding one checks it is a
+// repository; removing one first copies in what the repository borrows, so
+// nothing it needs goes with the alternate.
+package alternates
+
+import (
+	"evo/internal/commits"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/maintenance"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var logger = log.For("alternates")
+
+// pauseTimeout is how long Remove waits for a maintenance run to finish
+const pauseTimeout = 10 * time.Minute
+
+// dataDir returns the absolute data directory of the repository at path
+func dataDir(path string) (string, error) {
+	return filepath.Abs(repo.Dir(path))
+}
+
+// Add makes the repository at repoPath borrow from the one at other,
+// returning the data directory listed
+func Add(repoPath, other string) (string, error) {
+	if !repo.IsRepo(other) {
+		return "", fmt.Errorf("%s is not an Evo repository", other)
+	}
+	dir, err := dataDir(other)
+	if err != nil {
+		return "", err
+	}
+	own, err := dataDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if dir == own {
+		return "", fmt.Errorf("a repository can't borrow from itself")
+	}
+	alts, err := repo.Alternates(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(alts, dir) {
+		return dir, nil
+	}
+	if err := repo.SetAlternates(repoPath, append(alts, dir)); err != nil {
+		return "", fmt.Errorf("failed to write alternates: %w", err)
+	}
+	logger.Info("added alternate", "dir", dir)
+	return dir, nil
+}
+
+// Copied is what Remove copied into the repository before dropping an
+// alternate
+type Copied struct {
+	Dir      string `json:"dir"`
+	Segments int    `json:"segments"`
+	Commits  int    `json:"commits"`
+	Chunks   int    `json:"chunks"`
+}
+
+// Remove stops the repository at repoPath borrowing from other, a repository
+// or the data directory listed. Everything the repository borrows is copied
+// in first, from whichever alternate lends it; blobs are a cache and are
+// not.
+func Remove(repoPath, other string) (*Copied, error) {
+	alts, err := repo.Alternates(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(alts, func(dir string) bool {
+		abs, err := filepath.Abs(other)
+		if err == nil && abs == dir {
+			return true
+		}
+		abs, err = dataDir(other)
+		return err == nil && abs == dir
+	})
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not an alternate of this repository", other)
+	}
+	resume, err := maintenance.Pause(repoPath, pauseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+
+	res := &Copied{Dir: alts[i]}
+	if res.Segments, err = ops.OwnSegments(filepath.Join(repo.Dir(repoPath), "ops")); err != nil {
+		return nil, fmt.Errorf("failed to copy op log segments: %w", err)
+	}
+	if res.Commits, err = commits.OwnCommits(repoPath); err != nil {
+		return nil, fmt.Errorf("failed to copy commits: %w", err)
+	}
+	if res.Chunks, err = lfs.NewStore(repoPath).OwnChunks(); err != nil {
+		return nil, fmt.Errorf("failed to copy LFS chunks: %w", err)
+	}
+	if err := repo.SetAlternates(repoPath, slices.Delete(alts, i, i+1)); err != nil {
+		return nil, fmt.Errorf("failed to write alternates: %w", err)
+	}
+	logger.Info("removed alternate", "dir", res.Dir, "segments", res.Segments, "commits", res.Commits, "chunks", res.Chunks)
+	return res, nil
+}
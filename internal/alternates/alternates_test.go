@@ -0,0 +1,84 @@
+package alternates
+
+import (
+	"context"
+	"evo/internal/checkout"
+	"evo/internal/clone"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddRemove(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	src := t.TempDir()
+	require.NoError(t, repo.InitRepo(src))
+	require.NoError(t, config.SetConfigValue(src, "ops.segmentSize", "512"))
+	var b strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	content := []byte(b.String())
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), content, 0644))
+	require.NoError(t, index.UpdateIndex(src))
+	_, err := ingest.IngestLocalChanges(context.Background(), src, "main")
+	require.NoError(t, err)
+	pending, err := commits.GatherNewOps(src, "main")
+	require.NoError(t, err)
+	commit, err := commits.CreateCommit(src, "main", "add a.txt", "Ann", "ann@example.com", pending, false)
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "copy")
+	_, err = clone.Clone(context.Background(), src, dir, clone.Options{Shared: true})
+	require.NoError(t, err)
+
+	// adding what is listed already, or the repository itself, changes nothing
+	listed, err := Add(dir, src)
+	require.NoError(t, err)
+	_, err = Add(dir, dir)
+	assert.Error(t, err)
+	_, err = Add(dir, t.TempDir())
+	assert.ErrorContains(t, err, "not an Evo repository")
+	alts, err := repo.Alternates(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{listed}, alts)
+
+	_, err = Remove(dir, t.TempDir())
+	assert.ErrorContains(t, err, "not an alternate")
+	sealed, err := ops.SealedSegments(filepath.Join(src, ".evo", "ops"))
+	require.NoError(t, err)
+	require.NotEmpty(t, sealed)
+	copied, err := Remove(dir, src)
+	require.NoError(t, err)
+	assert.Equal(t, listed, copied.Dir)
+	assert.Equal(t, len(sealed), copied.Segments)
+	assert.Equal(t, 1, copied.Commits)
+	alts, err = repo.Alternates(dir)
+	require.NoError(t, err)
+	assert.Empty(t, alts)
+
+	// nothing is read from the source any more
+	require.NoError(t, os.RemoveAll(src))
+	list, err := commits.ListCommits(dir, "main")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, commit.ID, list[0].ID)
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.txt")))
+	_, err = checkout.Attach(dir, "main", true)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
@@ -0,0 +1,333 @@
+// Package backup snapshots the .evo directory of a repository into a backup
+// directory and restores it from there. A backup directory keeps every file
+// once, by content, so each snapshot after the first copies only what is new:
+//
+//	<dest>/objects/ab/<sha256>        content of backed up files
+//	<dest>/snapshots/<time>.json      Manifest of one snapshot
+//
+// Files whose size and mtime match the last snapshot are taken to be
+// unchanged without reading them, unless a full backup is asked for.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"evo/internal/everrors"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/maintenance"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var logger = log.For("backup")
+
+// pauseTimeout is how long a backup waits for a maintenance run to finish
+const pauseTimeout = 10 * time.Minute
+
+// File is one file of a snapshot, by its path relative to .evo
+type File struct {
+	Path  string      `json:"path"`
+	Size  int64       `json:"size"`
+	Mode  fs.FileMode `json:"mode"`
+	MTime int64       `json:"mtime"` // nanoseconds since the epoch
+	Hash  string      `json:"hash"`
+}
+
+// Manifest lists the files of a snapshot
+type Manifest struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Repo    string    `json:"repo"`
+	Version int       `json:"version"` // repository format version
+	Files   []File    `json:"files"`
+}
+
+// Result is what Create did
+type Result struct {
+	Snapshot string `json:"snapshot"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Copied   int    `json:"copied"` // files whose content wasn't in the backup yet
+	Copy     int64  `json:"copiedBytes"`
+}
+
+// skipped are the paths under .evo a snapshot leaves out: locks, pid files,
+// half written files, and the backups made by evo migrate
+var skipped = []string{"backups", "lfs/lock", "maintenance.lock", "daemon.pid", "tmp", "chunks/tmp"}
+
+func skip(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, s := range skipped {
+		if rel == s || strings.HasPrefix(rel, s+"/") {
+			return true
+		}
+	}
+	return strings.HasSuffix(rel, ".tmp")
+}
+
+func objectPath(dest, hash string) string {
+	return filepath.Join(dest, "objects", hash[:2], hash)
+}
+
+// Snapshots returns the names of the snapshots in dest, oldest first
+func Snapshots(dest string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dest, "snapshots"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	var out []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".json"); ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Load returns the manifest of a snapshot in dest, the latest if name is
+// empty
+func Load(dest, name string) (*Manifest, error) {
+	if name == "" {
+		names, err := Snapshots(dest)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no snapshots in %s", dest)
+		}
+		name = names[len(names)-1]
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "snapshots", name+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot %s in %s", name, dest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", name, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// Create snapshots the .evo directory of the repository at repoPath into
+// dest. Maintenance is paused and the LFS store locked meanwhile, so neither
+// rewrites files under the copy. Unless full is set, files unchanged since
+// the last snapshot are not read again.
+func Create(repoPath, dest string, full bool) (*Result, error) {
+	resume, err := maintenance.Pause(repoPath, pauseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	unlock, err := lfs.NewStore(repoPath).Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	prev := make(map[string]File)
+	if !full {
+		if last, err := Load(dest, ""); err == nil {
+			for _, f := range last.Files {
+				prev[f.Path] = f
+			}
+		}
+	}
+	version, err := repo.Version(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	m := &Manifest{Name: now.Format("20060102T150405.000000000Z"), Created: now, Repo: repoPath, Version: version}
+	res := &Result{Snapshot: m.Name}
+
+	evoDir := repo.Dir(repoPath)
+	err = filepath.WalkDir(evoDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(evoDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if skip(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := File{Path: filepath.ToSlash(rel), Size: info.Size(), Mode: info.Mode().Perm(), MTime: info.ModTime().UnixNano()}
+		if p, ok := prev[f.Path]; ok && p.Size == f.Size && p.MTime == f.MTime {
+			if _, err := os.Stat(objectPath(dest, p.Hash)); err == nil {
+				f.Hash = p.Hash
+			}
+		}
+		if f.Hash == "" {
+			copied, err := store(path, dest, &f)
+			if err != nil {
+				return fmt.Errorf("failed to back up %s: %w", rel, err)
+			}
+			if copied {
+				res.Copied++
+				res.Copy += f.Size
+			}
+		}
+		m.Files = append(m.Files, f)
+		res.Files++
+		res.Bytes += f.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dest, "snapshots"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup: %w", err)
+	}
+	path := filepath.Join(dest, "snapshots", m.Name+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := platform.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	logger.Info("backup created", "snapshot", m.Name, "files", res.Files, "copied", res.Copied)
+	return res, nil
+}
+
+// store copies a file into the objects of dest, hashing it on the way, and
+// reports whether its content was new to the backup
+func store(path, dest string, f *File) (bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Join(dest, "objects"), 0755); err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(dest, "objects"), "tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	// the file may have grown since it was stat'ed
+	f.Size = n
+	f.Hash = hex.EncodeToString(h.Sum(nil))
+	obj := objectPath(dest, f.Hash)
+	if _, err := os.Stat(obj); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return false, err
+	}
+	return true, platform.Rename(tmp.Name(), obj)
+}
+
+// ErrExists is returned when restoring over an existing repository
+var ErrExists = everrors.New(everrors.ErrConflict, "a repository already exists there")
+
+// Restore recreates the .evo directory of target from a snapshot in dest,
+// the latest if name is empty, checking every file against its hash. The
+// working tree is left alone.
+func Restore(dest, name, target string) (*Manifest, error) {
+	m, err := Load(dest, name)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version > repo.FormatVersion {
+		return nil, &repo.TooNewError{Version: m.Version}
+	}
+	evoDir := filepath.Join(target, repo.EvoDir)
+	if _, err := os.Stat(evoDir); err == nil {
+		return nil, fmt.Errorf("%s: %w", target, ErrExists)
+	}
+	// restored next to .evo and renamed into place, so a failed restore
+	// leaves no half repository
+	tmp := evoDir + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if err := restoreFile(dest, tmp, f); err != nil {
+			os.RemoveAll(tmp)
+			return nil, fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+	}
+	if err := platform.Rename(tmp, evoDir); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	logger.Info("backup restored", "snapshot", m.Name, "files", len(m.Files), "target", target)
+	return m, nil
+}
+
+func restoreFile(dest, evoDir string, f File) error {
+	if !filepath.IsLocal(f.Path) {
+		return fmt.Errorf("invalid path")
+	}
+	in, err := os.Open(objectPath(dest, f.Hash))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	path := filepath.Join(evoDir, filepath.FromSlash(f.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode|0200)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != f.Hash {
+		return everrors.Errorf(everrors.ErrCorrupt, "backup object %s is corrupt", f.Hash)
+	}
+	mtime := time.Unix(0, f.MTime)
+	return os.Chtimes(path, mtime, mtime)
+}
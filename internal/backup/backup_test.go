@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"bytes"
+	"evo/internal/lfs"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRestore(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	store := lfs.NewStore(rp)
+	_, err := store.StoreFile("big", strings.NewReader("large content"), 13)
+	require.NoError(t, err)
+	dest := t.TempDir()
+
+	first, err := Create(rp, dest, false)
+	require.NoError(t, err)
+	assert.Greater(t, first.Files, 3)
+	assert.Equal(t, first.Copied, first.Files-countDuplicates(t, dest, first.Snapshot))
+
+	// only what changed is copied again
+	time.Sleep(10 * time.Millisecond)
+	_, err = store.StoreFile("other", strings.NewReader("more content"), 12)
+	require.NoError(t, err)
+	second, err := Create(rp, dest, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Snapshot, second.Snapshot)
+	assert.Greater(t, second.Copied, 0)
+	assert.Less(t, second.Copied, 5, "chunk, object, ref and the store's bookkeeping")
+	full, err := Create(rp, dest, true)
+	require.NoError(t, err)
+	assert.Zero(t, full.Copied, "a full backup reads every file but finds nothing new")
+	names, err := Snapshots(dest)
+	require.NoError(t, err)
+	assert.Len(t, names, 3)
+
+	target := t.TempDir()
+	m, err := Restore(dest, second.Snapshot, target)
+	require.NoError(t, err)
+	assert.Equal(t, second.Files, len(m.Files))
+	var buf bytes.Buffer
+	require.NoError(t, lfs.NewStore(target).ReadFile("other", &buf))
+	assert.Equal(t, "more content", buf.String())
+	v, err := repo.Version(target)
+	require.NoError(t, err)
+	assert.Equal(t, repo.FormatVersion, v)
+
+	_, err = Restore(dest, "", target)
+	assert.ErrorIs(t, err, ErrExists)
+
+	// a corrupt object fails the restore without leaving a repository
+	for _, f := range m.Files {
+		if f.Path == "HEAD" {
+			require.NoError(t, os.WriteFile(objectPath(dest, f.Hash), []byte("garbage"), 0644))
+		}
+	}
+	other := t.TempDir()
+	_, err = Restore(dest, second.Snapshot, other)
+	assert.ErrorContains(t, err, "corrupt")
+	assert.NoDirExists(t, filepath.Join(other, ".evo"))
+}
+
+// countDuplicates counts the files of a snapshot sharing content with an
+// earlier file of it
+func countDuplicates(t *testing.T, dest, name string) int {
+	m, err := Load(dest, name)
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	n := 0
+	for _, f := range m.Files {
+		if seen[f.Hash] {
+			n++
+		}
+		seen[f.Hash] = true
+	}
+	return n
+}
@@ -0,0 +1,281 @@
+// Package bench generates synthetic repositories and times the operations
+// whose speed matters most on them: status, commit, merge and checkout.
+// Results can be kept as JSON and compared with a later run to catch
+// performance regressions.
+package bench
+
+import (
+	"context"
+	"evo/internal/checkout"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/log"
+	"evo/internal/repo"
+	"evo/internal/status"
+	"evo/internal/streams"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var logger = log.For("bench")
+
+// Options describes the repository to generate and how often to time each
+// operation
+type Options struct {
+	Files   int   `json:"files"`
+	Lines   int   `json:"lines"`   // lines per file
+	Commits int   `json:"commits"` // commits of the generated history
+	Runs    int   `json:"runs"`    // timed runs of each operation
+	Seed    int64 `json:"seed"`
+}
+
+// DefaultOptions is a small repository that benchmarks in seconds
+var DefaultOptions = Options{Files: 100, Lines: 100, Commits: 10, Runs: 5, Seed: 1}
+
+func (o Options) validate() error {
+	if o.Files < 1 || o.Lines < 1 || o.Commits < 1 || o.Runs < 1 {
+		return fmt.Errorf("files, lines, commits and runs must be at least 1")
+	}
+	return nil
+}
+
+// Measurement is the timing of one operation over several runs
+type Measurement struct {
+	Name   string  `json:"name"`
+	Runs   int     `json:"runs"`
+	Min    float64 `json:"min"` // seconds
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// Result is the outcome of a benchmark
+type Result struct {
+	Options  Options       `json:"options"`
+	Generate float64       `json:"generate"` // seconds taken to generate the repository
+	Ops      []Measurement `json:"ops"`
+}
+
+// generator writes the synthetic repository
+type generator struct {
+	rp    string
+	rnd   *rand.Rand
+	files []string
+	ids   []string // commit IDs, oldest first
+}
+
+var words = strings.Fields("alpha beta gamma delta epsilon zeta eta theta iota kappa lambda mu nu xi omicron pi rho sigma tau upsilon phi chi psi omega")
+
+func (g *generator) line() string {
+	n := 3 + g.rnd.Intn(8)
+	ws := make([]string, n)
+	for i := range ws {
+		ws[i] = words[g.rnd.Intn(len(words))]
+	}
+	return strings.Join(ws, " ")
+}
+
+// Generate creates a repository in dir with o.Files files of o.Lines lines in
+// a tree of nested directories, committed in o.Commits commits: one adding
+// every file, each later one editing a line in a tenth of them
+func Generate(dir string, o Options) error {
+	_, err := generate(dir, o)
+	return err
+}
+
+func generate(dir string, o Options) (*generator, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if err := repo.InitRepo(dir); err != nil {
+		return nil, err
+	}
+	g := &generator{rp: dir, rnd: rand.New(rand.NewSource(o.Seed))}
+	for i := range o.Files {
+		rel := filepath.Join(fmt.Sprintf("d%02d", i%16), fmt.Sprintf("s%02d", i/16%16), fmt.Sprintf("f%05d.txt", i))
+		lines := make([]string, o.Lines)
+		for j := range lines {
+			lines[j] = g.line()
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(rel)), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		g.files = append(g.files, rel)
+	}
+	if err := g.commit("generate files"); err != nil {
+		return nil, err
+	}
+	for i := 1; i < o.Commits; i++ {
+		if err := g.edit(); err != nil {
+			return nil, err
+		}
+		if err := g.commit(fmt.Sprintf("edit %d", i)); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// edit replaces a random line in a tenth of the files
+func (g *generator) edit() error {
+	for range max(1, len(g.files)/10) {
+		abs := filepath.Join(g.rp, g.files[g.rnd.Intn(len(g.files))])
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		lines[g.rnd.Intn(len(lines))] = g.line()
+		if err := os.WriteFile(abs, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit records the working tree as evo commit does
+func (g *generator) commit(msg string) error {
+	if err := index.UpdateIndex(g.rp); err != nil {
+		return err
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), g.rp, "main"); err != nil {
+		return err
+	}
+	eops, err := commits.GatherNewOps(g.rp, "main")
+	if err != nil {
+		return err
+	}
+	c, err := commits.CreateCommit(g.rp, "main", msg, "bench", "bench@example.com", eops, false)
+	if err != nil {
+		return err
+	}
+	g.ids = append(g.ids, c.ID)
+	return nil
+}
+
+// Run generates a repository in a temporary directory and times status,
+// commit, merge and checkout on it. With keep set the repository is left in
+// place and its path returned.
+func Run(o Options, keep bool) (*Result, string, error) {
+	dir, err := os.MkdirTemp("", "evo-bench-")
+	if err != nil {
+		return nil, "", err
+	}
+	kept := ""
+	if keep {
+		kept = dir
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	start := time.Now()
+	g, err := generate(dir, o)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate repository: %w", err)
+	}
+	res := &Result{Options: o, Generate: time.Since(start).Seconds()}
+	logger.Info("generated repository", "dir", dir, "files", o.Files, "lines", o.Lines, "commits", o.Commits)
+
+	for _, b := range []struct {
+		name string
+		prep func(run int) error // not timed
+		run  func(run int) error
+	}{
+		{"status", nil, func(int) error {
+			_, err := status.GetStatus(dir)
+			return err
+		}},
+		{"commit", func(int) error { return g.edit() }, func(run int) error {
+			return g.commit(fmt.Sprintf("bench commit %d", run))
+		}},
+		// merges the whole history after the first commit into a fresh stream
+		{"merge", func(run int) error {
+			return streams.CreateStreamFrom(dir, fmt.Sprintf("bench-%d", run), "main", g.ids[0])
+		}, func(run int) error {
+			return streams.MergeStreams(context.Background(), dir, "main", fmt.Sprintf("bench-%d", run))
+		}},
+		// to the first commit and back to the newest
+		{"checkout", nil, func(int) error {
+			_, err := checkout.Detach(dir, "main", g.ids[0], true)
+			return err
+		}},
+		{"attach", nil, func(int) error {
+			_, err := checkout.Attach(dir, "main", true)
+			return err
+		}},
+	} {
+		times := make([]float64, o.Runs)
+		for i := range times {
+			if b.prep != nil {
+				if err := b.prep(i); err != nil {
+					return nil, "", fmt.Errorf("failed to prepare %s: %w", b.name, err)
+				}
+			}
+			start := time.Now()
+			if err := b.run(i); err != nil {
+				return nil, "", fmt.Errorf("failed to run %s: %w", b.name, err)
+			}
+			times[i] = time.Since(start).Seconds()
+		}
+		sort.Float64s(times)
+		res.Ops = append(res.Ops, Measurement{Name: b.name, Runs: o.Runs, Min: times[0], Median: times[len(times)/2], Max: times[len(times)-1]})
+	}
+	return res, kept, nil
+}
+
+// Regression is an operation slower than in a baseline
+type Regression struct {
+	Name     string  `json:"name"`
+	Baseline float64 `json:"baseline"` // median seconds
+	Median   float64 `json:"median"`
+}
+
+// Compare returns the operations whose median is more than tolerance (0.2 for
+// 20%) slower than in baseline. Operations missing from either are skipped.
+func (r *Result) Compare(baseline *Result, tolerance float64) []Regression {
+	before := make(map[string]float64)
+	for _, m := range baseline.Ops {
+		before[m.Name] = m.Median
+	}
+	var out []Regression
+	for _, m := range r.Ops {
+		if b, ok := before[m.Name]; ok && m.Median > b*(1+tolerance) {
+			out = append(out, Regression{Name: m.Name, Baseline: b, Median: m.Median})
+		}
+	}
+	return out
+}
+
+func ms(s float64) string {
+	return fmt.Sprintf("%.2fms", s*1000)
+}
+
+// Format returns a text rendering of a result, with the change of each
+// median against baseline when there is one
+func (r *Result) Format(baseline *Result) string {
+	before := make(map[string]float64)
+	if baseline != nil {
+		for _, m := range baseline.Ops {
+			before[m.Name] = m.Median
+		}
+	}
+	var sb strings.Builder
+	o := r.Options
+	sb.WriteString(fmt.Sprintf("%d files x %d lines, %d commits (generated in %s), %d runs each\n\n", o.Files, o.Lines, o.Commits, ms(r.Generate), o.Runs))
+	sb.WriteString(fmt.Sprintf("%-10s %12s %12s %12s\n", "op", "min", "median", "max"))
+	for _, m := range r.Ops {
+		sb.WriteString(fmt.Sprintf("%-10s %12s %12s %12s", m.Name, ms(m.Min), ms(m.Median), ms(m.Max)))
+		if b, ok := before[m.Name]; ok && b > 0 {
+			sb.WriteString(fmt.Sprintf("  %+.0f%%", (m.Median/b-1)*100))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"evo/internal/status"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	o := Options{Files: 12, Lines: 5, Commits: 3, Runs: 2, Seed: 7}
+	r, dir, err := Run(o, true)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var names []string
+	for _, m := range r.Ops {
+		names = append(names, m.Name)
+		assert.Equal(t, 2, m.Runs)
+		assert.LessOrEqual(t, m.Min, m.Median)
+		assert.LessOrEqual(t, m.Median, m.Max)
+	}
+	assert.Equal(t, []string{"status", "commit", "merge", "checkout", "attach"}, names)
+	assert.Contains(t, r.Format(nil), "12 files x 5 lines, 3 commits")
+
+	slow := *r
+	slow.Ops = append([]Measurement(nil), r.Ops...)
+	slow.Ops[0].Median = r.Ops[0].Median*2 + 1
+	regs := slow.Compare(r, 0.2)
+	if assert.Len(t, regs, 1) {
+		assert.Equal(t, "status", regs[0].Name)
+	}
+	assert.Empty(t, r.Compare(&slow, 0.2))
+	assert.Contains(t, slow.Format(r), "%")
+
+	_, _, err = Run(Options{Files: 1}, false)
+	assert.ErrorContains(t, err, "at least 1")
+}
+
+func benchRepo(b *testing.B) string {
+	dir := b.TempDir()
+	require.NoError(b, Generate(dir, DefaultOptions))
+	return dir
+}
+
+func BenchmarkStatus(b *testing.B) {
+	dir := benchRepo(b)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := status.GetStatus(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCommit(b *testing.B) {
+	g, err := generate(b.TempDir(), DefaultOptions)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		require.NoError(b, g.edit())
+		b.StartTimer()
+		if err := g.commit("bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
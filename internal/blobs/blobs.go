@@ -0,0 +1,174 @@
+// Package blobs is the content-addressed store of file contents: each
+// distinct content once, zlib-compressed, under the SHA-256 of its bytes as
+// .evo/objects/ab/<sha256>. Ingest and checkout put the content they find in
+// sync with a stream's op log, whose hash .evo/hashes/<stream> records, so
+// status and diff know a file's base content without replaying its log.
+// Blobs are a cache of what the logs hold: losing one only costs a replay.
+// Blobs of the repositories listed as alternates are read as if held here.
+package blobs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"evo/internal/storage"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var logger = log.For("blobs")
+
+// ErrCorrupt is returned for a blob whose content doesn't match its hash
+var ErrCorrupt = everrors.New(everrors.ErrCorrupt, "blob does not match its hash")
+
+// backend returns the repository's blobs, read through to those of its
+// alternates
+func backend(repoPath string) storage.Backend {
+	own := storage.NewFS(filepath.Join(repo.Dir(repoPath), "objects"))
+	alts, err := repo.Alternates(repoPath)
+	if err != nil {
+		logger.Warn("failed to read alternates", "error", err)
+	}
+	var lower []storage.Backend
+	for _, dir := range alts {
+		lower = append(lower, storage.NewFS(filepath.Join(dir, "objects")))
+	}
+	return storage.NewOverlay(own, lower...)
+}
+
+func key(sum string) string {
+	return sum[:2] + "/" + sum
+}
+
+// Sum returns the hash a content is stored under
+func Sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// IsSum reports whether s is a hex SHA-256
+func IsSum(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Put stores data unless it is stored already and returns its hash
+func Put(repoPath string, data []byte) (string, error) {
+	sum := Sum(data)
+	if Has(repoPath, sum) {
+		return sum, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if err := backend(repoPath).Put(key(sum), &buf); err != nil {
+		return "", fmt.Errorf("failed to store blob %s: %w", sum, err)
+	}
+	return sum, nil
+}
+
+// Has reports whether the content of sum is stored
+func Has(repoPath, sum string) bool {
+	if !IsSum(sum) {
+		return false
+	}
+	_, err := backend(repoPath).Stat(key(sum))
+	return err == nil
+}
+
+// Get returns the content of sum, an fs.ErrNotExist if it isn't stored
+func Get(repoPath, sum string) ([]byte, error) {
+	if !IsSum(sum) {
+		return nil, fmt.Errorf("invalid blob hash %q", sum)
+	}
+	r, err := backend(repoPath).Get(key(sum))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, sum, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, sum, err)
+	}
+	if Sum(data) != sum {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, sum)
+	}
+	return data, nil
+}
+
+// Prune deletes the blobs of contents no stream's file hashes name, which
+// ingest and checkout leave behind as files change, and anything else in
+// .evo/objects that isn't a blob, such as the per-file objects of older
+// versions. It returns the number of files deleted. Cancelling ctx stops it
+// between files; the blobs checked are reported to the progress reporter of
+// ctx.
+func Prune(ctx context.Context, repoPath string) (int, error) {
+	keep := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(repo.Dir(repoPath), "hashes"))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		hashes, err := index.LoadHashes(repoPath, e.Name())
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range hashes {
+			keep[h.Content()] = true
+		}
+	}
+
+	b := backend(repoPath)
+	n := 0
+	pr := progress.From(ctx)
+	pr.Start("Pruning blobs", -1)
+	defer pr.Done()
+	err = b.List("", func(k string, _ int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pr.Add(1)
+		sum := path.Base(k)
+		if IsSum(sum) && k == key(sum) && keep[sum] {
+			return nil
+		}
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, fmt.Errorf("failed to prune blobs: %w", err)
+	}
+	if n > 0 {
+		logger.Info("pruned blobs", "deleted", n)
+	}
+	return n, nil
+}
@@ -0,0 +1,71 @@
+package blobs
+
+import (
+	"context"
+	"evo/internal/index"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobs(t *testing.T) {
+	rp := t.TempDir()
+	data := []byte(strings.Repeat("the same line\n", 1000))
+
+	sum, err := Put(rp, data)
+	require.NoError(t, err)
+	assert.Equal(t, index.HashContent(data), sum)
+	assert.True(t, Has(rp, sum))
+	got, err := Get(rp, sum)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// stored compressed, once
+	path := filepath.Join(rp, ".evo", "objects", sum[:2], sum)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, fi.Size(), int64(len(data)/10))
+	again, err := Put(rp, data)
+	require.NoError(t, err)
+	assert.Equal(t, sum, again)
+
+	_, err = Get(rp, Sum([]byte("never stored")))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = Get(rp, "../../etc/passwd")
+	assert.Error(t, err)
+
+	// a blob damaged on disk is caught
+	other, err := Put(rp, []byte("other"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "objects", other[:2], other), []byte("junk"), 0644))
+	_, err = Get(rp, other)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestPrune(t *testing.T) {
+	rp := t.TempDir()
+	kept, err := Put(rp, []byte("in sync"))
+	require.NoError(t, err)
+	tagged, err := Put(rp, []byte("in sync by character"))
+	require.NoError(t, err)
+	stale, err := Put(rp, []byte("an older version"))
+	require.NoError(t, err)
+	require.NoError(t, index.SetHash(rp, "main", "f1", index.Hash{LogSize: 10, Sum: kept}))
+	require.NoError(t, index.SetHash(rp, "dev", "f2", index.Hash{LogSize: 10, Sum: index.GranularSum(tagged, "char")}))
+	// an object of older versions, named by file ID
+	legacy := filepath.Join(rp, ".evo", "objects", "5f0e7c52-1b6a-4a39-9a53-3d3c1d2f4e10")
+	require.NoError(t, os.WriteFile(legacy, []byte("content"), 0644))
+
+	n, err := Prune(context.Background(), rp)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, Has(rp, kept))
+	assert.True(t, Has(rp, tagged))
+	assert.False(t, Has(rp, stale))
+	assert.NoFileExists(t, legacy)
+}
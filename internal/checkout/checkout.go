@@ -0,0 +1,388 @@
+// Package checkout moves the working tree to a commit of a stream other than
+// its newest, leaving HEAD detached, and back to a stream's newest commit.
+// A detached working tree is a read-only view: commits are refused until
+// HEAD is attached again or a stream is created from the detached commit.
+// Restore brings back single files from a commit without moving HEAD.
+package checkout
+
+import (
+	"errors"
+	"evo/internal/everrors"
+	"evo/internal/history"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/ops"
+	"evo/internal/prompt"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var logger = log.For("checkout")
+
+// ErrDirty is returned when checking out would overwrite uncommitted changes
+var ErrDirty = everrors.New(everrors.ErrConflict, "the working tree has uncommitted changes")
+
+// Result lists what a checkout changed in the working tree
+type Result struct {
+	Stream  string   `json:"stream"`
+	Commit  string   `json:"commit,omitempty"` // set when HEAD is detached
+	Written []string `json:"written"`
+	Removed []string `json:"removed"`
+	Skipped []string `json:"skipped,omitempty"` // large files whose content isn't stored
+	Unnamed []string `json:"unnamed,omitempty"` // IDs of files Refresh found no free path for
+}
+
+// check refuses to overwrite uncommitted changes unless force is set. A
+// detached working tree holds nothing to lose.
+func check(repoPath string, force bool) error {
+	if repo.IsBare(repoPath) {
+		return repo.ErrBare
+	}
+	head, err := repo.ReadHead(repoPath)
+	if err != nil {
+		return err
+	}
+	if force || head.Detached != "" {
+		return nil
+	}
+	dirty, err := prompt.Dirty(repoPath, head.Stream)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+	return nil
+}
+
+// Detach writes the tracked files of stream as of commitID into the working
+// tree and records the commit in HEAD. Large files are restored with their
+// current content, as the LFS store keeps one version per file.
+func Detach(repoPath, stream, commitID string, force bool) (*Result, error) {
+	if err := check(repoPath, force); err != nil {
+		return nil, err
+	}
+	snap, err := history.At(repoPath, stream, commitID)
+	if err != nil {
+		return nil, err
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Stream: stream, Commit: commitID, Written: []string{}, Removed: []string{}}
+	for path := range p2id {
+		if _, ok := snap.Files[path]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(repoPath, path)); err == nil {
+			res.Removed = append(res.Removed, path)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	// the index follows the files of the commit, and status compares them
+	// with what was written
+	view := make(map[string]string, len(snap.Files))
+	hashes := make(map[string]index.Hash, len(snap.Files))
+	for path, f := range snap.Files {
+		if path == f.FileID {
+			logger.Warn("skipping file with no known path", "stream", stream, "file", f.FileID)
+			continue
+		}
+		sum, err := writeFile(repoPath, filepath.Join(repoPath, path), f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		view[path] = f.FileID
+		hashes[f.FileID] = index.Hash{Sum: sum}
+		res.Written = append(res.Written, path)
+	}
+	sort.Strings(res.Written)
+	sort.Strings(res.Removed)
+	if err := index.SaveHashes(repoPath, index.Detached, hashes); err != nil {
+		return nil, err
+	}
+	if err := index.SaveIndex(repoPath, view); err != nil {
+		return nil, err
+	}
+	if err := repo.WriteHead(repoPath, repo.Head{Stream: stream, Detached: commitID}); err != nil {
+		return nil, err
+	}
+	logger.Info("detached", "stream", stream, "commit", commitID, "written", len(res.Written), "removed", len(res.Removed))
+	return res, nil
+}
+
+// writeFile writes a file as of a snapshot and returns the SHA-256 of what
+// it wrote
+func writeFile(repoPath, abs string, f *history.File) (string, error) {
+	lines := make([]string, len(f.Lines))
+	for i, l := range f.Lines {
+		lines[i] = l.Content
+	}
+	return materialize.WriteContent(repoPath, abs, f.FileID, lines)
+}
+
+// files returns the files stream holds at its newest, by path: those whose
+// op log leaves lines, at the path the index last gave them. Files no index
+// ever named are left out.
+func files(repoPath, stream string) (map[string]string, error) {
+	id2path, err := index.KnownPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := ops.ListLogs(filepath.Join(repo.Dir(repoPath), "ops", stream))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, l := range logs {
+		fid := strings.TrimSuffix(filepath.Base(l), ".bin")
+		doc, err := materialize.Load(repoPath, stream, fid)
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Lines) == 0 {
+			continue
+		}
+		path, ok := id2path[fid]
+		if !ok {
+			logger.Warn("skipping file with no known path", "stream", stream, "file", fid)
+			continue
+		}
+		out[path] = fid
+	}
+	return out, nil
+}
+
+// Restore writes the files at or under paths as they were at commitID of
+// stream, removing tracked files the commit didn't have, without moving
+// HEAD: the restored content shows up as uncommitted changes. Files deleted
+// since are written back and tracked again. Paths are relative to the
+// repository root with forward slashes; "." is all of it.
+func Restore(repoPath, stream, commitID string, paths []string) (*Result, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	snap, err := history.At(repoPath, stream, commitID)
+	if err != nil {
+		return nil, err
+	}
+	p2id, id2p, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	under := func(path, dir string) bool {
+		return dir == "." || path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+	}
+	res := &Result{Stream: stream, Commit: commitID, Written: []string{}, Removed: []string{}}
+	retracked := false
+	for _, dir := range paths {
+		matched := false
+		for path, f := range snap.Files {
+			if path == f.FileID || !under(path, dir) {
+				continue
+			}
+			matched = true
+			if _, err := writeFile(repoPath, filepath.Join(repoPath, path), f); err != nil {
+				return nil, fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+			res.Written = append(res.Written, path)
+			// a file deleted since is tracked again, unless it lives on
+			// under another path
+			if _, ok := id2p[f.FileID]; !ok {
+				p2id[path] = f.FileID
+				id2p[f.FileID] = path
+				retracked = true
+			}
+		}
+		for path := range p2id {
+			if !under(path, dir) {
+				continue
+			}
+			matched = true
+			if _, ok := snap.Files[path]; ok {
+				continue
+			}
+			if err := os.Remove(filepath.Join(repoPath, path)); err == nil {
+				res.Removed = append(res.Removed, path)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%s did not match any tracked file", dir)
+		}
+	}
+	if retracked {
+		if err := index.SaveIndex(repoPath, p2id); err != nil {
+			return nil, err
+		}
+	}
+	res.Written = dedup(res.Written)
+	res.Removed = dedup(res.Removed)
+	logger.Info("restored", "stream", stream, "commit", commitID, "written", len(res.Written), "removed", len(res.Removed))
+	return res, nil
+}
+
+// dedup sorts paths and drops repeats, which overlapping arguments produce
+func dedup(paths []string) []string {
+	sort.Strings(paths)
+	return slices.Compact(paths)
+}
+
+// Attach makes stream current and rewrites the working tree from its newest
+// state, ending a detached checkout: files it holds are written, the other
+// tracked files removed, and the index left holding the stream's files.
+func Attach(repoPath, stream string, force bool) (*Result, error) {
+	if err := check(repoPath, force); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", stream)); err != nil {
+		return nil, everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", stream)
+	}
+	if err := repo.WriteHead(repoPath, repo.Head{Stream: stream}); err != nil {
+		return nil, err
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	target, err := files(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	// files of the working tree and of the stream are all written from the
+	// stream, which removes those it lacks; the index names them meanwhile
+	both := make(map[string]string, len(p2id)+len(target))
+	for path, fid := range p2id {
+		both[path] = fid
+	}
+	for path, fid := range target {
+		both[path] = fid
+	}
+	if err := index.SaveIndex(repoPath, both); err != nil {
+		return nil, err
+	}
+	res := &Result{Stream: stream, Written: []string{}, Removed: []string{}}
+	for path, fid := range both {
+		if err := materialize.WriteFile(repoPath, stream, fid); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, path)); err == nil {
+			res.Written = append(res.Written, path)
+		} else {
+			res.Removed = append(res.Removed, path)
+		}
+	}
+	sort.Strings(res.Written)
+	sort.Strings(res.Removed)
+	if err := index.SaveIndex(repoPath, target); err != nil {
+		return nil, err
+	}
+	logger.Info("attached", "stream", stream, "written", len(res.Written), "removed", len(res.Removed))
+	return res, nil
+}
+
+// CanRefresh checks that Refresh can write commits merged into stream into
+// the working tree: stream is current, HEAD attached, and nothing is left
+// uncommitted that rewriting files would lose
+func CanRefresh(repoPath, stream string) error {
+	if repo.IsBare(repoPath) {
+		return repo.ErrBare
+	}
+	head, err := repo.ReadHead(repoPath)
+	if err != nil {
+		return err
+	}
+	if head.Detached != "" {
+		return fmt.Errorf("HEAD is detached at %s; run 'evo checkout %s' first", head.Detached, head.Stream)
+	}
+	if head.Stream != stream {
+		return fmt.Errorf("%s is not the current stream", stream)
+	}
+	dirty, err := prompt.Dirty(repoPath, stream)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+	return nil
+}
+
+// Refresh rewrites the files with the given IDs from the newest state of
+// stream, as after merging commits that change them into the current
+// stream. Files new to the working tree are tracked at the path remembered
+// or pulled for them; those with no path known, or whose path another file
+// holds, are listed as unnamed and not written. Large files whose content
+// isn't stored are listed as skipped.
+func Refresh(repoPath, stream string, fileIDs []string) (*Result, error) {
+	p2id, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	known, err := index.KnownPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Stream: stream, Written: []string{}, Removed: []string{}}
+	added := false
+	for _, fid := range fileIDs {
+		if _, ok := id2path[fid]; ok {
+			continue
+		}
+		doc, err := materialize.Load(repoPath, stream, fid)
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Lines) == 0 {
+			// nothing to write
+			continue
+		}
+		path, ok := known[fid]
+		if _, taken := p2id[path]; !ok || taken {
+			logger.Warn("not writing file with no free path", "stream", stream, "file", fid, "path", path)
+			res.Unnamed = append(res.Unnamed, fid)
+			continue
+		}
+		p2id[path], id2path[fid] = fid, path
+		added = true
+	}
+	if added {
+		if err := index.SaveIndex(repoPath, p2id); err != nil {
+			return nil, err
+		}
+	}
+	for _, fid := range fileIDs {
+		path, ok := id2path[fid]
+		if !ok {
+			continue
+		}
+		err := materialize.WriteFile(repoPath, stream, fid)
+		if errors.Is(err, materialize.ErrNotStored) {
+			res.Skipped = append(res.Skipped, path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, path)); err == nil {
+			res.Written = append(res.Written, path)
+		} else {
+			res.Removed = append(res.Removed, path)
+		}
+	}
+	sort.Strings(res.Written)
+	sort.Strings(res.Removed)
+	sort.Strings(res.Skipped)
+	sort.Strings(res.Unnamed)
+	logger.Info("refreshed", "stream", stream, "written", len(res.Written), "removed", len(res.Removed), "skipped", len(res.Skipped), "unnamed", len(res.Unnamed))
+	return res, nil
+}
@@ -0,0 +1,351 @@
+package checkout
+
+import (
+	"context"
+	"evo/internal/history"
+	"evo/internal/index"
+	"evo/internal/journal"
+	"evo/internal/prompt"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/status"
+	"evo/internal/streams"
+	"evo/internal/testutil"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clean asserts status finds nothing changed in the working tree
+func clean(t *testing.T, rp string) {
+	t.Helper()
+	st, err := status.GetStatus(rp)
+	require.NoError(t, err)
+	assert.Empty(t, st.Files)
+}
+
+func read(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestDetachAttach(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a, b := filepath.Join(rp, "a.txt"), filepath.Join(rp, "b.txt")
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	first := testutil.Commit(t, rp, "main", "first")
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("bee"), 0644))
+	testutil.Commit(t, rp, "main", "second")
+
+	res, err := Detach(rp, "main", first.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, res.Written)
+	assert.Equal(t, []string{"b.txt"}, res.Removed)
+	assert.Equal(t, "one", read(t, a))
+	assert.NoFileExists(t, b)
+	head, err := repo.ReadHead(rp)
+	require.NoError(t, err)
+	assert.Equal(t, repo.Head{Stream: "main", Detached: first.ID}, head)
+
+	// HEAD names the detached commit
+	c, stream, err := revparse.Resolve(rp, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, c.ID)
+	assert.Equal(t, "main", stream)
+
+	res, err = Attach(rp, "main", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, res.Written)
+	assert.Equal(t, "two", read(t, a))
+	assert.Equal(t, "bee", read(t, b))
+	head, err = repo.ReadHead(rp)
+	require.NoError(t, err)
+	assert.Equal(t, repo.Head{Stream: "main"}, head)
+
+	_, err = Attach(rp, "nope", false)
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestStreams(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a, f := filepath.Join(rp, "a.txt"), filepath.Join(rp, "f.txt")
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	first := testutil.Commit(t, rp, "main", "first")
+	require.NoError(t, streams.CreateStream(rp, "feature"))
+
+	// a file main has and feature hasn't goes, and isn't reported deleted
+	res, err := Attach(rp, "feature", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, res.Removed)
+	assert.NoFileExists(t, a)
+	clean(t, rp)
+
+	require.NoError(t, os.WriteFile(f, []byte("feat"), 0644))
+	testutil.Commit(t, rp, "feature", "feature")
+
+	// main's file comes back though the feature commit dropped its path
+	res, err = Attach(rp, "main", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, res.Written)
+	assert.Equal(t, []string{"f.txt"}, res.Removed)
+	assert.Equal(t, "one", read(t, a))
+	assert.NoFileExists(t, f)
+	clean(t, rp)
+
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	testutil.Commit(t, rp, "main", "second")
+	clean(t, rp)
+
+	// and feature's after a commit on main
+	_, err = Attach(rp, "feature", false)
+	require.NoError(t, err)
+	assert.Equal(t, "feat", read(t, f))
+	assert.NoFileExists(t, a)
+	clean(t, rp)
+
+	// a detached checkout is compared with the commit it checked out
+	_, err = Detach(rp, "main", first.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, "one", read(t, a))
+	assert.NoFileExists(t, f)
+	clean(t, rp)
+	require.NoError(t, os.WriteFile(a, []byte("scratch"), 0644))
+	st, err := status.GetStatus(rp)
+	require.NoError(t, err)
+	assert.Equal(t, []status.FileStatus{{Path: "a.txt", Status: "modified"}}, st.Files)
+
+	_, err = Attach(rp, "main", true)
+	require.NoError(t, err)
+	assert.Equal(t, "two", read(t, a))
+	clean(t, rp)
+}
+
+// TestStaleHashes checks that status compares files with their op log when a
+// merge or an undo changes the log under the working tree
+func TestStaleHashes(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a := filepath.Join(rp, "a.txt")
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	testutil.Commit(t, rp, "main", "first")
+	require.NoError(t, streams.CreateStreamFrom(rp, "feature", "main", ""))
+	_, err := Attach(rp, "feature", false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	testutil.Commit(t, rp, "feature", "feature")
+	_, err = Attach(rp, "main", false)
+	require.NoError(t, err)
+	clean(t, rp)
+
+	// merged in, the file no longer matches main
+	rec, err := journal.Begin(rp, "merge", "merge feature into main")
+	require.NoError(t, err)
+	require.NoError(t, rec.TrackFile("a.txt"))
+	require.NoError(t, streams.MergeStreams(context.Background(), rp, "feature", "main"))
+	st, err := status.GetStatus(rp)
+	require.NoError(t, err)
+	assert.Equal(t, []status.FileStatus{{Path: "a.txt", Status: "modified"}}, st.Files)
+	dirty, err := prompt.Dirty(rp, "main")
+	require.NoError(t, err)
+	assert.True(t, dirty)
+
+	// until it is written from the stream
+	_, id2p, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	var fid string
+	for id := range id2p {
+		fid = id
+	}
+	_, err = Refresh(rp, "main", []string{fid})
+	require.NoError(t, err)
+	require.NoError(t, rec.Finish())
+	assert.Equal(t, "two", read(t, a))
+	clean(t, rp)
+
+	// undone, the file and its log are as before and in sync again
+	_, err = journal.Undo(rp, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "one", read(t, a))
+	clean(t, rp)
+	dirty, err = prompt.Dirty(rp, "main")
+	require.NoError(t, err)
+	assert.False(t, dirty)
+}
+
+func TestDirty(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a := filepath.Join(rp, "a.txt")
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	first := testutil.Commit(t, rp, "main", "first")
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	testutil.Commit(t, rp, "main", "second")
+
+	require.NoError(t, os.WriteFile(a, []byte("edited"), 0644))
+	_, err := Detach(rp, "main", first.ID, false)
+	assert.ErrorIs(t, err, ErrDirty)
+	assert.Equal(t, "edited", read(t, a))
+
+	_, err = Detach(rp, "main", first.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, "one", read(t, a))
+
+	// nothing to lose while detached
+	require.NoError(t, os.WriteFile(a, []byte("scratch"), 0644))
+	_, err = Attach(rp, "main", false)
+	require.NoError(t, err)
+	assert.Equal(t, "two", read(t, a))
+}
+
+func TestRestore(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a, x, y := filepath.Join(rp, "a.txt"), filepath.Join(rp, "d", "x.txt"), filepath.Join(rp, "d", "y.txt")
+	require.NoError(t, os.MkdirAll(filepath.Join(rp, "d"), 0755))
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	require.NoError(t, os.WriteFile(x, []byte("x"), 0644))
+	first := testutil.Commit(t, rp, "main", "first")
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	require.NoError(t, os.WriteFile(x, []byte("x2"), 0644))
+	require.NoError(t, os.WriteFile(y, []byte("y"), 0644))
+	testutil.Commit(t, rp, "main", "second")
+
+	res, err := Restore(rp, "main", first.ID, []string{"d", "d/x.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d/x.txt"}, res.Written)
+	assert.Equal(t, []string{"d/y.txt"}, res.Removed)
+	assert.Equal(t, "x", read(t, x))
+	assert.NoFileExists(t, y)
+	assert.Equal(t, "two", read(t, a), "other paths are left alone")
+
+	// HEAD stays on the stream, and the restored file is a change
+	head, err := repo.ReadHead(rp)
+	require.NoError(t, err)
+	assert.Equal(t, repo.Head{Stream: "main"}, head)
+	dirty, err := prompt.Dirty(rp, "main")
+	require.NoError(t, err)
+	assert.True(t, dirty)
+
+	_, err = Restore(rp, "main", first.ID, []string{"nope"})
+	assert.ErrorContains(t, err, "nope did not match any tracked file")
+}
+
+func TestRestoreDeleted(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a, x, y := filepath.Join(rp, "a.txt"), filepath.Join(rp, "d", "x.txt"), filepath.Join(rp, "d", "y.txt")
+	require.NoError(t, os.MkdirAll(filepath.Join(rp, "d"), 0755))
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	require.NoError(t, os.WriteFile(x, []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(y, []byte("y"), 0644))
+	first := testutil.Commit(t, rp, "main", "first")
+	p2id, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+
+	// a deleted file and a deleted directory are committed away
+	require.NoError(t, os.Remove(a))
+	require.NoError(t, os.RemoveAll(filepath.Join(rp, "d")))
+	testutil.Commit(t, rp, "main", "second")
+	after, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	require.Empty(t, after)
+
+	res, err := Restore(rp, "main", first.ID, []string{"a.txt", "d"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "d/x.txt", "d/y.txt"}, res.Written)
+	assert.Empty(t, res.Removed)
+	assert.Equal(t, "one", read(t, a))
+	assert.Equal(t, "x", read(t, x))
+	assert.Equal(t, "y", read(t, y))
+
+	// they are tracked again under their file IDs, and committing them
+	// brings them back to the stream
+	after, _, err = index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Equal(t, p2id, after)
+	third := testutil.Commit(t, rp, "main", "third")
+	clean(t, rp)
+	snap, err := history.At(rp, "main", third.ID)
+	require.NoError(t, err)
+	assert.Len(t, snap.Files, 3)
+}
+
+func TestRefresh(t *testing.T) {
+	src, rp := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(src))
+	require.NoError(t, repo.InitRepo(rp))
+	a := filepath.Join(src, "a.txt")
+	require.NoError(t, os.WriteFile(a, []byte("one"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("bee"), 0644))
+	first := testutil.Commit(t, src, "main", "first")
+	idx, err := os.ReadFile(filepath.Join(src, ".evo", "index"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "index"), idx, 0644))
+
+	// commits received into the current stream are written once refreshed
+	require.NoError(t, CanRefresh(rp, "main"))
+	applied, err := streams.ReceiveReport(context.Background(), rp, "main", []types.Commit{*first})
+	require.NoError(t, err)
+	res, err := Refresh(rp, "main", applied.Files)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, res.Written)
+	assert.Equal(t, "one", read(t, filepath.Join(rp, "a.txt")))
+
+	// only the files the commits change are rewritten
+	require.NoError(t, os.WriteFile(a, []byte("two"), 0644))
+	second := testutil.Commit(t, src, "main", "second")
+	require.NoError(t, CanRefresh(rp, "main"))
+	applied, err = streams.ReceiveReport(context.Background(), rp, "main", []types.Commit{*first, *second})
+	require.NoError(t, err)
+	res, err = Refresh(rp, "main", applied.Files)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, res.Written)
+	assert.Equal(t, "two", read(t, filepath.Join(rp, "a.txt")))
+	dirty, err := prompt.Dirty(rp, "main")
+	require.NoError(t, err)
+	assert.False(t, dirty)
+
+	// uncommitted changes, or another stream, refuse it
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "b.txt"), []byte("changed"), 0644))
+	assert.ErrorIs(t, CanRefresh(rp, "main"), ErrDirty)
+	assert.ErrorContains(t, CanRefresh(rp, "dev"), "not the current stream")
+}
+
+func TestRefreshNewFiles(t *testing.T) {
+	src, rp := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(src))
+	require.NoError(t, repo.InitRepo(rp))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("one"), 0644))
+	first := testutil.Commit(t, src, "main", "first")
+	applied, err := streams.ReceiveReport(context.Background(), rp, "main", []types.Commit{*first})
+	require.NoError(t, err)
+	require.Len(t, applied.Files, 1)
+
+	// a file no path is known for isn't written, but listed
+	res, err := Refresh(rp, "main", applied.Files)
+	require.NoError(t, err)
+	assert.Empty(t, res.Written)
+	assert.Equal(t, applied.Files, res.Unnamed)
+
+	// once its path is sent along, it is tracked and written
+	paths, err := streams.Paths(src, []types.Commit{*first})
+	require.NoError(t, err)
+	require.NoError(t, index.LearnPaths(rp, paths))
+	res, err = Refresh(rp, "main", applied.Files)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, res.Written)
+	assert.Empty(t, res.Unnamed)
+	assert.Equal(t, "one", read(t, filepath.Join(rp, "a.txt")))
+	p2id, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a.txt": applied.Files[0]}, p2id)
+	clean(t, rp)
+}
@@ -0,0 +1,301 @@
+// Package clone copies a repository on the same machine into a new directory
+// with a working tree of its own. The copy gets a node identity of its own
+// and leaves out what belongs to the source's working tree alone: staged
+// ops, the undo journal, untracked paths and caches of file stats.
+//
+// A local clone hard-links the files nothing changes in place, LFS chunks,
+// blobs and sealed op log segments, when both repositories are on one
+// filesystem, and reflinks the rest where the filesystem can. A shared clone
+// takes none of these, nor commit files: it lists the source in
+// .evo/alternates and reads them from there.
+package clone
+
+import (
+	"context"
+	"evo/internal/blobs"
+	"evo/internal/checkout"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/maintenance"
+	"evo/internal/node"
+	"evo/internal/ops"
+	"evo/internal/platform"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"evo/internal/transfer"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = log.For("clone")
+
+// pauseTimeout is how long a clone waits for a maintenance run to finish
+const pauseTimeout = 10 * time.Minute
+
+// Options adjust how a clone takes the source's files
+type Options struct {
+	Local  bool // hard-link and reflink files instead of copying them
+	Shared bool // borrow what the source never changes instead of taking it
+	// Transfer sets how many files are copied at once and how fast, nil
+	// for one at a time at full speed
+	Transfer *transfer.Options
+}
+
+// Result is what Clone did
+type Result struct {
+	Source    string `json:"source"`
+	Dir       string `json:"dir"`
+	Stream    string `json:"stream"`
+	Files     int    `json:"files"`     // files of .evo taken from the source
+	Linked    int    `json:"linked"`    // of them, hard-linked
+	Reflinked int    `json:"reflinked"` // reflinked
+	Copied    int64  `json:"copiedBytes"`
+	Shared    bool   `json:"shared"`
+	Written   int    `json:"written"` // files written into the working tree
+}
+
+// skipped are the paths under .evo a clone leaves out: the source's locks,
+// half written files, backups and identity, and state of its working tree
+var skipped = []string{
+	"backups", "compact-backup", "transfers", "lfs/lock", "maintenance.lock", "daemon.pid",
+	"tmp", "chunks/tmp", "objects/tmp", "node", "journal", "staged", "untracked", "stat", "alternates",
+}
+
+func skip(rel string) bool {
+	for _, s := range skipped {
+		if rel == s || strings.HasPrefix(rel, s+"/") {
+			return true
+		}
+	}
+	return strings.HasSuffix(rel, ".tmp")
+}
+
+// object reports whether rel, relative to .evo, is an LFS chunk or a blob:
+// content named by its hash, never changed once written
+func object(rel string) bool {
+	name := path.Base(rel)
+	switch {
+	case strings.HasPrefix(rel, "chunks/"):
+		return lfs.IsHash(name)
+	case strings.HasPrefix(rel, "objects/"):
+		return blobs.IsSum(name)
+	}
+	return false
+}
+
+// commitFile reports whether rel, relative to .evo, is a commit file, which
+// is replaced rather than changed
+func commitFile(rel string) bool {
+	return strings.HasPrefix(rel, "commits/") && path.Ext(rel) == ".bin"
+}
+
+// Clone copies the repository at src into dir, which must not exist or be
+// empty, and checks out the stream src has checked out. Cancelling ctx
+// before the checkout removes what was copied. The files taken are reported
+// to the progress reporter of ctx.
+func Clone(ctx context.Context, src, dir string, opts Options) (*Result, error) {
+	if !repo.IsRepo(src) {
+		return nil, fmt.Errorf("%s is not an Evo repository", src)
+	}
+	if repo.IsBare(src) {
+		return nil, fmt.Errorf("cannot clone %s: a bare repository has no index of paths to check out", src)
+	}
+	cfg, err := config.Load(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config of %s: %w", src, err)
+	}
+	for _, key := range []string{"commits.storage", "ops.storage"} {
+		if spec, _ := cfg.Get(key); spec != "" {
+			return nil, fmt.Errorf("cannot clone %s: it keeps history in %s (%s), which a clone would share", src, spec, key)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s exists and is not empty", dir)
+	}
+	created := os.IsNotExist(err)
+	srcDir, err := filepath.Abs(repo.Dir(src))
+	if err != nil {
+		return nil, err
+	}
+	alts, err := repo.Alternates(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alternates of %s: %w", src, err)
+	}
+	if opts.Shared {
+		alts = append([]string{srcDir}, alts...)
+	}
+
+	res := &Result{Source: src, Dir: dir, Shared: opts.Shared}
+	dstDir := filepath.Join(dir, repo.EvoDir)
+	// a failed or cancelled clone leaves nothing behind
+	fail := func(err error) (*Result, error) {
+		if created {
+			os.RemoveAll(dir)
+		} else {
+			os.RemoveAll(dstDir)
+		}
+		return nil, err
+	}
+	if err := take(ctx, src, srcDir, dstDir, opts, res); err != nil {
+		return fail(fmt.Errorf("failed to clone %s: %w", src, err))
+	}
+	if err := repo.SetAlternates(dir, alts); err != nil {
+		return fail(err)
+	}
+	if _, err := node.Fork(src, dir); err != nil {
+		return fail(fmt.Errorf("failed to create a node identity: %w", err))
+	}
+
+	head, err := repo.ReadHead(dir)
+	if err != nil {
+		return fail(err)
+	}
+	// the checkout is not interrupted: a clone stopped halfway through it
+	// would be neither complete nor removed
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	res.Stream = head.Stream
+	var co *checkout.Result
+	if head.Detached != "" {
+		co, err = checkout.Detach(dir, head.Stream, head.Detached, true)
+	} else {
+		co, err = checkout.Attach(dir, head.Stream, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cloned %s but failed to check out %s: %w", src, head.Stream, err)
+	}
+	res.Written = len(co.Written)
+	logger.Info("cloned", "source", src, "dir", dir, "files", res.Files, "linked", res.Linked,
+		"reflinked", res.Reflinked, "copied", res.Copied, "shared", opts.Shared)
+	return res, nil
+}
+
+// take copies the files of srcDir, the .evo of src, into dstDir. Maintenance
+// is paused and the LFS store locked meanwhile, so neither rewrites files
+// under the copy.
+func take(ctx context.Context, src, srcDir, dstDir string, opts Options, res *Result) error {
+	resume, err := maintenance.Pause(src, pauseTimeout)
+	if err != nil {
+		return err
+	}
+	defer resume()
+	unlock, err := lfs.NewStore(src).Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the clone's commit index lists the commits it borrows
+	if opts.Shared {
+		if err := commits.RefreshIndex(src); err != nil {
+			return err
+		}
+	}
+	sealed, err := ops.SealedSegments(filepath.Join(srcDir, "ops"))
+	if err != nil {
+		return fmt.Errorf("failed to read op logs: %w", err)
+	}
+	linkable := make(map[string]bool, len(sealed))
+	for _, s := range sealed {
+		linkable[s] = true
+	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+	pr := progress.From(ctx)
+	pr.Start("Copying", -1)
+	defer pr.Done()
+	var copies []string // relative paths of the files copied after the walk
+	err = filepath.WalkDir(srcDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		lent := object(rel) || linkable[p] || commitFile(rel)
+		if skip(rel) || opts.Shared && lent {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		res.Files++
+		pr.Add(1)
+		if opts.Local && lent {
+			if err := os.Link(p, dst); err == nil {
+				res.Linked++
+				return nil
+			}
+		}
+		if opts.Local {
+			if err := platform.Reflink(p, dst); err == nil {
+				res.Reflinked++
+				return nil
+			}
+		}
+		copies = append(copies, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	return transfer.Each(opts.Transfer, copies, func(rel string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := copyFile(filepath.Join(srcDir, filepath.FromSlash(rel)), filepath.Join(dstDir, filepath.FromSlash(rel)), opts.Transfer)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		mu.Lock()
+		res.Copied += n
+		mu.Unlock()
+		return nil
+	})
+}
+
+func copyFile(src, dst string, opts *transfer.Options) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, opts.Reader(in))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
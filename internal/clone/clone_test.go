@@ -0,0 +1,166 @@
+package clone
+
+import (
+	"context"
+	"evo/internal/blobs"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/node"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// source returns a repository with a.txt ingested on main, its op log past
+// the segment size
+func source(t *testing.T) (string, []byte) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	src := t.TempDir()
+	require.NoError(t, repo.InitRepo(src))
+	require.NoError(t, config.SetConfigValue(src, "ops.segmentSize", "512"))
+	var b strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	content := []byte(b.String())
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), content, 0644))
+	require.NoError(t, index.UpdateIndex(src))
+	_, err := ingest.IngestLocalChanges(context.Background(), src, "main")
+	require.NoError(t, err)
+	return src, content
+}
+
+func TestClone(t *testing.T) {
+	src, content := source(t)
+	dir := filepath.Join(t.TempDir(), "copy")
+	res, err := Clone(context.Background(), src, dir, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "main", res.Stream)
+	assert.Equal(t, 1, res.Written)
+	assert.Zero(t, res.Linked)
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	a, err := node.Load(src)
+	require.NoError(t, err)
+	b, err := node.Load(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, a.ID, b.ID)
+	assert.Equal(t, a.Clock.Now(), b.Clock.Now())
+	assert.NoFileExists(t, filepath.Join(dir, ".evo", "journal"))
+
+	_, err = Clone(context.Background(), src, dir, Options{})
+	assert.ErrorContains(t, err, "not empty")
+	_, err = Clone(context.Background(), t.TempDir(), filepath.Join(t.TempDir(), "x"), Options{})
+	assert.ErrorContains(t, err, "not an Evo repository")
+
+	// a cancelled clone leaves nothing behind
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gone := filepath.Join(t.TempDir(), "gone")
+	_, err = Clone(ctx, src, gone, Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoDirExists(t, gone)
+}
+
+func TestCloneLocal(t *testing.T) {
+	src, content := source(t)
+	dir := filepath.Join(t.TempDir(), "copy")
+	res, err := Clone(context.Background(), src, dir, Options{Local: true})
+	require.NoError(t, err)
+
+	// the blob and the sealed segments are the source's files
+	sum := blobs.Sum(content)
+	blob := filepath.Join(sum[:2], sum)
+	sealed, err := ops.SealedSegments(filepath.Join(src, ".evo", "ops"))
+	require.NoError(t, err)
+	require.NotEmpty(t, sealed)
+	assert.Equal(t, 1+len(sealed), res.Linked)
+	for _, p := range append(sealed, filepath.Join(src, ".evo", "objects", blob)) {
+		rel, err := filepath.Rel(src, p)
+		require.NoError(t, err)
+		a, err := os.Stat(p)
+		require.NoError(t, err)
+		b, err := os.Stat(filepath.Join(dir, rel))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(a, b), rel)
+	}
+
+	// appending in the clone leaves the source alone
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), append(content, "more\n"...), 0644))
+	_, err = ingest.IngestLocalChanges(context.Background(), dir, "main")
+	require.NoError(t, err)
+	logs, err := ops.AllLogs(filepath.Join(src, ".evo", "ops"))
+	require.NoError(t, err)
+	for _, l := range logs {
+		srcOps, err := ops.LoadAllOps(l)
+		require.NoError(t, err)
+		rel, _ := filepath.Rel(src, l)
+		cloneOps, err := ops.LoadAllOps(filepath.Join(dir, rel))
+		require.NoError(t, err)
+		assert.Greater(t, len(cloneOps), len(srcOps))
+	}
+}
+
+func TestCloneShared(t *testing.T) {
+	src, content := source(t)
+	dir := filepath.Join(t.TempDir(), "copy")
+	pending, err := commits.GatherNewOps(src, "main")
+	require.NoError(t, err)
+	commit, err := commits.CreateCommit(src, "main", "add a.txt", "Ann", "ann@example.com", pending, false)
+	require.NoError(t, err)
+	res, err := Clone(context.Background(), src, dir, Options{Shared: true})
+	require.NoError(t, err)
+	assert.True(t, res.Shared)
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	alts, err := repo.Alternates(dir)
+	require.NoError(t, err)
+	abs, err := filepath.Abs(filepath.Join(src, ".evo"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{abs}, alts)
+
+	// the blob is read from the source
+	sum := blobs.Sum(content)
+	assert.NoFileExists(t, filepath.Join(dir, ".evo", "objects", sum[:2], sum))
+	assert.True(t, blobs.Has(dir, sum))
+	data, err = blobs.Get(dir, sum)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// so are the sealed segments and the commit
+	sealed, err := ops.SealedSegments(filepath.Join(src, ".evo", "ops"))
+	require.NoError(t, err)
+	require.NotEmpty(t, sealed)
+	for _, p := range sealed {
+		rel, err := filepath.Rel(src, p)
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(dir, rel))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, ".evo", "commits", "main", commit.ID+".bin"))
+	list, err := commits.ListCommits(dir, "main")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, commit.ID, list[0].ID)
+
+	// a clone of the clone borrows from the same place
+	again := filepath.Join(t.TempDir(), "again")
+	_, err = Clone(context.Background(), dir, again, Options{})
+	require.NoError(t, err)
+	alts, err = repo.Alternates(again)
+	require.NoError(t, err)
+	assert.Equal(t, []string{abs}, alts)
+}
@@ -0,0 +1,317 @@
+package commits
+
+import (
+	"errors"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/node"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Builder collects ops on any number of a stream's files and records them
+// as one commit, for tools that generate changes rather than edit the
+// working tree: importers, editors, scripted refactorings. Each op is
+// checked against its file as the op log and the ops added before it leave
+// it, so a commit never updates or deletes a line that isn't there.
+//
+// Applying the ops rewrites the files they touch when the stream is checked
+// out; working tree edits to them not ingested yet are lost.
+type Builder struct {
+	repoPath string
+	stream   string
+	self     *node.Node
+	path2id  map[string]string
+	id2path  map[string]string
+	added    []string // paths new to the index
+	files    map[uuid.UUID]*fileState
+	eops     []ExtendedOp
+}
+
+// fileState is a file as the builder's ops leave it
+type fileState struct {
+	doc   *crdt.RGA
+	known map[uuid.UUID]bool // lines ever inserted, deleted ones too
+}
+
+// OpSpec is a change to one line as tools describe it: by path and line
+// number rather than IDs and stamps. Lines count from 1 in the file as the
+// ops before leave it; an insert gives the number the new line gets.
+type OpSpec struct {
+	Op      string `json:"op"` // insert, update or delete
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Content string `json:"content,omitempty"`
+}
+
+// NewBuilder starts a batch of ops for the stream
+func NewBuilder(repoPath, stream string) (*Builder, error) {
+	if !repo.ValidStreamName(stream) {
+		return nil, fmt.Errorf("invalid stream name %q", stream)
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	p2id, id2p, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Builder{
+		repoPath: repoPath,
+		stream:   stream,
+		self:     self,
+		path2id:  p2id,
+		id2path:  id2p,
+		files:    make(map[uuid.UUID]*fileState),
+	}, nil
+}
+
+// Ops returns the ops added so far, stamped
+func (b *Builder) Ops() []ExtendedOp {
+	return b.eops
+}
+
+// File returns the ID of the file at path, relative to the repository,
+// assigning one if the index has none. Only the checked-out stream takes
+// new files, as the index follows its working tree.
+func (b *Builder) File(path string) (uuid.UUID, error) {
+	p := filepath.ToSlash(filepath.Clean(path))
+	if fid, ok := b.path2id[p]; ok {
+		return uuid.Parse(fid)
+	}
+	if !filepath.IsLocal(p) || p == repo.EvoDir || strings.HasPrefix(p, repo.EvoDir+"/") {
+		return uuid.Nil, fmt.Errorf("invalid path %q", path)
+	}
+	if !isCurrentStream(b.repoPath, b.stream) {
+		return uuid.Nil, fmt.Errorf("can't add %s: %s isn't the checked-out stream", p, b.stream)
+	}
+	id := uuid.New()
+	b.path2id[p] = id.String()
+	b.id2path[id.String()] = p
+	b.added = append(b.added, p)
+	return id, nil
+}
+
+// file returns the state of a tracked file, replaying its log the first time
+func (b *Builder) file(id uuid.UUID) (*fileState, error) {
+	if f, ok := b.files[id]; ok {
+		return f, nil
+	}
+	if _, ok := b.id2path[id.String()]; !ok {
+		return nil, fmt.Errorf("file %s isn't tracked", id)
+	}
+	doc, err := materialize.Load(b.repoPath, b.stream, id.String())
+	if err != nil {
+		return nil, err
+	}
+	logged, err := doc.Ops()
+	if err != nil {
+		return nil, err
+	}
+	f := &fileState{doc: crdt.NewRGA(crdt.WithoutLog()), known: make(map[uuid.UUID]bool)}
+	for _, op := range logged {
+		if err := f.doc.Apply(op); err != nil {
+			return nil, fmt.Errorf("failed to replay file %s: %w", id, err)
+		}
+		if op.Type == crdt.OpInsert {
+			f.known[op.LineID] = true
+		}
+	}
+	b.self.Clock.Observe(doc.Lamport)
+	b.files[id] = f
+	return f, nil
+}
+
+// Add checks op against its file and adds it. The caller sets Type,
+// FileID, LineID, Content and, for inserts, OriginLineID; the builder
+// stamps the rest.
+func (b *Builder) Add(op crdt.Operation) error {
+	if op.LineID == uuid.Nil || op.LineID == crdt.DocumentStart {
+		return fmt.Errorf("invalid line ID %s", op.LineID)
+	}
+	f, err := b.file(op.FileID)
+	if err != nil {
+		return err
+	}
+	eop := ExtendedOp{}
+	switch op.Type {
+	case crdt.OpInsert:
+		if f.doc.IndexOf(op.LineID) >= 0 {
+			return fmt.Errorf("line %s of file %s is already there", op.LineID, op.FileID)
+		}
+		if op.OriginLineID != crdt.DocumentStart && !f.known[op.OriginLineID] {
+			return fmt.Errorf("insert after unknown line %s of file %s", op.OriginLineID, op.FileID)
+		}
+	case crdt.OpUpdate, crdt.OpDelete:
+		i := f.doc.IndexOf(op.LineID)
+		if i < 0 {
+			return fmt.Errorf("line %s of file %s isn't there", op.LineID, op.FileID)
+		}
+		_, eop.OldContent, _ = f.doc.LineAt(i)
+	default:
+		return fmt.Errorf("unknown operation type: %d", op.Type)
+	}
+	op.Lamport = b.self.Tick()
+	op.NodeID = b.self.ID
+	op.Stream = b.stream
+	op.Timestamp = time.Now()
+	if err := f.doc.Apply(op); err != nil {
+		return err
+	}
+	if op.Type == crdt.OpInsert {
+		f.known[op.LineID] = true
+	}
+	eop.Op = op
+	b.eops = append(b.eops, eop)
+	return nil
+}
+
+// Insert adds a line after the line after, or at the top for
+// crdt.DocumentStart, and returns its ID
+func (b *Builder) Insert(fileID, after uuid.UUID, content string) (uuid.UUID, error) {
+	id := uuid.New()
+	err := b.Add(crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: id, OriginLineID: after, Content: content})
+	return id, err
+}
+
+// Update replaces the content of a line
+func (b *Builder) Update(fileID, lineID uuid.UUID, content string) error {
+	return b.Add(crdt.Operation{Type: crdt.OpUpdate, FileID: fileID, LineID: lineID, Content: content})
+}
+
+// Delete removes a line
+func (b *Builder) Delete(fileID, lineID uuid.UUID) error {
+	return b.Add(crdt.Operation{Type: crdt.OpDelete, FileID: fileID, LineID: lineID})
+}
+
+// AddSpec adds the op a spec describes
+func (b *Builder) AddSpec(s OpSpec) error {
+	fid, err := b.File(s.Path)
+	if err != nil {
+		return err
+	}
+	f, err := b.file(fid)
+	if err != nil {
+		return err
+	}
+	n := f.doc.Len()
+	switch s.Op {
+	case "insert":
+		if s.Line < 1 || s.Line > n+1 {
+			return fmt.Errorf("%s: can't insert line %d in %d line(s)", s.Path, s.Line, n)
+		}
+		after := crdt.DocumentStart
+		if s.Line > 1 {
+			after, _, _ = f.doc.LineAt(s.Line - 2)
+		}
+		_, err = b.Insert(fid, after, s.Content)
+		return err
+	case "update", "delete":
+		if s.Line < 1 || s.Line > n {
+			return fmt.Errorf("%s: no line %d in %d line(s)", s.Path, s.Line, n)
+		}
+		id, _, _ := f.doc.LineAt(s.Line - 1)
+		if s.Op == "update" {
+			return b.Update(fid, id, s.Content)
+		}
+		return b.Delete(fid, id)
+	}
+	return fmt.Errorf("unknown op %q: use insert, update or delete", s.Op)
+}
+
+// Apply appends the ops to the stream's op logs, as ApplyOps does, and
+// returns them; the next commit of the stream gathers them like any other.
+// Should any of it fail, the logs, the index and the working tree are put
+// back as they were.
+func (b *Builder) Apply() ([]ExtendedOp, error) {
+	_, err := b.apply()
+	if err != nil {
+		return nil, err
+	}
+	eops := b.eops
+	b.eops, b.added = nil, nil
+	return eops, nil
+}
+
+// Commit applies the ops and records them as one commit, or leaves the
+// repository as it was
+func (b *Builder) Commit(message, authorName, authorEmail string, trailers []types.Trailer, sign bool) (*types.Commit, error) {
+	if len(b.eops) == 0 {
+		return nil, fmt.Errorf("nothing to commit")
+	}
+	undo, err := b.apply()
+	if err != nil {
+		return nil, err
+	}
+	c, err := CreateCommitWithTrailers(b.repoPath, b.stream, message, authorName, authorEmail, trailers, b.eops, sign)
+	if err != nil {
+		return nil, errors.Join(err, undo())
+	}
+	b.eops, b.added = nil, nil
+	return c, nil
+}
+
+// apply writes the ops and returns how to take them back
+func (b *Builder) apply() (func() error, error) {
+	opsRoot := filepath.Join(repo.Dir(b.repoPath), "ops", b.stream)
+	marks := make(map[string]int64)
+	for _, eop := range b.eops {
+		path := filepath.Join(opsRoot, eop.Op.FileID.String()+".bin")
+		if _, ok := marks[path]; !ok {
+			marks[path] = ops.Mark(path)
+		}
+	}
+	oldIndex := make(map[string]string, len(b.path2id))
+	for p, fid := range b.path2id {
+		oldIndex[p] = fid
+	}
+	for _, p := range b.added {
+		delete(oldIndex, p)
+	}
+
+	undo := func() error {
+		errs := []error{ops.Restore(marks)}
+		if len(b.added) > 0 {
+			errs = append(errs, index.SaveIndex(b.repoPath, oldIndex))
+			for _, p := range b.added {
+				if err := os.Remove(filepath.Join(b.repoPath, p)); err != nil && !os.IsNotExist(err) {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if isCurrentStream(b.repoPath, b.stream) {
+			for path := range marks {
+				fid := strings.TrimSuffix(filepath.Base(path), ".bin")
+				errs = append(errs, materialize.WriteFile(b.repoPath, b.stream, fid))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("failed to roll back ops: %w", err)
+		}
+		return nil
+	}
+
+	if len(b.added) > 0 {
+		if err := index.SaveIndex(b.repoPath, b.path2id); err != nil {
+			return nil, errors.Join(err, undo())
+		}
+	}
+	if err := ApplyOps(b.repoPath, b.stream, b.eops); err != nil {
+		return nil, errors.Join(err, undo())
+	}
+	if err := b.self.Save(); err != nil {
+		return nil, errors.Join(err, undo())
+	}
+	logger.Debug("applied batch", "stream", b.stream, "ops", len(b.eops), "files", len(marks))
+	return undo, nil
+}
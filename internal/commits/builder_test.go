@@ -0,0 +1,114 @@
+package commits
+
+import (
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	if err := repo.InitRepo(rp); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(rp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// ops across two new files make one commit
+	b, err := NewBuilder(rp, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []OpSpec{
+		{Op: "insert", Path: "a.txt", Line: 1, Content: "two"},
+		{Op: "insert", Path: "a.txt", Line: 1, Content: "one"},
+		{Op: "insert", Path: "dir/b.txt", Line: 1, Content: "bee"},
+	} {
+		if err := b.AddSpec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := b.Commit("generated", "Tool", "tool@example.com", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Operations) != 3 {
+		t.Errorf("Expected 3 ops in the commit, got %d", len(c.Operations))
+	}
+	if got := read("a.txt"); got != "one\ntwo" {
+		t.Errorf("Expected a.txt written, got %q", got)
+	}
+	if got := read("dir/b.txt"); got != "bee" {
+		t.Errorf("Expected dir/b.txt written, got %q", got)
+	}
+	if pending, _ := GatherNewOps(rp, "main"); len(pending) != 0 {
+		t.Errorf("Expected every op committed, got %v", pending)
+	}
+
+	// ops are checked against the state the ones before leave
+	b, err = NewBuilder(rp, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddSpec(OpSpec{Op: "delete", Path: "a.txt", Line: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddSpec(OpSpec{Op: "update", Path: "a.txt", Line: 2, Content: "x"}); err == nil {
+		t.Error("Expected an error updating a line past the end")
+	}
+	if err := b.AddSpec(OpSpec{Op: "update", Path: "a.txt", Line: 1, Content: "TWO"}); err != nil {
+		t.Fatal(err)
+	}
+	fid, _ := b.File("a.txt")
+	if err := b.Update(fid, uuid.New(), "x"); err == nil {
+		t.Error("Expected an error updating an unknown line")
+	}
+	if _, err := b.Insert(fid, uuid.New(), "x"); err == nil {
+		t.Error("Expected an error inserting after an unknown line")
+	}
+	if err := b.Add(crdt.Operation{Type: crdt.OpInsert, FileID: uuid.New(), LineID: uuid.New(), OriginLineID: crdt.DocumentStart}); err == nil {
+		t.Error("Expected an error for an untracked file")
+	}
+	if _, err := b.File("../out.txt"); err == nil {
+		t.Error("Expected an error for a path outside the repository")
+	}
+	if ops := b.Ops(); len(ops) != 2 || ops[0].OldContent != "one" || ops[1].OldContent != "two" {
+		t.Errorf("Expected the old content recorded, got %+v", ops)
+	}
+
+	// a commit that fails leaves the repository as it was
+	if err := b.AddSpec(OpSpec{Op: "insert", Path: "c.txt", Line: 1, Content: "sea"}); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(repo.Dir(rp), "ops", "main", fid.String()+".bin")
+	size := ops.LogSize(log)
+	if _, err := b.Commit("signed", "Tool", "tool@example.com", nil, true); err == nil {
+		t.Fatal("Expected signing without a key to fail")
+	}
+	if got := read("a.txt"); got != "one\ntwo" {
+		t.Errorf("Expected a.txt restored, got %q", got)
+	}
+	if ops.LogSize(log) != size {
+		t.Errorf("Expected the op log cut back to %d bytes, got %d", size, ops.LogSize(log))
+	}
+	if _, err := os.Stat(filepath.Join(rp, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected c.txt removed, got %v", err)
+	}
+	if p2id, _, _ := index.LoadIndex(rp); p2id["c.txt"] != "" || p2id["a.txt"] == "" {
+		t.Errorf("Expected the index restored, got %v", p2id)
+	}
+}
@@ -2,14 +2,17 @@ package commits
 
 import (
 	"crypto/sha256"
-	"encoding/binary"
-	"encoding/json"
 	"evo/internal/crdt"
+	"evo/internal/everrors"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/node"
 	"evo/internal/ops"
+	"evo/internal/repo"
 	"evo/internal/signing"
 	"evo/internal/types"
+	"evo/internal/validate"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
@@ -22,8 +25,16 @@ import (
 // ExtendedOp includes oldContent for update ops
 type ExtendedOp = types.ExtendedOp
 
+var logger = log.For("commits")
+
 // CreateCommit creates a new commit with the given operations
 func CreateCommit(repoPath, stream, message, authorName, authorEmail string, ops []types.ExtendedOp, sign bool) (*types.Commit, error) {
+	return CreateCommitWithTrailers(repoPath, stream, message, authorName, authorEmail, nil, ops, sign)
+}
+
+// CreateCommitWithTrailers creates a new commit carrying the given trailers,
+// which are covered by its signature
+func CreateCommitWithTrailers(repoPath, stream, message, authorName, authorEmail string, trailers []types.Trailer, ops []types.ExtendedOp, sign bool) (*types.Commit, error) {
 	commit := &types.Commit{
 		ID:          uuid.New().String(),
 		Stream:      stream,
@@ -32,6 +43,7 @@ func CreateCommit(repoPath, stream, message, authorName, authorEmail string, ops
 		AuthorEmail: authorEmail,
 		Timestamp:   time.Now().UTC(),
 		Operations:  ops,
+		Trailers:    trailers,
 	}
 
 	// Sign commit if requested
@@ -56,26 +68,22 @@ func CreateCommit(repoPath, stream, message, authorName, authorEmail string, ops
 	if err := SaveCommit(repoPath, commit); err != nil {
 		return nil, fmt.Errorf("failed to save commit: %w", err)
 	}
+	logger.Info("created commit", "id", commit.ID, "stream", stream, "ops", len(ops), "signed", sign)
 
 	return commit, nil
 }
 
-// LoadCommit loads a commit from disk
+// LoadCommit loads a commit of a stream, verifying its signature if it has
+// one
 func LoadCommit(repoPath, stream, commitID string) (*types.Commit, error) {
-	commitPath := filepath.Join(repoPath, ".evo", "commits", stream, commitID+".bin")
-	data, err := os.ReadFile(commitPath)
+	commit, err := ReadCommit(repoPath, stream, commitID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read commit file: %w", err)
-	}
-
-	var commit types.Commit
-	if err := json.Unmarshal(data, &commit); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal commit: %w", err)
+		return nil, err
 	}
 
 	// Verify signature if present
 	if commit.Signature != "" {
-		valid, err := signing.VerifyCommit(&commit, repoPath)
+		valid, err := signing.VerifyCommit(commit, repoPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to verify commit signature: %w", err)
 		}
@@ -84,31 +92,18 @@ func LoadCommit(repoPath, stream, commitID string) (*types.Commit, error) {
 		}
 	}
 
-	return &commit, nil
+	return commit, nil
 }
 
-// SaveCommit saves a commit to disk
+// SaveCommit saves a commit among the commits of its stream
 func SaveCommit(repoPath string, commit *types.Commit) error {
-	commitDir := filepath.Join(repoPath, ".evo", "commits", commit.Stream)
-	if err := os.MkdirAll(commitDir, 0755); err != nil {
-		return fmt.Errorf("failed to create commit directory: %w", err)
-	}
-
-	data, err := json.Marshal(commit)
-	if err != nil {
-		return fmt.Errorf("failed to marshal commit: %w", err)
-	}
-
-	commitPath := filepath.Join(commitDir, commit.ID+".bin")
-	if err := os.WriteFile(commitPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write commit file: %w", err)
-	}
-
-	return nil
+	return SaveCommitTo(repoPath, commit.Stream, commit)
 }
 
-// gatherNewOps => find ops not in prior commits, augment 'update' ops with oldContent
-func gatherNewOps(repoPath, stream string) ([]ExtendedOp, error) {
+// GatherNewOps returns the ops in the stream's logs that no commit of the
+// stream records yet, in op order. Update and delete ops carry the content
+// the line had before them, so they can be reverted.
+func GatherNewOps(repoPath, stream string) ([]ExtendedOp, error) {
 	all, err := ListCommits(repoPath, stream)
 	if err != nil {
 		return nil, err
@@ -120,52 +115,31 @@ func gatherNewOps(repoPath, stream string) ([]ExtendedOp, error) {
 		}
 	}
 
-	// load all current ops
-	var allOps []ExtendedOp
-	opsDir := filepath.Join(repoPath, ".evo", "ops", stream)
-	if err := filepath.WalkDir(opsDir, func(path string, d fs.DirEntry, err error) error {
+	logs, err := ops.ListLogs(filepath.Join(repo.Dir(repoPath), "ops", stream))
+	if err != nil {
+		return nil, err
+	}
+	var newEops []ExtendedOp
+	for _, path := range logs {
+		logOps, err := ops.LoadAllOps(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if !d.IsDir() && filepath.Ext(path) == ".bin" {
-			f, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			var size int64
-			if err := binary.Read(f, binary.LittleEndian, &size); err != nil {
-				return err
-			}
-			data := make([]byte, size)
-			if _, err := f.Read(data); err != nil {
-				return err
+		// replay the log to know each line's content before each op
+		content := make(map[uuid.UUID]string)
+		for _, op := range logOps {
+			old := content[op.LineID]
+			if op.Type == crdt.OpInsert || op.Type == crdt.OpUpdate {
+				content[op.LineID] = op.Content
 			}
-			var op crdt.Operation
-			if err := json.Unmarshal(data, &op); err != nil {
-				return err
+			if known[opKey(op)] {
+				continue
 			}
-			allOps = append(allOps, ExtendedOp{Op: op})
-		}
-		return nil
-	}); err != nil && !os.IsNotExist(err) {
-		return nil, err
-	}
-
-	// build doc states to find old text
-	docStates := buildDocStates(repoPath, stream)
-	var newEops []ExtendedOp
-	for _, op := range allOps {
-		k := opKey(op.Op)
-		if !known[k] {
-			var old string
-			if op.Op.Type == crdt.OpUpdate {
-				old = findOldContent(docStates, op.Op.LineID)
+			eop := ExtendedOp{Op: op}
+			if op.Type == crdt.OpUpdate || op.Type == crdt.OpDelete {
+				eop.OldContent = old
 			}
-			newEops = append(newEops, ExtendedOp{
-				Op:         op.Op,
-				OldContent: old,
-			})
+			newEops = append(newEops, eop)
 		}
 	}
 	sort.Slice(newEops, func(i, j int) bool {
@@ -178,129 +152,23 @@ func opKey(op crdt.Operation) string {
 	return fmt.Sprintf("%d_%s_%s", op.Lamport, op.NodeID.String(), op.LineID.String())
 }
 
-func buildDocStates(repoPath, stream string) map[uuid.UUID]map[uuid.UUID]string {
-	res := make(map[uuid.UUID]map[uuid.UUID]string)
-	root := filepath.Join(repoPath, ".evo", "ops", stream)
-	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() && strings.HasSuffix(path, ".bin") {
-			fn := filepath.Base(path)
-			fidStr := strings.TrimSuffix(fn, ".bin")
-			fid, err := uuid.Parse(fidStr)
-			if err == nil {
-				ops2, _ := ops.LoadAllOps(path)
-				doc := crdt.NewRGA()
-				for _, op := range ops2 {
-					doc.Apply(op)
-				}
-				res[fid] = doc.LineMap()
-			}
-		}
-		return nil
-	}); err != nil && !os.IsNotExist(err) {
-		return nil
-	}
-	return res
-}
-
-func findOldContent(ds map[uuid.UUID]map[uuid.UUID]string, lineID uuid.UUID) string {
-	for _, linesMap := range ds {
-		if txt, ok := linesMap[lineID]; ok {
-			return txt
-		}
-	}
-	return ""
-}
-
 // ListCommits returns all commits in a stream, sorted by timestamp
 func ListCommits(repoPath, stream string) ([]types.Commit, error) {
-	commitDir := filepath.Join(repoPath, ".evo", "commits", stream)
-	entries, err := os.ReadDir(commitDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to read commit directory: %w", err)
-	}
-
 	var commits []types.Commit
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".bin") {
-			commit, err := LoadCommit(repoPath, stream, strings.TrimSuffix(entry.Name(), ".bin"))
-			if err != nil {
-				return nil, fmt.Errorf("failed to load commit %s: %w", entry.Name(), err)
-			}
-			commits = append(commits, *commit)
-		}
-	}
-
-	// Sort by timestamp
-	sort.Slice(commits, func(i, j int) bool {
-		return commits[i].Timestamp.Before(commits[j].Timestamp)
+	err := ForEachCommit(repoPath, stream, IterOptions{Verify: true}, func(c *types.Commit) error {
+		commits = append(commits, *c)
+		return nil
 	})
-
-	return commits, nil
-}
-
-func saveCommit(repoPath string, c *types.Commit) error {
-	dir := filepath.Join(repoPath, ".evo", "commits", c.Stream)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-	fp := filepath.Join(dir, c.ID+".bin")
-	b, _ := json.Marshal(c)
-	sz := make([]byte, 4)
-	binary.BigEndian.PutUint32(sz, uint32(len(b)))
-	f, err := os.Create(fp)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	f.Write(sz)
-	f.Write(b)
-	return nil
-}
-
-func SaveCommitFile(dir string, c *types.Commit) error {
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-	fp := filepath.Join(dir, c.ID+".bin")
-	b, _ := json.Marshal(c)
-	sz := make([]byte, 4)
-	binary.BigEndian.PutUint32(sz, uint32(len(b)))
-	f, err := os.Create(fp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	f.Write(sz)
-	f.Write(b)
-	return nil
+	return commits, nil
 }
 
-func loadCommit(fp string) (*types.Commit, error) {
-	f, err := os.Open(fp)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	szBuf := make([]byte, 4)
-	if _, err := f.Read(szBuf); err != nil {
-		return nil, err
-	}
-	sz := binary.BigEndian.Uint32(szBuf)
-	data := make([]byte, sz)
-	if _, err := f.Read(data); err != nil {
-		return nil, err
-	}
-	var c types.Commit
-	if err := json.Unmarshal(data, &c); err != nil {
-		return nil, err
-	}
-	return &c, nil
+// ValidID reports whether id can name a commit file: commits from
+// elsewhere (pushes, pulls, dumps) must not name one outside their directory
+func ValidID(id string) bool {
+	return id != "" && filepath.Base(id) == id && !strings.HasPrefix(id, ".")
 }
 
 // RevertCommit creates a new commit that reverts the changes in the specified commit
@@ -310,11 +178,22 @@ func RevertCommit(repoPath, stream, commitID string) (*types.Commit, error) {
 		return nil, fmt.Errorf("failed to load commit %s: %w", commitID, err)
 	}
 
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+
 	// Generate inverse operations
-	inverted, err := invertOps(target.Operations)
+	inverted, err := invertOps(target.Operations, self)
 	if err != nil {
 		return nil, fmt.Errorf("failed to invert operations: %w", err)
 	}
+	if err := self.Save(); err != nil {
+		return nil, err
+	}
+	for i := range inverted {
+		inverted[i].Op.Stream = stream
+	}
 
 	// Create revert commit
 	revert := &types.Commit{
@@ -327,7 +206,10 @@ func RevertCommit(repoPath, stream, commitID string) (*types.Commit, error) {
 		Operations:  inverted,
 	}
 
-	// Save revert commit
+	// Apply the inverse ops like any other change, then record them
+	if err := ApplyOps(repoPath, stream, inverted); err != nil {
+		return nil, fmt.Errorf("failed to apply revert operations: %w", err)
+	}
 	if err := SaveCommit(repoPath, revert); err != nil {
 		return nil, fmt.Errorf("failed to save revert commit: %w", err)
 	}
@@ -335,13 +217,117 @@ func RevertCommit(repoPath, stream, commitID string) (*types.Commit, error) {
 	return revert, nil
 }
 
+// ResolveRevertTargets expands commit IDs and "A..B" ranges into the commits
+// to revert, ordered oldest to newest. A range covers the commits after A up to
+// and including B, as listed in the stream.
+func ResolveRevertTargets(repoPath, stream string, specs []string) ([]types.Commit, error) {
+	all, err := ListCommits(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	pos := make(map[string]int, len(all))
+	for i, c := range all {
+		pos[c.ID] = i
+	}
+	lookup := func(id string) (int, error) {
+		i, ok := pos[id]
+		if !ok {
+			return 0, everrors.Errorf(everrors.ErrNotFound, "commit %s not found in stream %s", id, stream)
+		}
+		return i, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, spec := range specs {
+		if from, to, ok := strings.Cut(spec, ".."); ok {
+			start, err := lookup(from)
+			if err != nil {
+				return nil, err
+			}
+			end, err := lookup(to)
+			if err != nil {
+				return nil, err
+			}
+			if start >= end {
+				return nil, fmt.Errorf("empty revert range %s: %s is not older than %s", spec, from, to)
+			}
+			for i := start + 1; i <= end; i++ {
+				seen[i] = true
+			}
+			continue
+		}
+		i, err := lookup(spec)
+		if err != nil {
+			return nil, err
+		}
+		seen[i] = true
+	}
+
+	var out []types.Commit
+	for i, c := range all {
+		if seen[i] {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// RevertCommits creates one revert commit per target commit, reverting the
+// newest first so later changes are undone before the ones they build on
+func RevertCommits(repoPath, stream string, specs []string) ([]*types.Commit, error) {
+	targets, err := ResolveRevertTargets(repoPath, stream, specs)
+	if err != nil {
+		return nil, err
+	}
+	var out []*types.Commit
+	for i := len(targets) - 1; i >= 0; i-- {
+		rc, err := RevertCommit(repoPath, stream, targets[i].ID)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, rc)
+	}
+	return out, nil
+}
+
+// StageRevert stages the inverse ops of the target commits, newest first,
+// without committing them. It returns the number of ops staged.
+func StageRevert(repoPath, stream string, specs []string) (int, error) {
+	targets, err := ResolveRevertTargets(repoPath, stream, specs)
+	if err != nil {
+		return 0, err
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	var inverted []ExtendedOp
+	for i := len(targets) - 1; i >= 0; i-- {
+		inv, err := invertOps(targets[i].Operations, self)
+		if err != nil {
+			return 0, fmt.Errorf("failed to invert commit %s: %w", targets[i].ID, err)
+		}
+		inverted = append(inverted, inv...)
+	}
+	if err := self.Save(); err != nil {
+		return 0, err
+	}
+	if err := StageOps(repoPath, stream, inverted); err != nil {
+		return 0, err
+	}
+	return len(inverted), nil
+}
+
 // invertOps generates inverse operations for a commit
-func invertOps(ops []types.ExtendedOp) ([]types.ExtendedOp, error) {
+func invertOps(ops []types.ExtendedOp, self *node.Node) ([]types.ExtendedOp, error) {
 	var inverted []types.ExtendedOp
+	nodeID := self.ID
 
 	// Process operations in reverse order
 	for i := len(ops) - 1; i >= 0; i-- {
 		op := ops[i]
+		self.Observe(op.Op)
+		lamport := self.Tick()
 		switch op.Op.Type {
 		case crdt.OpInsert:
 			// Invert insert -> delete
@@ -349,20 +335,30 @@ func invertOps(ops []types.ExtendedOp) ([]types.ExtendedOp, error) {
 				Op: crdt.Operation{
 					Type:      crdt.OpDelete,
 					LineID:    op.Op.LineID,
+					FileID:    op.Op.FileID,
+					NodeID:    nodeID,
+					Lamport:   lamport,
 					Timestamp: time.Now(),
 				},
 			})
 
 		case crdt.OpDelete:
 			// Invert delete -> insert with original content
-			if op.Op.Content == "" {
+			content := op.Op.Content
+			if content == "" {
+				content = op.OldContent
+			}
+			if content == "" {
 				return nil, fmt.Errorf("cannot revert delete operation: missing original content")
 			}
 			inverted = append(inverted, types.ExtendedOp{
 				Op: crdt.Operation{
 					Type:      crdt.OpInsert,
 					LineID:    op.Op.LineID,
-					Content:   op.Op.Content,
+					FileID:    op.Op.FileID,
+					NodeID:    nodeID,
+					Lamport:   lamport,
+					Content:   content,
 					Timestamp: time.Now(),
 				},
 			})
@@ -376,6 +372,9 @@ func invertOps(ops []types.ExtendedOp) ([]types.ExtendedOp, error) {
 				Op: crdt.Operation{
 					Type:      crdt.OpUpdate,
 					LineID:    op.Op.LineID,
+					FileID:    op.Op.FileID,
+					NodeID:    nodeID,
+					Lamport:   lamport,
 					Content:   op.OldContent,
 					Timestamp: time.Now(),
 				},
@@ -387,26 +386,61 @@ func invertOps(ops []types.ExtendedOp) ([]types.ExtendedOp, error) {
 	return inverted, nil
 }
 
-func newLamport() uint64 {
-	return uint64(time.Now().UnixNano())
-}
-
-func applyOps(repoPath, stream string, eops []ExtendedOp) error {
-	// for each extended op, append to .evo/ops/<stream>/<fileID>.bin
-	opsRoot := filepath.Join(repoPath, ".evo", "ops", stream)
+// ApplyOps stamps new local ops with vector clocks, appends them to
+// .evo/ops/<stream>/<fileID>.bin and, when stream is the checked-out stream,
+// rewrites the affected files in the working tree. Ops without a stream are
+// taken as the stream's; none is written unless all pass validation.
+func ApplyOps(repoPath, stream string, eops []ExtendedOp) error {
+	for i := range eops {
+		if eops[i].Op.Stream == "" {
+			eops[i].Op.Stream = stream
+		}
+	}
+	if err := validate.Ops(repoPath, stream, validate.Local(stream), eops); err != nil {
+		return err
+	}
+	opsRoot := filepath.Join(repo.Dir(repoPath), "ops", stream)
 	if err := os.MkdirAll(opsRoot, 0755); err != nil {
 		return err
 	}
-	for _, eop := range eops {
+	stamper := ops.NewStamper(repoPath, stream)
+	touched := make(map[string]bool)
+	var order []string
+	for i := range eops {
+		eop := &eops[i]
+		if err := stamper.Stamp(&eop.Op); err != nil {
+			return err
+		}
 		fid := eop.Op.FileID.String()
 		binFile := filepath.Join(opsRoot, fid+".bin")
-		if err := ops.AppendOp(binFile, eop.Op); err != nil {
+		if err := ops.AppendRef(binFile, eop.Op); err != nil {
 			return err
 		}
+		logger.Trace("appended op", "stream", stream, "file", fid, "type", eop.Op.Type, "lamport", eop.Op.Lamport, "line", eop.Op.LineID)
+		if !touched[fid] {
+			touched[fid] = true
+			order = append(order, fid)
+		}
+	}
+	logger.Debug("applied ops", "stream", stream, "ops", len(eops), "files", len(order))
+	if !isCurrentStream(repoPath, stream) {
+		return nil
+	}
+	for _, fid := range order {
+		if err := materialize.WriteFile(repoPath, stream, fid); err != nil {
+			return fmt.Errorf("failed to update working tree: %w", err)
+		}
 	}
 	return nil
 }
 
+// isCurrentStream reports whether the working tree follows stream; a
+// detached checkout doesn't
+func isCurrentStream(repoPath, stream string) bool {
+	head, err := repo.ReadHead(repoPath)
+	return err == nil && head.Stream == stream && head.Detached == ""
+}
+
 // For signing
 func CommitHashString(c *types.Commit) string {
 	// stable representation => ID + stream + message + etc
@@ -417,6 +451,9 @@ func CommitHashString(c *types.Commit) string {
 	h.Write([]byte(c.AuthorName))
 	h.Write([]byte(c.AuthorEmail))
 	h.Write([]byte(c.Timestamp.String()))
+	for _, t := range c.Trailers {
+		h.Write([]byte(t.Key + ": " + t.Value + "\n"))
+	}
 	for _, eop := range c.Operations {
 		// incorporate lamport, node, lineID, content, oldContent
 		h.Write([]byte(fmt.Sprintf("%d_%s_%s_%s_old=%s",
@@ -2,23 +2,65 @@ package commits
 
 import (
 	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/ops"
 	"evo/internal/types"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/google/uuid"
+
 	"evo/internal/config"
 	"evo/internal/signing"
 )
 
+// logged gives ops on a tracked file the IDs they lack and applies them, as
+// the ops of a commit are before it is made
+func logged(t *testing.T, repoPath string, eops ...types.ExtendedOp) []types.ExtendedOp {
+	t.Helper()
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, ok := p2id["file.txt"]
+	if !ok {
+		fid = uuid.NewString()
+		if err := os.MkdirAll(filepath.Join(repoPath, ".evo"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := index.SaveIndex(repoPath, map[string]string{"file.txt": fid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := materialize.Load(repoPath, "main", fid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range eops {
+		op := &eops[i].Op
+		op.FileID, op.NodeID, op.Lamport = uuid.MustParse(fid), uuid.New(), doc.Lamport+uint64(i)+1
+		if op.LineID == uuid.Nil {
+			op.LineID = uuid.New()
+		}
+		if op.Type == crdt.OpInsert && op.OriginLineID == uuid.Nil {
+			op.OriginLineID = crdt.DocumentStart
+		}
+	}
+	if err := ApplyOps(repoPath, "main", eops); err != nil {
+		t.Fatal(err)
+	}
+	return eops
+}
+
 func TestRevertCommit(t *testing.T) {
 	// Create temp directory for test
 	testDir := t.TempDir()
 
 	t.Run("Revert_Insert", func(t *testing.T) {
 		// Create original commit with insert operation
-		ops := []types.ExtendedOp{
-			{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test"}},
-		}
+		ops := logged(t, testDir, types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test"}})
 		commit, err := CreateCommit(testDir, "main", "Test commit", "Test User", "test@example.com", ops, false)
 		if err != nil {
 			t.Fatalf("Failed to create commit: %v", err)
@@ -43,9 +85,8 @@ func TestRevertCommit(t *testing.T) {
 
 	t.Run("Revert_Delete", func(t *testing.T) {
 		// Create original commit with delete operation
-		ops := []types.ExtendedOp{
-			{Op: crdt.Operation{Type: crdt.OpDelete, Content: "test"}},
-		}
+		inserted := logged(t, testDir, types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test"}})
+		ops := logged(t, testDir, types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpDelete, LineID: inserted[0].Op.LineID, Content: "test"}})
 		commit, err := CreateCommit(testDir, "main", "Test commit", "Test User", "test@example.com", ops, false)
 		if err != nil {
 			t.Fatalf("Failed to create commit: %v", err)
@@ -75,15 +116,15 @@ func TestRevertCommit(t *testing.T) {
 		// Create original commit with update operation
 		oldContent := "old"
 		newContent := "new"
-		ops := []types.ExtendedOp{
-			{
-				Op: crdt.Operation{
-					Type:    crdt.OpUpdate,
-					Content: newContent,
-				},
-				OldContent: oldContent,
+		inserted := logged(t, testDir, types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: oldContent}})
+		ops := logged(t, testDir, types.ExtendedOp{
+			Op: crdt.Operation{
+				Type:    crdt.OpUpdate,
+				LineID:  inserted[0].Op.LineID,
+				Content: newContent,
 			},
-		}
+			OldContent: oldContent,
+		})
 		commit, err := CreateCommit(testDir, "main", "Test commit", "Test User", "test@example.com", ops, false)
 		if err != nil {
 			t.Fatalf("Failed to create commit: %v", err)
@@ -111,10 +152,10 @@ func TestRevertCommit(t *testing.T) {
 
 	t.Run("Revert_Multiple_Operations", func(t *testing.T) {
 		// Create original commit with multiple operations
-		ops := []types.ExtendedOp{
-			{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test1"}},
-			{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test2"}},
-		}
+		ops := logged(t, testDir,
+			types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test1"}},
+			types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: "test2"}},
+		)
 		commit, err := CreateCommit(testDir, "main", "Test commit", "Test User", "test@example.com", ops, false)
 		if err != nil {
 			t.Fatalf("Failed to create commit: %v", err)
@@ -228,3 +269,196 @@ func TestSignedCommits(t *testing.T) {
 		}
 	})
 }
+
+func TestRevertRanges(t *testing.T) {
+	testDir := t.TempDir()
+
+	var ids []string
+	for _, content := range []string{"one", "two", "three"} {
+		ops := logged(t, testDir, types.ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Content: content}})
+		c, err := CreateCommit(testDir, "main", "add "+content, "Test User", "test@example.com", ops, false)
+		if err != nil {
+			t.Fatalf("Failed to create commit: %v", err)
+		}
+		ids = append(ids, c.ID)
+	}
+
+	t.Run("Resolve_Range", func(t *testing.T) {
+		targets, err := ResolveRevertTargets(testDir, "main", []string{ids[0] + ".." + ids[2]})
+		if err != nil {
+			t.Fatalf("Failed to resolve range: %v", err)
+		}
+		if len(targets) != 2 || targets[0].ID != ids[1] || targets[1].ID != ids[2] {
+			t.Errorf("Expected commits 2 and 3, got %v", targets)
+		}
+	})
+
+	t.Run("Invalid_Range", func(t *testing.T) {
+		if _, err := ResolveRevertTargets(testDir, "main", []string{ids[2] + ".." + ids[0]}); err == nil {
+			t.Error("Expected error for reversed range")
+		}
+	})
+
+	t.Run("Stage_No_Commit", func(t *testing.T) {
+		n, err := StageRevert(testDir, "main", []string{ids[0], ids[2]})
+		if err != nil {
+			t.Fatalf("Failed to stage revert: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("Expected 2 staged ops, got %d", n)
+		}
+		staged, err := LoadStaged(testDir, "main")
+		if err != nil {
+			t.Fatalf("Failed to load staged ops: %v", err)
+		}
+		// newest commit is inverted first
+		if staged[0].Op.Type != crdt.OpDelete {
+			t.Error("Expected delete operation in staged revert")
+		}
+		all, _ := ListCommits(testDir, "main")
+		if len(all) != 3 {
+			t.Errorf("Expected no new commits, got %d", len(all))
+		}
+		if err := ClearStaged(testDir, "main"); err != nil {
+			t.Fatalf("Failed to clear staged ops: %v", err)
+		}
+		staged, _ = LoadStaged(testDir, "main")
+		if len(staged) != 0 {
+			t.Error("Expected staging area to be empty")
+		}
+	})
+
+	t.Run("Revert_Multiple", func(t *testing.T) {
+		created, err := RevertCommits(testDir, "main", []string{ids[1], ids[2]})
+		if err != nil {
+			t.Fatalf("Failed to revert commits: %v", err)
+		}
+		if len(created) != 2 {
+			t.Fatalf("Expected 2 revert commits, got %d", len(created))
+		}
+		if created[0].Message != "Revert commit "+ids[2] {
+			t.Errorf("Expected newest commit reverted first, got %q", created[0].Message)
+		}
+	})
+}
+
+func TestRevertUpdatesWorkingTree(t *testing.T) {
+	repoPath := t.TempDir()
+	evoDir := filepath.Join(repoPath, ".evo")
+	if err := os.MkdirAll(filepath.Join(evoDir, "ops", "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(evoDir, "HEAD"), []byte("main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileID := uuid.New()
+	if err := os.WriteFile(filepath.Join(evoDir, "index"), []byte(fileID.String()+" a.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeID := uuid.New()
+	base := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, FileID: fileID, LineID: uuid.New(), Content: "keep"}
+	added := crdt.Operation{Type: crdt.OpInsert, Lamport: 2, NodeID: nodeID, FileID: fileID, LineID: uuid.New(), Content: "drop"}
+	if err := ApplyOps(repoPath, "main", []ExtendedOp{{Op: base}, {Op: added}}); err != nil {
+		t.Fatalf("Failed to apply ops: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(repoPath, "a.txt"))
+	if string(data) != "keep\ndrop" {
+		t.Fatalf("Unexpected working tree content %q", data)
+	}
+
+	commit, err := CreateCommit(repoPath, "main", "add line", "Test User", "test@example.com", []ExtendedOp{{Op: added}}, false)
+	if err != nil {
+		t.Fatalf("Failed to create commit: %v", err)
+	}
+	if _, err := RevertCommit(repoPath, "main", commit.ID); err != nil {
+		t.Fatalf("Failed to revert commit: %v", err)
+	}
+
+	logged, err := ops.LoadAllOps(filepath.Join(evoDir, "ops", "main", fileID.String()+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 3 || logged[2].Type != crdt.OpDelete {
+		t.Errorf("Expected inverse delete appended to op log, got %d ops", len(logged))
+	}
+	data, _ = os.ReadFile(filepath.Join(repoPath, "a.txt"))
+	if string(data) != "keep" {
+		t.Errorf("Expected working tree to be reverted, got %q", data)
+	}
+}
+
+func TestGatherNewOps(t *testing.T) {
+	rp := t.TempDir()
+	fid, nid := uuid.New(), uuid.New()
+	line := uuid.New()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.SaveIndex(rp, map[string]string{"one.txt": fid.String()}); err != nil {
+		t.Fatal(err)
+	}
+	insert := ExtendedOp{Op: crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nid, FileID: fid, LineID: line, OriginLineID: crdt.DocumentStart, Content: "one"}}
+	if err := ApplyOps(rp, "main", []ExtendedOp{insert}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := GatherNewOps(rp, "main")
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected the uncommitted insert, got %v (%v)", pending, err)
+	}
+	if _, err := CreateCommit(rp, "main", "first", "Ann", "ann@example.com", pending, false); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ = GatherNewOps(rp, "main"); len(pending) != 0 {
+		t.Fatalf("Expected nothing new after committing, got %v", pending)
+	}
+
+	update := ExtendedOp{Op: crdt.Operation{Type: crdt.OpUpdate, Lamport: 2, NodeID: nid, FileID: fid, LineID: line, Content: "ONE"}}
+	if err := ApplyOps(rp, "main", []ExtendedOp{update}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = GatherNewOps(rp, "main")
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected the uncommitted update, got %v (%v)", pending, err)
+	}
+	if pending[0].OldContent != "one" || pending[0].Op.Content != "ONE" {
+		t.Errorf("Expected update from one to ONE, got %q to %q", pending[0].OldContent, pending[0].Op.Content)
+	}
+}
+
+func TestCommitHostilePath(t *testing.T) {
+	repoPath := t.TempDir()
+	evoDir := filepath.Join(repoPath, ".evo")
+	if err := os.MkdirAll(filepath.Join(evoDir, "ops", "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(evoDir, "HEAD"), []byte("main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileID := uuid.New()
+	name := "dir with space/new\nline:\xe9.txt"
+	if err := index.SaveIndex(repoPath, map[string]string{name: fileID.String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeID := uuid.New()
+	op := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, FileID: fileID, LineID: uuid.New(), Content: "line"}
+	if err := ApplyOps(repoPath, "main", []ExtendedOp{{Op: op}}); err != nil {
+		t.Fatalf("Failed to apply ops: %v", err)
+	}
+	abs := filepath.Join(repoPath, filepath.FromSlash(name))
+	if data, err := os.ReadFile(abs); err != nil || string(data) != "line" {
+		t.Fatalf("Expected the op written to %q, got %q, %v", name, data, err)
+	}
+
+	commit, err := CreateCommit(repoPath, "main", "add", "Test User", "test@example.com", []ExtendedOp{{Op: op}}, false)
+	if err != nil {
+		t.Fatalf("Failed to create commit: %v", err)
+	}
+	if _, err := RevertCommit(repoPath, "main", commit.ID); err != nil {
+		t.Fatalf("Failed to revert commit: %v", err)
+	}
+	if _, err := os.Stat(abs); !os.IsNotExist(err) {
+		t.Errorf("Expected %q removed by the revert, got %v", name, err)
+	}
+}
@@ -0,0 +1,86 @@
+package commits
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"evo/internal/everrors"
+	"evo/internal/types"
+	"fmt"
+)
+
+// A commit file is a one-line header followed by the commit as JSON:
+//
+//	evo-commit 1 sha256:<hex digest of the JSON>
+//	{"id":"...",...}
+//
+// Files written before the header existed are either plain JSON or JSON
+// behind a 4-byte big-endian size; they are still read, with nothing to verify.
+
+// FormatVersion is the commit file version written by this build
+const FormatVersion = 1
+
+const formatMagic = "evo-commit"
+
+// ErrCorrupt is returned for commit files whose content does not match their
+// recorded hash or cannot be parsed
+var ErrCorrupt = everrors.New(everrors.ErrCorrupt, "corrupt commit file")
+
+// EncodeCommit returns the commit file content of a commit
+func EncodeCommit(c *types.Commit) ([]byte, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal commit: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %d sha256:%x\n", formatMagic, FormatVersion, sum)
+	b.Write(payload)
+	return b.Bytes(), nil
+}
+
+// DecodeCommit parses commit file content in the current or a legacy format,
+// verifying the hash when the file has one
+func DecodeCommit(data []byte) (*types.Commit, error) {
+	var payload []byte
+	switch {
+	case bytes.HasPrefix(data, []byte(formatMagic+" ")):
+		header, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			return nil, fmt.Errorf("%w: missing header line", ErrCorrupt)
+		}
+		var version int
+		var digest string
+		if _, err := fmt.Sscanf(string(header), formatMagic+" %d sha256:%s", &version, &digest); err != nil {
+			return nil, fmt.Errorf("%w: bad header %q", ErrCorrupt, header)
+		}
+		if version > FormatVersion {
+			return nil, fmt.Errorf("commit format version %d is newer than this evo supports (%d)", version, FormatVersion)
+		}
+		want, err := hex.DecodeString(digest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad hash %q", ErrCorrupt, digest)
+		}
+		if sum := sha256.Sum256(rest); !bytes.Equal(sum[:], want) {
+			return nil, fmt.Errorf("%w: hash mismatch", ErrCorrupt)
+		}
+		payload = rest
+	case len(data) > 0 && data[0] == '{':
+		payload = data
+	case len(data) >= 4:
+		sz := binary.BigEndian.Uint32(data[:4])
+		if int64(sz) > int64(len(data)-4) {
+			return nil, fmt.Errorf("%w: truncated", ErrCorrupt)
+		}
+		payload = data[4 : 4+sz]
+	default:
+		return nil, fmt.Errorf("%w: truncated", ErrCorrupt)
+	}
+	var c types.Commit
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &c, nil
+}
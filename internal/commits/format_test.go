@@ -0,0 +1,81 @@
+package commits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitFormat(t *testing.T) {
+	c := &types.Commit{ID: "c1", Stream: "main", Message: "work", AuthorName: "Ann", Timestamp: time.Now().UTC()}
+	payload, err := json.Marshal(c)
+	assert.NoError(t, err)
+
+	t.Run("Round_Trip", func(t *testing.T) {
+		data, err := EncodeCommit(c)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(data, []byte("evo-commit 1 sha256:")))
+		got, err := DecodeCommit(data)
+		assert.NoError(t, err)
+		assert.Equal(t, c.ID, got.ID)
+		assert.Equal(t, c.Message, got.Message)
+		assert.True(t, c.Timestamp.Equal(got.Timestamp))
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		got, err := DecodeCommit(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, "c1", got.ID)
+
+		prefixed := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		got, err = DecodeCommit(append(prefixed, payload...))
+		assert.NoError(t, err)
+		assert.Equal(t, "c1", got.ID)
+
+		_, err = DecodeCommit(append(prefixed, payload[:10]...))
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		data, err := EncodeCommit(c)
+		assert.NoError(t, err)
+		tampered := bytes.Replace(data, []byte(`"work"`), []byte(`"evil"`), 1)
+		_, err = DecodeCommit(tampered)
+		assert.ErrorIs(t, err, ErrCorrupt)
+
+		_, err = DecodeCommit([]byte("evo-commit 1 sha256:zz\n{}"))
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("Newer_Version", func(t *testing.T) {
+		_, err := DecodeCommit([]byte("evo-commit 9 sha256:00\n{}"))
+		assert.ErrorContains(t, err, "newer")
+	})
+
+	t.Run("All_Readers", func(t *testing.T) {
+		rp := t.TempDir()
+		dir := filepath.Join(rp, ".evo", "commits", "main")
+		assert.NoError(t, SaveCommitTo(rp, "main", c))
+		old := &types.Commit{ID: "c0", Stream: "main", Timestamp: c.Timestamp.Add(-time.Hour)}
+		b, _ := json.Marshal(old)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "c0.bin"), b, 0644))
+
+		got, err := LoadCommit(rp, "main", "c1")
+		assert.NoError(t, err)
+		assert.Equal(t, "work", got.Message)
+		all, err := ListCommits(rp, "main")
+		assert.NoError(t, err)
+		if assert.Len(t, all, 2) {
+			assert.Equal(t, "c0", all[0].ID)
+		}
+		_, err = os.Stat(filepath.Join(dir, "c1.bin.tmp"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
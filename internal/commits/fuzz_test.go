@@ -0,0 +1,59 @@
+package commits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"evo/internal/crdt"
+	"evo/internal/types"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FuzzDecodeCommit decodes arbitrary bytes as a commit file: it may fail but
+// not panic, what it decodes encodes to a file that decodes the same, and
+// the commit index reads that file's entry as the commit it holds
+func FuzzDecodeCommit(f *testing.F) {
+	c := &types.Commit{ID: "c1", Stream: "main", Message: "work", AuthorName: "Ann", Timestamp: time.Unix(1, 0).UTC(),
+		Operations: []types.ExtendedOp{{Op: crdt.Operation{Type: crdt.OpInsert, Lamport: 1, LineID: uuid.New(), Content: "x"}}}}
+	data, err := EncodeCommit(c)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	payload, _ := json.Marshal(c)
+	f.Add(payload)
+	f.Add(binary.BigEndian.AppendUint32(nil, uint32(len(payload))))
+	f.Add([]byte("evo-commit 1 sha256:00\n{}"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readEntry(bytes.NewReader(data))
+		c, err := DecodeCommit(data)
+		if err != nil {
+			return
+		}
+		first, err := EncodeCommit(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := DecodeCommit(first)
+		if err != nil {
+			t.Fatalf("re-encoded commit does not decode: %v", err)
+		}
+		second, err := EncodeCommit(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Fatalf("commit changed in a round trip:\n%s\n%s", first, second)
+		}
+		e, err := readEntry(bytes.NewReader(first))
+		if err != nil {
+			t.Fatalf("index can't read a valid commit: %v", err)
+		}
+		if e.ID != again.ID || e.Stream != again.Stream || !e.Timestamp.Equal(again.Timestamp) {
+			t.Fatalf("index entry %+v differs from commit", e)
+		}
+	})
+}
@@ -0,0 +1,396 @@
+package commits
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/storage"
+	"evo/internal/types"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// .evo/commit-index records what lookups need of each commit, so finding or
+// ordering commits does not read their files. After a header line it holds one
+// line per commit and stream:
+//
+//	<id> TAB <stream> TAB <timestamp> TAB <author email> TAB <author name>
+//
+// Saving a commit appends its line; a later line for the same stream and ID
+// replaces an earlier one. The commit files stay the truth: a stream is
+// checked against the files its store lists when first used, so entries of
+// removed files (undo, compaction) are dropped, files without an entry are
+// read, and an index found behind is rewritten. An entry whose file only an
+// alternate of the repository holds is a commit borrowed from it, and is kept.
+
+const indexMagic = "evo-commit-index 1"
+
+// IndexEntry is what the commit index records of a commit in one stream
+type IndexEntry struct {
+	ID          string
+	Stream      string
+	Timestamp   time.Time
+	AuthorName  string
+	AuthorEmail string
+}
+
+// Index is the commit index of a repository
+type Index struct {
+	repoPath string
+	store    storage.Backend
+	stored   map[string]map[string]IndexEntry // stream => ID => entry, as read
+	streams  map[string][]IndexEntry          // checked streams, oldest first
+	dirty    bool
+}
+
+func indexPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "commit-index")
+}
+
+// LoadIndex reads the commit index of a repository. A missing or damaged index
+// is rebuilt from the commit files as streams are used.
+func LoadIndex(repoPath string) (*Index, error) {
+	b, err := store(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	x := &Index{
+		repoPath: repoPath,
+		store:    b,
+		stored:   make(map[string]map[string]IndexEntry),
+		streams:  make(map[string][]IndexEntry),
+	}
+	f, err := os.Open(indexPath(repoPath))
+	if os.IsNotExist(err) {
+		return x, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit index: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	if !sc.Scan() || sc.Text() != indexMagic {
+		x.dirty = true
+		return x, nil
+	}
+	for sc.Scan() {
+		e, ok := parseEntry(sc.Text())
+		if !ok {
+			x.dirty = true
+			continue
+		}
+		if x.stored[e.Stream] == nil {
+			x.stored[e.Stream] = make(map[string]IndexEntry)
+		}
+		x.stored[e.Stream][e.ID] = e
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read commit index: %w", err)
+	}
+	return x, nil
+}
+
+// Stream returns the commits of a stream, oldest first
+func (x *Index) Stream(stream string) ([]IndexEntry, error) {
+	if es, ok := x.streams[stream]; ok {
+		return es, nil
+	}
+	known := x.stored[stream]
+	var es []IndexEntry
+	held := make(map[string]bool)
+	err := own(x.store).List("commits/"+stream+"/", func(key string, _ int64) error {
+		s, id, ok := splitKey(key)
+		if !ok || s != stream {
+			return nil
+		}
+		held[id] = true
+		e, ok := known[id]
+		if !ok {
+			f, err := x.store.Get(key)
+			if err == nil {
+				e, err = readEntry(f)
+				f.Close()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load commit %s.bin: %w", id, err)
+			}
+			e.ID, e.Stream = id, stream
+			x.dirty = true
+		}
+		es = append(es, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := x.store.(*storage.Overlay); ok {
+		for id, e := range known {
+			if held[id] {
+				continue
+			}
+			if _, err := x.store.Stat(fileKey(stream, id)); err == nil {
+				es = append(es, e)
+			}
+		}
+	}
+	if len(es) != len(known) {
+		x.dirty = true
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		return es[i].Timestamp.Before(es[j].Timestamp)
+	})
+	x.streams[stream] = es
+	return es, nil
+}
+
+// Find returns the entries of a commit in every stream holding it, by stream
+// name
+func (x *Index) Find(id string) ([]IndexEntry, error) {
+	names, err := x.streamNames()
+	if err != nil {
+		return nil, err
+	}
+	var out []IndexEntry
+	for _, name := range names {
+		es, err := x.Stream(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			if e.ID == id {
+				out = append(out, e)
+			}
+		}
+	}
+	return out, nil
+}
+
+// streamNames returns the streams the repository holds commit files of, and
+// those the index lists commits borrowed from an alternate of, sorted
+func (x *Index) streamNames() ([]string, error) {
+	seen := make(map[string]bool)
+	if _, ok := x.store.(*storage.Overlay); ok {
+		for name := range x.stored {
+			seen[name] = true
+		}
+	}
+	err := own(x.store).List("commits/", func(key string, _ int64) error {
+		if stream, _, ok := splitKey(key); ok {
+			seen[stream] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commit files: %w", err)
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// OwnCommits copies into the repository the commit files it reads from an
+// alternate, returning how many it copied
+func OwnCommits(repoPath string) (int, error) {
+	x, err := LoadIndex(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	names, err := x.streamNames()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, name := range names {
+		es, err := x.Stream(name)
+		if err != nil {
+			return n, err
+		}
+		for _, e := range es {
+			key := fileKey(e.Stream, e.ID)
+			if _, err := own(x.store).Stat(key); !errors.Is(err, storage.ErrNotFound) {
+				if err != nil {
+					return n, err
+				}
+				continue
+			}
+			r, err := x.store.Get(key)
+			if err != nil {
+				return n, err
+			}
+			err = own(x.store).Put(key, r)
+			r.Close()
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, x.Save()
+}
+
+// RefreshIndex checks every stream of the repository's commit index against
+// its directory and saves it, so the index lists every commit
+func RefreshIndex(repoPath string) error {
+	x, err := LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	names, err := x.streamNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := x.Stream(name); err != nil {
+			return err
+		}
+	}
+	return x.Save()
+}
+
+// Save rewrites the index if checking streams found it behind. Streams not
+// checked are kept as read.
+func (x *Index) Save() error {
+	if !x.dirty {
+		return nil
+	}
+	names := make([]string, 0, len(x.stored)+len(x.streams))
+	for s := range x.stored {
+		if _, ok := x.streams[s]; !ok {
+			names = append(names, s)
+		}
+	}
+	for s := range x.streams {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(indexMagic + "\n")
+	for _, s := range names {
+		es, ok := x.streams[s]
+		if !ok {
+			for _, e := range x.stored[s] {
+				es = append(es, e)
+			}
+			sort.Slice(es, func(i, j int) bool { return es[i].ID < es[j].ID })
+		}
+		for _, e := range es {
+			b.WriteString(formatEntry(e))
+		}
+	}
+	tmp := indexPath(x.repoPath) + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write commit index: %w", err)
+	}
+	if err := platform.Rename(tmp, indexPath(x.repoPath)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write commit index: %w", err)
+	}
+	x.dirty = false
+	return nil
+}
+
+// indexCommit appends the entry of a commit saved among the commits of
+// stream to the index
+func indexCommit(repoPath, stream string, c *types.Commit) error {
+	f, err := os.OpenFile(indexPath(repoPath), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var b strings.Builder
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		b.WriteString(indexMagic + "\n")
+	}
+	b.WriteString(formatEntry(IndexEntry{c.ID, stream, c.Timestamp, c.AuthorName, c.AuthorEmail}))
+	_, err = f.WriteString(b.String())
+	return err
+}
+
+var entryField = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+func formatEntry(e IndexEntry) string {
+	return strings.Join([]string{
+		e.ID, e.Stream, e.Timestamp.UTC().Format(time.RFC3339Nano),
+		entryField.Replace(e.AuthorEmail), entryField.Replace(e.AuthorName),
+	}, "\t") + "\n"
+}
+
+func parseEntry(line string) (IndexEntry, bool) {
+	f := strings.Split(line, "\t")
+	if len(f) != 5 || f[0] == "" || f[1] == "" {
+		return IndexEntry{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, f[2])
+	if err != nil {
+		return IndexEntry{}, false
+	}
+	return IndexEntry{ID: f[0], Stream: f[1], Timestamp: ts, AuthorEmail: f[3], AuthorName: f[4]}, true
+}
+
+// readEntry reads the index entry of a commit from the start of its file.
+// Commits are encoded with their fields in declaration order, so the
+// timestamp, which follows the author, comes before the ops.
+func readEntry(f io.Reader) (IndexEntry, error) {
+	var e IndexEntry
+	r := bufio.NewReader(f)
+	head, err := r.Peek(len(formatMagic) + 1)
+	switch {
+	case err == nil && bytes.Equal(head, []byte(formatMagic+" ")):
+		if _, err := r.ReadBytes('\n'); err != nil {
+			return e, fmt.Errorf("%w: missing header line", ErrCorrupt)
+		}
+	case len(head) > 0 && head[0] == '{':
+	case len(head) >= 4:
+		// legacy size prefix
+		r.Discard(4)
+	default:
+		return e, fmt.Errorf("%w: truncated", ErrCorrupt)
+	}
+
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return e, fmt.Errorf("%w: not a commit", ErrCorrupt)
+	}
+	fields := map[string]any{
+		"ID":          &e.ID,
+		"Stream":      &e.Stream,
+		"AuthorName":  &e.AuthorName,
+		"AuthorEmail": &e.AuthorEmail,
+		"Timestamp":   &e.Timestamp,
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return e, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		key, _ := tok.(string)
+		v, ok := fields[key]
+		if !ok {
+			v = new(json.RawMessage)
+		}
+		if err := dec.Decode(v); err != nil {
+			return e, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if key == "Timestamp" {
+			return e, nil
+		}
+	}
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return e, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	// a commit without a timestamp sorts first
+	return e, nil
+}
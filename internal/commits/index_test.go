@@ -0,0 +1,122 @@
+package commits
+
+import (
+	"evo/internal/config"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitIndex(t *testing.T) {
+	rp := t.TempDir()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	save := func(stream, id string, hours int) {
+		c := &types.Commit{ID: id, Stream: stream, AuthorName: "Ann\tLee", AuthorEmail: "ann@example.com", Timestamp: base.Add(time.Duration(hours) * time.Hour)}
+		assert.NoError(t, SaveCommitTo(rp, stream, c))
+	}
+	ids := func(es []IndexEntry) []string {
+		var out []string
+		for _, e := range es {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	save("main", "b", 2)
+	save("main", "a", 1)
+	save("feature", "a", 1)
+	save("feature", "c", 3)
+
+	data, err := os.ReadFile(indexPath(rp))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), indexMagic+"\n"))
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 5)
+
+	idx, err := LoadIndex(rp)
+	assert.NoError(t, err)
+	es, err := idx.Stream("main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids(es))
+	if assert.Len(t, es, 2) {
+		assert.Equal(t, "Ann Lee", es[0].AuthorName)
+		assert.True(t, base.Add(time.Hour).Equal(es[0].Timestamp))
+		c, err := ReadCommit(rp, "main", es[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "a", c.ID)
+	}
+	where, err := idx.Find("a")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"feature", "main"}, []string{where[0].Stream, where[1].Stream})
+	assert.False(t, idx.dirty)
+
+	t.Run("Catches_Up", func(t *testing.T) {
+		// a removed file (undo, compaction) and one written without the index
+		assert.NoError(t, os.Remove(filepath.Join(rp, ".evo", "commits", "main", "b.bin")))
+		c := &types.Commit{ID: "d", Stream: "main", AuthorEmail: "bo@example.com", Timestamp: base}
+		data, err := EncodeCommit(c)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "commits", "main", "d.bin"), data, 0644))
+
+		idx, err := LoadIndex(rp)
+		assert.NoError(t, err)
+		es, err := idx.Stream("main")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"d", "a"}, ids(es))
+		assert.Equal(t, "bo@example.com", es[0].AuthorEmail)
+		assert.NoError(t, idx.Save())
+
+		idx, err = LoadIndex(rp)
+		assert.NoError(t, err)
+		assert.Len(t, idx.stored["main"], 2)
+		assert.Len(t, idx.stored["feature"], 2, "unchecked streams are kept")
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(indexPath(rp), []byte("garbage\n"), 0644))
+		idx, err := LoadIndex(rp)
+		assert.NoError(t, err)
+		es, err := idx.Stream("feature")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, ids(es))
+		assert.NoError(t, idx.Save())
+		data, err := os.ReadFile(indexPath(rp))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), indexMagic+"\n"))
+	})
+}
+
+func TestCommitStorage(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, config.SetRepoConfigValue(rp, "commits.storage", "file:shared"))
+	c := &types.Commit{ID: "a", Stream: "main", AuthorName: "Ann", Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	assert.NoError(t, SaveCommitTo(rp, "main", c))
+
+	_, err := os.Stat(filepath.Join(rp, "shared", "commits", "main", "a.bin"))
+	assert.NoError(t, err, "the commit is kept in the configured storage")
+	_, err = os.Stat(filepath.Join(rp, ".evo", "commits", "main", "a.bin"))
+	assert.True(t, os.IsNotExist(err), "nothing is written to .evo/commits")
+
+	got, err := LoadCommit(rp, "main", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "Ann", got.AuthorName)
+	stored, err := Stored(rp)
+	assert.NoError(t, err)
+	assert.Contains(t, stored, "commits/main/a.bin")
+
+	// an index rebuilt from scratch lists what the storage holds
+	assert.NoError(t, os.Remove(indexPath(rp)))
+	idx, err := LoadIndex(rp)
+	assert.NoError(t, err)
+	es, err := idx.Stream("main")
+	assert.NoError(t, err)
+	assert.Len(t, es, 1)
+
+	assert.NoError(t, RemoveCommit(rp, "main", "a"))
+	ok, err := HasCommit(rp, "main", "a")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,158 @@
+package commits
+
+import (
+	"errors"
+	"evo/internal/signing"
+	"evo/internal/types"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SkipAll stops ForEachCommit without an error when returned by its callback
+var SkipAll = errors.New("skip all commits")
+
+// IterOptions selects and orders the commits of ForEachCommit. All filters
+// must match; only Grep needs the commit loaded to be decided.
+type IterOptions struct {
+	Reverse bool           // newest first
+	Since   time.Time      // skip commits older than this
+	Until   time.Time      // skip commits newer than this, if set
+	Author  string         // case-insensitive part of "name <email>"
+	Grep    *regexp.Regexp // matches the message
+	Verify  bool           // check signatures, as LoadCommit does
+}
+
+// Selects reports whether the filters decided from the index (all but Grep)
+// keep e
+func (o *IterOptions) Selects(e *IndexEntry) bool {
+	if e.Timestamp.Before(o.Since) || (!o.Until.IsZero() && e.Timestamp.After(o.Until)) {
+		return false
+	}
+	if o.Author != "" {
+		who := strings.ToLower(e.AuthorName + " <" + e.AuthorEmail + ">")
+		if !strings.Contains(who, strings.ToLower(o.Author)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ForEachCommit calls fn with each commit of a stream in timestamp order. The
+// commits are ordered by the commit index; each is loaded in full when its
+// turn comes, so stopping early with SkipAll reads no more.
+func ForEachCommit(repoPath, stream string, opts IterOptions, fn func(*types.Commit) error) error {
+	idx, err := LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	all, err := idx.Stream(stream)
+	if err != nil {
+		return err
+	}
+	if err := idx.Save(); err != nil {
+		logger.Warn("failed to update commit index", "error", err)
+	}
+	var entries []IndexEntry
+	for i := range all {
+		if opts.Selects(&all[i]) {
+			entries = append(entries, all[i])
+		}
+	}
+
+	for i := range entries {
+		e := entries[i]
+		if opts.Reverse {
+			e = entries[len(entries)-1-i]
+		}
+		c, err := idx.load(e, opts.Verify)
+		if err != nil {
+			return fmt.Errorf("failed to load commit %s.bin: %w", e.ID, err)
+		}
+		if opts.Grep != nil && !opts.Grep.MatchString(c.Message) {
+			continue
+		}
+		if err := fn(c); err != nil {
+			if err == SkipAll {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// CountCommits returns the number of commits of a stream ForEachCommit would
+// visit. Without Grep it reads only the commit index.
+func CountCommits(repoPath, stream string, opts IterOptions) (int, error) {
+	n := 0
+	if opts.Grep != nil {
+		err := ForEachCommit(repoPath, stream, opts, func(*types.Commit) error {
+			n++
+			return nil
+		})
+		return n, err
+	}
+	idx, err := LoadIndex(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	all, err := idx.Stream(stream)
+	if err != nil {
+		return 0, err
+	}
+	if err := idx.Save(); err != nil {
+		logger.Warn("failed to update commit index", "error", err)
+	}
+	for i := range all {
+		if opts.Selects(&all[i]) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// load reads the commit of an entry, verifying its signature if asked to
+func (x *Index) load(e IndexEntry, verify bool) (*types.Commit, error) {
+	c, err := readCommit(x.store, e.Stream, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	if verify && c.Signature != "" {
+		valid, err := signing.VerifyCommit(c, x.repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify commit signature: %w", err)
+		}
+		if !valid {
+			return nil, fmt.Errorf("commit signature verification failed")
+		}
+	}
+	return c, nil
+}
+
+// ParseTime parses the argument of --since or --until: a date (2006-01-02), a
+// date and time (RFC 3339 or 2006-01-02 15:04), or an age before now such as
+// 36h, 3d or 2w
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if n, unit := strings.TrimRight(s, "dw"), strings.TrimLeft(s, "0123456789"); n != s && (unit == "d" || unit == "w") {
+		days, err := strconv.Atoi(n)
+		if err == nil {
+			if unit == "w" {
+				days *= 7
+			}
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want a date, a date and time, or an age like 3d", s)
+}
@@ -0,0 +1,105 @@
+package commits
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachCommit(t *testing.T) {
+	rp := t.TempDir()
+	dir := filepath.Join(rp, ".evo", "commits", "main")
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// saved out of order, one of them in the legacy size-prefixed encoding
+	for _, i := range []int{3, 0, 4, 1} {
+		c := &types.Commit{ID: fmt.Sprintf("c%d", i), Stream: "main", Message: "work", Timestamp: base.Add(time.Duration(i) * time.Hour)}
+		assert.NoError(t, SaveCommitTo(rp, "main", c))
+	}
+	legacy, _ := json.Marshal(&types.Commit{ID: "c2", Stream: "main", Timestamp: base.Add(2 * time.Hour)})
+	prefixed := binary.BigEndian.AppendUint32(nil, uint32(len(legacy)))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "c2.bin"), append(prefixed, legacy...), 0644))
+
+	ids := func(opts IterOptions, limit int) []string {
+		var out []string
+		err := ForEachCommit(rp, "main", opts, func(c *types.Commit) error {
+			out = append(out, c.ID)
+			if len(out) == limit {
+				return SkipAll
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		return out
+	}
+	assert.Equal(t, []string{"c0", "c1", "c2", "c3", "c4"}, ids(IterOptions{}, 0))
+	assert.Equal(t, []string{"c4", "c3", "c2", "c1", "c0"}, ids(IterOptions{Reverse: true}, 0))
+	assert.Equal(t, []string{"c4", "c3"}, ids(IterOptions{Reverse: true}, 2))
+	assert.Equal(t, []string{"c2", "c3", "c4"}, ids(IterOptions{Since: base.Add(2 * time.Hour)}, 0))
+	assert.Empty(t, ids(IterOptions{Since: base.Add(5 * time.Hour)}, 0))
+
+	t.Run("Filters", func(t *testing.T) {
+		for i, a := range []string{"Ann <ann@example.com>", "Bo <bo@example.com>", "Ann <ann@example.com>", "Bo <bo@example.com>"} {
+			name, email, _ := strings.Cut(strings.TrimSuffix(a, ">"), " <")
+			c := &types.Commit{ID: fmt.Sprintf("f%d", i), Stream: "feature", Message: fmt.Sprintf("change %d", i), AuthorName: name, AuthorEmail: email, Timestamp: base.Add(time.Duration(i) * time.Hour)}
+			assert.NoError(t, SaveCommitTo(rp, "feature", c))
+		}
+		feature := func(opts IterOptions) []string {
+			var out []string
+			assert.NoError(t, ForEachCommit(rp, "feature", opts, func(c *types.Commit) error {
+				out = append(out, c.ID)
+				return nil
+			}))
+			n, err := CountCommits(rp, "feature", opts)
+			assert.NoError(t, err)
+			assert.Equal(t, len(out), n)
+			return out
+		}
+		assert.Equal(t, []string{"f1", "f3"}, feature(IterOptions{Author: "BO@"}))
+		assert.Equal(t, []string{"f0", "f1"}, feature(IterOptions{Until: base.Add(time.Hour)}))
+		assert.Equal(t, []string{"f2", "f3"}, feature(IterOptions{Grep: regexp.MustCompile(`[23]$`)}))
+		assert.Equal(t, []string{"f1"}, feature(IterOptions{Author: "bo", Until: base.Add(2 * time.Hour)}))
+		assert.Equal(t, []string{"f2"}, feature(IterOptions{Author: "ann", Since: base.Add(time.Hour), Grep: regexp.MustCompile("change")}))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		boom := fmt.Errorf("boom")
+		err := ForEachCommit(rp, "main", IterOptions{}, func(*types.Commit) error { return boom })
+		assert.ErrorIs(t, err, boom)
+		assert.NoError(t, ForEachCommit(rp, "none", IterOptions{}, func(*types.Commit) error { return boom }))
+
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "bad.bin"), []byte("evo-commit 1 sha256:00\n{"), 0644))
+		err = ForEachCommit(rp, "main", IterOptions{}, func(*types.Commit) error { return nil })
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	for in, want := range map[string]time.Time{
+		"2024-05-01":            time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		"2024-05-01 08:30":      time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local),
+		"2024-05-01T08:30:00Z":  time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC),
+		"3d":                    now.AddDate(0, 0, -3),
+		"2w":                    now.AddDate(0, 0, -14),
+		"36h":                   now.Add(-36 * time.Hour),
+		" 2024-05-01 08:30:15 ": time.Date(2024, 5, 1, 8, 30, 15, 0, time.Local),
+	} {
+		got, err := ParseTime(in, now)
+		if assert.NoError(t, err, in) {
+			assert.True(t, want.Equal(got), "%s: got %v, want %v", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "yesterday", "3x", "d"} {
+		_, err := ParseTime(in, now)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,134 @@
+package commits
+
+import (
+	"encoding/json"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Staged ops wait in .evo/staged/<stream>.json until the next commit picks them up,
+// and renames from evo mv in .evo/staged/<stream>.renames.json
+
+func stagedPath(repoPath, stream string) string {
+	return filepath.Join(repo.Dir(repoPath), "staged", stream+".json")
+}
+
+func renamesPath(repoPath, stream string) string {
+	return filepath.Join(repo.Dir(repoPath), "staged", stream+".renames.json")
+}
+
+// Rename is a tracked file moved to another path. Paths are local to the
+// index, so the commit records it as a trailer rather than an op.
+type Rename struct {
+	FileID string `json:"fileID"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// StageOps appends ops to the stream's staging area
+func StageOps(repoPath, stream string, eops []ExtendedOp) error {
+	staged, err := LoadStaged(repoPath, stream)
+	if err != nil {
+		return err
+	}
+	staged = append(staged, eops...)
+
+	fp := stagedPath(repoPath, stream)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	data, err := json.Marshal(staged)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staged ops: %w", err)
+	}
+	if err := os.WriteFile(fp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write staged ops: %w", err)
+	}
+	return nil
+}
+
+// LoadStaged returns the ops staged for the stream, if any
+func LoadStaged(repoPath, stream string) ([]ExtendedOp, error) {
+	data, err := os.ReadFile(stagedPath(repoPath, stream))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged ops: %w", err)
+	}
+	var staged []ExtendedOp
+	if err := json.Unmarshal(data, &staged); err != nil {
+		return nil, fmt.Errorf("failed to parse staged ops: %w", err)
+	}
+	return staged, nil
+}
+
+// StageRename records a rename for the next commit. Renaming a file again
+// updates its entry, and renaming it back drops it.
+func StageRename(repoPath, stream string, r Rename) error {
+	renames, err := LoadStagedRenames(repoPath, stream)
+	if err != nil {
+		return err
+	}
+	kept := renames[:0]
+	for _, have := range renames {
+		if have.FileID == r.FileID {
+			r.From = have.From
+			continue
+		}
+		kept = append(kept, have)
+	}
+	if r.From != r.To {
+		kept = append(kept, r)
+	}
+
+	if len(kept) == 0 {
+		return ClearStagedRenames(repoPath, stream)
+	}
+	fp := renamesPath(repoPath, stream)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staged renames: %w", err)
+	}
+	if err := os.WriteFile(fp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write staged renames: %w", err)
+	}
+	return nil
+}
+
+// LoadStagedRenames returns the renames staged for the stream, if any
+func LoadStagedRenames(repoPath, stream string) ([]Rename, error) {
+	data, err := os.ReadFile(renamesPath(repoPath, stream))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged renames: %w", err)
+	}
+	var renames []Rename
+	if err := json.Unmarshal(data, &renames); err != nil {
+		return nil, fmt.Errorf("failed to parse staged renames: %w", err)
+	}
+	return renames, nil
+}
+
+// ClearStaged discards the ops staged for the stream
+func ClearStaged(repoPath, stream string) error {
+	if err := os.Remove(stagedPath(repoPath, stream)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ClearStagedRenames discards the renames staged for the stream
+func ClearStagedRenames(repoPath, stream string) error {
+	if err := os.Remove(renamesPath(repoPath, stream)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package commits
+
+import (
+	"bytes"
+	"errors"
+	"evo/internal/config"
+	"evo/internal/repo"
+	"evo/internal/storage"
+	"evo/internal/types"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Commit files are kept through a storage.Backend under the keys
+// commits/<stream>/<id>.bin: by default the files of those paths in .evo,
+// read through to the repository's alternates, else the backend
+// commits.storage names, such as a bucket a server deployment keeps its
+// history in. The commit index stays in .evo and catches up with the
+// backend's listing as streams are used.
+
+// store returns the backend of a repository's commit files
+func store(repoPath string) (storage.Backend, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if spec, _ := cfg.Get("commits.storage"); spec != "" {
+		b, err := storage.Open(spec, repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open commit storage: %w", err)
+		}
+		return b, nil
+	}
+	own := storage.NewFS(repo.Dir(repoPath))
+	alts, err := repo.Alternates(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alternates: %w", err)
+	}
+	var lower []storage.Backend
+	for _, dir := range alts {
+		lower = append(lower, storage.NewFS(dir))
+	}
+	return storage.NewOverlay(own, lower...), nil
+}
+
+// own returns the part of a store the repository writes to, without its
+// alternates
+func own(b storage.Backend) storage.Backend {
+	if o, ok := b.(*storage.Overlay); ok {
+		return o.Backend
+	}
+	return b
+}
+
+// fileKey returns the key of a commit file
+func fileKey(stream, id string) string {
+	return "commits/" + stream + "/" + id + ".bin"
+}
+
+// readCommit reads a commit file written in any format
+func readCommit(b storage.Backend, stream, id string) (*types.Commit, error) {
+	r, err := b.Get(fileKey(stream, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit file: %w", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit file: %w", err)
+	}
+	c, err := DecodeCommit(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s.bin: %w", id, err)
+	}
+	return c, nil
+}
+
+// ReadCommit reads a commit of a stream without verifying its signature
+func ReadCommit(repoPath, stream, id string) (*types.Commit, error) {
+	b, err := store(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return readCommit(b, stream, id)
+}
+
+// SaveCommitTo saves a commit as <id>.bin among the commits of stream,
+// replacing any earlier file atomically
+func SaveCommitTo(repoPath, stream string, c *types.Commit) error {
+	if !ValidID(c.ID) {
+		return fmt.Errorf("invalid commit ID %q", c.ID)
+	}
+	if !repo.ValidStreamName(stream) {
+		return fmt.Errorf("invalid stream name %q", stream)
+	}
+	data, err := EncodeCommit(c)
+	if err != nil {
+		return err
+	}
+	b, err := store(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := own(b).Put(fileKey(stream, c.ID), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write commit file: %w", err)
+	}
+	// the index catches up with the store on its next use
+	if err := indexCommit(repoPath, stream, c); err != nil {
+		logger.Warn("failed to index commit", "id", c.ID, "error", err)
+	}
+	return nil
+}
+
+// HasCommit reports whether the repository holds the file of a commit
+// itself, rather than reading it from an alternate
+func HasCommit(repoPath, stream, id string) (bool, error) {
+	b, err := store(repoPath)
+	if err != nil {
+		return false, err
+	}
+	_, err = own(b).Stat(fileKey(stream, id))
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RemoveCommit deletes the file of a commit; a missing one is not an error
+func RemoveCommit(repoPath, stream, id string) error {
+	b, err := store(repoPath)
+	if err != nil {
+		return err
+	}
+	return own(b).Delete(fileKey(stream, id))
+}
+
+// RemoveStream deletes the commit files of a stream
+func RemoveStream(repoPath, stream string) error {
+	b, err := store(repoPath)
+	if err != nil {
+		return err
+	}
+	b = own(b)
+	return b.List("commits/"+stream+"/", func(key string, _ int64) error {
+		return b.Delete(key)
+	})
+}
+
+// Stored maps the key of every commit file the repository holds itself,
+// commits/<stream>/<id>.bin, to its size
+func Stored(repoPath string) (map[string]int64, error) {
+	b, err := store(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64)
+	err = own(b).List("commits/", func(key string, size int64) error {
+		if _, _, ok := splitKey(key); ok {
+			out[key] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commit files: %w", err)
+	}
+	return out, nil
+}
+
+// splitKey returns the stream and commit ID of a commit file's key
+func splitKey(key string) (stream, id string, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || parts[0] != "commits" || !strings.HasSuffix(parts[2], ".bin") {
+		return "", "", false
+	}
+	id = strings.TrimSuffix(parts[2], ".bin")
+	return parts[1], id, ValidID(id)
+}
@@ -2,134 +2,499 @@ package config
 
 import (
 	"encoding/json"
+	"evo/internal/repo"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pelletier/go-toml"
 )
 
-// For example: user.name, user.email, signing.keyPath, files.largeThreshold, verifySignatures
+// Config is read from three TOML layers, each overriding the one before:
+//
+//	system  /etc/evo/config.toml (or $EVO_CONFIG_SYSTEM)
+//	global  ~/.config/evo/config.toml (or $EVO_CONFIG_GLOBAL)
+//	repo    .evo/config/config.toml
+//
+// Keys are dotted paths such as user.name; see Schema for the known ones.
+
+// Scope names a config layer
+type Scope int
+
+const (
+	ScopeSystem Scope = iota
+	ScopeGlobal
+	ScopeRepo
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeSystem:
+		return "system"
+	case ScopeGlobal:
+		return "global"
+	default:
+		return "repo"
+	}
+}
+
+// ParseScope parses "system", "global" or "repo"
+func ParseScope(s string) (Scope, error) {
+	switch s {
+	case "system":
+		return ScopeSystem, nil
+	case "global":
+		return ScopeGlobal, nil
+	case "repo", "local":
+		return ScopeRepo, nil
+	}
+	return 0, fmt.Errorf("unknown config scope: %s (expected system, global or repo)", s)
+}
+
+func systemConfigPath() string {
+	if p := os.Getenv("EVO_CONFIG_SYSTEM"); p != "" {
+		return p
+	}
+	return filepath.Join("/etc", "evo", "config.toml")
+}
 
 func globalConfigPath() (string, error) {
+	if p := os.Getenv("EVO_CONFIG_GLOBAL"); p != "" {
+		return p, nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	cfgDir := filepath.Join(home, ".config", "evo")
-	if err := os.MkdirAll(cfgDir, 0755); err != nil {
-		return "", err
-	}
-	return filepath.Join(cfgDir, "config.toml"), nil
+	return filepath.Join(home, ".config", "evo", "config.toml"), nil
 }
 
 func repoConfigPath(repoPath string) string {
-	return filepath.Join(repoPath, ".evo", "config", "config.toml")
+	return filepath.Join(repo.Dir(repoPath), "config", "config.toml")
 }
 
-func loadToml(path string) (*toml.Tree, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		tree, err := toml.TreeFromMap(map[string]interface{}{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to create empty config: %w", err)
-		}
-		return tree, nil
+// legacyConfigPath is the JSON store older versions wrote signing.keyPath and
+// friends to. It is read as part of the repo layer and folded into the TOML
+// file on the next repo-level write.
+func legacyConfigPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "config.json")
+}
+
+func scopePath(repoPath string, scope Scope) (string, error) {
+	switch scope {
+	case ScopeSystem:
+		return systemConfigPath(), nil
+	case ScopeGlobal:
+		return globalConfigPath()
 	}
+	if repoPath == "" {
+		return "", fmt.Errorf("not in an evo repository")
+	}
+	return repoConfigPath(repoPath), nil
+}
+
+func loadToml(path string) (*toml.Tree, error) {
 	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return toml.TreeFromMap(map[string]interface{}{})
+	}
 	if err != nil {
 		return nil, err
 	}
-	return toml.LoadBytes(b)
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return tree, nil
 }
 
 func saveToml(tree *toml.Tree, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
 	return os.WriteFile(path, []byte(tree.String()), 0644)
 }
 
-// SetGlobalConfigValue sets key=val in ~/.config/evo/config.toml
-func SetGlobalConfigValue(key, val string) error {
-	gp, err := globalConfigPath()
+func loadLegacy(repoPath string) (map[string]string, error) {
+	data, err := os.ReadFile(legacyConfigPath(repoPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return m, nil
+}
+
+// Entry is one effective config value
+type Entry struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Scope  string `json:"scope"`
+	Path   string `json:"path"`
+	Stream string `json:"stream,omitempty"` // set when the value comes from a [stream.<name>] section
+}
+
+// Config is the merged view of all layers
+type Config struct {
+	values map[string]Entry
+	stream string
+}
+
+// maxIncludeDepth bounds includeIf chains so a file including itself fails
+// instead of recursing forever
+const maxIncludeDepth = 10
+
+// Load reads all layers for the current stream; repoPath may be empty
+// outside a repository
+func Load(repoPath string) (*Config, error) {
+	return LoadForStream(repoPath, currentStream(repoPath))
+}
+
+// LoadForStream reads all layers as seen from stream. In every file,
+// [stream.<name>] sections override plain keys when <name> is stream, and
+// [includeIf."<cond>"] sections pull in another file when cond holds:
+//
+//	[includeIf."repo:~/work/"]      repo path under ~/work (or a glob)
+//	path = "~/.config/evo/work.toml"
+//	[includeIf."stream:release/*"]  current stream matches the glob
+//	path = "release.toml"           relative to the including file
+func LoadForStream(repoPath, stream string) (*Config, error) {
+	c := &Config{values: make(map[string]Entry), stream: stream}
+	if err := c.layer(repoPath, ScopeSystem, systemConfigPath(), 0); err != nil {
+		return nil, err
+	}
+	if gp, err := globalConfigPath(); err == nil {
+		if err := c.layer(repoPath, ScopeGlobal, gp, 0); err != nil {
+			return nil, err
+		}
+	}
+	if repoPath != "" {
+		legacy, err := loadLegacy(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range legacy {
+			c.values[k] = Entry{Key: k, Value: v, Scope: ScopeRepo.String(), Path: legacyConfigPath(repoPath)}
+		}
+		if err := c.layer(repoPath, ScopeRepo, repoConfigPath(repoPath), 0); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Stream returns the stream the config was loaded for
+func (c *Config) Stream() string {
+	return c.stream
+}
+
+// layer merges one config file, then the files it includes, then its
+// section for the current stream
+func (c *Config) layer(repoPath string, scope Scope, path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("config includes nested too deeply at %s", path)
 	}
-	tree, err := loadToml(gp)
+	tree, err := loadToml(path)
 	if err != nil {
 		return err
 	}
-	tree.Set(key, val)
-	return saveToml(tree, gp)
+	values := flatten(tree)
+	for k, v := range values {
+		c.values[k] = Entry{Key: k, Value: v, Scope: scope.String(), Path: path}
+	}
+	if inc, ok := tree.GetPath([]string{"includeIf"}).(*toml.Tree); ok {
+		for _, cond := range inc.Keys() {
+			sub, ok := inc.GetPath([]string{cond}).(*toml.Tree)
+			if !ok {
+				return fmt.Errorf("%s: includeIf.%q must be a table with a path", path, cond)
+			}
+			target, _ := sub.GetPath([]string{"path"}).(string)
+			if target == "" {
+				return fmt.Errorf("%s: includeIf.%q has no path", path, cond)
+			}
+			match, err := c.matches(repoPath, cond)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if !match {
+				continue
+			}
+			target = expandHome(target)
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if err := c.layer(repoPath, scope, target, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if c.stream == "" {
+		return nil
+	}
+	prefix := "stream." + c.stream + "."
+	for k, v := range values {
+		if strings.HasPrefix(k, prefix) {
+			key := strings.TrimPrefix(k, prefix)
+			c.values[key] = Entry{Key: key, Value: v, Scope: scope.String(), Path: path, Stream: c.stream}
+		}
+	}
+	return nil
 }
 
-// SetRepoConfigValue sets key=val in .evo/config/config.toml
-func SetRepoConfigValue(repoPath, key, val string) error {
-	rp := repoConfigPath(repoPath)
-	tree, err := loadToml(rp)
+// matches evaluates an includeIf condition
+func (c *Config) matches(repoPath, cond string) (bool, error) {
+	kind, pattern, ok := strings.Cut(cond, ":")
+	if !ok {
+		return false, fmt.Errorf("invalid includeIf condition %q (expected repo:<path> or stream:<name>)", cond)
+	}
+	switch kind {
+	case "repo":
+		if repoPath == "" {
+			return false, nil
+		}
+		abs, err := filepath.Abs(repoPath)
+		if err != nil {
+			return false, err
+		}
+		pattern = expandHome(pattern)
+		if strings.HasSuffix(pattern, "/") {
+			return strings.HasPrefix(abs+"/", pattern), nil
+		}
+		return filepath.Match(pattern, abs)
+	case "stream":
+		if c.stream == "" {
+			return false, nil
+		}
+		return filepath.Match(pattern, c.stream)
+	}
+	return false, fmt.Errorf("unknown includeIf condition %q (expected repo:<path> or stream:<name>)", cond)
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return p
 	}
-	tree.Set(key, val)
-	return saveToml(tree, rp)
+	return home + p[1:]
 }
 
-// GetConfigValue retrieves a value from the config file
-func GetConfigValue(repoPath, key string) (string, error) {
-	config, err := loadConfig(repoPath)
+// currentStream reads .evo/HEAD; the streams package depends on config, so
+// it can't be used here
+func currentStream(repoPath string) string {
+	if repoPath == "" {
+		return ""
+	}
+	head, err := repo.ReadHead(repoPath)
 	if err != nil {
-		return "", err
+		return ""
 	}
+	return head.Stream
+}
 
-	value, ok := config[key]
-	if !ok {
-		return "", fmt.Errorf("no config value for %s", key)
+// flatten turns nested tables into dotted keys with string values. includeIf
+// sections are directives, not values, and are left out.
+func flatten(tree *toml.Tree) map[string]string {
+	out := make(map[string]string)
+	var walk func(t *toml.Tree, prefix string)
+	walk = func(t *toml.Tree, prefix string) {
+		for _, k := range t.Keys() {
+			if prefix == "" && k == "includeIf" {
+				continue
+			}
+			switch v := t.GetPath([]string{k}).(type) {
+			case *toml.Tree:
+				walk(v, prefix+k+".")
+			default:
+				out[prefix+k] = fmt.Sprint(v)
+			}
+		}
 	}
+	walk(tree, "")
+	return out
+}
 
-	return value, nil
+// Get returns the effective value of key
+func (c *Config) Get(key string) (string, bool) {
+	e, ok := c.values[key]
+	return e.Value, ok
 }
 
-// SetConfigValue stores a value in the config file
-func SetConfigValue(repoPath, key, value string) error {
-	config, err := loadConfig(repoPath)
-	if err != nil {
-		// If config doesn't exist, create new map
-		config = make(map[string]string)
+// Lookup returns the effective entry of key, including where it was set
+func (c *Config) Lookup(key string) (Entry, bool) {
+	e, ok := c.values[key]
+	return e, ok
+}
+
+// List returns every effective entry sorted by key
+func (c *Config) List() []Entry {
+	out := make([]Entry, 0, len(c.values))
+	for _, e := range c.values {
+		out = append(out, e)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
 
-	config[key] = value
+// Set validates val against the schema and stores key=val in the given layer
+func Set(repoPath string, scope Scope, key, val string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	typed, err := Validate(key, val)
+	if err != nil {
+		return err
+	}
+	path, err := scopePath(repoPath, scope)
+	if err != nil {
+		return err
+	}
+	tree, err := loadToml(path)
+	if err != nil {
+		return err
+	}
+	if scope == ScopeRepo {
+		if err := foldLegacy(repoPath, tree); err != nil {
+			return err
+		}
+	}
+	tree.Set(key, typed)
+	return saveToml(tree, path)
+}
 
-	// Ensure .evo directory exists
-	configDir := filepath.Join(repoPath, ".evo")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+// Unset removes key from the given layer. It reports whether the key was set.
+func Unset(repoPath string, scope Scope, key string) (bool, error) {
+	path, err := scopePath(repoPath, scope)
+	if err != nil {
+		return false, err
+	}
+	tree, err := loadToml(path)
+	if err != nil {
+		return false, err
+	}
+	if scope == ScopeRepo {
+		if err := foldLegacy(repoPath, tree); err != nil {
+			return false, err
+		}
 	}
+	found := tree.Has(key)
+	if found {
+		if err := tree.Delete(key); err != nil {
+			return false, err
+		}
+		pruneEmpty(tree, strings.Split(key, ".")[:strings.Count(key, ".")])
+	}
+	if err := saveToml(tree, path); err != nil {
+		return false, err
+	}
+	return found, nil
+}
 
-	// Write updated config
-	configPath := filepath.Join(configDir, "config.json")
-	data, err := json.MarshalIndent(config, "", "  ")
+// FoldLegacy moves the keys of the JSON config of older versions into the
+// repo's config.toml
+func FoldLegacy(repoPath string) error {
+	tree, err := loadToml(repoConfigPath(repoPath))
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return err
 	}
+	return foldLegacy(repoPath, tree)
+}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+// pruneEmpty removes tables left empty after a delete, innermost first
+func pruneEmpty(tree *toml.Tree, table []string) {
+	for n := len(table); n > 0; n-- {
+		sub, ok := tree.GetPath(table[:n]).(*toml.Tree)
+		if !ok || len(sub.Keys()) > 0 {
+			return
+		}
+		tree.DeletePath(table[:n])
 	}
+}
 
-	return nil
+// foldLegacy moves the keys of .evo/config.json into the repo TOML tree and
+// removes the JSON file; the TOML value wins where both are set
+func foldLegacy(repoPath string, tree *toml.Tree) error {
+	legacy, err := loadLegacy(repoPath)
+	if err != nil || legacy == nil {
+		return err
+	}
+	for k, v := range legacy {
+		if !tree.Has(k) {
+			tree.Set(k, v)
+		}
+	}
+	if err := saveToml(tree, repoConfigPath(repoPath)); err != nil {
+		return err
+	}
+	return os.Remove(legacyConfigPath(repoPath))
 }
 
-func loadConfig(repoPath string) (map[string]string, error) {
-	configPath := filepath.Join(repoPath, ".evo", "config.json")
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("no config value for signing.keyPath")
+func validKey(key string) error {
+	parts := strings.Split(key, ".")
+	if _, known := Spec(key); len(parts) < 2 && !known {
+		return fmt.Errorf("invalid config key %q: expected section.name", key)
+	}
+	for _, p := range parts {
+		if p == "" || strings.ContainsAny(p, " \t\n=[]\"'") {
+			return fmt.Errorf("invalid config key %q", key)
 		}
-		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return nil
+}
 
-	var config map[string]string
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// GetConfigValue returns the effective value of key, or an error if it is not
+// set in any layer
+func GetConfigValue(repoPath, key string) (string, error) {
+	c, err := Load(repoPath)
+	if err != nil {
+		return "", err
 	}
+	v, ok := c.Get(key)
+	if !ok {
+		return "", fmt.Errorf("no config value for %s", key)
+	}
+	return v, nil
+}
 
-	return config, nil
+// SetConfigValue sets key=val in the repo config
+func SetConfigValue(repoPath, key, val string) error {
+	return Set(repoPath, ScopeRepo, key, val)
+}
+
+// SetRepoConfigValue sets key=val in .evo/config/config.toml
+func SetRepoConfigValue(repoPath, key, val string) error {
+	return Set(repoPath, ScopeRepo, key, val)
+}
+
+// SetGlobalConfigValue sets key=val in ~/.config/evo/config.toml
+func SetGlobalConfigValue(key, val string) error {
+	return Set("", ScopeGlobal, key, val)
+}
+
+// Keys returns the known keys plus every key set in any layer, sorted
+func Keys(repoPath string) []string {
+	set := make(map[string]bool)
+	for _, k := range knownKeys() {
+		set[k] = true
+	}
+	if c, err := Load(repoPath); err == nil {
+		for k := range c.values {
+			set[k] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLayers(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(dir, "system.toml"))
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(dir, "global.toml"))
+	repo := filepath.Join(dir, "repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(repo, ".evo"), 0755))
+	return repo
+}
+
+func TestLayers(t *testing.T) {
+	repo := setupLayers(t)
+
+	assert.NoError(t, Set(repo, ScopeSystem, "user.name", "sys"))
+	assert.NoError(t, Set(repo, ScopeSystem, "user.email", "sys@evo"))
+	assert.NoError(t, Set(repo, ScopeGlobal, "user.name", "global"))
+	assert.NoError(t, Set(repo, ScopeRepo, "verifySignatures", "yes"))
+
+	cfg, err := Load(repo)
+	assert.NoError(t, err)
+	e, ok := cfg.Lookup("user.name")
+	assert.True(t, ok)
+	assert.Equal(t, "global", e.Value)
+	assert.Equal(t, "global", e.Scope)
+	v, _ := cfg.Get("user.email")
+	assert.Equal(t, "sys@evo", v)
+	b, err := cfg.Bool("verifySignatures")
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	// outside a repository only system and global apply
+	v, err = GetConfigValue("", "user.name")
+	assert.NoError(t, err)
+	assert.Equal(t, "global", v)
+	_, err = GetConfigValue("", "verifySignatures")
+	assert.Error(t, err)
+
+	found, err := Unset(repo, ScopeGlobal, "user.name")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	v, _ = GetConfigValue(repo, "user.name")
+	assert.Equal(t, "sys", v)
+	found, err = Unset(repo, ScopeGlobal, "user.name")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestTyped(t *testing.T) {
+	repo := setupLayers(t)
+
+	assert.Error(t, Set(repo, ScopeRepo, "verifySignatures", "maybe"))
+	assert.Error(t, Set(repo, ScopeRepo, "maintenance.auto.ops", "lots"))
+	assert.Error(t, Set(repo, ScopeRepo, "files.largeThreshold", "5 parsecs"))
+	assert.Error(t, Set(repo, ScopeRepo, "nodot", "x"))
+	assert.NoError(t, Set(repo, ScopeRepo, "files.largeThreshold", "2MiB"))
+	assert.NoError(t, Set(repo, ScopeRepo, "merge.mine.driver", "cat %A"))
+	assert.NoError(t, Set(repo, ScopeRepo, "custom.key", "anything"))
+
+	cfg, err := Load(repo)
+	assert.NoError(t, err)
+	n, err := cfg.Size("files.largeThreshold")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2<<20), n)
+	ops, err := cfg.Int64("maintenance.auto.ops")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100000), ops) // default
+	d, err := cfg.Duration("custom.key")
+	assert.Error(t, err)
+	assert.Zero(t, d)
+	assert.Empty(t, cfg.Check())
+
+	for in, want := range map[string]int64{"10": 10, "1k": 1024, "1KB": 1000, "1.5MiB": 3 << 19, "2g": 2 << 30} {
+		got, err := ParseSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, ok := Spec("merge.other.driver")
+	assert.True(t, ok)
+}
+
+func TestLegacyJSON(t *testing.T) {
+	repo := setupLayers(t)
+	legacy := filepath.Join(repo, ".evo", "config.json")
+	assert.NoError(t, os.WriteFile(legacy, []byte(`{"signing.keyPath": "/tmp/key"}`), 0644))
+
+	v, err := GetConfigValue(repo, "signing.keyPath")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/key", v)
+
+	// the next repo write moves it into the TOML file
+	assert.NoError(t, SetConfigValue(repo, "user.name", "me"))
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err))
+	v, err = GetConfigValue(repo, "signing.keyPath")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/key", v)
+}
+
+func TestStreamSections(t *testing.T) {
+	repo := setupLayers(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, ".evo", "HEAD"), []byte("main\n"), 0644))
+
+	assert.NoError(t, Set(repo, ScopeGlobal, "stream.main.user.email", "main@evo"))
+	assert.NoError(t, Set(repo, ScopeRepo, "user.email", "repo@evo"))
+	assert.NoError(t, Set(repo, ScopeRepo, "stream.main.verifySignatures", "true"))
+	assert.Error(t, Set(repo, ScopeRepo, "stream.main.verifySignatures", "maybe"))
+
+	cfg, err := Load(repo)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", cfg.Stream())
+	b, err := cfg.Bool("verifySignatures")
+	assert.NoError(t, err)
+	assert.True(t, b)
+	e, _ := cfg.Lookup("verifySignatures")
+	assert.Equal(t, "main", e.Stream)
+	// a later layer wins over an earlier layer's stream section
+	v, _ := cfg.Get("user.email")
+	assert.Equal(t, "repo@evo", v)
+
+	cfg, err = LoadForStream(repo, "dev")
+	assert.NoError(t, err)
+	b, _ = cfg.Bool("verifySignatures")
+	assert.False(t, b)
+}
+
+func TestIncludeIf(t *testing.T) {
+	repo := setupLayers(t)
+	dir := filepath.Dir(repo)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "work.toml"), []byte("[user]\nemail = \"me@work\"\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "release.toml"), []byte("verifySignatures = true\n"), 0644))
+	global := `[user]
+email = "me@home"
+
+[includeIf."repo:` + dir + `/"]
+path = "work.toml"
+
+[includeIf."repo:/elsewhere/"]
+path = "missing.toml"
+
+[includeIf."stream:release/*"]
+path = "release.toml"
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "global.toml"), []byte(global), 0644))
+
+	cfg, err := LoadForStream(repo, "release/1.0")
+	assert.NoError(t, err)
+	e, _ := cfg.Lookup("user.email")
+	assert.Equal(t, "me@work", e.Value)
+	assert.Equal(t, filepath.Join(dir, "work.toml"), e.Path)
+	b, _ := cfg.Bool("verifySignatures")
+	assert.True(t, b)
+	_, ok := cfg.Lookup("includeIf.stream:release/*.path")
+	assert.False(t, ok)
+
+	// outside the repo only the unconditional value applies
+	v, err := GetConfigValue("", "user.email")
+	assert.NoError(t, err)
+	assert.Equal(t, "me@home", v)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "global.toml"), []byte("[includeIf.\"branch:x\"]\npath = \"a.toml\"\n"), 0644))
+	_, err = Load(repo)
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type of a config value
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeBool
+	TypeInt
+	TypeSize     // byte count, e.g. 1000000, 512k, 1MiB
+	TypeDuration // Go duration, e.g. 90m, 24h
+)
+
+func (t Type) String() string {
+	return [...]string{"string", "bool", "int", "size", "duration"}[t]
+}
+
+// KeySpec describes a known config key
+type KeySpec struct {
+	Type    Type
+	Default string
+	Doc     string
+}
+
+// Schema lists the keys evo reads. A "*" segment matches any single name,
+// as in merge.*.driver.
+var Schema = map[string]KeySpec{
+	"user.name":                   {TypeString, "", "Author name recorded in commits"},
+	"user.email":                  {TypeString, "", "Author email recorded in commits"},
+	"user.identity":               {TypeString, "", "Identity profile used for commits instead of user.name/user.email"},
+	"user.requireIdentity":        {TypeBool, "true", "Refuse to commit when no identity is configured"},
+	"identity.*.name":             {TypeString, "", "Author name of identity profile <name>"},
+	"identity.*.email":            {TypeString, "", "Author email of identity profile <name>"},
+	"signing.keyPath":             {TypeString, "", "Ed25519 private key used by commit --sign"},
+	"verifySignatures":            {TypeBool, "false", "Verify commit signatures in evo log"},
+	"files.largeThreshold":        {TypeSize, "1000000", "Files larger than this are stored as large files"},
+	"commits.storage":             {TypeString, "", "Where commit files are kept instead of .evo/commits: file:<dir>, s3://<bucket>/<prefix>?endpoint=<url>&region=<region>, or sqlite:<file> in builds with -tags sqlite"},
+	"lfs.storage":                 {TypeString, "", "Where large file chunks are kept instead of .evo/chunks: file:<dir>, s3://<bucket>/<prefix>?endpoint=<url>&region=<region>, or sqlite:<file> in builds with -tags sqlite"},
+	"lfs.filters":                 {TypeString, "", "Comma-separated filters new LFS chunks are stored through, in order: zstd, aes-gcm (encryption comes last)"},
+	"lfs.encryptionKey":           {TypeString, "", "File holding the 64 hex digit AES-256 key of the aes-gcm LFS filter, relative to the repository unless absolute"},
+	"lfs.quota":                   {TypeSize, "0", "Bytes of chunks the LFS store may hold; the GC evicts the oldest content no file points to past it (0 disables)"},
+	"lfs.maxUnreferencedAge":      {TypeDuration, "0s", "How long the LFS store keeps content no file points to any more (0 deletes it right away)"},
+	"lfs.retention.*":             {TypeDuration, "0s", "lfs.maxUnreferencedAge of content last held by a file with extension <ext>, e.g. lfs.retention.psd"},
+	"ops.segmentSize":             {TypeSize, "4MiB", "Op logs past this size are rotated into a new checksummed segment"},
+	"ops.storage":                 {TypeString, "", "Where sealed op log segments are kept instead of .evo/ops: file:<dir>, s3://<bucket>/<prefix>?endpoint=<url>&region=<region>, or sqlite:<file> in builds with -tags sqlite"},
+	"maintenance.auto.ops":        {TypeInt, "100000", "Total ops that make the daemon run maintenance (0 disables)"},
+	"maintenance.auto.bytes":      {TypeSize, "512MiB", "Repository size that makes the daemon run maintenance (0 disables)"},
+	"review.requiredApprovals":    {TypeInt, "1", "Signed approvals of the current head that evo review merge requires"},
+	"review.trustedKeys":          {TypeString, "", "Directory of <email>.pub reviewer keys whose approvals count (default: .evo/reviews/keys)"},
+	"receive.protected":           {TypeBool, "false", "evo serve rejects pushes to the stream; set it in a [stream.<name>] section"},
+	"receive.allowReviewedMerges": {TypeBool, "false", "API merges of a review approved by trusted reviewers may reach a protected stream"},
+	"receive.requireSignatures":   {TypeBool, "false", "evo serve rejects pushed commits without a trusted signature"},
+	"receive.trustedKeys":         {TypeString, "", "Directory of .pub keys trusted by receive.requireSignatures (default: signing.keyPath's key)"},
+	"receive.maxCommitSize":       {TypeSize, "0", "evo serve rejects pushed commits larger than this (0 disables)"},
+	"receive.hook":                {TypeString, "", "Executable run before a push is accepted, after .evo/hooks/pre-receive"},
+	"guard.maxFileSize":           {TypeSize, "0", "evo commit and evo serve reject files larger than this that are not stored as large files (0 disables)"},
+	"guard.forbidden":             {TypeString, "", "Comma-separated path patterns evo commit and evo serve reject content in, such as .env or *.pem"},
+	"guard.secrets":               {TypeBool, "false", "evo commit and evo serve reject added lines that look like secrets: private keys, access keys, high-entropy strings"},
+	"guard.secretsIgnore":         {TypeString, "", "Comma-separated path patterns guard.secrets doesn't scan"},
+	"webhook.*.url":               {TypeString, "", "URL evo serve posts events to for webhook <name>"},
+	"webhook.*.secret":            {TypeString, "", "Key of the HMAC-SHA256 X-Evo-Signature of webhook <name>"},
+	"webhook.*.events":            {TypeString, "", "Comma-separated events (push, merge) webhook <name> receives; empty for all"},
+	"upstream":                    {TypeString, "", "Stream, or <remote>/<stream>, that evo prompt and evo stream status count commits ahead of and behind; set it in a [stream.<name>] section"},
+	"remote.*.url":                {TypeString, "", "Base URL of the evo server of remote <name>"},
+	"remote.*.proxy":              {TypeString, "", "Proxy URL for remote <name>, instead of the https_proxy environment variables"},
+	"remote.*.caFile":             {TypeString, "", "PEM bundle of CAs trusted for remote <name> besides the system's"},
+	"remote.*.certFile":           {TypeString, "", "PEM client certificate presented to remote <name>"},
+	"remote.*.keyFile":            {TypeString, "", "PEM key of remote.<name>.certFile, if not in the same file"},
+	"remote.*.push":               {TypeString, "", "Streams evo push sends to remote <name> when given none: <stream>[:<remote stream>] entries, with globs such as feature-*"},
+	"remote.*.pull":               {TypeString, "", "Streams evo pull merges from remote <name> when given none, as <remote stream>[:<stream>] entries with globs"},
+	"remote.*.insecure":           {TypeBool, "false", "Skip verifying the TLS certificate of remote <name>"},
+	"transfer.jobs":               {TypeInt, "4", "Chunks, streams or files evo transfer, push, pull and clone move at once"},
+	"transfer.limitRate":          {TypeSize, "0", "Bytes per second evo transfer, push, pull and clone are limited to (0 for no limit)"},
+	"merge.*.driver":              {TypeString, "", "Command run by the custom merge driver <name>"},
+	"init.defaultStream":          {TypeString, "main", "Stream evo init checks out when neither --default-stream nor the template names one"},
+	"init.template":               {TypeString, "", "Template evo init uses when --template is not given"},
+}
+
+// Spec returns the schema entry of key. A key in a stream section,
+// stream.<name>.<key>, has the spec of <key>.
+func Spec(key string) (KeySpec, bool) {
+	if s, ok := Schema[key]; ok {
+		return s, true
+	}
+	parts := strings.Split(key, ".")
+	if len(parts) > 2 && parts[0] == "stream" {
+		return Spec(strings.Join(parts[2:], "."))
+	}
+	for pattern, s := range Schema {
+		pp := strings.Split(pattern, ".")
+		if len(pp) != len(parts) {
+			continue
+		}
+		match := true
+		for i := range pp {
+			if pp[i] != "*" && pp[i] != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return s, true
+		}
+	}
+	return KeySpec{}, false
+}
+
+func knownKeys() []string {
+	var keys []string
+	for k := range Schema {
+		if !strings.Contains(k, "*") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks val against the schema of key and returns the value to
+// store: bools and ints natively, everything else as a string. Keys outside
+// the schema are stored as strings.
+func Validate(key, val string) (interface{}, error) {
+	spec, ok := Spec(key)
+	if !ok {
+		return val, nil
+	}
+	switch spec.Type {
+	case TypeBool:
+		b, err := ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		return b, nil
+	case TypeInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: expected an integer, got %q", key, val)
+		}
+		return n, nil
+	case TypeSize:
+		if _, err := ParseSize(val); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	case TypeDuration:
+		if _, err := time.ParseDuration(strings.TrimSpace(val)); err != nil {
+			return nil, fmt.Errorf("%s: expected a duration such as 90m, got %q", key, val)
+		}
+	}
+	return val, nil
+}
+
+// ParseBool accepts true/false, yes/no, on/off and 1/0
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected a boolean, got %q", s)
+}
+
+// ParseSize parses a byte count with an optional unit: k/KiB = 1024,
+// KB = 1000, and likewise for m, g and t
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size such as 512k or 1MiB, got %q", s)
+	}
+	mult := map[string]float64{
+		"": 1, "b": 1,
+		"k": 1 << 10, "kib": 1 << 10, "kb": 1e3,
+		"m": 1 << 20, "mib": 1 << 20, "mb": 1e6,
+		"g": 1 << 30, "gib": 1 << 30, "gb": 1e9,
+		"t": 1 << 40, "tib": 1 << 40, "tb": 1e12,
+	}[unit]
+	if mult == 0 {
+		return 0, fmt.Errorf("unknown size unit %q in %q", unit, s)
+	}
+	return int64(n * mult), nil
+}
+
+// value returns the effective value of key, falling back to its default
+func (c *Config) value(key string) (string, KeySpec) {
+	spec, _ := Spec(key)
+	if v, ok := c.Get(key); ok {
+		return v, spec
+	}
+	return spec.Default, spec
+}
+
+// String returns the value of key or its default
+func (c *Config) String(key string) string {
+	v, _ := c.value(key)
+	return v
+}
+
+// Bool returns the value of key or its default as a bool
+func (c *Config) Bool(key string) (bool, error) {
+	v, _ := c.value(key)
+	b, err := ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	return b, nil
+}
+
+// Int64 returns the value of key or its default as an integer
+func (c *Config) Int64(key string) (int64, error) {
+	v, _ := c.value(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: expected an integer, got %q", key, v)
+	}
+	return n, nil
+}
+
+// Size returns the value of key or its default as a byte count
+func (c *Config) Size(key string) (int64, error) {
+	v, _ := c.value(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := ParseSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+// Duration returns the value of key or its default as a duration
+func (c *Config) Duration(key string) (time.Duration, error) {
+	v, _ := c.value(key)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%s: expected a duration, got %q", key, v)
+	}
+	return d, nil
+}
+
+// Check validates every effective value against the schema
+func (c *Config) Check() []error {
+	var errs []error
+	for _, e := range c.List() {
+		if _, err := Validate(e.Key, e.Value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", e.Path, e.Scope, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,78 @@
+package crdt
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// CausalBuffer delays ops until everything they depend on has been delivered.
+// An op from node n is ready once, for every other node m in its vector, an op
+// of m at least as new as the vector's entry has been delivered. Ops of one
+// node are delivered in Lamport order.
+type CausalBuffer struct {
+	delivered VectorClock
+	waiting   []Operation
+}
+
+// NewCausalBuffer creates a buffer for a log that already holds the ops
+// covered by delivered
+func NewCausalBuffer(delivered VectorClock) *CausalBuffer {
+	if delivered == nil {
+		delivered = make(VectorClock)
+	}
+	return &CausalBuffer{delivered: delivered.Copy()}
+}
+
+// Add queues ops and returns those that became deliverable, in causal order
+func (b *CausalBuffer) Add(ops ...Operation) []Operation {
+	b.waiting = append(b.waiting, ops...)
+	sort.SliceStable(b.waiting, func(i, j int) bool {
+		return b.waiting[i].LessThan(&b.waiting[j])
+	})
+
+	var out []Operation
+	for progress := true; progress; {
+		progress = false
+		rest := b.waiting[:0]
+		blocked := make(map[uuid.UUID]bool)
+		for _, op := range b.waiting {
+			if !blocked[op.NodeID] && b.ready(op) {
+				b.delivered.Witness(op)
+				out = append(out, op)
+				progress = true
+			} else {
+				// later ops of the node wait behind this one
+				blocked[op.NodeID] = true
+				rest = append(rest, op)
+			}
+		}
+		b.waiting = rest
+	}
+	return out
+}
+
+func (b *CausalBuffer) ready(op Operation) bool {
+	for n, t := range op.Vector {
+		if n != op.NodeID && b.delivered[n] < t {
+			return false
+		}
+	}
+	return true
+}
+
+// Pending returns ops still waiting for their dependencies
+func (b *CausalBuffer) Pending() []Operation {
+	return append([]Operation(nil), b.waiting...)
+}
+
+// Flush returns all waiting ops in Lamport order and empties the buffer, for
+// when the missing dependencies are known never to arrive
+func (b *CausalBuffer) Flush() []Operation {
+	out := b.waiting
+	for _, op := range out {
+		b.delivered.Witness(op)
+	}
+	b.waiting = nil
+	return out
+}
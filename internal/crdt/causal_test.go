@@ -0,0 +1,101 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestVectorClockCompare(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name string
+		v, o VectorClock
+		want Ordering
+	}{
+		{"Equal", VectorClock{a: 1, b: 2}, VectorClock{a: 1, b: 2}, Equal},
+		{"Before", VectorClock{a: 1}, VectorClock{a: 1, b: 1}, Before},
+		{"After", VectorClock{a: 3, b: 1}, VectorClock{a: 2, b: 1}, After},
+		{"Concurrent", VectorClock{a: 2}, VectorClock{b: 2}, Concurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.Compare(tt.o); got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Legacy Ops", func(t *testing.T) {
+		x := Operation{NodeID: a, Lamport: 1}
+		y := Operation{NodeID: a, Lamport: 2}
+		z := Operation{NodeID: b, Lamport: 3}
+		if !HappenedBefore(x, y) {
+			t.Error("Expected ops of one node to be ordered by Lamport time")
+		}
+		if !IsConcurrent(y, z) {
+			t.Error("Expected legacy ops of different nodes to be concurrent")
+		}
+	})
+}
+
+func TestCausalBuffer(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	a1 := Operation{NodeID: a, Lamport: 1, Vector: VectorClock{a: 1}}
+	a2 := Operation{NodeID: a, Lamport: 2, Vector: VectorClock{a: 2}}
+	// b saw both of a's ops
+	b3 := Operation{NodeID: b, Lamport: 3, Vector: VectorClock{a: 2, b: 3}}
+
+	buf := NewCausalBuffer(nil)
+	if ready := buf.Add(b3); len(ready) != 0 {
+		t.Fatalf("Expected b3 to wait for its dependencies, got %v", ready)
+	}
+	if ready := buf.Add(a1); len(ready) != 1 {
+		t.Fatalf("Expected only a1 to be delivered, got %d ops", len(ready))
+	}
+	if len(buf.Pending()) != 1 {
+		t.Errorf("Expected b3 to still be pending")
+	}
+	ready := buf.Add(a2)
+	if len(ready) != 2 || ready[0].Lamport != 2 || ready[1].Lamport != 3 {
+		t.Fatalf("Expected a2 then b3, got %v", ready)
+	}
+	if len(buf.Pending()) != 0 {
+		t.Errorf("Expected nothing pending")
+	}
+
+	t.Run("Seeded", func(t *testing.T) {
+		buf := NewCausalBuffer(VectorClock{a: 2})
+		if ready := buf.Add(b3); len(ready) != 1 {
+			t.Errorf("Expected b3 to be ready when a's ops are already in the log")
+		}
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		buf := NewCausalBuffer(nil)
+		buf.Add(b3)
+		if out := buf.Flush(); len(out) != 1 || len(buf.Pending()) != 0 {
+			t.Errorf("Expected flush to release waiting ops")
+		}
+	})
+}
+
+func TestVectorClockStamp(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	v := Knowledge([]Operation{
+		{NodeID: a, Lamport: 4},
+		{NodeID: b, Lamport: 2, Vector: VectorClock{a: 1, b: 2}},
+	})
+	op1 := Operation{NodeID: b, Lamport: 5}
+	v.Stamp(&op1)
+	op2 := Operation{NodeID: b, Lamport: 6}
+	v.Stamp(&op2)
+
+	if op1.Vector[a] != 4 || op1.Vector[b] != 5 {
+		t.Errorf("Unexpected vector %v", op1.Vector)
+	}
+	if !HappenedBefore(op1, op2) {
+		t.Error("Expected later stamped op to depend on the earlier one")
+	}
+}
@@ -0,0 +1,40 @@
+package crdt
+
+import "sync"
+
+// LamportClock is a monotonic logical clock. Local events Tick it, and
+// timestamps seen on other replicas' ops are merged in with Observe, so every
+// new op orders after everything this node has seen.
+type LamportClock struct {
+	mu   sync.Mutex
+	time uint64
+}
+
+// NewLamportClock creates a clock starting at the given time
+func NewLamportClock(start uint64) *LamportClock {
+	return &LamportClock{time: start}
+}
+
+// Tick advances the clock and returns the new time
+func (c *LamportClock) Tick() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.time++
+	return c.time
+}
+
+// Observe merges a timestamp seen on another op into the clock
+func (c *LamportClock) Observe(t uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t > c.time {
+		c.time = t
+	}
+}
+
+// Now returns the current time without advancing the clock
+func (c *LamportClock) Now() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.time
+}
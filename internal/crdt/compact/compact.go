@@ -2,64 +2,180 @@ package compact
 
 import (
 	"evo/internal/crdt"
-	"sort"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// CompactOperations compacts a list of operations by:
-// 1. Pruning old tombstones
-// 2. Collapsing multiple operations on the same line into a single op
-// 3. Removing redundant operations that don't affect the final state
+// CompactOperations shrinks one file's op log without changing the document it
+// materializes to. Ops keep their log order; for each line only the insert
+// that placed it, the op that set its current content and, while it is still
+// deleted, the winning delete survive. Tombstones older than TombstoneTTL are
+// dropped as well (see PruneTombstones). Logs shorter than MaxOps are left
+// alone, and the newest MinOpsToKeep ops and ops within HistoryHorizon are
+// never touched.
 func CompactOperations(ops []crdt.Operation, cfg *Config) []crdt.Operation {
 	if len(ops) < cfg.MaxOps {
 		return ops
 	}
+	return reduce(ops, cfg, true, nil)
+}
 
-	// Build a map of lineID to its operations
-	lineOps := make(map[uuid.UUID][]crdt.Operation)
-	for _, op := range ops {
-		lineOps[op.LineID] = append(lineOps[op.LineID], op)
-	}
+// PruneTombstones drops deleted lines whose delete is older than TombstoneTTL,
+// along with all their ops. A tombstone another line is anchored to stays, as
+// removing it would orphan that line. The newest MinOpsToKeep ops and ops
+// within HistoryHorizon are kept.
+func PruneTombstones(ops []crdt.Operation, cfg *Config) []crdt.Operation {
+	return reduce(ops, cfg, false, nil)
+}
 
-	var compacted []crdt.Operation
-	now := time.Now()
+// lineHistory summarizes the ops on one line
+type lineHistory struct {
+	insert  int // first insert, -1 if the log has none
+	content int // op that set the current content
+	delete  int // winning delete
+	deleted bool
+	hot     bool // touched by the ops that must be kept verbatim
+	refs    int  // surviving lines anchored to this one
+}
 
-	for _, lineHistory := range lineOps {
-		// Sort operations by lamport timestamp
-		sortOps(lineHistory)
+type stamp struct {
+	lamport uint64
+	node    string
+}
 
-		// Keep only the latest operation for each line
-		finalOp := lineHistory[len(lineHistory)-1]
-		
-		// Skip old tombstones
-		if finalOp.Type == crdt.OpDelete {
-			age := now.Sub(finalOp.Timestamp)
-			if age > cfg.TombstoneTTL {
-				continue
-			}
-		}
+func stampOf(op crdt.Operation) stamp {
+	return stamp{op.Lamport, op.NodeID.String()}
+}
 
-		compacted = append(compacted, finalOp)
+func (s stamp) after(o stamp) bool {
+	if s.lamport != o.lamport {
+		return s.lamport > o.lamport
 	}
+	return s.node > o.node
+}
 
-	// Sort compacted operations
-	sortOps(compacted)
+// reduce compacts or prunes ops. Ops for which protect returns true are kept
+// verbatim, like the newest MinOpsToKeep ops and ops within HistoryHorizon.
+func reduce(ops []crdt.Operation, cfg *Config, collapse bool, protect func(crdt.Operation) bool) []crdt.Operation {
+	keepFrom := len(ops) - cfg.MinOpsToKeep
+	if keepFrom <= 0 {
+		return ops
+	}
+	horizon := time.Now().Add(-cfg.HistoryHorizon)
+	kept := func(i int, op crdt.Operation) bool {
+		if i >= keepFrom {
+			return true
+		}
+		if cfg.HistoryHorizon > 0 && op.Timestamp.After(horizon) {
+			return true
+		}
+		return protect != nil && protect(op)
+	}
 
-	// Ensure we keep minimum number of ops
-	if len(compacted) < cfg.MinOpsToKeep {
-		return ops[:cfg.MinOpsToKeep]
+	lines := make(map[uuid.UUID]*lineHistory)
+	var order []uuid.UUID
+	revived := make(map[uuid.UUID]stamp)
+	removed := make(map[uuid.UUID]stamp)
+	written := make(map[uuid.UUID]stamp)
+	for i, op := range ops {
+		h, ok := lines[op.LineID]
+		if !ok {
+			h = &lineHistory{insert: -1, content: -1, delete: -1}
+			lines[op.LineID] = h
+			order = append(order, op.LineID)
+		}
+		if kept(i, op) {
+			h.hot = true
+			if op.Type == crdt.OpInsert {
+				if o, ok := lines[op.OriginLineID]; ok {
+					o.hot = true
+				}
+			}
+			continue
+		}
+		s := stampOf(op)
+		switch op.Type {
+		case crdt.OpInsert:
+			if h.insert < 0 {
+				h.insert = i
+			}
+			if s.after(revived[op.LineID]) {
+				revived[op.LineID] = s
+			}
+			if h.content < 0 || s.after(written[op.LineID]) {
+				h.content, written[op.LineID] = i, s
+			}
+		case crdt.OpUpdate:
+			if h.content < 0 || s.after(written[op.LineID]) {
+				h.content, written[op.LineID] = i, s
+			}
+		case crdt.OpDelete:
+			if h.delete < 0 || s.after(removed[op.LineID]) {
+				h.delete, removed[op.LineID] = i, s
+			}
+		}
+	}
+	for id, h := range lines {
+		h.deleted = h.delete >= 0 && removed[id].after(revived[id])
 	}
 
-	return compacted
-}
+	// a tombstone can go once it is old enough and nothing hangs off it
+	cutoff := time.Now().Add(-cfg.TombstoneTTL)
+	pruned := make(map[uuid.UUID]bool)
+	prunable := func(h *lineHistory) bool {
+		if !h.deleted || h.hot || h.insert < 0 || h.refs > 0 {
+			return false
+		}
+		ts := ops[h.delete].Timestamp
+		// ops from logs written before timestamps were stored never expire
+		return !ts.IsZero() && ts.Before(cutoff)
+	}
+	for _, id := range order {
+		h := lines[id]
+		if h.insert < 0 {
+			continue
+		}
+		if o, ok := lines[ops[h.insert].OriginLineID]; ok {
+			o.refs++
+		}
+	}
+	queue := append([]uuid.UUID(nil), order...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		h := lines[id]
+		if pruned[id] || !prunable(h) {
+			continue
+		}
+		pruned[id] = true
+		origin := ops[h.insert].OriginLineID
+		if o, ok := lines[origin]; ok {
+			o.refs--
+			queue = append(queue, origin)
+		}
+	}
 
-// sortOps sorts operations by lamport timestamp and nodeID
-func sortOps(ops []crdt.Operation) {
-	sort.Slice(ops, func(i, j int) bool {
-		return ops[i].LessThan(&ops[j])
-	})
+	out := make([]crdt.Operation, 0, len(ops))
+	for i, op := range ops {
+		if kept(i, op) {
+			out = append(out, op)
+			continue
+		}
+		if pruned[op.LineID] {
+			continue
+		}
+		h := lines[op.LineID]
+		if !collapse || h.insert < 0 {
+			// without the line's insert there is nothing to fold into
+			out = append(out, op)
+			continue
+		}
+		if i == h.insert || i == h.content || (h.deleted && i == h.delete) {
+			out = append(out, op)
+		}
+	}
+	return out
 }
 
 // CompactRGA creates a new RGA with compacted operations
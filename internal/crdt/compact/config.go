@@ -8,18 +8,21 @@ type Config struct {
 	MaxOps int
 	// Maximum age of tombstones before pruning
 	TombstoneTTL time.Duration
-	// Minimum number of operations to keep after compaction
+	// Number of most recent operations per log that compaction leaves untouched
 	MinOpsToKeep int
 	// How often to run compaction
 	CompactionInterval time.Duration
+	// Ops and commits younger than this are never compacted or pruned
+	HistoryHorizon time.Duration
 }
 
 // DefaultConfig returns sensible defaults for compaction
 func DefaultConfig() *Config {
 	return &Config{
-		MaxOps:             10000,                // Compact when we have more than 10k ops
+		MaxOps:             10000,               // Compact when we have more than 10k ops
 		TombstoneTTL:       7 * 24 * time.Hour,  // Keep tombstones for 1 week
-		MinOpsToKeep:       1000,                // Keep at least 1k ops after compaction
+		MinOpsToKeep:       1000,                // Never touch the newest 1k ops of a log
 		CompactionInterval: 1 * time.Hour,       // Run compaction every hour
+		HistoryHorizon:     90 * 24 * time.Hour, // Leave the last 90 days of history intact
 	}
 }
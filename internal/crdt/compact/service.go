@@ -1,22 +1,34 @@
 package compact
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"context"
+	"errors"
+	"evo/internal/commits"
 	"evo/internal/crdt"
+	"evo/internal/log"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+var logger = log.For("compact")
+
 // CompactionService manages operation compaction and tombstone pruning
 type CompactionService struct {
 	repoPath string
 	config   *Config
 	mu       sync.RWMutex
 	done     chan struct{}
+	wg       sync.WaitGroup
 }
 
 // NewCompactionService creates a new compaction service
@@ -40,16 +52,18 @@ func (s *CompactionService) Start() error {
 	ticker := time.NewTicker(s.config.CompactionInterval)
 
 	// Start background goroutine
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
 		for {
 			select {
 			case <-ticker.C:
-				if err := s.CompactOperations(); err != nil {
-					// Log error but continue running
+				if err := s.CompactOperations(context.Background()); err != nil {
+					logger.Error("compaction failed", "repo", s.repoPath, "err", err)
 					continue
 				}
-				if err := s.PruneTombstones(); err != nil {
-					// Log error but continue running
+				if err := s.PruneTombstones(context.Background()); err != nil {
+					logger.Error("tombstone pruning failed", "repo", s.repoPath, "err", err)
 					continue
 				}
 			case <-s.done:
@@ -62,265 +76,277 @@ func (s *CompactionService) Start() error {
 	return nil
 }
 
-// Stop stops the compaction service
+// Stop stops the compaction service, waiting for a running pass to finish
 func (s *CompactionService) Stop() {
 	close(s.done)
+	s.wg.Wait()
 }
 
-// CompactOperations compacts operations by combining sequential operations
-func (s *CompactionService) CompactOperations() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	opsDir := filepath.Join(s.repoPath, ".evo", "ops")
-	streams, err := os.ReadDir(opsDir)
-	if err != nil {
-		return err
-	}
-
-	for _, stream := range streams {
-		if !stream.IsDir() {
-			continue
+// CompactOperations compacts every op log under .evo/ops that has reached MaxOps
+func (s *CompactionService) CompactOperations(ctx context.Context) error {
+	return s.rewriteLogs(ctx, func(all []crdt.Operation, protect func(crdt.Operation) bool) []crdt.Operation {
+		if len(all) < s.config.MaxOps {
+			return all
 		}
+		return reduce(all, s.config, true, protect)
+	})
+}
 
-		streamDir := filepath.Join(opsDir, stream.Name())
-		files, err := os.ReadDir(streamDir)
-		if err != nil {
-			continue
-		}
+// PruneTombstones removes old tombstones from every op log under .evo/ops
+func (s *CompactionService) PruneTombstones(ctx context.Context) error {
+	return s.rewriteLogs(ctx, func(all []crdt.Operation, protect func(crdt.Operation) bool) []crdt.Operation {
+		return reduce(all, s.config, false, protect)
+	})
+}
 
-		var ops []crdt.Operation
-		for _, f := range files {
-			if !strings.HasSuffix(f.Name(), ".bin") {
-				continue
-			}
+// opID identifies an op across op logs and commits
+type opID struct {
+	lamport uint64
+	node    uuid.UUID
+	line    uuid.UUID
+}
 
-			data, err := os.ReadFile(filepath.Join(streamDir, f.Name()))
-			if err != nil {
-				continue
-			}
+func idOf(op crdt.Operation) opID {
+	return opID{op.Lamport, op.NodeID, op.LineID}
+}
 
-			// Read size prefix
-			if len(data) < 4 {
-				continue
-			}
-			size := binary.BigEndian.Uint32(data[:4])
-			if len(data) < int(4+size) {
-				continue
-			}
-			opData := data[4 : 4+size]
+// history splits a stream's commits at the history horizon. Ops of recent
+// commits must stay in the log so the commits can still be reverted and
+// replayed; old commits may lose ops and are then squashed into a baseline.
+type history struct {
+	recent map[opID]bool
+	old    []types.Commit
+	oldOps map[opID]bool
+}
 
-			var op crdt.Operation
-			if err := json.Unmarshal(opData, &op); err != nil {
-				continue
+func (s *CompactionService) loadHistory(stream string) (*history, error) {
+	all, err := commits.ListCommits(s.repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	h := &history{recent: make(map[opID]bool), oldOps: make(map[opID]bool)}
+	horizon := time.Now().Add(-s.config.HistoryHorizon)
+	for _, c := range all {
+		if c.Timestamp.After(horizon) {
+			for _, eop := range c.Operations {
+				h.recent[idOf(eop.Op)] = true
 			}
-			ops = append(ops, op)
-		}
-
-		if len(ops) < s.config.MaxOps {
 			continue
 		}
-
-		// Combine sequential operations
-		for i := range ops {
-			op := &ops[i]
-			if i > 0 && ops[i-1].LineID == op.LineID {
-				// Combine with previous operation
-				ops[i-1].Content = op.Content
-				ops[i-1].Lamport = op.Lamport
-				ops[i-1].Timestamp = op.Timestamp
-				ops = append(ops[:i], ops[i+1:]...)
-				i--
-				continue
-			}
+		h.old = append(h.old, c)
+		for _, eop := range c.Operations {
+			h.oldOps[idOf(eop.Op)] = true
 		}
+	}
+	return h, nil
+}
 
-		// Write compacted operations back
-		compacted := make([]crdt.Operation, 0, len(ops))
-		for _, op := range ops {
-			if op.Type != crdt.OpDelete || time.Since(op.Timestamp) <= s.config.TombstoneTTL {
-				compacted = append(compacted, op)
-			}
+// squash replaces the old commits with one baseline commit holding the ops of
+// theirs that survived compaction. The baseline remembers the squashed IDs so
+// merges still recognize those commits.
+func (s *CompactionService) squash(stream string, h *history, surviving map[opID]bool) error {
+	if len(h.old) == 0 {
+		return nil
+	}
+	base := types.Commit{
+		ID:        uuid.New().String(),
+		Stream:    stream,
+		Message:   fmt.Sprintf("[baseline] %d commit(s) squashed by compaction", len(h.old)),
+		Timestamp: h.old[len(h.old)-1].Timestamp,
+	}
+	for _, c := range h.old {
+		if len(c.Squashed) > 0 {
+			base.Squashed = append(base.Squashed, c.Squashed...)
+		} else {
+			base.Squashed = append(base.Squashed, c.ID)
 		}
-
-		// Save compacted operations
-		for _, op := range compacted {
-			data, err := json.Marshal(op)
-			if err != nil {
-				continue
-			}
-
-			// Write size prefix followed by data
-			opPath := filepath.Join(streamDir, op.LineID.String()+".bin")
-			f, err := os.Create(opPath)
-			if err != nil {
-				continue
-			}
-
-			// Write 4-byte size prefix
-			size := uint32(len(data))
-			var sizeBuf [4]byte
-			binary.BigEndian.PutUint32(sizeBuf[:], size)
-			if _, err := f.Write(sizeBuf[:]); err != nil {
-				f.Close()
-				continue
+		for _, eop := range c.Operations {
+			if surviving[idOf(eop.Op)] {
+				base.Operations = append(base.Operations, eop)
 			}
-
-			// Write operation data
-			if _, err := f.Write(data); err != nil {
-				f.Close()
-				continue
-			}
-			f.Close()
 		}
-
-		// Remove old operations
-		for _, op := range ops {
-			found := false
-			for _, c := range compacted {
-				if c.LineID == op.LineID {
-					found = true
-					break
-				}
-			}
-			if !found {
-				os.Remove(filepath.Join(streamDir, op.LineID.String()+".bin"))
-			}
+	}
+	if err := commits.SaveCommitTo(s.repoPath, stream, &base); err != nil {
+		return err
+	}
+	logger.Info("squashed old commits into a baseline", "stream", stream, "baseline", base.ID, "commits", len(h.old))
+	for _, c := range h.old {
+		if err := commits.RemoveCommit(s.repoPath, stream, c.ID); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
-// PruneTombstones removes old tombstones
-func (s *CompactionService) PruneTombstones() error {
+func (s *CompactionService) opsDir() string {
+	return filepath.Join(repo.Dir(s.repoPath), "ops")
+}
+
+// backupDir holds the original of each log of a stream being rewritten,
+// until the stream is done. A backup left behind means a rewrite was
+// interrupted, and the original is restored before anything else touches
+// the logs.
+func (s *CompactionService) backupDir() string {
+	return filepath.Join(repo.Dir(s.repoPath), "compact-backup")
+}
+
+// rewriteLogs applies fn to each .evo/ops/<stream>/<fileID>.bin and replaces
+// the logs it shortened. fn must keep the ops protect reports. When ops of old
+// commits are dropped, those commits are squashed into a baseline. Streams
+// are rewritten whole: if ctx is cancelled or a log fails, the logs of the
+// stream at hand are put back from their backups.
+func (s *CompactionService) rewriteLogs(ctx context.Context, fn func(all []crdt.Operation, protect func(crdt.Operation) bool) []crdt.Operation) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	opsDir := filepath.Join(s.repoPath, ".evo", "ops")
-	streams, err := os.ReadDir(opsDir)
+	if err := s.recover(); err != nil {
+		return fmt.Errorf("failed to recover interrupted compaction: %w", err)
+	}
+
+	streams, err := os.ReadDir(s.opsDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-
-	cutoff := time.Now().Add(-s.config.TombstoneTTL)
-
 	for _, stream := range streams {
 		if !stream.IsDir() {
 			continue
 		}
+		if err := s.rewriteStream(ctx, stream.Name(), fn); err != nil {
+			if rerr := s.recover(); rerr != nil {
+				return errors.Join(err, fmt.Errorf("failed to restore the op logs of %s: %w", stream.Name(), rerr))
+			}
+			return err
+		}
+	}
+	return os.RemoveAll(s.backupDir())
+}
 
-		streamDir := filepath.Join(opsDir, stream.Name())
-		files, err := os.ReadDir(streamDir)
+// rewriteStream rewrites the logs of a stream, keeping their backups until
+// the stream is done
+func (s *CompactionService) rewriteStream(ctx context.Context, stream string, fn func(all []crdt.Operation, protect func(crdt.Operation) bool) []crdt.Operation) error {
+	streamDir := filepath.Join(s.opsDir(), stream)
+	logs, err := ops.ListLogs(streamDir)
+	if err != nil {
+		return err
+	}
+	hist, err := s.loadHistory(stream)
+	if err != nil {
+		return fmt.Errorf("failed to load commits of %s: %w", stream, err)
+	}
+	protect := func(op crdt.Operation) bool {
+		return hist.recent[idOf(op)]
+	}
+	surviving := make(map[opID]bool)
+	squash := false
+	for _, path := range logs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		all, err := ops.LoadAllOps(path)
 		if err != nil {
+			return err
+		}
+		out := fn(all, protect)
+		for _, op := range out {
+			surviving[idOf(op)] = true
+		}
+		if len(out) == len(all) {
 			continue
 		}
-
-		var ops []crdt.Operation
-		var filesToRemove []string
-
-		// Read all operations in this stream
-		for _, f := range files {
-			if !strings.HasSuffix(f.Name(), ".bin") {
-				continue
-			}
-
-			data, err := os.ReadFile(filepath.Join(streamDir, f.Name()))
-			if err != nil {
-				continue
-			}
-
-			// Read size prefix
-			if len(data) < 4 {
-				continue
-			}
-			size := binary.BigEndian.Uint32(data[:4])
-			if len(data) < int(4+size) {
-				continue
-			}
-			opData := data[4 : 4+size]
-
-			var op crdt.Operation
-			if err := json.Unmarshal(opData, &op); err != nil {
-				continue
-			}
-
-			// Keep non-delete operations and recent tombstones
-			if op.Type != crdt.OpDelete || op.Timestamp.After(cutoff) {
-				ops = append(ops, op)
-			} else {
-				filesToRemove = append(filesToRemove, f.Name())
+		for _, op := range all {
+			if hist.oldOps[idOf(op)] && !surviving[idOf(op)] {
+				squash = true
 			}
 		}
-
-		// Remove old tombstones
-		for _, name := range filesToRemove {
-			if err := os.Remove(filepath.Join(streamDir, name)); err != nil && !os.IsNotExist(err) {
-				return err
-			}
+		if err := s.rewriteLog(stream, path, out); err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", path, err)
 		}
+		logger.Debug("rewrote op log", "file", path, "before", len(all), "after", len(out))
+	}
+	if squash {
+		if err := s.squash(stream, hist, surviving); err != nil {
+			return fmt.Errorf("failed to write baseline for %s: %w", stream, err)
+		}
+	}
+	return os.RemoveAll(filepath.Join(s.backupDir(), stream))
+}
 
-		// Write remaining operations back
-		for _, op := range ops {
-			data, err := json.Marshal(op)
-			if err != nil {
-				return err
-			}
-
-			// Write size prefix followed by data
-			opPath := filepath.Join(streamDir, op.LineID.String()+".bin")
-			tempPath := opPath + ".tmp"
-			f, err := os.Create(tempPath)
+// rewriteLog atomically replaces a log, backing it up first
+func (s *CompactionService) rewriteLog(stream, path string, out []crdt.Operation) error {
+	backup := filepath.Join(s.backupDir(), stream, filepath.Base(path))
+	if err := backupLog(path, backup); err != nil {
+		return err
+	}
+	return ops.ReplaceLog(path, func(w io.Writer) error {
+		lw := ops.NewLogWriter(w)
+		for _, op := range out {
+			off, err := ops.Put(ops.StorePath(path), op)
 			if err != nil {
 				return err
 			}
-
-			// Write 4-byte size prefix
-			size := uint32(len(data))
-			var sizeBuf [4]byte
-			binary.BigEndian.PutUint32(sizeBuf[:], size)
-			if _, err := f.Write(sizeBuf[:]); err != nil {
-				f.Close()
-				os.Remove(tempPath)
-				return err
-			}
-
-			// Write operation data
-			if _, err := f.Write(data); err != nil {
-				f.Close()
-				os.Remove(tempPath)
-				return err
-			}
-			f.Close()
-
-			// Atomically replace the old file with the new one
-			if err := os.Rename(tempPath, opPath); err != nil {
-				os.Remove(tempPath)
+			if err := lw.WriteRef(off); err != nil {
 				return err
 			}
 		}
+		return nil
+	})
+}
 
-		// Remove any remaining files that weren't rewritten
-		for _, f := range files {
-			if !strings.HasSuffix(f.Name(), ".bin") {
-				continue
-			}
-
-			found := false
-			for _, op := range ops {
-				if f.Name() == op.LineID.String()+".bin" {
-					found = true
-					break
-				}
-			}
-
-			if !found {
-				if err := os.Remove(filepath.Join(streamDir, f.Name())); err != nil && !os.IsNotExist(err) {
-					return err
-				}
-			}
+// recover puts back the original of any log whose rewrite was interrupted
+// or is taken back
+func (s *CompactionService) recover() error {
+	root := s.backupDir()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
 		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(s.opsDir(), rel)
+		logger.Warn("restoring op log from interrupted compaction", "file", target)
+		os.Remove(target + ".tmp")
+		return ops.ReplaceLog(target, func(w io.Writer) error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
 	}
+	return os.RemoveAll(root)
+}
 
-	return nil
+// backupLog copies the bytes of a log, across its segments, to a single file
+func backupLog(path, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := ops.OpenLog(path, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -1,11 +1,15 @@
 package compact
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"context"
+	"errors"
+	"evo/internal/commits"
 	"evo/internal/crdt"
+	evoops "evo/internal/ops"
+	"evo/internal/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,197 +51,220 @@ func TestCompactionService(t *testing.T) {
 
 	t.Run("Operation Compaction", func(t *testing.T) {
 		fileID := uuid.New()
-		lineID := uuid.New()
 		nodeID := uuid.New()
+		kept, gone := uuid.New(), uuid.New()
 
-		// Create test operations
+		// kept is inserted and updated twice, gone is inserted and deleted
 		ops := []crdt.Operation{
-			{
-				Type:      crdt.OpUpdate,
-				Lamport:   1,
-				NodeID:    nodeID,
-				FileID:    fileID,
-				LineID:    lineID,
-				Content:   "value1",
-				Stream:    "stream1",
-				Timestamp: time.Now().Add(-2 * time.Hour),
-				Vector:    []int64{1, 0, 0},
-			},
-			{
-				Type:      crdt.OpUpdate,
-				Lamport:   2,
-				NodeID:    nodeID,
-				FileID:    fileID,
-				LineID:    lineID,
-				Content:   "value2",
-				Stream:    "stream1",
-				Timestamp: time.Now().Add(-1 * time.Hour),
-				Vector:    []int64{1, 1, 0},
-			},
-			{
-				Type:      crdt.OpDelete,
-				Lamport:   3,
-				NodeID:    nodeID,
-				FileID:    fileID,
-				LineID:    lineID,
-				Stream:    "stream1",
-				Timestamp: time.Now(),
-				Vector:    []int64{1, 1, 1},
-			},
+			{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, FileID: fileID, LineID: kept, OriginLineID: crdt.DocumentStart, Content: "value1", Timestamp: time.Now().Add(-3 * time.Hour)},
+			{Type: crdt.OpInsert, Lamport: 2, NodeID: nodeID, FileID: fileID, LineID: gone, OriginLineID: kept, Content: "gone", Timestamp: time.Now().Add(-3 * time.Hour)},
+			{Type: crdt.OpUpdate, Lamport: 3, NodeID: nodeID, FileID: fileID, LineID: kept, Content: "value2", Timestamp: time.Now().Add(-2 * time.Hour)},
+			{Type: crdt.OpUpdate, Lamport: 4, NodeID: nodeID, FileID: fileID, LineID: kept, Content: "value3", Timestamp: time.Now().Add(-1 * time.Hour)},
+			{Type: crdt.OpDelete, Lamport: 5, NodeID: nodeID, FileID: fileID, LineID: gone, Timestamp: time.Now()},
 		}
+		logPath := writeLog(t, repoPath, "stream1", fileID, ops)
+		before := materialize(t, logPath)
 
-		// Write operations to file
-		opsFile := filepath.Join(repoPath, ".evo", "ops", "test.bin")
-		f, err := os.Create(opsFile)
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer f.Close()
-
-		for _, op := range ops {
-			data, err := json.Marshal(op)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if _, err := f.Write(data); err != nil {
-				t.Fatal(err)
-			}
-		}
-
-		// Run compaction
 		config := &Config{
 			CompactionInterval: 100 * time.Millisecond,
 			TombstoneTTL:       30 * time.Minute,
-			MinOpsToKeep:       1,
+			MinOpsToKeep:       0,
 			MaxOps:             2,
 		}
 
 		service := NewCompactionService(repoPath, config)
-		if err := service.CompactOperations(); err != nil {
+		if err := service.CompactOperations(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 
-		// Verify results
-		// TODO: Add verification logic
+		after, err := evoops.LoadAllOps(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// insert + last update of kept, insert + recent tombstone of gone
+		if len(after) != 4 {
+			t.Errorf("Expected 4 operations after compaction, got %d", len(after))
+		}
+		if got := materialize(t, logPath); strings.Join(got, "\n") != strings.Join(before, "\n") {
+			t.Errorf("Compaction changed the document: %v => %v", before, got)
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, ".evo", "compact-backup")); !os.IsNotExist(err) {
+			t.Error("Expected no backup left after a successful rewrite")
+		}
 	})
 
 	t.Run("Tombstone Pruning", func(t *testing.T) {
 		fileID := uuid.New()
-		lineID := uuid.New()
 		nodeID := uuid.New()
+		live, old, anchor, child := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+		longAgo := time.Now().Add(-2 * time.Hour)
 
-		// Create test operations including a tombstone
 		ops := []crdt.Operation{
-			{
-				Type:      crdt.OpUpdate,
-				Lamport:   1,
-				NodeID:    nodeID,
-				FileID:    fileID,
-				LineID:    lineID,
-				Content:   "value1",
-				Stream:    "stream1",
-				Timestamp: time.Now(),
-				Vector:    []int64{1, 0, 0},
-			},
-			{
-				Type:      crdt.OpDelete,
-				Lamport:   2,
-				NodeID:    nodeID,
-				FileID:    fileID,
-				LineID:    uuid.New(), // Use a different LineID for the tombstone
-				Stream:    "stream1",
-				Timestamp: time.Now().Add(-2 * time.Hour), // Old tombstone
-				Vector:    []int64{1, 1, 0},
-			},
-		}
-
-		// Write operations to disk
-		opsDir := filepath.Join(repoPath, ".evo", "ops")
-		streamDir := filepath.Join(opsDir, "stream1")
-		if err := os.MkdirAll(streamDir, 0755); err != nil {
-			t.Fatal(err)
-		}
-
-		for _, op := range ops {
-			data, err := json.Marshal(op)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			// Write size prefix followed by data
-			opFile := filepath.Join(streamDir, op.LineID.String()+".bin")
-			f, err := os.Create(opFile)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			// Write 4-byte size prefix
-			size := uint32(len(data))
-			var sizeBuf [4]byte
-			binary.BigEndian.PutUint32(sizeBuf[:], size)
-			if _, err := f.Write(sizeBuf[:]); err != nil {
-				f.Close()
-				t.Fatal(err)
-			}
-
-			// Write operation data
-			if _, err := f.Write(data); err != nil {
-				f.Close()
-				t.Fatal(err)
-			}
-			f.Close()
+			{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, FileID: fileID, LineID: live, OriginLineID: crdt.DocumentStart, Content: "value1", Timestamp: longAgo},
+			{Type: crdt.OpInsert, Lamport: 2, NodeID: nodeID, FileID: fileID, LineID: old, OriginLineID: live, Content: "old", Timestamp: longAgo},
+			{Type: crdt.OpInsert, Lamport: 3, NodeID: nodeID, FileID: fileID, LineID: anchor, OriginLineID: live, Content: "anchor", Timestamp: longAgo},
+			{Type: crdt.OpInsert, Lamport: 4, NodeID: nodeID, FileID: fileID, LineID: child, OriginLineID: anchor, Content: "child", Timestamp: longAgo},
+			{Type: crdt.OpDelete, Lamport: 5, NodeID: nodeID, FileID: fileID, LineID: old, Timestamp: longAgo},
+			{Type: crdt.OpDelete, Lamport: 6, NodeID: nodeID, FileID: fileID, LineID: anchor, Timestamp: longAgo},
 		}
+		logPath := writeLog(t, repoPath, "stream2", fileID, ops)
+		before := materialize(t, logPath)
 
-		// Create and run compaction service
 		config := &Config{
 			CompactionInterval: 1 * time.Hour,
 			TombstoneTTL:       1 * time.Hour,
-			MinOpsToKeep:       1,
+			MinOpsToKeep:       0,
 			MaxOps:             10,
 		}
 
 		service := NewCompactionService(repoPath, config)
-		if err := service.PruneTombstones(); err != nil {
+		if err := service.PruneTombstones(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 
-		// Check that old tombstone was removed
-		files, err := os.ReadDir(streamDir)
+		after, err := evoops.LoadAllOps(logPath)
 		if err != nil {
 			t.Fatal(err)
 		}
+		for _, op := range after {
+			if op.LineID == old {
+				t.Error("Expected old tombstone to be pruned")
+			}
+		}
+		// anchor is deleted but child still hangs off it
+		if len(after) != 4 {
+			t.Errorf("Expected 4 operations after pruning, got %d", len(after))
+		}
+		if got := materialize(t, logPath); strings.Join(got, "\n") != strings.Join(before, "\n") {
+			t.Errorf("Pruning changed the document: %v => %v", before, got)
+		}
+	})
 
-		if len(files) != 1 {
-			t.Errorf("Expected 1 operation after pruning, got %d", len(files))
+	t.Run("Interrupted Rewrite", func(t *testing.T) {
+		fileID := uuid.New()
+		ops := []crdt.Operation{
+			{Type: crdt.OpInsert, Lamport: 1, FileID: fileID, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "original"},
+		}
+		logPath := writeLog(t, repoPath, "stream3", fileID, ops)
+		backup := filepath.Join(repoPath, ".evo", "compact-backup", "stream3", filepath.Base(logPath))
+		if err := backupLog(logPath, backup); err != nil {
+			t.Fatal(err)
+		}
+		// a half-written replacement
+		if err := os.WriteFile(logPath, []byte{0x80}, 0644); err != nil {
+			t.Fatal(err)
 		}
 
-		// The remaining operation should be the update
-		for _, f := range files {
-			data, err := os.ReadFile(filepath.Join(streamDir, f.Name()))
-			if err != nil {
-				t.Fatal(err)
-			}
+		service := NewCompactionService(repoPath, DefaultConfig())
+		if err := service.PruneTombstones(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := materialize(t, logPath); len(got) != 1 || got[0] != "original" {
+			t.Errorf("Expected original log to be restored, got %v", got)
+		}
+	})
+}
 
-			// Read size prefix
-			if len(data) < 4 {
-				t.Fatal("Invalid operation file: too short")
-			}
-			size := binary.BigEndian.Uint32(data[:4])
-			if len(data) < int(4+size) {
-				t.Fatalf("Invalid operation file: expected %d bytes after size prefix, got %d", size, len(data)-4)
-			}
-			opData := data[4 : 4+size]
+func TestCommitAwareCompaction(t *testing.T) {
+	repoPath := t.TempDir()
+	fileID := uuid.New()
+	nodeID := uuid.New()
+	line := uuid.New()
+	longAgo := time.Now().Add(-200 * 24 * time.Hour)
+	lately := time.Now().Add(-24 * time.Hour)
+
+	ops := []crdt.Operation{
+		{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, FileID: fileID, LineID: line, OriginLineID: crdt.DocumentStart, Content: "v1", Timestamp: longAgo},
+		{Type: crdt.OpUpdate, Lamport: 2, NodeID: nodeID, FileID: fileID, LineID: line, Content: "v2", Timestamp: longAgo},
+		{Type: crdt.OpUpdate, Lamport: 3, NodeID: nodeID, FileID: fileID, LineID: line, Content: "v3", Timestamp: longAgo},
+		{Type: crdt.OpUpdate, Lamport: 4, NodeID: nodeID, FileID: fileID, LineID: line, Content: "v4", Timestamp: longAgo},
+	}
+	logPath := writeLog(t, repoPath, "main", fileID, ops)
+
+	// an old commit owns the first two ops, a recent one (whose ops kept an
+	// old timestamp) the third
+	old := &types.Commit{ID: "old", Stream: "main", Timestamp: longAgo, Operations: []types.ExtendedOp{{Op: ops[0]}, {Op: ops[1]}}}
+	recent := &types.Commit{ID: "recent", Stream: "main", Timestamp: lately, Operations: []types.ExtendedOp{{Op: ops[2], OldContent: "v2"}}}
+	for _, c := range []*types.Commit{old, recent} {
+		if err := commits.SaveCommit(repoPath, c); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-			var op crdt.Operation
-			if err := json.Unmarshal(opData, &op); err != nil {
-				t.Fatal(err)
-			}
+	config := &Config{
+		CompactionInterval: time.Hour,
+		TombstoneTTL:       time.Hour,
+		MinOpsToKeep:       0,
+		MaxOps:             2,
+		HistoryHorizon:     90 * 24 * time.Hour,
+	}
+	// a cancelled compaction leaves the logs as they were
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewCompactionService(repoPath, config).CompactOperations(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled compaction to stop, got %v", err)
+	}
+	if kept, err := evoops.LoadAllOps(logPath); err != nil || len(kept) != len(ops) {
+		t.Fatalf("Expected the cancelled compaction to keep %d ops, got %d (%v)", len(ops), len(kept), err)
+	}
+	if err := NewCompactionService(repoPath, config).CompactOperations(context.Background()); err != nil {
+		t.Fatal(err)
+	}
 
-			if op.Type == crdt.OpDelete {
-				t.Error("Expected tombstone to be pruned")
-			}
+	after, err := evoops.LoadAllOps(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lamports []uint64
+	for _, op := range after {
+		lamports = append(lamports, op.Lamport)
+	}
+	// insert, the recent commit's update and the final update
+	if len(lamports) != 3 || lamports[0] != 1 || lamports[1] != 3 || lamports[2] != 4 {
+		t.Errorf("Expected ops 1, 3 and 4 to survive, got %v", lamports)
+	}
+
+	all, err := commits.ListCommits(repoPath, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Expected baseline and recent commit, got %d commits", len(all))
+	}
+	base := all[0]
+	if len(base.Squashed) != 1 || base.Squashed[0] != "old" {
+		t.Errorf("Expected baseline to squash the old commit, got %v", base.Squashed)
+	}
+	if len(base.Operations) != 1 || base.Operations[0].Op.Lamport != 1 {
+		t.Errorf("Expected baseline to keep the surviving insert, got %d ops", len(base.Operations))
+	}
+	if all[1].ID != "recent" {
+		t.Errorf("Expected recent commit to be untouched, got %s", all[1].ID)
+	}
+}
+
+func writeLog(t *testing.T, repoPath, stream string, fileID uuid.UUID, ops []crdt.Operation) string {
+	t.Helper()
+	logPath := filepath.Join(repoPath, ".evo", "ops", stream, fileID.String()+".bin")
+	for _, op := range ops {
+		if err := evoops.AppendOp(logPath, op); err != nil {
+			t.Fatal(err)
 		}
-	})
+	}
+	return logPath
+}
+
+func materialize(t *testing.T, logPath string) []string {
+	t.Helper()
+	all, err := evoops.LoadAllOps(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := crdt.NewRGA()
+	for _, op := range all {
+		doc.Apply(op)
+	}
+	return doc.Materialize()
 }
 
 func TestCompactionConfig(t *testing.T) {
@@ -0,0 +1,183 @@
+// Package convergence checks that RGA replicas converge: documents built from
+// the same ops must be identical whatever order the ops arrive in. History
+// generates the ops of replicas editing one file concurrently and syncing now
+// and then; Check applies them in random orders and compares the results.
+package convergence
+
+import (
+	"evo/internal/crdt"
+	"fmt"
+	"math/rand"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Options shapes a generated history
+type Options struct {
+	Replicas int
+	Edits    int     // edits across all replicas
+	Sync     float64 // chance a replica pulls another's ops before an edit
+}
+
+// DefaultOptions is a short history of three replicas syncing rarely
+var DefaultOptions = Options{Replicas: 3, Edits: 200, Sync: 0.1}
+
+// replica is one simulated copy of the file
+type replica struct {
+	node    uuid.UUID
+	doc     *crdt.RGA
+	lamport uint64
+	vector  crdt.VectorClock
+	have    []bool      // by index into the history
+	known   []uuid.UUID // lines ever inserted here, in order
+}
+
+func (r *replica) apply(i int, op crdt.Operation) error {
+	if err := r.doc.Apply(op); err != nil {
+		return err
+	}
+	r.have[i] = true
+	r.lamport = max(r.lamport, op.Lamport)
+	r.vector.Witness(op)
+	if op.Type == crdt.OpInsert && !slices.Contains(r.known, op.LineID) {
+		r.known = append(r.known, op.LineID)
+	}
+	return nil
+}
+
+// History returns the ops of o.Replicas replicas making o.Edits random edits
+// in total: inserts after any line, updates, deletes, and re-inserts of
+// deleted lines as revert makes them. Replicas only see each other's ops
+// when they sync, so most edits are concurrent.
+func History(rnd *rand.Rand, o Options) ([]crdt.Operation, error) {
+	var ops []crdt.Operation
+	replicas := make([]*replica, o.Replicas)
+	for i := range replicas {
+		replicas[i] = &replica{node: newID(rnd), doc: crdt.NewRGA(crdt.WithoutLog()), vector: make(crdt.VectorClock)}
+	}
+	for range o.Edits {
+		r := replicas[rnd.Intn(len(replicas))]
+		r.have = append(r.have, make([]bool, len(ops)-len(r.have))...)
+		if len(replicas) > 1 && rnd.Float64() < o.Sync {
+			from := replicas[rnd.Intn(len(replicas))]
+			for i, had := range from.have {
+				if had && !r.have[i] {
+					if err := r.apply(i, ops[i]); err != nil {
+						return nil, fmt.Errorf("failed to sync op %d: %w", i, err)
+					}
+				}
+			}
+		}
+
+		op := edit(rnd, r)
+		r.lamport++
+		op.Lamport = r.lamport
+		op.NodeID = r.node
+		r.vector.Stamp(&op)
+		ops = append(ops, op)
+		r.have = append(r.have, false)
+		if err := r.apply(len(ops)-1, op); err != nil {
+			return nil, fmt.Errorf("failed to apply op %d: %w", len(ops)-1, err)
+		}
+	}
+	return ops, nil
+}
+
+// edit returns a random edit of r's document, without its stamp
+func edit(rnd *rand.Rand, r *replica) crdt.Operation {
+	n := r.doc.Len()
+	content := fmt.Sprintf("%s %d", r.node.String()[:4], r.lamport+1)
+	switch k := rnd.Intn(10); {
+	case k < 2 && n > 0:
+		id, _, _ := r.doc.LineAt(rnd.Intn(n))
+		return crdt.Operation{Type: crdt.OpUpdate, LineID: id, Content: content}
+	case k < 4 && n > 0:
+		id, _, _ := r.doc.LineAt(rnd.Intn(n))
+		return crdt.Operation{Type: crdt.OpDelete, LineID: id}
+	case k < 5 && len(r.known) > n:
+		// revert re-inserts a deleted line without an origin
+		for {
+			id := r.known[rnd.Intn(len(r.known))]
+			if r.doc.IndexOf(id) < 0 {
+				return crdt.Operation{Type: crdt.OpInsert, LineID: id, Content: content}
+			}
+		}
+	}
+	origin := crdt.DocumentStart
+	if i := rnd.Intn(n + 1); i > 0 {
+		origin, _, _ = r.doc.LineAt(i - 1)
+	}
+	return crdt.Operation{Type: crdt.OpInsert, LineID: newID(rnd), OriginLineID: origin, Content: content, Fragment: rnd.Intn(10) == 0}
+}
+
+// newID returns a random UUID from rnd, so a seed's history is reproducible
+func newID(rnd *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	rnd.Read(id[:])
+	return id
+}
+
+// Check applies ops in their own order and then in orders random
+// permutations of it, and returns an error unless every document passes
+// Validate, has no insert left waiting for its origin and equals the first
+func Check(rnd *rand.Rand, ops []crdt.Operation, orders int) error {
+	want, err := build(ops)
+	if err != nil {
+		return fmt.Errorf("history order: %w", err)
+	}
+	for o := range orders {
+		perm := rnd.Perm(len(ops))
+		shuffled := make([]crdt.Operation, len(ops))
+		for i, j := range perm {
+			shuffled[i] = ops[j]
+		}
+		got, err := build(shuffled)
+		if err != nil {
+			return fmt.Errorf("order %d: %w", o, err)
+		}
+		if i := diff(want, got); i >= 0 {
+			return fmt.Errorf("order %d diverged at element %d of %d: %s", o, i, len(want), describe(want, got, i))
+		}
+	}
+	return nil
+}
+
+func build(ops []crdt.Operation) ([]crdt.Element, error) {
+	doc := crdt.NewRGA(crdt.WithoutLog())
+	for i, op := range ops {
+		if err := doc.Apply(op); err != nil {
+			return nil, fmt.Errorf("failed to apply op %d: %w", i, err)
+		}
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	if n := doc.Pending(); n > 0 {
+		return nil, fmt.Errorf("%d inserts still wait for their origin", n)
+	}
+	return doc.Elements(), nil
+}
+
+// diff returns the first index where a and b differ, or -1
+func diff(a, b []crdt.Element) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return min(len(a), len(b))
+	}
+	return -1
+}
+
+func describe(want, got []crdt.Element, i int) string {
+	at := func(es []crdt.Element) string {
+		if i >= len(es) {
+			return "end of document"
+		}
+		return fmt.Sprintf("%s %q", es[i].ID, es[i].Content)
+	}
+	return fmt.Sprintf("want %s, got %s", at(want), at(got))
+}
@@ -0,0 +1,48 @@
+package convergence
+
+import (
+	"evo/internal/crdt"
+	"math/rand"
+	"testing"
+)
+
+func TestConvergence(t *testing.T) {
+	seeds, orders := int64(100), 10
+	if testing.Short() {
+		seeds = 10
+	}
+	for name, o := range map[string]Options{
+		"Default":       DefaultOptions,
+		"Never Synced":  {Replicas: 4, Edits: 150},
+		"Often Synced":  {Replicas: 3, Edits: 300, Sync: 0.5},
+		"One Replica":   {Replicas: 1, Edits: 200},
+		"Many Replicas": {Replicas: 12, Edits: 300, Sync: 0.3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for seed := range seeds {
+				rnd := rand.New(rand.NewSource(seed))
+				ops, err := History(rnd, o)
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if err := Check(rnd, ops, orders); err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	// one line inserted twice with one stamp can't converge: the content
+	// that arrives first wins
+	rnd := rand.New(rand.NewSource(1))
+	node, line := newID(rnd), newID(rnd)
+	ops := []crdt.Operation{
+		{Type: crdt.OpInsert, Lamport: 1, NodeID: node, LineID: line, OriginLineID: crdt.DocumentStart, Content: "a"},
+		{Type: crdt.OpInsert, Lamport: 1, NodeID: node, LineID: line, OriginLineID: crdt.DocumentStart, Content: "b"},
+	}
+	if err := Check(rnd, ops, 20); err == nil {
+		t.Error("Expected a diverging history to fail")
+	}
+}
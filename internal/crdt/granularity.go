@@ -0,0 +1,73 @@
+package crdt
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Granularity is what one element of a document holds. Line documents keep a
+// line per element; character and word documents keep fragments of text,
+// which suits prose and configuration where whole-line conflicts are noisy.
+type Granularity string
+
+const (
+	GranularityLine Granularity = "line"
+	GranularityChar Granularity = "char"
+	GranularityWord Granularity = "word"
+)
+
+// ParseGranularity validates a granularity name, "" meaning lines
+func ParseGranularity(s string) (Granularity, error) {
+	switch Granularity(s) {
+	case "", GranularityLine:
+		return GranularityLine, nil
+	case GranularityChar, GranularityWord:
+		return Granularity(s), nil
+	}
+	return "", fmt.Errorf("unknown granularity: %s (expected line, char or word)", s)
+}
+
+// Fragments reports whether elements of g are fragments rather than lines
+func (g Granularity) Fragments() bool {
+	return g == GranularityChar || g == GranularityWord
+}
+
+// Split cuts text into the fragments of a character or word document.
+// Characters are runes; words are runs of letters and digits, runs of
+// whitespace, and single other runes. A line break is always a fragment of its
+// own. Empty text is one empty fragment, so an empty file still exists.
+func (g Granularity) Split(text string) []string {
+	if text == "" {
+		return []string{""}
+	}
+	var out []string
+	for i := 0; i < len(text); {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		j := i + n
+		if g == GranularityWord && r != '\n' {
+			class := wordClass(r)
+			for j < len(text) && class != 0 {
+				r2, n2 := utf8.DecodeRuneInString(text[j:])
+				if r2 == '\n' || wordClass(r2) != class {
+					break
+				}
+				j += n2
+			}
+		}
+		out = append(out, text[i:j])
+		i = j
+	}
+	return out
+}
+
+// wordClass groups runes that form one word fragment; 0 stands alone
+func wordClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return 1
+	case unicode.IsSpace(r):
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package crdt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGranularitySplit(t *testing.T) {
+	assert.Equal(t, []string{""}, GranularityChar.Split(""))
+	assert.Equal(t, []string{"h", "é", "\n", "!"}, GranularityChar.Split("hé\n!"))
+	assert.Equal(t, []string{"key", " ", "=", " ", "value_1", "\n", "\n", "  ", "x", "."}, GranularityWord.Split("key = value_1\n\n  x."))
+
+	g, err := ParseGranularity("")
+	assert.NoError(t, err)
+	assert.Equal(t, GranularityLine, g)
+	assert.False(t, g.Fragments())
+	_, err = ParseGranularity("token")
+	assert.Error(t, err)
+}
+
+func TestConcurrentFragmentEdits(t *testing.T) {
+	// two nodes change different words of the same line; both edits survive
+	a, b := uuid.New(), uuid.New()
+	base := NewRGA()
+	var ids []uuid.UUID
+	prev := DocumentStart
+	for i, w := range GranularityWord.Split("the quick fox") {
+		id := uuid.New()
+		assert.NoError(t, base.Apply(Operation{Type: OpInsert, Lamport: uint64(i + 1), NodeID: a, LineID: id, OriginLineID: prev, Content: w, Fragment: true}))
+		ids = append(ids, id)
+		prev = id
+	}
+	edits := []Operation{
+		{Type: OpUpdate, Lamport: 10, NodeID: a, LineID: ids[0], Content: "a", Fragment: true},
+		{Type: OpUpdate, Lamport: 10, NodeID: b, LineID: ids[4], Content: "dog", Fragment: true},
+	}
+	for _, order := range [][]Operation{edits, {edits[1], edits[0]}} {
+		rga := NewRGA()
+		for _, op := range base.GetOperations() {
+			assert.NoError(t, rga.Apply(op))
+		}
+		for _, op := range order {
+			assert.NoError(t, rga.Apply(op))
+		}
+		assert.Equal(t, "a quick dog", strings.Join(rga.Materialize(), ""))
+		assert.Equal(t, 5, rga.Fragments())
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// DocumentStart is the OriginLineID of a line inserted at the top of a file
+var DocumentStart = uuid.Max
+
 // OpType represents the type of operation
 type OpType int
 
@@ -17,15 +20,17 @@ const (
 
 // Operation represents a CRDT operation
 type Operation struct {
-	Type      OpType    // Type of operation
-	Lamport   uint64    // Lamport timestamp for ordering
-	NodeID    uuid.UUID // ID of the node that created this operation
-	FileID    uuid.UUID // ID of the file being modified
-	LineID    uuid.UUID // ID of the line being modified
-	Content   string    // Content for insert/update operations
-	Stream    string    // Stream this operation belongs to
-	Timestamp time.Time // When the operation occurred
-	Vector    []int64   // Vector clock for causal ordering
+	Type         OpType      // Type of operation
+	Lamport      uint64      // Lamport timestamp for ordering
+	NodeID       uuid.UUID   // ID of the node that created this operation
+	FileID       uuid.UUID   // ID of the file being modified
+	LineID       uuid.UUID   // ID of the line being modified
+	OriginLineID uuid.UUID   // Line an insert goes after (DocumentStart = top, Nil = legacy)
+	Content      string      // Content for insert/update operations
+	Fragment     bool        // Content is a piece of text joined to its neighbours without a line break
+	Stream       string      // Stream this operation belongs to
+	Timestamp    time.Time   // When the operation occurred
+	Vector       VectorClock // Ops of each node this op has seen
 }
 
 // CanCombine checks if two operations can be combined
@@ -56,19 +61,10 @@ func (o *Operation) Combine(other *Operation) {
 	o.Lamport = other.Lamport
 	o.Timestamp = other.Timestamp
 
-	// Extend vector clock if needed
-	if len(other.Vector) > len(o.Vector) {
-		newVec := make([]int64, len(other.Vector))
-		copy(newVec, o.Vector)
-		o.Vector = newVec
-	}
-
-	// Update vector clock values
-	for i := 0; i < len(other.Vector); i++ {
-		if i < len(o.Vector) {
-			o.Vector[i] = other.Vector[i]
-		}
-	}
+	// The combined op has seen everything either op had seen
+	vector := VectorOf(*o)
+	vector.Merge(VectorOf(*other))
+	o.Vector = vector
 }
 
 // LessThan compares operations for ordering
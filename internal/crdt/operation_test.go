@@ -22,7 +22,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1, 0, 0},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		op2 := &Operation{
@@ -34,7 +34,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: op1.Timestamp.Add(time.Second),
-			Vector:    []int64{1, 1, 0},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		if !op1.CanCombine(op2) {
@@ -47,8 +47,8 @@ func TestOperationCombining(t *testing.T) {
 			t.Errorf("Expected combined content to be 'value2', got '%s'", op1.Content)
 		}
 
-		if op1.Vector[1] != 1 {
-			t.Errorf("Expected vector clock [1] to be 1, got %d", op1.Vector[1])
+		if op1.Vector[nodeID] != 2 {
+			t.Errorf("Expected vector clock entry to be 2, got %d", op1.Vector[nodeID])
 		}
 	})
 
@@ -66,7 +66,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1, 0, 0},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		op2 := &Operation{
@@ -78,7 +78,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream2",
 			Timestamp: op1.Timestamp.Add(time.Second),
-			Vector:    []int64{1, 1, 0},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		if op1.CanCombine(op2) {
@@ -100,7 +100,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1, 0, 0},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		op2 := &Operation{
@@ -111,7 +111,7 @@ func TestOperationCombining(t *testing.T) {
 			LineID:    lineID,
 			Stream:    "stream1",
 			Timestamp: op1.Timestamp.Add(time.Second),
-			Vector:    []int64{1, 1, 0},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		if op1.CanCombine(op2) {
@@ -123,6 +123,8 @@ func TestOperationCombining(t *testing.T) {
 		fileID := uuid.New()
 		lineID := uuid.New()
 		nodeID := uuid.New()
+		otherA := uuid.New()
+		otherB := uuid.New()
 
 		op1 := &Operation{
 			Type:      OpUpdate,
@@ -133,7 +135,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1, 0},
+			Vector:    VectorClock{nodeID: 1, otherA: 4},
 		}
 
 		op2 := &Operation{
@@ -145,7 +147,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: op1.Timestamp.Add(time.Second),
-			Vector:    []int64{1, 1, 1},
+			Vector:    VectorClock{nodeID: 2, otherA: 3, otherB: 1},
 		}
 
 		if !op1.CanCombine(op2) {
@@ -158,8 +160,8 @@ func TestOperationCombining(t *testing.T) {
 			t.Errorf("Expected vector clock length to be 3, got %d", len(op1.Vector))
 		}
 
-		if op1.Vector[2] != 1 {
-			t.Errorf("Expected vector clock [2] to be 1, got %d", op1.Vector[2])
+		if op1.Vector[otherA] != 4 || op1.Vector[otherB] != 1 {
+			t.Errorf("Expected vector clock to keep the highest entries, got %v", op1.Vector)
 		}
 	})
 
@@ -177,7 +179,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{1, 0, 0},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		op2 := &Operation{
@@ -189,7 +191,7 @@ func TestOperationCombining(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1, 1, 0},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		if op1.CanCombine(op2) {
@@ -214,7 +216,7 @@ func TestOperationOrdering(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: now,
-			Vector:    []int64{1, 0, 0},
+			Vector:    VectorClock{nodeID: 1},
 		},
 		{
 			Type:      OpUpdate,
@@ -225,7 +227,7 @@ func TestOperationOrdering(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: now.Add(time.Second),
-			Vector:    []int64{1, 1, 0},
+			Vector:    VectorClock{nodeID: 2},
 		},
 		{
 			Type:      OpDelete,
@@ -235,7 +237,7 @@ func TestOperationOrdering(t *testing.T) {
 			LineID:    lineID,
 			Stream:    "stream1",
 			Timestamp: now.Add(2 * time.Second),
-			Vector:    []int64{1, 1, 1},
+			Vector:    VectorClock{nodeID: 3},
 		},
 	}
 
@@ -252,16 +254,7 @@ func TestOperationOrdering(t *testing.T) {
 	t.Run("Vector Clock Order", func(t *testing.T) {
 		// Test that vector clocks are monotonically increasing
 		for i := 1; i < len(ops); i++ {
-			prev := ops[i-1].Vector
-			curr := ops[i].Vector
-			increasing := false
-			for j := 0; j < len(prev) && j < len(curr); j++ {
-				if curr[j] > prev[j] {
-					increasing = true
-					break
-				}
-			}
-			if !increasing {
+			if ops[i-1].Vector.Compare(ops[i].Vector) != Before {
 				t.Errorf("Expected vector clock to increase between op%d and op%d", i, i+1)
 			}
 		}
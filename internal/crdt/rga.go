@@ -1,7 +1,9 @@
 package crdt
 
 import (
+	"bytes"
 	"fmt"
+	"slices"
 	"sort"
 	"sync"
 
@@ -22,18 +24,110 @@ func NewRGAOperation(op Operation, index int) RGAOperation {
 	}
 }
 
+// rgaNode is one line in the RGA tree. Each insert is a child of the line it
+// was inserted after (its origin), and the document is the pre-order walk of
+// the tree. Siblings are ordered newest first, so a later insert after the
+// same origin lands closer to it and concurrent runs of inserts stay contiguous.
+// The walk itself is kept in a sequence so reads never traverse the tree.
+//
+// A node keeps only what ordering and last-writer-wins need, not the insert
+// op, and holds its place in the sequence inline, so a line costs one
+// allocation of a couple hundred bytes plus its content.
+type rgaNode struct {
+	seq      seqNode
+	id       uuid.UUID
+	created  stamp // the first insert, for sibling order
+	content  string
+	written  stamp // last write to content
+	revived  stamp // last insert of this line
+	removed  stamp // last delete
+	parent   *rgaNode
+	children []*rgaNode
+	legacy   bool // inserted without an origin
+	deleted  bool // removed after revived
+	fragment bool // a piece of text, not a line
+}
+
+// stamp identifies an op for last-writer-wins decisions. The node points
+// into the RGA's table of node IDs, since a document has few writers but
+// every line holds several stamps.
+type stamp struct {
+	lamport uint64
+	node    *uuid.UUID
+}
+
+func (r *RGA) stampOf(op Operation) stamp {
+	id, ok := r.nodes[op.NodeID]
+	if !ok {
+		id = new(uuid.UUID)
+		*id = op.NodeID
+		r.nodes[op.NodeID] = id
+	}
+	return stamp{lamport: op.Lamport, node: id}
+}
+
+func (s stamp) after(o stamp) bool {
+	if s.lamport != o.lamport {
+		return s.lamport > o.lamport
+	}
+	// byte order of IDs is the order of their string form
+	var a, b uuid.UUID
+	if s.node != nil {
+		a = *s.node
+	}
+	if o.node != nil {
+		b = *o.node
+	}
+	return bytes.Compare(a[:], b[:]) > 0
+}
+
 // RGA represents a Replicated Growable Array CRDT
 type RGA struct {
-	mu        sync.RWMutex
-	ops       []RGAOperation
-	tombstone map[string]bool
+	mu       sync.RWMutex
+	root     *rgaNode
+	seq      *sequence
+	lines    map[uuid.UUID]*rgaNode
+	nodes    map[uuid.UUID]*uuid.UUID // interned node IDs of stamps
+	frags    int                      // fragment elements, deleted ones included
+	log      []RGAOperation
+	nolog    bool
+	pending  map[uuid.UUID][]Operation // inserts waiting for their origin
+	deletes  map[uuid.UUID]Operation   // latest delete that arrived before its insert
+	updates  map[uuid.UUID]Operation   // latest update that arrived before its insert
+	orphaned int
+}
+
+// Option configures an RGA
+type Option func(*RGA)
+
+// WithoutLog keeps no copy of applied ops, for documents that only need their
+// lines; GetOperations then returns nothing. Op logs of large files cost more
+// memory than the lines they produce.
+func WithoutLog() Option {
+	return func(r *RGA) { r.nolog = true }
 }
 
 // NewRGA creates a new RGA instance
-func NewRGA() *RGA {
-	return &RGA{
-		ops:       make([]RGAOperation, 0),
-		tombstone: make(map[string]bool),
+func NewRGA(opts ...Option) *RGA {
+	r := &RGA{
+		root:    &rgaNode{},
+		seq:     newSequence(),
+		lines:   make(map[uuid.UUID]*rgaNode),
+		nodes:   make(map[uuid.UUID]*uuid.UUID),
+		pending: make(map[uuid.UUID][]Operation),
+		deletes: make(map[uuid.UUID]Operation),
+		updates: make(map[uuid.UUID]Operation),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// record appends an applied op to the log
+func (r *RGA) record(op Operation) {
+	if !r.nolog {
+		r.log = append(r.log, NewRGAOperation(op, len(r.log)))
 	}
 }
 
@@ -42,47 +136,29 @@ func (r *RGA) Apply(op Operation) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	rgaOp := NewRGAOperation(op, len(r.ops))
-
 	switch op.Type {
 	case OpInsert:
-		// Filter out any previous operations for this LineID
-		newOps := make([]RGAOperation, 0)
-		for _, existingOp := range r.ops {
-			if existingOp.LineID != op.LineID {
-				newOps = append(newOps, existingOp)
-			}
-		}
-		r.ops = append(newOps, rgaOp)
-		// Clear tombstone status
-		delete(r.tombstone, op.LineID.String())
-		sort.Slice(r.ops, func(i, j int) bool {
-			return r.ops[i].LessThan(&r.ops[j].Operation)
-		})
+		r.insert(op)
 	case OpDelete:
-		// Get content before marking as deleted
-		var content string
-		for _, op := range r.ops {
-			if op.LineID == rgaOp.LineID && !r.tombstone[op.LineID.String()] {
-				content = op.Content
-				break
-			}
+		n, ok := r.lines[op.LineID]
+		if !ok {
+			r.hold(r.deletes, op)
+			r.record(op)
+			return nil
 		}
-		rgaOp.Content = content // Store content in the delete operation
-		r.ops = append(r.ops, rgaOp)
-		r.tombstone[op.LineID.String()] = true
+		// Store content in the delete operation
+		op.Content = n.content
+		r.delete(n, op)
+		r.record(op)
 	case OpUpdate:
-		found := false
-		for i := range r.ops {
-			if r.ops[i].LineID == op.LineID {
-				r.ops[i].Content = op.Content
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("line not found for update: %s", op.LineID)
+		n, ok := r.lines[op.LineID]
+		if !ok {
+			r.hold(r.updates, op)
+			r.record(op)
+			return nil
 		}
+		r.write(n, op)
+		r.record(op)
 	default:
 		return fmt.Errorf("unknown operation type: %d", op.Type)
 	}
@@ -90,39 +166,218 @@ func (r *RGA) Apply(op Operation) error {
 	return nil
 }
 
-// Get returns the current state of the RGA
-func (r *RGA) Get() []string {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// hold keeps an op on a line not inserted yet until it is, if it beats the
+// op already held
+func (r *RGA) hold(held map[uuid.UUID]Operation, op Operation) {
+	if h, ok := held[op.LineID]; !ok || r.stampOf(op).after(r.stampOf(h)) {
+		held[op.LineID] = op
+	}
+}
 
-	var result []string
-	for _, op := range r.ops {
-		if !r.tombstone[op.LineID.String()] {
-			result = append(result, op.Content)
+// write sets a line's content unless a later write already did
+func (r *RGA) write(n *rgaNode, op Operation) {
+	if s := r.stampOf(op); s.after(n.written) {
+		n.content = op.Content
+		n.written = s
+	}
+}
+
+func (r *RGA) insert(op Operation) {
+	if n, ok := r.lines[op.LineID]; ok {
+		// re-insert of a known line (e.g. a reverted delete) revives it in
+		// place. The first insert places the line, so one older than the
+		// insert that placed it moves the line where it says.
+		s := r.stampOf(op)
+		if n.created.after(s) {
+			if !r.parentOf(op) {
+				return
+			}
+			r.move(n, op)
+		}
+		if s.after(n.revived) {
+			n.revived = s
+			r.setDeleted(n, n.removed.after(n.revived))
 		}
+		r.write(n, op)
+		r.record(op)
+		return
+	}
+
+	if !r.parentOf(op) {
+		return
+	}
+	s := r.stampOf(op)
+	n := &rgaNode{id: op.LineID, created: s, legacy: op.OriginLineID == uuid.Nil, fragment: op.Fragment, content: op.Content, written: s, revived: s}
+	if n.fragment {
+		r.frags++
+	}
+	n.seq.line = n
+	r.lines[op.LineID] = n
+	r.seq.insertAfter(r.place(n, r.origin(op)), &n.seq)
+	r.record(op)
+
+	if up, ok := r.updates[op.LineID]; ok {
+		delete(r.updates, op.LineID)
+		r.write(n, up)
+	}
+	if del, ok := r.deletes[op.LineID]; ok {
+		delete(r.deletes, op.LineID)
+		r.delete(n, del)
+	}
+	if waiting, ok := r.pending[op.LineID]; ok {
+		delete(r.pending, op.LineID)
+		r.orphaned -= len(waiting)
+		for _, w := range waiting {
+			r.insert(w)
+		}
+	}
+}
+
+// parentOf reports whether the origin of an insert is known; if not, the
+// insert waits for it
+func (r *RGA) parentOf(op Operation) bool {
+	if _, ok := r.lines[op.OriginLineID]; ok || op.OriginLineID == uuid.Nil || op.OriginLineID == DocumentStart {
+		return true
+	}
+	r.pending[op.OriginLineID] = append(r.pending[op.OriginLineID], op)
+	r.orphaned++
+	return false
+}
+
+// origin returns the node an insert whose origin is known goes under
+func (r *RGA) origin(op Operation) *rgaNode {
+	if p, ok := r.lines[op.OriginLineID]; ok {
+		return p
+	}
+	return r.root
+}
+
+// place makes n a child of parent and returns the line n follows in
+// document order: its parent, or the last line of the subtree of the
+// sibling before it. That is nil for the first line, as the root is none.
+func (r *RGA) place(n, parent *rgaNode) *seqNode {
+	n.parent = parent
+	i := sort.Search(len(parent.children), func(i int) bool {
+		return siblingBefore(n, parent.children[i])
+	})
+	parent.children = append(parent.children, nil)
+	copy(parent.children[i+1:], parent.children[i:])
+	parent.children[i] = n
+
+	prev := parent
+	if i > 0 {
+		prev = last(parent.children[i-1])
+	}
+	if prev == r.root {
+		return nil
+	}
+	return &prev.seq
+}
+
+// last returns the last line of n's subtree in document order
+func last(n *rgaNode) *rgaNode {
+	for len(n.children) > 0 {
+		n = n.children[len(n.children)-1]
+	}
+	return n
+}
+
+// move re-places a line and the lines after it in its subtree as inserted by
+// op. An origin inside the subtree would make a cycle; such an op only
+// revives the line.
+func (r *RGA) move(n *rgaNode, op Operation) {
+	parent := r.origin(op)
+	for p := parent; p != nil; p = p.parent {
+		if p == n {
+			return
+		}
+	}
+	siblings := n.parent.children
+	i := slices.Index(siblings, n)
+	n.parent.children = append(siblings[:i], siblings[i+1:]...)
+	tree := r.seq.cut(&n.seq, &last(n).seq)
+
+	n.created = r.stampOf(op)
+	n.legacy = op.OriginLineID == uuid.Nil
+	if n.fragment != op.Fragment {
+		n.fragment = op.Fragment
+		if n.fragment {
+			r.frags++
+		} else {
+			r.frags--
+		}
+	}
+	r.seq.splice(r.place(n, parent), tree)
+}
+
+// delete tombstones a line; a re-insert with a later stamp revives it, so the
+// outcome does not depend on the order deletes and re-inserts arrive in
+func (r *RGA) delete(n *rgaNode, op Operation) {
+	if s := r.stampOf(op); s.after(n.removed) {
+		n.removed = s
+		r.setDeleted(n, n.removed.after(n.revived))
+	}
+}
+
+func (r *RGA) setDeleted(n *rgaNode, deleted bool) {
+	if n.deleted != deleted {
+		n.deleted = deleted
+		r.seq.refresh(&n.seq)
 	}
-	return result
 }
 
-// GetOperations returns all operations in order
+// siblingBefore reports whether a sorts before b among children of one parent.
+// Anchored inserts go newest first; legacy inserts without an origin keep the
+// old global Lamport order and come after anchored ones.
+func siblingBefore(a, b *rgaNode) bool {
+	if a.legacy != b.legacy {
+		return !a.legacy
+	}
+	if a.legacy {
+		return b.created.after(a.created)
+	}
+	return a.created.after(b.created)
+}
+
+// Get returns the current state of the RGA
+func (r *RGA) Get() []string {
+	return r.Materialize()
+}
+
+// GetOperations returns all applied operations in the order they were applied
 func (r *RGA) GetOperations() []Operation {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	result := make([]Operation, len(r.ops))
-	for i, op := range r.ops {
+	result := make([]Operation, len(r.log))
+	for i, op := range r.log {
 		result[i] = op.Operation
 	}
 	return result
 }
 
+// Pending returns the number of inserts still waiting for their origin line
+func (r *RGA) Pending() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.orphaned
+}
+
 // Clear removes all operations and resets the RGA
 func (r *RGA) Clear() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.ops = make([]RGAOperation, 0)
-	r.tombstone = make(map[string]bool)
+	r.root = &rgaNode{}
+	r.seq = newSequence()
+	r.lines = make(map[uuid.UUID]*rgaNode)
+	r.nodes = make(map[uuid.UUID]*uuid.UUID)
+	r.log = nil
+	r.pending = make(map[uuid.UUID][]Operation)
+	r.deletes = make(map[uuid.UUID]Operation)
+	r.updates = make(map[uuid.UUID]Operation)
+	r.orphaned = 0
+	r.frags = 0
 }
 
 // Materialize returns the current document state as a slice of strings
@@ -131,25 +386,58 @@ func (r *RGA) Materialize() []string {
 	defer r.mu.RUnlock()
 
 	var result []string
-	for _, op := range r.ops {
-		if !r.tombstone[op.LineID.String()] {
-			result = append(result, op.Content)
+	r.seq.each(func(n *rgaNode) {
+		if !n.deleted {
+			result = append(result, n.content)
 		}
-	}
+	})
 	return result
 }
 
-// GetPositions returns the positions of all active lines
+// Element is one active element of a document: a line, or a fragment of text
+// in a character or word document
+type Element struct {
+	ID       uuid.UUID
+	Content  string
+	Fragment bool
+}
+
+// Elements returns the active elements in document order
+func (r *RGA) Elements() []Element {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Element, 0, visible(r.seq.root))
+	r.seq.each(func(n *rgaNode) {
+		if !n.deleted {
+			out = append(out, Element{ID: n.id, Content: n.content, Fragment: n.fragment})
+		}
+	})
+	return out
+}
+
+// Fragments returns the number of fragment elements ever inserted; documents
+// without any are plain lines
+func (r *RGA) Fragments() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.frags
+}
+
+// GetPositions returns the positions of all active lines among all lines,
+// deleted ones included
 func (r *RGA) GetPositions() []int {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	var positions []int
-	for i, op := range r.ops {
-		if !r.tombstone[op.LineID.String()] {
+	i := 0
+	r.seq.each(func(n *rgaNode) {
+		if !n.deleted {
 			positions = append(positions, i)
 		}
-	}
+		i++
+	})
 	return positions
 }
 
@@ -159,23 +447,55 @@ func (r *RGA) GetLineIDs() []uuid.UUID {
 	defer r.mu.RUnlock()
 
 	var lineIDs []uuid.UUID
-	for _, op := range r.ops {
-		if !r.tombstone[op.LineID.String()] {
-			lineIDs = append(lineIDs, op.LineID)
+	r.seq.each(func(n *rgaNode) {
+		if !n.deleted {
+			lineIDs = append(lineIDs, n.id)
 		}
-	}
+	})
 	return lineIDs
 }
 
+// Len returns the number of active lines
+func (r *RGA) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return visible(r.seq.root)
+}
+
+// LineAt returns the LineID and content of the i-th active line
+func (r *RGA) LineAt(i int) (uuid.UUID, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if i < 0 || i >= visible(r.seq.root) {
+		return uuid.Nil, "", false
+	}
+	n := r.seq.at(i)
+	return n.id, n.content, true
+}
+
+// IndexOf returns the position of an active line, or -1 if it is unknown or
+// deleted
+func (r *RGA) IndexOf(lineID uuid.UUID) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n, ok := r.lines[lineID]
+	if !ok || n.deleted {
+		return -1
+	}
+	return r.seq.visibleRank(&n.seq)
+}
+
 // LineMap returns a map of LineID to Content for all active lines
 func (r *RGA) LineMap() map[uuid.UUID]string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	result := make(map[uuid.UUID]string)
-	for _, op := range r.ops {
-		if !r.tombstone[op.LineID.String()] {
-			result[op.LineID] = op.Content
+	for id, n := range r.lines {
+		if !n.deleted {
+			result[id] = n.content
 		}
 	}
 	return result
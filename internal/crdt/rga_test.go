@@ -1,6 +1,10 @@
 package crdt
 
 import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,7 +29,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		op2 := Operation{
@@ -37,7 +41,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply operations
@@ -78,7 +82,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		// Apply insert
@@ -96,7 +100,7 @@ func TestRGA(t *testing.T) {
 			LineID:    lineID,
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply delete
@@ -128,7 +132,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		// Apply insert
@@ -147,7 +151,7 @@ func TestRGA(t *testing.T) {
 			Content:   "updated",
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply update
@@ -167,7 +171,7 @@ func TestRGA(t *testing.T) {
 		}
 	})
 
-	t.Run("Invalid Update Operation", func(t *testing.T) {
+	t.Run("Update Before Insert", func(t *testing.T) {
 		rga := NewRGA()
 		fileID := uuid.New()
 		lineID := uuid.New()
@@ -183,13 +187,26 @@ func TestRGA(t *testing.T) {
 			Content:   "updated",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
-		// Apply update
-		err := rga.Apply(updateOp)
-		if err == nil {
-			t.Error("Expected error when updating non-existent line")
+		// an update that arrives first waits for its line
+		if err := rga.Apply(updateOp); err != nil {
+			t.Fatalf("Failed to apply update: %v", err)
+		}
+		if len(rga.Get()) != 0 {
+			t.Errorf("Expected no lines, got %v", rga.Get())
+		}
+		insertOp := updateOp
+		insertOp.Type = OpInsert
+		insertOp.Lamport = 0
+		insertOp.OriginLineID = DocumentStart
+		insertOp.Content = "inserted"
+		if err := rga.Apply(insertOp); err != nil {
+			t.Fatalf("Failed to apply insert: %v", err)
+		}
+		if values := rga.Get(); len(values) != 1 || values[0] != "updated" {
+			t.Errorf("Expected the update to win, got %v", values)
 		}
 	})
 
@@ -210,7 +227,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value1",
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		op2 := Operation{
@@ -222,7 +239,7 @@ func TestRGA(t *testing.T) {
 			Content:   "value2",
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply operations
@@ -268,7 +285,7 @@ func TestRGA(t *testing.T) {
 			Content:   content,
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		// Apply insert
@@ -286,7 +303,7 @@ func TestRGA(t *testing.T) {
 			LineID:    lineID,
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply delete
@@ -329,7 +346,7 @@ func TestRGA(t *testing.T) {
 			Content:   content,
 			Stream:    "stream1",
 			Timestamp: time.Now(),
-			Vector:    []int64{1},
+			Vector:    VectorClock{nodeID: 1},
 		}
 
 		// Apply insert
@@ -347,7 +364,7 @@ func TestRGA(t *testing.T) {
 			LineID:    lineID,
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(time.Second),
-			Vector:    []int64{2},
+			Vector:    VectorClock{nodeID: 2},
 		}
 
 		// Apply delete
@@ -366,7 +383,7 @@ func TestRGA(t *testing.T) {
 			Content:   content,
 			Stream:    "stream1",
 			Timestamp: time.Now().Add(2 * time.Second),
-			Vector:    []int64{3},
+			Vector:    VectorClock{nodeID: 3},
 		}
 
 		// Apply reinsert
@@ -384,3 +401,234 @@ func TestRGA(t *testing.T) {
 		}
 	})
 }
+
+func TestRGAOrigins(t *testing.T) {
+	fileID := uuid.New()
+	nodeA := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	nodeB := uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	ins := func(lamport uint64, node, line, origin uuid.UUID, content string) Operation {
+		return Operation{Type: OpInsert, Lamport: lamport, NodeID: node, FileID: fileID, LineID: line, OriginLineID: origin, Content: content}
+	}
+
+	top := uuid.New()
+	a1, a2 := uuid.New(), uuid.New()
+	b1, b2 := uuid.New(), uuid.New()
+	base := ins(1, nodeA, top, DocumentStart, "top")
+	// two replicas each insert a run of lines after "top"
+	runA := []Operation{ins(2, nodeA, a1, top, "a1"), ins(3, nodeA, a2, a1, "a2")}
+	runB := []Operation{ins(2, nodeB, b1, top, "b1"), ins(3, nodeB, b2, b1, "b2")}
+
+	t.Run("Concurrent Runs Stay Contiguous", func(t *testing.T) {
+		orders := [][]Operation{
+			append(append([]Operation{base}, runA...), runB...),
+			append(append([]Operation{base}, runB...), runA...),
+			{base, runA[0], runB[0], runA[1], runB[1]},
+		}
+		var first []string
+		for _, order := range orders {
+			rga := NewRGA()
+			for _, op := range order {
+				if err := rga.Apply(op); err != nil {
+					t.Fatalf("Failed to apply operation: %v", err)
+				}
+			}
+			got := rga.Materialize()
+			if first == nil {
+				first = got
+			}
+			if strings.Join(got, ",") != strings.Join(first, ",") {
+				t.Errorf("Replicas diverged: %v vs %v", got, first)
+			}
+		}
+		if strings.Join(first, ",") != "top,b1,b2,a1,a2" {
+			t.Errorf("Expected contiguous runs, got %v", first)
+		}
+	})
+
+	t.Run("Insert Between Lines", func(t *testing.T) {
+		rga := NewRGA()
+		mid := uuid.New()
+		for _, op := range append([]Operation{base}, runA...) {
+			rga.Apply(op)
+		}
+		// a later insert after "top" lands right after it
+		rga.Apply(ins(4, nodeB, mid, top, "mid"))
+		if got := strings.Join(rga.Materialize(), ","); got != "top,mid,a1,a2" {
+			t.Errorf("Unexpected order: %s", got)
+		}
+	})
+
+	t.Run("Out Of Order Delivery", func(t *testing.T) {
+		rga := NewRGA()
+		rga.Apply(runA[1])
+		rga.Apply(runA[0])
+		if rga.Pending() != 2 {
+			t.Errorf("Expected 2 pending inserts, got %d", rga.Pending())
+		}
+		rga.Apply(base)
+		if rga.Pending() != 0 {
+			t.Errorf("Expected no pending inserts, got %d", rga.Pending())
+		}
+		if got := strings.Join(rga.Materialize(), ","); got != "top,a1,a2" {
+			t.Errorf("Unexpected order: %s", got)
+		}
+	})
+
+	t.Run("Re-Insert Before Insert", func(t *testing.T) {
+		// revert re-inserts a line without an origin; arriving before the
+		// insert that placed the line, it must not decide where the line goes
+		revive := Operation{Type: OpInsert, Lamport: 9, NodeID: nodeB, FileID: fileID, LineID: a1, Content: "a1"}
+		rga := NewRGA()
+		for _, op := range []Operation{revive, runA[1], base, runA[0]} {
+			rga.Apply(op)
+		}
+		if got := strings.Join(rga.Materialize(), ","); got != "top,a1,a2" {
+			t.Errorf("Unexpected order: %s", got)
+		}
+		if err := rga.Validate(); err != nil {
+			t.Errorf("Invalid document: %v", err)
+		}
+	})
+}
+
+// TestRGAIndexed checks position lookups against a plain slice while lines are
+// inserted and deleted at random positions
+func TestRGAIndexed(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	nodeID := uuid.New()
+	rga := NewRGA()
+	var model []uuid.UUID
+	content := make(map[uuid.UUID]string)
+	var lamport uint64
+
+	for step := 0; step < 2000; step++ {
+		lamport++
+		if len(model) > 0 && rnd.Intn(4) == 0 {
+			i := rnd.Intn(len(model))
+			if err := rga.Apply(Operation{Type: OpDelete, Lamport: lamport, NodeID: nodeID, LineID: model[i]}); err != nil {
+				t.Fatal(err)
+			}
+			model = append(model[:i], model[i+1:]...)
+			continue
+		}
+		pos := rnd.Intn(len(model) + 1)
+		origin := DocumentStart
+		if pos > 0 {
+			origin = model[pos-1]
+		}
+		id := uuid.New()
+		content[id] = fmt.Sprintf("line %d", step)
+		if err := rga.Apply(Operation{Type: OpInsert, Lamport: lamport, NodeID: nodeID, LineID: id, OriginLineID: origin, Content: content[id]}); err != nil {
+			t.Fatal(err)
+		}
+		model = append(model, uuid.Nil)
+		copy(model[pos+1:], model[pos:])
+		model[pos] = id
+	}
+
+	if rga.Len() != len(model) {
+		t.Fatalf("Expected %d lines, got %d", len(model), rga.Len())
+	}
+	lines := rga.Materialize()
+	for i, id := range model {
+		if lines[i] != content[id] {
+			t.Fatalf("Line %d: expected %q, got %q", i, content[id], lines[i])
+		}
+		got, text, ok := rga.LineAt(i)
+		if !ok || got != id || text != content[id] {
+			t.Fatalf("LineAt(%d) returned the wrong line", i)
+		}
+		if idx := rga.IndexOf(id); idx != i {
+			t.Fatalf("IndexOf: expected %d, got %d", i, idx)
+		}
+	}
+	if _, _, ok := rga.LineAt(len(model)); ok {
+		t.Error("Expected LineAt past the end to fail")
+	}
+}
+
+func BenchmarkRGAAppend(b *testing.B) {
+	nodeID := uuid.New()
+	for i := 0; i < b.N; i++ {
+		rga := NewRGA()
+		prev := DocumentStart
+		for j := 0; j < 10000; j++ {
+			id := uuid.New()
+			rga.Apply(Operation{Type: OpInsert, Lamport: uint64(j + 1), NodeID: nodeID, LineID: id, OriginLineID: prev, Content: "line"})
+			prev = id
+		}
+		rga.Materialize()
+	}
+}
+
+// largeLines is the size of the documents the large file benchmarks edit
+const largeLines = 500_000
+
+// buildLarge appends n lines written by one node, each after the last
+func buildLarge(n int, opts ...Option) (*RGA, uuid.UUID, []uuid.UUID) {
+	nodeID := uuid.New()
+	rga := NewRGA(opts...)
+	ids := make([]uuid.UUID, n)
+	prev := DocumentStart
+	for j := range ids {
+		ids[j] = uuid.New()
+		rga.Apply(Operation{Type: OpInsert, Lamport: uint64(j + 1), NodeID: nodeID, LineID: ids[j], OriginLineID: prev, Content: "line", Vector: VectorClock{nodeID: uint64(j + 1)}})
+		prev = ids[j]
+	}
+	return rga, nodeID, ids
+}
+
+// heapPerLine returns the heap bytes per line held by a freshly built
+// document of n lines
+func heapPerLine(n int, opts ...Option) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rga, _, _ := buildLarge(n, opts...)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(rga)
+	return float64(after.HeapAlloc-before.HeapAlloc) / float64(n)
+}
+
+func TestRGALargeFileMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a 500k line document")
+	}
+	// a document without an op log stays within a few hundred bytes a line
+	if got := heapPerLine(largeLines, WithoutLog()); got > 320 {
+		t.Errorf("Expected at most 320 bytes per line, got %.0f", got)
+	}
+}
+
+func BenchmarkRGALargeFile(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.ReportMetric(heapPerLine(largeLines, WithoutLog()), "B/line")
+	}
+}
+
+func BenchmarkRGALargeFileWithLog(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.ReportMetric(heapPerLine(largeLines), "B/line")
+	}
+}
+
+// BenchmarkRGALargeFileEdit inserts, changes and deletes a line in the middle
+// of a large document and reads it back
+func BenchmarkRGALargeFileEdit(b *testing.B) {
+	rga, nodeID, ids := buildLarge(largeLines, WithoutLog())
+	lamport := uint64(largeLines)
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		at := ids[len(ids)/2+rnd.Intn(1000)]
+		id := uuid.New()
+		lamport += 3
+		rga.Apply(Operation{Type: OpInsert, Lamport: lamport - 2, NodeID: nodeID, LineID: id, OriginLineID: at, Content: "new"})
+		rga.Apply(Operation{Type: OpUpdate, Lamport: lamport - 1, NodeID: nodeID, LineID: id, Content: "changed"})
+		if _, text, _ := rga.LineAt(rga.IndexOf(id)); text != "changed" {
+			b.Fatalf("Expected the changed line, got %q", text)
+		}
+		rga.Apply(Operation{Type: OpDelete, Lamport: lamport, NodeID: nodeID, LineID: id})
+	}
+}
@@ -0,0 +1,196 @@
+package crdt
+
+import "math/rand"
+
+// sequence keeps every line of an RGA, tombstones included, in document order.
+// It is an implicit treap: nodes are ordered by position rather than by key,
+// and each node counts the lines below it, so finding a line's position,
+// inserting after a line and looking up the i-th visible line are O(log n).
+type sequence struct {
+	root *seqNode
+	rnd  *rand.Rand
+}
+
+type seqNode struct {
+	line                *rgaNode
+	prio                uint32
+	left, right, parent *seqNode
+	size                int // lines in this subtree
+	visible             int // lines in this subtree that are not deleted
+}
+
+func newSequence() *sequence {
+	return &sequence{rnd: rand.New(rand.NewSource(1))}
+}
+
+func size(s *seqNode) int {
+	if s == nil {
+		return 0
+	}
+	return s.size
+}
+
+func visible(s *seqNode) int {
+	if s == nil {
+		return 0
+	}
+	return s.visible
+}
+
+// update recomputes counts from the children and re-links them to s
+func (s *seqNode) update() {
+	s.size = 1 + size(s.left) + size(s.right)
+	s.visible = visible(s.left) + visible(s.right)
+	if !s.line.deleted {
+		s.visible++
+	}
+	if s.left != nil {
+		s.left.parent = s
+	}
+	if s.right != nil {
+		s.right.parent = s
+	}
+}
+
+// split cuts t into the first k lines and the rest
+func split(t *seqNode, k int) (*seqNode, *seqNode) {
+	if t == nil {
+		return nil, nil
+	}
+	if size(t.left) < k {
+		l, r := split(t.right, k-size(t.left)-1)
+		t.right = l
+		t.update()
+		if r != nil {
+			r.parent = nil
+		}
+		return t, r
+	}
+	l, r := split(t.left, k)
+	t.left = r
+	t.update()
+	if l != nil {
+		l.parent = nil
+	}
+	return l, t
+}
+
+// join concatenates two treaps, every line of a before every line of b
+func join(a, b *seqNode) *seqNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.prio > b.prio {
+		a.right = join(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = join(a, b.left)
+	b.update()
+	return b
+}
+
+// insertAfter places s, whose line is set, directly after prev, or first if
+// prev is nil
+func (q *sequence) insertAfter(prev, s *seqNode) {
+	s.prio = q.rnd.Uint32()
+	s.update()
+	q.splice(prev, s)
+}
+
+// splice places the lines of treap t directly after prev, or first if prev
+// is nil
+func (q *sequence) splice(prev, t *seqNode) {
+	k := 0
+	if prev != nil {
+		k = q.rank(prev) + 1
+	}
+	l, r := split(q.root, k)
+	q.root = join(join(l, t), r)
+	q.root.parent = nil
+}
+
+// cut takes the lines from first to last out of the sequence and returns
+// them as a treap
+func (q *sequence) cut(first, last *seqNode) *seqNode {
+	start, end := q.rank(first), q.rank(last)
+	l, rest := split(q.root, start)
+	mid, r := split(rest, end-start+1)
+	q.root = join(l, r)
+	if q.root != nil {
+		q.root.parent = nil
+	}
+	return mid
+}
+
+// rank returns the position of s among all lines
+func (q *sequence) rank(s *seqNode) int {
+	r := size(s.left)
+	for c := s; c.parent != nil; c = c.parent {
+		if c.parent.right == c {
+			r += size(c.parent.left) + 1
+		}
+	}
+	return r
+}
+
+// visibleRank returns the number of visible lines before s
+func (q *sequence) visibleRank(s *seqNode) int {
+	r := visible(s.left)
+	for c := s; c.parent != nil; c = c.parent {
+		if c.parent.right == c {
+			r += visible(c.parent.left)
+			if !c.parent.line.deleted {
+				r++
+			}
+		}
+	}
+	return r
+}
+
+// refresh fixes visible counts after a line was deleted or revived
+func (q *sequence) refresh(s *seqNode) {
+	for c := s; c != nil; c = c.parent {
+		c.update()
+	}
+}
+
+// at returns the i-th visible line
+func (q *sequence) at(i int) *rgaNode {
+	s := q.root
+	for s != nil {
+		lv := visible(s.left)
+		switch {
+		case i < lv:
+			s = s.left
+		case i == lv && !s.line.deleted:
+			return s.line
+		default:
+			i -= lv
+			if !s.line.deleted {
+				i--
+			}
+			s = s.right
+		}
+	}
+	return nil
+}
+
+// each visits every line in document order, deleted ones included
+func (q *sequence) each(fn func(n *rgaNode)) {
+	var stack []*seqNode
+	s := q.root
+	for s != nil || len(stack) > 0 {
+		for s != nil {
+			stack = append(stack, s)
+			s = s.left
+		}
+		s = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		fn(s.line)
+		s = s.right
+	}
+}
@@ -0,0 +1,132 @@
+package crdt
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Validate checks the invariants of the document and returns the first one
+// broken: every line is reached once from the root, siblings are in order,
+// the sequence holds the lines in the order of the tree with correct counts,
+// tombstones agree with their stamps, and ops held for a missing line or
+// origin are still waiting for one. A document built by Apply always passes;
+// fsck runs it on every op log.
+func (r *RGA) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// the tree, in document order
+	var order []*rgaNode
+	seen := make(map[*rgaNode]bool)
+	frags := 0
+	stack := []*rgaNode{r.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n != r.root {
+			if seen[n] {
+				return fmt.Errorf("line %s is reached twice", n.id)
+			}
+			seen[n] = true
+			if r.lines[n.id] != n {
+				return fmt.Errorf("line %s is in the tree but not indexed", n.id)
+			}
+			if want := n.removed.after(n.revived); n.deleted != want {
+				return fmt.Errorf("line %s is deleted=%t, its stamps say %t", n.id, n.deleted, want)
+			}
+			if n.fragment {
+				frags++
+			}
+			order = append(order, n)
+		}
+		for i, c := range n.children {
+			if c.parent != n {
+				return fmt.Errorf("line %s has the wrong parent", c.id)
+			}
+			if i > 0 && !siblingBefore(n.children[i-1], c) {
+				return fmt.Errorf("line %s is out of order after %s", c.id, n.children[i-1].id)
+			}
+		}
+		for i := len(n.children) - 1; i >= 0; i-- {
+			stack = append(stack, n.children[i])
+		}
+	}
+	if len(order) != len(r.lines) {
+		return fmt.Errorf("%d lines are indexed but %d are in the tree", len(r.lines), len(order))
+	}
+	if frags != r.frags {
+		return fmt.Errorf("%d fragments are counted but %d are in the tree", r.frags, frags)
+	}
+
+	if r.seq.root != nil && r.seq.root.parent != nil {
+		return fmt.Errorf("sequence root has a parent")
+	}
+	if err := validateSeq(r.seq.root); err != nil {
+		return err
+	}
+	i := 0
+	var err error
+	r.seq.each(func(n *rgaNode) {
+		if err == nil && (i >= len(order) || order[i] != n) {
+			err = fmt.Errorf("line %s is at position %d of the sequence but not of the tree", n.id, i)
+		}
+		i++
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(order) {
+		return fmt.Errorf("sequence holds %d lines, the tree %d", i, len(order))
+	}
+
+	waiting := 0
+	for origin, ops := range r.pending {
+		if _, ok := r.lines[origin]; ok {
+			return fmt.Errorf("%d inserts wait for line %s, which is known", len(ops), origin)
+		}
+		waiting += len(ops)
+	}
+	if waiting != r.orphaned {
+		return fmt.Errorf("%d inserts are counted as waiting but %d are", r.orphaned, waiting)
+	}
+	for _, held := range []map[uuid.UUID]Operation{r.deletes, r.updates} {
+		for id := range held {
+			if _, ok := r.lines[id]; ok {
+				return fmt.Errorf("an op waits for line %s, which is known", id)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSeq checks the counts, parent links and heap order of a treap
+func validateSeq(s *seqNode) error {
+	if s == nil {
+		return nil
+	}
+	size, vis := 1, 0
+	if !s.line.deleted {
+		vis = 1
+	}
+	for _, c := range []*seqNode{s.left, s.right} {
+		if c == nil {
+			continue
+		}
+		if c.parent != s {
+			return fmt.Errorf("line %s has the wrong parent in the sequence", c.line.id)
+		}
+		if c.prio > s.prio {
+			return fmt.Errorf("line %s is above its parent in the sequence", c.line.id)
+		}
+		if err := validateSeq(c); err != nil {
+			return err
+		}
+		size += c.size
+		vis += c.visible
+	}
+	if s.size != size || s.visible != vis {
+		return fmt.Errorf("line %s counts %d/%d lines in the sequence, not %d/%d", s.line.id, s.size, s.visible, size, vis)
+	}
+	return nil
+}
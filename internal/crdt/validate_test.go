@@ -0,0 +1,58 @@
+package crdt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidate(t *testing.T) {
+	build := func() (*RGA, []uuid.UUID) {
+		rga := NewRGA()
+		nodeID := uuid.New()
+		ids := make([]uuid.UUID, 5)
+		origin := DocumentStart
+		for i := range ids {
+			ids[i] = uuid.New()
+			rga.Apply(Operation{Type: OpInsert, Lamport: uint64(i + 1), NodeID: nodeID, LineID: ids[i], OriginLineID: origin, Content: "line"})
+			origin = ids[i]
+		}
+		rga.Apply(Operation{Type: OpDelete, Lamport: 9, NodeID: nodeID, LineID: ids[2]})
+		return rga, ids
+	}
+	rga, _ := build()
+	if err := rga.Validate(); err != nil {
+		t.Fatalf("Expected a valid document, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		corrupt func(r *RGA, ids []uuid.UUID)
+		want    string
+	}{
+		"Tombstone": {func(r *RGA, ids []uuid.UUID) { r.lines[ids[1]].deleted = true }, "its stamps say"},
+		"Siblings": {func(r *RGA, ids []uuid.UUID) {
+			// the newest insert at the top belongs first
+			extra := &rgaNode{id: uuid.New(), parent: r.root, created: stamp{lamport: 100}}
+			extra.seq.line = extra
+			r.lines[extra.id] = extra
+			r.root.children = append(r.root.children, extra)
+			r.seq.insertAfter(nil, &extra.seq)
+		}, "out of order"},
+		"Sequence": {func(r *RGA, ids []uuid.UUID) { r.seq.root.size++ }, "counts"},
+		"Index":    {func(r *RGA, ids []uuid.UUID) { delete(r.lines, ids[4]) }, "not indexed"},
+		"Pending": {func(r *RGA, ids []uuid.UUID) {
+			r.pending[ids[0]] = []Operation{{Type: OpInsert, LineID: uuid.New(), OriginLineID: ids[0]}}
+			r.orphaned++
+		}, "which is known"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rga, ids := build()
+			tc.corrupt(rga, ids)
+			err := rga.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
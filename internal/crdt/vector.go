@@ -0,0 +1,120 @@
+package crdt
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// VectorClock maps a NodeID to the latest Lamport time of that node's ops an
+// op has seen. Vectors are scoped to one file's op log: an op's vector covers
+// the ops that were in the log when it was created, plus the op itself.
+type VectorClock map[uuid.UUID]uint64
+
+// Ordering is the causal relation between two vector clocks
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+// Copy returns an independent copy of the clock
+func (v VectorClock) Copy() VectorClock {
+	out := make(VectorClock, len(v))
+	for n, t := range v {
+		out[n] = t
+	}
+	return out
+}
+
+// Merge raises every entry of v to at least the entry in o
+func (v VectorClock) Merge(o VectorClock) {
+	for n, t := range o {
+		if t > v[n] {
+			v[n] = t
+		}
+	}
+}
+
+// Witness merges an op's vector into v
+func (v VectorClock) Witness(op Operation) {
+	v.Merge(VectorOf(op))
+}
+
+// Stamp gives a new local op the vector of everything v has seen plus the op
+// itself, then records the op in v so later ops depend on it
+func (v VectorClock) Stamp(op *Operation) {
+	v[op.NodeID] = op.Lamport
+	op.Vector = v.Copy()
+}
+
+// Compare reports how v relates causally to o
+func (v VectorClock) Compare(o VectorClock) Ordering {
+	less, greater := false, false
+	for n, t := range v {
+		if t > o[n] {
+			greater = true
+		} else if t < o[n] {
+			less = true
+		}
+	}
+	for n, t := range o {
+		if _, ok := v[n]; !ok && t > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+// Nodes returns the clock's node IDs in a stable order
+func (v VectorClock) Nodes() []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(v))
+	for n := range v {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out
+}
+
+// VectorOf returns the op's vector. Ops written before vectors were maintained
+// only know about themselves.
+func VectorOf(op Operation) VectorClock {
+	v := make(VectorClock, len(op.Vector)+1)
+	v.Merge(op.Vector)
+	if op.Lamport > v[op.NodeID] {
+		v[op.NodeID] = op.Lamport
+	}
+	return v
+}
+
+// Knowledge returns the vector covering all of ops
+func Knowledge(ops []Operation) VectorClock {
+	v := make(VectorClock)
+	for _, op := range ops {
+		v.Witness(op)
+	}
+	return v
+}
+
+// HappenedBefore reports whether a causally precedes b
+func HappenedBefore(a, b Operation) bool {
+	return VectorOf(a).Compare(VectorOf(b)) == Before
+}
+
+// IsConcurrent reports whether neither op has seen the other
+func IsConcurrent(a, b Operation) bool {
+	return VectorOf(a).Compare(VectorOf(b)) == Concurrent
+}
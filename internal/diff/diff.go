@@ -0,0 +1,254 @@
+package diff
+
+// Op is the kind of an edit
+type Op int
+
+const (
+	Equal  Op = iota // line A of a is line B of b
+	Delete           // line A of a is gone
+	Insert           // line B of b is new
+)
+
+// Edit is one step of an edit script
+type Edit struct {
+	Op Op
+	A  int // index in a, for Equal and Delete
+	B  int // index in b, for Equal and Insert
+}
+
+// Lines returns a shortest edit script turning a into b, using Myers'
+// O(ND) algorithm. Within a run of changes, deletions come first.
+func Lines(a, b []string) []Edit {
+	// common prefix and suffix need no search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b)-pre-suf)
+	for i := 0; i < pre; i++ {
+		edits = append(edits, Edit{Equal, i, i})
+	}
+	for _, e := range myers(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		e.A += pre
+		e.B += pre
+		edits = append(edits, e)
+	}
+	for i := suf; i > 0; i-- {
+		edits = append(edits, Edit{Equal, len(a) - i, len(b) - i})
+	}
+	return edits
+}
+
+// maxTrace is the edit distance up to which myers records the search to walk
+// it back; the record grows with its square. Larger differences are split in
+// halves first.
+const maxTrace = 512
+
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(n, m)
+	}
+	if edits, ok := search(a, b); ok {
+		return edits
+	}
+	x, y := bisect(a, b)
+	if x < 0 {
+		return replace(n, m)
+	}
+	edits := Lines(a[:x], b[:y])
+	for _, e := range Lines(a[x:], b[y:]) {
+		e.A += x
+		e.B += y
+		edits = append(edits, e)
+	}
+	return sortRuns(edits)
+}
+
+// replace deletes all n lines of a and inserts all m lines of b
+func replace(n, m int) []Edit {
+	edits := make([]Edit, 0, n+m)
+	for i := 0; i < n; i++ {
+		edits = append(edits, Edit{Op: Delete, A: i})
+	}
+	for j := 0; j < m; j++ {
+		edits = append(edits, Edit{Op: Insert, A: n, B: j})
+	}
+	return edits
+}
+
+// search finds a shortest edit script by the greedy forward search, unless
+// it is longer than maxTrace
+func search(a, b []string) ([]Edit, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds the diagonals -d-1..d+1 of v before step d, all that
+	// step d reads
+	var trace [][]int
+	for d := 0; d <= max && d <= maxTrace; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack walks the recorded frontiers from the end back to the start
+func backtrack(trace [][]int, n, m int) []Edit {
+	var rev []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, Edit{Equal, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, Edit{Op: Insert, A: prevX, B: prevY})
+			} else {
+				rev = append(rev, Edit{Op: Delete, A: prevX, B: prevY})
+			}
+			x, y = prevX, prevY
+		}
+	}
+	out := make([]Edit, 0, len(rev))
+	for i := len(rev) - 1; i >= 0; i-- {
+		out = append(out, rev[i])
+	}
+	return sortRuns(out)
+}
+
+// bisect searches forward from the start and backward from the end at once,
+// in linear space, and returns where the two meet on a shortest path: a
+// point splitting the problem in two. It returns -1, -1 when a and b have
+// no line in common.
+func bisect(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off := maxD + 1
+	vf := make([]int, 2*maxD+3) // forward: furthest x on each diagonal
+	vb := make([]int, 2*maxD+3) // backward, counted from the ends
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[off+1], vb[off+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+	// diagonals that ran off an edge are no longer searched
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if kb := off + delta - k; kb >= 0 && kb < len(vb) && vb[kb] != -1 && x >= n-vb[kb] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if kf := off + delta - k; kf >= 0 && kf < len(vf) && vf[kf] != -1 {
+					fx := vf[kf]
+					if fx >= n-x {
+						return fx, fx - (kf - off)
+					}
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// sortRuns moves deletions before insertions within each run of changes
+func sortRuns(edits []Edit) []Edit {
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i++
+			continue
+		}
+		j := i
+		for j < len(edits) && edits[j].Op != Equal {
+			j++
+		}
+		run := make([]Edit, 0, j-i)
+		for _, e := range edits[i:j] {
+			if e.Op == Delete {
+				run = append(run, e)
+			}
+		}
+		for _, e := range edits[i:j] {
+			if e.Op == Insert {
+				run = append(run, e)
+			}
+		}
+		copy(edits[i:j], run)
+		i = j
+	}
+	return edits
+}
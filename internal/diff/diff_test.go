@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func script(edits []Edit, a, b []string) string {
+	var out []string
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			out = append(out, " "+a[e.A])
+		case Delete:
+			out = append(out, "-"+a[e.A])
+		case Insert:
+			out = append(out, "+"+b[e.B])
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", " a, b, c"},
+		{"", "a b", "+a,+b"},
+		{"a b", "", "-a,-b"},
+		{"a b c d", "a x b c d", " a,+x, b, c, d"},
+		{"a b c d", "a c d", " a,-b, c, d"},
+		{"a b c d", "a B c d", " a,-b,+B, c, d"},
+		{"a b c a b b a", "c b a b a c", "-a,-b, c,+b, a, b,-b, a,+c"},
+	}
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		edits := Lines(a, b)
+		assert.Equal(t, tt.want, script(edits, a, b), "%q => %q", tt.a, tt.b)
+
+		// the script rebuilds b from a
+		var got []string
+		ai := 0
+		for _, e := range edits {
+			switch e.Op {
+			case Equal:
+				assert.Equal(t, ai, e.A)
+				got = append(got, a[e.A])
+				ai++
+			case Delete:
+				assert.Equal(t, ai, e.A)
+				ai++
+			case Insert:
+				got = append(got, b[e.B])
+			}
+		}
+		assert.Equal(t, len(a), ai)
+		assert.Equal(t, strings.Join(b, " "), strings.Join(got, " "))
+	}
+}
+
+// lcs returns the length of a longest common subsequence of a and b
+func lcs(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestLinesLarge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	words := func(n, alphabet int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = strconv.Itoa(rnd.Intn(alphabet))
+		}
+		return out
+	}
+	for _, tt := range []struct{ n, m, alphabet int }{
+		{2000, 1500, 10},
+		{1200, 1800, 40},
+		{3000, 0, 5},
+		{1500, 1500, 2000}, // almost nothing in common
+	} {
+		a, b := words(tt.n, tt.alphabet), words(tt.m, tt.alphabet)
+		edits := Lines(a, b)
+		var got []string
+		changes := 0
+		ai := 0
+		for _, e := range edits {
+			switch e.Op {
+			case Equal:
+				assert.Equal(t, a[ai], b[e.B])
+				got = append(got, a[e.A])
+				ai++
+			case Delete:
+				changes++
+				ai++
+			case Insert:
+				changes++
+				got = append(got, b[e.B])
+			}
+		}
+		assert.Equal(t, len(a), ai)
+		assert.Equal(t, strings.Join(b, " "), strings.Join(got, " "), "%d => %d lines", tt.n, tt.m)
+		// still a shortest script past the traced search
+		assert.Equal(t, tt.n+tt.m-2*lcs(a, b), changes, "%d => %d lines", tt.n, tt.m)
+	}
+}
@@ -0,0 +1,74 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commit(t *testing.T, rp, stream, msg string) {
+	require.NoError(t, index.UpdateIndex(rp))
+	_, err := ingest.IngestLocalChanges(context.Background(), rp, stream)
+	require.NoError(t, err)
+	eops, err := commits.GatherNewOps(rp, stream)
+	require.NoError(t, err)
+	_, err = commits.CreateCommit(rp, stream, msg, "ann", "ann@example.com", eops, false)
+	require.NoError(t, err)
+}
+
+func TestDumpLoad(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	require.NoError(t, os.MkdirAll(filepath.Join(rp, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\ntwo\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "src", "b.txt"), []byte("bee\n"), 0644))
+	commit(t, rp, "main", "first")
+	require.NoError(t, streams.CreateStreamFrom(rp, "feature", "main", ""))
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\n2\nthree\n"), 0644))
+	commit(t, rp, "main", "second")
+
+	d, err := Create(rp)
+	require.NoError(t, err)
+	var first bytes.Buffer
+	require.NoError(t, d.Write(&first))
+	assert.Len(t, d.Streams, 2)
+	assert.Equal(t, "src/b.txt", d.Index[1].Path)
+	assert.Equal(t, &streams.Origin{Stream: "main", Commit: d.Streams[1].Commits[0].ID}, d.Streams[0].Origin)
+
+	// the same state dumps to the same bytes, before and after a load
+	var again bytes.Buffer
+	d, err = Create(rp)
+	require.NoError(t, err)
+	require.NoError(t, d.Write(&again))
+	assert.Equal(t, first.String(), again.String())
+
+	loaded, err := Read(bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+	target := t.TempDir()
+	require.NoError(t, Load(target, loaded))
+	data, err := os.ReadFile(filepath.Join(target, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\n2\nthree\n", string(data))
+
+	d, err = Create(target)
+	require.NoError(t, err)
+	again.Reset()
+	require.NoError(t, d.Write(&again))
+	assert.Equal(t, first.String(), again.String())
+
+	assert.ErrorContains(t, Load(target, loaded), "already")
+	loaded.Index[0].Path = "../escape.txt"
+	assert.ErrorContains(t, Load(t.TempDir(), loaded), "invalid path")
+	_, err = Read(bytes.NewReader([]byte(`{"format":"evo-dump","version":99}`)))
+	assert.ErrorContains(t, err, "newer")
+}
@@ -0,0 +1,266 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"evo/internal/node"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An event log is an op-based CRDT for small records such as issues and
+// reviews: .evo/<kind>/<id>.json holds every change as an event stamped with
+// the node's Lamport clock. Replaying events in (Lamport, NodeID) order gives
+// the same state on every replica, and two replicas merge a record by taking
+// the union of its events.
+
+// Event is one change to a record
+type Event struct {
+	Lamport     uint64            `json:"lamport"`
+	NodeID      uuid.UUID         `json:"node"`
+	Timestamp   time.Time         `json:"timestamp"`
+	AuthorName  string            `json:"authorName"`
+	AuthorEmail string            `json:"authorEmail"`
+	Kind        string            `json:"kind"`
+	Field       string            `json:"field,omitempty"`
+	Value       string            `json:"value"`
+	Attrs       map[string]string `json:"attrs,omitempty"`
+}
+
+// Key identifies an event across replicas
+func (e Event) Key() string {
+	return fmt.Sprintf("%d-%s", e.Lamport, e.NodeID)
+}
+
+// Before orders events by Lamport time, then NodeID
+func (e Event) Before(o Event) bool {
+	if e.Lamport != o.Lamport {
+		return e.Lamport < o.Lamport
+	}
+	return e.NodeID.String() < o.NodeID.String()
+}
+
+// Author is who makes a change
+type Author struct {
+	Name  string
+	Email string
+}
+
+// Store is the set of records of one kind, e.g. .evo/issues
+type Store struct {
+	repoPath string
+	kind     string
+}
+
+// New returns the store of records under .evo/<kind>
+func New(repoPath, kind string) *Store {
+	return &Store{repoPath: repoPath, kind: kind}
+}
+
+func (s *Store) dir() string {
+	return filepath.Join(repo.Dir(s.repoPath), s.kind)
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir(), id+".json")
+}
+
+// Load returns the events of a record sorted for replay. A missing record
+// returns an error satisfying os.IsNotExist.
+func (s *Store) Load(id string) ([]Event, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var evs []Event
+	if err := json.Unmarshal(data, &evs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s %s: %w", s.kind, id, err)
+	}
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Before(evs[j]) })
+	return evs, nil
+}
+
+func (s *Store) save(id string, evs []Event) error {
+	sort.Slice(evs, func(i, j int) bool { return evs[i].Before(evs[j]) })
+	if err := os.MkdirAll(s.dir(), 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", s.kind, err)
+	}
+	data, err := json.MarshalIndent(evs, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.path(id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s %s: %w", s.kind, id, err)
+	}
+	return platform.Rename(tmp, path)
+}
+
+// Append stamps events with this node's clock and adds them to a record,
+// creating it if needed
+func (s *Store) Append(id string, who Author, evs ...Event) error {
+	self, err := node.Load(s.repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load node identity: %w", err)
+	}
+	all, err := s.Load(id)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range all {
+		self.Clock.Observe(e.Lamport)
+	}
+	now := time.Now().UTC()
+	for _, e := range evs {
+		e.Lamport = self.Tick()
+		e.NodeID = self.ID
+		e.Timestamp = now
+		e.AuthorName, e.AuthorEmail = who.Name, who.Email
+		all = append(all, e)
+	}
+	if err := self.Save(); err != nil {
+		return err
+	}
+	return s.save(id, all)
+}
+
+// IDs lists every record
+func (s *Store) IDs() ([]string, error) {
+	entries, err := os.ReadDir(s.dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && !e.IsDir() {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// Resolve expands a unique ID prefix, optionally written as #prefix
+func (s *Store) Resolve(prefix string) (string, error) {
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "#")
+	if prefix == "" {
+		return "", fmt.Errorf("empty %s ID", s.kind)
+	}
+	all, err := s.IDs()
+	if err != nil {
+		return "", err
+	}
+	var match []string
+	for _, id := range all {
+		if strings.HasPrefix(id, prefix) {
+			match = append(match, id)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return "", fmt.Errorf("no %s %s", strings.TrimSuffix(s.kind, "s"), prefix)
+	case 1:
+		return match[0], nil
+	}
+	return "", fmt.Errorf("%s ID %s is ambiguous", strings.TrimSuffix(s.kind, "s"), prefix)
+}
+
+// Sync merges the records of the same kind in another repository on disk
+// into this one and back. It returns how many events were added here and
+// there.
+func (s *Store) Sync(otherPath string) (int, int, error) {
+	other := New(otherPath, s.kind)
+	pulled, err := s.mergeFrom(other)
+	if err != nil {
+		return pulled, 0, err
+	}
+	pushed, err := other.mergeFrom(s)
+	return pulled, pushed, err
+}
+
+// mergeFrom adds the events of src's records that s lacks
+func (s *Store) mergeFrom(src *Store) (int, error) {
+	recs, err := src.Records()
+	if err != nil {
+		return 0, err
+	}
+	return s.Merge(recs)
+}
+
+// ValidID reports whether id is a record ID as this package makes them
+func ValidID(id string) bool {
+	u, err := uuid.Parse(id)
+	return err == nil && u.String() == id
+}
+
+// Records returns the events of every record, by ID, so they can be sent to
+// another replica
+func (s *Store) Records() (map[string][]Event, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, err
+	}
+	recs := make(map[string][]Event, len(ids))
+	for _, id := range ids {
+		if recs[id], err = s.Load(id); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// Merge adds the events of another replica's records that s lacks and
+// returns how many it added. The records may come from the network, so
+// their IDs must be UUIDs as the ones this package makes are.
+func (s *Store) Merge(recs map[string][]Event) (int, error) {
+	self, err := node.Load(s.repoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	ids := make([]string, 0, len(recs))
+	for id := range recs {
+		if !ValidID(id) {
+			return 0, fmt.Errorf("invalid %s ID %q", strings.TrimSuffix(s.kind, "s"), id)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	added := 0
+	for _, id := range ids {
+		have, err := s.Load(id)
+		if err != nil && !os.IsNotExist(err) {
+			return added, err
+		}
+		known := make(map[string]bool, len(have))
+		for _, e := range have {
+			known[e.Key()] = true
+		}
+		n := 0
+		for _, e := range recs[id] {
+			if !known[e.Key()] {
+				known[e.Key()] = true
+				have = append(have, e)
+				self.Clock.Observe(e.Lamport)
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if err := s.save(id, have); err != nil {
+			return added, err
+		}
+		added += n
+	}
+	return added, self.Save()
+}
@@ -0,0 +1,103 @@
+// Package everrors holds the kinds of error evo reports, so callers can tell
+// them apart with errors.Is whatever package they come from, and the CLI can
+// turn each into its own exit code. Packages keep their own errors and
+// messages; New and Errorf mark them with a kind.
+package everrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUsage means a command was given flags or arguments it can't take
+	ErrUsage = errors.New("invalid usage")
+	// ErrNotARepo means no repository was found where one was needed
+	ErrNotARepo = errors.New("not an evo repository")
+	// ErrNotFound means a stream, commit or revision doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrStreamExists means a stream to be created is already there
+	ErrStreamExists = errors.New("stream already exists")
+	// ErrConflict means something in the way has to be dealt with first:
+	// uncommitted changes, a lock someone else holds, a run in progress
+	ErrConflict = errors.New("conflict")
+	// ErrCorrupt means stored data doesn't match its hash or can't be parsed
+	ErrCorrupt = errors.New("corrupt data")
+)
+
+// Exit codes of the CLI, one per kind. Errors of no kind exit with
+// ExitError; operations stopped by cancelling their context (Ctrl-C) with
+// ExitInterrupted, as a shell reports a process killed by SIGINT.
+const (
+	ExitOK           = 0
+	ExitError        = 1
+	ExitUsage        = 2
+	ExitNotARepo     = 3
+	ExitNotFound     = 4
+	ExitStreamExists = 5
+	ExitConflict     = 6
+	ExitCorrupt      = 7
+	ExitInterrupted  = 130
+)
+
+// kinds maps each kind to its code in JSON output and its exit code, most
+// specific first
+var kinds = []struct {
+	kind error
+	code string
+	exit int
+}{
+	{ErrUsage, "usage", ExitUsage},
+	{ErrNotARepo, "not_a_repo", ExitNotARepo},
+	{ErrStreamExists, "stream_exists", ExitStreamExists},
+	{ErrNotFound, "not_found", ExitNotFound},
+	{ErrConflict, "conflict", ExitConflict},
+	{ErrCorrupt, "corrupt", ExitCorrupt},
+	{context.Canceled, "interrupted", ExitInterrupted},
+}
+
+// kindError is an error marked with a kind. Its message is the error's own;
+// errors.Is matches it to the kind as well as to what it wraps.
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string        { return e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// New returns an error with the message msg, of the kind
+func New(kind error, msg string) error {
+	return &kindError{err: errors.New(msg), kind: kind}
+}
+
+// Errorf formats an error as fmt.Errorf does, of the kind
+func Errorf(kind error, format string, args ...any) error {
+	return &kindError{err: fmt.Errorf(format, args...), kind: kind}
+}
+
+// Code returns the code of err's kind, as --json prints it with the
+// message, or "error" for an error of no kind
+func Code(err error) string {
+	for _, k := range kinds {
+		if errors.Is(err, k.kind) {
+			return k.code
+		}
+	}
+	return "error"
+}
+
+// ExitCode returns the exit code of err's kind, ExitOK for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, k := range kinds {
+		if errors.Is(err, k.kind) {
+			return k.exit
+		}
+	}
+	return ExitError
+}
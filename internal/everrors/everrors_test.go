@@ -0,0 +1,31 @@
+package everrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKinds(t *testing.T) {
+	err := Errorf(ErrCorrupt, "bad record: %w", fs.ErrNotExist)
+	assert.Equal(t, "bad record: file does not exist", err.Error())
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.NotErrorIs(t, err, ErrConflict)
+
+	// a kind survives wrapping
+	wrapped := fmt.Errorf("failed to read: %w", New(ErrNotFound, "unknown revision"))
+	assert.Equal(t, "not_found", Code(wrapped))
+	assert.Equal(t, ExitNotFound, ExitCode(wrapped))
+
+	assert.Equal(t, "error", Code(errors.New("boom")))
+	assert.Equal(t, ExitError, ExitCode(errors.New("boom")))
+	assert.Equal(t, ExitOK, ExitCode(nil))
+	assert.Equal(t, ExitStreamExists, ExitCode(New(ErrStreamExists, "stream 'main' already exists")))
+	assert.Equal(t, ExitUsage, ExitCode(Errorf(ErrUsage, "%w", New(ErrNotFound, "x"))))
+	assert.Equal(t, ExitInterrupted, ExitCode(fmt.Errorf("failed to merge: %w", context.Canceled)))
+}
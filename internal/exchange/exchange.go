@@ -0,0 +1,198 @@
+// Package exchange moves commits between a repository and a remote. A push
+// sends the whole history of a stream and the server applies what it lacks,
+// checked against its receive policy; a pull fetches the remote's history
+// and merges what the local stream lacks, like `evo stream merge`. Both
+// record what the remote's stream holds for package tracking. Reviews are
+// exchanged alongside, both ways at once, by merging their event logs. The
+// commits sent, fetched and applied are reported to the progress reporter of
+// the context given.
+package exchange
+
+import (
+	"context"
+	"evo/internal/eventlog"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/merge"
+	"evo/internal/progress"
+	"evo/internal/remotes"
+	"evo/internal/review"
+	"evo/internal/streams"
+	"evo/internal/tracking"
+	"evo/internal/types"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+var logger = log.For("exchange")
+
+// learning serializes remembering fetched paths, as streams may be fetched
+// several at once
+var learning sync.Mutex
+
+// Result reports the commits an exchange added to the other side
+type Result struct {
+	Remote       string           `json:"remote"`
+	Stream       string           `json:"stream"`
+	RemoteStream string           `json:"remoteStream,omitempty"` // the remote's name for the stream, if another
+	Commits      []string         `json:"commits"`
+	Files        []string         `json:"files,omitempty"`     // IDs of the files pulled commits change
+	Conflicts    []merge.Conflict `json:"conflicts,omitempty"` // lines pulled commits edited that the stream had too
+}
+
+// history is what a push sends and a pull gets: the commits of a stream,
+// oldest first, and the paths of the files they change, by file ID
+type history struct {
+	Commits []types.Commit    `json:"commits"`
+	Paths   map[string]string `json:"paths,omitempty"`
+}
+
+// Push sends stream to the remote
+func Push(ctx context.Context, repoPath string, r *remotes.Remote, stream string) (*Result, error) {
+	return PushTo(ctx, repoPath, r, stream, stream)
+}
+
+// PushTo sends stream to the remote's stream to
+func PushTo(ctx context.Context, repoPath string, r *remotes.Remote, stream, to string) (*Result, error) {
+	cs, err := streams.ListCommits(repoPath, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of %s: %w", stream, err)
+	}
+	paths, err := streams.Paths(repoPath, cs)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Received []string `json:"received"`
+	}
+	// the history goes in one request, so it is done all at once
+	pr := progress.From(ctx)
+	pr.Start("Pushing "+stream, int64(len(cs)))
+	err = r.Do("POST", "/push/"+url.PathEscape(to), history{Commits: cs, Paths: paths}, &out)
+	if err == nil {
+		pr.Add(int64(len(cs)))
+	}
+	pr.Done()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("pushed", "remote", r.Name, "stream", stream, "to", to, "commits", len(out.Received))
+	if err := tracking.RecordPushed(repoPath, r.Name, to, cs); err != nil {
+		logger.Warn("failed to record the remote stream", "remote", r.Name, "stream", to, "err", err)
+	}
+	res := &Result{Remote: r.Name, Stream: stream, Commits: nonNil(out.Received)}
+	if to != stream {
+		res.RemoteStream = to
+	}
+	return res, nil
+}
+
+// RemoteStreams returns the names of the remote's streams
+func RemoteStreams(r *remotes.Remote) ([]string, error) {
+	var sts []struct {
+		Name string `json:"name"`
+	}
+	if err := r.Do("GET", "/api/v1/streams", nil, &sts); err != nil {
+		return nil, fmt.Errorf("failed to list the streams of %s: %w", r.Name, err)
+	}
+	names := make([]string, len(sts))
+	for i, st := range sts {
+		names[i] = st.Name
+	}
+	return names, nil
+}
+
+// Fetch returns the commits of the remote's stream, oldest first, and
+// records them without merging any, remembering the paths the remote gives
+// files this repository has none for. Fetches of different streams may run
+// at once.
+func Fetch(ctx context.Context, repoPath string, r *remotes.Remote, stream string) ([]types.Commit, error) {
+	var in history
+	pr := progress.From(ctx)
+	pr.Start("Fetching "+stream, -1)
+	err := r.Do("GET", "/pull/"+url.PathEscape(stream), nil, &in)
+	pr.Add(int64(len(in.Commits)))
+	pr.Done()
+	if err != nil {
+		return nil, err
+	}
+	if err := tracking.Record(repoPath, r.Name, stream, in.Commits); err != nil {
+		return nil, err
+	}
+	learning.Lock()
+	defer learning.Unlock()
+	if err := index.LearnPaths(repoPath, in.Paths); err != nil {
+		return nil, fmt.Errorf("failed to remember the paths of %s: %w", r.Name, err)
+	}
+	return in.Commits, nil
+}
+
+// Pull merges the remote's stream into the local one, creating it if needed
+func Pull(ctx context.Context, repoPath string, r *remotes.Remote, stream string) (*Result, error) {
+	return PullFrom(ctx, repoPath, r, stream, stream)
+}
+
+// PullFrom merges the remote's stream from into the local stream
+func PullFrom(ctx context.Context, repoPath string, r *remotes.Remote, from, stream string) (*Result, error) {
+	cs, err := Fetch(ctx, repoPath, r, from)
+	if err != nil {
+		return nil, err
+	}
+	res, err := Apply(ctx, repoPath, r.Name, stream, cs)
+	if err == nil && from != stream {
+		res.RemoteStream = from
+	}
+	return res, err
+}
+
+// Apply merges the fetched history cs of the remote's stream into the local
+// one, creating it if needed. Cancelling ctx takes back what it applied.
+func Apply(ctx context.Context, repoPath, remote, stream string, cs []types.Commit) (*Result, error) {
+	res := &Result{Remote: remote, Stream: stream, Commits: []string{}}
+	incoming, err := streams.Unreceived(repoPath, stream, cs)
+	if err != nil {
+		return nil, err
+	}
+	if len(incoming) == 0 {
+		return res, nil
+	}
+	applied, err := streams.ReceiveReport(ctx, repoPath, stream, incoming)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply pulled commits: %w", err)
+	}
+	for _, c := range applied.Commits {
+		res.Commits = append(res.Commits, c.ID)
+	}
+	res.Files, res.Conflicts = applied.Files, applied.Conflicts
+	logger.Info("pulled", "remote", remote, "stream", stream, "commits", len(applied.Commits))
+	return res, nil
+}
+
+// SyncReviews sends the repository's reviews to the remote and merges the
+// remote's back, returning how many review events were added here and there
+func SyncReviews(repoPath string, r *remotes.Remote) (pulled, pushed int, err error) {
+	recs, err := review.Records(repoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	var out struct {
+		Reviews map[string][]eventlog.Event `json:"reviews"`
+		Added   int                         `json:"added"`
+	}
+	if err := r.Do("POST", "/reviews", map[string]any{"reviews": recs}, &out); err != nil {
+		return 0, 0, err
+	}
+	if pulled, err = review.Merge(repoPath, out.Reviews); err != nil {
+		return 0, out.Added, fmt.Errorf("failed to merge the reviews of %s: %w", r.Name, err)
+	}
+	logger.Info("synced reviews", "remote", r.Name, "pulled", pulled, "pushed", out.Added)
+	return pulled, out.Added, nil
+}
+
+func nonNil(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
@@ -0,0 +1,224 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/remotes"
+	"evo/internal/repo"
+	"evo/internal/review"
+	"evo/internal/server"
+	"evo/internal/streams"
+	"evo/internal/tracking"
+	"evo/internal/transfer"
+	"evo/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPull(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	a, b, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(a))
+	require.NoError(t, repo.InitRepo(b))
+	require.NoError(t, repo.Init(remote, repo.InitOptions{Bare: true}))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	c1 := types.Commit{ID: "c1", Stream: "main", Message: "one", Timestamp: time.Now()}
+	_, err := streams.Receive(a, "main", []types.Commit{c1})
+	require.NoError(t, err)
+
+	res, err := Push(context.Background(), a, r, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, res.Commits)
+	res, err = Push(context.Background(), a, r, "main")
+	require.NoError(t, err)
+	assert.Empty(t, res.Commits)
+	seen, err := tracking.Recorded(a, "origin", "main")
+	require.NoError(t, err)
+	assert.Equal(t, []types.Commit{{ID: "c1"}}, seen)
+
+	res, err = Pull(context.Background(), b, r, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, res.Commits)
+	cs, err := streams.ListCommits(b, "main")
+	require.NoError(t, err)
+	assert.Len(t, cs, 1)
+	res, err = Pull(context.Background(), b, r, "main")
+	require.NoError(t, err)
+	assert.Empty(t, res.Commits)
+	seen, err = tracking.Recorded(b, "origin", "main")
+	require.NoError(t, err)
+	assert.Len(t, seen, 1)
+
+	_, err = Pull(context.Background(), b, r, "nope")
+	var rerr *remotes.Error
+	require.True(t, errors.As(err, &rerr))
+	assert.Equal(t, http.StatusNotFound, rerr.Status)
+}
+
+func TestRenamed(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	a, b, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(a))
+	require.NoError(t, repo.InitRepo(b))
+	require.NoError(t, repo.Init(remote, repo.InitOptions{Bare: true}))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	c1 := types.Commit{ID: "c1", Stream: "feature-a", Message: "one", Timestamp: time.Now()}
+	_, err := streams.Receive(a, "feature-a", []types.Commit{c1})
+	require.NoError(t, err)
+
+	res, err := PushTo(context.Background(), a, r, "feature-a", "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", res.RemoteStream)
+	assert.Equal(t, []string{"c1"}, res.Commits)
+	seen, err := tracking.Recorded(a, "origin", "team-a")
+	require.NoError(t, err)
+	assert.Len(t, seen, 1)
+	names, err := RemoteStreams(r)
+	require.NoError(t, err)
+	assert.Contains(t, names, "team-a")
+
+	res, err = PullFrom(context.Background(), b, r, "team-a", "upstream-a")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", res.RemoteStream)
+	assert.Equal(t, "upstream-a", res.Stream)
+	cs, err := streams.ListCommits(b, "upstream-a")
+	require.NoError(t, err)
+	assert.Len(t, cs, 1)
+}
+
+func TestPaths(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	a, b, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(a))
+	require.NoError(t, repo.InitRepo(b))
+	require.NoError(t, repo.Init(remote, repo.InitOptions{Bare: true}))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(a, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(a, "dir", "a.txt"), []byte("one"), 0644))
+	require.NoError(t, index.UpdateIndex(a))
+	_, err := ingest.IngestLocalChanges(context.Background(), a, "main")
+	require.NoError(t, err)
+	eops, err := commits.GatherNewOps(a, "main")
+	require.NoError(t, err)
+	_, err = commits.CreateCommit(a, "main", "one", "ann", "ann@example.com", eops, false)
+	require.NoError(t, err)
+	_, id2p, err := index.LoadIndex(a)
+	require.NoError(t, err)
+
+	// the paths of the files pushed go along, for the remote and whoever
+	// pulls from it
+	_, err = Push(context.Background(), a, r, "main")
+	require.NoError(t, err)
+	known, err := index.KnownPaths(remote)
+	require.NoError(t, err)
+	assert.Equal(t, id2p, known)
+
+	res, err := Pull(context.Background(), b, r, "main")
+	require.NoError(t, err)
+	require.Len(t, res.Files, 1)
+	known, err = index.KnownPaths(b)
+	require.NoError(t, err)
+	assert.Equal(t, id2p, known)
+}
+
+func TestThrottled(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	a, b, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(a))
+	require.NoError(t, repo.InitRepo(b))
+	require.NoError(t, repo.Init(remote, repo.InitOptions{Bare: true}))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+	(&transfer.Options{Jobs: 1, Limiter: transfer.NewLimiter(128 << 10)}).Throttle(r)
+	require.NotNil(t, r.Limit)
+
+	c1 := types.Commit{ID: "c1", Stream: "main", Message: strings.Repeat("x", 96<<10), Timestamp: time.Now()}
+	_, err := streams.Receive(a, "main", []types.Commit{c1})
+	require.NoError(t, err)
+
+	// a quarter second of burst, then at least half a second at the rate,
+	// each way
+	start := time.Now()
+	res, err := Push(context.Background(), a, r, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, res.Commits)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	start = time.Now()
+	res, err = Pull(context.Background(), b, r, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, res.Commits)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestSyncReviews(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	a, b, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(a))
+	require.NoError(t, repo.InitRepo(b))
+	require.NoError(t, repo.Init(remote, repo.InitOptions{Bare: true}))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	require.NoError(t, streams.CreateStream(a, "feature"))
+	rv, err := review.Open(a, "", "feature", "main", review.Author{Name: "Ann", Email: "ann@example.com"})
+	require.NoError(t, err)
+	pulled, pushed, err := SyncReviews(a, r)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pulled)
+	assert.Equal(t, 4, pushed)
+
+	pulled, pushed, err = SyncReviews(b, r)
+	require.NoError(t, err)
+	assert.Equal(t, 4, pulled)
+	assert.Equal(t, 0, pushed)
+	got, err := review.Get(b, rv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rv.Title, got.Title)
+
+	require.NoError(t, review.AddComment(b, rv.ID, "looks good", nil, review.Author{Name: "Bo", Email: "bo@example.com"}))
+	_, pushed, err = SyncReviews(b, r)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pushed)
+	pulled, _, err = SyncReviews(a, r)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pulled)
+	got, err = review.Get(a, rv.ID)
+	require.NoError(t, err)
+	if assert.Len(t, got.Comments, 1) {
+		assert.Equal(t, "looks good", got.Comments[0].Text)
+	}
+
+	// record IDs name files on the server
+	err = r.Do("POST", "/reviews", map[string]any{"reviews": map[string]any{"../config": []any{}}}, nil)
+	var rerr *remotes.Error
+	require.True(t, errors.As(err, &rerr))
+	assert.Equal(t, http.StatusBadRequest, rerr.Status)
+}
@@ -0,0 +1,120 @@
+// Package fsck checks the op logs and op stores of a repository for damage,
+// and the documents the logs build for broken invariants.
+package fsck
+
+import (
+	"errors"
+	"evo/internal/crdt"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Problem is a damaged file of the repository
+type Problem struct {
+	Path   string `json:"path"`             // relative to the repository
+	Offset int64  `json:"offset,omitempty"` // byte offset of a damaged record
+	Reason string `json:"reason"`
+}
+
+func (p Problem) String() string {
+	if p.Offset > 0 {
+		return fmt.Sprintf("%s: byte %d: %s", p.Path, p.Offset, p.Reason)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Reason)
+}
+
+// Report is the result of a check
+type Report struct {
+	Logs     int       `json:"logs"`
+	Stores   int       `json:"stores"`
+	Problems []Problem `json:"problems"`
+}
+
+// Check reads every op log and op store of a repository, verifying segment
+// and record checksums, and reports what is damaged. The ops of each undamaged
+// log are applied to a document that must pass crdt's Validate.
+func Check(repoPath string) (*Report, error) {
+	r := &Report{Problems: []Problem{}}
+	seen := make(map[Problem]bool)
+	add := func(err error) {
+		p := problem(repoPath, err)
+		if !seen[p] {
+			seen[p] = true
+			r.Problems = append(r.Problems, p)
+		}
+	}
+
+	logs, err := ops.AllLogs(filepath.Join(repo.Dir(repoPath), "ops"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list op logs: %w", err)
+	}
+	for _, path := range logs {
+		r.Logs++
+		if err := ops.VerifyLog(path); err != nil {
+			add(&os.PathError{Op: "verify", Path: path, Err: err})
+		} else if err := validate(path); err != nil {
+			add(&os.PathError{Op: "validate", Path: path, Err: err})
+		}
+	}
+
+	stores, err := filepath.Glob(filepath.Join(repo.Dir(repoPath), "opstore", "*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stores {
+		r.Stores++
+		if err := ops.VerifyStore(path); err != nil {
+			add(&os.PathError{Op: "verify", Path: path, Err: err})
+		}
+	}
+	sort.Slice(r.Problems, func(i, j int) bool {
+		a, b := r.Problems[i], r.Problems[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Offset < b.Offset
+	})
+	return r, nil
+}
+
+// validate builds the document of an op log and checks its invariants
+func validate(path string) error {
+	logOps, _, err := ops.ReadOpsFrom(path, 0)
+	if err != nil {
+		return err
+	}
+	doc := crdt.NewRGA(crdt.WithoutLog())
+	for _, op := range logOps {
+		if err := doc.Apply(op); err != nil {
+			return fmt.Errorf("invalid document: %w", err)
+		}
+	}
+	if err := doc.Validate(); err != nil {
+		return fmt.Errorf("invalid document: %w", err)
+	}
+	return nil
+}
+
+// problem describes an error of the file it was found in; a damaged record
+// may be in the store a log refers to
+func problem(repoPath string, err error) Problem {
+	var p Problem
+	var ce *ops.CorruptionError
+	var pe *os.PathError
+	switch {
+	case errors.As(err, &ce):
+		p = Problem{Path: ce.Path, Offset: ce.Offset, Reason: ce.Reason}
+	case errors.As(err, &pe):
+		p = Problem{Path: pe.Path, Reason: pe.Err.Error()}
+	default:
+		p = Problem{Reason: err.Error()}
+	}
+	if rel, err := filepath.Rel(repoPath, p.Path); err == nil {
+		p.Path = rel
+	}
+	return p
+}
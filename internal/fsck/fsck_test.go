@@ -0,0 +1,43 @@
+package fsck
+
+import (
+	"evo/internal/crdt"
+	"evo/internal/ops"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	rp := t.TempDir()
+	fid, nid := uuid.New(), uuid.New()
+	main := filepath.Join(rp, ".evo", "ops", "main", fid.String()+".bin")
+	feature := filepath.Join(rp, ".evo", "ops", "feature", fid.String()+".bin")
+	for i, content := range []string{"one", "two"} {
+		op := crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i + 1), NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: content}
+		assert.NoError(t, ops.AppendRef(main, op))
+		assert.NoError(t, ops.AppendOp(feature, op))
+	}
+
+	r, err := Check(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Logs)
+	assert.Equal(t, 1, r.Stores)
+	assert.Empty(t, r.Problems)
+
+	data, err := os.ReadFile(feature)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(feature, data, 0644))
+	r, err = Check(rp)
+	assert.NoError(t, err)
+	if assert.Len(t, r.Problems, 1) {
+		p := r.Problems[0]
+		assert.Equal(t, filepath.Join(".evo", "ops", "feature", fid.String()+".bin"), p.Path)
+		assert.Greater(t, p.Offset, int64(0))
+		assert.Equal(t, "checksum mismatch", p.Reason)
+	}
+}
@@ -0,0 +1,129 @@
+// Package graph lays out the commits of several streams for log --graph.
+// Commits carry no parent links: a stream's history is its commits in
+// timestamp order, and a merge copies commits into the target stream under
+// the same IDs. So every stream is a lane, every commit ID a row, and a commit
+// held by several streams is drawn in each of their lanes, joined across its
+// row.
+package graph
+
+import (
+	"evo/internal/commits"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Row is one commit of the graph
+type Row struct {
+	ID        string
+	Timestamp time.Time
+	Lanes     []bool   // whether each stream holds the commit
+	Heads     []string // streams whose newest commit this is
+}
+
+// Stream returns the first stream holding the commit
+func (r *Row) Stream(streams []string) string {
+	for i, held := range r.Lanes {
+		if held {
+			return streams[i]
+		}
+	}
+	return ""
+}
+
+// Graph is the commits of a set of streams, newest first
+type Graph struct {
+	Streams []string
+	Rows    []Row
+	first   []int // row of each lane's newest commit, -1 for none
+	last    []int // row of each lane's oldest commit
+}
+
+// Build lays out the commits of each stream, given oldest first as the commit
+// index lists them; lanes follow the order of streams
+func Build(streams []string, entries map[string][]commits.IndexEntry) *Graph {
+	g := &Graph{Streams: streams}
+	rows := make(map[string]*Row)
+	for lane, s := range streams {
+		for _, e := range entries[s] {
+			r, ok := rows[e.ID]
+			if !ok {
+				r = &Row{ID: e.ID, Timestamp: e.Timestamp, Lanes: make([]bool, len(streams))}
+				rows[e.ID] = r
+			}
+			r.Lanes[lane] = true
+		}
+	}
+	for _, r := range rows {
+		g.Rows = append(g.Rows, *r)
+	}
+	sort.Slice(g.Rows, func(i, j int) bool {
+		a, b := &g.Rows[i], &g.Rows[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		return a.ID > b.ID
+	})
+
+	g.first = make([]int, len(streams))
+	g.last = make([]int, len(streams))
+	for lane := range streams {
+		g.first[lane], g.last[lane] = -1, -1
+	}
+	for i := range g.Rows {
+		for lane, held := range g.Rows[i].Lanes {
+			if !held {
+				continue
+			}
+			if g.first[lane] < 0 {
+				g.first[lane] = i
+				g.Rows[i].Heads = append(g.Rows[i].Heads, streams[lane])
+			}
+			g.last[lane] = i
+		}
+	}
+	return g
+}
+
+// Line draws the lanes of row i: "*" where a stream holds the commit, "|"
+// where a stream's history runs past it and "-" joining the lanes of a
+// commit several streams hold ("+" where the join crosses a lane). color
+// paints the part of a lane and is called with the lane's index.
+func (g *Graph) Line(i int, color func(lane int, s string) string) string {
+	r := &g.Rows[i]
+	lo, hi := -1, -1
+	for lane, held := range r.Lanes {
+		if held {
+			if lo < 0 {
+				lo = lane
+			}
+			hi = lane
+		}
+	}
+	var b strings.Builder
+	for lane := range g.Streams {
+		active := g.first[lane] >= 0 && g.first[lane] < i && i < g.last[lane]
+		joined := lane > lo && lane < hi
+		sym := " "
+		switch {
+		case r.Lanes[lane]:
+			sym = "*"
+		case joined && active:
+			sym = "+"
+		case joined:
+			sym = "-"
+		case active:
+			sym = "|"
+		}
+		if sym != " " {
+			sym = color(lane, sym)
+		}
+		b.WriteString(sym)
+		if lane >= lo && lane < hi {
+			b.WriteString(color(lo, "-"))
+		} else {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
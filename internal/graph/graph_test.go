@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"evo/internal/commits"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(stream, id string, hours int) commits.IndexEntry {
+		return commits.IndexEntry{ID: id, Stream: stream, Timestamp: base.Add(time.Duration(hours) * time.Hour)}
+	}
+	// main: a, b (merged from feature), d; feature: b, c; docs: e
+	g := Build([]string{"main", "feature", "docs"}, map[string][]commits.IndexEntry{
+		"main":    {at("main", "a", 0), at("main", "b", 1), at("main", "d", 3)},
+		"feature": {at("feature", "b", 1), at("feature", "c", 2)},
+		"docs":    {at("docs", "e", 4)},
+	})
+	plain := func(_ int, s string) string { return s }
+
+	var ids, lines []string
+	for i := range g.Rows {
+		ids = append(ids, g.Rows[i].ID)
+		lines = append(lines, g.Line(i, plain))
+	}
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, ids)
+	assert.Equal(t, []string{
+		"    * ",
+		"*     ",
+		"| *   ",
+		"*-*   ",
+		"*     ",
+	}, lines)
+	assert.Equal(t, []string{"docs"}, g.Rows[0].Heads)
+	assert.Equal(t, []string{"feature"}, g.Rows[2].Heads)
+	assert.Equal(t, "main", g.Rows[3].Stream(g.Streams))
+
+	// a join across a lane it does not hold crosses it
+	g = Build([]string{"main", "feature", "docs"}, map[string][]commits.IndexEntry{
+		"main":    {at("main", "a", 0)},
+		"feature": {at("feature", "x", -1), at("feature", "y", 1)},
+		"docs":    {at("docs", "a", 0)},
+	})
+	assert.Equal(t, "*-+-* ", g.Line(1, plain))
+}
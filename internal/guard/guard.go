@@ -0,0 +1,269 @@
+// Package guard checks what commits leave in the files they touch against
+// the guards in the config: a size limit for files not stored as large
+// files, path patterns no commit may add to, and lines that look like
+// secrets. evo commit runs the guards before recording a commit, unless
+// given --no-verify, and evo serve before accepting a push or a merge.
+package guard
+
+import (
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+var logger = log.For("guard")
+
+// Guards are the checks commits to one stream must pass
+type Guards struct {
+	MaxFileSize   int64    // largest file kept as text, 0 for no limit
+	Forbidden     []string // path patterns no commit may leave content in
+	Secrets       bool     // reject added lines that look like secrets
+	SecretsIgnore []string // path patterns not scanned for secrets
+}
+
+// Load reads the guards of stream
+func Load(repoPath, stream string) (*Guards, error) {
+	cfg, err := config.LoadForStream(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	g := &Guards{
+		Forbidden:     patterns(cfg.String("guard.forbidden")),
+		SecretsIgnore: patterns(cfg.String("guard.secretsIgnore")),
+	}
+	if g.MaxFileSize, err = cfg.Size("guard.maxFileSize"); err != nil {
+		return nil, err
+	}
+	if g.Secrets, err = cfg.Bool("guard.secrets"); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func patterns(list string) []string {
+	var out []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Enabled reports whether any guard is configured
+func (g *Guards) Enabled() bool {
+	return g.MaxFileSize > 0 || len(g.Forbidden) > 0 || g.Secrets
+}
+
+// Violation is one thing a commit would leave that a guard rejects
+type Violation struct {
+	Path   string `json:"path"` // file ID when the path isn't known
+	FileID string `json:"fileId"`
+	Kind   string `json:"kind"` // "size", "forbidden" or "secret"
+	Line   int    `json:"line,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("%s: %s:%d: %s", v.Kind, v.Path, v.Line, v.Detail)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Kind, v.Path, v.Detail)
+}
+
+// Check runs the guards against the files eops touch, as the stream's op logs
+// and eops leave them. applied tells whether eops are in the op logs already,
+// as with the new ops evo commit gathers, or not yet, as with a push. Paths
+// come from the index; a repository without one, as a bare server, knows
+// files by ID only and can't apply guard.forbidden or guard.secretsIgnore.
+func (g *Guards) Check(repoPath, stream string, eops []types.ExtendedOp, applied bool) ([]Violation, error) {
+	if !g.Enabled() {
+		return nil, nil
+	}
+	_, id2p, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	byFile := make(map[string][]crdt.Operation)
+	var fids []string
+	for _, eop := range eops {
+		fid := eop.Op.FileID.String()
+		if _, ok := byFile[fid]; !ok {
+			fids = append(fids, fid)
+		}
+		byFile[fid] = append(byFile[fid], eop.Op)
+	}
+	sort.Strings(fids)
+
+	var out []Violation
+	for _, fid := range fids {
+		path, known := id2p[fid]
+		if !known {
+			path = fid
+		}
+		violate := func(kind string, line int, format string, args ...any) {
+			out = append(out, Violation{Path: path, FileID: fid, Kind: kind, Line: line, Detail: fmt.Sprintf(format, args...)})
+		}
+		before, after, err := states(repoPath, stream, fid, byFile[fid], applied)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay %s: %w", path, err)
+		}
+		if after == "" {
+			// removing a file, or all of it, is always allowed
+			continue
+		}
+		if known && matchAny(g.Forbidden, path) {
+			violate("forbidden", 0, "path matches guard.forbidden")
+		}
+		if strings.HasPrefix(after, "EVO-LFS:") && !strings.Contains(after, "\n") {
+			// stored as a large file: the op log holds only its stub
+			continue
+		}
+		if g.MaxFileSize > 0 && int64(len(after)) > g.MaxFileSize {
+			violate("size", 0, "%d bytes not stored as a large file (guard.maxFileSize %d)", len(after), g.MaxFileSize)
+		}
+		if !g.Secrets || known && matchAny(g.SecretsIgnore, path) {
+			continue
+		}
+		old := make(map[string]int)
+		for _, l := range strings.Split(before, "\n") {
+			old[l]++
+		}
+		for i, l := range strings.Split(after, "\n") {
+			if old[l] > 0 {
+				old[l]--
+				continue
+			}
+			if what := Secret(l); what != "" {
+				violate("secret", i+1, "%s", what)
+			}
+		}
+	}
+	if len(out) > 0 {
+		logger.Info("guards rejected changes", "stream", stream, "violations", len(out))
+	}
+	return out, nil
+}
+
+// states returns a file's text without and with the ops pending, the ones a
+// commit or push is about to record
+func states(repoPath, stream, fid string, pending []crdt.Operation, applied bool) (string, string, error) {
+	logged, err := ops.LoadAllOps(filepath.Join(repo.Dir(repoPath), "ops", stream, fid+".bin"))
+	if err != nil {
+		return "", "", err
+	}
+	type key struct {
+		typ     crdt.OpType
+		lamport uint64
+		node    [16]byte
+		line    [16]byte
+	}
+	isPending := make(map[key]bool, len(pending))
+	for _, op := range pending {
+		isPending[key{op.Type, op.Lamport, op.NodeID, op.LineID}] = true
+	}
+	before, after := crdt.NewRGA(crdt.WithoutLog()), crdt.NewRGA(crdt.WithoutLog())
+	for _, op := range logged {
+		if !isPending[key{op.Type, op.Lamport, op.NodeID, op.LineID}] {
+			if err := before.Apply(op); err != nil {
+				return "", "", err
+			}
+		}
+		if err := after.Apply(op); err != nil {
+			return "", "", err
+		}
+	}
+	if !applied {
+		for _, op := range pending {
+			if err := after.Apply(op); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return materialize.Content(before), materialize.Content(after), nil
+}
+
+// matchAny reports whether path matches one of the patterns. As in
+// .evo/attributes, a pattern without a slash matches the base name anywhere
+// in the tree.
+func matchAny(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if ok, err := doublestar.Match(p, path); err == nil && ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, err := doublestar.Match(p, filepath.Base(path)); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	privateKey = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	accessKey  = regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)
+	token      = regexp.MustCompile(`[A-Za-z0-9+/=_-]{20,}`)
+	hasDigit   = regexp.MustCompile(`[0-9]`)
+	hasLetter  = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// minEntropy is the Shannon entropy, in bits per character, from which a
+// token of letters and digits is taken for a key. Random base64 reaches it
+// from about 24 characters; words and identifiers stay well below.
+const minEntropy = 4.5
+
+// Secret describes what in line looks like a secret, "" if nothing does: a
+// private key, an AWS access key ID, or a long token of letters and digits
+// as random as generated keys are
+func Secret(line string) string {
+	if privateKey.MatchString(line) {
+		return "private key"
+	}
+	if m := accessKey.FindString(line); m != "" {
+		return fmt.Sprintf("access key ID %s", mask(m))
+	}
+	for _, t := range token.FindAllString(line, -1) {
+		if !hasDigit.MatchString(t) || !hasLetter.MatchString(t) {
+			continue
+		}
+		if e := entropy(t); e >= minEntropy {
+			return fmt.Sprintf("high-entropy string %s (%.1f bits per character)", mask(t), e)
+		}
+	}
+	return ""
+}
+
+// mask shows enough of a secret to find it, without repeating it
+func mask(s string) string {
+	return s[:4] + strings.Repeat("*", 4)
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,158 @@
+// Package history rebuilds what a stream looked like at one of its commits
+// by replaying the ops of its commits in order.
+package history
+
+import (
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/types"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("history")
+
+// Line is one line of a file with the commit that last wrote it
+type Line struct {
+	ID      uuid.UUID `json:"id"`
+	Content string    `json:"content"`
+	Commit  string    `json:"commit"`
+}
+
+// File is a file as of a commit
+type File struct {
+	Path   string `json:"path"`
+	FileID string `json:"fileId"`
+	Lines  []Line `json:"lines"`
+}
+
+// Snapshot is the files of a stream as of a commit
+type Snapshot struct {
+	Stream string                  `json:"stream"`
+	Commit *types.Commit           `json:"commit"`
+	Files  map[string]*File        `json:"files"` // by path
+	byID   map[string]types.Commit // commits up to Commit
+}
+
+// At replays the commits of stream up to and including commitID. Paths come
+// from the index, or for files it no longer holds the path it last gave
+// them; files it never knew are named by their file ID.
+func At(repoPath, stream, commitID string) (*Snapshot, error) {
+	cs, err := commits.ListCommits(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	id2path, err := index.KnownPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Stream: stream, Files: make(map[string]*File), byID: make(map[string]types.Commit)}
+	docs := make(map[uuid.UUID]*crdt.RGA)
+	writer := make(map[uuid.UUID]string)
+	for i := range cs {
+		c := cs[i]
+		s.byID[c.ID] = c
+		for _, eop := range c.Operations {
+			op := eop.Op
+			doc := docs[op.FileID]
+			if doc == nil {
+				doc = crdt.NewRGA()
+				docs[op.FileID] = doc
+			}
+			if err := doc.Apply(op); err != nil {
+				// ops pruned by compaction leave updates without their line
+				logger.Debug("skipping op", "commit", c.ID, "err", err)
+				continue
+			}
+			if op.Type != crdt.OpDelete {
+				writer[op.LineID] = c.ID
+			}
+		}
+		if c.ID == commitID {
+			s.Commit = &c
+			break
+		}
+	}
+	if s.Commit == nil {
+		return nil, fmt.Errorf("commit %s is not in stream %s", commitID, stream)
+	}
+	for fid, doc := range docs {
+		content := doc.LineMap()
+		ids := doc.GetLineIDs()
+		if len(ids) == 0 {
+			continue
+		}
+		path := id2path[fid.String()]
+		if path == "" {
+			path = fid.String()
+		}
+		f := &File{Path: path, FileID: fid.String()}
+		for _, id := range ids {
+			f.Lines = append(f.Lines, Line{ID: id, Content: content[id], Commit: writer[id]})
+		}
+		s.Files[path] = f
+	}
+	return s, nil
+}
+
+// Paths lists the files of the snapshot in order
+func (s *Snapshot) Paths() []string {
+	out := make([]string, 0, len(s.Files))
+	for p := range s.Files {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CommitOf returns a commit the snapshot was built from, for blame
+func (s *Snapshot) CommitOf(id string) (types.Commit, bool) {
+	c, ok := s.byID[id]
+	return c, ok
+}
+
+// Change is one changed line of a commit
+type Change struct {
+	Kind    string `json:"kind"` // "+" or "-"
+	Content string `json:"content"`
+}
+
+// FileChanges are the changed lines of one file
+type FileChanges struct {
+	Path    string   `json:"path"`
+	Changes []Change `json:"changes"`
+}
+
+// Changes groups the line changes of a commit by file, in path order. An
+// update is a removed line followed by an added one.
+func Changes(repoPath string, c *types.Commit) ([]FileChanges, error) {
+	id2path, err := index.KnownPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	byFile := make(map[string][]Change)
+	for _, eop := range c.Operations {
+		path := id2path[eop.Op.FileID.String()]
+		if path == "" {
+			path = eop.Op.FileID.String()
+		}
+		switch eop.Op.Type {
+		case crdt.OpInsert:
+			byFile[path] = append(byFile[path], Change{"+", eop.Op.Content})
+		case crdt.OpDelete:
+			byFile[path] = append(byFile[path], Change{"-", eop.OldContent})
+		case crdt.OpUpdate:
+			byFile[path] = append(byFile[path], Change{"-", eop.OldContent}, Change{"+", eop.Op.Content})
+		}
+	}
+	out := make([]FileChanges, 0, len(byFile))
+	for p, ch := range byFile {
+		out = append(out, FileChanges{p, ch})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
@@ -0,0 +1,112 @@
+package history
+
+import (
+	"context"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAt(t *testing.T) {
+	rp := t.TempDir()
+	fid, nid := uuid.New(), uuid.New()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" a.txt\n"), 0644))
+
+	lamport := uint64(0)
+	op := func(typ crdt.OpType, line, origin uuid.UUID, content string) types.ExtendedOp {
+		lamport++
+		return types.ExtendedOp{Op: crdt.Operation{Type: typ, Lamport: lamport, NodeID: nid, FileID: fid, LineID: line, OriginLineID: origin, Content: content}}
+	}
+	commit := func(id string, eops ...types.ExtendedOp) {
+		c := &types.Commit{ID: id, Stream: "main", Message: id, Timestamp: time.Now().Add(time.Duration(lamport) * time.Second), Operations: eops}
+		assert.NoError(t, commits.SaveCommitTo(rp, "main", c))
+	}
+	one, two := uuid.New(), uuid.New()
+	commit("c1", op(crdt.OpInsert, one, crdt.DocumentStart, "one"))
+	commit("c2", op(crdt.OpInsert, two, one, "two"))
+	update := op(crdt.OpUpdate, one, uuid.Nil, "ONE")
+	update.OldContent = "one"
+	del := op(crdt.OpDelete, two, uuid.Nil, "")
+	del.OldContent = "two"
+	commit("c3", update, del)
+
+	s, err := At(rp, "main", "c2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, s.Paths())
+	f := s.Files["a.txt"]
+	assert.Equal(t, []Line{{one, "one", "c1"}, {two, "two", "c2"}}, f.Lines)
+
+	s, err = At(rp, "main", "c3")
+	assert.NoError(t, err)
+	assert.Equal(t, []Line{{one, "ONE", "c3"}}, s.Files["a.txt"].Lines)
+	c, ok := s.CommitOf("c3")
+	assert.True(t, ok)
+	assert.Equal(t, "c3", c.Message)
+
+	_, err = At(rp, "main", "nope")
+	assert.Error(t, err)
+
+	changes, err := Changes(rp, s.Commit)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileChanges{{"a.txt", []Change{{"-", "one"}, {"+", "ONE"}, {"-", "two"}}}}, changes)
+}
+
+func TestWhy(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	a := filepath.Join(rp, "a.txt")
+	commit := func(stream, content, msg string) *types.Commit {
+		require.NoError(t, os.WriteFile(a, []byte(content), 0644))
+		require.NoError(t, index.UpdateIndex(rp))
+		_, err := ingest.IngestLocalChanges(context.Background(), rp, stream)
+		require.NoError(t, err)
+		if msg == "" {
+			return nil
+		}
+		eops, err := commits.GatherNewOps(rp, stream)
+		require.NoError(t, err)
+		c, err := commits.CreateCommit(rp, stream, msg, "ann", "ann@example.com", eops, false)
+		require.NoError(t, err)
+		return c
+	}
+	first := commit("main", "one\ntwo", "first")
+	require.NoError(t, streams.CreateStreamFrom(rp, "feat", "main", ""))
+	upd := commit("feat", "one\nTWO", "upd")
+	require.NoError(t, streams.MergeStreams(context.Background(), rp, "feat", "main"))
+	commit("main", "one\nTwo!", "")
+	fid, err := index.LookupFileID(rp, "a.txt")
+	require.NoError(t, err)
+
+	p, err := Why(rp, "main", fid, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Two!", p.Content)
+	require.Len(t, p.Steps, 3)
+	assert.Equal(t, "insert", p.Steps[0].Type)
+	assert.Equal(t, first.ID, p.Steps[0].Commit)
+	assert.Equal(t, []string{"feat", "main"}, p.Steps[0].Streams)
+	assert.Equal(t, "update", p.Steps[1].Type)
+	assert.Equal(t, upd.ID, p.Steps[1].Commit)
+	assert.Equal(t, "two", p.Steps[1].OldContent)
+	assert.Equal(t, "ann <ann@example.com>", p.Steps[1].Author)
+	assert.Equal(t, []string{"feat", "main"}, p.Steps[1].Streams)
+	// not committed yet
+	assert.Equal(t, "Two!", p.Steps[2].Content)
+	assert.Empty(t, p.Steps[2].Commit)
+	assert.Empty(t, p.Steps[2].Streams)
+
+	_, err = Why(rp, "main", fid, 3)
+	assert.ErrorContains(t, err, "out of range")
+}
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/materialize"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Step is one op of a line's history, with the commit that carried it into
+// the stream and every stream whose history has it
+type Step struct {
+	Type       string    `json:"type"` // "insert", "update" or "delete"
+	Content    string    `json:"content,omitempty"`
+	OldContent string    `json:"oldContent,omitempty"`
+	Lamport    uint64    `json:"lamport"`
+	Node       string    `json:"node"`
+	Time       time.Time `json:"time"`
+	Commit     string    `json:"commit,omitempty"` // empty while uncommitted
+	Author     string    `json:"author,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	PickedFrom string    `json:"pickedFrom,omitempty"`
+	Streams    []string  `json:"streams"`
+}
+
+// Provenance is the history of one line of a file
+type Provenance struct {
+	Stream  string    `json:"stream"`
+	FileID  string    `json:"fileId"`
+	Line    int       `json:"line"`
+	LineID  uuid.UUID `json:"lineId"`
+	Content string    `json:"content"`
+	Steps   []Step    `json:"steps"`
+}
+
+// opKey identifies an op across streams: a node stamps each op it makes
+// with a Lamport time of its own
+type opKey struct {
+	node    uuid.UUID
+	lamport uint64
+}
+
+// appearance is a commit of some stream holding an op
+type appearance struct {
+	stream string
+	commit types.Commit
+	eop    types.ExtendedOp
+}
+
+// Why traces the line at a 1-based line number of a file, as the stream's
+// op log has it, back through every op on its line ID in log order: the
+// insert that created it and each update since, including those merged in
+// from other streams. Each step names its commit in the stream, or in
+// another stream when it came by a merge, and all streams that have it. A
+// line made of word or character fragments is traced through the fragment
+// it starts with.
+func Why(repoPath, stream, fileID string, line int) (*Provenance, error) {
+	doc, err := materialize.Load(repoPath, stream, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if line < 1 || line > len(doc.Lines) {
+		return nil, fmt.Errorf("line %d is out of range: the file has %d line(s)", line, len(doc.Lines))
+	}
+	lineID := doc.LineIDs[line-1]
+	if lineID == uuid.Nil {
+		return nil, fmt.Errorf("line %d is the empty line after the file's last line break", line)
+	}
+	p := &Provenance{Stream: stream, FileID: fileID, Line: line, LineID: lineID, Content: doc.Lines[line-1], Steps: []Step{}}
+
+	seen, err := appearances(repoPath, lineID)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := doc.Ops()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.LineID != lineID {
+			continue
+		}
+		step := Step{
+			Type:    opName(op.Type),
+			Content: op.Content,
+			Lamport: op.Lamport,
+			Node:    op.NodeID.String(),
+			Time:    op.Timestamp,
+			Streams: []string{},
+		}
+		in := seen[opKey{op.NodeID, op.Lamport}]
+		for i, a := range in {
+			if i == 0 || a.stream == stream {
+				step.Commit = a.commit.ID
+				step.Author = fmt.Sprintf("%s <%s>", a.commit.AuthorName, a.commit.AuthorEmail)
+				step.Message = a.commit.Message
+				step.PickedFrom = a.commit.PickedFrom
+				step.OldContent = a.eop.OldContent
+			}
+			if n := len(step.Streams); n == 0 || step.Streams[n-1] != a.stream {
+				step.Streams = append(step.Streams, a.stream)
+			}
+		}
+		p.Steps = append(p.Steps, step)
+	}
+	return p, nil
+}
+
+// appearances finds the commits of every stream holding ops on lineID, by
+// stream name
+func appearances(repoPath string, lineID uuid.UUID) (map[opKey][]appearance, error) {
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	out := make(map[opKey][]appearance)
+	for _, name := range names {
+		err := commits.ForEachCommit(repoPath, name, commits.IterOptions{}, func(c *types.Commit) error {
+			for _, eop := range c.Operations {
+				if eop.Op.LineID != lineID {
+					continue
+				}
+				k := opKey{eop.Op.NodeID, eop.Op.Lamport}
+				out[k] = append(out[k], appearance{name, *c, eop})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commits of %s: %w", name, err)
+		}
+	}
+	return out, nil
+}
+
+func opName(t crdt.OpType) string {
+	switch t {
+	case crdt.OpInsert:
+		return "insert"
+	case crdt.OpUpdate:
+		return "update"
+	case crdt.OpDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("op %d", t)
+}
@@ -0,0 +1,225 @@
+// Package ide serves editor plugins over JSON-RPC 2.0 on a pair of streams,
+// framed as in the Language Server Protocol: each message is preceded by a
+// Content-Length header. One long-lived process answers status, stream, diff
+// and blame queries, so plugins don't start the CLI on every keystroke.
+//
+//	initialize              => {name, methods}
+//	evo/status              => status.RepoStatus of the working tree
+//	evo/stream              => StreamInfo: the current stream, its head and all streams
+//	evo/diff {path, text?}  => []Hunk between the stream and the file, or unsaved text
+//	evo/blame {path, line?, text?} => []BlameLine, one line (1-based) or all
+//	shutdown, exit
+//
+// Paths are relative to the repository, absolute, or file:// URIs.
+package ide
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"evo/internal/log"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var logger = log.For("ide")
+
+// JSON-RPC error codes
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternal       = -32603
+	codeRequestFailed  = -32000
+)
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+func invalidParams(format string, args ...any) error {
+	return &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// Server answers the queries of one editor
+type Server struct {
+	repoPath string
+	out      io.Writer
+	wmu      sync.Mutex
+	blame    blameCache
+	done     bool
+}
+
+// NewServer returns a server for a repository writing responses to w
+func NewServer(repoPath string, w io.Writer) *Server {
+	return &Server{repoPath: repoPath, out: w}
+}
+
+// Serve reads requests from r until it ends or an exit notification
+// arrives, answering each in turn
+func (s *Server) Serve(r io.Reader) error {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	for !s.done {
+		body, err := readMessage(tp)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.handle(body)
+	}
+	return nil
+}
+
+// readMessage reads the headers of a message and its body
+func readMessage(tp *textproto.Reader) ([]byte, error) {
+	h, err := tp.ReadMIMEHeader()
+	if err == io.EOF && len(h) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", h.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(tp.R, body); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return body, nil
+}
+
+func (s *Server) handle(body []byte) {
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.write(response{ID: json.RawMessage("null"), Error: &rpcError{Code: codeParse, Message: err.Error()}})
+		return
+	}
+	if req.Method == "" {
+		s.write(response{ID: req.ID, Error: &rpcError{Code: codeInvalidRequest, Message: "method is required"}})
+		return
+	}
+	result, err := s.call(req.Method, req.Params)
+	if req.ID == nil {
+		// a notification => no response
+		if err != nil {
+			logger.Debug("notification failed", "method", req.Method, "err", err)
+		}
+		return
+	}
+	resp := response{ID: req.ID, Result: result}
+	if err != nil {
+		var re *rpcError
+		if !errors.As(err, &re) {
+			re = &rpcError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		resp.Error = re
+	} else if result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+	s.write(resp)
+}
+
+func (s *Server) call(method string, params json.RawMessage) (any, error) {
+	decode := func(v any) error {
+		if len(params) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(params, v); err != nil {
+			return invalidParams("invalid params: %v", err)
+		}
+		return nil
+	}
+	switch method {
+	case "initialize":
+		return map[string]any{"name": "evo", "methods": methods}, nil
+	case "initialized", "$/cancelRequest":
+		return nil, nil
+	case "shutdown":
+		s.blame.clear()
+		return nil, nil
+	case "exit":
+		s.done = true
+		return nil, nil
+	case "evo/status":
+		return s.status()
+	case "evo/stream":
+		return s.stream()
+	case "evo/diff":
+		var p fileParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.diff(p)
+	case "evo/blame":
+		var p fileParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.blameLines(p)
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "unknown method " + method}
+}
+
+var methods = []string{"evo/status", "evo/stream", "evo/diff", "evo/blame"}
+
+func (s *Server) write(resp response) {
+	resp.JSONRPC = "2.0"
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(response{JSONRPC: "2.0", ID: resp.ID, Error: &rpcError{Code: codeInternal, Message: err.Error()}})
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data))
+	s.out.Write(data)
+}
+
+// fileParams name a file, with the editor's unsaved text if it has any
+type fileParams struct {
+	Path string  `json:"path"`
+	Line int     `json:"line,omitempty"`
+	Text *string `json:"text,omitempty"`
+}
+
+// rel returns the path of a file relative to the repository
+func (s *Server) rel(path string) (string, error) {
+	if path == "" {
+		return "", invalidParams("path is required")
+	}
+	if strings.HasPrefix(path, "file://") {
+		u, err := url.Parse(path)
+		if err != nil {
+			return "", invalidParams("invalid file URI %q", path)
+		}
+		path = filepath.FromSlash(u.Path)
+	}
+	return relPath(s.repoPath, path)
+}
@@ -0,0 +1,103 @@
+package ide
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"evo/internal/repo"
+	"evo/internal/testutil"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exchange sends requests to a server and returns its responses by ID
+func exchange(t *testing.T, rp string, reqs ...string) map[string]response {
+	var in bytes.Buffer
+	for _, r := range reqs {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(r), r)
+	}
+	var out bytes.Buffer
+	require.NoError(t, NewServer(rp, &out).Serve(&in))
+
+	got := make(map[string]response)
+	tp := textproto.NewReader(bufio.NewReader(&out))
+	for {
+		body, err := readMessage(tp)
+		if err != nil {
+			break
+		}
+		var resp struct {
+			response
+			Result json.RawMessage `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(body, &resp))
+		resp.response.Result = resp.Result
+		got[string(resp.ID)] = resp.response
+	}
+	return got
+}
+
+func TestServer(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	path := filepath.Join(rp, "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644))
+	testutil.CommitAs(t, rp, "main", "ann", "first")
+	require.NoError(t, os.WriteFile(path, []byte("one\nTWO\nthree\n"), 0644))
+	testutil.CommitAs(t, rp, "main", "bob", "second\n\nbody")
+	require.NoError(t, os.WriteFile(path, []byte("zero\none\nTWO\n"), 0644))
+
+	got := exchange(t, rp,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"evo/stream"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"evo/diff","params":{"path":"a.txt"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"evo/blame","params":{"path":"file://`+path+`"}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"evo/diff","params":{"path":"a.txt","text":"one\nTWO\nthree\n"}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"evo/blame","params":{"path":"a.txt","line":9}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"nope"}`,
+		`{"jsonrpc":"2.0","id":8,"method":"evo/diff","params":{"path":"../x"}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":9,"method":"evo/stream"}`,
+	)
+
+	var init struct{ Methods []string }
+	require.NoError(t, json.Unmarshal(got["1"].Result.(json.RawMessage), &init))
+	assert.Contains(t, init.Methods, "evo/blame")
+
+	var info StreamInfo
+	require.NoError(t, json.Unmarshal(got["2"].Result.(json.RawMessage), &info))
+	assert.Equal(t, "main", info.Stream)
+	assert.Equal(t, map[string]int{"main": 2}, info.Streams)
+	require.NotNil(t, info.Head)
+	assert.Equal(t, "bob", info.Head.AuthorName)
+
+	var hs []Hunk
+	require.NoError(t, json.Unmarshal(got["3"].Result.(json.RawMessage), &hs))
+	assert.Equal(t, []Hunk{
+		{Kind: "add", OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1},
+		{Kind: "delete", OldStart: 3, OldLines: 1, NewStart: 3, NewLines: 0},
+	}, hs)
+
+	var bl []BlameLine
+	require.NoError(t, json.Unmarshal(got["4"].Result.(json.RawMessage), &bl))
+	require.Len(t, bl, 4)
+	assert.Nil(t, bl[0].Commit)
+	assert.Equal(t, "ann", bl[1].Commit.AuthorName)
+	assert.Equal(t, "bob", bl[2].Commit.AuthorName)
+	assert.Equal(t, "second", bl[2].Commit.Message)
+
+	require.NoError(t, json.Unmarshal(got["5"].Result.(json.RawMessage), &hs))
+	assert.Empty(t, hs)
+
+	assert.Equal(t, codeInvalidParams, got["6"].Error.Code)
+	assert.Equal(t, codeMethodNotFound, got["7"].Error.Code)
+	assert.Equal(t, codeInvalidParams, got["8"].Error.Code)
+	_, answered := got["9"]
+	assert.False(t, answered, "nothing is read after exit")
+}
@@ -0,0 +1,305 @@
+package ide
+
+import (
+	"evo/internal/commits"
+	"evo/internal/diff"
+	"evo/internal/history"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/status"
+	"evo/internal/streams"
+	"evo/internal/textenc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (s *Server) status() (*status.RepoStatus, error) {
+	return status.GetStatus(s.repoPath)
+}
+
+// CommitInfo is the metadata of a commit
+type CommitInfo struct {
+	ID          string    `json:"id"`
+	Message     string    `json:"message,omitempty"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// StreamInfo is the current stream with its newest commit, and every stream
+// with its commit count
+type StreamInfo struct {
+	Stream  string         `json:"stream"`
+	Head    *CommitInfo    `json:"head,omitempty"`
+	Streams map[string]int `json:"streams"`
+}
+
+func (s *Server) stream() (*StreamInfo, error) {
+	cur, err := streams.CurrentStream(s.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	names, err := streams.ListStreams(s.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := commits.LoadIndex(s.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	info := &StreamInfo{Stream: cur, Streams: make(map[string]int)}
+	for _, name := range names {
+		es, err := idx.Stream(name)
+		if err != nil {
+			return nil, err
+		}
+		info.Streams[name] = len(es)
+		if name == cur && len(es) > 0 {
+			e := es[len(es)-1]
+			c, err := commits.ReadCommit(s.repoPath, e.Stream, e.ID)
+			if err != nil {
+				return nil, err
+			}
+			info.Head = &CommitInfo{ID: c.ID, Message: c.Message, AuthorName: c.AuthorName, AuthorEmail: c.AuthorEmail, Timestamp: c.Timestamp}
+		}
+	}
+	if err := idx.Save(); err != nil {
+		logger.Warn("failed to save commit index", "err", err)
+	}
+	return info, nil
+}
+
+// Hunk is a run of changed lines. Starts are 1-based; a hunk with no lines
+// on one side starts after the line before it there, as in unified diffs.
+type Hunk struct {
+	Kind     string `json:"kind"` // "add", "delete" or "change"
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+}
+
+// diff compares a file as the stream holds it with the working file
+func (s *Server) diff(p fileParams) ([]Hunk, error) {
+	old, cur, err := s.versions(p)
+	if err != nil {
+		return nil, err
+	}
+	return hunks(diff.Lines(old, cur)), nil
+}
+
+// versions returns the lines of a file in the current stream and in the
+// working tree, or the editor's text. A large file has no lines.
+func (s *Server) versions(p fileParams) ([]string, []string, error) {
+	rel, err := s.rel(p.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cur []string
+	if p.Text != nil {
+		cur = splitLines(*p.Text)
+	} else {
+		data, err := os.ReadFile(filepath.Join(s.repoPath, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		if err == nil {
+			text, _ := textenc.Text(data)
+			cur = splitLines(text)
+		}
+	}
+
+	stream, err := streams.CurrentStream(s.repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fid, err := index.LookupFileID(s.repoPath, rel)
+	if err != nil {
+		// not tracked => every line is new
+		return nil, cur, nil
+	}
+	if data, ok := materialize.Base(s.repoPath, stream, fid); ok {
+		// the content last in sync with the log, without replaying it
+		text, _ := textenc.Text(data)
+		return splitLines(text), cur, nil
+	}
+	doc, err := materialize.Load(s.repoPath, stream, fid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Lines) == 1 && strings.HasPrefix(doc.Lines[0], "EVO-LFS:") {
+		return nil, nil, nil
+	}
+	return doc.Lines, cur, nil
+}
+
+// splitLines splits text the way ingest does, so a trailing newline ends in
+// an empty last line
+func splitLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// hunks groups an edit script into runs of changes
+func hunks(edits []diff.Edit) []Hunk {
+	var out []Hunk
+	a, b := 0, 0 // lines of each side before the current edit
+	for i := 0; i < len(edits); {
+		if edits[i].Op == diff.Equal {
+			a, b = a+1, b+1
+			i++
+			continue
+		}
+		h := Hunk{OldStart: a + 1, NewStart: b + 1}
+		for ; i < len(edits) && edits[i].Op != diff.Equal; i++ {
+			if edits[i].Op == diff.Delete {
+				h.OldLines++
+			} else {
+				h.NewLines++
+			}
+		}
+		a, b = a+h.OldLines, b+h.NewLines
+		switch {
+		case h.OldLines == 0:
+			h.Kind, h.OldStart = "add", h.OldStart-1
+		case h.NewLines == 0:
+			h.Kind, h.NewStart = "delete", h.NewStart-1
+		default:
+			h.Kind = "change"
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// BlameLine is a line of the working file with the commit that wrote it,
+// none if it isn't committed
+type BlameLine struct {
+	Line   int         `json:"line"`
+	Commit *CommitInfo `json:"commit"`
+}
+
+// blameCache keeps the last snapshot blamed, until its stream moves on
+type blameCache struct {
+	mu   sync.Mutex
+	snap *history.Snapshot
+}
+
+func (c *blameCache) get(repoPath, stream string) (*history.Snapshot, error) {
+	idx, err := commits.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	es, err := idx.Stream(stream)
+	if err != nil || len(es) == 0 {
+		return nil, err
+	}
+	head := es[len(es)-1].ID
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snap != nil && c.snap.Stream == stream && c.snap.Commit.ID == head {
+		return c.snap, nil
+	}
+	snap, err := history.At(repoPath, stream, head)
+	if err != nil {
+		return nil, err
+	}
+	c.snap = snap
+	return snap, nil
+}
+
+func (c *blameCache) clear() {
+	c.mu.Lock()
+	c.snap = nil
+	c.mu.Unlock()
+}
+
+// blameLines blames the lines of the working file, or of the editor's text,
+// by matching them to the lines the stream's commits wrote
+func (s *Server) blameLines(p fileParams) ([]BlameLine, error) {
+	rel, err := s.rel(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	_, cur, err := s.versions(p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Line < 0 || p.Line > len(cur) {
+		return nil, invalidParams("line %d is out of range", p.Line)
+	}
+	stream, err := streams.CurrentStream(s.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := s.blame.get(s.repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	var lines []history.Line
+	if snap != nil && snap.Files[rel] != nil {
+		lines = snap.Files[rel].Lines
+	}
+	old := make([]string, len(lines))
+	for i, l := range lines {
+		old[i] = l.Content
+	}
+
+	out := make([]BlameLine, len(cur))
+	for i := range out {
+		out[i].Line = i + 1
+	}
+	infos := make(map[string]*CommitInfo)
+	for _, e := range diff.Lines(old, cur) {
+		if e.Op != diff.Equal {
+			continue
+		}
+		id := lines[e.A].Commit
+		info, ok := infos[id]
+		if !ok {
+			if c, found := snap.CommitOf(id); found {
+				info = &CommitInfo{ID: c.ID, Message: firstLine(c.Message), AuthorName: c.AuthorName, AuthorEmail: c.AuthorEmail, Timestamp: c.Timestamp}
+			}
+			infos[id] = info
+		}
+		out[e.B].Commit = info
+	}
+	if p.Line > 0 {
+		return out[p.Line-1 : p.Line], nil
+	}
+	return out, nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// relPath returns path relative to the repository. It may be relative
+// already or absolute.
+func relPath(repoPath, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		rel := filepath.ToSlash(filepath.Clean(path))
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", invalidParams("%s is outside the repository", path)
+		}
+		return rel, nil
+	}
+	root, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", invalidParams("%s is outside the repository", path)
+	}
+	return filepath.ToSlash(rel), nil
+}
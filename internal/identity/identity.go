@@ -0,0 +1,174 @@
+package identity
+
+import (
+	"errors"
+	"evo/internal/config"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// An identity is either user.name/user.email, or a named profile
+// identity.<profile>.name/email selected with user.identity. Profiles usually
+// live in the global config and a repo picks one with `evo id use`.
+
+// ErrUnset is returned when no author identity is configured
+var ErrUnset = errors.New("author identity unknown; run 'evo id setup' or pass --author \"Name <email>\"")
+
+// Fallback is the author recorded when user.requireIdentity is false and no
+// identity is configured
+var Fallback = Identity{Name: "EvoUser", Email: "user@evo"}
+
+// Identity is a commit author
+type Identity struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Profile string `json:"profile,omitempty"`
+	Source  string `json:"source"` // where it was found: a config scope, "--author" or "fallback"
+}
+
+func (id Identity) String() string {
+	return fmt.Sprintf("%s <%s>", id.Name, id.Email)
+}
+
+// Parse reads "Name <email>"
+func Parse(s string) (Identity, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || addr.Name == "" {
+		return Identity{}, fmt.Errorf("invalid author %q: expected \"Name <email>\"", s)
+	}
+	return Identity{Name: addr.Name, Email: addr.Address}, nil
+}
+
+// Validate checks that name and email are usable
+func Validate(name, email string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	return nil
+}
+
+// Current returns the configured identity of repoPath. It returns ErrUnset
+// if neither a profile nor user.name and user.email are set.
+func Current(repoPath string) (Identity, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return Identity{}, err
+	}
+	return fromConfig(cfg)
+}
+
+func fromConfig(cfg *config.Config) (Identity, error) {
+	if e, ok := cfg.Lookup("user.identity"); ok && e.Value != "" {
+		p, err := profile(cfg, e.Value)
+		if err != nil {
+			return Identity{}, err
+		}
+		p.Source = e.Scope
+		return p, nil
+	}
+	name, nok := cfg.Lookup("user.name")
+	email, eok := cfg.Lookup("user.email")
+	if !nok || !eok || name.Value == "" || email.Value == "" {
+		return Identity{}, ErrUnset
+	}
+	source := name.Scope
+	if email.Scope != source {
+		source = name.Scope + "," + email.Scope
+	}
+	return Identity{Name: name.Value, Email: email.Value, Source: source}, nil
+}
+
+func profile(cfg *config.Config, name string) (Identity, error) {
+	n, _ := cfg.Get("identity." + name + ".name")
+	e, _ := cfg.Get("identity." + name + ".email")
+	if n == "" || e == "" {
+		return Identity{}, fmt.Errorf("identity profile %q is not configured (user.identity)", name)
+	}
+	return Identity{Name: n, Email: e, Profile: name}, nil
+}
+
+// ForCommit returns the author of a new commit: author if given ("Name
+// <email>"), else the configured identity. With no identity configured it
+// fails unless user.requireIdentity is false, in which case Fallback is used.
+func ForCommit(repoPath, author string) (Identity, error) {
+	if author != "" {
+		id, err := Parse(author)
+		if err != nil {
+			return Identity{}, err
+		}
+		id.Source = "--author"
+		return id, nil
+	}
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return Identity{}, err
+	}
+	id, err := fromConfig(cfg)
+	if !errors.Is(err, ErrUnset) {
+		return id, err
+	}
+	require, cerr := cfg.Bool("user.requireIdentity")
+	if cerr != nil {
+		return Identity{}, cerr
+	}
+	if require {
+		return Identity{}, err
+	}
+	id = Fallback
+	id.Source = "fallback"
+	return id, nil
+}
+
+// Profiles lists the configured identity profiles
+func Profiles(repoPath string) ([]Identity, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, e := range cfg.List() {
+		parts := strings.Split(e.Key, ".")
+		if len(parts) == 3 && parts[0] == "identity" {
+			names[parts[1]] = true
+		}
+	}
+	var out []Identity
+	for n := range names {
+		p, _ := profile(cfg, n)
+		p.Profile = n
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Profile < out[j].Profile })
+	return out, nil
+}
+
+// SaveProfile stores a named profile in the given config layer
+func SaveProfile(repoPath string, scope config.Scope, name string, id Identity) error {
+	if err := Validate(id.Name, id.Email); err != nil {
+		return err
+	}
+	if name == "" || strings.ContainsAny(name, ". \t") {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	if err := config.Set(repoPath, scope, "identity."+name+".name", id.Name); err != nil {
+		return err
+	}
+	return config.Set(repoPath, scope, "identity."+name+".email", id.Email)
+}
+
+// Use selects profile for the repository
+func Use(repoPath, name string) error {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return err
+	}
+	if _, err := profile(cfg, name); err != nil {
+		return err
+	}
+	return config.Set(repoPath, config.ScopeRepo, "user.identity", name)
+}
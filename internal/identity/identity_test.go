@@ -0,0 +1,70 @@
+package identity
+
+import (
+	"evo/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setup(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(dir, "system.toml"))
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(dir, "global.toml"))
+	repo := filepath.Join(dir, "repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(repo, ".evo"), 0755))
+	return repo
+}
+
+func TestForCommit(t *testing.T) {
+	repo := setup(t)
+
+	_, err := ForCommit(repo, "")
+	assert.ErrorIs(t, err, ErrUnset)
+
+	id, err := ForCommit(repo, "Ann Author <ann@example.com>")
+	assert.NoError(t, err)
+	assert.Equal(t, "Ann Author", id.Name)
+	assert.Equal(t, "ann@example.com", id.Email)
+	_, err = ForCommit(repo, "ann@example.com")
+	assert.Error(t, err)
+
+	assert.NoError(t, config.Set(repo, config.ScopeRepo, "user.requireIdentity", "false"))
+	id, err = ForCommit(repo, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Fallback.Name, id.Name)
+
+	assert.NoError(t, config.Set(repo, config.ScopeGlobal, "user.name", "Global"))
+	assert.NoError(t, config.Set(repo, config.ScopeGlobal, "user.email", "g@example.com"))
+	id, err = ForCommit(repo, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Global <g@example.com>", id.String())
+	assert.Equal(t, "global", id.Source)
+}
+
+func TestProfiles(t *testing.T) {
+	repo := setup(t)
+	assert.NoError(t, SaveProfile(repo, config.ScopeGlobal, "work", Identity{Name: "Worker", Email: "w@corp.example"}))
+	assert.NoError(t, SaveProfile(repo, config.ScopeGlobal, "home", Identity{Name: "Homer", Email: "h@home.example"}))
+	assert.Error(t, SaveProfile(repo, config.ScopeGlobal, "bad", Identity{Name: "X", Email: "not-an-email"}))
+	assert.Error(t, Use(repo, "missing"))
+
+	ps, err := Profiles(repo)
+	assert.NoError(t, err)
+	assert.Len(t, ps, 2)
+	assert.Equal(t, "home", ps[0].Profile)
+
+	assert.NoError(t, Use(repo, "work"))
+	id, err := Current(repo)
+	assert.NoError(t, err)
+	assert.Equal(t, "Worker", id.Name)
+	assert.Equal(t, "work", id.Profile)
+	assert.Equal(t, "repo", id.Source)
+
+	// the profile wins over user.name/user.email
+	assert.NoError(t, config.Set(repo, config.ScopeRepo, "user.name", "Plain"))
+	id, _ = Current(repo)
+	assert.Equal(t, "Worker", id.Name)
+}
@@ -0,0 +1,28 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzIsIgnored matches arbitrary paths against arbitrary .evo-ignore
+// content: malformed patterns match nothing rather than panic, and matching
+// stays fast for patterns full of wildcards
+func FuzzIsIgnored(f *testing.F) {
+	f.Add("*.log\nbuild/\n!keep.log\n", "build/out/keep.log")
+	f.Add("**/a/**/b/**/c", "a/x/b/y/c/z")
+	f.Add("[", "a")
+	f.Add("{a,b}/**/*.{c,d}", "b/x/y.d")
+	f.Fuzz(func(t *testing.T, content, path string) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ".evo-ignore"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		il, err := LoadIgnoreFile(dir)
+		if err != nil {
+			return
+		}
+		il.IsIgnored(path)
+	})
+}
@@ -171,6 +171,26 @@ func TestIsIgnored(t *testing.T) {
 				"../repo/temp/file.txt": true,
 			},
 		},
+		{
+			name: "Unusual characters",
+			patterns: []string{
+				"*.log",
+				"dir with space/",
+				`\[draft\]*`,
+			},
+			paths: map[string]bool{
+				"a b.log":            true,
+				"colon:id.log":       true,
+				"new\nline.log":      true,
+				"latin1-\xe9.log":    true,
+				"dir with space/a:b": true,
+				"[draft] notes.txt":  true,
+				"d notes.txt":        false,
+				"new\nline.txt":      false,
+				"\"quoted\".txt":     false,
+				"dir with space.txt": false,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,88 @@
+package index
+
+import (
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/textenc"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// .evo/encodings records, as lines "<fileID> <encoding>", the encoding of
+// each file not in plain UTF-8, as ingest last found it. Op logs hold the
+// decoded text; writing a file out encodes it back.
+
+func encodingsPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "encodings")
+}
+
+// LoadEncodings returns the recorded encodings by fileID. Files missing are
+// in UTF-8.
+func LoadEncodings(repoPath string) (map[string]textenc.Encoding, error) {
+	out := make(map[string]textenc.Encoding)
+	path := encodingsPath(repoPath)
+	err := readLines(path, func(n int, line string) error {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		fid, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return &FormatError{Path: path, Line: n, Reason: "expected \"<fileID> <encoding>\""}
+		}
+		e, err := textenc.Parse(name)
+		if err != nil {
+			return &FormatError{Path: path, Line: n, Reason: err.Error()}
+		}
+		if e != textenc.UTF8 {
+			out[fid] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveEncodings replaces the recorded encodings, dropping UTF-8 entries
+func SaveEncodings(repoPath string, encs map[string]textenc.Encoding) error {
+	ids := make([]string, 0, len(encs))
+	for fid, e := range encs {
+		if e != textenc.UTF8 && e != "" {
+			ids = append(ids, fid)
+		}
+	}
+	path := encodingsPath(repoPath)
+	if len(ids) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, fid := range ids {
+		fmt.Fprintf(&b, "%s %s\n", fid, encs[fid])
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, path)
+}
+
+// EncodingOf returns the recorded encoding of a file
+func EncodingOf(repoPath, fileID string) (textenc.Encoding, error) {
+	encs, err := LoadEncodings(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if e, ok := encs[fileID]; ok {
+		return e, nil
+	}
+	return textenc.UTF8, nil
+}
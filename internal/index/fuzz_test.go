@@ -0,0 +1,61 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// FuzzLoadIndex reads arbitrary bytes as .evo/index: it may fail, with a
+// *FormatError for lines it can't read, but not panic, and what it loads is
+// saved and loaded back unchanged
+func FuzzLoadIndex(f *testing.F) {
+	f.Add([]byte("0f8fad5b-d9cb-469f-a165-70867728950e a.txt\n"))
+	f.Add([]byte("id1 dir/with space .txt\r\nid2  lead\n\nfile1.txt:id1\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rp := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(rp, ".evo", "index"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		p2id, _, err := LoadIndex(rp)
+		if err != nil {
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Fatalf("unstructured error: %v", err)
+			}
+			return
+		}
+		if err := SaveIndex(rp, p2id); err != nil {
+			t.Fatal(err)
+		}
+		again, _, err := LoadIndex(rp)
+		if err != nil {
+			t.Fatalf("saved index does not load: %v", err)
+		}
+		if !reflect.DeepEqual(p2id, again) {
+			t.Fatalf("index changed in a round trip:\n%q\n%q", p2id, again)
+		}
+	})
+}
+
+func TestLoadIndexLongLine(t *testing.T) {
+	rp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := "id1 a.txt\nid2 " + strings.Repeat("x", maxLine) + "\n"
+	if err := os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := LoadIndex(rp)
+	var fe *FormatError
+	if !errors.As(err, &fe) || fe.Line != 2 {
+		t.Fatalf("expected a FormatError for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package index
+
+import (
+	"crypto/sha256"
+	"evo/internal/platform"
+	"evo/internal/profile"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// .evo/hashes/<stream> remembers, per file, the content last known to match
+// the stream's op log, as lines "<fileID> <op log size> <sha256 of content>".
+// A file whose content and op log are both unchanged since needs no ingest;
+// a log that grew or was truncated (merge, undo) invalidates the entry.
+
+// Detached names the hashes of the files a detached checkout wrote, which
+// the working tree is compared with instead of its stream's while detached
+const Detached = ".detached"
+
+// Hash is the remembered state of one file
+type Hash struct {
+	LogSize int64
+	Sum     string
+}
+
+// HashContent returns the Sum of file content
+func HashContent(data []byte) string {
+	defer profile.Track(profile.Hash)()
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// GranularSum tags the Sum of a file tracked at a granularity other than
+// lines, so changing a file's granularity invalidates its entry
+func GranularSum(sum, granularity string) string {
+	if granularity == "" || granularity == "line" {
+		return sum
+	}
+	return sum + ":" + granularity
+}
+
+// Content returns the SHA-256 of the file's content, without the tag
+// GranularSum adds; it is the hash the content is stored under in blobs
+func (h Hash) Content() string {
+	sum, _, _ := strings.Cut(h.Sum, ":")
+	return sum
+}
+
+func hashesPath(repoPath, stream string) string {
+	return filepath.Join(repo.Dir(repoPath), "hashes", stream)
+}
+
+// LoadHashes returns the remembered file hashes of a stream by fileID
+func LoadHashes(repoPath, stream string) (map[string]Hash, error) {
+	out := make(map[string]Hash)
+	err := readLines(hashesPath(repoPath, stream), func(_ int, line string) error {
+		// a damaged entry only costs re-reading its file
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		out[parts[0]] = Hash{LogSize: size, Sum: parts[2]}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveHashes replaces the remembered file hashes of a stream
+func SaveHashes(repoPath, stream string, hashes map[string]Hash) error {
+	path := hashesPath(repoPath, stream)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(hashes))
+	for fid := range hashes {
+		ids = append(ids, fid)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, fid := range ids {
+		fmt.Fprintf(&b, "%s %d %s\n", fid, hashes[fid].LogSize, hashes[fid].Sum)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, path)
+}
+
+// SetHash remembers the hash of one file; a zero Hash forgets it
+func SetHash(repoPath, stream, fileID string, h Hash) error {
+	hashes, err := LoadHashes(repoPath, stream)
+	if err != nil {
+		return err
+	}
+	if h == (Hash{}) {
+		delete(hashes, fileID)
+	} else {
+		hashes[fileID] = h
+	}
+	return SaveHashes(repoPath, stream, hashes)
+}
@@ -1,8 +1,10 @@
 package index
 
 import (
-	"bufio"
 	"errors"
+	"evo/internal/platform"
+	"evo/internal/profile"
+	"evo/internal/repo"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,49 +13,47 @@ import (
 	"github.com/google/uuid"
 )
 
-// The .evo/index is lines: "<fileID> <path>"
+// The .evo/index is lines: "<fileID> <path>", paths slash-separated on
+// every platform and quoted as QuotePath does, so any name a filesystem
+// allows fits on one line
+
+// caseInsensitive reports whether the repository's filesystem ignores case
+var caseInsensitive = func(repoPath string) bool {
+	return platform.CaseInsensitive(repo.Dir(repoPath))
+}
 
 func LoadIndex(repoPath string) (map[string]string, map[string]string, error) {
 	// path->fileID, fileID->path
 	path2id := make(map[string]string)
 	id2path := make(map[string]string)
-	idxPath := filepath.Join(repoPath, ".evo", "index")
-	f, err := os.Open(idxPath)
-	if os.IsNotExist(err) {
-		return path2id, id2path, nil
-	}
-	if err != nil {
-		return path2id, id2path, err
-	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" {
-			continue
-		}
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) == 2 {
-			fid := parts[0]
-			p := parts[1]
+	idxPath := filepath.Join(repo.Dir(repoPath), "index")
+	err := readLines(idxPath, func(_ int, line string) error {
+		// paths keep their spaces; lines without one are of an old format
+		if fid, p, ok := strings.Cut(line, " "); ok {
+			p = unquotePath(p)
 			path2id[p] = fid
 			id2path[fid] = p
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 	return path2id, id2path, nil
 }
 
+// SaveIndex replaces the index, remembering its paths in .evo/paths
 func SaveIndex(repoPath string, path2id map[string]string) error {
-	idxPath := filepath.Join(repoPath, ".evo", "index")
+	idxPath := filepath.Join(repo.Dir(repoPath), "index")
 	f, err := os.OpenFile(idxPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 	for p, fid := range path2id {
-		fmt.Fprintf(f, "%s %s\n", fid, p)
+		fmt.Fprintf(f, "%s %s\n", fid, QuotePath(p))
 	}
-	return nil
+	return rememberPaths(repoPath, path2id)
 }
 
 // UpdateIndex => scans working dir, assigns stable fileIDs, removes missing files
@@ -62,7 +62,12 @@ func UpdateIndex(repoPath string) error {
 	if err != nil {
 		return err
 	}
+	untracked, err := LoadUntracked(repoPath)
+	if err != nil {
+		return err
+	}
 	var working []string
+	done := profile.Track(profile.Walk)
 	filepath.Walk(repoPath, func(path string, info os.FileInfo, e error) error {
 		if e != nil {
 			return nil
@@ -70,30 +75,62 @@ func UpdateIndex(repoPath string) error {
 		if !info.IsDir() {
 			rel, _ := filepath.Rel(repoPath, path)
 			if !strings.HasPrefix(rel, ".evo") {
-				working = append(working, rel)
+				working = append(working, filepath.ToSlash(rel))
 			}
 		}
 		return nil
 	})
+	done()
+	// untracked paths whose file is gone may be tracked again
+	if len(untracked) > 0 {
+		exists := make(map[string]bool, len(working))
+		for _, w := range working {
+			exists[w] = true
+		}
+		kept := make(map[string]bool)
+		for p := range untracked {
+			if exists[p] {
+				kept[p] = true
+			}
+		}
+		if len(kept) != len(untracked) {
+			if err := saveUntracked(repoPath, kept); err != nil {
+				return err
+			}
+		}
+		untracked = kept
+	}
+	present := make(map[string]bool, len(working))
+	for _, w := range working {
+		present[w] = true
+	}
+	// on a filesystem ignoring case, a file renamed only in case keeps its ID
+	fold := len(p2id) > 0 && caseInsensitive(repoPath)
 	// detect new files
 	for _, w := range working {
-		if _, ok := p2id[w]; !ok {
-			// assign new fileID
-			fid := uuid.New().String()
-			p2id[w] = fid
-			id2p[fid] = w
+		if untracked[w] {
+			continue
+		}
+		if _, ok := p2id[w]; ok {
+			continue
+		}
+		if fold {
+			if p, ok := foldedPath(p2id, w); ok && !present[p] {
+				fid := p2id[p]
+				delete(p2id, p)
+				p2id[w] = fid
+				id2p[fid] = w
+				continue
+			}
 		}
+		// assign new fileID
+		fid := uuid.New().String()
+		p2id[w] = fid
+		id2p[fid] = w
 	}
 	// detect removed
 	for p, fid := range p2id {
-		found := false
-		for _, w := range working {
-			if w == p {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if !present[p] {
 			delete(p2id, p)
 			delete(id2p, fid)
 		}
@@ -107,9 +144,26 @@ func LookupFileID(repoPath, relPath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	relPath = filepath.ToSlash(relPath)
 	fid, ok := p2id[relPath]
+	if !ok && caseInsensitive(repoPath) {
+		var p string
+		if p, ok = foldedPath(p2id, relPath); ok {
+			fid = p2id[p]
+		}
+	}
 	if !ok {
 		return "", errors.New("file not tracked in index: " + relPath)
 	}
 	return fid, nil
 }
+
+// foldedPath finds the indexed path equal to p under case folding
+func foldedPath(p2id map[string]string, p string) (string, bool) {
+	for q := range p2id {
+		if strings.EqualFold(q, p) {
+			return q, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,195 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdateIndexCaseRename(t *testing.T) {
+	rp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(rp, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(rel string) {
+		if err := os.WriteFile(filepath.Join(rp, filepath.FromSlash(rel)), []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("docs/README.md")
+	if err := UpdateIndex(rp); err != nil {
+		t.Fatal(err)
+	}
+	fid, err := LookupFileID(rp, "docs/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as a filesystem ignoring case would show a rename only in case
+	defer func(f func(string) bool) { caseInsensitive = f }(caseInsensitive)
+	caseInsensitive = func(string) bool { return true }
+	if err := os.Remove(filepath.Join(rp, "docs", "README.md")); err != nil {
+		t.Fatal(err)
+	}
+	write("docs/readme.md")
+	if err := UpdateIndex(rp); err != nil {
+		t.Fatal(err)
+	}
+	p2id, _, err := LoadIndex(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p2id) != 1 || p2id["docs/readme.md"] != fid {
+		t.Fatalf("expected the rename to keep file ID %s, got %v", fid, p2id)
+	}
+	if got, err := LookupFileID(rp, filepath.Join("DOCS", "Readme.md")); err != nil || got != fid {
+		t.Fatalf("lookup ignoring case: %s, %v", got, err)
+	}
+
+	// where case counts, the same names are different files
+	caseInsensitive = func(string) bool { return false }
+	if _, err := LookupFileID(rp, "docs/README.md"); err == nil {
+		t.Fatal("expected a case-sensitive lookup to fail")
+	}
+}
+
+// hostilePaths are names any Unix filesystem allows that line-based files
+// have trouble with
+var hostilePaths = []string{
+	"with space.txt",
+	"colon:id.txt",
+	"new\nline.txt",
+	"cr\r.txt",
+	"tab\there.txt",
+	"\"quoted\".txt",
+	`back\slash.txt`,
+	"latin1-\xe9.txt",
+	"ünïcode.txt",
+	" lead and trail ",
+	"dir with space/inner:file",
+}
+
+func TestHostilePaths(t *testing.T) {
+	rp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p2id := make(map[string]string)
+	for i, p := range hostilePaths {
+		p2id[p] = fmt.Sprintf("id%d", i)
+	}
+	if err := SaveIndex(rp, p2id); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(rp, ".evo", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != len(hostilePaths) {
+		t.Fatalf("expected one line per path, got %d:\n%s", n, data)
+	}
+	got, id2p, err := LoadIndex(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p2id) || id2p["id2"] != "new\nline.txt" {
+		t.Fatalf("index changed in a round trip:\n%q\n%q", p2id, got)
+	}
+
+	if err := Untrack(rp, hostilePaths...); err != nil {
+		t.Fatal(err)
+	}
+	untracked, err := LoadUntracked(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(untracked) != len(hostilePaths) || !untracked["cr\r.txt"] || !untracked["latin1-\xe9.txt"] {
+		t.Fatalf("untracked paths changed in a round trip: %v", untracked)
+	}
+
+	// indexes of older versions wrote paths as they were
+	legacy := "id1 \"odd\n" + "id2 plain name\n"
+	if err := os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err = LoadIndex(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["\"odd"] != "id1" || got["plain name"] != "id2" {
+		t.Fatalf("legacy index misread: %q", got)
+	}
+}
+
+func TestUpdateIndexHostilePaths(t *testing.T) {
+	rp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range hostilePaths {
+		abs := filepath.Join(rp, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateIndex(rp); err != nil {
+		t.Fatal(err)
+	}
+	p2id, _, err := LoadIndex(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p2id) != len(hostilePaths) {
+		t.Fatalf("expected %d tracked files, got %q", len(hostilePaths), p2id)
+	}
+	for _, p := range hostilePaths {
+		if _, err := LookupFileID(rp, p); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLearnPaths(t *testing.T) {
+	rp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rp, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	own, pulled := "6f1c3e0a-3b9e-4d3c-9a51-0c6b1e1f2a01", "6f1c3e0a-3b9e-4d3c-9a51-0c6b1e1f2a02"
+	if err := SaveIndex(rp, map[string]string{"mine.txt": own}); err != nil {
+		t.Fatal(err)
+	}
+	// a path this repository has is kept; hostile paths and IDs are ignored
+	err := LearnPaths(rp, map[string]string{
+		own:                                    "theirs.txt",
+		pulled:                                 "dir/new.txt",
+		"6f1c3e0a-3b9e-4d3c-9a51-0c6b1e1f2a03": "../escaped.txt",
+		"6f1c3e0a-3b9e-4d3c-9a51-0c6b1e1f2a04": ".evo/HEAD",
+		"not-an-id":                            "odd.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, err := KnownPaths(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{own: "mine.txt", pulled: "dir/new.txt"}; !reflect.DeepEqual(known, want) {
+		t.Fatalf("expected %q, got %q", want, known)
+	}
+
+	if err := ForgetPaths(rp, pulled); err != nil {
+		t.Fatal(err)
+	}
+	if known, err = KnownPaths(rp); err != nil || len(known) != 1 {
+		t.Fatalf("expected the pulled path forgotten, got %q, %v", known, err)
+	}
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxLine bounds the lines of the files of this package; longer ones are
+// damage, not paths
+const maxLine = 1 << 20
+
+// FormatError reports a line of a file under .evo that can't be read
+type FormatError struct {
+	Path   string
+	Line   int
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Reason)
+}
+
+// readLines calls fn with each line of the file at path, without its line
+// break, and its line number. A missing file has no lines.
+func readLines(path string, fn func(n int, line string) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxLine)
+	n := 0
+	for sc.Scan() {
+		n++
+		if err := fn(n, strings.TrimSuffix(sc.Text(), "\r")); err != nil {
+			return err
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return &FormatError{Path: path, Line: n + 1, Reason: fmt.Sprintf("line longer than %d bytes", maxLine)}
+	}
+	return sc.Err()
+}
+
+// QuotePath returns p as the files of this package and status output write
+// it: unchanged, unless it has line breaks or other control characters, isn't
+// valid UTF-8 or starts with a double quote, in which case it is a Go quoted
+// string, with invalid bytes as \x escapes
+func QuotePath(p string) string {
+	if p == "" || p[0] == '"' || !utf8.ValidString(p) || strings.IndexFunc(p, unicode.IsControl) >= 0 {
+		return strconv.Quote(p)
+	}
+	return p
+}
+
+// unquotePath reverses QuotePath. A quoted-looking path that doesn't unquote
+// was written before paths were quoted and is taken as it is.
+func unquotePath(s string) string {
+	if len(s) > 1 && s[0] == '"' {
+		if p, err := strconv.Unquote(s); err == nil {
+			return p
+		}
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package index
+
+import (
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// .evo/paths remembers the path of every file the index ever held, in the
+// index's format. The index follows the working tree of the checked-out
+// stream and drops files it lacks; files of other streams keep their paths
+// here, for checkouts and for naming files in history.
+
+func pathsPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "paths")
+}
+
+// KnownPaths returns the path of every file the index holds or ever held,
+// by file ID, the index's own taking precedence
+func KnownPaths(repoPath string) (map[string]string, error) {
+	id2path, err := loadPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	_, current, err := LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for fid, p := range current {
+		id2path[fid] = p
+	}
+	return id2path, nil
+}
+
+// LearnPaths remembers the paths another repository gives files this one
+// has no path for, as pushes and pulls send them: ops name files by ID
+// alone. Paths outside the working tree or in .evo, and IDs that aren't file
+// IDs, are ignored.
+func LearnPaths(repoPath string, id2path map[string]string) error {
+	if len(id2path) == 0 {
+		return nil
+	}
+	known, err := KnownPaths(repoPath)
+	if err != nil {
+		return err
+	}
+	stored, err := loadPaths(repoPath)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for fid, p := range id2path {
+		if _, ok := known[fid]; ok || !localPath(p) {
+			continue
+		}
+		if id, err := uuid.Parse(fid); err != nil || id.String() != fid {
+			continue
+		}
+		stored[fid] = p
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return savePaths(repoPath, stored)
+}
+
+// localPath reports whether a slash-separated path names a file of the
+// working tree
+func localPath(p string) bool {
+	return filepath.IsLocal(filepath.FromSlash(p)) && p != repo.EvoDir && !strings.HasPrefix(p, repo.EvoDir+"/")
+}
+
+// ForgetPaths drops the remembered paths of files, as when they are purged
+func ForgetPaths(repoPath string, fileIDs ...string) error {
+	known, err := loadPaths(repoPath)
+	if err != nil {
+		return err
+	}
+	n := len(known)
+	for _, fid := range fileIDs {
+		delete(known, fid)
+	}
+	if len(known) == n {
+		return nil
+	}
+	return savePaths(repoPath, known)
+}
+
+// rememberPaths adds the paths of an index to .evo/paths, rewriting it only
+// when one is new or moved
+func rememberPaths(repoPath string, path2id map[string]string) error {
+	known, err := loadPaths(repoPath)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for p, fid := range path2id {
+		if known[fid] != p {
+			known[fid] = p
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return savePaths(repoPath, known)
+}
+
+func loadPaths(repoPath string) (map[string]string, error) {
+	known := make(map[string]string)
+	err := readLines(pathsPath(repoPath), func(_ int, line string) error {
+		if fid, p, ok := strings.Cut(line, " "); ok {
+			known[fid] = unquotePath(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return known, nil
+}
+
+func savePaths(repoPath string, known map[string]string) error {
+	ids := make([]string, 0, len(known))
+	for fid := range known {
+		ids = append(ids, fid)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, fid := range ids {
+		fmt.Fprintf(&b, "%s %s\n", fid, QuotePath(known[fid]))
+	}
+	tmp := pathsPath(repoPath) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, pathsPath(repoPath))
+}
@@ -0,0 +1,59 @@
+package index
+
+import (
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// .evo/untracked lists, one per line and quoted as in the index, paths removed from the index while
+// their file stays in the working tree (evo rm --cached). UpdateIndex
+// doesn't track them again until the file is gone.
+
+func untrackedPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "untracked")
+}
+
+// LoadUntracked returns the paths kept out of the index
+func LoadUntracked(repoPath string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	err := readLines(untrackedPath(repoPath), func(_ int, p string) error {
+		if p != "" {
+			out[unquotePath(p)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Untrack keeps paths out of the index while their files exist
+func Untrack(repoPath string, paths ...string) error {
+	untracked, err := LoadUntracked(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		untracked[p] = true
+	}
+	return saveUntracked(repoPath, untracked)
+}
+
+func saveUntracked(repoPath string, untracked map[string]bool) error {
+	if len(untracked) == 0 {
+		if err := os.Remove(untrackedPath(repoPath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	lines := make([]string, 0, len(untracked))
+	for p := range untracked {
+		lines = append(lines, QuotePath(p))
+	}
+	sort.Strings(lines)
+	return os.WriteFile(untrackedPath(repoPath), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
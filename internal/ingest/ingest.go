@@ -0,0 +1,451 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"evo/internal/blobs"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/diff"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/merge"
+	"evo/internal/node"
+	"evo/internal/ops"
+	"evo/internal/profile"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"evo/internal/textenc"
+	"evo/internal/util"
+	"evo/internal/validate"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("ingest")
+
+// IngestLocalChanges checks each file in the working directory, handles large-file threshold, stable fileID, then line CRDT logic.
+// It stops when ctx is cancelled; then, or if any file fails, the op logs
+// are cut back to what they held before, so nothing of the run is kept.
+// Files checked are reported to the progress reporter of ctx.
+func IngestLocalChanges(ctx context.Context, repoPath, stream string) ([]string, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	files, err := util.ListAllFiles(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	hashes, err := index.LoadHashes(repoPath, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file hashes: %w", err)
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attributes: %w", err)
+	}
+	seen := make(map[string]index.Hash)
+	found := make(map[string]textenc.Encoding)
+	marks := make(map[string]int64) // op logs written, to put back on failure
+	var changed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	chWork := make(chan string, len(files))
+	chErr := make(chan error, 8)
+
+	n := 0
+	for _, f := range files {
+		if !strings.HasPrefix(f, ".evo") {
+			chWork <- f
+			n++
+		}
+	}
+	close(chWork)
+	pr := progress.From(ctx)
+	pr.Start("Ingesting", int64(n))
+	defer pr.Done()
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range chWork {
+				if err := ctx.Err(); err != nil {
+					chErr <- err
+					return
+				}
+				pr.Add(1)
+				abs := filepath.Join(repoPath, rel)
+				fi, errStat := os.Stat(abs)
+				if errStat != nil || fi.IsDir() {
+					continue
+				}
+				fileID, err := index.LookupFileID(repoPath, rel)
+				if err != nil {
+					// not tracked => skip
+					continue
+				}
+				opsFile := filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin")
+				mark := ops.Mark(opsFile)
+				mu.Lock()
+				marks[opsFile] = mark
+				mu.Unlock()
+				ok, h, enc, e2 := processFile(repoPath, stream, fileID, abs, fi.Size(), attrs.GranularityFor(rel), self, hashes[fileID])
+				if e2 != nil {
+					chErr <- e2
+					return
+				}
+				mu.Lock()
+				seen[fileID] = h
+				if enc != "" {
+					found[fileID] = enc
+				}
+				if ok {
+					changed = append(changed, rel)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(chErr)
+	for e := range chErr {
+		if e != nil {
+			return nil, rollback(marks, e)
+		}
+	}
+	if err := self.Save(); err != nil {
+		return nil, rollback(marks, err)
+	}
+	for fid, h := range seen {
+		hashes[fid] = h
+	}
+	if err := index.SaveHashes(repoPath, stream, hashes); err != nil {
+		return nil, rollback(marks, fmt.Errorf("failed to save file hashes: %w", err))
+	}
+	if err := recordEncodings(repoPath, found); err != nil {
+		return nil, fmt.Errorf("failed to save file encodings: %w", err)
+	}
+	return changed, nil
+}
+
+// rollback cuts the op logs an ingest wrote back to their marks and returns
+// why it failed
+func rollback(marks map[string]int64, err error) error {
+	if rerr := ops.Restore(marks); rerr != nil {
+		return errors.Join(err, fmt.Errorf("failed to roll back op logs: %w", rerr))
+	}
+	logger.Debug("rolled back ingest", "logs", len(marks), "err", err)
+	return err
+}
+
+// recordEncodings remembers the encodings found for files read, saving them
+// only when one changed
+func recordEncodings(repoPath string, found map[string]textenc.Encoding) error {
+	if len(found) == 0 {
+		return nil
+	}
+	encs, err := index.LoadEncodings(repoPath)
+	if err != nil {
+		return err
+	}
+	dirty := false
+	for fid, e := range found {
+		old, ok := encs[fid]
+		if !ok {
+			old = textenc.UTF8
+		}
+		if old != e {
+			encs[fid] = e
+			dirty = true
+		}
+	}
+	if !dirty {
+		return nil
+	}
+	return index.SaveEncodings(repoPath, encs)
+}
+
+// processFile turns the differences between a file and its op log into ops.
+// Files whose content and op log match last, the hash recorded when they
+// were last in sync, are skipped without replaying the log. Text is decoded
+// from the encoding detected for it. It returns the hash to remember for the
+// file and the encoding found, empty when the file wasn't decoded.
+func processFile(repoPath, stream, fileID, absPath string, fsize int64, g crdt.Granularity, self *node.Node, last index.Hash) (bool, index.Hash, textenc.Encoding, error) {
+	opsFile := filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin")
+	large := fsize > readLargeThreshold(repoPath)
+	var data []byte
+	var sum string
+	var err error
+	if large {
+		sum, err = hashFile(absPath)
+	} else {
+		done := profile.Track(profile.IO)
+		data, err = os.ReadFile(absPath)
+		done()
+		sum = index.HashContent(data)
+	}
+	if err != nil {
+		return false, last, "", err
+	}
+	sum = index.GranularSum(sum, string(g))
+	if sum == last.Sum && ops.LogSize(opsFile) == last.LogSize {
+		return false, last, "", nil
+	}
+
+	doc, err := materialize.Load(repoPath, stream, fileID)
+	if err != nil {
+		return false, last, "", err
+	}
+	self.Clock.Observe(doc.Lamport)
+	vector := doc.Knowledge
+	// the file came from the index, so only its stream and lines are checked
+	check, err := validate.New(repoPath, stream, validate.Options{Stream: stream, Lines: true})
+	if err != nil {
+		return false, last, "", err
+	}
+
+	var changed bool
+	var enc textenc.Encoding
+	if large {
+		// large file => store stub
+		changed, err = storeLargeFile(repoPath, stream, fileID, absPath, doc, vector, opsFile, self, check)
+	} else {
+		var text string
+		text, enc = textenc.Text(data)
+		changed, err = diffLines(stream, fileID, []byte(text), g, doc, vector, opsFile, self, check)
+		if err == nil {
+			// the base status and diff compare the file with from now on
+			_, err = blobs.Put(repoPath, data)
+		}
+	}
+	if err != nil {
+		return false, last, "", err
+	}
+	return changed, index.Hash{LogSize: ops.LogSize(opsFile), Sum: sum}, enc, nil
+}
+
+// diffLines appends the ops that turn the document into data: inserts and
+// deletes for added and removed lines, updates for lines replaced in place.
+// Character and word files are diffed the same way, fragment by fragment.
+func diffLines(stream, fileID string, data []byte, g crdt.Granularity, doc *materialize.Document, vector crdt.VectorClock, opsFile string, self *node.Node, check *validate.Validator) (bool, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	fragment := g.Fragments()
+	var diskLines []string
+	if fragment {
+		diskLines = g.Split(text)
+	} else {
+		diskLines = strings.Split(text, "\n")
+	}
+	docLines, lineIDs, same := elementsOf(doc, fragment)
+	if same && eqLines(docLines, diskLines) {
+		return false, nil
+	}
+	emit := emitter(stream, fileID, vector, opsFile, self, check)
+	if !same {
+		// the file's granularity changed => replace every element
+		for _, id := range lineIDs {
+			if err := emit(crdt.Operation{Type: crdt.OpDelete, LineID: id}); err != nil {
+				return false, err
+			}
+		}
+		docLines, lineIDs = nil, nil
+	}
+
+	// origin is the last line before the current position that stays in the
+	// file; inserted lines are anchored to it, each new line to the one before
+	origin := crdt.DocumentStart
+	edits := diff.Lines(docLines, diskLines)
+	for i := 0; i < len(edits); {
+		if edits[i].Op == diff.Equal {
+			origin = lineIDs[edits[i].A]
+			i++
+			continue
+		}
+		var dels, ins []int
+		for ; i < len(edits) && edits[i].Op != diff.Equal; i++ {
+			if edits[i].Op == diff.Delete {
+				dels = append(dels, edits[i].A)
+			} else {
+				ins = append(ins, edits[i].B)
+			}
+		}
+		// lines replaced one for one are updates, keeping their identity
+		n := min(len(dels), len(ins))
+		for j := 0; j < n; j++ {
+			id := lineIDs[dels[j]]
+			if err := emit(crdt.Operation{Type: crdt.OpUpdate, LineID: id, Content: diskLines[ins[j]], Fragment: fragment}); err != nil {
+				return false, err
+			}
+			origin = id
+		}
+		for _, a := range dels[n:] {
+			if err := emit(crdt.Operation{Type: crdt.OpDelete, LineID: lineIDs[a], Fragment: fragment}); err != nil {
+				return false, err
+			}
+		}
+		for _, b := range ins[n:] {
+			id := uuid.New()
+			if err := emit(crdt.Operation{Type: crdt.OpInsert, LineID: id, OriginLineID: origin, Content: diskLines[b], Fragment: fragment}); err != nil {
+				return false, err
+			}
+			origin = id
+		}
+	}
+	return true, nil
+}
+
+// emitter returns a function appending ops for a file to its log, stamped
+// by the node once check passes them
+func emitter(stream, fileID string, vector crdt.VectorClock, opsFile string, self *node.Node, check *validate.Validator) func(crdt.Operation) error {
+	return func(op crdt.Operation) error {
+		op.Lamport = self.Tick()
+		op.NodeID = self.ID
+		op.FileID = parseUUID(fileID)
+		op.Stream = stream
+		op.Timestamp = time.Now()
+		if err := check.Check(op); err != nil {
+			return err
+		}
+		vector.Stamp(&op)
+		return ops.AppendRef(opsFile, op)
+	}
+}
+
+// RemoveFile deletes every line of a file from the stream's log, which
+// materializing reads as the file's removal. Ingest only sees files that
+// exist, so removals made by other means than evo are recorded this way.
+func RemoveFile(repoPath, stream, fileID string) (bool, error) {
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	doc, err := materialize.Load(repoPath, stream, fileID)
+	if err != nil {
+		return false, err
+	}
+	self.Clock.Observe(doc.Lamport)
+	opsFile := filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin")
+	// the file may be gone from the index already, so it isn't checked
+	check, err := validate.New(repoPath, stream, validate.Options{Stream: stream, Lines: true})
+	if err != nil {
+		return false, err
+	}
+	emit := emitter(stream, fileID, doc.Knowledge, opsFile, self, check)
+	_, ids, _ := elementsOf(doc, false)
+	for _, id := range ids {
+		if err := emit(crdt.Operation{Type: crdt.OpDelete, LineID: id}); err != nil {
+			return false, err
+		}
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+	if err := self.Save(); err != nil {
+		return false, err
+	}
+	return true, index.SetHash(repoPath, stream, fileID, index.Hash{})
+}
+
+// elementsOf returns the contents and IDs of a document's elements, and
+// whether they all are fragments, or all lines, as wanted
+func elementsOf(doc *materialize.Document, fragment bool) ([]string, []uuid.UUID, bool) {
+	if doc.Elements == nil {
+		return doc.Lines, doc.LineIDs, !fragment || len(doc.Lines) == 0
+	}
+	contents := make([]string, len(doc.Elements))
+	ids := make([]uuid.UUID, len(doc.Elements))
+	same := true
+	for i, el := range doc.Elements {
+		contents[i], ids[i] = el.Content, el.ID
+		if el.Fragment != fragment {
+			same = false
+		}
+	}
+	return contents, ids, same
+}
+
+func storeLargeFile(repoPath, stream, fileID, absPath string, doc *materialize.Document, vector crdt.VectorClock, opsFile string, self *node.Node, check *validate.Validator) (bool, error) {
+	// Initialize LFS store
+	store := lfs.NewStore(repoPath)
+
+	// Open file
+	f, err := os.Open(absPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// Get file info
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// Store in LFS
+	info, err := store.StoreFile(fileID, f, stat.Size())
+	if err != nil {
+		return false, err
+	}
+
+	// the stub replaces whatever the document held before
+	stub := fmt.Sprintf("EVO-LFS:%s:%d", fileID, info.Size)
+	return diffLines(stream, fileID, []byte(stub), crdt.GranularityLine, doc, vector, opsFile, self, check)
+}
+
+func hashFile(path string) (string, error) {
+	defer profile.Track(profile.Hash)()
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func readLargeThreshold(repoPath string) int64 {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return 1_000_000
+	}
+	n, err := cfg.Size("files.largeThreshold")
+	if err != nil || n <= 0 {
+		return 1_000_000
+	}
+	return n
+}
+
+func parseUUID(s string) uuid.UUID {
+	id, _ := uuid.Parse(s)
+	return id
+}
+
+func eqLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
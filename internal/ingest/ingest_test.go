@@ -0,0 +1,355 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/ops"
+	"evo/internal/progress"
+	"evo/internal/textenc"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngestSkipsUnchangedFiles(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\ntwo"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	assert.NoError(t, err)
+	log := filepath.Join(rp, ".evo", "ops", "main", fid+".bin")
+
+	changed, err := IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, changed)
+	fi, err := os.Stat(log)
+	assert.NoError(t, err)
+	hashes, err := index.LoadHashes(rp, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Hash{LogSize: fi.Size(), Sum: index.HashContent([]byte("one\ntwo"))}, hashes[fid])
+
+	changed, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// a truncated log (e.g. by undo) no longer matches the remembered hash
+	assert.NoError(t, os.Truncate(log, 0))
+	changed, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, changed)
+	doc, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, doc.Lines)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\nTWO"), 0644))
+	changed, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, changed)
+	doc, err = materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one", "TWO"}, doc.Lines)
+}
+
+func TestIngestCancelled(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\ntwo"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = IngestLocalChanges(ctx, rp, "main")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(-1), ops.Mark(filepath.Join(rp, ".evo", "ops", "main", fid+".bin")))
+	hashes, err := index.LoadHashes(rp, "main")
+	assert.NoError(t, err)
+	assert.Empty(t, hashes)
+}
+
+func TestIngestProgress(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "b.txt"), []byte("two"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+
+	var buf bytes.Buffer
+	_, err := IngestLocalChanges(progress.With(context.Background(), progress.NewBar(&buf)), rp, "main")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "\rIngesting ["), buf.String())
+	assert.True(t, strings.HasSuffix(buf.String(), "] 100% 2/2\n"), buf.String())
+}
+
+func TestIngestDiffsLines(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte(content), 0644))
+		_, err := IngestLocalChanges(context.Background(), rp, "main")
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), nil, 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	assert.NoError(t, err)
+	write("a\nb\nc\nd")
+	before, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+
+	// one line inserted in the middle is one insert, not a cascade of updates
+	write("a\nb\nnew\nc\nd")
+	after, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, before.NumOps+1, after.NumOps)
+	assert.Equal(t, []string{"a", "b", "new", "c", "d"}, after.Lines)
+	assert.Equal(t, before.LineIDs[2], after.LineIDs[3])
+
+	// a removed line and a changed one
+	write("a\nnew\nC\nd")
+	after, err = materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "new", "C", "d"}, after.Lines)
+	assert.Equal(t, before.LineIDs[2], after.LineIDs[2])
+}
+
+func TestIngestEditSequences(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	path := filepath.Join(rp, "a.txt")
+	assert.NoError(t, os.WriteFile(path, nil, 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	assert.NoError(t, err)
+
+	rnd := rand.New(rand.NewSource(1))
+	lines := []string{"start"}
+	next := 0
+	for step := 0; step < 60; step++ {
+		// insert, delete or change a block of up to three lines
+		at := rnd.Intn(len(lines) + 1)
+		n := 1 + rnd.Intn(3)
+		switch rnd.Intn(3) {
+		case 0:
+			var block []string
+			for i := 0; i < n; i++ {
+				next++
+				block = append(block, fmt.Sprintf("line %d", next))
+			}
+			lines = append(lines[:at], append(block, lines[at:]...)...)
+		case 1:
+			if at+n <= len(lines) && len(lines) > n {
+				lines = append(lines[:at], lines[at+n:]...)
+			}
+		case 2:
+			for i := at; i < at+n && i < len(lines); i++ {
+				next++
+				lines[i] = fmt.Sprintf("changed %d", next)
+			}
+		}
+		assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+		_, err := IngestLocalChanges(context.Background(), rp, "main")
+		assert.NoError(t, err)
+
+		doc, err := materialize.Load(rp, "main", fid)
+		assert.NoError(t, err)
+		if !assert.Equal(t, lines, doc.Lines, "step %d", step) {
+			return
+		}
+		// anchors, not Lamport order, decide where lines go: a replay in
+		// Lamport order gives the same document
+		all, err := ops.LoadAllOps(filepath.Join(rp, ".evo", "ops", "main", fid+".bin"))
+		assert.NoError(t, err)
+		sort.Slice(all, func(i, j int) bool { return all[i].LessThan(&all[j]) })
+		rga := crdt.NewRGA()
+		for _, op := range all {
+			assert.NoError(t, rga.Apply(op))
+		}
+		if !assert.Equal(t, lines, rga.Materialize(), "replay at step %d", step) {
+			return
+		}
+	}
+}
+
+func TestIngestLargeFileStub(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, config.SetConfigValue(rp, "files.largeThreshold", "16"))
+	path := filepath.Join(rp, "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("one\ntwo"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	assert.NoError(t, err)
+	_, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+
+	// growing past the threshold replaces the lines with a single stub
+	big := strings.Repeat("x", 40)
+	assert.NoError(t, os.WriteFile(path, []byte(big), 0644))
+	_, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	doc, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("EVO-LFS:%s:40", fid)}, doc.Lines)
+}
+
+func TestIngestEncodings(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	utf16 := textenc.Encode("grüße\nwelt", textenc.UTF16LEBOM)
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), utf16, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "b.txt"), []byte("\xef\xbb\xbfone\ntwo"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	_, err := IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+
+	// lines are decoded, without the byte order mark
+	p2id, _, err := index.LoadIndex(rp)
+	assert.NoError(t, err)
+	doc, err := materialize.Load(rp, "main", p2id["a.txt"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"grüße", "welt"}, doc.Lines)
+	doc, err = materialize.Load(rp, "main", p2id["b.txt"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, doc.Lines)
+	encs, err := index.LoadEncodings(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]textenc.Encoding{p2id["a.txt"]: textenc.UTF16LEBOM, p2id["b.txt"]: textenc.UTF8BOM}, encs)
+
+	// and encoded back when written out
+	assert.NoError(t, os.Remove(filepath.Join(rp, "a.txt")))
+	assert.NoError(t, materialize.WriteFile(rp, "main", p2id["a.txt"]))
+	data, err := os.ReadFile(filepath.Join(rp, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, utf16, data)
+
+	// a file saved as plain UTF-8 only changes its encoding
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, "b.txt"), []byte("one\ntwo"), 0644))
+	changed, err := IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+	encs, err = index.LoadEncodings(rp)
+	assert.NoError(t, err)
+	assert.NotContains(t, encs, p2id["b.txt"])
+}
+
+func TestIngestWordGranularity(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	attrs := filepath.Join(rp, ".evo-attributes")
+	assert.NoError(t, os.WriteFile(attrs, []byte("*.md crdt=word\n"), 0644))
+	path := filepath.Join(rp, "notes.md")
+	assert.NoError(t, os.WriteFile(path, []byte("hello world\nbye"), 0644))
+	assert.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "notes.md")
+	assert.NoError(t, err)
+	_, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	before, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello world", "bye"}, before.Lines)
+	assert.Len(t, before.Elements, 5)
+
+	// a word added inside a line is two fragments, the word and its space
+	assert.NoError(t, os.WriteFile(path, []byte("hello brave world\nbye"), 0644))
+	_, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	after, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, before.NumOps+2, after.NumOps)
+	assert.Equal(t, []string{"hello brave world", "bye"}, after.Lines)
+
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, materialize.WriteFile(rp, "main", fid))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello brave world\nbye", string(data))
+
+	// back to lines: the fragments are replaced by whole lines
+	assert.NoError(t, os.WriteFile(attrs, nil, 0644))
+	_, err = IngestLocalChanges(context.Background(), rp, "main")
+	assert.NoError(t, err)
+	doc, err := materialize.Load(rp, "main", fid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello brave world", "bye"}, doc.Lines)
+	for _, el := range doc.Elements {
+		assert.False(t, el.Fragment)
+	}
+}
+
+// BenchmarkIngestMillionOps ingests one changed line in each file of a repo
+// whose op logs hold a million ops, with nothing cached: each run works on a
+// fresh copy of the repo
+func BenchmarkIngestMillionOps(b *testing.B) {
+	const files, lines = 100, 10_000
+	b.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(b.TempDir(), "global"))
+	b.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(b.TempDir(), "system"))
+	src := b.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, ".evo", "config"), 0755); err != nil {
+		b.Fatal(err)
+	}
+	content := make([]string, lines)
+	for i := range content {
+		content[i] = fmt.Sprintf("line %d of the file", i)
+	}
+	for f := 0; f < files; f++ {
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%03d.txt", f)), []byte(strings.Join(content, "\n")), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := index.UpdateIndex(src); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := IngestLocalChanges(context.Background(), src, "main"); err != nil {
+		b.Fatal(err)
+	}
+	content[lines/2] = "changed"
+	changed := []byte(strings.Join(content, "\n"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		rp := filepath.Join(b.TempDir(), "repo")
+		if err := os.CopyFS(rp, os.DirFS(src)); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			if err := os.WriteFile(filepath.Join(rp, fmt.Sprintf("f%03d.txt", f)), changed, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		got, err := IngestLocalChanges(context.Background(), rp, "main")
+		if err != nil || len(got) != files {
+			b.Fatalf("ingested %d files: %v", len(got), err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package interop moves history between evo and other version control
+// systems.
+package interop
+
+import (
+	"bufio"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("interop")
+
+// Evo commits have no parent links, so FastExport writes each stream as a
+// linear branch refs/heads/<stream>: its commits oldest first, each the child
+// of the one before. A commit merged into several streams appears once per
+// branch. File contents are replayed from the commits' ops and written inline;
+// large files are exported as the EVO-LFS pointer their ops hold.
+
+// FastExport writes the history of the named streams, or of every stream, to
+// w as a git fast-import stream, followed by the tags pointing at exported
+// commits. Paths are those of the current index; files it doesn't know are
+// named by their file ID.
+func FastExport(repoPath string, w io.Writer, names []string) error {
+	if len(names) == 0 {
+		all, err := streams.ListStreams(repoPath)
+		if err != nil {
+			return err
+		}
+		sort.Strings(all)
+		names = all
+	}
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	x := &exporter{w: bw, id2path: id2path, marks: make(map[string]int)}
+	for _, s := range names {
+		if err := x.stream(repoPath, s); err != nil {
+			return fmt.Errorf("failed to export stream %s: %w", s, err)
+		}
+	}
+
+	tags, err := revparse.Tags(repoPath)
+	if err != nil {
+		return err
+	}
+	tagNames := make([]string, 0, len(tags))
+	for name := range tags {
+		tagNames = append(tagNames, name)
+	}
+	sort.Strings(tagNames)
+	for _, name := range tagNames {
+		if mark, ok := x.marks[tags[name]]; ok {
+			fmt.Fprintf(bw, "reset refs/tags/%s\nfrom :%d\n\n", name, mark)
+		}
+	}
+	bw.WriteString("done\n")
+	return bw.Flush()
+}
+
+type exporter struct {
+	w       *bufio.Writer
+	id2path map[string]string
+	next    int            // last mark used
+	marks   map[string]int // commit ID => mark of its first export, for tags
+}
+
+// stream replays the commits of a stream and writes each with the files its
+// ops touched
+func (x *exporter) stream(repoPath, stream string) error {
+	docs := make(map[uuid.UUID]*crdt.RGA)
+	written := make(map[uuid.UUID]string) // content last exported, by file
+	prev := 0
+	n := 0
+	err := commits.ForEachCommit(repoPath, stream, commits.IterOptions{}, func(c *types.Commit) error {
+		var touched []uuid.UUID
+		for _, eop := range c.Operations {
+			op := eop.Op
+			doc := docs[op.FileID]
+			if doc == nil {
+				doc = crdt.NewRGA(crdt.WithoutLog())
+				docs[op.FileID] = doc
+			}
+			if err := doc.Apply(op); err != nil {
+				// ops pruned by compaction leave updates without their line
+				logger.Debug("skipping op", "commit", c.ID, "err", err)
+				continue
+			}
+			touched = append(touched, op.FileID)
+		}
+
+		x.next++
+		mark := x.next
+		if _, ok := x.marks[c.ID]; !ok {
+			x.marks[c.ID] = mark
+		}
+		fmt.Fprintf(x.w, "commit refs/heads/%s\nmark :%d\n", stream, mark)
+		who := fmt.Sprintf("%s <%s> %d +0000", c.AuthorName, c.AuthorEmail, c.Timestamp.Unix())
+		fmt.Fprintf(x.w, "author %s\ncommitter %s\n", who, who)
+		writeData(x.w, exportMessage(c))
+		if prev > 0 {
+			fmt.Fprintf(x.w, "from :%d\n", prev)
+		}
+		seen := make(map[uuid.UUID]bool)
+		for _, fid := range touched {
+			if seen[fid] {
+				continue
+			}
+			seen[fid] = true
+			path := x.id2path[fid.String()]
+			if path == "" {
+				path = fid.String()
+			}
+			doc := docs[fid]
+			old, had := written[fid]
+			if doc.Len() == 0 {
+				if had {
+					fmt.Fprintf(x.w, "D %s\n", quotePath(path))
+					delete(written, fid)
+				}
+				continue
+			}
+			content := materialize.Content(doc)
+			if had && content == old {
+				continue
+			}
+			fmt.Fprintf(x.w, "M 100644 inline %s\n", quotePath(path))
+			writeData(x.w, content)
+			written[fid] = content
+		}
+		x.w.WriteString("\n")
+		prev = mark
+		n++
+		return nil
+	})
+	logger.Info("exported stream", "stream", stream, "commits", n)
+	return err
+}
+
+// exportMessage returns a commit's message with its trailers as a last
+// paragraph, as they would be written when committing
+func exportMessage(c *types.Commit) string {
+	if len(c.Trailers) == 0 {
+		return c.Message
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(c.Message, "\n "))
+	b.WriteString("\n\n")
+	for _, t := range c.Trailers {
+		fmt.Fprintf(&b, "%s: %s\n", t.Key, t.Value)
+	}
+	return b.String()
+}
+
+func writeData(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "data %d\n%s\n", len(s), s)
+}
+
+// quotePath quotes a path the way fast-import reads it when it starts with a
+// quote or holds a line break
+func quotePath(p string) string {
+	if !strings.HasPrefix(p, `"`) && !strings.Contains(p, "\n") {
+		return p
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(p) + `"`
+}
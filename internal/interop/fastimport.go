@@ -0,0 +1,619 @@
+package interop
+
+import (
+	"bufio"
+	"bytes"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportResult sums up an import
+type ImportResult struct {
+	Commits int      `json:"commits"`
+	Streams []string `json:"streams"`
+	Tags    []string `json:"tags"`
+}
+
+// FastImport reads a git fast-import stream, such as git fast-export writes,
+// and commits it into the repository: each branch refs/heads/<name> becomes
+// the stream <name>, with "/" replaced by "-", and each tag an evo tag. The
+// working tree must be empty and the streams new or without commits. Commits
+// are imported in stream order; their parents only give the files they start
+// from, as evo keeps no history graph. Afterwards the working tree holds the
+// current stream if it was imported, otherwise main or the first imported
+// stream.
+func FastImport(repoPath string, r io.Reader) (*ImportResult, error) {
+	im, err := NewImporter(repoPath, false)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		r:        &lineReader{br: bufio.NewReader(r)},
+		im:       im,
+		blobs:    make(map[string]string),
+		commits:  make(map[string]*imported),
+		branches: make(map[string]*imported),
+		res:      &ImportResult{},
+		dates:    "raw",
+	}
+	if err := p.run(repoPath); err != nil {
+		im.Close("", nil)
+		return nil, fmt.Errorf("line %d: %w", p.r.n, err)
+	}
+
+	head := ""
+	if cur, err := streams.CurrentStream(repoPath); err == nil && p.branches[cur] != nil {
+		head = cur
+	} else if p.branches["main"] != nil {
+		head = "main"
+	} else if len(p.res.Streams) > 0 {
+		head = p.res.Streams[0]
+	}
+	var files map[string]string
+	if head != "" {
+		files = p.branches[head].files
+	}
+	if err := im.Close(head, files); err != nil {
+		return nil, err
+	}
+	return p.res, nil
+}
+
+// imported is a commit as read so far: its evo ID and the files it leaves
+type imported struct {
+	id    string
+	files map[string]string
+}
+
+type parser struct {
+	r        *lineReader
+	im       *Importer
+	blobs    map[string]string    // mark or original ID => content file
+	commits  map[string]*imported // mark or original ID => commit
+	branches map[string]*imported // stream => tip
+	res      *ImportResult
+	dates    string // date format: "raw", "rfc2822" or "now"
+}
+
+func (p *parser) run(repoPath string) error {
+	for {
+		line, err := p.r.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "blob":
+			err = p.blob()
+		case "commit":
+			err = p.commit(arg)
+		case "reset":
+			err = p.reset(repoPath, arg)
+		case "tag":
+			err = p.tag(repoPath, arg)
+		case "progress":
+			logger.Info("fast-import progress", "message", arg)
+		case "checkpoint", "option":
+		case "feature":
+			err = p.feature(arg)
+		case "done":
+			return nil
+		default:
+			err = fmt.Errorf("unsupported command %q", cmd)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) feature(arg string) error {
+	switch arg {
+	case "done", "date-format=raw", "date-format=raw-permissive":
+	case "date-format=rfc2822":
+		p.dates = "rfc2822"
+	case "date-format=now":
+		p.dates = "now"
+	default:
+		return fmt.Errorf("unsupported feature %q", arg)
+	}
+	return nil
+}
+
+func (p *parser) blob() error {
+	var refs []string
+	for {
+		line, err := p.r.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "mark "):
+			refs = append(refs, strings.TrimPrefix(line, "mark "))
+		case strings.HasPrefix(line, "original-oid "):
+			refs = append(refs, strings.TrimPrefix(line, "original-oid "))
+		case strings.HasPrefix(line, "data "):
+			data, err := p.r.data(line)
+			if err != nil {
+				return err
+			}
+			path, err := p.im.Blob(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				p.blobs[ref] = path
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected %q in blob", line)
+		}
+	}
+}
+
+func (p *parser) commit(ref string) error {
+	stream, ok := streamOf(ref)
+	if !ok {
+		return fmt.Errorf("cannot import commits to %s", ref)
+	}
+	rev := &Revision{Stream: stream}
+	var refs []string
+	var author, committer string
+	from := p.branches[stream]
+	files := map[string]string{}
+	changed := false
+
+changes:
+	for {
+		line, err := p.r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "mark", "original-oid":
+			refs = append(refs, arg)
+			continue
+		case "author":
+			author = arg
+			continue
+		case "committer":
+			committer = arg
+			continue
+		case "encoding":
+			continue
+		case "data":
+			data, err := p.r.data(line)
+			if err != nil {
+				return err
+			}
+			rev.Message = string(data)
+			continue
+		case "from":
+			c, err := p.commitish(arg)
+			if err != nil {
+				return err
+			}
+			from = c
+			continue
+		case "merge":
+			// evo keeps no merge parents; the changes give the merged files
+			continue
+		}
+		if !changed {
+			if from != nil {
+				for k, v := range from.files {
+					files[k] = v
+				}
+			}
+			changed = true
+		}
+		switch cmd {
+		case "M":
+			err = p.modify(files, arg)
+		case "D":
+			path, _, err2 := readPath(arg, false)
+			if err2 == nil {
+				err2 = checkPath(path)
+			}
+			if err2 != nil {
+				return err2
+			}
+			removePath(files, path)
+		case "C", "R":
+			err = copyPath(files, arg, cmd == "R")
+		case "deleteall":
+			files = map[string]string{}
+		case "N":
+			// notes are not imported
+			if ref, _, _ := strings.Cut(arg, " "); ref == "inline" {
+				err = p.skipData()
+			}
+		default:
+			p.r.unread(line)
+			break changes
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !changed && from != nil {
+		files = from.files
+	}
+	if committer == "" {
+		return fmt.Errorf("commit to %s has no committer", ref)
+	}
+	if author == "" {
+		author = committer
+	}
+	var err error
+	rev.AuthorName, rev.AuthorEmail, _, err = p.ident(author)
+	if err != nil {
+		return err
+	}
+	if _, _, rev.Time, err = p.ident(committer); err != nil {
+		return err
+	}
+	rev.Files = files
+	c, err := p.im.Commit(rev)
+	if err != nil {
+		return err
+	}
+	tip := &imported{id: c.ID, files: files}
+	for _, r := range refs {
+		p.commits[r] = tip
+	}
+	if !slices.Contains(p.res.Streams, stream) {
+		p.res.Streams = append(p.res.Streams, stream)
+	}
+	p.branches[stream] = tip
+	p.res.Commits++
+	return nil
+}
+
+// modify applies "M <mode> <dataref> <path>", reading inline data
+func (p *parser) modify(files map[string]string, arg string) error {
+	mode, rest, _ := strings.Cut(arg, " ")
+	ref, rest, _ := strings.Cut(rest, " ")
+	path, _, err := readPath(rest, false)
+	if err != nil {
+		return err
+	}
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	switch mode {
+	case "100644", "644", "100755", "755", "120000":
+	case "160000":
+		// submodules have no content to import
+		return nil
+	default:
+		return fmt.Errorf("unsupported file mode %s for %s", mode, path)
+	}
+	var blob string
+	if ref == "inline" {
+		data, err := p.nextData()
+		if err != nil {
+			return err
+		}
+		if blob, err = p.im.Blob(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	} else if blob = p.blobs[ref]; blob == "" {
+		return fmt.Errorf("unknown blob %s for %s", ref, path)
+	}
+	files[path] = blob
+	return nil
+}
+
+// nextData reads the data block on the next line
+func (p *parser) nextData() ([]byte, error) {
+	line, err := p.r.next()
+	if err != nil {
+		return nil, err
+	}
+	return p.r.data(line)
+}
+
+func (p *parser) skipData() error {
+	_, err := p.nextData()
+	return err
+}
+
+func (p *parser) reset(repoPath, ref string) error {
+	var from *imported
+	line, err := p.r.next()
+	if err == nil && strings.HasPrefix(line, "from ") {
+		if from, err = p.commitish(strings.TrimPrefix(line, "from ")); err != nil {
+			return err
+		}
+	} else if err == nil {
+		p.r.unread(line)
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		if from == nil {
+			return nil
+		}
+		return p.writeTag(repoPath, name, from)
+	}
+	stream, ok := streamOf(ref)
+	if !ok {
+		return nil
+	}
+	if from == nil {
+		delete(p.branches, stream)
+		return nil
+	}
+	p.branches[stream] = from
+	return nil
+}
+
+func (p *parser) tag(repoPath, name string) error {
+	var from *imported
+	for {
+		line, err := p.r.next()
+		if err != nil {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "from":
+			if from, err = p.commitish(arg); err != nil {
+				return err
+			}
+		case "mark", "original-oid", "tagger":
+		case "data":
+			// evo tags carry no message
+			if _, err := p.r.data(line); err != nil {
+				return err
+			}
+			if from == nil {
+				return fmt.Errorf("tag %s has no from", name)
+			}
+			return p.writeTag(repoPath, name, from)
+		default:
+			return fmt.Errorf("unexpected %q in tag %s", line, name)
+		}
+	}
+}
+
+func (p *parser) writeTag(repoPath, name string, c *imported) error {
+	if err := revparse.WriteTag(repoPath, name, c.id); err != nil {
+		return err
+	}
+	p.res.Tags = append(p.res.Tags, name)
+	return nil
+}
+
+// commitish resolves a mark, original ID or branch to a commit read earlier
+func (p *parser) commitish(s string) (*imported, error) {
+	if c := p.commits[s]; c != nil {
+		return c, nil
+	}
+	if stream, ok := streamOf(s); ok {
+		if c := p.branches[stream]; c != nil {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown commit %s", s)
+}
+
+// ident parses "<name> <<email>> <date>" in the stream's date format
+func (p *parser) ident(s string) (string, string, time.Time, error) {
+	lt := strings.Index(s, "<")
+	gt := strings.Index(s, ">")
+	if lt < 0 || gt < lt {
+		return "", "", time.Time{}, fmt.Errorf("invalid identity %q", s)
+	}
+	name := strings.TrimSpace(s[:lt])
+	email := s[lt+1 : gt]
+	date := strings.TrimSpace(s[gt+1:])
+	switch p.dates {
+	case "now":
+		return name, email, time.Now(), nil
+	case "rfc2822":
+		t, err := mail.ParseDate(date)
+		if err != nil {
+			return "", "", time.Time{}, fmt.Errorf("invalid date %q: %w", date, err)
+		}
+		return name, email, t, nil
+	}
+	secs, _, _ := strings.Cut(date, " ")
+	n, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("invalid date %q", date)
+	}
+	return name, email, time.Unix(n, 0), nil
+}
+
+// streamOf names the stream a branch ref imports into
+func streamOf(ref string) (string, bool) {
+	name, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok || name == "" {
+		return "", false
+	}
+	return strings.ReplaceAll(name, "/", "-"), true
+}
+
+// removePath removes a file, or every file under a directory
+func removePath(files map[string]string, path string) {
+	delete(files, path)
+	for p := range files {
+		if strings.HasPrefix(p, path+"/") {
+			delete(files, p)
+		}
+	}
+}
+
+// copyPath applies "C <src> <dst>" or, when rename is set, "R <src> <dst>";
+// either may name a directory
+func copyPath(files map[string]string, arg string, rename bool) error {
+	src, rest, err := readPath(arg, true)
+	if err != nil {
+		return err
+	}
+	dst, _, err := readPath(strings.TrimPrefix(rest, " "), false)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{src, dst} {
+		if err := checkPath(p); err != nil {
+			return err
+		}
+	}
+	moved := false
+	for p, blob := range files {
+		switch {
+		case p == src:
+			files[dst] = blob
+		case strings.HasPrefix(p, src+"/"):
+			files[dst+strings.TrimPrefix(p, src)] = blob
+		default:
+			continue
+		}
+		moved = true
+		if rename {
+			delete(files, p)
+		}
+	}
+	if !moved {
+		return fmt.Errorf("no file %s to copy", src)
+	}
+	return nil
+}
+
+// readPath reads a path, C-style quoted or not, and returns the rest of the
+// line. An unquoted path runs to the end of the line, or to the first space
+// if spaced is set.
+func readPath(s string, spaced bool) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if spaced {
+			path, rest, _ := strings.Cut(s, " ")
+			return path, rest, nil
+		}
+		return s, "", nil
+	}
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return string(b), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated path %s", s)
+			}
+			switch e := s[i]; e {
+			case 'n':
+				b = append(b, '\n')
+			case 't':
+				b = append(b, '\t')
+			case 'a':
+				b = append(b, '\a')
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case 'r':
+				b = append(b, '\r')
+			case 'v':
+				b = append(b, '\v')
+			case '0', '1', '2', '3':
+				if i+2 >= len(s) {
+					return "", "", fmt.Errorf("invalid escape in path %s", s)
+				}
+				n, err := strconv.ParseUint(s[i:i+3], 8, 8)
+				if err != nil {
+					return "", "", fmt.Errorf("invalid escape in path %s", s)
+				}
+				b = append(b, byte(n))
+				i += 2
+			default:
+				b = append(b, e)
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated path %s", s)
+}
+
+// lineReader reads the commands of a stream line by line, skipping comments,
+// and the data blocks between them
+type lineReader struct {
+	br      *bufio.Reader
+	n       int
+	pending *string
+}
+
+func (r *lineReader) next() (string, error) {
+	if r.pending != nil {
+		line := *r.pending
+		r.pending = nil
+		return line, nil
+	}
+	for {
+		line, err := r.br.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		if err != nil {
+			return "", err
+		}
+		r.n++
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, nil
+	}
+}
+
+func (r *lineReader) unread(line string) {
+	r.pending = &line
+}
+
+// data reads the block a "data <count>" or "data <<<delim>" line starts
+func (r *lineReader) data(header string) ([]byte, error) {
+	arg, ok := strings.CutPrefix(header, "data ")
+	if !ok {
+		return nil, fmt.Errorf("expected data, got %q", header)
+	}
+	if delim, ok := strings.CutPrefix(arg, "<<"); ok {
+		var b bytes.Buffer
+		for {
+			line, err := r.br.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("unterminated data block: %w", err)
+			}
+			r.n++
+			if strings.TrimSuffix(line, "\n") == delim {
+				return b.Bytes(), nil
+			}
+			b.WriteString(line)
+		}
+	}
+	size, err := strconv.Atoi(arg)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid data length %q", arg)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.br, buf); err != nil {
+		return nil, fmt.Errorf("truncated data block: %w", err)
+	}
+	r.n += bytes.Count(buf, []byte("\n"))
+	return buf, nil
+}
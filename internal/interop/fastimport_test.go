@@ -0,0 +1,186 @@
+package interop
+
+import (
+	"bytes"
+	"evo/internal/commits"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = `feature done
+blob
+mark :1
+data 4
+one
+
+# a comment
+commit refs/heads/main
+mark :2
+author Ann <ann@example.com> 1700000000 +0100
+committer Bob <bob@example.com> 1700000000 +0100
+data 6
+first
+M 100644 :1 a.txt
+M 100644 inline "dir/with \"quote\".txt"
+data <<EOF
+quoted
+EOF
+
+commit refs/heads/main
+mark :3
+committer Bob <bob@example.com> 1700000000 +0000
+data 33
+second
+
+Signed-off-by: Ann <a@b>
+from :2
+R a.txt b.txt
+D dir
+
+reset refs/heads/topic/x
+from :2
+
+commit refs/heads/topic/x
+mark :4
+committer Bob <bob@example.com> 1700000100 +0000
+data 5
+third
+M 100644 inline a.txt
+data 4
+two
+
+tag v1
+from :3
+tagger Bob <bob@example.com> 1700000000 +0000
+data 4
+rel
+
+reset refs/tags/v0
+from :2
+
+done
+`
+
+func TestFastImport(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+
+	res, err := FastImport(rp, strings.NewReader(sample))
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Commits)
+	assert.Equal(t, []string{"main", "topic-x"}, res.Streams)
+	assert.ElementsMatch(t, []string{"v1", "v0"}, res.Tags)
+
+	// the working tree holds main
+	data, err := os.ReadFile(filepath.Join(rp, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(data))
+	_, err = os.Stat(filepath.Join(rp, "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(rp, "dir"))
+	assert.True(t, os.IsNotExist(err))
+
+	main, err := streams.ListCommits(rp, "main")
+	require.NoError(t, err)
+	require.Len(t, main, 2)
+	assert.Equal(t, "Ann", main[0].AuthorName)
+	assert.Equal(t, "first\n", main[0].Message)
+	assert.Equal(t, "Bob", main[1].AuthorName)
+	assert.Equal(t, "second", strings.TrimSpace(main[1].Message))
+	require.Len(t, main[1].Trailers, 1)
+	assert.Equal(t, "Ann <a@b>", main[1].Trailers[0].Value)
+	assert.True(t, main[1].Timestamp.After(main[0].Timestamp), "equal times keep their order")
+
+	tags, err := revparse.Tags(rp)
+	require.NoError(t, err)
+	assert.Equal(t, main[1].ID, tags["v1"])
+	assert.Equal(t, main[0].ID, tags["v0"])
+
+	// streams must be new
+	_, err = FastImport(rp, strings.NewReader(sample))
+	assert.Error(t, err)
+
+	// exporting replays the files each commit leaves
+	var out bytes.Buffer
+	require.NoError(t, FastExport(rp, &out, []string{"main"}))
+	export := out.String()
+	assert.Contains(t, export, "M 100644 inline dir/with \"quote\".txt\ndata 7\nquoted\n")
+	assert.Contains(t, export, "D a.txt\n")
+	assert.Contains(t, export, "Signed-off-by: Ann <a@b>\n")
+	assert.Contains(t, export, "reset refs/tags/v1\nfrom :2\n")
+	assert.True(t, strings.HasSuffix(export, "done\n"))
+
+	// and imports back into the same history
+	rp2 := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp2))
+	res, err = FastImport(rp2, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Commits)
+	n, err := commits.CountCommits(rp2, "main", commits.IterOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	data, err = os.ReadFile(filepath.Join(rp2, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(data))
+}
+
+func TestFastImportPaths(t *testing.T) {
+	for _, change := range []string{
+		"M 100644 inline ../fi_escape.txt\ndata 2\nx\n",
+		"M 100644 inline .evo/config/config.toml\ndata 2\nx\n",
+		"M 100644 inline \"a/../../fi_escape.txt\"\ndata 2\nx\n",
+		"M 100644 inline /tmp/fi_escape.txt\ndata 2\nx\n",
+		"M 100644 inline a.txt\ndata 2\nx\nR a.txt ../fi_escape.txt\n",
+		"M 100644 inline a.txt\ndata 2\nx\nC a.txt .evo/index\n",
+		"D ../fi_escape.txt\n",
+	} {
+		parent := t.TempDir()
+		rp := filepath.Join(parent, "repo")
+		require.NoError(t, os.Mkdir(rp, 0755))
+		require.NoError(t, repo.InitRepo(rp))
+		stream := "commit refs/heads/main\ncommitter Ann <a@b> 1700000000 +0000\ndata 4\nbad\n" + change
+		_, err := FastImport(rp, strings.NewReader(stream))
+		if assert.Error(t, err, change) {
+			assert.Contains(t, err.Error(), "line ")
+			assert.Contains(t, err.Error(), "invalid path")
+		}
+		_, err = os.Stat(filepath.Join(parent, "fi_escape.txt"))
+		assert.True(t, os.IsNotExist(err), change)
+		n, err := commits.CountCommits(rp, "main", commits.IterOptions{})
+		assert.NoError(t, err)
+		assert.Zero(t, n, change)
+	}
+}
+
+func TestReadPath(t *testing.T) {
+	path, rest, err := readPath(`"a \"b\"\tc\303\251" d`, true)
+	require.NoError(t, err)
+	assert.Equal(t, "a \"b\"\tcé", path)
+	assert.Equal(t, " d", rest)
+
+	path, rest, err = readPath("src dst", true)
+	require.NoError(t, err)
+	assert.Equal(t, "src", path)
+	assert.Equal(t, "dst", rest)
+
+	path, _, err = readPath("with space", false)
+	require.NoError(t, err)
+	assert.Equal(t, "with space", path)
+
+	_, _, err = readPath(`"open`, false)
+	assert.Error(t, err)
+
+	for _, p := range []string{"plain name", `"quoted"`, "line\nbreak"} {
+		got, _, err := readPath(quotePath(p), false)
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+}
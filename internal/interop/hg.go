@@ -0,0 +1,206 @@
+package interop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hg reads a Mercurial repository through the hg command. Named branches
+// become streams; a changeset whose first parent isn't the last one read of
+// its branch starts from all of its files.
+type Hg struct {
+	Path string
+}
+
+// hgBatch bounds the files given to one hg cat
+const hgBatch = 200
+
+func (h *Hg) ID() string    { return "hg " + h.Path }
+func (h *Hg) Trunk() string { return "default" }
+
+// hgEntry is a changeset as hgLogTemplate writes it
+type hgEntry struct {
+	Rev    int64
+	Branch string
+	Author string
+	Time   time.Time
+	P1     int64
+	Desc   string
+}
+
+const hgLogTemplate = `{rev}\x1f{branch}\x1f{author}\x1f{date|hgdate}\x1f{p1rev}\x1f{desc}\x1e`
+
+func (h *Hg) hg(args ...string) ([]byte, error) {
+	return run("hg", append([]string{"-R", h.Path, "--config", "ui.interactive=false"}, args...)...)
+}
+
+func (h *Hg) Changes(after int64, store Store, fn func(*Change) error) error {
+	tip, err := h.hg("log", "-r", "tip", "--template", "{rev}")
+	if err != nil {
+		return err
+	}
+	last, err := strconv.ParseInt(strings.TrimSpace(string(tip)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected revision %q", tip)
+	}
+	if after+1 <= last {
+		out, err := h.hg("log", "-r", fmt.Sprintf("%d:%d", after+1, last), "--template", hgLogTemplate)
+		if err != nil {
+			return err
+		}
+		entries, err := parseHgLog(out)
+		if err != nil {
+			return err
+		}
+		branchTip := make(map[string]int64)
+		for _, e := range entries {
+			ch := &Change{Rev: e.Rev, Branch: e.Branch, Author: e.Author, Time: e.Time, Message: e.Desc, Files: make(map[string]string)}
+			var changed []string
+			if t, ok := branchTip[e.Branch]; !ok || t != e.P1 {
+				ch.Reset = true
+				if changed, err = h.lines("manifest", "-r", strconv.FormatInt(e.Rev, 10)); err != nil {
+					return err
+				}
+			} else {
+				status, err := h.lines("status", "--change", strconv.FormatInt(e.Rev, 10))
+				if err != nil {
+					return err
+				}
+				for _, s := range status {
+					code, path, _ := strings.Cut(s, " ")
+					if code == "R" {
+						ch.Deleted = append(ch.Deleted, path)
+					} else {
+						changed = append(changed, path)
+					}
+				}
+			}
+			if err := h.cat(e.Rev, changed, ch.Files, store); err != nil {
+				return fmt.Errorf("failed to read revision %d: %w", e.Rev, err)
+			}
+			branchTip[e.Branch] = e.Rev
+			if err := fn(ch); err != nil {
+				return err
+			}
+		}
+	}
+
+	out, err := h.hg("log", "-r", "tag()", "--template", `{rev}\x1f{branch}{tags % "\x1f{tag}"}\n`)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) < 3 {
+			continue
+		}
+		rev, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, tag := range fields[2:] {
+			if tag == "tip" {
+				continue
+			}
+			if err := fn(&Change{Rev: rev, Branch: fields[1], Tag: tag}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseHgLog reads changesets written with hgLogTemplate
+func parseHgLog(out []byte) ([]hgEntry, error) {
+	var entries []hgEntry
+	for _, rec := range strings.Split(string(out), "\x1e") {
+		if strings.TrimSpace(rec) == "" {
+			continue
+		}
+		f := strings.SplitN(rec, "\x1f", 6)
+		if len(f) != 6 {
+			return nil, fmt.Errorf("unexpected hg log output %q", rec)
+		}
+		var e hgEntry
+		var err error
+		if e.Rev, err = strconv.ParseInt(f[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("unexpected revision %q", f[0])
+		}
+		if e.P1, err = strconv.ParseInt(f[4], 10, 64); err != nil {
+			return nil, fmt.Errorf("unexpected parent %q", f[4])
+		}
+		secs, _, _ := strings.Cut(f[3], " ")
+		n, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected date %q", f[3])
+		}
+		e.Branch, e.Author, e.Time, e.Desc = f[1], f[2], time.Unix(n, 0), f[5]
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// lines runs hg and returns the lines it prints
+func (h *Hg) lines(args ...string) ([]string, error) {
+	out, err := h.hg(args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if sc.Text() != "" {
+			lines = append(lines, sc.Text())
+		}
+	}
+	return lines, sc.Err()
+}
+
+// cat stores the content of files as of a revision into files, writing them
+// out with hg cat a batch at a time
+func (h *Hg) cat(rev int64, paths []string, files map[string]string, store Store) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "evo-hg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < len(paths); i += hgBatch {
+		batch := paths[i:min(i+hgBatch, len(paths))]
+		args := []string{"cat", "-r", strconv.FormatInt(rev, 10), "-o", filepath.Join(dir, "%p"), "--"}
+		for _, p := range batch {
+			args = append(args, "path:"+p)
+		}
+		if _, err := h.hg(args...); err != nil {
+			return err
+		}
+		for _, p := range batch {
+			if err := storeFile(filepath.Join(dir, filepath.FromSlash(p)), p, files, store); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func storeFile(path, name string, files map[string]string, store Store) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	blob, err := store(f)
+	if err != nil {
+		return err
+	}
+	files[name] = blob
+	return nil
+}
@@ -0,0 +1,24 @@
+package interop
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHgLog(t *testing.T) {
+	out := "0\x1fdefault\x1fAnn <ann@example.com>\x1f1700000000 -3600\x1f-1\x1ffirst\x1e" +
+		"1\x1ffeature\x1fbob\x1f1700000100 0\x1f0\x1fsecond\nwith \x1f odd bytes\x1e"
+	entries, err := parseHgLog([]byte(out))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, hgEntry{Rev: 0, Branch: "default", Author: "Ann <ann@example.com>", Time: entries[0].Time, P1: -1, Desc: "first"}, entries[0])
+	assert.Equal(t, int64(1700000000), entries[0].Time.Unix())
+	assert.Equal(t, "feature", entries[1].Branch)
+	assert.Equal(t, int64(0), entries[1].P1)
+	assert.Equal(t, "second\nwith \x1f odd bytes", entries[1].Desc)
+
+	_, err = parseHgLog([]byte("x\x1fdefault\x1e"))
+	assert.Error(t, err)
+}
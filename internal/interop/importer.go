@@ -0,0 +1,373 @@
+package interop
+
+import (
+	"bytes"
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/lfs"
+	"evo/internal/materialize"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/trailers"
+	"evo/internal/types"
+	"evo/internal/util"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Revision is a commit of another system to import: the files its stream
+// holds after it, each the path of a file with its content
+type Revision struct {
+	Stream      string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	Time        time.Time
+	Files       map[string]string // path => content file
+}
+
+// Importer commits revisions into evo streams the way evo commit does: it
+// writes each revision's files to the working tree and records the
+// differences as ops. Paths keep their file IDs across streams and removals,
+// so a file that comes back continues its history.
+type Importer struct {
+	repoPath string
+	resume   bool
+	blobDir  string
+	disk     map[string]string            // path => content file in the working tree
+	trees    map[string]map[string]string // files of each stream's last revision
+	last     map[string]time.Time         // time of each stream's last commit
+	checked  map[string]bool              // streams ready for import
+	next     int
+}
+
+// NewImporter prepares an import into a repository whose working tree is
+// empty. With resume set, streams that have commits are continued, and the
+// working tree may hold the current stream's files. Contents are staged in a
+// directory under .evo until Close.
+func NewImporter(repoPath string, resume bool) (*Importer, error) {
+	dir, err := os.MkdirTemp(repo.Dir(repoPath), "import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import directory: %w", err)
+	}
+	im := &Importer{
+		repoPath: repoPath,
+		resume:   resume,
+		blobDir:  dir,
+		disk:     make(map[string]string),
+		trees:    make(map[string]map[string]string),
+		last:     make(map[string]time.Time),
+		checked:  make(map[string]bool),
+	}
+	if err := im.adopt(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return im, nil
+}
+
+// adopt checks the working tree holds nothing an import would overwrite
+func (im *Importer) adopt() error {
+	all, err := util.ListAllFiles(im.repoPath)
+	if err != nil {
+		return err
+	}
+	var working []string
+	for _, f := range all {
+		if !strings.HasPrefix(f, ".evo") {
+			working = append(working, f)
+		}
+	}
+	if len(working) == 0 {
+		return nil
+	}
+	if !im.resume {
+		return fmt.Errorf("importing needs an empty working tree, but %s exists", working[0])
+	}
+	cur, err := streams.CurrentStream(im.repoPath)
+	if err != nil {
+		return err
+	}
+	files, err := im.Tree(cur)
+	if err != nil {
+		return err
+	}
+	for _, f := range working {
+		blob, ok := files[f]
+		if !ok || !sameContent(filepath.Join(im.repoPath, f), blob) {
+			return fmt.Errorf("the working tree has changes not committed to %s: %s", cur, f)
+		}
+		im.disk[f] = blob
+	}
+	if len(working) != len(files) {
+		return fmt.Errorf("the working tree lacks files of %s", cur)
+	}
+	return nil
+}
+
+// Tree returns the files a stream holds: those of its last imported
+// revision, or of its commits when continuing it. The map must not be
+// changed.
+func (im *Importer) Tree(stream string) (map[string]string, error) {
+	if files, ok := im.trees[stream]; ok {
+		return files, nil
+	}
+	_, id2path, err := index.LoadIndex(im.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for fid, path := range id2path {
+		doc, err := materialize.Load(im.repoPath, stream, fid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		if len(doc.Lines) == 0 {
+			continue
+		}
+		var r io.Reader
+		if len(doc.Lines) == 1 && strings.HasPrefix(doc.Lines[0], "EVO-LFS:") {
+			var b bytes.Buffer
+			if err := lfs.NewStore(im.repoPath).ReadFile(fid, &b); err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", path, err)
+			}
+			r = &b
+		} else {
+			r = strings.NewReader(strings.Join(doc.Lines, "\n"))
+		}
+		if files[path], err = im.Blob(r); err != nil {
+			return nil, err
+		}
+	}
+	im.trees[stream] = files
+	return files, nil
+}
+
+// Blob stores content read from r and returns the file holding it
+func (im *Importer) Blob(r io.Reader) (string, error) {
+	im.next++
+	path := filepath.Join(im.blobDir, fmt.Sprintf("%d", im.next))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to store content: %w", err)
+	}
+	return path, nil
+}
+
+// Commit records a revision as a commit of its stream, creating the stream if
+// needed. Streams must have no commits before the import. Files must not
+// change afterwards.
+func (im *Importer) Commit(rev *Revision) (*types.Commit, error) {
+	for path := range rev.Files {
+		if err := checkPath(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := im.prepare(rev.Stream); err != nil {
+		return nil, err
+	}
+	if err := im.checkout(rev.Files); err != nil {
+		return nil, err
+	}
+	p2id, err := im.track(rev.Files)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), im.repoPath, rev.Stream); err != nil {
+		return nil, fmt.Errorf("failed to record files: %w", err)
+	}
+	for path := range im.trees[rev.Stream] {
+		if _, ok := rev.Files[path]; ok {
+			continue
+		}
+		if _, err := ingest.RemoveFile(im.repoPath, rev.Stream, p2id[path]); err != nil {
+			return nil, fmt.Errorf("failed to record removal of %s: %w", path, err)
+		}
+	}
+	im.trees[rev.Stream] = rev.Files
+	eops, err := commits.GatherNewOps(im.repoPath, rev.Stream)
+	if err != nil {
+		return nil, err
+	}
+	// commits are ordered by time: one no later than the one before it, as
+	// with times in whole seconds, is stamped just after it
+	t := rev.Time.UTC()
+	if last := im.last[rev.Stream]; !t.After(last) {
+		t = last.Add(time.Nanosecond)
+	}
+	im.last[rev.Stream] = t
+	msg, ts := trailers.Split(rev.Message)
+	c := &types.Commit{
+		ID:          uuid.New().String(),
+		Stream:      rev.Stream,
+		Message:     msg,
+		AuthorName:  rev.AuthorName,
+		AuthorEmail: rev.AuthorEmail,
+		Timestamp:   t,
+		Operations:  eops,
+		Trailers:    ts,
+	}
+	if err := commits.SaveCommit(im.repoPath, c); err != nil {
+		return nil, err
+	}
+	logger.Debug("imported commit", "id", c.ID, "stream", rev.Stream, "ops", len(eops))
+	return c, nil
+}
+
+// Close leaves the working tree as head's files and makes head the current
+// stream, then removes the staged contents. An empty head keeps the current
+// stream.
+func (im *Importer) Close(head string, files map[string]string) error {
+	defer os.RemoveAll(im.blobDir)
+	if head == "" {
+		return nil
+	}
+	if err := im.checkout(files); err != nil {
+		return err
+	}
+	return streams.SwitchStream(im.repoPath, head)
+}
+
+func (im *Importer) prepare(stream string) error {
+	if im.checked[stream] {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(repo.Dir(im.repoPath), "streams", stream)); os.IsNotExist(err) {
+		if err := streams.CreateStream(im.repoPath, stream); err != nil {
+			return err
+		}
+	} else {
+		idx, err := commits.LoadIndex(im.repoPath)
+		if err != nil {
+			return err
+		}
+		es, err := idx.Stream(stream)
+		if err != nil {
+			return err
+		}
+		if len(es) > 0 {
+			if !im.resume {
+				return fmt.Errorf("stream %s already has commits", stream)
+			}
+			if _, err := im.Tree(stream); err != nil {
+				return err
+			}
+			im.last[stream] = es[len(es)-1].Timestamp
+		}
+	}
+	im.checked[stream] = true
+	return nil
+}
+
+// checkPath refuses a path an import must not write: one outside the
+// repository or inside its .evo
+func checkPath(path string) error {
+	first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(path)), "/")
+	if !filepath.IsLocal(path) || first == repo.EvoDir {
+		return fmt.Errorf("invalid path %q: outside the repository or in %s", path, repo.EvoDir)
+	}
+	return nil
+}
+
+// checkout makes the working tree hold exactly files
+func (im *Importer) checkout(files map[string]string) error {
+	for path := range im.disk {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(im.repoPath, path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(im.repoPath, filepath.Dir(path))
+		delete(im.disk, path)
+	}
+	for path, blob := range files {
+		if im.disk[path] == blob {
+			continue
+		}
+		abs := filepath.Join(im.repoPath, path)
+		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(blob, abs); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		im.disk[path] = blob
+	}
+	return nil
+}
+
+// track gives new paths a file ID and returns the index. Unlike evo commit
+// it never drops paths, so files of other streams and removed files keep
+// theirs.
+func (im *Importer) track(files map[string]string) (map[string]string, error) {
+	p2id, _, err := index.LoadIndex(im.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	added := false
+	for path := range files {
+		if _, ok := p2id[path]; !ok {
+			p2id[path] = uuid.New().String()
+			added = true
+		}
+	}
+	if added {
+		if err := index.SaveIndex(im.repoPath, p2id); err != nil {
+			return nil, err
+		}
+	}
+	return p2id, nil
+}
+
+func sameContent(a, b string) bool {
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	db, err := os.ReadFile(b)
+	return err == nil && bytes.Equal(da, db)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// removeEmptyDirs removes dir and its parents inside the repository while
+// they are empty
+func removeEmptyDirs(repoPath, dir string) {
+	for dir != "." && dir != "" && !strings.HasPrefix(dir, "..") {
+		if os.Remove(filepath.Join(repoPath, dir)) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
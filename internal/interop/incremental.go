@@ -0,0 +1,297 @@
+package interop
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"evo/internal/identity"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source is a repository of another version control system whose numbered
+// revisions can be read in order
+type Source interface {
+	// ID names the source in the import state, as "svn <url>"
+	ID() string
+	// Trunk is the branch imported into main
+	Trunk() string
+	// Changes calls fn with the changes of each revision after rev, oldest
+	// first, and then with the tags. Contents are saved with store.
+	Changes(after int64, store Store, fn func(*Change) error) error
+}
+
+// Store saves file content and returns the file holding it
+type Store func(r io.Reader) (string, error)
+
+// Change is what a revision did to one branch, or a tag
+type Change struct {
+	Rev     int64
+	Branch  string
+	Author  string // as the source records it
+	Time    time.Time
+	Message string
+	Reset   bool              // Files holds every file of the branch
+	Files   map[string]string // path => content file, of added and changed files
+	Deleted []string          // removed files and directories
+	Tag     string            // set for a tag on Branch as of Rev
+}
+
+// ImportOptions maps a source's authors and branches
+type ImportOptions struct {
+	// Authors maps the source's authors to evo identities. If set, authors
+	// missing from it stop the import.
+	Authors map[string]identity.Identity
+	// Streams maps branches to streams; an empty stream skips a branch.
+	// Others are imported into streams of their name, with "/" replaced by
+	// "-", the trunk into main.
+	Streams map[string]string
+}
+
+// Import imports the revisions of a source the repository doesn't have yet.
+// The commit each revision became is recorded in .evo/imports, so importing
+// the same source again continues where the last import stopped. Imported
+// streams are continued, and the working tree must be empty or hold the
+// current stream.
+func Import(repoPath string, src Source, opts ImportOptions) (*ImportResult, error) {
+	state, err := loadImportState(repoPath, src.ID())
+	if err != nil {
+		return nil, err
+	}
+	defer state.close()
+	im, err := NewImporter(repoPath, true)
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportResult{}
+	err = src.Changes(state.last, im.Blob, func(ch *Change) error {
+		stream := opts.stream(src, ch.Branch)
+		if stream == "" {
+			return nil
+		}
+		if ch.Tag != "" {
+			id := state.at(stream, ch.Rev)
+			if id == "" {
+				logger.Warn("skipping tag of a revision not imported", "tag", ch.Tag, "rev", ch.Rev)
+				return nil
+			}
+			if err := revparse.WriteTag(repoPath, ch.Tag, id); err != nil {
+				logger.Warn("skipping tag", "tag", ch.Tag, "err", err)
+				return nil
+			}
+			res.Tags = append(res.Tags, ch.Tag)
+			return nil
+		}
+		if ch.Rev <= state.last {
+			return nil
+		}
+		author, err := opts.author(ch.Author)
+		if err != nil {
+			return err
+		}
+		files := make(map[string]string)
+		if !ch.Reset {
+			base, err := im.Tree(stream)
+			if err != nil {
+				return err
+			}
+			for p, blob := range base {
+				files[p] = blob
+			}
+		}
+		for _, p := range ch.Deleted {
+			removePath(files, p)
+		}
+		for p, blob := range ch.Files {
+			files[p] = blob
+		}
+		c, err := im.Commit(&Revision{
+			Stream:      stream,
+			Message:     ch.Message,
+			AuthorName:  author.Name,
+			AuthorEmail: author.Email,
+			Time:        ch.Time,
+			Files:       files,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to import revision %d: %w", ch.Rev, err)
+		}
+		if err := state.record(ch.Rev, stream, c.ID); err != nil {
+			return err
+		}
+		if !slices.Contains(res.Streams, stream) {
+			res.Streams = append(res.Streams, stream)
+		}
+		res.Commits++
+		return nil
+	})
+	if err != nil {
+		im.Close("", nil)
+		return nil, err
+	}
+
+	head, err := streams.CurrentStream(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if state.streams[head] == nil {
+		switch {
+		case state.streams["main"] != nil:
+			head = "main"
+		case len(res.Streams) > 0:
+			head = res.Streams[0]
+		}
+	}
+	files, err := im.Tree(head)
+	if err != nil {
+		return nil, err
+	}
+	if err := im.Close(head, files); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (o *ImportOptions) stream(src Source, branch string) string {
+	if s, ok := o.Streams[branch]; ok {
+		return s
+	}
+	if branch == src.Trunk() {
+		return "main"
+	}
+	return strings.ReplaceAll(branch, "/", "-")
+}
+
+// author maps a source's author, taking one written as "Name <email>" as is
+// and using a bare user name as both name and email if it isn't mapped
+func (o *ImportOptions) author(s string) (identity.Identity, error) {
+	if o.Authors != nil {
+		id, ok := o.Authors[s]
+		if !ok {
+			return id, fmt.Errorf("author %q is missing from the authors file", s)
+		}
+		return id, nil
+	}
+	if id, err := identity.Parse(s); err == nil {
+		return id, nil
+	}
+	return identity.Identity{Name: s, Email: s}, nil
+}
+
+// LoadAuthors reads an authors file: lines "user = Name <email>", as git svn
+// reads them, with # starting a comment
+func LoadAuthors(path string) (map[string]identity.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authors file: %w", err)
+	}
+	authors := make(map[string]identity.Identity)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, who, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("authors file line %d: expected \"user = Name <email>\"", i+1)
+		}
+		id, err := identity.Parse(who)
+		if err != nil {
+			return nil, fmt.Errorf("authors file line %d: %w", i+1, err)
+		}
+		authors[strings.TrimSpace(user)] = id
+	}
+	return authors, nil
+}
+
+const importMagic = "evo-import 1"
+
+// importState is the record of a source's imported revisions, a file under
+// .evo/imports named after the source: a header naming it, then a line
+// "<rev> <stream> <commit ID>" per imported change
+type importState struct {
+	f       *os.File
+	last    int64
+	streams map[string][]importedRev // stream => revisions, in order
+}
+
+type importedRev struct {
+	rev int64
+	id  string
+}
+
+func loadImportState(repoPath, source string) (*importState, error) {
+	dir := filepath.Join(repo.Dir(repoPath), "imports")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%x", sha256.Sum256([]byte(source)))[:16])
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open import state: %w", err)
+	}
+	st := &importState{f: f, last: -1, streams: make(map[string][]importedRev)}
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		if _, err := fmt.Fprintf(f, "%s %s\n", importMagic, source); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return st, nil
+	}
+	if sc.Text() != importMagic+" "+source {
+		f.Close()
+		return nil, fmt.Errorf("unexpected import state header in %s", path)
+	}
+	for sc.Scan() {
+		parts := strings.Fields(sc.Text())
+		if len(parts) != 3 {
+			continue
+		}
+		rev, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		st.streams[parts[1]] = append(st.streams[parts[1]], importedRev{rev, parts[2]})
+		st.last = max(st.last, rev)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read import state: %w", err)
+	}
+	return st, nil
+}
+
+func (st *importState) record(rev int64, stream, id string) error {
+	if _, err := fmt.Fprintf(st.f, "%d %s %s\n", rev, stream, id); err != nil {
+		return fmt.Errorf("failed to record imported revision: %w", err)
+	}
+	st.streams[stream] = append(st.streams[stream], importedRev{rev, id})
+	st.last = max(st.last, rev)
+	return nil
+}
+
+// at returns the commit holding a stream as of a revision: that of the last
+// revision at or before it which changed the stream
+func (st *importState) at(stream string, rev int64) string {
+	id := ""
+	for _, r := range st.streams[stream] {
+		if r.rev > rev {
+			break
+		}
+		id = r.id
+	}
+	return id
+}
+
+func (st *importState) close() {
+	st.f.Close()
+}
@@ -0,0 +1,135 @@
+package interop
+
+import (
+	"evo/internal/identity"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memSource serves changes whose files are given by content
+type memSource struct {
+	revs []memRev
+}
+
+type memRev struct {
+	Change
+	content map[string]string
+}
+
+func (m *memSource) ID() string    { return "mem" }
+func (m *memSource) Trunk() string { return "trunk" }
+
+func (m *memSource) Changes(after int64, store Store, fn func(*Change) error) error {
+	for _, r := range m.revs {
+		if r.Tag == "" && r.Rev <= after {
+			continue
+		}
+		ch := r.Change
+		ch.Files = make(map[string]string)
+		for p, content := range r.content {
+			blob, err := store(strings.NewReader(content))
+			if err != nil {
+				return err
+			}
+			ch.Files[p] = blob
+		}
+		if err := fn(&ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rev := func(n int64, branch, author string, content map[string]string, deleted ...string) memRev {
+		return memRev{Change: Change{Rev: n, Branch: branch, Author: author, Time: base.Add(time.Duration(n) * time.Hour), Message: fmt.Sprintf("r%d", n), Deleted: deleted}, content: content}
+	}
+	src := &memSource{revs: []memRev{
+		rev(1, "trunk", "ann", map[string]string{"a.txt": "one\n", "dir/b.txt": "b\n"}),
+		rev(2, "trunk", "bob", map[string]string{"a.txt": "two\n"}, "dir"),
+	}}
+	authors := filepath.Join(t.TempDir(), "authors")
+	require.NoError(t, os.WriteFile(authors, []byte("# svn users\nann = Ann Lee <ann@example.com>\n"), 0644))
+	ids, err := LoadAuthors(authors)
+	require.NoError(t, err)
+
+	// bob is not mapped
+	opts := ImportOptions{Authors: ids}
+	_, err = Import(rp, src, opts)
+	assert.ErrorContains(t, err, `author "bob"`)
+
+	// the working tree was left at the first revision, which resuming accepts
+	ids["bob"] = identity.Identity{Name: "Bob", Email: "bob@example.com"}
+	res, err := Import(rp, src, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Commits)
+	cs, err := streams.ListCommits(rp, "main")
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+	assert.Equal(t, "Ann Lee", cs[0].AuthorName)
+	assert.Equal(t, "Bob", cs[1].AuthorName)
+	data, err := os.ReadFile(filepath.Join(rp, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(data))
+	_, err = os.Stat(filepath.Join(rp, "dir"))
+	assert.True(t, os.IsNotExist(err))
+
+	// nothing new
+	res, err = Import(rp, src, opts)
+	require.NoError(t, err)
+	assert.Zero(t, res.Commits)
+
+	// new revisions: a branch from trunk, one skipped, a tag
+	src.revs = append(src.revs,
+		rev(3, "feature/x", "ann", map[string]string{"c.txt": "c\n"}),
+		rev(4, "vendor", "ann", map[string]string{"v.txt": "v\n"}),
+		rev(5, "trunk", "ann", map[string]string{"a.txt": "three\n"}),
+		memRev{Change: Change{Rev: 4, Branch: "trunk", Tag: "v1"}},
+	)
+	opts.Streams = map[string]string{"vendor": ""}
+	res, err = Import(rp, src, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Commits)
+	assert.Equal(t, []string{"feature-x", "main"}, res.Streams)
+	assert.Equal(t, []string{"v1"}, res.Tags)
+
+	fx, err := streams.ListCommits(rp, "feature-x")
+	require.NoError(t, err)
+	require.Len(t, fx, 1)
+	_, err = os.Stat(filepath.Join(rp, ".evo", "streams", "vendor"))
+	assert.True(t, os.IsNotExist(err))
+
+	// v1 is trunk as of revision 4: revision 2
+	tags, err := revparse.Tags(rp)
+	require.NoError(t, err)
+	assert.Equal(t, cs[1].ID, tags["v1"])
+
+	data, err = os.ReadFile(filepath.Join(rp, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "three\n", string(data))
+
+	// changes in the working tree stop an import
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("edited\n"), 0644))
+	_, err = Import(rp, src, opts)
+	assert.ErrorContains(t, err, "not committed")
+}
+
+func TestLoadAuthors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authors")
+	require.NoError(t, os.WriteFile(path, []byte("jdoe = John Doe <jd@example.com>\nbad line\n"), 0644))
+	_, err := LoadAuthors(path)
+	assert.ErrorContains(t, err, "line 2")
+}
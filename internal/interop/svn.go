@@ -0,0 +1,302 @@
+package interop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SVN reads a Subversion repository through the svn command. With a layout,
+// TrunkDir holds the trunk and BranchesDir and TagsDir a directory per branch
+// and tag; without one, TrunkDir empty, the whole URL is the trunk.
+type SVN struct {
+	URL         string
+	TrunkDir    string
+	BranchesDir string
+	TagsDir     string
+}
+
+// NewSVN returns the source for a repository with the standard layout
+func NewSVN(url string) *SVN {
+	return &SVN{URL: strings.TrimRight(url, "/"), TrunkDir: "trunk", BranchesDir: "branches", TagsDir: "tags"}
+}
+
+func (s *SVN) ID() string    { return "svn " + s.URL }
+func (s *SVN) Trunk() string { return "trunk" }
+
+// svnEntry is a revision as svn log --xml -v lists it
+type svnEntry struct {
+	Rev    int64     `xml:"revision,attr"`
+	Author string    `xml:"author"`
+	Date   time.Time `xml:"date"`
+	Msg    string    `xml:"msg"`
+	Paths  []svnPath `xml:"paths>path"`
+}
+
+type svnPath struct {
+	Path     string `xml:",chardata"`
+	Action   string `xml:"action,attr"` // A, M, D or R
+	Kind     string `xml:"kind,attr"`   // file or dir
+	CopyPath string `xml:"copyfrom-path,attr"`
+	CopyRev  int64  `xml:"copyfrom-rev,attr"`
+}
+
+func (s *SVN) Changes(after int64, store Store, fn func(*Change) error) error {
+	info := func(item string) (string, error) {
+		out, err := run("svn", "info", "--non-interactive", "--show-item", item, s.URL)
+		return strings.TrimSpace(string(out)), err
+	}
+	head, err := info("revision")
+	if err != nil {
+		return err
+	}
+	last, err := strconv.ParseInt(head, 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected revision %q", head)
+	}
+	root, err := info("repos-root-url")
+	if err != nil {
+		return err
+	}
+	rel, err := info("relative-url")
+	if err != nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(strings.TrimPrefix(rel, "^"), "/") + "/"
+	start := max(after+1, 1)
+	if start > last {
+		return nil
+	}
+
+	cmd := exec.Command("svn", "log", "--non-interactive", "--xml", "-v", "-r", fmt.Sprintf("%d:%d", start, last), s.URL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run svn: %w", err)
+	}
+	err = readSVNLog(out, func(e *svnEntry) error {
+		changes, err := s.changes(e, prefix, func(path string, rev int64) ([]string, error) {
+			return s.list(root+path, rev)
+		}, func(path string, rev int64) (string, error) {
+			return s.cat(root+path, rev, store)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read revision %d: %w", e.Rev, err)
+		}
+		for _, ch := range changes {
+			if err := fn(ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("svn log: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// readSVNLog decodes the entries of svn log --xml one at a time
+func readSVNLog(r io.Reader, fn func(*svnEntry) error) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read svn log: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "logentry" {
+			continue
+		}
+		var e svnEntry
+		if err := dec.DecodeElement(&e, &start); err != nil {
+			return fmt.Errorf("failed to read svn log: %w", err)
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+}
+
+// locate splits a repository path into the branch or tag it belongs to and
+// the path inside it
+func (s *SVN) locate(path, prefix string) (branch, tag, inner string, ok bool) {
+	rel, ok := strings.CutPrefix(path+"/", prefix)
+	if !ok {
+		return "", "", "", false
+	}
+	rel = strings.TrimSuffix(rel, "/")
+	if s.TrunkDir == "" {
+		return "trunk", "", rel, true
+	}
+	split := func(dir string) (string, string, bool) {
+		rest, ok := strings.CutPrefix(rel, dir+"/")
+		if !ok || dir == "" {
+			return "", "", false
+		}
+		name, inner, _ := strings.Cut(rest, "/")
+		return name, inner, true
+	}
+	if rel == s.TrunkDir || strings.HasPrefix(rel, s.TrunkDir+"/") {
+		return "trunk", "", strings.TrimPrefix(strings.TrimPrefix(rel, s.TrunkDir), "/"), true
+	}
+	if name, inner, ok := split(s.BranchesDir); ok {
+		return name, "", inner, true
+	}
+	if name, inner, ok := split(s.TagsDir); ok {
+		return "", name, inner, true
+	}
+	return "", "", "", false
+}
+
+// changes turns a revision into a change per branch it touched, reading the
+// files it added or changed with cat and the files of copied directories
+// with list, followed by the tags it created
+func (s *SVN) changes(e *svnEntry, prefix string, list func(path string, rev int64) ([]string, error), cat func(path string, rev int64) (string, error)) ([]*Change, error) {
+	var out, tags []*Change
+	byBranch := make(map[string]*Change)
+	for _, p := range e.Paths {
+		branch, tag, inner, ok := s.locate(p.Path, prefix)
+		if !ok {
+			continue
+		}
+		if tag != "" {
+			if inner == "" && p.CopyPath != "" && (p.Action == "A" || p.Action == "R") {
+				if from, _, _, ok := s.locate(p.CopyPath, prefix); ok && from != "" {
+					tags = append(tags, &Change{Rev: p.CopyRev, Branch: from, Tag: tag})
+				}
+			}
+			continue
+		}
+		ch := byBranch[branch]
+		if ch == nil {
+			ch = &Change{Rev: e.Rev, Branch: branch, Author: e.Author, Time: e.Date, Message: e.Msg, Files: make(map[string]string)}
+			if ch.Author == "" {
+				ch.Author = "(no author)"
+			}
+			byBranch[branch] = ch
+			out = append(out, ch)
+		}
+		if ch.Reset {
+			// the branch was listed whole
+			continue
+		}
+		if inner == "" {
+			if p.Action == "A" || p.Action == "R" {
+				// a new branch => take every file it holds
+				ch.Reset = true
+				clear(ch.Files)
+				ch.Deleted = nil
+				if err := s.addTree(ch, "", p.Path, e.Rev, list, cat); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if p.Action == "D" || p.Action == "R" {
+			ch.Deleted = append(ch.Deleted, inner)
+			removePath(ch.Files, inner)
+		}
+		if p.Action == "D" {
+			continue
+		}
+		switch {
+		case p.Kind == "dir" && p.CopyPath != "" && p.Action != "M":
+			if err := s.addTree(ch, inner, p.Path, e.Rev, list, cat); err != nil {
+				return nil, err
+			}
+		case p.Kind != "dir":
+			blob, err := cat(p.Path, e.Rev)
+			if err != nil {
+				return nil, err
+			}
+			ch.Files[inner] = blob
+		}
+	}
+	return append(out, tags...), nil
+}
+
+// addTree adds the files under a directory as of a revision to a change
+func (s *SVN) addTree(ch *Change, inner, path string, rev int64, list func(string, int64) ([]string, error), cat func(string, int64) (string, error)) error {
+	files, err := list(path, rev)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		blob, err := cat(path+"/"+f, rev)
+		if err != nil {
+			return err
+		}
+		if inner != "" {
+			f = inner + "/" + f
+		}
+		ch.Files[f] = blob
+	}
+	return nil
+}
+
+// list returns the files under a directory URL as of a revision
+func (s *SVN) list(url string, rev int64) ([]string, error) {
+	out, err := run("svn", "list", "--non-interactive", "-R", fmt.Sprintf("%s@%d", url, rev))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if f := sc.Text(); f != "" && !strings.HasSuffix(f, "/") {
+			files = append(files, f)
+		}
+	}
+	return files, sc.Err()
+}
+
+// cat stores the content of a file URL as of a revision
+func (s *SVN) cat(url string, rev int64, store Store) (string, error) {
+	cmd := exec.Command("svn", "cat", "--non-interactive", fmt.Sprintf("%s@%d", url, rev))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to run svn: %w", err)
+	}
+	blob, err := store(out)
+	if werr := cmd.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("svn cat %s: %w: %s", url, werr, strings.TrimSpace(stderr.String()))
+	}
+	return blob, err
+}
+
+// run runs a command and returns its output, with its error output in the
+// error if it fails
+func run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package interop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const svnLog = `<?xml version="1.0" encoding="UTF-8"?>
+<log>
+<logentry revision="7">
+<author>ann</author>
+<date>2021-03-04T05:06:07.123456Z</date>
+<paths>
+<path action="M" prop-mods="false" text-mods="true" kind="file">/proj/trunk/a.txt</path>
+<path action="D" prop-mods="false" text-mods="false" kind="dir">/proj/trunk/old</path>
+<path action="A" prop-mods="false" text-mods="false" kind="dir" copyfrom-path="/proj/trunk/lib" copyfrom-rev="6">/proj/trunk/lib2</path>
+<path action="A" prop-mods="false" text-mods="false" kind="dir" copyfrom-path="/proj/trunk" copyfrom-rev="6">/proj/branches/fix</path>
+<path action="A" prop-mods="false" text-mods="false" kind="dir" copyfrom-path="/proj/trunk" copyfrom-rev="5">/proj/tags/v1.0</path>
+<path action="M" prop-mods="true" text-mods="false" kind="dir">/proj/trunk</path>
+<path action="A" prop-mods="false" text-mods="true" kind="file">/other/x.txt</path>
+</paths>
+<msg>many things</msg>
+</logentry>
+</log>`
+
+func TestSVNChanges(t *testing.T) {
+	var entries []*svnEntry
+	require.NoError(t, readSVNLog(strings.NewReader(svnLog), func(e *svnEntry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+	e := entries[0]
+	assert.Equal(t, int64(7), e.Rev)
+	assert.Equal(t, "ann", e.Author)
+	assert.Equal(t, 2021, e.Date.Year())
+	require.Len(t, e.Paths, 7)
+
+	trees := map[string][]string{
+		"/proj/trunk/lib":    {"l.go", "sub/m.go"},
+		"/proj/trunk/lib2":   {"l.go", "sub/m.go"},
+		"/proj/branches/fix": {"a.txt", "lib/l.go"},
+	}
+	list := func(path string, rev int64) ([]string, error) {
+		assert.Equal(t, int64(7), rev)
+		return trees[path], nil
+	}
+	cat := func(path string, rev int64) (string, error) {
+		return fmt.Sprintf("%s@%d", path, rev), nil
+	}
+	chs, err := NewSVN("svn://host/proj").changes(e, "/proj/", list, cat)
+	require.NoError(t, err)
+	require.Len(t, chs, 3)
+
+	trunk := chs[0]
+	assert.Equal(t, "trunk", trunk.Branch)
+	assert.False(t, trunk.Reset)
+	assert.Equal(t, []string{"old"}, trunk.Deleted)
+	var paths []string
+	for p := range trunk.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"a.txt", "lib2/l.go", "lib2/sub/m.go"}, paths)
+	assert.Equal(t, "/proj/trunk/lib2/sub/m.go@7", trunk.Files["lib2/sub/m.go"])
+
+	fix := chs[1]
+	assert.Equal(t, "fix", fix.Branch)
+	assert.True(t, fix.Reset)
+	assert.Len(t, fix.Files, 2)
+
+	tag := chs[2]
+	assert.Equal(t, Change{Rev: 5, Branch: "trunk", Tag: "v1.0"}, *tag)
+
+	// without a layout everything is trunk
+	chs, err = (&SVN{URL: "svn://host/proj"}).changes(e, "/proj/", list, cat)
+	require.NoError(t, err)
+	require.Len(t, chs, 1)
+	assert.Contains(t, chs[0].Files, "trunk/a.txt")
+	assert.Contains(t, chs[0].Files, "branches/fix/lib/l.go")
+}
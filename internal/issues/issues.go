@@ -0,0 +1,227 @@
+package issues
+
+import (
+	"evo/internal/eventlog"
+	"evo/internal/log"
+	"evo/internal/trailers"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("issues")
+
+// Each issue is an event log (see package eventlog) in .evo/issues/<id>.json:
+// fields are last-writer-wins by (Lamport, NodeID), and comments and commit
+// links only grow, so replicas merge an issue by taking the union of its
+// events, like code ops.
+
+// Issue states
+const (
+	StateOpen   = "open"
+	StateClosed = "closed"
+)
+
+// Event kinds
+const (
+	KindSet     = "set"     // Field = Value
+	KindComment = "comment" // Value is the comment text
+	KindLink    = "link"    // Value is a commit ID that references the issue
+)
+
+// Author is who makes a change
+type Author = eventlog.Author
+
+// Comment is a comment on an issue
+type Comment struct {
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+	Text        string    `json:"text"`
+}
+
+// Issue is the state obtained by replaying an issue's events
+type Issue struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body,omitempty"`
+	State       string    `json:"state"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Comments    []Comment `json:"comments,omitempty"`
+	Commits     []string  `json:"commits,omitempty"`
+}
+
+func store(repoPath string) *eventlog.Store {
+	return eventlog.New(repoPath, "issues")
+}
+
+// replay folds events into the issue state
+func replay(id string, evs []eventlog.Event) *Issue {
+	is := &Issue{ID: id, State: StateOpen}
+	seen := make(map[string]bool)
+	for i, e := range evs {
+		if i == 0 {
+			is.AuthorName, is.AuthorEmail, is.Created = e.AuthorName, e.AuthorEmail, e.Timestamp
+		}
+		if e.Timestamp.After(is.Updated) {
+			is.Updated = e.Timestamp
+		}
+		switch e.Kind {
+		case KindSet:
+			switch e.Field {
+			case "title":
+				is.Title = e.Value
+			case "body":
+				is.Body = e.Value
+			case "state":
+				is.State = e.Value
+			}
+		case KindComment:
+			is.Comments = append(is.Comments, Comment{e.AuthorName, e.AuthorEmail, e.Timestamp, e.Value})
+		case KindLink:
+			if !seen[e.Value] {
+				seen[e.Value] = true
+				is.Commits = append(is.Commits, e.Value)
+			}
+		}
+	}
+	return is
+}
+
+// Create opens a new issue
+func Create(repoPath, title, body string, who Author) (*Issue, error) {
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("issue title must not be empty")
+	}
+	id := uuid.New().String()
+	evs := []eventlog.Event{
+		{Kind: KindSet, Field: "title", Value: title},
+		{Kind: KindSet, Field: "state", Value: StateOpen},
+	}
+	if body != "" {
+		evs = append(evs, eventlog.Event{Kind: KindSet, Field: "body", Value: body})
+	}
+	if err := store(repoPath).Append(id, who, evs...); err != nil {
+		return nil, err
+	}
+	return Get(repoPath, id)
+}
+
+// Get loads an issue by its full ID
+func Get(repoPath, id string) (*Issue, error) {
+	evs, err := store(repoPath).Load(id)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no issue %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replay(id, evs), nil
+}
+
+// Resolve expands a unique ID prefix, optionally written as #prefix
+func Resolve(repoPath, prefix string) (string, error) {
+	return store(repoPath).Resolve(prefix)
+}
+
+// List returns every issue, newest first
+func List(repoPath string) ([]*Issue, error) {
+	all, err := store(repoPath).IDs()
+	if err != nil {
+		return nil, err
+	}
+	var out []*Issue
+	for _, id := range all {
+		is, err := Get(repoPath, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, is)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out, nil
+}
+
+// SetState closes or reopens an issue. A non-empty commitID records the commit
+// that closed it.
+func SetState(repoPath, id, state, commitID string, who Author) error {
+	if state != StateOpen && state != StateClosed {
+		return fmt.Errorf("invalid issue state %q", state)
+	}
+	if _, err := Get(repoPath, id); err != nil {
+		return err
+	}
+	evs := []eventlog.Event{{Kind: KindSet, Field: "state", Value: state}}
+	if commitID != "" {
+		evs = append(evs, eventlog.Event{Kind: KindLink, Value: commitID})
+	}
+	return store(repoPath).Append(id, who, evs...)
+}
+
+// AddComment comments on an issue
+func AddComment(repoPath, id, text string, who Author) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("comment must not be empty")
+	}
+	if _, err := Get(repoPath, id); err != nil {
+		return err
+	}
+	return store(repoPath).Append(id, who, eventlog.Event{Kind: KindComment, Value: text})
+}
+
+// Link records that a commit references an issue
+func Link(repoPath, id, commitID string, who Author) error {
+	if _, err := Get(repoPath, id); err != nil {
+		return err
+	}
+	return store(repoPath).Append(id, who, eventlog.Event{Kind: KindLink, Value: commitID})
+}
+
+// closingKeys are the trailers that close the issues they name
+var closingKeys = []string{"Closes", "Fixes", "Resolves"}
+
+// ApplyTrailers closes the issues named by Closes, Fixes or Resolves trailers
+// of a commit and links the ones named by Refs. Unknown issues are skipped
+// with a warning, since the commit already exists. It returns the IDs of the
+// closed issues.
+func ApplyTrailers(repoPath string, c *types.Commit) ([]string, error) {
+	who := Author{Name: c.AuthorName, Email: c.AuthorEmail}
+	var closed []string
+	for _, key := range closingKeys {
+		for _, v := range trailers.Values(c.Trailers, key) {
+			id, err := Resolve(repoPath, v)
+			if err != nil {
+				logger.Warn("skipping issue reference", "commit", c.ID, "trailer", key, "issue", v, "err", err)
+				continue
+			}
+			if err := SetState(repoPath, id, StateClosed, c.ID, who); err != nil {
+				return closed, err
+			}
+			closed = append(closed, id)
+		}
+	}
+	for _, v := range trailers.Values(c.Trailers, trailers.Refs) {
+		id, err := Resolve(repoPath, v)
+		if err != nil {
+			continue // Refs may name external trackers
+		}
+		if err := Link(repoPath, id, c.ID, who); err != nil {
+			return closed, err
+		}
+	}
+	return closed, nil
+}
+
+// Sync merges the issues of another repository on disk into this one and
+// back. It returns how many events were added here and there.
+func Sync(repoPath, otherPath string) (int, int, error) {
+	return store(repoPath).Sync(otherPath)
+}
@@ -0,0 +1,91 @@
+package issues
+
+import (
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ann = Author{Name: "Ann", Email: "ann@example.com"}
+
+func TestLifecycle(t *testing.T) {
+	repo := t.TempDir()
+	is, err := Create(repo, "Crash on empty file", "steps...", ann)
+	assert.NoError(t, err)
+	assert.Equal(t, StateOpen, is.State)
+	assert.Equal(t, "Ann", is.AuthorName)
+	_, err = Create(repo, " ", "", ann)
+	assert.Error(t, err)
+
+	id, err := Resolve(repo, "#"+is.ID[:6])
+	assert.NoError(t, err)
+	assert.Equal(t, is.ID, id)
+	_, err = Resolve(repo, "zzzz")
+	assert.Error(t, err)
+
+	assert.NoError(t, AddComment(repo, id, "reproduced", ann))
+	assert.NoError(t, SetState(repo, id, StateClosed, "", ann))
+	is, err = Get(repo, id)
+	assert.NoError(t, err)
+	assert.Equal(t, StateClosed, is.State)
+	assert.Len(t, is.Comments, 1)
+
+	list, err := List(repo)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestApplyTrailers(t *testing.T) {
+	repo := t.TempDir()
+	a, _ := Create(repo, "first", "", ann)
+	b, _ := Create(repo, "second", "", ann)
+	c := &types.Commit{ID: "c1", AuthorName: "Bo", AuthorEmail: "bo@example.com", Trailers: []types.Trailer{
+		{Key: "Fixes", Value: "#" + a.ID[:8]},
+		{Key: "Refs", Value: b.ID[:8]},
+		{Key: "Closes", Value: "unknown"},
+		{Key: "Refs", Value: "JIRA-1"},
+	}}
+	closed, err := ApplyTrailers(repo, c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{a.ID}, closed)
+
+	a, _ = Get(repo, a.ID)
+	assert.Equal(t, StateClosed, a.State)
+	assert.Equal(t, []string{"c1"}, a.Commits)
+	b, _ = Get(repo, b.ID)
+	assert.Equal(t, StateOpen, b.State)
+	assert.Equal(t, []string{"c1"}, b.Commits)
+}
+
+func TestSync(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	for _, dir := range []string{a, b} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".evo"), 0755))
+	}
+	is, err := Create(a, "shared", "", ann)
+	assert.NoError(t, err)
+	pulled, pushed, err := Sync(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pulled)
+	assert.Equal(t, 2, pushed)
+
+	// concurrent edits on both replicas merge
+	assert.NoError(t, AddComment(a, is.ID, "from a", ann))
+	assert.NoError(t, AddComment(b, is.ID, "from b", Author{Name: "Bo", Email: "bo@example.com"}))
+	assert.NoError(t, SetState(b, is.ID, StateClosed, "", ann))
+	_, _, err = Sync(a, b)
+	assert.NoError(t, err)
+
+	ia, _ := Get(a, is.ID)
+	ib, _ := Get(b, is.ID)
+	assert.Equal(t, ia, ib)
+	assert.Len(t, ia.Comments, 2)
+	assert.Equal(t, StateClosed, ia.State)
+
+	pulled, pushed, err = Sync(a, b)
+	assert.NoError(t, err)
+	assert.Zero(t, pulled+pushed)
+}
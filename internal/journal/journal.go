@@ -0,0 +1,323 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/everrors"
+	"evo/internal/materialize"
+	"evo/internal/ops"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The journal lives in .evo/journal, one JSON entry per line, oldest first.
+// Op logs and commits are append-only, so a mutation is inverted by truncating
+// the op logs it grew, removing the commit files it wrote and restoring HEAD.
+
+// Entry records one repo-mutating command
+type Entry struct {
+	ID          string           `json:"id"`
+	Kind        string           `json:"kind"` // commit, merge, cherry-pick, revert
+	Description string           `json:"description"`
+	Timestamp   time.Time        `json:"timestamp"`
+	Head        string           `json:"head"`    // HEAD before the mutation
+	Commits     []string         `json:"commits"` // commit files written, relative to .evo
+	OpLogs      map[string]int64 `json:"opLogs"`  // op log => size before (-1 if new)
+	OpLogsAfter map[string]int64 `json:"opLogsAfter"`
+	Files       map[string]*File `json:"files,omitempty"` // working tree files => content before
+}
+
+// File is the prior state of a working tree file touched by a mutation
+type File struct {
+	Existed bool   `json:"existed"`
+	Content []byte `json:"content,omitempty"`
+}
+
+// Recorder captures repository state before a mutation
+type Recorder struct {
+	repoPath string
+	entry    Entry
+	opSizes  map[string]int64
+	commits  map[string]bool
+}
+
+func evoPath(repoPath string, parts ...string) string {
+	return filepath.Join(append([]string{repo.Dir(repoPath)}, parts...)...)
+}
+
+// Begin snapshots op log sizes, commit files and HEAD before a mutation
+func Begin(repoPath, kind, description string) (*Recorder, error) {
+	head, err := os.ReadFile(evoPath(repoPath, "HEAD"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	opSizes, err := logSizes(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := commits.Stored(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(stored))
+	for p := range stored {
+		held[p] = true
+	}
+	return &Recorder{
+		repoPath: repoPath,
+		entry: Entry{
+			ID:          uuid.New().String(),
+			Kind:        kind,
+			Description: description,
+			Head:        strings.TrimSpace(string(head)),
+		},
+		opSizes: opSizes,
+		commits: held,
+	}, nil
+}
+
+// TrackFile remembers the current content of a working tree file the mutation
+// is about to change, so undo can restore it
+func (r *Recorder) TrackFile(relPath string) error {
+	if r.entry.Files == nil {
+		r.entry.Files = make(map[string]*File)
+	}
+	if _, ok := r.entry.Files[relPath]; ok {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.repoPath, relPath))
+	if os.IsNotExist(err) {
+		r.entry.Files[relPath] = &File{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.entry.Files[relPath] = &File{Existed: true, Content: data}
+	return nil
+}
+
+// Finish diffs the repository against the snapshot and appends the entry.
+// Mutations that changed nothing are not recorded.
+func (r *Recorder) Finish() error {
+	opSizes, err := logSizes(r.repoPath)
+	if err != nil {
+		return err
+	}
+	stored, err := commits.Stored(r.repoPath)
+	if err != nil {
+		return err
+	}
+
+	r.entry.OpLogs = make(map[string]int64)
+	r.entry.OpLogsAfter = make(map[string]int64)
+	for p, size := range opSizes {
+		before, ok := r.opSizes[p]
+		if !ok {
+			before = -1
+		}
+		if before != size {
+			r.entry.OpLogs[p] = before
+			r.entry.OpLogsAfter[p] = size
+		}
+	}
+	for p := range stored {
+		if !r.commits[p] {
+			r.entry.Commits = append(r.entry.Commits, p)
+		}
+	}
+	head, _ := os.ReadFile(evoPath(r.repoPath, "HEAD"))
+	headChanged := strings.TrimSpace(string(head)) != r.entry.Head
+	if len(r.entry.OpLogs) == 0 && len(r.entry.Commits) == 0 && len(r.entry.Files) == 0 && !headChanged {
+		return nil
+	}
+
+	r.entry.Timestamp = time.Now().UTC()
+	data, err := json.Marshal(r.entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(evoPath(r.repoPath, "journal"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// List returns all journal entries, oldest first
+func List(repoPath string) ([]Entry, error) {
+	f, err := os.Open(evoPath(repoPath, "journal"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, everrors.Errorf(everrors.ErrCorrupt, "corrupt journal entry: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+// Clear forgets every entry, as when history was rewritten and the recorded
+// state, which may hold removed content, no longer applies
+func Clear(repoPath string) error {
+	if err := os.Remove(evoPath(repoPath, "journal")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func save(repoPath string, entries []Entry) error {
+	var sb strings.Builder
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	tmp := evoPath(repoPath, "journal.tmp")
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, evoPath(repoPath, "journal"))
+}
+
+// Undo rolls back the last n journaled mutations, newest first, and returns
+// the entries that were undone. It refuses to touch an op log that changed
+// after the mutation was recorded.
+func Undo(repoPath string, n int) ([]Entry, error) {
+	entries, err := List(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, errors.New("nothing to undo")
+	}
+	if n > len(entries) {
+		return nil, fmt.Errorf("only %d operation(s) in the journal", len(entries))
+	}
+
+	var undone []Entry
+	for i := 0; i < n; i++ {
+		e := entries[len(entries)-1]
+		if err := undoEntry(repoPath, e); err != nil {
+			return undone, fmt.Errorf("failed to undo %s %q: %w", e.Kind, e.Description, err)
+		}
+		entries = entries[:len(entries)-1]
+		if err := save(repoPath, entries); err != nil {
+			return undone, err
+		}
+		undone = append(undone, e)
+	}
+	return undone, nil
+}
+
+func undoEntry(repoPath string, e Entry) error {
+	// check everything first so a refused undo leaves the repo untouched
+	for p, after := range e.OpLogsAfter {
+		size, _, err := ops.LogInfo(evoPath(repoPath, p))
+		if err != nil {
+			return fmt.Errorf("op log %s: %w", p, err)
+		}
+		if size != after {
+			return fmt.Errorf("op log %s changed since it was recorded", p)
+		}
+	}
+
+	for p, before := range e.OpLogs {
+		fp := evoPath(repoPath, p)
+		if before < 0 {
+			if err := ops.RemoveLog(fp); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := ops.TruncateLog(fp, before); err != nil {
+			return err
+		}
+	}
+	for _, p := range e.Commits {
+		parts := strings.Split(p, "/")
+		if len(parts) != 3 || parts[0] != "commits" {
+			continue
+		}
+		if err := commits.RemoveCommit(repoPath, parts[1], strings.TrimSuffix(parts[2], ".bin")); err != nil {
+			return err
+		}
+	}
+	for p, f := range e.Files {
+		fp := filepath.Join(repoPath, p)
+		if !f.Existed {
+			if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fp, f.Content, 0644); err != nil {
+			return err
+		}
+	}
+	if e.Head != "" {
+		if err := os.WriteFile(evoPath(repoPath, "HEAD"), []byte(e.Head), 0644); err != nil {
+			return err
+		}
+	}
+	// the hashes remembered for the files of the logs cut describe them as
+	// they were; they are recorded anew from the working tree
+	for p := range e.OpLogs {
+		parts := strings.Split(p, "/")
+		if len(parts) != 3 || parts[0] != "ops" {
+			continue
+		}
+		if err := materialize.Resync(repoPath, parts[1], strings.TrimSuffix(parts[2], ".bin")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logSizes maps every op log (relative to .evo) to its size, counting all
+// segments of a segmented log
+func logSizes(repoPath string) (map[string]int64, error) {
+	out := make(map[string]int64)
+	logs, err := ops.AllLogs(evoPath(repoPath, "ops"))
+	if err != nil {
+		return nil, err
+	}
+	root := evoPath(repoPath)
+	for _, l := range logs {
+		rel, _ := filepath.Rel(root, l)
+		out[filepath.ToSlash(rel)] = ops.LogSize(l)
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRepo(t *testing.T) string {
+	repoPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".evo", "ops", "main"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".evo", "commits", "main"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, ".evo", "HEAD"), []byte("main"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, ".evo", "ops", "main", "a.bin"), []byte("0123"), 0644))
+	return repoPath
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(data)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func TestUndo(t *testing.T) {
+	repoPath := setupRepo(t)
+	opLog := filepath.Join(repoPath, ".evo", "ops", "main", "a.bin")
+	newLog := filepath.Join(repoPath, ".evo", "ops", "main", "b.bin")
+	commitFile := filepath.Join(repoPath, ".evo", "commits", "main", "c1.bin")
+	workFile := filepath.Join(repoPath, "file.txt")
+	assert.NoError(t, os.WriteFile(workFile, []byte("before"), 0644))
+
+	rec, err := Begin(repoPath, "commit", "first")
+	assert.NoError(t, err)
+	assert.NoError(t, rec.TrackFile("file.txt"))
+	appendFile(t, opLog, "4567")
+	appendFile(t, newLog, "xyz")
+	assert.NoError(t, os.WriteFile(commitFile, []byte("{}"), 0644))
+	assert.NoError(t, os.WriteFile(workFile, []byte("after"), 0644))
+	assert.NoError(t, rec.Finish())
+
+	entries, err := List(repoPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(4), entries[0].OpLogs["ops/main/a.bin"])
+	assert.Equal(t, int64(-1), entries[0].OpLogs["ops/main/b.bin"])
+
+	undone, err := Undo(repoPath, 1)
+	assert.NoError(t, err)
+	assert.Len(t, undone, 1)
+
+	data, err := os.ReadFile(opLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(data))
+	assert.NoFileExists(t, newLog)
+	assert.NoFileExists(t, commitFile)
+	data, err = os.ReadFile(workFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "before", string(data))
+
+	entries, err = List(repoPath)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestUndoRefusesChangedLog(t *testing.T) {
+	repoPath := setupRepo(t)
+	opLog := filepath.Join(repoPath, ".evo", "ops", "main", "a.bin")
+
+	rec, err := Begin(repoPath, "merge", "merge feature into main")
+	assert.NoError(t, err)
+	appendFile(t, opLog, "4567")
+	assert.NoError(t, rec.Finish())
+
+	// an unjournaled write after the merge
+	appendFile(t, opLog, "89")
+
+	_, err = Undo(repoPath, 1)
+	assert.Error(t, err)
+	data, err := os.ReadFile(opLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+}
+
+func TestNoopNotRecorded(t *testing.T) {
+	repoPath := setupRepo(t)
+	rec, err := Begin(repoPath, "merge", "nothing")
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Finish())
+
+	entries, err := List(repoPath)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = Undo(repoPath, 1)
+	assert.Error(t, err)
+}
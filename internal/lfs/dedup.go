@@ -0,0 +1,165 @@
+package lfs
+
+import (
+	"evo/internal/index"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DedupReport compares the content files point to with the chunks holding
+// it, to tell how much chunking saves and where
+type DedupReport struct {
+	Files      int          `json:"files"`
+	Chunks     int          `json:"chunks"`    // distinct chunks the files use
+	ChunkSize  int64        `json:"chunkSize"` // the most a chunk holds
+	Logical    int64        `json:"logical"`   // bytes of the files' content
+	Physical   int64        `json:"physical"`  // bytes of the distinct chunks
+	Stored     int64        `json:"stored"`    // bytes the backend holds for them, after filters
+	Ratio      float64      `json:"ratio"`     // logical per physical byte
+	Extensions []ExtDedup   `json:"extensions"`
+	Groups     []DedupGroup `json:"groups"`
+}
+
+// ExtDedup is the dedup of the files with one extension among themselves
+type ExtDedup struct {
+	Ext      string  `json:"ext"`
+	Files    int     `json:"files"`
+	Logical  int64   `json:"logical"`
+	Physical int64   `json:"physical"`
+	Ratio    float64 `json:"ratio"`
+}
+
+// DedupGroup is a set of files sharing chunks: the same content, or parts
+// of it. A group of one file repeats chunks within itself.
+type DedupGroup struct {
+	Files     []string `json:"files"` // paths, or IDs the index has no path for
+	Identical bool     `json:"identical"`
+	Chunks    int      `json:"chunks"` // distinct chunks shared
+	Shared    int64    `json:"shared"` // bytes of one copy of them
+	Saved     int64    `json:"saved"`  // bytes the other copies would take
+}
+
+func ratio(logical, physical int64) float64 {
+	if physical == 0 {
+		return 0
+	}
+	return float64(logical) / float64(physical)
+}
+
+// Dedup reports the dedup of the content files point to, overall, by
+// extension, and for the top groups of files sharing chunks, the most saved
+// first (all of them if top isn't positive). Content no file points to any
+// more is left out.
+func (s *Store) Dedup(top int) (*DedupReport, error) {
+	files, err := s.Files()
+	if err != nil {
+		return nil, err
+	}
+	_, id2path, err := index.LoadIndex(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index: %w", err)
+	}
+	r := &DedupReport{Files: len(files), ChunkSize: ChunkSize, Extensions: []ExtDedup{}, Groups: []DedupGroup{}}
+	sizes := make(map[string]int64)
+	uses := make(map[string]int)                  // chunk -> times files list it
+	users := make(map[string][]string)            // chunk -> files listing it
+	exts := make(map[string]*ExtDedup)            // ext -> its files
+	extChunks := make(map[string]map[string]bool) // ext -> chunks of its files
+	content := make(map[string]string)            // file -> content hash
+	for _, f := range files {
+		name := f.ID
+		if p, ok := id2path[f.ID]; ok {
+			name = p
+		}
+		content[name] = f.ContentHash
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(id2path[f.ID]), "."))
+		e := exts[ext]
+		if e == nil {
+			e = &ExtDedup{Ext: ext}
+			exts[ext] = e
+			extChunks[ext] = make(map[string]bool)
+		}
+		e.Files++
+		e.Logical += f.Size
+		r.Logical += f.Size
+		seen := make(map[string]bool)
+		for _, c := range f.Chunks {
+			sizes[c.Hash] = c.Size
+			uses[c.Hash]++
+			if !seen[c.Hash] {
+				seen[c.Hash] = true
+				users[c.Hash] = append(users[c.Hash], name)
+			}
+			if !extChunks[ext][c.Hash] {
+				extChunks[ext][c.Hash] = true
+				e.Physical += c.Size
+			}
+		}
+	}
+	r.Chunks = len(sizes)
+	for _, size := range sizes {
+		r.Physical += size
+	}
+	r.Ratio = ratio(r.Logical, r.Physical)
+	err = s.Chunks(func(hash string, size int64) error {
+		if _, ok := sizes[hash]; ok {
+			r.Stored += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS chunks: %w", err)
+	}
+
+	for _, e := range exts {
+		e.Ratio = ratio(e.Logical, e.Physical)
+		r.Extensions = append(r.Extensions, *e)
+	}
+	sort.Slice(r.Extensions, func(i, j int) bool {
+		a, b := r.Extensions[i], r.Extensions[j]
+		if a.Logical != b.Logical {
+			return a.Logical > b.Logical
+		}
+		return a.Ext < b.Ext
+	})
+
+	// chunks listed more than once are grouped by the files listing them
+	groups := make(map[string]*DedupGroup)
+	for hash, n := range uses {
+		if n < 2 {
+			continue
+		}
+		names := users[hash]
+		sort.Strings(names)
+		key := strings.Join(names, "\x00")
+		g := groups[key]
+		if g == nil {
+			g = &DedupGroup{Files: names, Identical: len(names) > 1}
+			for _, name := range names[1:] {
+				if content[name] != content[names[0]] {
+					g.Identical = false
+				}
+			}
+			groups[key] = g
+		}
+		g.Chunks++
+		g.Shared += sizes[hash]
+		g.Saved += int64(n-1) * sizes[hash]
+	}
+	for _, g := range groups {
+		r.Groups = append(r.Groups, *g)
+	}
+	sort.Slice(r.Groups, func(i, j int) bool {
+		a, b := r.Groups[i], r.Groups[j]
+		if a.Saved != b.Saved {
+			return a.Saved > b.Saved
+		}
+		return a.Files[0] < b.Files[0]
+	})
+	if top > 0 && len(r.Groups) > top {
+		r.Groups = r.Groups[:top]
+	}
+	return r, nil
+}
@@ -0,0 +1,59 @@
+package lfs
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	index := "a scene.psd\nb scene copy.PSD\nc take1.bin\nd take2.bin\n"
+	if err := os.WriteFile(filepath.Join(root, ".evo", "index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a and b are the same content; c and d share their first chunk
+	block := make([]byte, ChunkSize)
+	rand.New(rand.NewSource(1)).Read(block)
+	put := func(id string, content []byte) {
+		if _, err := store.StoreFile(id, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", []byte("scene"))
+	put("b", []byte("scene"))
+	put("c", append(append([]byte{}, block...), "one"...))
+	put("d", append(append([]byte{}, block...), "two!"...))
+
+	r, err := store.Dedup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logical := int64(5 + 5 + ChunkSize + 3 + ChunkSize + 4)
+	physical := int64(5 + ChunkSize + 3 + 4)
+	if r.Files != 4 || r.Chunks != 4 || r.Logical != logical || r.Physical != physical || r.Stored != physical {
+		t.Errorf("Unexpected totals %+v", r)
+	}
+	if len(r.Extensions) != 2 || r.Extensions[0].Ext != "bin" || r.Extensions[1].Ext != "psd" {
+		t.Fatalf("Expected bin then psd, got %+v", r.Extensions)
+	}
+	if psd := r.Extensions[1]; psd.Files != 2 || psd.Logical != 10 || psd.Physical != 5 || psd.Ratio != 2 {
+		t.Errorf("Unexpected psd dedup %+v", psd)
+	}
+
+	want := []DedupGroup{
+		{Files: []string{"take1.bin", "take2.bin"}, Chunks: 1, Shared: ChunkSize, Saved: ChunkSize},
+		{Files: []string{"scene copy.PSD", "scene.psd"}, Identical: true, Chunks: 1, Shared: 5, Saved: 5},
+	}
+	if !reflect.DeepEqual(r.Groups, want) {
+		t.Errorf("Expected groups %+v, got %+v", want, r.Groups)
+	}
+	if r, err = store.Dedup(1); err != nil || len(r.Groups) != 1 {
+		t.Errorf("Expected the top group alone, got %+v, %v", r, err)
+	}
+}
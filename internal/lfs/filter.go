@@ -0,0 +1,296 @@
+package lfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"evo/internal/config"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Filters turn chunk content into the bytes stored and back. New chunks go
+// through the chain lfs.filters names, in order, and are stored behind a
+// header naming the filters applied:
+//
+//	"EVOCHNK" 0x01       magic and version
+//	n                    number of filters, then for each
+//	len name             its name, in the order applied
+//	payload
+//
+// Chunks without the header, as every chunk from before filters, are stored
+// as their content. A chunk keeps the hash of its content as key whatever
+// the filters, so content dedupes across them, and the store decodes chunks
+// as it reads them: transfers move content, and each side stores it through
+// its own filters.
+
+// Filter encodes chunks for storage and decodes them back
+type Filter interface {
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// filterOpeners open the filters lfs.filters may name, for the repository at root
+var filterOpeners = map[string]func(root string, cfg *config.Config) (Filter, error){
+	"zstd":    openZstd,
+	"aes-gcm": openAESGCM,
+}
+
+var chunkMagic = []byte("EVOCHNK\x01")
+
+// openFilters opens the chain of lfs.filters. A chain that fails to open,
+// or a config that can't be read to find it, fails every chunk written with
+// the reason, rather than storing chunks unfiltered.
+func openFilters(root string) ([]Filter, error) {
+	cfg, err := config.Load(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	spec, _ := cfg.Get("lfs.filters")
+	var chain []Filter
+	encrypted := false
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		// encrypted bytes don't compress, so encryption comes last
+		if encrypted {
+			return nil, fmt.Errorf("lfs.filters: %s comes after encryption", name)
+		}
+		f, err := openFilter(root, cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		encrypted = name == "aes-gcm"
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+func openFilter(root string, cfg *config.Config, name string) (Filter, error) {
+	open, ok := filterOpeners[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown LFS filter %q", name)
+	}
+	return open(root, cfg)
+}
+
+// filter returns the filter name for decoding, opening it once
+func (s *Store) filter(name string) (Filter, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if f, ok := s.decoders[name]; ok {
+		return f, nil
+	}
+	cfg, err := config.Load(s.root)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openFilter(s.root, cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	if s.decoders == nil {
+		s.decoders = make(map[string]Filter)
+	}
+	s.decoders[name] = f
+	return f, nil
+}
+
+// encodeChunk runs content through the store's filters, returning what to
+// store and the names of the filters applied
+func (s *Store) encodeChunk(data []byte) ([]byte, []string, error) {
+	if s.filterErr != nil {
+		return nil, nil, s.filterErr
+	}
+	if len(s.filters) == 0 {
+		return data, nil, nil
+	}
+	var names []string
+	for _, f := range s.filters {
+		var err error
+		if data, err = f.Encode(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to %s chunk: %w", f.Name(), err)
+		}
+		names = append(names, f.Name())
+	}
+	var buf bytes.Buffer
+	buf.Write(chunkMagic)
+	buf.WriteByte(byte(len(names)))
+	for _, name := range names {
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+	}
+	buf.Write(data)
+	return buf.Bytes(), names, nil
+}
+
+// parseHeader returns the filters a stored chunk names and its payload, or
+// ok false for a chunk stored as its content
+func parseHeader(data []byte) (names []string, payload []byte, ok bool) {
+	if !bytes.HasPrefix(data, chunkMagic) || len(data) <= len(chunkMagic) {
+		return nil, nil, false
+	}
+	rest := data[len(chunkMagic):]
+	n := int(rest[0])
+	rest = rest[1:]
+	for i := 0; i < n; i++ {
+		if len(rest) == 0 || len(rest) <= int(rest[0]) {
+			return nil, nil, false
+		}
+		names = append(names, string(rest[1:1+rest[0]]))
+		rest = rest[1+rest[0]:]
+	}
+	return names, rest, true
+}
+
+// decodeChunk returns the content of the stored chunk hash. Content that
+// merely starts like a header is told apart by its hash.
+func (s *Store) decodeChunk(hash string, data []byte) ([]byte, error) {
+	names, payload, ok := parseHeader(data)
+	if !ok {
+		return data, nil
+	}
+	content, err := s.unfilter(hash, names, payload)
+	switch {
+	case err == nil:
+		return content, nil
+	case HashBytes(data) == hash:
+		return data, nil
+	case errors.Is(err, errFilter):
+		return nil, fmt.Errorf("failed to read chunk %s: %w", hash, err)
+	}
+	return nil, fmt.Errorf("%w: chunk %s: %v", ErrCorrupt, hash, err)
+}
+
+// errFilter is a filter a chunk names that this store can't open, as
+// aes-gcm without the key: the chunk can't be read, but isn't damaged
+var errFilter = errors.New("can't open LFS filter")
+
+func (s *Store) unfilter(hash string, names []string, data []byte) ([]byte, error) {
+	for i := len(names) - 1; i >= 0; i-- {
+		f, err := s.filter(names[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", errFilter, names[i], err)
+		}
+		if data, err = f.Decode(data); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", names[i], err)
+		}
+	}
+	if got := HashBytes(data); got != hash {
+		return nil, fmt.Errorf("decodes to hash %s", got)
+	}
+	return data, nil
+}
+
+// chunkFilters returns the filters a stored chunk was written with
+func (s *Store) chunkFilters(hash string) []string {
+	r, err := s.getChunk(hash)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(r, head)
+	names, _, _ := parseHeader(head[:n])
+	return names
+}
+
+// zstd encoders and decoders are safe for concurrent use and costly to
+// create, so every store shares one
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+type zstdFilter struct{}
+
+func openZstd(string, *config.Config) (Filter, error) {
+	zstdOnce.Do(func() {
+		if zstdEnc, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		// a chunk decodes to at most ChunkSize, so a payload claiming
+		// more is damaged or hostile
+		zstdDec, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(ChunkSize))
+	})
+	if zstdErr != nil {
+		return nil, fmt.Errorf("failed to set up zstd: %w", zstdErr)
+	}
+	return zstdFilter{}, nil
+}
+
+func (zstdFilter) Name() string { return "zstd" }
+
+func (zstdFilter) Encode(data []byte) ([]byte, error) {
+	return zstdEnc.EncodeAll(data, nil), nil
+}
+
+func (zstdFilter) Decode(data []byte) ([]byte, error) {
+	return zstdDec.DecodeAll(data, nil)
+}
+
+// aesGCM encrypts chunks with AES-256-GCM under the key in the file
+// lfs.encryptionKey names. Each chunk gets a random nonce, stored before the
+// ciphertext.
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+func openAESGCM(root string, cfg *config.Config) (Filter, error) {
+	path, _ := cfg.Get("lfs.encryptionKey")
+	if path == "" {
+		return nil, errors.New("the aes-gcm filter needs lfs.encryptionKey")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS encryption key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("LFS encryption key %s isn't 64 hex digits", path)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCM{aead: aead}, nil
+}
+
+func (f *aesGCM) Name() string { return "aes-gcm" }
+
+func (f *aesGCM) Encode(data []byte) ([]byte, error) {
+	nonce := make([]byte, f.aead.NonceSize(), f.aead.NonceSize()+len(data)+f.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return f.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (f *aesGCM) Decode(data []byte) ([]byte, error) {
+	n := f.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return f.aead.Open(nil, data[:n], data[n:], nil)
+}
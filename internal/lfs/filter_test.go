@@ -0,0 +1,126 @@
+package lfs
+
+import (
+	"bytes"
+	"errors"
+	"evo/internal/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func setFilters(t *testing.T, root, filters string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(root, "lfs.key"), []byte(testKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for key, val := range map[string]string{"lfs.filters": filters, "lfs.encryptionKey": "lfs.key"} {
+		if err := config.SetRepoConfigValue(root, key, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readString(t *testing.T, s *Store, id string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.ReadFile(id, &buf); err != nil {
+		t.Fatalf("Failed to read %s: %v", id, err)
+	}
+	return buf.String()
+}
+
+func TestFilters(t *testing.T) {
+	root := t.TempDir()
+	NewStore(root)
+	setFilters(t, root, "zstd, aes-gcm")
+	store := NewStore(root)
+
+	content := strings.Repeat("compressible content ", 5000)
+	info := storeString(t, store, "a", content)
+	c := info.Chunks[0]
+	if !reflect.DeepEqual(c.Filters, []string{"zstd", "aes-gcm"}) {
+		t.Errorf("Expected the chunk stored through zstd and aes-gcm, got %v", c.Filters)
+	}
+	if c.Size != int64(len(content)) {
+		t.Errorf("Expected the chunk to list its content size, got %d", c.Size)
+	}
+	raw, err := os.ReadFile(store.chunkPath(c.Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, chunkMagic) || len(raw) >= len(content) || bytes.Contains(raw, []byte("compressible")) {
+		t.Errorf("Expected a compressed, encrypted chunk, got %d bytes", len(raw))
+	}
+	if got := readString(t, store, "a"); got != content {
+		t.Error("Content doesn't round trip through the filters")
+	}
+	if r, err := store.Check(); err != nil || len(r.Damage) != 0 {
+		t.Errorf("Expected a clean store, got %+v, %v", r, err)
+	}
+
+	// another key can't read the chunk, which fsck reports as corrupt
+	other := strings.Replace(testKey, "00", "ff", 1)
+	if err := os.WriteFile(filepath.Join(root, "lfs.key"), []byte(other), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStore(root).ReadFile("a", &bytes.Buffer{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt with the wrong key, got %v", err)
+	}
+
+	// without the key the chunk can't be read, but it isn't damaged either
+	os.Remove(filepath.Join(root, "lfs.key"))
+	if _, err := NewStore(root).Check(); !errors.Is(err, errFilter) {
+		t.Errorf("Expected Check to fail without the key, got %v", err)
+	}
+}
+
+func TestPlainChunks(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	old := storeString(t, store, "a", "stored before filters")
+	// content that happens to start like a filtered chunk
+	storeString(t, store, "b", string(chunkMagic)+"\x01\x04zstdnot really")
+
+	setFilters(t, root, "zstd")
+	store = NewStore(root)
+	if got := readString(t, store, "a"); got != "stored before filters" {
+		t.Errorf("Expected the plain chunk to read as before, got %q", got)
+	}
+	if got := readString(t, store, "b"); got != string(chunkMagic)+"\x01\x04zstdnot really" {
+		t.Errorf("Expected the plain chunk with a header-like start to read as is, got %q", got)
+	}
+
+	// the same content dedupes to the plain chunk, new content is filtered
+	again := storeString(t, store, "c", "stored before filters")
+	if again.Chunks[0].Hash != old.Chunks[0].Hash || again.Chunks[0].Filters != nil {
+		t.Errorf("Expected the plain chunk reused, got %+v", again.Chunks[0])
+	}
+	if c := storeString(t, store, "d", "stored after").Chunks[0]; !reflect.DeepEqual(c.Filters, []string{"zstd"}) {
+		t.Errorf("Expected a new chunk through zstd, got %+v", c)
+	}
+	if r, err := store.Check(); err != nil || len(r.Damage) != 0 {
+		t.Errorf("Expected a clean store, got %+v, %v", r, err)
+	}
+
+	// bad chains fail writes rather than storing chunks unfiltered
+	for _, filters := range []string{"gzip", "aes-gcm,zstd"} {
+		setFilters(t, root, filters)
+		if _, err := NewStore(root).StoreFile("e", strings.NewReader("new"), 3); err == nil {
+			t.Errorf("Expected lfs.filters %q to fail", filters)
+		}
+	}
+
+	// so does a config that can't be read
+	setFilters(t, root, "zstd")
+	if err := os.WriteFile(filepath.Join(root, ".evo", "config", "config.toml"), []byte("lfs.filters = [broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(root).StoreFile("e", strings.NewReader("new"), 3); err == nil || !strings.Contains(err.Error(), "failed to load config") {
+		t.Errorf("Expected an unreadable config to fail writes, got %v", err)
+	}
+}
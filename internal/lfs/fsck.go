@@ -0,0 +1,224 @@
+package lfs
+
+import (
+	"errors"
+	"evo/internal/repo"
+	"evo/internal/storage"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of damage Check finds
+const (
+	DamageMissing = "missing"  // a chunk an object lists isn't stored
+	DamageCorrupt = "corrupt"  // a chunk doesn't hash to its name or has the wrong size
+	DamageObject  = "object"   // an object's chunks don't add up to its content
+	DamageRef     = "dangling" // an ID points to content the store doesn't have
+)
+
+// Damage is something wrong in the store
+type Damage struct {
+	Kind   string   `json:"kind"`
+	Hash   string   `json:"hash"`            // the chunk, or the content hash of an object
+	Size   int64    `json:"size,omitempty"`  // the size objects list for a chunk, 0 for one none lists
+	Files  []string `json:"files,omitempty"` // IDs whose content it breaks
+	Reason string   `json:"reason"`
+}
+
+func (d Damage) String() string {
+	s := fmt.Sprintf("%s %s: %s", d.Kind, d.Hash, d.Reason)
+	if len(d.Files) > 0 {
+		s += " (" + strings.Join(d.Files, ", ") + ")"
+	}
+	return s
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	Objects int      `json:"objects"`
+	Chunks  int      `json:"chunks"` // distinct chunks read
+	Bytes   int64    `json:"bytes"`
+	Damage  []Damage `json:"damage"`
+}
+
+// Lost returns the missing and corrupt chunks objects list, which a remote
+// holding the same content can give back
+func (r *CheckReport) Lost() []ChunkInfo {
+	var out []ChunkInfo
+	for _, d := range r.Damage {
+		if (d.Kind == DamageMissing || d.Kind == DamageCorrupt) && d.Size > 0 {
+			out = append(out, ChunkInfo{Hash: d.Hash, Size: d.Size})
+		}
+	}
+	return out
+}
+
+// Check re-hashes every chunk of the store and checks that the chunks of
+// each object add up to its size and content hash, and that every ID points
+// to a stored object. It doesn't lock the store, so a GC running meanwhile
+// can make it report chunks as missing that were just deleted.
+func (s *Store) Check() (*CheckReport, error) {
+	if s.legacy() {
+		if err := s.convert(); err != nil {
+			return nil, err
+		}
+	}
+	r := &CheckReport{Damage: []Damage{}}
+	files := make(map[string][]string)
+	refs, err := os.ReadDir(filepath.Join(repo.Dir(s.root), "lfs", "refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read LFS refs: %w", err)
+	}
+	for _, ref := range refs {
+		if ref.IsDir() || strings.Contains(ref.Name(), ".tmp") {
+			continue
+		}
+		hash, err := s.readRef(ref.Name())
+		if err != nil {
+			return nil, err
+		}
+		files[hash] = append(files[hash], ref.Name())
+	}
+	objs, err := s.objects()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS objects: %w", err)
+	}
+
+	// damage to a chunk is reported once, naming every file it breaks
+	chunks := make(map[string]*Damage)
+	checked := make(map[string]bool)
+	stored := make(map[string]bool)
+	for _, obj := range objs {
+		r.Objects++
+		stored[obj.ContentHash] = true
+		ids := files[obj.ContentHash]
+		content := NewHash()
+		var size int64
+		whole := true
+		for _, c := range obj.Chunks {
+			n, err := s.checkChunk(c, content)
+			size += n
+			if !checked[c.Hash] {
+				checked[c.Hash] = true
+				r.Chunks++
+				r.Bytes += n
+			}
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, errFilter) {
+				return nil, err
+			}
+			whole = false
+			d := chunks[c.Hash]
+			if d == nil {
+				d = &Damage{Kind: DamageCorrupt, Hash: c.Hash, Size: c.Size, Reason: err.Error()}
+				if errors.Is(err, fs.ErrNotExist) {
+					d.Kind, d.Reason = DamageMissing, "not stored"
+				}
+				chunks[c.Hash] = d
+			}
+			d.Files = append(d.Files, ids...)
+		}
+		switch {
+		case obj.NumChunks != len(obj.Chunks):
+			r.Damage = append(r.Damage, Damage{Kind: DamageObject, Hash: obj.ContentHash, Files: ids,
+				Reason: fmt.Sprintf("lists %d chunks but counts %d", len(obj.Chunks), obj.NumChunks)})
+		case whole && size != obj.Size:
+			r.Damage = append(r.Damage, Damage{Kind: DamageObject, Hash: obj.ContentHash, Files: ids,
+				Reason: fmt.Sprintf("chunks add up to %d bytes, not %d", size, obj.Size)})
+		case whole && content.Sum() != obj.ContentHash:
+			r.Damage = append(r.Damage, Damage{Kind: DamageObject, Hash: obj.ContentHash, Files: ids,
+				Reason: "chunks add up to content with hash " + content.Sum()})
+		}
+	}
+
+	// chunks no object lists are checked too, though the GC deletes them;
+	// what they stored says nothing of their content's size
+	var orphans []ChunkInfo
+	err = s.Chunks(func(hash string, size int64) error {
+		if !checked[hash] {
+			orphans = append(orphans, ChunkInfo{Hash: hash, Size: -1})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS chunks: %w", err)
+	}
+	for _, c := range orphans {
+		checked[c.Hash] = true
+		r.Chunks++
+		n, err := s.checkChunk(c, io.Discard)
+		r.Bytes += n
+		if errors.Is(err, errFilter) {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			chunks[c.Hash] = &Damage{Kind: DamageCorrupt, Hash: c.Hash, Reason: err.Error() + "; no object lists it"}
+		}
+	}
+	for _, d := range chunks {
+		sort.Strings(d.Files)
+		r.Damage = append(r.Damage, *d)
+	}
+
+	for hash, ids := range files {
+		if !stored[hash] {
+			sort.Strings(ids)
+			r.Damage = append(r.Damage, Damage{Kind: DamageRef, Hash: hash, Files: ids, Reason: "no object holds this content"})
+		}
+	}
+	sort.Slice(r.Damage, func(i, j int) bool {
+		a, b := r.Damage[i], r.Damage[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Hash < b.Hash
+	})
+	return r, nil
+}
+
+// checkChunk reads a chunk into content, checking it against its hash and
+// size, unless that is negative, and returns the bytes read
+func (s *Store) checkChunk(c ChunkInfo, content io.Writer) (int64, error) {
+	f, err := s.OpenChunk(c.Hash)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := NewHash()
+	n, err := io.Copy(io.MultiWriter(h, content), f)
+	if err != nil {
+		return n, err
+	}
+	if got := h.Sum(); got != c.Hash {
+		return n, fmt.Errorf("%w: has hash %s", ErrCorrupt, got)
+	}
+	if c.Size >= 0 && n != c.Size {
+		return n, fmt.Errorf("%w: %d bytes, not %d", ErrCorrupt, n, c.Size)
+	}
+	return n, nil
+}
+
+// RemoveChunk deletes a chunk from the store's own backend, as a corrupt
+// one before it is fetched again. A chunk read from an alternate is left
+// alone.
+func (s *Store) RemoveChunk(hash string) error {
+	if !IsHash(hash) {
+		return fmt.Errorf("invalid chunk hash %q", hash)
+	}
+	own := s.chunks
+	if o, ok := own.(*storage.Overlay); ok {
+		own = o.Backend
+	}
+	err := own.Delete(chunkKey(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
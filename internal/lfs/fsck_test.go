@@ -0,0 +1,71 @@
+package lfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	storeString(t, store, "a", "intact")
+	shared := storeString(t, store, "b", "shared")
+	storeString(t, store, "c", "shared")
+	gone := storeString(t, store, "d", "gone")
+
+	r, err := store.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Objects != 3 || r.Chunks != 3 || len(r.Damage) != 0 {
+		t.Fatalf("Expected a clean store of 3 objects, got %+v", r)
+	}
+
+	// a damaged chunk names both files that share it
+	if err := os.WriteFile(store.chunkPath(shared.Chunks[0].Hash), []byte("sharee"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveChunk(gone.Chunks[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	// an object whose chunks add up to other content
+	obj, err := store.loadObject(HashBytes([]byte("intact")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Size++
+	if err := store.saveObject(obj); err != nil {
+		t.Fatal(err)
+	}
+	// and a file pointing to content that isn't there
+	if err := os.WriteFile(store.refPath("e"), []byte(HashBytes([]byte("never stored"))+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = store.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]Damage)
+	for _, d := range r.Damage {
+		kinds[d.Kind] = d
+	}
+	if len(r.Damage) != 4 || len(kinds) != 4 {
+		t.Fatalf("Expected one damage of each kind, got %v", r.Damage)
+	}
+	if d := kinds[DamageCorrupt]; d.Hash != shared.Chunks[0].Hash || len(d.Files) != 2 || d.Files[0] != "b" || d.Files[1] != "c" {
+		t.Errorf("Unexpected corrupt chunk %v", d)
+	}
+	if d := kinds[DamageMissing]; d.Hash != gone.Chunks[0].Hash || len(d.Files) != 1 || d.Files[0] != "d" {
+		t.Errorf("Unexpected missing chunk %v", d)
+	}
+	if d := kinds[DamageObject]; d.Hash != obj.ContentHash || len(d.Files) != 1 || d.Files[0] != "a" {
+		t.Errorf("Unexpected damaged object %v", d)
+	}
+	if d := kinds[DamageRef]; len(d.Files) != 1 || d.Files[0] != "e" {
+		t.Errorf("Unexpected dangling ref %v", d)
+	}
+	if lost := r.Lost(); len(lost) != 2 {
+		t.Errorf("Expected the 2 damaged chunks to be lost, got %v", lost)
+	}
+}
@@ -1,13 +1,22 @@
 package lfs
 
 import (
+	"context"
+	"evo/internal/log"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"evo/internal/storage"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+var logger = log.For("lfs")
+
 // GarbageCollector manages cleanup of unreferenced chunks
 type GarbageCollector struct {
 	store *Store
@@ -30,8 +39,8 @@ func (gc *GarbageCollector) Start() {
 		for {
 			select {
 			case <-ticker.C:
-				if err := gc.Run(); err != nil {
-					fmt.Fprintf(os.Stderr, "Error during LFS garbage collection: %v\n", err)
+				if _, err := gc.Run(context.Background()); err != nil {
+					logger.Error("garbage collection failed", "err", err)
 				}
 			case <-gc.done:
 				ticker.Stop()
@@ -46,70 +55,228 @@ func (gc *GarbageCollector) Stop() {
 	close(gc.done)
 }
 
-// Run performs garbage collection
-func (gc *GarbageCollector) Run() error {
+// GCResult is what a garbage collection did, or would do
+type GCResult struct {
+	Chunks   int   `json:"chunks"`  // chunks stored before
+	Removed  int   `json:"removed"` // of them, deleted
+	Freed    int64 `json:"freed"`   // bytes of the chunks deleted
+	Expired  int   `json:"expired"` // unreferenced objects past their retention
+	Evicted  int   `json:"evicted"` // unreferenced objects deleted to meet the quota
+	Stored   int64 `json:"stored"`  // bytes of chunks stored after
+	Quota    int64 `json:"quota"`   // lfs.quota, 0 for none
+	DryRun   bool  `json:"dryRun,omitempty"`
+	Overflow bool  `json:"overQuota,omitempty"` // content files point to alone passes the quota
+}
+
+// Run performs garbage collection: it deletes the objects no file points
+// to that the policy no longer keeps, then every chunk no object uses.
+// Cancelling ctx stops it between deletions; what is left unreferenced goes
+// on the next run. The chunks deleted are reported to the progress reporter
+// of ctx.
+func (gc *GarbageCollector) Run(ctx context.Context) (*GCResult, error) {
+	return gc.collect(ctx, false)
+}
+
+// Plan reports what Run would delete without deleting anything
+func (gc *GarbageCollector) Plan(ctx context.Context) (*GCResult, error) {
+	return gc.collect(ctx, true)
+}
+
+func (gc *GarbageCollector) collect(ctx context.Context, dryRun bool) (*GCResult, error) {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	unlock, err := gc.store.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	// Get all chunks
-	chunksDir := filepath.Join(gc.store.root, ".evo", "chunks")
-	chunks, err := os.ReadDir(chunksDir)
+	policy, err := LoadPolicy(gc.store.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the LFS policy: %w", err)
+	}
+	objs, err := gc.store.objects()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS objects: %w", err)
+	}
+	referenced, err := gc.store.referenced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS refs: %w", err)
+	}
+	sizes := make(map[string]int64)
+	err = gc.store.Chunks(func(hash string, size int64) error {
+		sizes[hash] = size
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read chunks directory: %w", err)
+		return nil, err
 	}
+	res := &GCResult{Chunks: len(sizes), Quota: policy.Quota, DryRun: dryRun}
 
-	// Check each chunk
-	for _, chunk := range chunks {
-		if chunk.IsDir() {
+	// Objects no file points to go once past their retention, ...
+	now := time.Now()
+	uses := make(map[string]int)
+	var kept, unreferenced []*object
+	for _, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !referenced[obj.ContentHash] {
+			if policy.expired(obj, now) {
+				if err := gc.deleteObject(obj, dryRun); err != nil {
+					return nil, err
+				}
+				res.Expired++
+				continue
+			}
+			unreferenced = append(unreferenced, obj)
+		}
+		kept = append(kept, obj)
+		for _, c := range obj.Chunks {
+			if uses[c.Hash]++; uses[c.Hash] == 1 {
+				res.Stored += sizes[c.Hash]
+			}
+		}
+	}
+
+	// ... or, oldest first, while the store holds more than the quota
+	if policy.Quota > 0 && res.Stored > policy.Quota {
+		sort.Slice(unreferenced, func(i, j int) bool { return unreferenced[i].since().Before(unreferenced[j].since()) })
+		for _, obj := range unreferenced {
+			if res.Stored <= policy.Quota {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := gc.deleteObject(obj, dryRun); err != nil {
+				return nil, err
+			}
+			res.Evicted++
+			for _, c := range obj.Chunks {
+				if uses[c.Hash]--; uses[c.Hash] == 0 {
+					res.Stored -= sizes[c.Hash]
+				}
+			}
+		}
+		res.Overflow = res.Stored > policy.Quota
+	}
+
+	// Delete every chunk no object left uses
+	unused := 0
+	for hash := range sizes {
+		if uses[hash] == 0 {
+			unused++
+		}
+	}
+	pr := progress.From(ctx)
+	if dryRun {
+		pr = progress.Discard
+	}
+	pr.Start("Removing chunks", int64(unused))
+	defer pr.Done()
+	for hash, size := range sizes {
+		if uses[hash] > 0 {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !dryRun {
+			if err := gc.store.chunks.Delete(chunkKey(hash)); err != nil {
+				return nil, fmt.Errorf("failed to delete unreferenced chunk %s: %w", hash, err)
+			}
+		}
+		logger.Trace("removed unreferenced chunk", "chunk", hash)
+		res.Removed++
+		res.Freed += size
+		pr.Add(1)
+	}
+	if dryRun {
+		return res, nil
+	}
 
-		// Delete if not referenced
-		chunkHash := chunk.Name()
-		if !gc.store.isChunkReferenced(chunkHash) {
-			chunkPath := filepath.Join(chunksDir, chunkHash)
-			if err := os.Remove(chunkPath); err != nil {
-				return fmt.Errorf("failed to delete unreferenced chunk %s: %w", chunkHash, err)
+	// and chunks left half written by an interrupted store
+	own := gc.store.chunks
+	if o, ok := own.(*storage.Overlay); ok {
+		own = o.Backend
+	}
+	if dir, ok := own.(*storage.FS); ok {
+		tmpDir := filepath.Join(dir.Dir, "tmp")
+		tmps, _ := os.ReadDir(tmpDir)
+		for _, t := range tmps {
+			if info, err := t.Info(); err == nil && time.Since(info.ModTime()) > time.Hour {
+				os.Remove(filepath.Join(tmpDir, t.Name()))
 			}
 		}
 	}
+	if res.Overflow {
+		logger.Warn("LFS store is over its quota with content files point to", "stored", res.Stored, "quota", res.Quota)
+	}
+	logger.Info("garbage collection done", "chunks", res.Chunks, "removed", res.Removed,
+		"expired", res.Expired, "evicted", res.Evicted)
+	return res, nil
+}
 
+func (gc *GarbageCollector) deleteObject(obj *object, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	if err := os.Remove(gc.store.objectPath(obj.ContentHash)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete LFS object %s: %w", obj.ContentHash, err)
+	}
 	return nil
 }
 
-// PruneTombstones removes old tombstones
+// PruneTombstones removes objects that no file points to any more, as an
+// interrupted store or delete can leave, once older than maxAge. Their
+// chunks go with the next Run.
 func (gc *GarbageCollector) PruneTombstones(maxAge time.Duration) error {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	unlock, err := gc.store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	// Get all files
-	filesDir := filepath.Join(gc.store.root, ".evo", "lfs")
-	files, err := os.ReadDir(filesDir)
+	objs, err := gc.store.objects()
+	if err != nil {
+		return fmt.Errorf("failed to read LFS objects: %w", err)
+	}
+	used, err := gc.store.referenced()
 	if err != nil {
-		return fmt.Errorf("failed to read files directory: %w", err)
+		return fmt.Errorf("failed to read LFS refs: %w", err)
 	}
 
+	// released objects are left to the policy
 	cutoff := time.Now().Add(-maxAge)
-
-	// Check each file
-	for _, file := range files {
-		if !file.IsDir() {
+	for _, obj := range objs {
+		if used[obj.ContentHash] || obj.Released != nil || !obj.Created.Before(cutoff) {
 			continue
 		}
+		if err := os.Remove(gc.store.objectPath(obj.ContentHash)); err != nil {
+			return fmt.Errorf("failed to delete old tombstone %s: %w", obj.ContentHash, err)
+		}
+	}
+
+	return nil
+}
 
-		// Load file info
-		info, err := gc.store.loadFileInfo(file.Name())
-		if err != nil {
+// referenced returns the content hashes IDs point to
+func (s *Store) referenced() (map[string]bool, error) {
+	refs, err := os.ReadDir(filepath.Join(repo.Dir(s.root), "lfs", "refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.IsDir() || strings.Contains(ref.Name(), ".tmp") {
 			continue
 		}
-
-		// Delete if it's a tombstone older than maxAge
-		if info.RefCount == 0 && info.Created.Before(cutoff) {
-			if err := gc.store.DeleteFile(file.Name()); err != nil {
-				return fmt.Errorf("failed to delete old tombstone %s: %w", file.Name(), err)
-			}
+		if hash, err := s.readRef(ref.Name()); err == nil {
+			used[hash] = true
 		}
 	}
-
-	return nil
+	return used, nil
 }
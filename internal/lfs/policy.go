@@ -0,0 +1,161 @@
+package lfs
+
+import (
+	"evo/internal/config"
+	"evo/internal/index"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Policy is what the store keeps of content no file points to any more, as
+// the content an ID held before it was stored again or deleted:
+//
+//	lfs.maxUnreferencedAge   how long it is kept; 0 deletes it right away
+//	lfs.retention.<ext>      the same for content last held by a .<ext> file
+//	lfs.quota                bytes of chunks the store may hold; the GC
+//	                         evicts the oldest unreferenced content first
+//
+// Content a file points to is never deleted, whatever the quota.
+type Policy struct {
+	Quota     int64                    `json:"quota"`
+	MaxAge    time.Duration            `json:"maxUnreferencedAge"`
+	Retention map[string]time.Duration `json:"retention,omitempty"`
+}
+
+// retentionPrefix starts the keys of per-extension retention
+const retentionPrefix = "lfs.retention."
+
+// LoadPolicy reads the policy of the repository's config
+func LoadPolicy(repoPath string) (*Policy, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if p.Quota, err = cfg.Size("lfs.quota"); err != nil {
+		return nil, err
+	}
+	if p.MaxAge, err = cfg.Duration("lfs.maxUnreferencedAge"); err != nil {
+		return nil, err
+	}
+	for _, e := range cfg.List() {
+		ext, ok := strings.CutPrefix(e.Key, retentionPrefix)
+		if !ok || ext == "" {
+			continue
+		}
+		d, err := cfg.Duration(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		if p.Retention == nil {
+			p.Retention = make(map[string]time.Duration)
+		}
+		p.Retention[strings.ToLower(ext)] = d
+	}
+	return p, nil
+}
+
+// keeps reports whether the policy keeps any unreferenced content
+func (p *Policy) keeps() bool {
+	if p.MaxAge > 0 {
+		return true
+	}
+	for _, d := range p.Retention {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// retention returns how long unreferenced content last held by a file with
+// extension ext is kept
+func (p *Policy) retention(ext string) time.Duration {
+	if d, ok := p.Retention[ext]; ok {
+		return d
+	}
+	return p.MaxAge
+}
+
+// expired reports whether obj, which no file points to, is past its
+// retention at now
+func (p *Policy) expired(obj *object, now time.Time) bool {
+	return now.Sub(obj.since()) >= p.retention(obj.Ext)
+}
+
+// extOf returns the lower-cased extension, without the dot, of the path the
+// index has for id, or "" if it has none
+func (s *Store) extOf(id string) (string, error) {
+	_, id2path, err := index.LoadIndex(s.root)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the index: %w", err)
+	}
+	return strings.ToLower(strings.TrimPrefix(path.Ext(id2path[id]), ".")), nil
+}
+
+// Usage is what the store holds, against its policy
+type Usage struct {
+	Files        int     `json:"files"`        // IDs pointing to content
+	Objects      int     `json:"objects"`      // distinct contents stored
+	Unreferenced int     `json:"unreferenced"` // of them, no file points to
+	Chunks       int     `json:"chunks"`
+	Stored       int64   `json:"stored"`     // bytes of chunks held
+	Referenced   int64   `json:"referenced"` // of them, used by content files point to
+	Retained     int64   `json:"retained"`   // used only by unreferenced content
+	Orphaned     int64   `json:"orphaned"`   // used by no object, left for the GC
+	Policy       *Policy `json:"policy"`
+}
+
+// Usage reports what the store holds. Chunks of alternates are not counted.
+func (s *Store) Usage() (*Usage, error) {
+	policy, err := LoadPolicy(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the LFS policy: %w", err)
+	}
+	files, err := s.Files()
+	if err != nil {
+		return nil, err
+	}
+	objs, err := s.objects()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS objects: %w", err)
+	}
+	referenced, err := s.referenced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS refs: %w", err)
+	}
+	u := &Usage{Files: len(files), Objects: len(objs), Policy: policy}
+	used := make(map[string]bool)
+	retained := make(map[string]bool)
+	for _, obj := range objs {
+		if !referenced[obj.ContentHash] {
+			u.Unreferenced++
+		}
+		for _, c := range obj.Chunks {
+			if referenced[obj.ContentHash] {
+				used[c.Hash] = true
+			} else {
+				retained[c.Hash] = true
+			}
+		}
+	}
+	err = s.Chunks(func(hash string, size int64) error {
+		u.Chunks++
+		u.Stored += size
+		switch {
+		case used[hash]:
+			u.Referenced += size
+		case retained[hash]:
+			u.Retained += size
+		default:
+			u.Orphaned += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS chunks: %w", err)
+	}
+	return u, nil
+}
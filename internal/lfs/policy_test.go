@@ -0,0 +1,153 @@
+package lfs
+
+import (
+	"context"
+	"evo/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backdate makes the released object of content look released d ago
+func backdate(t *testing.T, s *Store, content string, d time.Duration) {
+	t.Helper()
+	obj, err := s.loadObject(HashBytes([]byte(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Released == nil {
+		t.Fatalf("Expected %q to be released", content)
+	}
+	when := obj.Released.Add(-d)
+	obj.Released = &when
+	if err := s.saveObject(obj); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hasObject(s *Store, content string) bool {
+	_, err := s.loadObject(HashBytes([]byte(content)))
+	return err == nil
+}
+
+func TestRetention(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	gc := NewGarbageCollector(store)
+	for key, val := range map[string]string{"lfs.maxUnreferencedAge": "1h", "lfs.retention.psd": "48h"} {
+		if err := config.SetRepoConfigValue(root, key, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := "a model.bin\nb Cover.PSD\n"
+	if err := os.WriteFile(filepath.Join(root, ".evo", "index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storeString(t, store, "a", "model v1")
+	storeString(t, store, "a", "model v2")
+	storeString(t, store, "b", "cover v1")
+	if err := store.DeleteFile("b"); err != nil {
+		t.Fatal(err)
+	}
+	if !hasObject(store, "model v1") || !hasObject(store, "cover v1") {
+		t.Fatal("Expected released content to be kept")
+	}
+	if n := countChunks(t, root); n != 3 {
+		t.Errorf("Expected 3 chunks, got %d", n)
+	}
+
+	// storing released content again takes it back
+	storeString(t, store, "c", "model v1")
+	if obj, _ := store.loadObject(HashBytes([]byte("model v1"))); obj.Released != nil || obj.RefCount != 1 {
+		t.Errorf("Expected model v1 referenced again, got %+v", obj)
+	}
+	if err := store.DeleteFile("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// past 1h the .bin content goes, the .psd content has 48h
+	backdate(t, store, "model v1", 2*time.Hour)
+	backdate(t, store, "cover v1", 2*time.Hour)
+	res, err := gc.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Expired != 1 || res.Removed != 1 {
+		t.Errorf("Expected one object and chunk expired, got %+v", res)
+	}
+	if hasObject(store, "model v1") || !hasObject(store, "cover v1") {
+		t.Error("Expected only model v1 to expire")
+	}
+	backdate(t, store, "cover v1", 48*time.Hour)
+	if res, err = gc.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.Expired != 1 || hasObject(store, "cover v1") {
+		t.Errorf("Expected cover v1 to expire, got %+v", res)
+	}
+	if n := countChunks(t, root); n != 1 {
+		t.Errorf("Expected only the chunk of model v2, got %d", n)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	gc := NewGarbageCollector(store)
+	if err := config.SetRepoConfigValue(root, "lfs.maxUnreferencedAge", "720h"); err != nil {
+		t.Fatal(err)
+	}
+	storeString(t, store, "a", "first version")
+	storeString(t, store, "a", "second version")
+	storeString(t, store, "a", "third version")
+	backdate(t, store, "first version", time.Hour)
+
+	u, err := store.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Files != 1 || u.Objects != 3 || u.Unreferenced != 2 {
+		t.Errorf("Unexpected counts %+v", u)
+	}
+	if u.Referenced != 13 || u.Retained != 27 || u.Stored != 40 {
+		t.Errorf("Unexpected bytes %+v", u)
+	}
+
+	// the oldest released content makes room first
+	if err := config.SetRepoConfigValue(root, "lfs.quota", "30"); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := gc.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Evicted != 1 || plan.Stored != 27 || !hasObject(store, "first version") {
+		t.Errorf("Expected a plan evicting one object and deleting nothing, got %+v", plan)
+	}
+	res, err := gc.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Evicted != 1 || res.Freed != 13 || res.Overflow {
+		t.Errorf("Expected first version evicted, got %+v", res)
+	}
+	if hasObject(store, "first version") || !hasObject(store, "second version") {
+		t.Error("Expected only the first version to be evicted")
+	}
+
+	// content files point to stays past the quota
+	if err := config.SetRepoConfigValue(root, "lfs.quota", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = gc.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res.Evicted != 1 || !res.Overflow || res.Stored != 13 {
+		t.Errorf("Expected the store over its quota with third version, got %+v", res)
+	}
+	if _, err := store.Info("a"); err != nil {
+		t.Errorf("Expected a to keep its content: %v", err)
+	}
+}
@@ -1,199 +1,523 @@
 package lfs
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"evo/internal/config"
+	"evo/internal/everrors"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/storage"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
+// The store keeps each distinct content once, as an object counting the IDs
+// that point to it:
+//
+//	.evo/chunks/ab/cd/<sha256>        chunk content, shared between objects
+//	.evo/lfs/objects/<sha256>.json    object: chunk list and reference count
+//	.evo/lfs/refs/<id>                the content hash an ID points to
+//	.evo/lfs/lock                     held while the store is changed
+//
+// An object no ID refers to any more is deleted with the chunks no other
+// object uses, unless the Policy keeps it: then it is marked released and
+// left to the GC.
+//
+// Stores from before objects, one .evo/lfs/<id>/info.json per ID, and
+// chunks from before shards, .evo/chunks/<sha256>, are converted the first
+// time the store is locked.
+//
+// Chunks are kept through a storage.Backend under the same keys, ab/cd/<sha256>:
+// .evo/chunks by default, or wherever lfs.storage points, such as an S3
+// bucket shared by the replicas of a server.
+
+// lockTimeout is how long to wait for another process holding the store lock;
+// a lock older than lockStale was left by a crashed process
+const (
+	lockTimeout = 30 * time.Second
+	lockStale   = 5 * time.Minute
+)
+
 // Store manages large file storage with deduplication
 type Store struct {
-	mu   sync.RWMutex
-	root string
+	mu        sync.Mutex
+	root      string
+	chunks    storage.Backend
+	filters   []Filter // applied to new chunks
+	filterErr error
+
+	fmu      sync.Mutex
+	decoders map[string]Filter // opened to read chunks, by name
+}
+
+// object is stored content with the number of IDs pointing to it
+type object struct {
+	Size        int64       `json:"size"`
+	ContentHash string      `json:"contentHash"`
+	NumChunks   int         `json:"numChunks"`
+	Chunks      []ChunkInfo `json:"chunks"`
+	RefCount    int         `json:"refCount"`
+	Created     time.Time   `json:"created"`
+	Released    *time.Time  `json:"released,omitempty"` // when the last ID let go of it
+	Ext         string      `json:"ext,omitempty"`      // extension of the file that last held it
+}
+
+// since returns when the object was released, or else created
+func (o *object) since() time.Time {
+	if o.Released != nil {
+		return *o.Released
+	}
+	return o.Created
+}
+
+func (o *object) info(id string) *FileInfo {
+	return &FileInfo{
+		ID:          id,
+		Size:        o.Size,
+		ContentHash: o.ContentHash,
+		NumChunks:   o.NumChunks,
+		Chunks:      o.Chunks,
+		RefCount:    o.RefCount,
+		Created:     o.Created,
+	}
 }
 
 // NewStore creates a new LFS store at the given root path
 func NewStore(root string) *Store {
 	// Create necessary directories
-	os.MkdirAll(filepath.Join(root, ".evo", "lfs"), 0755)
-	os.MkdirAll(filepath.Join(root, ".evo", "chunks"), 0755)
+	os.MkdirAll(filepath.Join(repo.Dir(root), "lfs", "objects"), 0755)
+	os.MkdirAll(filepath.Join(repo.Dir(root), "lfs", "refs"), 0755)
+	os.MkdirAll(filepath.Join(repo.Dir(root), "chunks"), 0755)
 
-	return &Store{
-		root: root,
+	s := &Store{
+		root:   root,
+		chunks: openChunks(root),
 	}
+	s.filters, s.filterErr = openFilters(root)
+	return s
 }
 
-// StoreFile stores a file in chunks and returns file info
-func (s *Store) StoreFile(id string, r io.Reader, size int64) (*FileInfo, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Create file directory
-	fileDir := filepath.Join(s.root, ".evo", "lfs", id)
-	if err := os.MkdirAll(fileDir, 0755); err != nil {
-		return nil, err
+// openChunks opens the backend lfs.storage names, or .evo/chunks read
+// through to the chunks of the repository's alternates. A backend that fails
+// to open, or a config that can't be read to find it, fails every chunk
+// operation with the reason.
+func openChunks(root string) storage.Backend {
+	cfg, err := config.Load(root)
+	if err != nil {
+		return failed{fmt.Errorf("failed to load config: %w", err)}
+	}
+	spec, _ := cfg.Get("lfs.storage")
+	if spec == "" {
+		own := storage.NewFS(filepath.Join(repo.Dir(root), "chunks"))
+		alts, err := repo.Alternates(root)
+		if err != nil {
+			return failed{fmt.Errorf("failed to read alternates: %w", err)}
+		}
+		var lower []storage.Backend
+		for _, dir := range alts {
+			lower = append(lower, storage.NewFS(filepath.Join(dir, "chunks")))
+		}
+		return storage.NewOverlay(own, lower...)
+	}
+	b, err := storage.Open(spec, root)
+	if err != nil {
+		return failed{fmt.Errorf("failed to open LFS storage: %w", err)}
 	}
+	return b
+}
 
-	// Calculate content hash and split into chunks
-	chunks := make([]ChunkInfo, 0)
-	contentHash := NewHash()
+// failed is a backend that couldn't be opened
+type failed struct{ err error }
 
-	// Read file in chunks to calculate hash and store chunks
-	var totalSize int64
-	buf := make([]byte, ChunkSize)
-	for totalSize < size {
-		// Calculate remaining size and read size
-		remaining := size - totalSize
-		readSize := ChunkSize
-		if remaining < ChunkSize {
-			readSize = int(remaining)
-		}
+func (f failed) Get(string) (io.ReadCloser, error)            { return nil, f.err }
+func (f failed) Put(string, io.Reader) error                  { return f.err }
+func (f failed) Stat(string) (int64, error)                   { return 0, f.err }
+func (f failed) List(string, func(string, int64) error) error { return f.err }
+func (f failed) Delete(string) error                          { return f.err }
 
-		// Read chunk
-		n, err := io.ReadFull(r, buf[:readSize])
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
+func (s *Store) objectPath(hash string) string {
+	return filepath.Join(repo.Dir(s.root), "lfs", "objects", hash+".json")
+}
+
+func (s *Store) refPath(id string) string {
+	return filepath.Join(repo.Dir(s.root), "lfs", "refs", id)
+}
 
-		// Calculate content hash for this chunk
-		contentHash.Write(buf[:n])
+// chunkKey shards chunks by the first two bytes of their hash, as
+// ab/cd/abcd...
+func chunkKey(hash string) string {
+	if len(hash) < 4 {
+		return hash
+	}
+	return hash[:2] + "/" + hash[2:4] + "/" + hash
+}
 
-		// Calculate chunk hash and store chunk
-		chunk := make([]byte, n)
-		copy(chunk, buf[:n])
-		chunkHash := HashBytes(chunk)
+// chunkPath is where .evo/chunks keeps a chunk
+func (s *Store) chunkPath(hash string) string {
+	return filepath.Join(repo.Dir(s.root), "chunks", filepath.FromSlash(chunkKey(hash)))
+}
 
-		// Store chunk if it doesn't exist
-		chunkPath := filepath.Join(s.root, ".evo", "chunks", chunkHash)
-		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
-			// Store new chunk
-			chunkData := make([]byte, n)
-			copy(chunkData, chunk)
-			if err := os.WriteFile(chunkPath, chunkData, 0644); err != nil {
-				return nil, err
+// OpenChunk opens the content of a chunk, decoding it if it was stored
+// through filters. A missing chunk is an fs.ErrNotExist, one that doesn't
+// decode an ErrCorrupt.
+func (s *Store) OpenChunk(hash string) (io.ReadCloser, error) {
+	r, err := s.getChunk(hash)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(chunkMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	if !bytes.Equal(head[:n], chunkMagic) {
+		// stored as its content: read it from the backend as is
+		if sk, ok := r.(io.Seeker); ok {
+			if _, err := sk.Seek(0, io.SeekStart); err == nil {
+				return r, nil
 			}
 		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head[:n]), r), r}, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, 2*ChunkSize))
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.decodeChunk(hash, append(head, data...))
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(content)}, nil
+}
+
+// nopCloser keeps a bytes.Reader seekable, as io.NopCloser doesn't
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+// getChunk opens a chunk as stored, which may still be unsharded in a store
+// from before shards that no one has locked since
+func (s *Store) getChunk(hash string) (io.ReadCloser, error) {
+	r, err := s.chunks.Get(chunkKey(hash))
+	if errors.Is(err, fs.ErrNotExist) && IsHash(hash) {
+		if flat, ferr := s.chunks.Get(hash); ferr == nil {
+			return flat, nil
+		}
+	}
+	return r, err
+}
 
-		chunks = append(chunks, ChunkInfo{
-			Hash: chunkHash,
-			Size: int64(n),
-		})
+// lock takes the store lock of this process and of the repository, and
+// returns the function releasing both
+func (s *Store) lock() (func(), error) {
+	s.mu.Lock()
+	path := filepath.Join(repo.Dir(s.root), "lfs", "lock")
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to lock LFS store: %w", err)
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > lockStale {
+			logger.Warn("removing stale LFS store lock", "path", path)
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to lock LFS store: %s is held by another process", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	unlock := func() {
+		os.Remove(path)
+		s.mu.Unlock()
+	}
+	if err := s.migrate(); err != nil {
+		unlock()
+		return nil, err
+	}
+	if err := s.shardChunks(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
 
-		totalSize += int64(n)
+// StoreFile stores a file in chunks and returns file info. Storing under an
+// ID that already holds other content releases that content.
+func (s *Store) StoreFile(id string, r io.Reader, size int64) (*FileInfo, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-		// Break if we've read all the data
-		if totalSize >= size {
+	// Split into chunks, hashing the whole content on the way
+	chunks := make([]ChunkInfo, 0)
+	contentHash := NewHash()
+	var totalSize int64
+	for totalSize < size {
+		chunk, err := s.writeChunk(r, min(size-totalSize, ChunkSize), contentHash)
+		totalSize += chunk.Size
+		if err == io.ErrUnexpectedEOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
 	}
 
 	// Verify total size matches expected size
 	if totalSize != size {
 		return nil, fmt.Errorf("expected size %d, got %d", size, totalSize)
 	}
+	return s.point(id, &object{
+		Size:        size,
+		ContentHash: contentHash.Sum(),
+		NumChunks:   len(chunks),
+		Chunks:      chunks,
+	})
+}
 
-	hashStr := contentHash.Sum()
+// point makes id refer to the object with the content of obj, creating it
+// from obj if it isn't stored yet, and releases what id referred to before.
+// It runs with the store locked.
+func (s *Store) point(id string, obj *object) (*FileInfo, error) {
+	old, err := s.readRef(id)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	stored, err := s.loadObject(obj.ContentHash)
+	if err == nil && old == obj.ContentHash {
+		return stored.info(id), nil
+	}
+	if os.IsNotExist(err) {
+		stored = obj
+		stored.RefCount = 0
+		stored.Created = time.Now()
+	} else if err != nil {
+		return nil, err
+	}
+	stored.RefCount++
+	stored.Released, stored.Ext = nil, ""
+	if err := s.saveObject(stored); err != nil {
+		return nil, err
+	}
+	if err := writeAtomic(s.refPath(id), []byte(stored.ContentHash+"\n")); err != nil {
+		return nil, err
+	}
+	if old != "" && old != stored.ContentHash {
+		if err := s.release(id, old); err != nil {
+			return nil, err
+		}
+	}
+	return stored.info(id), nil
+}
 
-	// Check for existing file with same content hash
-	existingFiles, err := os.ReadDir(filepath.Join(s.root, ".evo", "lfs"))
-	if err == nil {
-		for _, f := range existingFiles {
-			if !f.IsDir() {
-				continue
-			}
-			existingInfo, err := s.loadFileInfo(f.Name())
-			if err != nil {
-				continue
-			}
-			if existingInfo.ContentHash == hashStr {
-				// Found existing file with same content
-				existingInfo.RefCount++
-				if err := s.saveFileInfo(f.Name(), existingInfo); err != nil {
-					return nil, err
-				}
+// writeChunk copies the next n bytes of r, at most a chunk, into a chunk,
+// also writing them to content. The bytes are hashed as they are read and
+// put under their hash once complete; backends put atomically, so a crash
+// never leaves a truncated chunk behind. If r ends early, the chunk is
+// dropped and io.ErrUnexpectedEOF returned with the bytes read.
+func (s *Store) writeChunk(r io.Reader, n int64, content io.Writer) (ChunkInfo, error) {
+	var buf bytes.Buffer
+	h := NewHash()
+	written, err := io.CopyN(io.MultiWriter(&buf, h, content), r, n)
+	if err == io.EOF {
+		return ChunkInfo{Size: written}, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return ChunkInfo{}, err
+	}
 
-				// Create new file info pointing to same chunks
-				newInfo := &FileInfo{
-					ID:          id,
-					Size:        existingInfo.Size,
-					ContentHash: existingInfo.ContentHash,
-					NumChunks:   existingInfo.NumChunks,
-					Chunks:      existingInfo.Chunks,
-					RefCount:    existingInfo.RefCount, // Use same ref count as existing file
-					Created:     time.Now(),
-				}
-				if err := s.saveFileInfo(id, newInfo); err != nil {
-					return nil, err
-				}
-				return newInfo, nil
-			}
-		}
+	chunk := ChunkInfo{Hash: h.Sum(), Size: written}
+	if s.HasChunk(chunk.Hash) {
+		chunk.Filters = s.chunkFilters(chunk.Hash)
+		return chunk, nil
+	}
+	data, names, err := s.encodeChunk(buf.Bytes())
+	if err != nil {
+		return ChunkInfo{}, err
 	}
+	chunk.Filters = names
+	if err := s.chunks.Put(chunkKey(chunk.Hash), bytes.NewReader(data)); err != nil {
+		return ChunkInfo{}, fmt.Errorf("failed to store chunk %s: %w", chunk.Hash, err)
+	}
+	return chunk, nil
+}
 
-	// Create file info
-	info := &FileInfo{
-		ID:          id,
-		Size:        size,
-		ContentHash: hashStr,
-		NumChunks:   len(chunks),
-		Chunks:      chunks,
-		RefCount:    1,
-		Created:     time.Now(),
+// writeAtomic replaces a file with data, syncing it before it takes the
+// place of the old one
+func writeAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := platform.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
 
-	// Save file info
-	if err := s.saveFileInfo(id, info); err != nil {
-		return nil, err
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failing to is not an error.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
 	}
+}
 
-	return info, nil
+func (s *Store) readRef(id string) (string, error) {
+	data, err := os.ReadFile(s.refPath(id))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
 }
 
-func (s *Store) saveFileInfo(id string, info *FileInfo) error {
-	data, err := json.Marshal(info)
+func (s *Store) saveObject(obj *object) error {
+	data, err := json.Marshal(obj)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(s.root, ".evo", "lfs", id, "info.json"), data, 0644)
+	return writeAtomic(s.objectPath(obj.ContentHash), data)
+}
+
+func (s *Store) loadObject(hash string) (*object, error) {
+	data, err := os.ReadFile(s.objectPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("failed to parse LFS object %s: %w", hash, err)
+	}
+	return &obj, nil
 }
 
 func (s *Store) loadFileInfo(id string) (*FileInfo, error) {
-	data, err := os.ReadFile(filepath.Join(s.root, ".evo", "lfs", id, "info.json"))
+	hash, err := s.readRef(id)
 	if err != nil {
 		return nil, err
 	}
-	var info FileInfo
-	if err := json.Unmarshal(data, &info); err != nil {
+	obj, err := s.loadObject(hash)
+	if err != nil {
 		return nil, err
 	}
-	return &info, nil
+	return obj.info(id), nil
 }
 
-// ReadFile reads a file from chunks into the writer
-func (s *Store) ReadFile(id string, w io.Writer) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// release drops the reference of id to an object. When none are left, the
+// object is kept as released if the policy keeps content last held by id,
+// or else deleted with the chunks no other object uses.
+func (s *Store) release(id, hash string) error {
+	obj, err := s.loadObject(hash)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	obj.RefCount--
+	if obj.RefCount > 0 {
+		return s.saveObject(obj)
+	}
+	policy, err := LoadPolicy(s.root)
+	if err != nil {
+		return fmt.Errorf("failed to read the LFS policy: %w", err)
+	}
+	if policy.keeps() {
+		if obj.Ext, err = s.extOf(id); err != nil {
+			return err
+		}
+		if policy.retention(obj.Ext) > 0 {
+			now := time.Now()
+			obj.Released = &now
+			return s.saveObject(obj)
+		}
+	}
+	if err := os.Remove(s.objectPath(hash)); err != nil {
+		return err
+	}
+	used, err := s.chunksInUse()
+	if err != nil {
+		return err
+	}
+	for _, chunk := range obj.Chunks {
+		if used[chunk.Hash] {
+			continue
+		}
+		if err := s.chunks.Delete(chunkKey(chunk.Hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Load file info
+// Info returns the info of a stored file
+func (s *Store) Info(id string) (*FileInfo, error) {
 	info, err := s.loadFileInfo(id)
+	if os.IsNotExist(err) && s.legacy() {
+		if err := s.convert(); err != nil {
+			return nil, err
+		}
+		info, err = s.loadFileInfo(id)
+	}
+	return info, err
+}
+
+// ReadFile reads a file from chunks into the writer
+func (s *Store) ReadFile(id string, w io.Writer) error {
+	info, err := s.Info(id)
 	if err != nil {
 		return err
 	}
 
-	// Read chunks
+	// Stream chunks
 	for _, chunk := range info.Chunks {
-		data, err := os.ReadFile(filepath.Join(s.root, ".evo", "chunks", chunk.Hash))
+		f, err := s.OpenChunk(chunk.Hash)
 		if err != nil {
 			return err
 		}
-		if _, err := w.Write(data); err != nil {
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
 			return err
 		}
 	}
@@ -201,89 +525,430 @@ func (s *Store) ReadFile(id string, w io.Writer) error {
 	return nil
 }
 
-// DeleteFile deletes a file and its chunks if no longer referenced
-func (s *Store) DeleteFile(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Load file info
-	info, err := s.loadFileInfo(id)
+// ReadFileRange writes n bytes of a file from offset off into w, reading
+// only the chunks they fall in. A range running past the end of the file
+// stops there; one starting past it is an error.
+func (s *Store) ReadFileRange(id string, off, n int64, w io.Writer) error {
+	if off < 0 || n < 0 {
+		return fmt.Errorf("invalid range of %d bytes at %d", n, off)
+	}
+	info, err := s.Info(id)
 	if err != nil {
 		return err
 	}
+	if off > info.Size {
+		return fmt.Errorf("offset %d is past the end of %s (%d bytes)", off, id, info.Size)
+	}
+	end := off + min(n, info.Size-off)
+	var pos int64 // where the chunk starts in the file
+	for _, chunk := range info.Chunks {
+		if pos >= end {
+			break
+		}
+		start := pos
+		pos += chunk.Size
+		if pos <= off {
+			continue
+		}
+		f, err := s.OpenChunk(chunk.Hash)
+		if err != nil {
+			return err
+		}
+		err = copyRange(w, f, max(off-start, 0), min(end, pos)-max(off, start))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %s: %w", chunk.Hash, err)
+		}
+	}
+	return nil
+}
+
+// copyRange copies n bytes of r from offset skip into w, seeking past what
+// is skipped when r can
+func copyRange(w io.Writer, r io.Reader, skip, n int64) error {
+	if skip > 0 {
+		var err error
+		if sk, ok := r.(io.Seeker); ok {
+			_, err = sk.Seek(skip, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, r, skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.CopyN(w, r, n)
+	return err
+}
 
-	// Delete file info
-	fileDir := filepath.Join(s.root, ".evo", "lfs", id)
-	if err := os.RemoveAll(fileDir); err != nil {
+// HasChunk reports whether a chunk is stored
+func (s *Store) HasChunk(hash string) bool {
+	if _, err := s.chunks.Stat(chunkKey(hash)); err == nil {
+		return true
+	}
+	if !IsHash(hash) {
+		return false
+	}
+	_, err := s.chunks.Stat(hash)
+	return err == nil
+}
+
+// PutChunk stores a chunk received from elsewhere, such as a remote, checking
+// that its content has the hash it was sent under
+func (s *Store) PutChunk(hash string, r io.Reader) error {
+	if !IsHash(hash) {
+		return fmt.Errorf("invalid chunk hash %q", hash)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, ChunkSize+1))
+	if err != nil {
 		return err
 	}
+	if len(data) > ChunkSize {
+		return fmt.Errorf("%w: chunk %s is larger than %d bytes", ErrCorrupt, hash, ChunkSize)
+	}
+	if got := HashBytes(data); got != hash {
+		return fmt.Errorf("%w: chunk %s has hash %s", ErrCorrupt, hash, got)
+	}
+	_, err = s.writeChunk(bytes.NewReader(data), int64(len(data)), io.Discard)
+	return err
+}
 
-	// Find other files with same content hash
-	existingFiles, err := os.ReadDir(filepath.Join(s.root, ".evo", "lfs"))
-	if err == nil {
-		for _, f := range existingFiles {
-			if !f.IsDir() || f.Name() == id {
-				continue
-			}
-			existingInfo, err := s.loadFileInfo(f.Name())
-			if err != nil {
-				continue
-			}
-			if existingInfo.ContentHash == info.ContentHash {
-				// Found another file with same content, decrement its ref count
-				existingInfo.RefCount--
-				if err := s.saveFileInfo(f.Name(), existingInfo); err != nil {
-					return err
-				}
-				break
-			}
+// ErrCorrupt is returned when content doesn't match the hash it is stored
+// or sent under
+var ErrCorrupt = everrors.New(everrors.ErrCorrupt, "content does not match its hash")
+
+// MissingChunksError is returned when linking a file whose chunks aren't
+// all stored
+type MissingChunksError struct {
+	Chunks []string
+}
+
+func (e *MissingChunksError) Error() string {
+	return fmt.Sprintf("%d of the chunks are missing", len(e.Chunks))
+}
+
+// Missing returns the chunks of hashes that aren't stored
+func (s *Store) Missing(hashes []string) []string {
+	out := []string{}
+	for _, h := range hashes {
+		if !s.HasChunk(h) {
+			out = append(out, h)
 		}
 	}
+	return out
+}
 
-	// Delete unreferenced chunks
-	for _, chunk := range info.Chunks {
-		chunkPath := filepath.Join(s.root, ".evo", "chunks", chunk.Hash)
-		if s.isChunkReferenced(chunk.Hash) {
-			continue
+// Link stores a file whose chunks were put one by one, as info describes it,
+// after checking that they add up to its size and content hash
+func (s *Store) Link(id string, info *FileInfo) (*FileInfo, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	hashes := make([]string, len(info.Chunks))
+	for i, chunk := range info.Chunks {
+		hashes[i] = chunk.Hash
+	}
+	if missing := s.Missing(hashes); len(missing) > 0 {
+		return nil, &MissingChunksError{Chunks: missing}
+	}
+	// the chunks are listed as this store keeps them, whatever filters the
+	// sender's went through
+	chunks := make([]ChunkInfo, len(info.Chunks))
+	contentHash := NewHash()
+	var size int64
+	for i, chunk := range info.Chunks {
+		f, err := s.OpenChunk(chunk.Hash)
+		if err != nil {
+			return nil, err
 		}
-		if err := os.Remove(chunkPath); err != nil {
-			return err
+		n, err := io.Copy(contentHash, f)
+		f.Close()
+		if err != nil {
+			return nil, err
 		}
+		chunks[i] = ChunkInfo{Hash: chunk.Hash, Size: n, Filters: s.chunkFilters(chunk.Hash)}
+		size += n
 	}
+	if size != info.Size || contentHash.Sum() != info.ContentHash {
+		return nil, fmt.Errorf("%w: the chunks of %s don't add up to its content", ErrCorrupt, id)
+	}
+	return s.point(id, &object{
+		Size:        info.Size,
+		ContentHash: info.ContentHash,
+		NumChunks:   len(chunks),
+		Chunks:      chunks,
+	})
+}
 
-	return nil
+// DeleteFile deletes a file, and its content once no other file has it
+func (s *Store) DeleteFile(id string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	hash, err := s.readRef(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.refPath(id)); err != nil {
+		return err
+	}
+	return s.release(id, hash)
 }
 
-// isChunkReferenced checks if a chunk is referenced by any file
-func (s *Store) isChunkReferenced(hash string) bool {
-	files, err := os.ReadDir(filepath.Join(s.root, ".evo", "lfs"))
+// chunksInUse returns the chunks of every object
+func (s *Store) chunksInUse() (map[string]bool, error) {
+	objs, err := s.objects()
 	if err != nil {
-		return false
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, obj := range objs {
+		for _, chunk := range obj.Chunks {
+			used[chunk.Hash] = true
+		}
 	}
+	return used, nil
+}
 
-	for _, file := range files {
-		if !file.IsDir() {
+// OwnChunks copies into the store's own backend the chunks in use that it
+// reads from an alternate, returning how many it copied
+func (s *Store) OwnChunks() (int, error) {
+	o, ok := s.chunks.(*storage.Overlay)
+	if !ok {
+		return 0, nil
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	used, err := s.chunksInUse()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for hash := range used {
+		key := chunkKey(hash)
+		if _, err := o.Backend.Stat(key); !errors.Is(err, storage.ErrNotFound) {
 			continue
 		}
-
-		info, err := s.loadFileInfo(file.Name())
+		r, err := o.Get(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		err = o.Backend.Put(key, r)
+		r.Close()
 		if err != nil {
+			return n, fmt.Errorf("failed to copy chunk %s: %w", hash, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// objects returns every stored object
+func (s *Store) objects() ([]*object, error) {
+	entries, err := os.ReadDir(filepath.Join(repo.Dir(s.root), "lfs", "objects"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []*object
+	for _, e := range entries {
+		hash, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
 			continue
 		}
+		obj, err := s.loadObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, obj)
+	}
+	return out, nil
+}
 
-		for _, chunk := range info.Chunks {
-			if chunk.Hash == hash {
-				return true
-			}
+// Files returns the info of every stored file
+func (s *Store) Files() ([]*FileInfo, error) {
+	if s.legacy() {
+		if err := s.convert(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(repo.Dir(s.root), "lfs", "refs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []*FileInfo
+	for _, e := range entries {
+		if e.IsDir() || strings.Contains(e.Name(), ".tmp") {
+			continue
+		}
+		info, err := s.loadFileInfo(e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load info of %s: %w", e.Name(), err)
 		}
+		out = append(out, info)
 	}
+	return out, nil
+}
 
+// legacy reports whether the store holds files from before objects
+func (s *Store) legacy() bool {
+	entries, err := os.ReadDir(filepath.Join(repo.Dir(s.root), "lfs"))
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != "objects" && e.Name() != "refs" {
+			return true
+		}
+	}
 	return false
 }
 
-func min(a, b int64) int64 {
-	if a < b {
-		return a
+// Lock takes the store lock, keeping every process from changing the store
+// until the returned function is called
+func (s *Store) Lock() (func(), error) {
+	return s.lock()
+}
+
+// Upgrade converts a store from before objects or shards now rather than the
+// first time it is locked
+func (s *Store) Upgrade() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
 	}
-	return b
+	unlock()
+	return nil
+}
+
+// IsHash reports whether name is a hex SHA-256
+func IsHash(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// shardChunks moves the chunks of a store from before shards into theirs.
+// It runs with the store locked.
+func (s *Store) shardChunks() error {
+	dir := filepath.Join(repo.Dir(s.root), "chunks")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	moved := 0
+	for _, e := range entries {
+		if e.IsDir() || !IsHash(e.Name()) {
+			continue
+		}
+		path := s.chunkPath(e.Name())
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := platform.Rename(filepath.Join(dir, e.Name()), path); err != nil {
+			return fmt.Errorf("failed to shard chunk %s: %w", e.Name(), err)
+		}
+		moved++
+	}
+	if moved > 0 {
+		logger.Info("sharded LFS chunks", "chunks", moved)
+	}
+	return nil
+}
+
+// Chunks calls fn with the hash and size of every stored chunk
+func (s *Store) Chunks(fn func(hash string, size int64) error) error {
+	return s.chunks.List("", func(key string, size int64) error {
+		if hash := path.Base(key); IsHash(hash) {
+			return fn(hash, size)
+		}
+		return nil
+	})
+}
+
+// convert converts an older store, which locking the store does
+func (s *Store) convert() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	unlock()
+	return nil
+}
+
+// migrate converts the per-ID info.json files of an older store into refs
+// and objects counting them. It runs with the store locked.
+func (s *Store) migrate() error {
+	if !s.legacy() {
+		return nil
+	}
+	dir := filepath.Join(repo.Dir(s.root), "lfs")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() || id == "objects" || id == "refs" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, id, "info.json"))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to convert LFS file %s: %w", id, err)
+		}
+		var old FileInfo
+		if err == nil {
+			if err := json.Unmarshal(data, &old); err != nil {
+				return fmt.Errorf("failed to convert LFS file %s: %w", id, err)
+			}
+		}
+		if old.ContentHash != "" {
+			if _, err := s.readRef(id); errors.Is(err, os.ErrNotExist) {
+				obj, err := s.loadObject(old.ContentHash)
+				if os.IsNotExist(err) {
+					obj = &object{
+						Size:        old.Size,
+						ContentHash: old.ContentHash,
+						NumChunks:   old.NumChunks,
+						Chunks:      old.Chunks,
+						Created:     old.Created,
+					}
+				} else if err != nil {
+					return err
+				}
+				obj.RefCount++
+				if err := s.saveObject(obj); err != nil {
+					return err
+				}
+				if err := writeAtomic(s.refPath(id), []byte(old.ContentHash+"\n")); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(dir, id)); err != nil {
+			return err
+		}
+	}
+	logger.Info("converted LFS store to content-addressed objects")
+	return nil
 }
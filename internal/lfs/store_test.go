@@ -2,9 +2,17 @@ package lfs
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"evo/internal/config"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStore(t *testing.T) {
@@ -76,16 +84,9 @@ func TestStore(t *testing.T) {
 			t.Errorf("Expected refCount 2, got %d", info.RefCount)
 		}
 
-		// Check chunks directory
-		chunksDir := filepath.Join(tmpDir, ".evo", "chunks")
-		entries, err := os.ReadDir(chunksDir)
-		if err != nil {
-			t.Fatal(err)
-		}
-
 		// Should only have one chunk since content is identical
-		if len(entries) != 1 {
-			t.Errorf("Expected 1 chunk, got %d", len(entries))
+		if c := countChunks(t, tmpDir); c != 1 {
+			t.Errorf("Expected 1 chunk, got %d", c)
 		}
 	})
 
@@ -162,13 +163,8 @@ func TestLargeFileChunking(t *testing.T) {
 		}
 
 		// Check chunks directory
-		chunksDir := filepath.Join(tmpDir, ".evo", "chunks")
-		entries, err := os.ReadDir(chunksDir)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if len(entries) != expectedChunks {
-			t.Errorf("Expected %d chunk files, got %d", expectedChunks, len(entries))
+		if c := countChunks(t, tmpDir); c != expectedChunks {
+			t.Errorf("Expected %d chunk files, got %d", expectedChunks, c)
 		}
 	})
 
@@ -224,20 +220,315 @@ func TestGarbageCollection(t *testing.T) {
 		}
 
 		// Run GC
-		if err := gc.Run(); err != nil {
+		if _, err := gc.Run(context.Background()); err != nil {
 			t.Fatal(err)
 		}
 
 		// Verify only file3's chunks remain
-		chunksDir := filepath.Join(tmpDir, ".evo", "chunks")
-		entries, err := os.ReadDir(chunksDir)
+		expectedChunks := 1 // Only file3's chunk should remain
+		if c := countChunks(t, tmpDir); c != expectedChunks {
+			t.Errorf("Expected %d chunks after GC, got %d", expectedChunks, c)
+		}
+	})
+}
+
+func storeString(t *testing.T, s *Store, id, content string) *FileInfo {
+	t.Helper()
+	info, err := s.StoreFile(id, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func countChunks(t *testing.T, root string) int {
+	t.Helper()
+	n := 0
+	err := NewStore(root).Chunks(func(string, int64) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRefCountLifecycle(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+
+	storeString(t, store, "a", "shared")
+	storeString(t, store, "b", "shared")
+	// storing an ID's content again adds no reference
+	if info := storeString(t, store, "a", "shared"); info.RefCount != 2 {
+		t.Errorf("Expected refCount 2 after restoring a, got %d", info.RefCount)
+	}
+	if info := storeString(t, store, "c", "shared"); info.RefCount != 3 {
+		t.Errorf("Expected refCount 3, got %d", info.RefCount)
+	}
+
+	// new content under an ID releases the old
+	storeString(t, store, "c", "other")
+	if info, _ := store.loadFileInfo("b"); info.RefCount != 2 {
+		t.Errorf("Expected refCount 2 after replacing c, got %d", info.RefCount)
+	}
+	if n := countChunks(t, root); n != 2 {
+		t.Errorf("Expected 2 chunks, got %d", n)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if err := store.DeleteFile(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.loadObject(HashBytes([]byte("shared"))); !os.IsNotExist(err) {
+		t.Errorf("Expected the object to be deleted with its last reference, got %v", err)
+	}
+	if n := countChunks(t, root); n != 1 {
+		t.Errorf("Expected only the chunk of c, got %d", n)
+	}
+	if err := store.DeleteFile("a"); !os.IsNotExist(err) {
+		t.Errorf("Expected deleting a twice to fail, got %v", err)
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].ID != "c" || files[0].RefCount != 1 {
+		t.Errorf("Expected only c with one reference, got %+v", files)
+	}
+}
+
+func TestConcurrentStore(t *testing.T) {
+	root := t.TempDir()
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// a store per goroutine, as separate processes would have
+			_, err := NewStore(root).StoreFile(fmt.Sprintf("id%d", i), strings.NewReader("same content"), 12)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
 		if err != nil {
 			t.Fatal(err)
 		}
+	}
+	store := NewStore(root)
+	info, err := store.loadFileInfo("id0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RefCount != n {
+		t.Errorf("Expected refCount %d, got %d", n, info.RefCount)
+	}
 
-		expectedChunks := 1 // Only file3's chunk should remain
-		if len(entries) != expectedChunks {
-			t.Errorf("Expected %d chunks after GC, got %d", expectedChunks, len(entries))
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := NewStore(root).DeleteFile(fmt.Sprintf("id%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c := countChunks(t, root); c != 0 {
+		t.Errorf("Expected no chunks left, got %d", c)
+	}
+}
+
+func TestMigrateLegacyStore(t *testing.T) {
+	root := t.TempDir()
+	chunk := []byte("legacy")
+	hash := HashBytes(chunk)
+	if err := os.MkdirAll(filepath.Join(root, ".evo", "chunks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".evo", "chunks", hash), chunk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the old store gave both IDs refCount 2
+	for _, id := range []string{"x", "y"} {
+		dir := filepath.Join(root, ".evo", "lfs", id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
 		}
-	})
+		data, _ := json.Marshal(FileInfo{ID: id, Size: 6, ContentHash: hash, NumChunks: 1, Chunks: []ChunkInfo{{Hash: hash, Size: 6}}, RefCount: 2})
+		if err := os.WriteFile(filepath.Join(dir, "info.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewStore(root)
+	var buf bytes.Buffer
+	if err := store.ReadFile("x", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "legacy" {
+		t.Errorf("Expected legacy content, got %q", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(root, ".evo", "chunks", hash[:2], hash[2:4], hash)); err != nil {
+		t.Errorf("Expected the chunk to move into its shard: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".evo", "lfs", "x")); !os.IsNotExist(err) {
+		t.Error("Expected the legacy directory to be removed")
+	}
+	info, err := store.loadFileInfo("y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RefCount != 2 {
+		t.Errorf("Expected refCount 2, got %d", info.RefCount)
+	}
+	if err := store.DeleteFile("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteFile("y"); err != nil {
+		t.Fatal(err)
+	}
+	if c := countChunks(t, root); c != 0 {
+		t.Errorf("Expected no chunks left, got %d", c)
+	}
+}
+
+func TestShardedChunks(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	content := "sharded content"
+	info := storeString(t, store, "a", content)
+	hash := info.Chunks[0].Hash
+	path := filepath.Join(root, ".evo", "chunks", hash[:2], hash[2:4], hash)
+	if data, err := os.ReadFile(path); err != nil || string(data) != content {
+		t.Fatalf("Expected chunk at %s, got %q, %v", path, data, err)
+	}
+
+	// a chunk left half written is neither counted nor kept by gc
+	tmp := filepath.Join(root, ".evo", "chunks", "tmp", "chunk-stale")
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(tmp, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if c := countChunks(t, root); c != 1 {
+		t.Errorf("Expected 1 chunk, got %d", c)
+	}
+	if _, err := NewGarbageCollector(store).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("Expected gc to remove the stale temp chunk")
+	}
+
+	// a short read stores nothing
+	if _, err := store.StoreFile("b", strings.NewReader("short"), 100); err == nil {
+		t.Error("Expected an error for a short read")
+	}
+	if c := countChunks(t, root); c != 1 {
+		t.Errorf("Expected 1 chunk after a failed store, got %d", c)
+	}
+}
+
+func TestChunkStorage(t *testing.T) {
+	root := t.TempDir()
+	if err := config.SetRepoConfigValue(root, "lfs.storage", "file:shared"); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(root)
+	content := strings.Repeat("stored elsewhere\n", 100)
+	info := storeString(t, store, "a", content)
+	hash := info.Chunks[0].Hash
+	if _, err := os.Stat(filepath.Join(root, "shared", hash[:2], hash[2:4], hash)); err != nil {
+		t.Fatalf("Expected the chunk in the configured storage: %v", err)
+	}
+	if local, _ := filepath.Glob(filepath.Join(root, ".evo", "chunks", "*", "*", "*")); len(local) != 0 {
+		t.Errorf("Expected no chunks in .evo/chunks, got %v", local)
+	}
+	var b bytes.Buffer
+	if err := store.ReadFile("a", &b); err != nil || b.String() != content {
+		t.Fatalf("Expected the content back, got %q, %v", b.String(), err)
+	}
+
+	if err := store.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+	if store.HasChunk(hash) {
+		t.Error("Expected the chunk deleted with its last file")
+	}
+
+	// a storage that can't be opened fails with the reason
+	if err := config.SetRepoConfigValue(root, "lfs.storage", "ftp://host/dir"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewStore(root).StoreFile("b", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "unknown storage scheme") {
+		t.Errorf("Expected an unknown scheme error, got %v", err)
+	}
+}
+
+func TestReadFileRange(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root)
+	content := make([]byte, 2*ChunkSize+500)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	info, err := store.StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(content))
+
+	for _, tc := range []struct {
+		name     string
+		off, n   int64
+		from, to int64
+	}{
+		{"Header", 0, 16, 0, 16},
+		{"Within a chunk", ChunkSize + 10, 100, ChunkSize + 10, ChunkSize + 110},
+		{"Across chunks", ChunkSize - 5, 10, ChunkSize - 5, ChunkSize + 5},
+		{"Whole file", 0, size, 0, size},
+		{"Past the end", size - 20, 1000, size - 20, size},
+		{"At the end", size, 10, size, size},
+		{"Nothing", 5, 0, 5, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := store.ReadFileRange("big", tc.off, tc.n, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), content[tc.from:tc.to]) {
+				t.Errorf("Expected bytes %d-%d, got %d bytes", tc.from, tc.to, buf.Len())
+			}
+		})
+	}
+
+	if err := store.ReadFileRange("big", size+1, 1, io.Discard); err == nil {
+		t.Error("Expected an error reading past the end")
+	}
+	if err := store.ReadFileRange("big", -1, 1, io.Discard); err == nil {
+		t.Error("Expected an error for a negative offset")
+	}
+
+	// only the chunks of the range are read
+	if err := store.RemoveChunk(info.Chunks[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := store.ReadFileRange("big", 2*ChunkSize, 500, &buf); err != nil {
+		t.Fatalf("Expected the last chunk alone to be read: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content[2*ChunkSize:]) {
+		t.Error("Unexpected content of the last chunk")
+	}
 }
@@ -25,8 +25,9 @@ type FileInfo struct {
 
 // ChunkInfo contains metadata about a file chunk
 type ChunkInfo struct {
-	Hash string `json:"hash"` // Hash of chunk content
-	Size int64  `json:"size"` // Size of chunk in bytes
+	Hash    string   `json:"hash"`              // Hash of chunk content
+	Size    int64    `json:"size"`              // Size of chunk content in bytes
+	Filters []string `json:"filters,omitempty"` // Filters the chunk is stored through, in order
 }
 
 // Hash represents a content-addressable hash
@@ -0,0 +1,229 @@
+// Package locks keeps advisory locks on files that can't be merged, such as
+// binary assets. A lock only warns: status and commit point out changes to
+// files someone else holds, nothing is refused.
+//
+// A repository records in .evo/locks the locks it took and the ones it last
+// saw on its remotes; a server records the locks its clients take through
+// the API.
+package locks
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/merge"
+	"evo/internal/ops"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Lock is a file held by one person
+type Lock struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Created time.Time `json:"created"`
+	Remote  string    `json:"remote,omitempty"` // remote holding the lock, empty when only local
+}
+
+// Owner returns "Name <email>" of the holder
+func (l Lock) Owner() string {
+	return fmt.Sprintf("%s <%s>", l.Name, l.Email)
+}
+
+// HeldError is returned when taking or releasing a lock someone else holds
+type HeldError struct {
+	Lock Lock
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s since %s", e.Lock.Path, e.Lock.Owner(), e.Lock.Created.Local().Format("2006-01-02 15:04"))
+}
+
+// Is makes a held lock an everrors.ErrConflict
+func (e *HeldError) Is(target error) bool {
+	return target == everrors.ErrConflict
+}
+
+// ErrNotLocked is returned when releasing a file nobody holds
+var ErrNotLocked = everrors.New(everrors.ErrNotFound, "not locked")
+
+func locksPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "locks")
+}
+
+// List returns the recorded locks sorted by path
+func List(repoPath string) ([]Lock, error) {
+	data, err := os.ReadFile(locksPath(repoPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read locks: %w", err)
+	}
+	var ls []Lock
+	if err := json.Unmarshal(data, &ls); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", locksPath(repoPath), err)
+	}
+	return ls, nil
+}
+
+func save(repoPath string, ls []Lock) error {
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Path < ls[j].Path })
+	data, err := json.MarshalIndent(ls, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := locksPath(repoPath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write locks: %w", err)
+	}
+	return platform.Rename(tmp, locksPath(repoPath))
+}
+
+// Acquire records l unless its file is locked by someone else. Taking a lock
+// one already holds keeps the older one.
+func Acquire(repoPath string, l Lock) (Lock, error) {
+	ls, err := List(repoPath)
+	if err != nil {
+		return Lock{}, err
+	}
+	for i, held := range ls {
+		if held.Path != l.Path {
+			continue
+		}
+		if held.Email != l.Email {
+			return Lock{}, &HeldError{Lock: held}
+		}
+		if held.Remote == l.Remote {
+			return held, nil
+		}
+		ls = append(ls[:i], ls[i+1:]...)
+		break
+	}
+	if l.Created.IsZero() {
+		l.Created = time.Now().UTC()
+	}
+	return l, save(repoPath, append(ls, l))
+}
+
+// Release removes the lock on path held by email; force removes it whoever
+// holds it
+func Release(repoPath, path, email string, force bool) (Lock, error) {
+	ls, err := List(repoPath)
+	if err != nil {
+		return Lock{}, err
+	}
+	for i, held := range ls {
+		if held.Path != path {
+			continue
+		}
+		if held.Email != email && !force {
+			return Lock{}, &HeldError{Lock: held}
+		}
+		return held, save(repoPath, append(ls[:i], ls[i+1:]...))
+	}
+	return Lock{}, fmt.Errorf("%s: %w", path, ErrNotLocked)
+}
+
+// Replace records ls as every lock held on remote, replacing those last seen
+// there
+func Replace(repoPath, remote string, ls []Lock) error {
+	old, err := List(repoPath)
+	if err != nil {
+		return err
+	}
+	var out []Lock
+	for _, l := range old {
+		if l.Remote != remote {
+			out = append(out, l)
+		}
+	}
+	for _, l := range ls {
+		l.Remote = remote
+		out = append(out, l)
+	}
+	return save(repoPath, out)
+}
+
+// HeldByOthers returns the locks on paths that someone other than email holds
+func HeldByOthers(repoPath string, paths []string, email string) ([]Lock, error) {
+	ls, err := List(repoPath)
+	if err != nil || len(ls) == 0 {
+		return nil, err
+	}
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[filepath.ToSlash(p)] = true
+	}
+	var out []Lock
+	for _, l := range ls {
+		if l.Email != email && want[l.Path] {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// Modified returns the locks someone other than email holds on files the
+// working tree changed since they were last committed to stream
+func Modified(repoPath, stream, email string) ([]Lock, error) {
+	ls, err := List(repoPath)
+	if err != nil || len(ls) == 0 {
+		return nil, err
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := index.LoadHashes(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var out []Lock
+	for _, l := range ls {
+		if l.Email == email {
+			continue
+		}
+		rel := filepath.FromSlash(l.Path)
+		last, known := hashes[p2id[rel]]
+		sum, err := hashFile(filepath.Join(repoPath, rel))
+		switch {
+		case os.IsNotExist(err):
+			// deleted, unless it was never committed
+			if known {
+				out = append(out, l)
+			}
+		case err != nil:
+			return nil, err
+		case !known || index.GranularSum(sum, string(attrs.GranularityFor(rel))) != last.Sum ||
+			ops.LogSize(filepath.Join(repo.Dir(repoPath), "ops", stream, p2id[rel]+".bin")) != last.LogSize:
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
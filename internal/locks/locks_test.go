@@ -0,0 +1,95 @@
+package locks
+
+import (
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	ann := Lock{Path: "art/logo.psd", Name: "Ann", Email: "ann@example.com"}
+	bob := Lock{Path: "art/logo.psd", Name: "Bob", Email: "bob@example.com"}
+
+	l, err := Acquire(rp, ann)
+	require.NoError(t, err)
+	assert.False(t, l.Created.IsZero())
+	again, err := Acquire(rp, ann)
+	require.NoError(t, err)
+	assert.Equal(t, l.Created, again.Created, "taking a held lock keeps it")
+
+	_, err = Acquire(rp, bob)
+	var held *HeldError
+	require.ErrorAs(t, err, &held)
+	assert.Equal(t, "ann@example.com", held.Lock.Email)
+	assert.Contains(t, err.Error(), "locked by Ann <ann@example.com>")
+
+	_, err = Release(rp, ann.Path, bob.Email, false)
+	assert.ErrorAs(t, err, &held)
+	others, err := HeldByOthers(rp, []string{"art/logo.psd", "x"}, bob.Email)
+	require.NoError(t, err)
+	assert.Len(t, others, 1)
+	others, err = HeldByOthers(rp, []string{"art/logo.psd"}, ann.Email)
+	require.NoError(t, err)
+	assert.Empty(t, others)
+
+	_, err = Release(rp, ann.Path, bob.Email, true)
+	require.NoError(t, err)
+	_, err = Release(rp, ann.Path, ann.Email, false)
+	assert.ErrorIs(t, err, ErrNotLocked)
+
+	// locks seen on a remote replace those seen there before
+	_, err = Acquire(rp, Lock{Path: "local.bin", Name: "Ann", Email: ann.Email})
+	require.NoError(t, err)
+	require.NoError(t, Replace(rp, "origin", []Lock{bob}))
+	require.NoError(t, Replace(rp, "origin", []Lock{{Path: "b.bin", Name: "Bob", Email: bob.Email}}))
+	ls, err := List(rp)
+	require.NoError(t, err)
+	require.Len(t, ls, 2)
+	assert.Equal(t, "b.bin", ls[0].Path)
+	assert.Equal(t, "origin", ls[0].Remote)
+	assert.Equal(t, "local.bin", ls[1].Path)
+}
+
+func TestModified(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		require.NoError(t, os.WriteFile(filepath.Join(rp, name), []byte(name), 0644))
+	}
+	require.NoError(t, index.UpdateIndex(rp))
+	_, err := ingest.IngestLocalChanges(context.Background(), rp, "main")
+	require.NoError(t, err)
+	eops, err := commits.GatherNewOps(rp, "main")
+	require.NoError(t, err)
+	_, err = commits.CreateCommit(rp, "main", "add", "Ann", "ann@example.com", eops, false)
+	require.NoError(t, err)
+
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "new.bin"} {
+		_, err := Acquire(rp, Lock{Path: name, Name: "Bob", Email: "bob@example.com"})
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "a.bin"), []byte("changed"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(rp, "b.bin")))
+
+	ls, err := Modified(rp, "main", "ann@example.com")
+	require.NoError(t, err)
+	var paths []string
+	for _, l := range ls {
+		paths = append(paths, l.Path)
+	}
+	assert.Equal(t, []string{"a.bin", "b.bin"}, paths)
+
+	ls, err = Modified(rp, "main", "bob@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, ls, "one's own locks don't count")
+}
@@ -0,0 +1,73 @@
+package locks
+
+import (
+	"encoding/json"
+	"errors"
+	"evo/internal/remotes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The server side of locks is the evo serve API under /api/v1/locks
+
+// AcquireRemote takes a lock on a remote
+func AcquireRemote(r *remotes.Remote, l Lock) (Lock, error) {
+	l.Remote = ""
+	var held Lock
+	if err := r.Do("POST", "/api/v1/locks", l, &held); err != nil {
+		return Lock{}, heldError(r, err)
+	}
+	held.Remote = r.Name
+	return held, nil
+}
+
+// ReleaseRemote releases a lock on a remote, as Release does locally
+func ReleaseRemote(r *remotes.Remote, path, email string, force bool) (Lock, error) {
+	q := url.Values{"email": {email}}
+	if force {
+		q.Set("force", "1")
+	}
+	segs := strings.Split(path, "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	var l Lock
+	if err := r.Do("DELETE", "/api/v1/locks/"+strings.Join(segs, "/")+"?"+q.Encode(), nil, &l); err != nil {
+		var re *remotes.Error
+		if errors.As(err, &re) && re.Status == http.StatusNotFound {
+			return Lock{}, ErrNotLocked
+		}
+		return Lock{}, heldError(r, err)
+	}
+	l.Remote = r.Name
+	return l, nil
+}
+
+// ListRemote returns the locks held on a remote
+func ListRemote(r *remotes.Remote) ([]Lock, error) {
+	var ls []Lock
+	if err := r.Do("GET", "/api/v1/locks", nil, &ls); err != nil {
+		return nil, err
+	}
+	for i := range ls {
+		ls[i].Remote = r.Name
+	}
+	return ls, nil
+}
+
+// heldError turns a 409 response into a *HeldError
+func heldError(r *remotes.Remote, err error) error {
+	var re *remotes.Error
+	if !errors.As(err, &re) || re.Status != http.StatusConflict {
+		return err
+	}
+	var body struct {
+		Lock Lock `json:"lock"`
+	}
+	if json.Unmarshal(re.Body, &body) != nil || body.Lock.Path == "" {
+		return err
+	}
+	body.Lock.Remote = r.Name
+	return &HeldError{Lock: body.Lock}
+}
@@ -0,0 +1,137 @@
+// Package log is evo's structured logger. Every subsystem gets its own logger
+// from For; records go to stderr as text or JSON. Warnings and errors are shown
+// by default, --verbose lowers the level, and EVO_TRACE turns on tracing for
+// all subsystems ("1" or "all") or a comma separated list of them.
+package log
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LevelTrace is below slog's debug level and only shown for traced subsystems
+const LevelTrace = slog.LevelDebug - 4
+
+// Format selects how records are written
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// Options configures the process-wide logger
+type Options struct {
+	Level  slog.Level
+	Format Format
+	Output io.Writer
+	Trace  string // EVO_TRACE syntax
+}
+
+var (
+	mu       sync.RWMutex
+	handler  slog.Handler
+	minLevel slog.Level
+	traceAll bool
+	traced   map[string]bool
+)
+
+func init() {
+	Setup(Options{Level: slog.LevelWarn, Trace: os.Getenv("EVO_TRACE")})
+}
+
+// Setup replaces the process-wide logger configuration
+func Setup(o Options) {
+	if o.Output == nil {
+		o.Output = os.Stderr
+	}
+	ho := &slog.HandlerOptions{Level: LevelTrace, ReplaceAttr: levelName}
+	var h slog.Handler
+	if o.Format == FormatJSON {
+		h = slog.NewJSONHandler(o.Output, ho)
+	} else {
+		h = slog.NewTextHandler(o.Output, ho)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	handler, minLevel = h, o.Level
+	traceAll, traced = false, make(map[string]bool)
+	for _, s := range strings.Split(o.Trace, ",") {
+		switch s = strings.TrimSpace(s); s {
+		case "":
+		case "1", "all", "true":
+			traceAll = true
+		default:
+			traced[s] = true
+		}
+	}
+}
+
+// ParseFormat checks a --log-format value
+func ParseFormat(s string) (Format, bool) {
+	switch Format(s) {
+	case FormatText, FormatJSON:
+		return Format(s), true
+	}
+	return "", false
+}
+
+func levelName(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if l, ok := a.Value.Any().(slog.Level); ok && l <= LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// Logger writes records tagged with a subsystem
+type Logger struct {
+	subsystem string
+}
+
+// For returns the logger of a subsystem, e.g. "commits" or "lfs"
+func For(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// Enabled reports whether records at level are written for this subsystem
+func (l *Logger) Enabled(level slog.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	if traceAll || traced[l.subsystem] {
+		return true
+	}
+	return level >= minLevel
+}
+
+func (l *Logger) log(level slog.Level, msg string, args ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+	mu.RLock()
+	h := handler
+	mu.RUnlock()
+	logger := slog.New(h).With("subsystem", l.subsystem)
+	logger.Log(context.Background(), level, msg, args...)
+}
+
+// Trace logs fine-grained detail, shown only when the subsystem is traced
+func (l *Logger) Trace(msg string, args ...any) { l.log(LevelTrace, msg, args...) }
+
+// Debug logs information useful when diagnosing a problem
+func (l *Logger) Debug(msg string, args ...any) { l.log(slog.LevelDebug, msg, args...) }
+
+// Info logs routine progress
+func (l *Logger) Info(msg string, args ...any) { l.log(slog.LevelInfo, msg, args...) }
+
+// Warn logs a problem evo worked around
+func (l *Logger) Warn(msg string, args ...any) { l.log(slog.LevelWarn, msg, args...) }
+
+// Error logs a failure that could not be returned to a caller
+func (l *Logger) Error(msg string, args ...any) { l.log(slog.LevelError, msg, args...) }
@@ -0,0 +1,52 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(Options{Level: slog.LevelWarn, Output: &buf})
+	defer Setup(Options{Level: slog.LevelWarn})
+
+	l := For("commits")
+	l.Info("hidden")
+	l.Warn("shown", "commit", "abc")
+	out := buf.String()
+	assert.NotContains(t, out, "hidden")
+	assert.Contains(t, out, "shown")
+	assert.Contains(t, out, "subsystem=commits")
+	assert.Contains(t, out, "commit=abc")
+}
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(Options{Level: slog.LevelWarn, Output: &buf, Trace: "lfs, ops"})
+	defer Setup(Options{Level: slog.LevelWarn})
+
+	For("lfs").Trace("chunk kept")
+	For("streams").Trace("not traced")
+	out := buf.String()
+	assert.Contains(t, out, "level=TRACE")
+	assert.Contains(t, out, "chunk kept")
+	assert.NotContains(t, out, "not traced")
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(Options{Level: slog.LevelDebug, Format: FormatJSON, Output: &buf})
+	defer Setup(Options{Level: slog.LevelWarn})
+
+	For("compact").Debug("rewrote log", "ops", 3)
+	var rec map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &rec))
+	assert.Equal(t, "compact", rec["subsystem"])
+	assert.Equal(t, "rewrote log", rec["msg"])
+	assert.Equal(t, float64(3), rec["ops"])
+}
@@ -0,0 +1,144 @@
+package maintenance
+
+import (
+	"errors"
+	"evo/internal/config"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Triggers start a maintenance run once the repository grows past them. A
+// zero field disables that trigger.
+type Triggers struct {
+	Ops   int   // total ops across all op logs (maintenance.auto.ops)
+	Bytes int64 // op logs, commits and LFS chunks together (maintenance.auto.bytes)
+}
+
+// TriggerCooldown is the least time between two runs started by triggers
+const TriggerCooldown = time.Hour
+
+// LoadTriggers reads the triggers from the config
+func LoadTriggers(repoPath string) (Triggers, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return Triggers{}, err
+	}
+	ops, err := cfg.Int64("maintenance.auto.ops")
+	if err != nil {
+		return Triggers{}, err
+	}
+	size, err := cfg.Size("maintenance.auto.bytes")
+	if err != nil {
+		return Triggers{}, err
+	}
+	return Triggers{Ops: int(ops), Bytes: size}, nil
+}
+
+// Check returns why s calls for a run, or "" if it does not
+func (tr Triggers) Check(s *Stats) string {
+	if tr.Ops > 0 && s.Ops >= tr.Ops {
+		return fmt.Sprintf("%d ops (limit %d)", s.Ops, tr.Ops)
+	}
+	if size := s.OpBytes + s.CommitBytes + s.LFSBytes; tr.Bytes > 0 && size >= tr.Bytes {
+		return fmt.Sprintf("%d bytes (limit %d)", size, tr.Bytes)
+	}
+	return ""
+}
+
+// Tick decides whether maintenance should run now: when scheduled
+// maintenance is enabled and due, or when the repo has crossed a trigger.
+// Triggers are not checked again until the last run is older than
+// TriggerCooldown, so a repo that stays big after maintenance is not reworked
+// on every tick.
+func Tick(repoPath string, now time.Time) (string, error) {
+	st, err := LoadState(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if st.Enabled {
+		iv, err := st.ScheduleInterval()
+		if err != nil {
+			return "", err
+		}
+		if st.Due(now, iv) {
+			return "scheduled", nil
+		}
+	}
+	if !st.Due(now, TriggerCooldown) {
+		return "", nil
+	}
+	tr, err := LoadTriggers(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if tr == (Triggers{}) {
+		return "", nil
+	}
+	s, err := CollectStats(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to collect stats: %w", err)
+	}
+	return tr.Check(s), nil
+}
+
+func pidPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "daemon.pid")
+}
+
+// DaemonPID returns the pid of the daemon running for the repo, or 0
+func DaemonPID(repoPath string) int {
+	data, err := os.ReadFile(pidPath(repoPath))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || !platform.Alive(pid) {
+		return 0
+	}
+	return pid
+}
+
+// Daemon checks every poll interval whether maintenance is due and runs it,
+// until stop is closed, which cancels a run in progress. Only one daemon
+// runs per repository.
+func Daemon(repoPath string, poll time.Duration, stop <-chan struct{}, report func(reason string, res *Result, err error)) error {
+	if poll <= 0 {
+		return fmt.Errorf("poll interval must be positive")
+	}
+	if pid := DaemonPID(repoPath); pid != 0 {
+		return fmt.Errorf("daemon already running with pid %d", pid)
+	}
+	if err := os.WriteFile(pidPath(repoPath), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return fmt.Errorf("failed to write pid file: %w", err)
+	}
+	defer os.Remove(pidPath(repoPath))
+	ctx, cancel := contextOf(stop)
+	defer cancel()
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		reason, err := Tick(repoPath, time.Now())
+		if err != nil {
+			report("", nil, err)
+		} else if reason != "" {
+			res, err := Run(ctx, repoPath, nil)
+			if errors.Is(err, ErrBusy) {
+				logger.Info("maintenance due but busy", "reason", reason)
+			} else {
+				report(reason, res, err)
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package maintenance
+
+import (
+	"evo/internal/everrors"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBusy is returned by Run while maintenance is paused or already running
+var ErrBusy = everrors.New(everrors.ErrConflict, "maintenance is paused or already running")
+
+func lockPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "maintenance.lock")
+}
+
+// tryLock takes the lock held while maintenance runs or is paused, taking
+// over one whose process has exited. It reports false if the lock is held.
+func tryLock(repoPath string) (func(), bool, error) {
+	path := lockPath(repoPath)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, fmt.Errorf("failed to lock maintenance: %w", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// released meanwhile
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && platform.Alive(pid) {
+			return nil, false, nil
+		}
+		logger.Warn("removing stale maintenance lock", "path", path)
+		os.Remove(path)
+	}
+}
+
+// Pause keeps maintenance from running until the returned function is
+// called, first waiting up to timeout for a run in progress to finish
+func Pause(repoPath string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		unlock, ok, err := tryLock(repoPath)
+		if err != nil || ok {
+			return unlock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to pause maintenance: %w", ErrBusy)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
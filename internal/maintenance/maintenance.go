@@ -0,0 +1,403 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evo/internal/blobs"
+	"evo/internal/commits"
+	"evo/internal/crdt/compact"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/ops"
+	"evo/internal/progress"
+	"evo/internal/repo"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var logger = log.For("maintenance")
+
+// Task is one maintenance job
+type Task string
+
+const (
+	// TaskCompact collapses op logs that reached the compaction threshold
+	TaskCompact Task = "compact"
+	// TaskPrune drops expired tombstones from op logs
+	TaskPrune Task = "prune"
+	// TaskLFSGC removes LFS chunks no stored file references
+	TaskLFSGC Task = "lfs-gc"
+	// TaskRepack rewrites op logs in the current record format and drops
+	// partial records left by interrupted writes
+	TaskRepack Task = "repack"
+	// TaskBlobGC removes stored file contents no stream's file hashes name
+	TaskBlobGC Task = "blob-gc"
+)
+
+// AllTasks lists every task in the order Run performs them
+var AllTasks = []Task{TaskRepack, TaskCompact, TaskPrune, TaskLFSGC, TaskBlobGC}
+
+// ParseTask validates a task name given on the command line
+func ParseTask(s string) (Task, error) {
+	for _, t := range AllTasks {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown maintenance task: %s (expected repack, compact, prune, lfs-gc or blob-gc)", s)
+}
+
+// Stats describes the on-disk size of a repository
+type Stats struct {
+	OpLogs      int   `json:"opLogs"`
+	Ops         int   `json:"ops"`
+	OpBytes     int64 `json:"opBytes"`
+	StoredOps   int   `json:"storedOps"` // ops in the shared op store
+	StoreBytes  int64 `json:"storeBytes"`
+	Commits     int   `json:"commits"`
+	CommitBytes int64 `json:"commitBytes"`
+	LFSChunks   int   `json:"lfsChunks"`
+	LFSBytes    int64 `json:"lfsBytes"`
+	Blobs       int   `json:"blobs"`
+	BlobBytes   int64 `json:"blobBytes"`
+}
+
+// CollectStats measures op logs, the op store, commits, LFS chunks and blobs
+// under .evo
+func CollectStats(repoPath string) (*Stats, error) {
+	st := &Stats{}
+	evo := repo.Dir(repoPath)
+	err := walkLogs(repoPath, func(path string) error {
+		all, err := ops.LoadAllOps(path)
+		if err != nil {
+			return err
+		}
+		st.OpLogs++
+		st.Ops += len(all)
+		st.OpBytes += ops.LogSize(path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkFiles(filepath.Join(evo, "opstore"), func(path string, size int64) error {
+		all, err := ops.LoadAllOps(path)
+		if err != nil {
+			return err
+		}
+		st.StoredOps += len(all)
+		st.StoreBytes += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stored, err := commits.Stored(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range stored {
+		st.Commits++
+		st.CommitBytes += size
+	}
+	err = walkFiles(filepath.Join(evo, "chunks"), func(path string, size int64) error {
+		st.LFSChunks++
+		st.LFSBytes += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkFiles(filepath.Join(evo, "objects"), func(path string, size int64) error {
+		st.Blobs++
+		st.BlobBytes += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// walkLogs calls fn with the .bin path of every stream op log
+func walkLogs(repoPath string, fn func(path string) error) error {
+	logs, err := ops.AllLogs(filepath.Join(repo.Dir(repoPath), "ops"))
+	if err != nil {
+		return err
+	}
+	for _, l := range logs {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkFiles(root string, fn func(path string, size int64) error) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info.Size())
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Result is the outcome of one maintenance run
+type Result struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Tasks    []Task        `json:"tasks"`
+	Before   *Stats        `json:"before"`
+	After    *Stats        `json:"after"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Run performs the given tasks (all of them if none are given), records the
+// result in the maintenance state and returns it. It returns ErrBusy while
+// maintenance is paused or another run is in progress. Cancelling ctx stops
+// the run between op logs, or for compaction between streams, each left
+// either done or as it was.
+func Run(ctx context.Context, repoPath string, tasks []Task) (*Result, error) {
+	if len(tasks) == 0 {
+		tasks = AllTasks
+	}
+	unlock, ok, err := tryLock(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrBusy
+	}
+	defer unlock()
+	res := &Result{Started: time.Now().UTC(), Tasks: tasks}
+	before, err := CollectStats(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect stats: %w", err)
+	}
+	res.Before = before
+
+	runErr := runTasks(ctx, repoPath, tasks)
+	if runErr != nil {
+		res.Error = runErr.Error()
+	}
+	if res.After, err = CollectStats(repoPath); err != nil && runErr == nil {
+		runErr = fmt.Errorf("failed to collect stats: %w", err)
+	}
+	res.Duration = time.Since(res.Started)
+	logger.Info("maintenance run done", "tasks", tasks, "duration", res.Duration, "opBytesBefore", res.Before.OpBytes, "opBytesAfter", res.After.OpBytes)
+
+	st, err := LoadState(repoPath)
+	if err != nil {
+		return res, err
+	}
+	st.LastRun = res
+	if err := st.Save(repoPath); err != nil {
+		return res, err
+	}
+	return res, runErr
+}
+
+func runTasks(ctx context.Context, repoPath string, tasks []Task) error {
+	svc := compact.NewCompactionService(repoPath, compact.DefaultConfig())
+	for _, t := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		switch t {
+		case TaskRepack:
+			err = Repack(ctx, repoPath)
+		case TaskCompact:
+			err = svc.CompactOperations(ctx)
+		case TaskPrune:
+			err = svc.PruneTombstones(ctx)
+		case TaskLFSGC:
+			_, err = lfs.NewGarbageCollector(lfs.NewStore(repoPath)).Run(ctx)
+		case TaskBlobGC:
+			_, err = blobs.Prune(ctx, repoPath)
+		default:
+			err = fmt.Errorf("unknown task")
+		}
+		if err != nil {
+			logger.Error("maintenance task failed", "task", t, "err", err)
+			return fmt.Errorf("%s failed: %w", t, err)
+		}
+		logger.Debug("maintenance task done", "task", t)
+	}
+	return nil
+}
+
+// Repack verifies op logs, migrates those written before line origins
+// existed, moves inline ops into the shared op store, rewrites logs of an
+// older format and truncates trailing partial records. Each log is rewritten
+// whole; cancelling ctx stops before the next one. The logs done are
+// reported to the progress reporter of ctx.
+func Repack(ctx context.Context, repoPath string) error {
+	logs, err := ops.AllLogs(filepath.Join(repo.Dir(repoPath), "ops"))
+	if err != nil {
+		return err
+	}
+	pr := progress.From(ctx)
+	pr.Start("Repacking", int64(len(logs)))
+	defer pr.Done()
+	for _, path := range logs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := repackLog(path); err != nil {
+			return err
+		}
+		pr.Add(1)
+	}
+	return nil
+}
+
+// repackLog repacks one op log
+func repackLog(path string) error {
+	if err := ops.VerifyLog(path); err != nil {
+		return err
+	}
+	if _, err := ops.MigrateLog(path); err != nil {
+		return err
+	}
+	if _, err := ops.ShareLog(path); err != nil {
+		return err
+	}
+	_, end, err := ops.ReadOpsFrom(path, 0)
+	if err != nil {
+		return err
+	}
+	if end < ops.LogSize(path) {
+		return ops.TruncateLog(path, end)
+	}
+	return nil
+}
+
+// State is the persisted maintenance configuration and last run, kept in
+// .evo/maintenance.json
+type State struct {
+	Enabled  bool    `json:"enabled"`
+	Interval string  `json:"interval,omitempty"` // Go duration between scheduled runs
+	LastRun  *Result `json:"lastRun,omitempty"`
+}
+
+// DefaultInterval is used for scheduled runs when none is configured
+const DefaultInterval = 24 * time.Hour
+
+func statePath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "maintenance.json")
+}
+
+// LoadState reads the maintenance state; a repo without one has maintenance disabled
+func LoadState(repoPath string) (*State, error) {
+	data, err := os.ReadFile(statePath(repoPath))
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse maintenance state: %w", err)
+	}
+	return &st, nil
+}
+
+// Save writes the maintenance state
+func (st *State) Save(repoPath string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath(repoPath), data, 0644)
+}
+
+// ScheduleInterval returns the configured interval between runs
+func (st *State) ScheduleInterval() (time.Duration, error) {
+	if st.Interval == "" {
+		return DefaultInterval, nil
+	}
+	d, err := time.ParseDuration(st.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maintenance interval %q: %w", st.Interval, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("maintenance interval must be positive")
+	}
+	return d, nil
+}
+
+// Due reports whether a scheduled run is due at now
+func (st *State) Due(now time.Time, interval time.Duration) bool {
+	if st.LastRun == nil {
+		return true
+	}
+	return !now.Before(st.LastRun.Started.Add(interval))
+}
+
+// Schedule runs the tasks every interval until stop is closed, calling report
+// after each run. A run is made right away if the last one is older than the
+// interval. Closing stop cancels a run in progress.
+func Schedule(repoPath string, tasks []Task, interval time.Duration, stop <-chan struct{}, report func(*Result, error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("maintenance interval must be positive")
+	}
+	ctx, cancel := contextOf(stop)
+	defer cancel()
+	var retry time.Duration
+	for {
+		st, err := LoadState(repoPath)
+		if err != nil {
+			return err
+		}
+		wait := retry
+		if !st.Due(time.Now(), interval) {
+			wait = time.Until(st.LastRun.Started.Add(interval))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		res, err := Run(ctx, repoPath, tasks)
+		// a busy run is tried again a minute later
+		retry = 0
+		if errors.Is(err, ErrBusy) {
+			retry = time.Minute
+		}
+		if report != nil {
+			report(res, err)
+		}
+	}
+}
+
+// contextOf returns a context cancelled when stop is closed
+func contextOf(stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
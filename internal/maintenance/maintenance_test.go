@@ -0,0 +1,142 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/ops"
+	"evo/internal/progress"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	repoPath := t.TempDir()
+	dir := filepath.Join(repoPath, ".evo", "ops", "main")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, uuid.New().String()+".bin")
+	f, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, line := uuid.New(), uuid.New()
+	op := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: node, LineID: line, OriginLineID: crdt.DocumentStart, Content: "a"}
+	if err := ops.WriteOp(f, op); err != nil {
+		t.Fatal(err)
+	}
+	// a record cut short by a crash
+	f.Write([]byte{byte(crdt.OpInsert), 0, 0, 0})
+	f.Close()
+
+	var buf bytes.Buffer
+	res, err := Run(progress.With(context.Background(), progress.NewBar(&buf)), repoPath, []Task{TaskRepack})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "\rRepacking ["+strings.Repeat("=", 30)+"] 100% 1/1\n"), buf.String())
+	assert.Equal(t, 1, res.Before.Ops)
+	assert.Equal(t, 1, res.After.Ops)
+	assert.Less(t, res.After.OpBytes, res.Before.OpBytes)
+
+	all, err := ops.LoadAllOps(logPath)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	st, err := LoadState(repoPath)
+	assert.NoError(t, err)
+	if assert.NotNil(t, st.LastRun) {
+		assert.Equal(t, []Task{TaskRepack}, st.LastRun.Tasks)
+	}
+}
+
+func TestState(t *testing.T) {
+	repoPath := t.TempDir()
+	os.MkdirAll(filepath.Join(repoPath, ".evo"), 0755)
+
+	st, err := LoadState(repoPath)
+	assert.NoError(t, err)
+	assert.False(t, st.Enabled)
+	iv, err := st.ScheduleInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultInterval, iv)
+	assert.True(t, st.Due(time.Now(), iv))
+
+	st.Enabled = true
+	st.Interval = "1h"
+	st.LastRun = &Result{Started: time.Now()}
+	assert.NoError(t, st.Save(repoPath))
+
+	st, err = LoadState(repoPath)
+	assert.NoError(t, err)
+	assert.True(t, st.Enabled)
+	iv, err = st.ScheduleInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, iv)
+	assert.False(t, st.Due(time.Now(), iv))
+	assert.True(t, st.Due(time.Now().Add(2*time.Hour), iv))
+
+	_, err = ParseTask("bogus")
+	assert.Error(t, err)
+}
+
+func TestTick(t *testing.T) {
+	repoPath := t.TempDir()
+	os.MkdirAll(filepath.Join(repoPath, ".evo", "ops", "main"), 0755)
+
+	reason, err := Tick(repoPath, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	assert.NoError(t, config.SetConfigValue(repoPath, "maintenance.auto.bytes", "1"))
+	os.WriteFile(filepath.Join(repoPath, ".evo", "ops", "main", uuid.New().String()+".bin"), []byte{1, 2}, 0644)
+	reason, err = Tick(repoPath, time.Now())
+	assert.NoError(t, err)
+	assert.Contains(t, reason, "bytes")
+
+	// a fresh run holds triggers back for the cooldown
+	(&State{LastRun: &Result{Started: time.Now()}}).Save(repoPath)
+	reason, err = Tick(repoPath, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	reason, err = Tick(repoPath, time.Now().Add(TriggerCooldown))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+
+	// scheduled runs ignore the cooldown
+	(&State{Enabled: true, Interval: "1m", LastRun: &Result{Started: time.Now().Add(-time.Minute)}}).Save(repoPath)
+	reason, err = Tick(repoPath, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "scheduled", reason)
+}
+
+func TestPause(t *testing.T) {
+	repoPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repoPath, ".evo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	resume, err := Pause(repoPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Run(context.Background(), repoPath, nil)
+	assert.ErrorIs(t, err, ErrBusy)
+	_, err = Pause(repoPath, 0)
+	assert.ErrorIs(t, err, ErrBusy)
+	resume()
+	_, err = Run(context.Background(), repoPath, nil)
+	assert.NoError(t, err)
+
+	// a lock left by a process that exited is taken over
+	if err := os.WriteFile(lockPath(repoPath), []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Run(context.Background(), repoPath, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,410 @@
+package materialize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"evo/internal/blobs"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/merge"
+	"evo/internal/ops"
+	"evo/internal/profile"
+	"evo/internal/repo"
+	"evo/internal/textenc"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Op logs are append-only, so a replayed document stays valid as long as the
+// log still starts with the bytes it was built from. The cache remembers the
+// log length and its last few bytes; a longer log with the same bytes at the
+// old end only needs the new ops applied, anything else is replayed in full.
+
+// tailSize is how many bytes before the cached length are compared to detect
+// a log that was rewritten rather than appended to
+const tailSize = 64
+
+// The cache keeps only the replayed lines, not the ops they came from, so a
+// file with hundreds of thousands of lines costs a few hundred bytes per line.
+// Ops are read back from the log when a caller needs them.
+
+// Document is the state of one file's op log
+type Document struct {
+	Lines     []string         // active lines in document order
+	LineIDs   []uuid.UUID      // LineIDs of Lines
+	Elements  []crdt.Element   // active elements when some are fragments, else nil
+	Knowledge crdt.VectorClock // every op in the log, as a vector clock
+	Lamport   uint64           // latest Lamport time in the log
+	NumOps    int              // ops in the log
+
+	path string
+}
+
+// Ops reads the document's ops from its log, in log order
+func (d *Document) Ops() ([]crdt.Operation, error) {
+	if d.NumOps == 0 {
+		return nil, nil
+	}
+	all, err := ops.LoadAllOps(d.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(all) > d.NumOps {
+		// appended to since
+		all = all[:d.NumOps]
+	}
+	return all, nil
+}
+
+// LineMap returns a map of LineID to content for the active lines
+func (d *Document) LineMap() map[uuid.UUID]string {
+	out := make(map[uuid.UUID]string, len(d.Lines))
+	for i, id := range d.LineIDs {
+		out[id] = d.Lines[i]
+	}
+	return out
+}
+
+type entry struct {
+	size      int64
+	modTime   time.Time
+	tail      []byte
+	knowledge crdt.VectorClock
+	lamport   uint64
+	count     int
+	doc       *crdt.RGA
+}
+
+var cache = struct {
+	sync.Mutex
+	entries map[string]*entry
+}{entries: make(map[string]*entry)}
+
+// Load returns the document for a file in a stream, replaying only the ops
+// appended since the last call
+func Load(repoPath, stream, fileID string) (*Document, error) {
+	path := filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin")
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	size, modTime, err := ops.LogInfo(path)
+	if os.IsNotExist(err) {
+		delete(cache.entries, key)
+		return &Document{Knowledge: make(crdt.VectorClock)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := cache.entries[key]
+	if e == nil || !e.valid(path, size, modTime) {
+		e = &entry{knowledge: make(crdt.VectorClock), doc: crdt.NewRGA(crdt.WithoutLog())}
+	}
+	if size != e.size {
+		added, end, err := ops.ReadOpsFrom(path, e.size)
+		if err != nil {
+			return nil, err
+		}
+		done := profile.Track(profile.CRDT)
+		for _, op := range added {
+			if err := e.doc.Apply(op); err != nil {
+				done()
+				delete(cache.entries, key)
+				return nil, fmt.Errorf("applying operation: %v", err)
+			}
+			e.knowledge.Witness(op)
+			e.lamport = max(e.lamport, op.Lamport)
+		}
+		done()
+		e.count += len(added)
+		e.size = end
+		if e.tail, err = readTail(path, end); err != nil {
+			return nil, err
+		}
+	}
+	e.modTime = modTime
+	cache.entries[key] = e
+
+	doc := &Document{
+		Knowledge: e.knowledge.Copy(),
+		Lamport:   e.lamport,
+		NumOps:    e.count,
+		path:      path,
+	}
+	if e.doc.Fragments() == 0 {
+		doc.Lines = e.doc.Materialize()
+		doc.LineIDs = e.doc.GetLineIDs()
+	} else {
+		doc.Elements = e.doc.Elements()
+		doc.Lines, doc.LineIDs = render(doc.Elements)
+	}
+	return doc, nil
+}
+
+// Content returns the text of a replayed document, as WriteFile writes it
+func Content(doc *crdt.RGA) string {
+	if doc.Fragments() == 0 {
+		return strings.Join(doc.Materialize(), "\n")
+	}
+	lines, _ := render(doc.Elements())
+	return strings.Join(lines, "\n")
+}
+
+// render joins elements into lines: fragments run together, a line element
+// ends its line. A line's ID is that of the element it starts with, Nil for
+// the empty line after a trailing line break.
+func render(elements []crdt.Element) ([]string, []uuid.UUID) {
+	if len(elements) == 0 {
+		return nil, nil
+	}
+	var text strings.Builder
+	var ids []uuid.UUID
+	start := true
+	for i, el := range elements {
+		if start {
+			ids = append(ids, el.ID)
+			start = false
+		}
+		text.WriteString(el.Content)
+		// line breaks inside a fragment start lines within it
+		for range strings.Count(strings.TrimSuffix(el.Content, "\n"), "\n") {
+			ids = append(ids, el.ID)
+		}
+		switch {
+		case !el.Fragment && i < len(elements)-1:
+			text.WriteByte('\n')
+			start = true
+		case el.Fragment && strings.HasSuffix(el.Content, "\n"):
+			start = true
+		}
+	}
+	if start {
+		ids = append(ids, uuid.Nil)
+	}
+	return strings.Split(text.String(), "\n"), ids
+}
+
+// valid reports whether the cached state is a prefix of the log on disk
+func (e *entry) valid(path string, size int64, modTime time.Time) bool {
+	switch {
+	case size < e.size:
+		return false
+	case size == e.size:
+		return modTime.Equal(e.modTime)
+	}
+	tail, err := readTail(path, e.size)
+	return err == nil && bytes.Equal(tail, e.tail)
+}
+
+// readTail returns up to tailSize bytes ending at offset end
+func readTail(path string, end int64) ([]byte, error) {
+	start := end - tailSize
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, end-start)
+	if err := ops.ReadLogAt(path, buf, start); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteFile rebuilds a file from the stream's op log and writes it to its
+// indexed path in the working tree. A file whose lines are all deleted is
+// removed. The written content is remembered as in sync with the log, so
+// ingest can skip it until either changes. A bare repository has no working
+// tree, so nothing is written.
+func WriteFile(repoPath, stream, fileID string) error {
+	if repo.IsBare(repoPath) {
+		return nil
+	}
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	rel, ok := id2path[fileID]
+	if !ok {
+		// not tracked => nothing to write
+		return nil
+	}
+	doc, err := Load(repoPath, stream, fileID)
+	if err != nil {
+		return err
+	}
+
+	abs := filepath.Join(repoPath, rel)
+	lines := doc.Lines
+	if len(lines) == 0 {
+		if err := os.Remove(abs); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return index.SetHash(repoPath, stream, fileID, index.Hash{})
+	}
+	sum, err := WriteContent(repoPath, abs, fileID, lines)
+	if err != nil {
+		return err
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load attributes: %w", err)
+	}
+	sum = index.GranularSum(sum, string(attrs.GranularityFor(rel)))
+	return rememberHash(repoPath, stream, fileID, sum)
+}
+
+// ErrNotStored is returned when a large file's content is not in the local
+// LFS store, e.g. in a clone whose large files were never pulled
+var ErrNotStored = errors.New("large file content is not in the local store")
+
+// WriteContent writes the lines of a file to abs, joined as a replayed
+// document is and in the file's recorded encoding, and returns the SHA-256
+// of what it wrote. The stub of a large file is replaced by its content in
+// the LFS store; a file whose content isn't all stored is left alone and
+// ErrNotStored returned.
+func WriteContent(repoPath, abs, fileID string, lines []string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if len(lines) == 1 && strings.HasPrefix(lines[0], "EVO-LFS:") {
+		// large file => restore content from the LFS store
+		store := lfs.NewStore(repoPath)
+		info, err := store.Info(fileID)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s; run 'evo transfer pull %s'", ErrNotStored, fileID, fileID)
+		}
+		hashes := make([]string, len(info.Chunks))
+		for i, c := range info.Chunks {
+			hashes[i] = c.Hash
+		}
+		if missing := store.Missing(hashes); len(missing) > 0 {
+			return "", fmt.Errorf("%w: %d chunk(s) of %s are missing; run 'evo transfer pull %s'", ErrNotStored, len(missing), fileID, fileID)
+		}
+		f, err := os.Create(abs)
+		if err != nil {
+			return "", err
+		}
+		err = store.ReadFile(fileID, io.MultiWriter(f, h))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+	} else {
+		text := strings.Join(lines, "\n")
+		enc, err := index.EncodingOf(repoPath, fileID)
+		if err != nil {
+			return "", err
+		}
+		if !enc.Valid(text) {
+			// text merged in that the encoding can't hold => keep it as is
+			enc = textenc.UTF8
+		}
+		data := textenc.Encode(text, enc)
+		if err := os.WriteFile(abs, data, 0644); err != nil {
+			return "", err
+		}
+		if _, err := blobs.Put(repoPath, data); err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// rememberHash records content written for a file as in sync with its log
+func rememberHash(repoPath, stream, fileID, sum string) error {
+	size, _, err := ops.LogInfo(filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin"))
+	if err != nil {
+		return index.SetHash(repoPath, stream, fileID, index.Hash{})
+	}
+	return index.SetHash(repoPath, stream, fileID, index.Hash{LogSize: size, Sum: sum})
+}
+
+// Base returns a file's content as it was last in sync with the stream's op
+// log, from the blob store, and false if the log changed since or the
+// content isn't stored. Large files have no base.
+func Base(repoPath, stream, fileID string) ([]byte, bool) {
+	hashes, err := index.LoadHashes(repoPath, stream)
+	if err != nil {
+		return nil, false
+	}
+	h, ok := hashes[fileID]
+	if !ok || ops.LogSize(filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin")) != h.LogSize {
+		return nil, false
+	}
+	data, err := blobs.Get(repoPath, h.Content())
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// InSync reports whether data, the content of a file, is what the stream's
+// op log holds for it: its lines joined as a replayed document is, or for a
+// large file the stored content its stub names. A large file whose content
+// isn't stored can't be compared and is reported as not in sync.
+func InSync(repoPath, stream, fileID string, data []byte) (bool, error) {
+	doc, err := Load(repoPath, stream, fileID)
+	if err != nil {
+		return false, err
+	}
+	if len(doc.Lines) == 1 && strings.HasPrefix(doc.Lines[0], "EVO-LFS:") {
+		info, err := lfs.NewStore(repoPath).Info(fileID)
+		if err != nil {
+			return false, nil
+		}
+		return info.ContentHash == index.HashContent(data) && info.Size == int64(len(data)), nil
+	}
+	text, _ := textenc.Text(data)
+	return strings.ReplaceAll(text, "\r\n", "\n") == strings.Join(doc.Lines, "\n"), nil
+}
+
+// Resync records a file's hash anew after its op log changed without the
+// working tree being rewritten from it, as undo does: content matching the
+// log is remembered as in sync, anything else is forgotten so status and
+// ingest read the file again.
+func Resync(repoPath, stream, fileID string) error {
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	rel, ok := id2path[fileID]
+	if !ok {
+		return index.SetHash(repoPath, stream, fileID, index.Hash{})
+	}
+	data, err := os.ReadFile(filepath.Join(repoPath, rel))
+	if os.IsNotExist(err) {
+		return index.SetHash(repoPath, stream, fileID, index.Hash{})
+	}
+	if err != nil {
+		return err
+	}
+	ok, err = InSync(repoPath, stream, fileID, data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return index.SetHash(repoPath, stream, fileID, index.Hash{})
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load attributes: %w", err)
+	}
+	return rememberHash(repoPath, stream, fileID, index.GranularSum(index.HashContent(data), string(attrs.GranularityFor(rel))))
+}
@@ -0,0 +1,147 @@
+package materialize
+
+import (
+	"evo/internal/crdt"
+	"evo/internal/lfs"
+	"evo/internal/ops"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	repoPath := t.TempDir()
+	fileID := uuid.New()
+	nodeID := uuid.New()
+	logPath := filepath.Join(repoPath, ".evo", "ops", "main", fileID.String()+".bin")
+
+	insert := func(lamport uint64, origin uuid.UUID, content string) uuid.UUID {
+		id := uuid.New()
+		assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{
+			Type: crdt.OpInsert, Lamport: lamport, NodeID: nodeID, FileID: fileID,
+			LineID: id, OriginLineID: origin, Content: content,
+		}))
+		return id
+	}
+
+	t.Run("Missing Log", func(t *testing.T) {
+		doc, err := Load(repoPath, "main", fileID.String())
+		assert.NoError(t, err)
+		assert.Empty(t, doc.Lines)
+	})
+
+	first := insert(1, crdt.DocumentStart, "one")
+	doc, err := Load(repoPath, "main", fileID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one"}, doc.Lines)
+
+	t.Run("Appended Ops", func(t *testing.T) {
+		second := insert(2, first, "two")
+		doc2, err := Load(repoPath, "main", fileID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"one", "two"}, doc2.Lines)
+		assert.Equal(t, []uuid.UUID{first, second}, doc2.LineIDs)
+		assert.Equal(t, 2, doc2.NumOps)
+		all, err := doc2.Ops()
+		assert.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{first, second}, []uuid.UUID{all[0].LineID, all[1].LineID})
+		assert.Equal(t, uint64(2), doc2.Lamport)
+		// earlier documents are snapshots
+		assert.Equal(t, []string{"one"}, doc.Lines)
+		all, err = doc.Ops()
+		assert.NoError(t, err)
+		assert.Len(t, all, 1)
+	})
+
+	t.Run("Rewritten Log", func(t *testing.T) {
+		assert.NoError(t, os.Remove(logPath))
+		insert(3, crdt.DocumentStart, "replaced")
+		insert(4, crdt.DocumentStart, "longer than before")
+		doc3, err := Load(repoPath, "main", fileID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"longer than before", "replaced"}, doc3.Lines)
+	})
+
+	t.Run("Truncated Log", func(t *testing.T) {
+		all, _ := ops.LoadAllOps(logPath)
+		assert.Len(t, all, 2)
+		fi, _ := os.Stat(logPath)
+		assert.NoError(t, os.Truncate(logPath, fi.Size()/2))
+		doc4, err := Load(repoPath, "main", fileID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"replaced"}, doc4.Lines)
+	})
+}
+
+func TestWriteFile(t *testing.T) {
+	repoPath := t.TempDir()
+	fileID := uuid.New()
+	assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".evo"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, ".evo", "index"), []byte(fileID.String()+" dir/a.txt\n"), 0644))
+
+	logPath := filepath.Join(repoPath, ".evo", "ops", "main", fileID.String()+".bin")
+	lineID := uuid.New()
+	assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{Type: crdt.OpInsert, Lamport: 1, FileID: fileID, LineID: lineID, OriginLineID: crdt.DocumentStart, Content: "hello"}))
+
+	assert.NoError(t, WriteFile(repoPath, "main", fileID.String()))
+	data, err := os.ReadFile(filepath.Join(repoPath, "dir", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// all lines deleted => file removed
+	assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{Type: crdt.OpDelete, Lamport: 2, FileID: fileID, LineID: lineID}))
+	assert.NoError(t, WriteFile(repoPath, "main", fileID.String()))
+	_, err = os.Stat(filepath.Join(repoPath, "dir", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteContent(t *testing.T) {
+	repoPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".evo"), 0755))
+	abs := filepath.Join(repoPath, "big.bin")
+	stub := []string{"EVO-LFS:big:5"}
+
+	_, err := WriteContent(repoPath, abs, "big", stub)
+	assert.ErrorIs(t, err, ErrNotStored)
+	assert.NoFileExists(t, abs)
+
+	store := lfs.NewStore(repoPath)
+	info, err := store.StoreFile("big", strings.NewReader("hello"), 5)
+	assert.NoError(t, err)
+	sum, err := WriteContent(repoPath, abs, "big", stub)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ContentHash, sum)
+	data, err := os.ReadFile(abs)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// a chunk gone missing leaves the working copy alone
+	assert.NoError(t, os.WriteFile(abs, []byte("local"), 0644))
+	assert.NoError(t, os.RemoveAll(filepath.Join(repoPath, ".evo", "chunks")))
+	_, err = WriteContent(repoPath, abs, "big", stub)
+	assert.ErrorIs(t, err, ErrNotStored)
+	data, err = os.ReadFile(abs)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", string(data))
+}
+
+func TestRender(t *testing.T) {
+	ids := make([]uuid.UUID, 6)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	frag := func(i int, s string) crdt.Element { return crdt.Element{ID: ids[i], Content: s, Fragment: true} }
+
+	lines, lineIDs := render([]crdt.Element{frag(0, "ab"), frag(1, "\n"), frag(2, "c"), frag(3, "\n")})
+	assert.Equal(t, []string{"ab", "c", ""}, lines)
+	assert.Equal(t, []uuid.UUID{ids[0], ids[2], uuid.Nil}, lineIDs)
+
+	// line elements left over from before a granularity change end their line
+	lines, lineIDs = render([]crdt.Element{{ID: ids[4], Content: "line"}, frag(0, "x"), {ID: ids[5], Content: "last"}})
+	assert.Equal(t, []string{"line", "xlast"}, lines)
+	assert.Equal(t, []uuid.UUID{ids[4], ids[0]}, lineIDs)
+}
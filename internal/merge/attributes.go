@@ -0,0 +1,115 @@
+package merge
+
+import (
+	"bufio"
+	"evo/internal/crdt"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// The .evo-attributes file holds lines of "<pattern> <key>=<value>...", e.g.
+//
+//	CHANGELOG.md merge=union
+//	*.lock       merge=theirs
+//	docs/**      merge=mydriver crdt=word
+//
+// merge selects a merge driver, crdt the granularity (line, char or word)
+// files are tracked at.
+
+type attrRule struct {
+	pattern string
+	key     string
+	value   string
+}
+
+// Attributes holds per-path merge driver and granularity assignments
+type Attributes struct {
+	rules []attrRule
+}
+
+// LoadAttributes reads the .evo-attributes file from the given repository path
+func LoadAttributes(repoPath string) (*Attributes, error) {
+	file, err := os.Open(filepath.Join(repoPath, ".evo-attributes"))
+	if os.IsNotExist(err) {
+		return &Attributes{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	attrs := &Attributes{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, f := range fields[1:] {
+			key, value, ok := strings.Cut(f, "=")
+			if !ok || (key != "merge" && key != "crdt") {
+				continue
+			}
+			if key == "crdt" {
+				if _, err := crdt.ParseGranularity(value); err != nil {
+					return nil, fmt.Errorf("%s: %w", fields[0], err)
+				}
+			}
+			attrs.rules = append(attrs.rules, attrRule{
+				pattern: fields[0],
+				key:     key,
+				value:   value,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// DriverFor returns the merge driver assigned to path, or "" if none matches.
+// Later rules override earlier ones.
+func (a *Attributes) DriverFor(path string) string {
+	return a.value(path, "merge")
+}
+
+// GranularityFor returns the granularity path is tracked at, lines unless a
+// crdt attribute says otherwise
+func (a *Attributes) GranularityFor(path string) crdt.Granularity {
+	g, _ := crdt.ParseGranularity(a.value(path, "crdt"))
+	return g
+}
+
+// value returns the last value of key assigned to path, or ""
+func (a *Attributes) value(path, key string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	value := ""
+	for _, r := range a.rules {
+		if r.key == key && matchPattern(r.pattern, path) {
+			value = r.value
+		}
+	}
+	return value
+}
+
+func matchPattern(pattern, path string) bool {
+	if ok, err := doublestar.Match(pattern, path); err == nil && ok {
+		return true
+	}
+	// patterns without a slash match the basename anywhere in the tree
+	if !strings.Contains(pattern, "/") {
+		if ok, err := doublestar.Match(pattern, filepath.Base(path)); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
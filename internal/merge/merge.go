@@ -0,0 +1,297 @@
+package merge
+
+import (
+	"bytes"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/node"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Strategy selects how conflicting ops on the same LineID are resolved
+type Strategy string
+
+const (
+	// StrategyCRDT lets the CRDT order decide (highest Lamport wins)
+	StrategyCRDT Strategy = "crdt"
+	// StrategyOurs keeps the target stream's version of a conflicting line
+	StrategyOurs Strategy = "ours"
+	// StrategyTheirs keeps the source stream's version of a conflicting line
+	StrategyTheirs Strategy = "theirs"
+	// StrategyUnion keeps both versions as separate lines
+	StrategyUnion Strategy = "union"
+)
+
+// ParseStrategy validates a strategy name given on the command line
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", StrategyCRDT:
+		return StrategyCRDT, nil
+	case StrategyOurs, StrategyTheirs, StrategyUnion:
+		return Strategy(s), nil
+	}
+	return "", fmt.Errorf("unknown merge strategy: %s (expected crdt, ours, theirs or union)", s)
+}
+
+// Resolver applies a merge strategy and per-path drivers to incoming ops
+type Resolver struct {
+	repoPath  string
+	strategy  Strategy
+	attrs     *Attributes
+	paths     map[string]string // fileID -> path
+	self      *node.Node        // stamps the ops the resolver makes
+	conflicts []Conflict
+}
+
+// Conflict is an incoming edit of a line that the target edited too without
+// either seeing the other's edit
+type Conflict struct {
+	FileID        string `json:"fileId"`
+	Path          string `json:"path,omitempty"`
+	LineID        string `json:"lineId"`
+	Driver        string `json:"driver"` // the strategy or driver that resolved it
+	Ours          string `json:"ours"`
+	Theirs        string `json:"theirs"`
+	OursDeleted   bool   `json:"oursDeleted,omitempty"`
+	TheirsDeleted bool   `json:"theirsDeleted,omitempty"`
+}
+
+// Conflicts returns the conflicts Resolve has resolved so far
+func (r *Resolver) Conflicts() []Conflict {
+	return r.conflicts
+}
+
+// NewResolver creates a resolver for the repository using the given default strategy
+func NewResolver(repoPath string, strategy Strategy) (*Resolver, error) {
+	attrs, err := LoadAttributes(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attributes: %w", err)
+	}
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	return &Resolver{
+		repoPath: repoPath,
+		strategy: strategy,
+		attrs:    attrs,
+		paths:    id2path,
+		self:     self,
+	}, nil
+}
+
+// driverFor returns the driver for a file: the path attribute if set, else the strategy
+func (r *Resolver) driverFor(fileID uuid.UUID) string {
+	if p, ok := r.paths[fileID.String()]; ok {
+		if d := r.attrs.DriverFor(p); d != "" {
+			return d
+		}
+	}
+	return string(r.strategy)
+}
+
+// lineState tracks what the target stream did to a line on its own
+type lineState struct {
+	base    string           // content when the line was inserted
+	ours    string           // current content in the target
+	lamport uint64           // highest Lamport seen on the line in the target
+	edits   []crdt.Operation // target's local updates/deletes on this line
+	vector  crdt.VectorClock // everything the target's ops on the line had seen
+	deleted bool
+}
+
+// concurrent reports whether op was made without seeing one of the target's
+// edits. An op that already saw them all is a causal successor, not a conflict.
+func (st *lineState) concurrent(op crdt.Operation) bool {
+	for _, e := range st.edits {
+		if crdt.IsConcurrent(e, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// stamp makes op a new op of this node: a Lamport time after every op seen
+// and a vector covering the target's ops on the line and seen, the ops it
+// was resolved against. Ops replicated from other nodes keep their stamps,
+// so the resolver only ever adds ops of its own.
+func (r *Resolver) stamp(st *lineState, op *crdt.Operation, seen ...crdt.Operation) {
+	r.self.Clock.Observe(st.lamport)
+	vector := st.vector.Copy()
+	for _, o := range seen {
+		r.self.Observe(o)
+		vector.Witness(o)
+	}
+	op.NodeID = r.self.ID
+	op.Lamport = r.self.Tick()
+	op.Timestamp = time.Now()
+	vector.Stamp(op)
+	st.lamport = op.Lamport
+	st.vector.Merge(op.Vector)
+}
+
+// Resolve rewrites incoming ops so they can be appended to the target stream.
+// local are the target's ops that the source does not have; incoming ops that
+// update or delete a line modified in local, without having seen that
+// modification, are conflicts and are handled by the driver for the op's file.
+// A driver that overrides the CRDT order adds an op of this repository's
+// node after the incoming one rather than re-stamping it; the caller saves
+// the node once the resolved ops are applied.
+func (r *Resolver) Resolve(local []crdt.Operation, incoming []types.ExtendedOp) ([]types.ExtendedOp, error) {
+	state := make(map[uuid.UUID]*lineState)
+	for _, op := range local {
+		st, ok := state[op.LineID]
+		if !ok {
+			st = &lineState{vector: make(crdt.VectorClock)}
+			state[op.LineID] = st
+		}
+		switch op.Type {
+		case crdt.OpInsert:
+			st.base = op.Content
+			st.ours = op.Content
+		case crdt.OpUpdate:
+			st.ours = op.Content
+			st.edits = append(st.edits, op)
+		case crdt.OpDelete:
+			st.deleted = true
+			st.edits = append(st.edits, op)
+		}
+		st.vector.Witness(op)
+		if op.Lamport > st.lamport {
+			st.lamport = op.Lamport
+		}
+	}
+
+	var out []types.ExtendedOp
+	for _, eop := range incoming {
+		st, ok := state[eop.Op.LineID]
+		if eop.Op.Type == crdt.OpInsert || !ok || !st.concurrent(eop.Op) {
+			out = append(out, eop)
+			continue
+		}
+		if st.base == "" && eop.OldContent != "" {
+			st.base = eop.OldContent
+		}
+
+		driver := r.driverFor(eop.Op.FileID)
+		c := Conflict{
+			FileID:        eop.Op.FileID.String(),
+			Path:          r.paths[eop.Op.FileID.String()],
+			LineID:        eop.Op.LineID.String(),
+			Driver:        driver,
+			OursDeleted:   st.deleted,
+			TheirsDeleted: eop.Op.Type == crdt.OpDelete,
+		}
+		if !c.OursDeleted {
+			c.Ours = st.ours
+		}
+		if !c.TheirsDeleted {
+			c.Theirs = eop.Op.Content
+		}
+		r.conflicts = append(r.conflicts, c)
+		switch Strategy(driver) {
+		case StrategyCRDT:
+			out = append(out, eop)
+		case StrategyOurs:
+			// target wins => drop the incoming op
+		case StrategyTheirs:
+			// their op replicates as it is and ours repeats it after our edits
+			res := eop.Op
+			r.stamp(st, &res, eop.Op)
+			out = append(out, eop, types.ExtendedOp{Op: res, OldContent: st.ours})
+		case StrategyUnion:
+			if eop.Op.Type == crdt.OpDelete {
+				// keep our line
+				continue
+			}
+			// keep both: their version becomes a sibling line next to ours;
+			// their op is dropped, so the sibling doesn't depend on it
+			ins := crdt.Operation{
+				Type:         crdt.OpInsert,
+				FileID:       eop.Op.FileID,
+				LineID:       uuid.New(),
+				OriginLineID: eop.Op.LineID,
+				Content:      eop.Op.Content,
+				Fragment:     eop.Op.Fragment,
+				Stream:       eop.Op.Stream,
+			}
+			r.self.Observe(eop.Op)
+			r.stamp(st, &ins)
+			out = append(out, types.ExtendedOp{Op: ins})
+		default:
+			if eop.Op.Type == crdt.OpDelete || st.deleted || eop.Op.Fragment {
+				// drivers only merge lines; deletes and fragments follow CRDT order
+				out = append(out, eop)
+				continue
+			}
+			merged, err := r.runDriver(driver, st.base, st.ours, eop.Op.Content)
+			if err != nil {
+				return nil, err
+			}
+			res := eop.Op
+			res.Content = merged
+			r.stamp(st, &res, eop.Op)
+			out = append(out, eop, types.ExtendedOp{Op: res, OldContent: st.ours})
+			st.ours = merged
+		}
+	}
+	return out, nil
+}
+
+// runDriver runs a custom merge driver configured as merge.<name>.driver.
+// As with git, %O, %A and %B are replaced by files holding the base, ours and
+// theirs versions, and the driver leaves its result in %A.
+func (r *Resolver) runDriver(name, base, ours, theirs string) (string, error) {
+	cmdline, err := config.GetConfigValue(r.repoPath, "merge."+name+".driver")
+	if err != nil || cmdline == "" {
+		return "", fmt.Errorf("merge driver %s is not configured (set merge.%s.driver)", name, name)
+	}
+
+	tmp, err := os.MkdirTemp("", "evo-merge-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	files := map[string]string{"%O": base, "%A": ours, "%B": theirs}
+	paths := make(map[string]string)
+	for ph, content := range files {
+		f, err := os.CreateTemp(tmp, "line-*")
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.WriteString(content + "\n"); err != nil {
+			f.Close()
+			return "", err
+		}
+		f.Close()
+		paths[ph] = f.Name()
+		cmdline = strings.ReplaceAll(cmdline, ph, f.Name())
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", cmdline)
+	cmd.Dir = r.repoPath
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("merge driver %s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	result, err := os.ReadFile(paths["%A"])
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(result), "\n"), nil
+}
@@ -0,0 +1,179 @@
+package merge
+
+import (
+	"evo/internal/crdt"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func conflictFixture() (uuid.UUID, []crdt.Operation, []types.ExtendedOp) {
+	fileID := uuid.New()
+	lineID := uuid.New()
+	ours, theirs := uuid.New(), uuid.New()
+	local := []crdt.Operation{
+		{Type: crdt.OpInsert, Lamport: 1, NodeID: ours, FileID: fileID, LineID: lineID, Content: "base", Vector: crdt.VectorClock{ours: 1}},
+		{Type: crdt.OpUpdate, Lamport: 5, NodeID: ours, FileID: fileID, LineID: lineID, Content: "ours", Vector: crdt.VectorClock{ours: 5}},
+	}
+	// made after seeing the insert but not the update
+	incoming := []types.ExtendedOp{
+		{Op: crdt.Operation{Type: crdt.OpUpdate, Lamport: 3, NodeID: theirs, FileID: fileID, LineID: lineID, Content: "theirs", Vector: crdt.VectorClock{ours: 1, theirs: 3}}, OldContent: "base"},
+	}
+	return fileID, local, incoming
+}
+
+func TestResolveStrategies(t *testing.T) {
+	repoPath := t.TempDir()
+
+	t.Run("CRDT", func(t *testing.T) {
+		fileID, local, incoming := conflictFixture()
+		r, err := NewResolver(repoPath, StrategyCRDT)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Equal(t, incoming, out)
+		assert.Equal(t, []Conflict{{FileID: fileID.String(), LineID: incoming[0].Op.LineID.String(), Driver: "crdt", Ours: "ours", Theirs: "theirs"}}, r.Conflicts())
+	})
+
+	t.Run("Ours", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		r, err := NewResolver(repoPath, StrategyOurs)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Empty(t, out)
+	})
+
+	t.Run("Theirs", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		r, err := NewResolver(repoPath, StrategyTheirs)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		if assert.Len(t, out, 2) {
+			assert.Equal(t, incoming[0], out[0], "their op replicates unchanged")
+			assert.Equal(t, r.self.ID, out[1].Op.NodeID)
+			assert.Equal(t, uint64(6), out[1].Op.Lamport)
+			assert.Equal(t, "theirs", out[1].Op.Content)
+			assert.Equal(t, crdt.Before, local[1].Vector.Compare(out[1].Op.Vector))
+		}
+	})
+
+	t.Run("Union_Fragment", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		incoming[0].Op.Fragment = true
+		r, err := NewResolver(repoPath, StrategyUnion)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Len(t, out, 1)
+		assert.True(t, out[0].Op.Fragment)
+	})
+
+	t.Run("Causal Successor", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		// the source had merged our update before editing the line
+		incoming[0].Op.Vector[local[0].NodeID] = 5
+		r, err := NewResolver(repoPath, StrategyOurs)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Equal(t, incoming, out)
+	})
+
+	t.Run("Union", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		r, err := NewResolver(repoPath, StrategyUnion)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Len(t, out, 1)
+		assert.Equal(t, crdt.OpInsert, out[0].Op.Type)
+		assert.NotEqual(t, incoming[0].Op.LineID, out[0].Op.LineID)
+		assert.Equal(t, "theirs", out[0].Op.Content)
+		assert.Equal(t, r.self.ID, out[0].Op.NodeID)
+	})
+
+	t.Run("Union_Stamps", func(t *testing.T) {
+		// every op the resolver makes takes a new tick of this node's clock,
+		// so none shares a (Lamport, NodeID) stamp with another op
+		_, local, incoming := conflictFixture()
+		second := incoming[0]
+		second.Op.LineID = uuid.New()
+		other := uuid.New()
+		local = append(local,
+			crdt.Operation{Type: crdt.OpInsert, Lamport: 2, NodeID: other, FileID: local[0].FileID, LineID: second.Op.LineID, Content: "base", Vector: crdt.VectorClock{other: 2}},
+			crdt.Operation{Type: crdt.OpUpdate, Lamport: 5, NodeID: other, FileID: local[0].FileID, LineID: second.Op.LineID, Content: "ours", Vector: crdt.VectorClock{other: 5}},
+		)
+		r, err := NewResolver(repoPath, StrategyUnion)
+		assert.NoError(t, err)
+		first, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		again, err := r.Resolve(local, []types.ExtendedOp{second})
+		assert.NoError(t, err)
+		seen := make(map[string]bool)
+		for _, op := range local {
+			seen[fmt.Sprint(op.Lamport, op.NodeID)] = true
+		}
+		for _, eop := range append(first, again...) {
+			key := fmt.Sprint(eop.Op.Lamport, eop.Op.NodeID)
+			assert.False(t, seen[key], "stamp %s is taken", key)
+			seen[key] = true
+		}
+		assert.Len(t, seen, len(local)+2)
+	})
+
+	t.Run("Union_Fragment", func(t *testing.T) {
+		_, local, incoming := conflictFixture()
+		incoming[0].Op.Fragment = true
+		r, err := NewResolver(repoPath, StrategyUnion)
+		assert.NoError(t, err)
+		out, err := r.Resolve(local, incoming)
+		assert.NoError(t, err)
+		assert.Len(t, out, 1)
+		assert.True(t, out[0].Op.Fragment)
+	})
+
+	t.Run("No_Conflict", func(t *testing.T) {
+		_, _, incoming := conflictFixture()
+		r, err := NewResolver(repoPath, StrategyOurs)
+		assert.NoError(t, err)
+		out, err := r.Resolve(nil, incoming)
+		assert.NoError(t, err)
+		assert.Equal(t, incoming, out)
+	})
+}
+
+func TestAttributes(t *testing.T) {
+	repoPath := t.TempDir()
+	content := "# merge drivers\nCHANGELOG.md merge=union\ndocs/** merge=theirs crdt=word\n*.lock merge=ours\n*.txt crdt=char\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, ".evo-attributes"), []byte(content), 0644))
+
+	attrs, err := LoadAttributes(repoPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "union", attrs.DriverFor("CHANGELOG.md"))
+	assert.Equal(t, "theirs", attrs.DriverFor("docs/guide/intro.md"))
+	assert.Equal(t, "ours", attrs.DriverFor("vendor/deps.lock"))
+	assert.Equal(t, "", attrs.DriverFor("main.go"))
+	assert.Equal(t, crdt.GranularityWord, attrs.GranularityFor("docs/guide/intro.md"))
+	assert.Equal(t, crdt.GranularityChar, attrs.GranularityFor("notes/todo.txt"))
+	assert.Equal(t, crdt.GranularityLine, attrs.GranularityFor("main.go"))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, ".evo-attributes"), []byte("*.md crdt=letters\n"), 0644))
+	_, err = LoadAttributes(repoPath)
+	assert.Error(t, err)
+}
+
+func TestParseStrategy(t *testing.T) {
+	s, err := ParseStrategy("")
+	assert.NoError(t, err)
+	assert.Equal(t, StrategyCRDT, s)
+
+	_, err = ParseStrategy("recursive")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+// Package migrate upgrades a repository to the format of this build, one
+// version at a time, after copying .evo aside so a failed upgrade can be
+// undone by hand.
+package migrate
+
+import (
+	"evo/internal/config"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var logger = log.For("migrate")
+
+// Migration upgrades a repository from format From to From+1
+type Migration struct {
+	From        int                         `json:"from"`
+	Description string                      `json:"description"`
+	Run         func(repoPath string) error `json:"-"`
+}
+
+// migrations is every upgrade, by the version it starts from
+var migrations = []Migration{
+	{1, "fold .evo/config.json into config.toml, convert the LFS store to objects and sharded chunks, drop unused directories", toV2},
+}
+
+// Pending returns the migrations the repository at repoPath needs, in order
+func Pending(repoPath string) ([]Migration, error) {
+	v, err := repo.Version(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if v > repo.FormatVersion {
+		return nil, &repo.TooNewError{Version: v}
+	}
+	var out []Migration
+	for _, m := range migrations {
+		if m.From >= v {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Result is what Run did
+type Result struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Applied []Migration `json:"applied"`
+	Backup  string      `json:"backup,omitempty"`
+}
+
+// Run upgrades the repository at repoPath to repo.FormatVersion. Unless
+// noBackup is set, .evo is first copied to .evo/backups/format-<version>-<time>.
+// The version is recorded after each migration, so a failed run resumes from
+// the one that failed.
+func Run(repoPath string, noBackup bool) (*Result, error) {
+	pending, err := Pending(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	from, _ := repo.Version(repoPath)
+	res := &Result{From: from, To: from, Applied: []Migration{}}
+	if len(pending) == 0 {
+		return res, nil
+	}
+	if !noBackup {
+		res.Backup = filepath.Join(repo.Dir(repoPath), "backups", fmt.Sprintf("format-%d-%s", from, time.Now().UTC().Format("20060102T150405Z")))
+		if err := backup(repo.Dir(repoPath), res.Backup); err != nil {
+			return nil, fmt.Errorf("failed to back up the repository: %w", err)
+		}
+	}
+	for _, m := range pending {
+		logger.Info("migrating", "from", m.From, "to", m.From+1)
+		if err := m.Run(repoPath); err != nil {
+			err = fmt.Errorf("failed to migrate from format %d to %d: %w", m.From, m.From+1, err)
+			if res.Backup != "" {
+				err = fmt.Errorf("%w; the repository before migrating is in %s", err, res.Backup)
+			}
+			return res, err
+		}
+		if err := repo.SetVersion(repoPath, m.From+1); err != nil {
+			return res, err
+		}
+		res.To = m.From + 1
+		res.Applied = append(res.Applied, m)
+	}
+	return res, nil
+}
+
+// backup copies the files of dir to dest, except earlier backups
+func backup(dir, dest string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "backups" {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// toV2 finishes the conversions older versions left to be done lazily
+func toV2(repoPath string) error {
+	if err := config.FoldLegacy(repoPath); err != nil {
+		return err
+	}
+	if err := lfs.NewStore(repoPath).Upgrade(); err != nil {
+		return err
+	}
+	// created by init but never used
+	for _, dir := range []string{"largefiles", "cache"} {
+		path := filepath.Join(repo.Dir(repoPath), dir)
+		if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
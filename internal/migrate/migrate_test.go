@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"evo/internal/config"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oldRepo makes a repository as evo wrote it before format versions
+func oldRepo(t *testing.T) string {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	evo := filepath.Join(rp, ".evo")
+	require.NoError(t, os.Remove(filepath.Join(evo, "version")))
+	require.NoError(t, os.Mkdir(filepath.Join(evo, "largefiles"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(evo, "config.json"), []byte(`{"signing.keyPath":"/k"}`), 0644))
+	return rp
+}
+
+func TestRun(t *testing.T) {
+	rp := oldRepo(t)
+	v, err := repo.Version(rp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+	pending, err := Pending(rp)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	res, err := Run(rp, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.From)
+	assert.Equal(t, repo.FormatVersion, res.To)
+	v, err = repo.Version(rp)
+	require.NoError(t, err)
+	assert.Equal(t, repo.FormatVersion, v)
+
+	cfg, err := config.Load(rp)
+	require.NoError(t, err)
+	assert.Equal(t, "/k", cfg.String("signing.keyPath"))
+	assert.NoFileExists(t, filepath.Join(rp, ".evo", "config.json"))
+	assert.NoDirExists(t, filepath.Join(rp, ".evo", "largefiles"))
+	assert.FileExists(t, filepath.Join(res.Backup, "config.json"))
+	assert.DirExists(t, filepath.Join(res.Backup, "largefiles"))
+
+	// nothing left to do, and no second backup
+	res, err = Run(rp, false)
+	require.NoError(t, err)
+	assert.Empty(t, res.Applied)
+	assert.Empty(t, res.Backup)
+	entries, err := os.ReadDir(filepath.Join(rp, ".evo", "backups"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestTooNew(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	require.NoError(t, repo.CheckVersion(rp))
+	require.NoError(t, repo.SetVersion(rp, repo.FormatVersion+1))
+	var tooNew *repo.TooNewError
+	assert.ErrorAs(t, repo.CheckVersion(rp), &tooNew)
+	_, err := Run(rp, false)
+	assert.ErrorAs(t, err, &tooNew)
+	assert.Contains(t, err.Error(), "upgrade evo")
+}
@@ -0,0 +1,118 @@
+// Package mirror replicates the repository another server serves into a
+// local one: the content of every large file, then every stream's commits,
+// merged as `evo pull` merges them. Commits carry CRDT ops, so a mirror that
+// is pushed to as well converges with its origin instead of conflicting;
+// the origin never gets the mirror's own commits. Streams the origin drops
+// stay in the mirror.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"evo/internal/exchange"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/remotes"
+	"evo/internal/transfer"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var logger = log.For("mirror")
+
+// Options control mirroring
+type Options struct {
+	Transfer *transfer.Options // how large file content is fetched
+	// Lock is held while commits are applied, as the lock a server serving
+	// the mirror takes for pushes; nil for none
+	Lock sync.Locker
+}
+
+// Result reports what a pass copied
+type Result struct {
+	Remote  string             `json:"remote"`
+	Streams []*exchange.Result `json:"streams"` // streams that got commits
+	Files   []string           `json:"files"`   // large files whose content was fetched
+}
+
+// Sync copies from the remote what the repository at repoPath lacks. A
+// stream or file that fails doesn't stop the others; their errors are
+// returned joined with what was copied.
+func Sync(repoPath string, r *remotes.Remote, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	res := &Result{Remote: r.Name, Streams: []*exchange.Result{}, Files: []string{}}
+	var errs []error
+
+	// content first, so commits never point to large files not there yet
+	var files []lfs.FileInfo
+	if err := r.Do(http.MethodGet, "/api/v1/lfs/files", nil, &files); err != nil {
+		return nil, fmt.Errorf("failed to list large files: %w", err)
+	}
+	store := lfs.NewStore(repoPath)
+	for _, f := range files {
+		if have, err := store.Info(f.ID); err == nil && have.ContentHash == f.ContentHash {
+			continue
+		}
+		if _, err := transfer.PullFile(repoPath, r, f.ID, opts.Transfer); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res.Files = append(res.Files, f.ID)
+	}
+
+	streams, err := exchange.RemoteStreams(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range streams {
+		cs, err := exchange.Fetch(context.Background(), repoPath, r, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to fetch %s: %w", name, err))
+			continue
+		}
+		if opts.Lock != nil {
+			opts.Lock.Lock()
+		}
+		applied, err := exchange.Apply(context.Background(), repoPath, r.Name, name, cs)
+		if opts.Lock != nil {
+			opts.Lock.Unlock()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to mirror %s: %w", name, err))
+			continue
+		}
+		if len(applied.Commits) > 0 {
+			res.Streams = append(res.Streams, applied)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// Run syncs every interval until ctx is done, logging what each pass
+// copied and why it failed
+func Run(ctx context.Context, repoPath string, r *remotes.Remote, interval time.Duration, opts *Options) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		res, err := Sync(repoPath, r, opts)
+		if err != nil {
+			logger.Warn("mirroring failed", "remote", r.Name, "err", err)
+		}
+		if res != nil && (len(res.Streams) > 0 || len(res.Files) > 0) {
+			commits := 0
+			for _, st := range res.Streams {
+				commits += len(st.Commits)
+			}
+			logger.Info("mirrored", "remote", r.Name, "streams", len(res.Streams), "commits", commits, "files", len(res.Files))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"evo/internal/lfs"
+	"evo/internal/remotes"
+	"evo/internal/repo"
+	"evo/internal/server"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commitIDs(t *testing.T, repoPath, stream string) []string {
+	t.Helper()
+	cs, err := streams.ListCommits(repoPath, stream)
+	require.NoError(t, err)
+	var ids []string
+	for _, c := range cs {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func receive(t *testing.T, repoPath, stream string, ids ...string) {
+	t.Helper()
+	var cs []types.Commit
+	for _, id := range ids {
+		cs = append(cs, types.Commit{ID: id, Stream: stream, Message: id, Timestamp: time.Now()})
+	}
+	_, err := streams.Receive(repoPath, stream, cs)
+	require.NoError(t, err)
+}
+
+func TestSync(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	origin, local := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(origin))
+	require.NoError(t, repo.InitRepo(local))
+	ts := httptest.NewServer(server.New(origin))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	receive(t, origin, "main", "c1")
+	receive(t, origin, "dev", "d1")
+	content := []byte("large file content")
+	_, err := lfs.NewStore(origin).StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	res, err := Sync(local, r, nil)
+	require.NoError(t, err)
+	assert.Len(t, res.Streams, 2)
+	assert.Equal(t, []string{"big"}, res.Files)
+	assert.Equal(t, []string{"c1"}, commitIDs(t, local, "main"))
+	assert.Equal(t, []string{"d1"}, commitIDs(t, local, "dev"))
+	var buf bytes.Buffer
+	require.NoError(t, lfs.NewStore(local).ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+
+	// nothing new, nothing copied
+	res, err = Sync(local, r, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res.Streams)
+	assert.Empty(t, res.Files)
+
+	// commits on both sides merge in the mirror; the origin keeps its own
+	receive(t, origin, "main", "c2")
+	receive(t, local, "main", "m1")
+	content = []byte("new large file content")
+	_, err = lfs.NewStore(origin).StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	res, err = Sync(local, r, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Streams, 1)
+	assert.Equal(t, []string{"c2"}, res.Streams[0].Commits)
+	assert.ElementsMatch(t, []string{"c1", "c2", "m1"}, commitIDs(t, local, "main"))
+	assert.Equal(t, []string{"c1", "c2"}, commitIDs(t, origin, "main"))
+	buf.Reset()
+	require.NoError(t, lfs.NewStore(local).ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	origin, local := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(origin))
+	require.NoError(t, repo.InitRepo(local))
+	ts := httptest.NewServer(server.New(origin))
+	defer ts.Close()
+	srv := server.New(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, local, &remotes.Remote{Name: "origin", URL: ts.URL}, 10*time.Millisecond, &Options{Lock: srv})
+		close(done)
+	}()
+	receive(t, origin, "main", "c1")
+	assert.Eventually(t, func() bool {
+		cs, _ := streams.ListCommits(local, "main")
+		return len(cs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
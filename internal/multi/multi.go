@@ -0,0 +1,172 @@
+// Package multi runs an operation over many repositories at once. The
+// repositories are found under a root directory, or listed in a manifest
+// file: one path per line, relative to the manifest, with blank lines and
+// lines starting with # ignored.
+package multi
+
+import (
+	"bufio"
+	"evo/internal/repo"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repo is a repository to run on
+type Repo struct {
+	Name string `json:"name"` // path relative to the root or manifest, used as prefix
+	Path string `json:"path"`
+}
+
+// Discover finds the repositories, bare or not, under root. The repositories
+// found are not searched for nested ones, nor are hidden directories.
+func Discover(root string) ([]Repo, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var out []Repo
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if repo.IsRepo(path) {
+			out = append(out, newRepo(root, path))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", root, err)
+	}
+	return out, nil
+}
+
+// ReadManifest returns the repositories a manifest file lists
+func ReadManifest(path string) ([]Repo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer f.Close()
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	var out []Repo
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := line
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		p = filepath.Clean(p)
+		if !repo.IsRepo(p) {
+			return nil, fmt.Errorf("%s:%d: %s is not an evo repository", path, n, line)
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, Repo{Name: line, Path: p})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return out, nil
+}
+
+func newRepo(root, path string) Repo {
+	name, err := filepath.Rel(root, path)
+	if err != nil || name == "." {
+		name = filepath.Base(path)
+	}
+	return Repo{Name: filepath.ToSlash(name), Path: path}
+}
+
+// Result is the outcome of an operation on one repository
+type Result struct {
+	Repo
+	Value  any    `json:"result,omitempty"`
+	Output string `json:"-"`
+	Err    error  `json:"-"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Op runs on one repository and returns its result for JSON output and the
+// text to print
+type Op func(r Repo) (any, string, error)
+
+// Run runs op on up to jobs repositories at a time. report is called with
+// each result in the order of repos, as soon as it and those before it are
+// done, so output is not interleaved. Run returns the number of failures.
+func Run(repos []Repo, jobs int, op Op, report func(*Result)) int {
+	jobs = max(1, min(jobs, len(repos)))
+	results := make([]*Result, len(repos))
+	done := make([]chan struct{}, len(repos))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				res := &Result{Repo: repos[i]}
+				res.Value, res.Output, res.Err = op(repos[i])
+				if res.Err != nil {
+					res.Error = res.Err.Error()
+				}
+				results[i] = res
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range repos {
+			work <- i
+		}
+		close(work)
+	}()
+	failed := 0
+	for i := range repos {
+		<-done[i]
+		if results[i].Err != nil {
+			failed++
+		}
+		report(results[i])
+	}
+	wg.Wait()
+	return failed
+}
+
+// Prefix puts "[name] " before every line of text
+func Prefix(name, text string) string {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return ""
+	}
+	var sb strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&sb, "[%s] %s\n", name, line)
+	}
+	return sb.String()
+}
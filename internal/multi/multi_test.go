@@ -0,0 +1,83 @@
+package multi
+
+import (
+	"errors"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func names(repos []Repo) []string {
+	var out []string
+	for _, r := range repos {
+		out = append(out, r.Name)
+	}
+	return out
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a", "group/b", "group/c", "a/nested", ".hidden/d"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, p, repo.EvoDir), 0755))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "plain"), 0755))
+	require.NoError(t, repo.Init(filepath.Join(root, "srv"), repo.InitOptions{Bare: true}))
+
+	repos, err := Discover(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "group/b", "group/c", "srv"}, names(repos))
+	assert.Equal(t, filepath.Join(root, "group", "b"), repos[1].Path)
+
+	// the root itself may be a repository
+	repos, err = Discover(filepath.Join(root, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, names(repos))
+
+	_, err = Discover(filepath.Join(root, "missing"))
+	assert.Error(t, err)
+}
+
+func TestReadManifest(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"x", "y"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, p, repo.EvoDir), 0755))
+	}
+	manifest := filepath.Join(root, "repos.txt")
+	require.NoError(t, os.WriteFile(manifest, []byte("# team repos\ny\n\nx\n./y\n"), 0644))
+
+	repos, err := ReadManifest(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"y", "x"}, names(repos))
+	assert.Equal(t, filepath.Join(root, "x"), repos[1].Path)
+
+	require.NoError(t, os.WriteFile(manifest, []byte("x\nplain\n"), 0644))
+	_, err = ReadManifest(manifest)
+	assert.ErrorContains(t, err, "repos.txt:2: plain is not an evo repository")
+}
+
+func TestRun(t *testing.T) {
+	repos := []Repo{{Name: "slow"}, {Name: "bad"}, {Name: "fast"}}
+	var order []string
+	failed := Run(repos, 3, func(r Repo) (any, string, error) {
+		switch r.Name {
+		case "slow":
+			time.Sleep(20 * time.Millisecond)
+		case "bad":
+			return nil, "", errors.New("boom")
+		}
+		return r.Name, r.Name + " done\n", nil
+	}, func(res *Result) {
+		order = append(order, res.Name)
+	})
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, []string{"slow", "bad", "fast"}, order)
+
+	assert.Equal(t, 0, Run(nil, 4, nil, nil))
+	assert.Equal(t, "[a] one\n[a] two\n", Prefix("a", "one\ntwo\n"))
+	assert.Equal(t, "", Prefix("a", ""))
+}
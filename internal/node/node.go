@@ -0,0 +1,122 @@
+package node
+
+import (
+	"bufio"
+	"evo/internal/crdt"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The .evo/node file holds this client's stable NodeID on the first line and
+// the last Lamport time it issued on the second.
+
+// Node is the identity and Lamport clock of this client in a repository
+type Node struct {
+	ID       uuid.UUID
+	Clock    *crdt.LamportClock
+	repoPath string
+}
+
+func nodePath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "node")
+}
+
+// Load reads the node identity, creating a new one if the repo has none yet
+func Load(repoPath string) (*Node, error) {
+	f, err := os.Open(nodePath(repoPath))
+	if os.IsNotExist(err) {
+		n := &Node{
+			ID:       uuid.New(),
+			Clock:    crdt.NewLamportClock(0),
+			repoPath: repoPath,
+		}
+		if err := n.Save(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, strings.TrimSpace(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("node file is empty")
+	}
+	id, err := uuid.Parse(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid node ID: %w", err)
+	}
+	var lamport uint64
+	if len(lines) > 1 && lines[1] != "" {
+		lamport, err = strconv.ParseUint(lines[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Lamport time: %w", err)
+		}
+	}
+	return &Node{
+		ID:       id,
+		Clock:    crdt.NewLamportClock(lamport),
+		repoPath: repoPath,
+	}, nil
+}
+
+// Fork gives the repository at dst, a copy of the one at src, an identity of
+// its own whose clock continues from src's, so ops made in either never
+// share a NodeID
+func Fork(src, dst string) (*Node, error) {
+	var now uint64
+	if _, err := os.Stat(nodePath(src)); err == nil {
+		n, err := Load(src)
+		if err != nil {
+			return nil, err
+		}
+		now = n.Clock.Now()
+	}
+	fork := &Node{ID: uuid.New(), Clock: crdt.NewLamportClock(now), repoPath: dst}
+	if err := fork.Save(); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+// Tick advances the Lamport clock for a new local op
+func (n *Node) Tick() uint64 {
+	return n.Clock.Tick()
+}
+
+// Observe merges Lamport times from ops created elsewhere into the clock
+func (n *Node) Observe(ops ...crdt.Operation) {
+	for _, op := range ops {
+		n.Clock.Observe(op.Lamport)
+	}
+}
+
+// Save persists the node identity and current Lamport time
+func (n *Node) Save() error {
+	fp := nodePath(n.repoPath)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("%s\n%d\n", n.ID, n.Clock.Now())
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, fp)
+}
@@ -0,0 +1,34 @@
+package node
+
+import (
+	"evo/internal/crdt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeIdentity(t *testing.T) {
+	repoPath := t.TempDir()
+
+	n, err := Load(repoPath)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), n.Tick())
+	assert.Equal(t, uint64(2), n.Tick())
+
+	// remote ops push the clock forward, local ticks continue after them
+	n.Observe(crdt.Operation{Lamport: 40}, crdt.Operation{Lamport: 7})
+	assert.Equal(t, uint64(41), n.Tick())
+	assert.NoError(t, n.Save())
+
+	again, err := Load(repoPath)
+	assert.NoError(t, err)
+	assert.Equal(t, n.ID, again.ID)
+	assert.Equal(t, uint64(42), again.Tick())
+}
+
+func TestLamportClockMonotonic(t *testing.T) {
+	c := crdt.NewLamportClock(10)
+	c.Observe(5)
+	assert.Equal(t, uint64(10), c.Now())
+	assert.Equal(t, uint64(11), c.Tick())
+}
@@ -0,0 +1,331 @@
+package notes
+
+import (
+	"encoding/json"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notes annotate commits without touching them: they live in
+// .evo/notes/<namespace>/<commitID>.json, outside the signed commit body, and
+// can be added or removed at any time. Each note has its own ID and removal
+// leaves a tombstone, so two copies of a repository merge their notes by
+// union without losing removals.
+
+// DefaultNamespace holds notes added without --ns
+const DefaultNamespace = "commits"
+
+// Note is one annotation of a commit
+type Note struct {
+	ID          string    `json:"id"`
+	Commit      string    `json:"commit"`
+	Namespace   string    `json:"namespace"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+	Text        string    `json:"text"`
+	Deleted     bool      `json:"deleted,omitempty"`
+}
+
+func notesDir(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "notes")
+}
+
+func notesPath(repoPath, ns, commitID string) string {
+	return filepath.Join(notesDir(repoPath), ns, commitID+".json")
+}
+
+func validNamespace(ns string) error {
+	if ns == "" || strings.ContainsAny(ns, `/\`) || strings.HasPrefix(ns, ".") {
+		return fmt.Errorf("invalid notes namespace %q", ns)
+	}
+	return nil
+}
+
+// load returns every note of a commit, tombstones included
+func load(repoPath, ns, commitID string) ([]Note, error) {
+	data, err := os.ReadFile(notesPath(repoPath, ns, commitID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	var all []Note
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse notes of %s: %w", commitID, err)
+	}
+	return all, nil
+}
+
+func save(repoPath, ns, commitID string, all []Note) error {
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Timestamp.Before(all[j].Timestamp) })
+	path := notesPath(repoPath, ns, commitID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create notes directory: %w", err)
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	return platform.Rename(tmp, path)
+}
+
+func live(all []Note) []Note {
+	var out []Note
+	for _, n := range all {
+		if !n.Deleted {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Add attaches a note to commitID
+func Add(repoPath, ns, commitID, authorName, authorEmail, text string) (*Note, error) {
+	if err := validNamespace(ns); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("note text must not be empty")
+	}
+	all, err := load(repoPath, ns, commitID)
+	if err != nil {
+		return nil, err
+	}
+	n := Note{
+		ID:          uuid.New().String(),
+		Commit:      commitID,
+		Namespace:   ns,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Timestamp:   time.Now().UTC(),
+		Text:        text,
+	}
+	if err := save(repoPath, ns, commitID, append(all, n)); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// List returns the notes of commitID in ns, oldest first
+func List(repoPath, ns, commitID string) ([]Note, error) {
+	all, err := load(repoPath, ns, commitID)
+	if err != nil {
+		return nil, err
+	}
+	return live(all), nil
+}
+
+// Remove deletes the note of commitID whose ID starts with idPrefix
+func Remove(repoPath, ns, commitID, idPrefix string) (*Note, error) {
+	all, err := load(repoPath, ns, commitID)
+	if err != nil {
+		return nil, err
+	}
+	match := -1
+	for i, n := range all {
+		if n.Deleted || !strings.HasPrefix(n.ID, idPrefix) {
+			continue
+		}
+		if match >= 0 {
+			return nil, fmt.Errorf("note ID %s is ambiguous", idPrefix)
+		}
+		match = i
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("no note %s on commit %s", idPrefix, commitID)
+	}
+	all[match].Deleted = true
+	all[match].Text = ""
+	if err := save(repoPath, ns, commitID, all); err != nil {
+		return nil, err
+	}
+	return &all[match], nil
+}
+
+// Namespaces lists the namespaces that hold notes
+func Namespaces(repoPath string) ([]string, error) {
+	entries, err := os.ReadDir(notesDir(repoPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, e.Name())
+		}
+	}
+	return out, nil
+}
+
+// Annotated returns the IDs of commits in ns that have notes, with their counts
+func Annotated(repoPath, ns string) (map[string]int, error) {
+	entries, err := os.ReadDir(filepath.Join(notesDir(repoPath), ns))
+	if os.IsNotExist(err) {
+		return map[string]int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		list, err := List(repoPath, ns, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			out[id] = len(list)
+		}
+	}
+	return out, nil
+}
+
+// Export returns every note of every namespace, tombstones included, for
+// merging into another repository with Import
+func Export(repoPath string) ([]Note, error) {
+	nss, err := Namespaces(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var out []Note
+	for _, ns := range nss {
+		entries, err := os.ReadDir(filepath.Join(notesDir(repoPath), ns))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			id, ok := strings.CutSuffix(e.Name(), ".json")
+			if !ok || e.IsDir() {
+				continue
+			}
+			all, err := load(repoPath, ns, id)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, all...)
+		}
+	}
+	return out, nil
+}
+
+// Import merges notes into the repository: unknown notes are added and
+// tombstones remove the notes they name. It returns how many notes changed.
+func Import(repoPath string, incoming []Note) (int, error) {
+	type key struct{ ns, commit string }
+	groups := make(map[key][]Note)
+	for _, n := range incoming {
+		if err := validNamespace(n.Namespace); err != nil {
+			return 0, err
+		}
+		if n.Commit == "" || strings.ContainsAny(n.Commit, `/\`) || n.ID == "" {
+			return 0, fmt.Errorf("invalid note %q on commit %q", n.ID, n.Commit)
+		}
+		k := key{n.Namespace, n.Commit}
+		groups[k] = append(groups[k], n)
+	}
+	changed := 0
+	for k, in := range groups {
+		all, err := load(repoPath, k.ns, k.commit)
+		if err != nil {
+			return changed, err
+		}
+		byID := make(map[string]int, len(all))
+		for i, n := range all {
+			byID[n.ID] = i
+		}
+		dirty := false
+		for _, n := range in {
+			i, ok := byID[n.ID]
+			switch {
+			case !ok:
+				byID[n.ID] = len(all)
+				all = append(all, n)
+			case n.Deleted && !all[i].Deleted:
+				all[i].Deleted, all[i].Text = true, ""
+			default:
+				continue
+			}
+			dirty = true
+			changed++
+		}
+		if dirty {
+			if err := save(repoPath, k.ns, k.commit, all); err != nil {
+				return changed, err
+			}
+		}
+	}
+	return changed, nil
+}
+
+// Sync exchanges notes with another repository on disk, leaving both with the
+// union. It returns the number of notes changed here and there.
+func Sync(repoPath, otherPath string) (int, int, error) {
+	mine, err := Export(repoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	theirs, err := Export(otherPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	pulled, err := Import(repoPath, theirs)
+	if err != nil {
+		return pulled, 0, err
+	}
+	pushed, err := Import(otherPath, mine)
+	return pulled, pushed, err
+}
+
+// RenameCommits moves the notes of commits whose IDs changed, as when
+// history is rewritten, to their new IDs (old => new)
+func RenameCommits(repoPath string, ids map[string]string) error {
+	namespaces, err := Namespaces(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		for old, id := range ids {
+			all, err := load(repoPath, ns, old)
+			if err != nil {
+				return err
+			}
+			if all == nil {
+				continue
+			}
+			have, err := load(repoPath, ns, id)
+			if err != nil {
+				return err
+			}
+			for i := range all {
+				all[i].Commit = id
+			}
+			if err := save(repoPath, ns, id, append(have, all...)); err != nil {
+				return err
+			}
+			if err := os.Remove(notesPath(repoPath, ns, old)); err != nil {
+				return fmt.Errorf("failed to remove notes of %s: %w", old, err)
+			}
+		}
+	}
+	return nil
+}
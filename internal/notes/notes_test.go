@@ -0,0 +1,71 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRemove(t *testing.T) {
+	repo := t.TempDir()
+	n, err := Add(repo, DefaultNamespace, "c1", "Ann", "ann@example.com", "build passed")
+	assert.NoError(t, err)
+	_, err = Add(repo, "review", "c1", "Bo", "bo@example.com", "looks good")
+	assert.NoError(t, err)
+	_, err = Add(repo, DefaultNamespace, "c1", "Ann", "ann@example.com", "  ")
+	assert.Error(t, err)
+	_, err = Add(repo, "../x", "c1", "Ann", "ann@example.com", "text")
+	assert.Error(t, err)
+
+	list, err := List(repo, DefaultNamespace, "c1")
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "build passed", list[0].Text)
+
+	nss, err := Namespaces(repo)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{DefaultNamespace, "review"}, nss)
+
+	_, err = Remove(repo, DefaultNamespace, "c1", "nope")
+	assert.Error(t, err)
+	_, err = Remove(repo, DefaultNamespace, "c1", n.ID[:6])
+	assert.NoError(t, err)
+	list, _ = List(repo, DefaultNamespace, "c1")
+	assert.Empty(t, list)
+	counts, err := Annotated(repo, DefaultNamespace)
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestSync(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	shared, err := Add(a, DefaultNamespace, "c1", "Ann", "ann@example.com", "shared")
+	assert.NoError(t, err)
+	_, err = Add(b, DefaultNamespace, "c2", "Bo", "bo@example.com", "from b")
+	assert.NoError(t, err)
+
+	pulled, pushed, err := Sync(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pulled)
+	assert.Equal(t, 1, pushed)
+
+	// a removal on one side reaches the other
+	_, err = Remove(b, DefaultNamespace, "c1", shared.ID)
+	assert.NoError(t, err)
+	pulled, pushed, err = Sync(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pulled)
+	assert.Equal(t, 0, pushed)
+	list, _ := List(a, DefaultNamespace, "c1")
+	assert.Empty(t, list)
+	list, _ = List(a, DefaultNamespace, "c2")
+	assert.Len(t, list, 1)
+
+	// syncing again changes nothing
+	pulled, pushed, err = Sync(a, b)
+	assert.NoError(t, err)
+	assert.Zero(t, pulled+pushed)
+
+	_, err = Import(a, []Note{{ID: "x", Commit: "../../etc", Namespace: DefaultNamespace}})
+	assert.Error(t, err)
+}
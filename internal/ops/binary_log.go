@@ -1,26 +1,78 @@
 package ops
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"evo/internal/crdt"
+	"evo/internal/log"
+	"evo/internal/profile"
+	"fmt"
 	"io"
 	"os"
+	"slices"
+	"sort"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// originFlag marks records carrying an OriginLineID. Records written before
+// origins existed lack the flag and decode with a Nil origin.
+const originFlag = 0x80
+
+// vectorFlag marks records followed by a vector clock
+const vectorFlag = 0x40
+
+// timeFlag marks records carrying the op's wall-clock timestamp
+const timeFlag = 0x20
+
+// fragmentFlag marks ops of a character or word document, whose content is
+// a piece of text rather than a line
+const fragmentFlag = 0x08
+
+const flagMask = originFlag | vectorFlag | timeFlag | fragmentFlag
+
+// refFlag marks a reference record: 8 bytes giving the offset of the op in
+// the file's shared store (see store.go) instead of the op itself
+const refFlag = 0x10
+
+var logger = log.For("ops")
+
 // WriteOp writes a single CRDT op in binary
 func WriteOp(w io.Writer, op crdt.Operation) error {
 	// Format:
-	// [1 byte opType]
+	// [1 byte opType | flags]
 	// [8 bytes lamport]
 	// [16 bytes nodeID]
 	// [16 bytes fileID]
 	// [16 bytes lineID]
 	// [4 bytes contentLen]
+	// [16 bytes originLineID]
+	// [if vectorFlag: 2 bytes count, count * (16 bytes nodeID, 8 bytes lamport)]
+	// [if timeFlag: 8 bytes unix nanoseconds]
 	// [content]
-	buf := make([]byte, 1+8+16+16+16+4)
-	buf[0] = byte(op.Type)
+	buf := make([]byte, 1+8+16+16+16+4+16)
+	buf[0] = byte(op.Type) | originFlag
+	if op.Fragment {
+		buf[0] |= fragmentFlag
+	}
+	if len(op.Vector) > 0 {
+		buf[0] |= vectorFlag
+		nodes := op.Vector.Nodes()
+		vec := make([]byte, 2+len(nodes)*24)
+		binary.BigEndian.PutUint16(vec[0:2], uint16(len(nodes)))
+		for i, n := range nodes {
+			off := 2 + i*24
+			copy(vec[off:off+16], n[:])
+			binary.BigEndian.PutUint64(vec[off+16:off+24], op.Vector[n])
+		}
+		buf = append(buf, vec...)
+	}
+	if !op.Timestamp.IsZero() {
+		buf[0] |= timeFlag
+		buf = binary.BigEndian.AppendUint64(buf, uint64(op.Timestamp.UnixNano()))
+	}
 	binary.BigEndian.PutUint64(buf[1:9], op.Lamport)
 	copy(buf[9:25], op.NodeID[:])
 	copy(buf[25:41], op.FileID[:])
@@ -28,6 +80,7 @@ func WriteOp(w io.Writer, op crdt.Operation) error {
 
 	contentBytes := []byte(op.Content)
 	binary.BigEndian.PutUint32(buf[57:61], uint32(len(contentBytes)))
+	copy(buf[61:77], op.OriginLineID[:])
 	if _, err := w.Write(buf); err != nil {
 		return err
 	}
@@ -39,77 +92,252 @@ func WriteOp(w io.Writer, op crdt.Operation) error {
 	return nil
 }
 
+// ReadOp reads a single op. Reference records can only be read through
+// ReadOpsFrom, which resolves them. Bytes that are no op record are a
+// *CorruptionError.
 func ReadOp(r io.Reader) (*crdt.Operation, error) {
-	header := make([]byte, 1+8+16+16+16+4)
-	_, err := io.ReadFull(r, header)
+	rec, n, err := readRecordBytes(r, nil, 1)
+	var bad badRecord
+	if errors.As(err, &bad) {
+		return nil, &CorruptionError{Reason: string(bad)}
+	}
 	if err != nil {
 		return nil, err
 	}
-	opType := crdt.OpType(header[0])
-	lamport := binary.BigEndian.Uint64(header[1:9])
-	var nodeID, fileID, lineID uuid.UUID
-	copy(nodeID[:], header[9:25])
-	copy(fileID[:], header[25:41])
-	copy(lineID[:], header[41:57])
-	contentLen := binary.BigEndian.Uint32(header[57:61])
-	content := make([]byte, contentLen)
+	var op crdt.Operation
+	if ref, isRef := decodeRecord(rec[:n], &op); isRef {
+		return nil, &CorruptionError{Reason: fmt.Sprintf("op reference to offset %d outside an op log", ref)}
+	}
+	return &op, nil
+}
+
+// fixedLen is the size of the part of an op record every op has
+const fixedLen = 1 + 8 + 16 + 16 + 16 + 4
+
+// recordLen returns the size of the record starting with b, without its
+// checksum. Until b holds enough of the record to tell, it returns how many
+// bytes it needs to know more.
+func recordLen(b []byte) (int, error) {
+	if b[0] == refFlag {
+		return 9, nil
+	}
+	if b[0]&^flagMask > byte(crdt.OpDelete) {
+		return 0, badRecord(fmt.Sprintf("unknown record type %#x", b[0]))
+	}
+	if len(b) < fixedLen {
+		return fixedLen, nil
+	}
+	contentLen := binary.BigEndian.Uint32(b[57:61])
+	if contentLen > maxContentLen {
+		return 0, badRecord(fmt.Sprintf("content length %d out of range", contentLen))
+	}
+	n := fixedLen
+	if b[0]&originFlag != 0 {
+		n += 16
+	}
+	if b[0]&vectorFlag != 0 {
+		if len(b) < n+2 {
+			return n + 2, nil
+		}
+		n += 2 + int(binary.BigEndian.Uint16(b[n:n+2]))*24
+	}
+	if b[0]&timeFlag != 0 {
+		n += 8
+	}
+	return n + int(contentLen), nil
+}
+
+// decodeRecord decodes a whole record into op, or returns the store offset
+// of a reference record
+func decodeRecord(b []byte, op *crdt.Operation) (int64, bool) {
+	if b[0] == refFlag {
+		return int64(binary.BigEndian.Uint64(b[1:9])), true
+	}
+	flags := b[0]
+	*op = crdt.Operation{
+		Type:     crdt.OpType(flags &^ flagMask),
+		Lamport:  binary.BigEndian.Uint64(b[1:9]),
+		Fragment: flags&fragmentFlag != 0,
+	}
+	copy(op.NodeID[:], b[9:25])
+	copy(op.FileID[:], b[25:41])
+	copy(op.LineID[:], b[41:57])
+	contentLen := int(binary.BigEndian.Uint32(b[57:61]))
+	b = b[fixedLen:]
+	if flags&originFlag != 0 {
+		copy(op.OriginLineID[:], b[:16])
+		b = b[16:]
+	}
+	if flags&vectorFlag != 0 {
+		count := int(binary.BigEndian.Uint16(b[:2]))
+		b = b[2:]
+		op.Vector = make(crdt.VectorClock, count)
+		for i := 0; i < count; i++ {
+			var n uuid.UUID
+			copy(n[:], b[:16])
+			op.Vector[n] = binary.BigEndian.Uint64(b[16:24])
+			b = b[24:]
+		}
+	}
+	if flags&timeFlag != 0 {
+		op.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+		b = b[8:]
+	}
 	if contentLen > 0 {
-		if _, err := io.ReadFull(r, content); err != nil {
-			return nil, err
+		op.Content = string(b[:contentLen])
+	}
+	return 0, false
+}
+
+// readStep is how much more of a record readRecordBytes reads at a time
+const readStep = 1 << 20
+
+// readRecordBytes reads the next record of r, with its checksum if the file's
+// version has them, into buf, and returns it with its size without the
+// checksum. It reads no further than the record.
+func readRecordBytes(r io.Reader, buf []byte, version int) ([]byte, int, error) {
+	b := buf[:0]
+	for need := 1; ; {
+		// grow in steps, so the length a damaged record claims is only
+		// allocated as far as there are bytes to fill it
+		for len(b) < need {
+			have := len(b)
+			next := min(need, have+readStep)
+			b = slices.Grow(b, next-have)[:next]
+			if k, err := io.ReadFull(r, b[have:]); err != nil {
+				if err == io.EOF && have > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return b[:have+k], 0, err
+			}
+		}
+		n, err := recordLen(b)
+		if err != nil {
+			return b, 0, err
+		}
+		total := n + checksumLen(version)
+		if total <= len(b) {
+			return b, n, nil
 		}
+		need = total
 	}
-	return &crdt.Operation{
-		Type:    opType,
-		Lamport: lamport,
-		NodeID:  nodeID,
-		FileID:  fileID,
-		LineID:  lineID,
-		Content: string(content),
-	}, nil
 }
 
 func LoadAllOps(filename string) ([]crdt.Operation, error) {
-	var out []crdt.Operation
-	f, err := os.Open(filename)
+	out, _, err := ReadOpsFrom(filename, 0)
+	return out, err
+}
+
+// ReadOpsFrom reads the ops stored after byte offset and returns them with the
+// offset just past the last complete record. A partial record at the end (an
+// interrupted write) is ignored; a damaged record is a *CorruptionError.
+func ReadOpsFrom(filename string, offset int64) ([]crdt.Operation, int64, error) {
+	defer profile.Track(profile.IO)()
+	version, hsize, err := readHeader(filename)
 	if os.IsNotExist(err) {
-		return out, nil
+		return nil, 0, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, offset, err
+	}
+	offset = max(offset, hsize)
+	f, err := OpenLog(filename, offset)
+	if err != nil {
+		return nil, offset, err
 	}
 	defer f.Close()
 
+	br := getReader(f)
+	defer putReader(br)
+	r := newRecordReader(br, filename, version, offset)
+	var store *storeReader
+	defer func() { store.Close() }()
+	var out []crdt.Operation
+	end := offset
 	for {
-		op, e := ReadOp(f)
-		if e == io.EOF {
+		out = append(out, crdt.Operation{})
+		op := &out[len(out)-1]
+		ref, isRef, e := r.next(op)
+		if e == io.ErrUnexpectedEOF {
+			// an interrupted write => stop at the last complete record
+			logger.Warn("op log ends in a partial record", "file", filename, "offset", end)
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			out = out[:len(out)-1]
 			break
 		}
 		if e != nil {
-			// partial read => ignore or return
-			return out, nil
+			return nil, end, e
+		}
+		if len(out) == 1 {
+			// the records of a log are mostly of one kind and size
+			out = slices.Grow(out, int((LogSize(filename)-offset)/(r.offset()-offset)))
+			op = &out[0]
 		}
-		out = append(out, *op)
+		if isRef {
+			if store == nil {
+				if store, err = openStore(StorePath(filename)); err != nil {
+					return nil, offset, err
+				}
+			}
+			if err = store.ReadAt(ref, op); err != nil {
+				return nil, offset, fmt.Errorf("failed to resolve op reference in %s: %w", filename, err)
+			}
+		}
+		end = r.offset()
 	}
-	return out, nil
+	return out, end, nil
 }
 
+// AppendOp appends an op, inline, to a log
 func AppendOp(filename string, op crdt.Operation) error {
-	if err := os.MkdirAll(dirOf(filename), 0755); err != nil {
+	var rec bytes.Buffer
+	if err := WriteOp(&rec, op); err != nil {
 		return err
 	}
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return WriteOp(f, op)
+	return appendRecord(filename, rec.Bytes())
 }
 
-func dirOf(fp string) string {
-	for i := len(fp) - 1; i >= 0; i-- {
-		if fp[i] == '/' || fp[i] == '\\' {
-			return fp[:i]
+// MigrateLog rewrites a log written before origins existed so every legacy
+// insert is anchored after the insert preceding it in Lamport order, which is
+// the order the old RGA produced. It reports whether the file was changed.
+func MigrateLog(filename string) (bool, error) {
+	all, err := LoadAllOps(filename)
+	if err != nil {
+		return false, err
+	}
+	var legacy []int
+	for i, op := range all {
+		if op.Type == crdt.OpInsert && op.OriginLineID == uuid.Nil {
+			legacy = append(legacy, i)
 		}
 	}
-	return "."
+	if len(legacy) == 0 {
+		return false, nil
+	}
+	sort.SliceStable(legacy, func(a, b int) bool {
+		return all[legacy[a]].LessThan(&all[legacy[b]])
+	})
+	prev := crdt.DocumentStart
+	seen := make(map[uuid.UUID]bool)
+	for _, i := range legacy {
+		if seen[all[i].LineID] {
+			// re-insert of an existing line keeps its place
+			all[i].OriginLineID = prev
+			continue
+		}
+		seen[all[i].LineID] = true
+		all[i].OriginLineID = prev
+		prev = all[i].LineID
+	}
+
+	return true, ReplaceLog(filename, func(w io.Writer) error {
+		lw := NewLogWriter(w)
+		for _, op := range all {
+			if err := lw.WriteOp(op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
@@ -0,0 +1,230 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"evo/internal/crdt"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// writeLegacyOp writes a record in the format used before origins existed
+func writeLegacyOp(buf *bytes.Buffer, op crdt.Operation) {
+	h := make([]byte, 1+8+16+16+16+4)
+	h[0] = byte(op.Type)
+	binary.BigEndian.PutUint64(h[1:9], op.Lamport)
+	copy(h[9:25], op.NodeID[:])
+	copy(h[25:41], op.FileID[:])
+	copy(h[41:57], op.LineID[:])
+	binary.BigEndian.PutUint32(h[57:61], uint32(len(op.Content)))
+	buf.Write(h)
+	buf.WriteString(op.Content)
+}
+
+func materialize(t *testing.T, path string) []string {
+	all, err := LoadAllOps(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := crdt.NewRGA()
+	for _, op := range all {
+		doc.Apply(op)
+	}
+	return doc.Materialize()
+}
+
+func TestMigrateLegacyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	nodeID := uuid.New()
+	l1, l2, l3 := uuid.New(), uuid.New(), uuid.New()
+
+	var buf bytes.Buffer
+	// written out of Lamport order, as concurrent ingest could
+	writeLegacyOp(&buf, crdt.Operation{Type: crdt.OpInsert, Lamport: 2, NodeID: nodeID, LineID: l2, Content: "two"})
+	writeLegacyOp(&buf, crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nodeID, LineID: l1, Content: "one"})
+	writeLegacyOp(&buf, crdt.Operation{Type: crdt.OpInsert, Lamport: 3, NodeID: nodeID, LineID: l3, Content: "three"})
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := materialize(t, path)
+	if len(before) != 3 || before[0] != "one" || before[2] != "three" {
+		t.Fatalf("Unexpected legacy order: %v", before)
+	}
+
+	// a new-format insert anchored to a legacy line
+	mid := crdt.Operation{Type: crdt.OpInsert, Lamport: 4, NodeID: nodeID, LineID: uuid.New(), OriginLineID: l1, Content: "one-and-a-half"}
+	if err := AppendOp(path, mid); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := MigrateLog(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate log: %v", err)
+	}
+	if !changed {
+		t.Error("Expected legacy log to be rewritten")
+	}
+	all, _ := LoadAllOps(path)
+	for _, op := range all {
+		if op.OriginLineID == uuid.Nil {
+			t.Error("Expected every insert to carry an origin after migration")
+		}
+	}
+
+	after := materialize(t, path)
+	want := []string{"one", "one-and-a-half", "two", "three"}
+	if len(after) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, after)
+	}
+	for i := range want {
+		if after[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, after)
+			break
+		}
+	}
+
+	changed, err = MigrateLog(path)
+	if err != nil || changed {
+		t.Errorf("Expected second migration to be a no-op, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestVectorRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	a, b := uuid.New(), uuid.New()
+	op := crdt.Operation{
+		Type:         crdt.OpInsert,
+		Lamport:      7,
+		NodeID:       a,
+		LineID:       uuid.New(),
+		OriginLineID: crdt.DocumentStart,
+		Content:      "line",
+		Vector:       crdt.VectorClock{a: 7, b: 3},
+		Timestamp:    time.Unix(1700000000, 42),
+	}
+	if err := AppendOp(path, op); err != nil {
+		t.Fatal(err)
+	}
+	// an op without a vector keeps the shorter record
+	if err := AppendOp(path, crdt.Operation{Type: crdt.OpDelete, Lamport: 8, NodeID: a, LineID: op.LineID}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := LoadAllOps(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Expected 2 ops, got %d", len(all))
+	}
+	if all[0].Type != crdt.OpInsert || all[0].Content != "line" || all[0].Vector[a] != 7 || all[0].Vector[b] != 3 {
+		t.Errorf("Vector clock not preserved: %+v", all[0])
+	}
+	if !all[0].Timestamp.Equal(op.Timestamp) {
+		t.Errorf("Expected timestamp %v, got %v", op.Timestamp, all[0].Timestamp)
+	}
+	if all[1].Vector != nil {
+		t.Errorf("Expected no vector, got %v", all[1].Vector)
+	}
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	nid := uuid.New()
+	line := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "line"}
+	frag := crdt.Operation{Type: crdt.OpInsert, Lamport: 2, NodeID: nid, LineID: uuid.New(), OriginLineID: line.LineID, Content: "x", Fragment: true}
+	for _, op := range []crdt.Operation{line, frag} {
+		if err := AppendOp(path, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := LoadAllOps(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Fragment || !all[1].Fragment || all[1].Type != crdt.OpInsert {
+		t.Errorf("Fragment flag not preserved: %+v", all)
+	}
+}
+
+// writeBenchLog writes a log of n ops, as references into the store or
+// inline, the way ingest leaves them: each with a vector clock and timestamp
+func writeBenchLog(b *testing.B, n int, refs bool) string {
+	evo := filepath.Join(b.TempDir(), ".evo")
+	fid, nid := uuid.New(), uuid.New()
+	path := filepath.Join(evo, "ops", "main", fid.String()+".bin")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(StorePath(path)), 0755); err != nil {
+		b.Fatal(err)
+	}
+	logFile, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	storeFile, err := os.Create(StorePath(path))
+	if err != nil {
+		b.Fatal(err)
+	}
+	logW, storeW := bufio.NewWriter(logFile), bufio.NewWriter(storeFile)
+	log, store := NewLogWriter(logW), NewLogWriter(storeW)
+	var off int64 = headerSize
+	prev := crdt.DocumentStart
+	var rec bytes.Buffer
+	for i := 0; i < n; i++ {
+		op := crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i + 1), NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: prev, Content: fmt.Sprintf("line %d of the file", i), Timestamp: time.Unix(int64(i), 0)}
+		op.Vector = crdt.VectorClock{nid: uint64(i)}
+		prev = op.LineID
+		if !refs {
+			if err := log.WriteOp(op); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		if err := store.WriteOp(op); err != nil {
+			b.Fatal(err)
+		}
+		if err := log.WriteRef(off); err != nil {
+			b.Fatal(err)
+		}
+		rec.Reset()
+		WriteOp(&rec, op)
+		off += int64(rec.Len() + crcSize)
+	}
+	for _, w := range []*bufio.Writer{logW, storeW} {
+		if err := w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	logFile.Close()
+	storeFile.Close()
+	return path
+}
+
+func benchmarkLoad(b *testing.B, refs bool) {
+	const n = 1_000_000
+	path := writeBenchLog(b, n, refs)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		all, err := LoadAllOps(path)
+		if err != nil || len(all) != n {
+			b.Fatalf("loaded %d ops: %v", len(all), err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/op-record")
+}
+
+// BenchmarkLoadAllOps reads a log of a million references
+func BenchmarkLoadAllOps(b *testing.B) { benchmarkLoad(b, true) }
+
+// BenchmarkLoadAllOpsInline reads a log of a million inline ops
+func BenchmarkLoadAllOpsInline(b *testing.B) { benchmarkLoad(b, false) }
@@ -0,0 +1,253 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"evo/internal/crdt"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Op logs and stores written in format 2 start with a header, logMagic and
+// the version byte, and every record in them is followed by the CRC-32C of
+// its bytes. The first byte of logMagic is no valid record (op type 7), so
+// format 1 files, which have neither, are still read, and appended to, as
+// they are; repack rewrites them in the current format.
+const (
+	FormatVersion = 2
+	logMagic      = "\xefevo-op"
+	headerSize    = 8 // logMagic and the version byte
+	crcSize       = 4
+)
+
+// maxContentLen bounds the content length a record may claim, so a damaged
+// length is reported instead of allocated
+const maxContentLen = 1 << 30
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError reports a damaged record of an op log or store, or one
+// read on its own, without a Path
+type CorruptionError struct {
+	Path   string
+	Offset int64 // byte offset of the record, across segments
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("corrupt record at byte %d: %s", e.Offset, e.Reason)
+	}
+	return fmt.Sprintf("%s: corrupt record at byte %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+// badRecord is returned by recordLen for bytes that are no valid record
+type badRecord string
+
+func (e badRecord) Error() string { return string(e) }
+
+func fileHeader() []byte {
+	return append([]byte(logMagic), FormatVersion)
+}
+
+// parseHeader returns the format version of a file starting with b, at most
+// headerSize bytes, and the size of its header. An empty file is in the
+// current format.
+func parseHeader(path string, b []byte) (int, int64, error) {
+	if len(b) == 0 {
+		return FormatVersion, 0, nil
+	}
+	if b[0] != logMagic[0] {
+		return 1, 0, nil
+	}
+	if len(b) < headerSize || string(b[:len(logMagic)]) != logMagic {
+		return 0, 0, &CorruptionError{Path: path, Reason: "bad header"}
+	}
+	if v := int(b[len(logMagic)]); v != FormatVersion {
+		return 0, 0, fmt.Errorf("%s: unsupported op log format version %d", path, v)
+	}
+	return FormatVersion, int64(headerSize), nil
+}
+
+// readHeader returns the format version of a log and the size of its header
+func readHeader(logPath string) (int, int64, error) {
+	r, err := OpenLog(logPath, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	b := make([]byte, headerSize)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, 0, err
+	}
+	return parseHeader(logPath, b[:n])
+}
+
+// frame returns a record as stored in a file of the given version
+func frame(version int, rec []byte) []byte {
+	if version < 2 {
+		return rec
+	}
+	return binary.BigEndian.AppendUint32(rec, crc32.Checksum(rec, crcTable))
+}
+
+// checksumLen returns the size of the checksum after each record of a file
+func checksumLen(version int) int {
+	if version < 2 {
+		return 0
+	}
+	return crcSize
+}
+
+// readBufSize is the buffer of record readers; records that do not fit are
+// read into a separate buffer
+const readBufSize = 64 << 10
+
+var readers = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, readBufSize) }}
+
+func getReader(r io.Reader) *bufio.Reader {
+	br := readers.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readers.Put(br)
+}
+
+// recordReader reads the records of a log or store, checking each against
+// its checksum. Records are decoded from the read buffer in place.
+type recordReader struct {
+	br      *bufio.Reader
+	path    string
+	version int
+	pos     int64  // offset of the next record
+	big     []byte // holds records larger than the read buffer
+}
+
+func newRecordReader(br *bufio.Reader, path string, version int, base int64) *recordReader {
+	return &recordReader{br: br, path: path, version: version, pos: base}
+}
+
+// offset returns the offset of the next record
+func (rr *recordReader) offset() int64 {
+	return rr.pos
+}
+
+// peek returns the next record, with its checksum, and its size without it
+// from the read buffer, without consuming it
+func (rr *recordReader) peek() ([]byte, int, error) {
+	for need := 1; ; {
+		if need > rr.br.Size() {
+			return nil, 0, bufio.ErrBufferFull
+		}
+		b, err := rr.br.Peek(need)
+		if len(b) < need {
+			if err == io.EOF && len(b) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, err
+		}
+		n, err := recordLen(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		total := n + checksumLen(rr.version)
+		if total <= len(b) {
+			return b, n, nil
+		}
+		need = total
+	}
+}
+
+// next decodes the next record into op, or returns the store offset of a
+// reference record. It returns io.EOF at the end of the file,
+// io.ErrUnexpectedEOF for a record cut short by it (an interrupted write) and
+// a *CorruptionError for a damaged record.
+func (rr *recordReader) next(op *crdt.Operation) (int64, bool, error) {
+	b, n, err := rr.peek()
+	consumed := false
+	if err == bufio.ErrBufferFull {
+		b, n, err = readRecordBytes(rr.br, rr.big, rr.version)
+		rr.big, consumed = b[:0], true
+	}
+	var bad badRecord
+	if errors.As(err, &bad) {
+		return 0, false, &CorruptionError{Path: rr.path, Offset: rr.pos, Reason: string(bad)}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(b) > n && binary.BigEndian.Uint32(b[n:]) != crc32.Checksum(b[:n], crcTable) {
+		return 0, false, &CorruptionError{Path: rr.path, Offset: rr.pos, Reason: "checksum mismatch"}
+	}
+	ref, isRef := decodeRecord(b[:n], op)
+	if !consumed {
+		rr.br.Discard(len(b))
+	}
+	rr.pos += int64(len(b))
+	return ref, isRef, nil
+}
+
+// seek moves the reader to offset off of f, keeping what is buffered when
+// off lies ahead within it
+func (rr *recordReader) seek(f io.ReadSeeker, off int64) error {
+	if d := off - rr.pos; d >= 0 && d <= int64(rr.br.Buffered()) {
+		rr.br.Discard(int(d))
+		rr.pos = off
+		return nil
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	rr.br.Reset(f)
+	rr.pos = off
+	return nil
+}
+
+// LogWriter writes records in the current format, for rewriting a log with
+// ReplaceLog. The header goes before the first record.
+type LogWriter struct {
+	w       io.Writer
+	rec     bytes.Buffer
+	started bool
+}
+
+func NewLogWriter(w io.Writer) *LogWriter {
+	return &LogWriter{w: w}
+}
+
+// WriteOp writes an op inline
+func (lw *LogWriter) WriteOp(op crdt.Operation) error {
+	lw.rec.Reset()
+	if err := WriteOp(&lw.rec, op); err != nil {
+		return err
+	}
+	return lw.flush()
+}
+
+// WriteRef writes a reference to the op at offset off of the file's store
+func (lw *LogWriter) WriteRef(off int64) error {
+	lw.rec.Reset()
+	if err := WriteRef(&lw.rec, off); err != nil {
+		return err
+	}
+	return lw.flush()
+}
+
+func (lw *LogWriter) flush() error {
+	if !lw.started {
+		if _, err := lw.w.Write(fileHeader()); err != nil {
+			return err
+		}
+		lw.started = true
+	}
+	_, err := lw.w.Write(frame(FormatVersion, lw.rec.Bytes()))
+	return err
+}
@@ -0,0 +1,110 @@
+package ops
+
+import (
+	"bytes"
+	"errors"
+	"evo/internal/crdt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFormat(t *testing.T) {
+	evo := filepath.Join(t.TempDir(), ".evo")
+	fid, nid := uuid.New(), uuid.New()
+	log := filepath.Join(evo, "ops", "main", fid.String()+".bin")
+	op := func(i uint64, content string) crdt.Operation {
+		return crdt.Operation{Type: crdt.OpInsert, Lamport: i, NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: content}
+	}
+
+	t.Run("Header_And_Checksums", func(t *testing.T) {
+		assert.NoError(t, AppendOp(log, op(1, "one")))
+		assert.NoError(t, AppendOp(log, op(2, "two")))
+		data, err := os.ReadFile(log)
+		assert.NoError(t, err)
+		assert.Equal(t, fileHeader(), data[:headerSize])
+
+		// a flipped content byte of the second record is reported at its offset
+		var rec bytes.Buffer
+		assert.NoError(t, WriteOp(&rec, op(1, "one")))
+		second := int64(headerSize + rec.Len() + crcSize)
+		data[len(data)-crcSize-1] ^= 0x20
+		assert.NoError(t, os.WriteFile(log, data, 0644))
+		_, _, err = ReadOpsFrom(log, 0)
+		var ce *CorruptionError
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.Equal(t, log, ce.Path)
+			assert.Equal(t, second, ce.Offset)
+			assert.Equal(t, "checksum mismatch", ce.Reason)
+		}
+		assert.Error(t, VerifyLog(log))
+		_, err = ShareLog(log)
+		assert.Error(t, err, "a damaged log is not rewritten")
+
+		// a record cut short is an interrupted write, not corruption
+		assert.NoError(t, os.WriteFile(log, data[:second+5], 0644))
+		got, end, err := ReadOpsFrom(log, 0)
+		assert.NoError(t, err)
+		assert.Len(t, got, 1)
+		assert.Equal(t, second, end)
+		assert.NoError(t, RemoveLog(log))
+	})
+
+	t.Run("Unknown_Record", func(t *testing.T) {
+		assert.NoError(t, AppendOp(log, op(1, "one")))
+		f, err := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		f.Write(bytes.Repeat([]byte{0x07}, 80))
+		f.Close()
+		_, _, err = ReadOpsFrom(log, 0)
+		var ce *CorruptionError
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.Contains(t, ce.Reason, "unknown record type")
+		}
+		assert.NoError(t, RemoveLog(log))
+	})
+
+	t.Run("Format_1", func(t *testing.T) {
+		// logs without a header are read and appended to as they are
+		var legacy bytes.Buffer
+		assert.NoError(t, WriteOp(&legacy, op(1, "one")))
+		assert.NoError(t, os.WriteFile(log, legacy.Bytes(), 0644))
+		assert.NoError(t, AppendOp(log, op(2, "two")))
+		assert.Equal(t, []string{"two", "one"}, materialize(t, log))
+		version, _, err := readHeader(log)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+
+		changed, err := ShareLog(log)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		version, _, err = readHeader(log)
+		assert.NoError(t, err)
+		assert.Equal(t, FormatVersion, version)
+		assert.Equal(t, []string{"two", "one"}, materialize(t, log))
+		assert.NoError(t, VerifyLog(log))
+		assert.NoError(t, VerifyStore(StorePath(log)))
+	})
+
+	t.Run("Damaged_Store", func(t *testing.T) {
+		store := StorePath(log)
+		data, err := os.ReadFile(store)
+		assert.NoError(t, err)
+		data[headerSize+1] ^= 0xff
+		assert.NoError(t, os.WriteFile(store, data, 0644))
+		err = VerifyStore(store)
+		var ce *CorruptionError
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.Equal(t, store, ce.Path)
+			assert.Equal(t, int64(headerSize), ce.Offset)
+		}
+		// a log referring to it reports the store record
+		err = VerifyLog(log)
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.Equal(t, store, ce.Path)
+		}
+	})
+}
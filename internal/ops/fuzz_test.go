@@ -0,0 +1,86 @@
+package ops
+
+import (
+	"bytes"
+	"errors"
+	"evo/internal/crdt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func fuzzSeeds(f *testing.F) {
+	var buf bytes.Buffer
+	for _, op := range []crdt.Operation{
+		{Type: crdt.OpInsert, Lamport: 1, NodeID: uuid.New(), FileID: uuid.New(), LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "hello"},
+		{Type: crdt.OpUpdate, Lamport: 2, Content: "x", Fragment: true, Timestamp: time.Unix(0, 42)},
+		{Type: crdt.OpDelete, Lamport: 3, Vector: crdt.VectorClock{uuid.New(): 7}},
+	} {
+		buf.Reset()
+		if err := WriteOp(&buf, op); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{})
+	f.Add([]byte{refFlag, 0, 0, 0, 0, 0, 0, 0, 1})
+}
+
+// FuzzReadOp decodes arbitrary bytes as an op record: it may fail but not
+// panic, and what it decodes encodes to a record that decodes the same
+func FuzzReadOp(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		op, err := ReadOp(bytes.NewReader(data))
+		var ce *CorruptionError
+		if err != nil && !errors.As(err, &ce) && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Fatalf("unstructured error: %v", err)
+		}
+		if err != nil {
+			return
+		}
+		var first, second bytes.Buffer
+		if err := WriteOp(&first, *op); err != nil {
+			t.Fatal(err)
+		}
+		again, err := ReadOp(bytes.NewReader(first.Bytes()))
+		if err != nil {
+			t.Fatalf("re-encoded op does not decode: %v", err)
+		}
+		if err := WriteOp(&second, *again); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("op changed in a round trip:\n%x\n%x", first.Bytes(), second.Bytes())
+		}
+	})
+}
+
+// FuzzReadOpsFrom reads arbitrary bytes as an op log, with and without the
+// header of the current format, and as the log's store
+func FuzzReadOpsFrom(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		evo := t.TempDir()
+		for _, dir := range []string{"ops/main", "opstore"} {
+			if err := os.MkdirAll(filepath.Join(evo, dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, content := range [][]byte{data, append(fileHeader(), data...)} {
+			path := filepath.Join(evo, "ops", "main", "log"+string(rune('0'+i))+".bin")
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(StorePath(path), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// damage is a *CorruptionError, or an unsupported format version
+			ReadOpsFrom(path, 0)
+		}
+	})
+}
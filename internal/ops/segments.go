@@ -0,0 +1,854 @@
+package ops
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"evo/internal/config"
+	"evo/internal/platform"
+	"evo/internal/profile"
+	"evo/internal/repo"
+	"evo/internal/storage"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An op log starts as a single file, .evo/ops/<stream>/<fileID>.bin. Once it
+// grows past ops.segmentSize it is split into segments in a directory of the
+// same name without the extension:
+//
+//	.evo/ops/<stream>/<fileID>/000001.seg
+//	.evo/ops/<stream>/<fileID>/000002.seg
+//	.evo/ops/<stream>/<fileID>/manifest
+//
+// Only the last segment is appended to. When it reaches the threshold it is
+// sealed: its size and sha256 are recorded in the manifest and the next append
+// starts a new segment. Sealed segments are not changed in place, so local
+// clones share them by hard link; truncating one first gives it its own file.
+// A sealed segment missing from the directory is read from the same place in
+// the repository's alternates, which is how shared clones borrow them, or
+// else from the backend ops.storage names: with one set, a sealed segment is
+// stored there under its path relative to .evo and its file removed, so only
+// the manifest and the segment appended to stay local. The first rotation
+// moves the single file in as segment 1. Offsets count through all segments,
+// so a segmented log reads as one byte stream and callers keep addressing it
+// by its .bin path. A .bin file takes precedence over a directory, which is
+// only left behind by an interrupted rewrite.
+
+const (
+	segmentExt     = ".seg"
+	manifestName   = "manifest"
+	manifestHeader = "evo-segments 1"
+)
+
+// DefaultSegmentSize is the rotation threshold when ops.segmentSize is unset
+const DefaultSegmentSize = 4 << 20
+
+// segment is one file of a log; sum is set once it is sealed, and key once
+// the backend of ops.storage holds it in place of its file at path
+type segment struct {
+	path string
+	size int64
+	sum  string
+	key  string
+}
+
+// logs serializes appends and rotations, and caches thresholds by repository
+var logs = struct {
+	sync.Mutex
+	threshold map[string]int64
+}{threshold: make(map[string]int64)}
+
+// backends caches the backend of ops.storage by repository, nil where it is
+// unset; readers look it up without holding logs
+var backends = struct {
+	sync.Mutex
+	m map[string]storage.Backend
+}{m: make(map[string]storage.Backend)}
+
+// backend returns the backend sealed segments under path are stored in and
+// the key of path there, nil when the repository holding it sets none
+func backend(path string) (storage.Backend, string, error) {
+	repoPath, rel, ok := repo.RootOf(path)
+	if !ok {
+		return nil, "", nil
+	}
+	backends.Lock()
+	defer backends.Unlock()
+	b, ok := backends.m[repoPath]
+	if !ok {
+		cfg, err := config.Load(repoPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load config: %w", err)
+		}
+		if spec, _ := cfg.Get("ops.storage"); spec != "" {
+			if b, err = storage.Open(spec, repoPath); err != nil {
+				return nil, "", fmt.Errorf("failed to open op log storage: %w", err)
+			}
+		}
+		backends.m[repoPath] = b
+	}
+	return b, rel, nil
+}
+
+// SegmentDir returns the directory of a segmented log
+func SegmentDir(logPath string) string {
+	return strings.TrimSuffix(logPath, ".bin")
+}
+
+// segments returns the files of a log in order, nil if it does not exist
+func segments(logPath string) ([]segment, error) {
+	fi, err := os.Stat(logPath)
+	if err == nil {
+		return []segment{{path: logPath, size: fi.Size()}}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	dir := SegmentDir(logPath)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []segment
+	have := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != segmentExt {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		have[e.Name()] = true
+		out = append(out, segment{path: filepath.Join(dir, e.Name()), size: info.Size(), sum: sealed[e.Name()].sum})
+	}
+	for name, s := range sealed {
+		if have[name] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if p, ok := borrowed(path, s.size); ok {
+			out = append(out, segment{path: p, size: s.size, sum: s.sum})
+			continue
+		}
+		// the manifest records a segment once the backend holds it
+		b, key, err := backend(path)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			out = append(out, segment{path: path, size: s.size, sum: s.sum, key: key})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return filepath.Base(out[i].path) < filepath.Base(out[j].path) })
+	return out, nil
+}
+
+// borrowed returns the file an alternate holds for the sealed segment at
+// path, of the size the manifest records
+func borrowed(path string, size int64) (string, bool) {
+	root, rel, ok := repo.RootOf(path)
+	if !ok {
+		return "", false
+	}
+	for _, p := range repo.Borrowed(root, rel) {
+		if fi, err := os.Stat(p); err == nil && fi.Size() == size {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// readManifest returns the sealed segments of a log directory by name
+func readManifest(dir string) (map[string]segment, error) {
+	out := make(map[string]segment)
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		parts := strings.Fields(sc.Text())
+		if len(parts) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		out[parts[0]] = segment{size: size, sum: parts[2]}
+	}
+	return out, sc.Err()
+}
+
+// writeManifest records the sealed segments of a log directory
+func writeManifest(dir string, segs []segment) error {
+	var b strings.Builder
+	b.WriteString(manifestHeader + "\n")
+	for _, s := range segs {
+		if s.sum != "" {
+			fmt.Fprintf(&b, "%s %d %s\n", filepath.Base(s.path), s.size, s.sum)
+		}
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return platform.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// segmentSize returns the rotation threshold of the repository holding a log
+func segmentSize(logPath string) int64 {
+	// logs live under .evo/ops/<stream>, or ops/<stream> of a bare repository
+	repoPath, _, ok := repo.RootOf(logPath)
+	if !ok {
+		repoPath = filepath.Dir(logPath)
+	}
+	if n, ok := logs.threshold[repoPath]; ok {
+		return n
+	}
+	n := int64(DefaultSegmentSize)
+	if cfg, err := config.Load(repoPath); err == nil {
+		if v, err := cfg.Size("ops.segmentSize"); err == nil && v > 0 {
+			n = v
+		}
+	}
+	logs.threshold[repoPath] = n
+	return n
+}
+
+// appendRecord appends an encoded record to a log in the log's format,
+// rotating its last segment once it reaches the threshold
+func appendRecord(logPath string, rec []byte) error {
+	defer profile.Track(profile.IO)()
+	logs.Lock()
+	defer logs.Unlock()
+	segs, err := segments(logPath)
+	if err != nil {
+		return err
+	}
+	if len(segs) == 0 || segs[0].path == logPath && segs[0].size == 0 {
+		rec = append(fileHeader(), frame(FormatVersion, rec)...)
+	} else {
+		version, _, err := readHeader(logPath)
+		if err != nil {
+			return err
+		}
+		rec = frame(version, rec)
+	}
+	var active segment
+	switch {
+	case len(segs) == 0:
+		if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+			return err
+		}
+		active = segment{path: logPath}
+	case segs[len(segs)-1].sum != "":
+		active = segment{path: segmentName(SegmentDir(logPath), len(segs)+1)}
+		segs = append(segs, active)
+	default:
+		active = segs[len(segs)-1]
+	}
+	f, err := os.OpenFile(active.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(rec)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if active.size+int64(len(rec)) < segmentSize(logPath) {
+		return nil
+	}
+	if len(segs) == 0 {
+		segs = []segment{active}
+	}
+	return seal(logPath, segs)
+}
+
+func segmentName(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d%s", n, segmentExt))
+}
+
+// seal records the last segment of a log in the manifest, first moving a
+// single-file log into its directory as segment 1
+func seal(logPath string, segs []segment) error {
+	dir := SegmentDir(logPath)
+	last := &segs[len(segs)-1]
+	if last.path == logPath {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		seg := segmentName(dir, 1)
+		if err := platform.Rename(logPath, seg); err != nil {
+			return err
+		}
+		last.path = seg
+	}
+	size, sum, err := hashSegment(*last)
+	if err != nil {
+		return err
+	}
+	last.size, last.sum = size, sum
+	b, key, err := backend(last.path)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		if err := store(b, key, last.path); err != nil {
+			return fmt.Errorf("failed to store segment %s: %w", last.path, err)
+		}
+	}
+	if err := writeManifest(dir, segs); err != nil {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+	// with the manifest recording it, the backend's copy stands in for the file
+	if err := os.Remove(last.path); err != nil {
+		return err
+	}
+	last.key = key
+	return nil
+}
+
+// store puts the file at path into a backend under key
+func store(b storage.Backend, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return b.Put(key, f)
+}
+
+// openSegment opens the file of a segment, or reads it from the backend
+// holding it
+func openSegment(s segment) (io.ReadCloser, error) {
+	if s.key == "" {
+		return os.Open(s.path)
+	}
+	b, _, err := backend(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(s.key)
+}
+
+func hashSegment(s segment) (int64, string, error) {
+	f, err := openSegment(s)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// LogSize returns the size of a log across its segments, 0 if it does not
+// exist
+func LogSize(logPath string) int64 {
+	size, _, err := LogInfo(logPath)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// LogInfo returns the size of a log and when it was last written. The error
+// satisfies os.IsNotExist for a missing log.
+func LogInfo(logPath string) (int64, time.Time, error) {
+	segs, err := segments(logPath)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(segs) == 0 {
+		return 0, time.Time{}, &os.PathError{Op: "stat", Path: logPath, Err: os.ErrNotExist}
+	}
+	var size int64
+	for _, s := range segs {
+		size += s.size
+	}
+	last := segs[len(segs)-1].path
+	if segs[len(segs)-1].key != "" {
+		// the manifest was written when the segment was sealed
+		last = filepath.Join(SegmentDir(logPath), manifestName)
+	}
+	fi, err := os.Stat(last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return size, fi.ModTime(), nil
+}
+
+// OpenLog returns a reader of a log's bytes from offset on. The error
+// satisfies os.IsNotExist for a missing log.
+func OpenLog(logPath string, offset int64) (io.ReadCloser, error) {
+	segs, err := segments(logPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) == 0 {
+		return nil, &os.PathError{Op: "open", Path: logPath, Err: os.ErrNotExist}
+	}
+	for len(segs) > 1 && offset >= segs[0].size {
+		offset -= segs[0].size
+		segs = segs[1:]
+	}
+	return &logReader{segs: segs, skip: offset}, nil
+}
+
+// ReadLogAt reads len(p) bytes of a log at offset
+func ReadLogAt(logPath string, p []byte, offset int64) error {
+	r, err := OpenLog(logPath, offset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.ReadFull(r, p)
+	return err
+}
+
+// logReader reads the segments of a log one after another
+type logReader struct {
+	segs []segment
+	skip int64 // bytes to skip in the first segment
+	f    io.ReadCloser
+}
+
+func (r *logReader) Read(p []byte) (int, error) {
+	for {
+		if r.f == nil {
+			if len(r.segs) == 0 {
+				return 0, io.EOF
+			}
+			f, err := openSegment(r.segs[0])
+			if err != nil {
+				return 0, err
+			}
+			if err := skip(f, r.skip); err != nil {
+				f.Close()
+				return 0, err
+			}
+			r.f, r.skip, r.segs = f, 0, r.segs[1:]
+		}
+		n, err := r.f.Read(p)
+		if err == io.EOF {
+			r.f.Close()
+			r.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// skip advances a segment's reader by n bytes, seeking a file
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+func (r *logReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// TruncateLog cuts a log to size bytes, removing whole segments past it. A
+// sealed segment that is cut becomes the one appended to again.
+func TruncateLog(logPath string, size int64) error {
+	logs.Lock()
+	defer logs.Unlock()
+	segs, err := segments(logPath)
+	if err != nil {
+		return err
+	}
+	if len(segs) == 0 {
+		return &os.PathError{Op: "truncate", Path: logPath, Err: os.ErrNotExist}
+	}
+	if segs[0].path == logPath {
+		return os.Truncate(logPath, size)
+	}
+	var keep []segment
+	start := int64(0)
+	for _, s := range segs {
+		end := start + s.size
+		switch {
+		case end <= size:
+			keep = append(keep, s)
+		case start < size || start == 0:
+			if s.sum != "" {
+				if err := own(&s, SegmentDir(logPath)); err != nil {
+					return err
+				}
+			}
+			if err := os.Truncate(s.path, size-start); err != nil {
+				return err
+			}
+			s.size, s.sum = size-start, ""
+			keep = append(keep, s)
+		default:
+			if err := removeSegment(s); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return writeManifest(SegmentDir(logPath), keep)
+}
+
+// unshare replaces the file at path with a copy of its own, leaving any other
+// link to it as it is
+func unshare(path string) error {
+	tmp := path + ".tmp"
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := platform.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeSegment deletes a segment's file, or the backend's copy of it
+func removeSegment(s segment) error {
+	if s.key == "" {
+		return os.Remove(s.path)
+	}
+	b, _, err := backend(s.path)
+	if err != nil {
+		return err
+	}
+	return b.Delete(s.key)
+}
+
+// own gives a sealed segment about to be changed a file of its own in the
+// log directory dir, copying it there if it is borrowed from an alternate or
+// fetching it from the backend holding it
+func own(s *segment, dir string) error {
+	local := filepath.Join(dir, filepath.Base(s.path))
+	if s.key != "" {
+		if err := fetch(*s, local); err != nil {
+			return err
+		}
+		if err := removeSegment(*s); err != nil {
+			return err
+		}
+		s.key = ""
+		return nil
+	}
+	if s.path == local {
+		return unshare(local)
+	}
+	if err := copyFile(s.path, local); err != nil {
+		return err
+	}
+	s.path = local
+	return nil
+}
+
+// fetch writes the backend's copy of a segment to the file at path
+func fetch(s segment, path string) error {
+	r, err := openSegment(s)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = platform.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// removeStored deletes what the backend holds of a log's segments
+func removeStored(logPath string) error {
+	b, prefix, err := backend(SegmentDir(logPath))
+	if err != nil || b == nil {
+		return err
+	}
+	return b.List(prefix+"/", func(key string, _ int64) error {
+		return b.Delete(key)
+	})
+}
+
+// OwnSegments copies into their log directories the sealed segments the logs
+// under an ops directory read from an alternate, returning how many it copied
+func OwnSegments(opsDir string) (int, error) {
+	logPaths, err := AllLogs(opsDir)
+	if err != nil {
+		return 0, err
+	}
+	logs.Lock()
+	defer logs.Unlock()
+	n := 0
+	for _, l := range logPaths {
+		segs, err := segments(l)
+		if err != nil {
+			return n, err
+		}
+		for i := range segs {
+			if segs[i].path == l || filepath.Dir(segs[i].path) == SegmentDir(l) {
+				continue
+			}
+			if err := own(&segs[i], SegmentDir(l)); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SealedSegments returns the sealed segments of the logs under an ops
+// directory (.evo/ops), which nothing changes in place
+func SealedSegments(opsDir string) ([]string, error) {
+	logPaths, err := AllLogs(opsDir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range logPaths {
+		segs, err := segments(l)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range segs {
+			if s.sum != "" && s.key == "" {
+				out = append(out, s.path)
+			}
+		}
+	}
+	return out, nil
+}
+
+// RemoveLog deletes a log and its segments
+func RemoveLog(logPath string) error {
+	logs.Lock()
+	defer logs.Unlock()
+	if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := removeStored(logPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(SegmentDir(logPath))
+}
+
+// Mark returns what Restore puts a log back to: its size, -1 if it doesn't
+// exist
+func Mark(logPath string) int64 {
+	size, _, err := LogInfo(logPath)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// Restore puts each log back to its mark, cutting what was appended since
+// and removing logs that didn't exist
+func Restore(marks map[string]int64) error {
+	var errs []error
+	for path, size := range marks {
+		if size < 0 {
+			errs = append(errs, RemoveLog(path))
+		} else {
+			errs = append(errs, TruncateLog(path, size))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReplaceLog atomically replaces the content of a log with what write
+// produces. The new content is a single file until it grows past the
+// threshold again.
+func ReplaceLog(logPath string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	tmp := logPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	logs.Lock()
+	defer logs.Unlock()
+	if err := platform.Rename(tmp, logPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the single file now takes precedence; drop the old segments
+	if err := removeStored(logPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(SegmentDir(logPath))
+}
+
+// ListLogs returns the .bin paths of the op logs in a stream directory,
+// single-file and segmented alike
+func ListLogs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case !e.IsDir() && filepath.Ext(name) == ".bin":
+			name = strings.TrimSuffix(name, ".bin")
+		case e.IsDir():
+		default:
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, filepath.Join(dir, name+".bin"))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// AllLogs returns the .bin paths of the op logs of every stream under an ops
+// directory (.evo/ops)
+func AllLogs(opsDir string) ([]string, error) {
+	streams, err := os.ReadDir(opsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, st := range streams {
+		if !st.IsDir() {
+			continue
+		}
+		logs, err := ListLogs(filepath.Join(opsDir, st.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, logs...)
+	}
+	return out, nil
+}
+
+// VerifyLog checks the sealed segments of a log against their recorded size
+// and checksum, then reads every record, returning the first damaged one as a
+// *CorruptionError
+func VerifyLog(logPath string) error {
+	segs, err := segments(logPath)
+	if err != nil {
+		return err
+	}
+	if len(segs) == 0 {
+		return nil
+	}
+	if segs[0].path != logPath {
+		if err := verifySegments(logPath, segs); err != nil {
+			return err
+		}
+	}
+	_, _, err = ReadOpsFrom(logPath, 0)
+	return err
+}
+
+// verifySegments checks the sealed segments of a log against the manifest,
+// wherever they are read from
+func verifySegments(logPath string, segs []segment) error {
+	sealed, err := readManifest(SegmentDir(logPath))
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]segment, len(segs))
+	for _, s := range segs {
+		byName[filepath.Base(s.path)] = s
+	}
+	for name, want := range sealed {
+		path := filepath.Join(SegmentDir(logPath), name)
+		s, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("segment %s: %w", path, os.ErrNotExist)
+		}
+		size, sum, err := hashSegment(s)
+		if err != nil {
+			return fmt.Errorf("segment %s: %w", path, err)
+		}
+		if size != want.size || sum != want.sum {
+			return fmt.Errorf("segment %s does not match its checksum", path)
+		}
+	}
+	return nil
+}
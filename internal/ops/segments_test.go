@@ -0,0 +1,216 @@
+package ops
+
+import (
+	"bufio"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegments(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, config.SetConfigValue(rp, "ops.segmentSize", "256"))
+	fid, nid := uuid.New(), uuid.New()
+	dir := filepath.Join(rp, ".evo", "ops", "main")
+	log := filepath.Join(dir, fid.String()+".bin")
+
+	op := func(i int) crdt.Operation {
+		return crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i), NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: fmt.Sprintf("line %d", i)}
+	}
+	assert.NoError(t, AppendOp(log, op(1)))
+	_, err := os.Stat(log)
+	assert.NoError(t, err, "a small log is a single file")
+
+	for i := 2; i <= 20; i++ {
+		assert.NoError(t, AppendOp(log, op(i)))
+	}
+	t.Run("Rotate", func(t *testing.T) {
+		_, err := os.Stat(log)
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(SegmentDir(log), "000001.seg"))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(SegmentDir(log), "000002.seg"))
+		assert.NoError(t, err)
+		sealed, err := readManifest(SegmentDir(log))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, sealed)
+		paths, err := SealedSegments(filepath.Join(rp, ".evo", "ops"))
+		assert.NoError(t, err)
+		assert.Len(t, paths, len(sealed))
+		assert.Contains(t, paths, filepath.Join(SegmentDir(log), "000001.seg"))
+
+		all, err := LoadAllOps(log)
+		assert.NoError(t, err)
+		if assert.Len(t, all, 20) {
+			for i, o := range all {
+				assert.Equal(t, uint64(i+1), o.Lamport)
+			}
+		}
+		logs, err := ListLogs(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{log}, logs)
+	})
+
+	t.Run("Offsets", func(t *testing.T) {
+		size := LogSize(log)
+		first, off, err := ReadOpsFrom(log, 0)
+		assert.NoError(t, err)
+		assert.Len(t, first, 20)
+		assert.Equal(t, size, off)
+
+		assert.NoError(t, AppendOp(log, op(21)))
+		more, end, err := ReadOpsFrom(log, off)
+		assert.NoError(t, err)
+		if assert.Len(t, more, 1) {
+			assert.Equal(t, uint64(21), more[0].Lamport)
+		}
+		assert.Equal(t, LogSize(log), end)
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		segs, err := segments(log)
+		assert.NoError(t, err)
+		// cut at a record boundary inside the first segment: later segments
+		// go and the first is unsealed
+		f, err := OpenLog(log, headerSize)
+		assert.NoError(t, err)
+		r := newRecordReader(bufio.NewReader(f), log, FormatVersion, headerSize)
+		var cut int64
+		var rec crdt.Operation
+		for r.offset() < segs[0].size {
+			cut = r.offset()
+			_, _, err := r.next(&rec)
+			assert.NoError(t, err)
+		}
+		f.Close()
+		// a clone's hard link to the sealed segment keeps its content
+		clone := filepath.Join(t.TempDir(), "000001.seg")
+		assert.NoError(t, os.Link(segs[0].path, clone))
+		assert.NoError(t, TruncateLog(log, cut))
+		fi, err := os.Stat(clone)
+		assert.NoError(t, err)
+		assert.Equal(t, segs[0].size, fi.Size())
+		assert.Equal(t, cut, LogSize(log))
+		segs, err = segments(log)
+		assert.NoError(t, err)
+		if assert.Len(t, segs, 1) {
+			assert.Empty(t, segs[0].sum)
+		}
+		all, err := LoadAllOps(log)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, all)
+		assert.NoError(t, AppendOp(log, op(99)))
+		again, err := LoadAllOps(log)
+		assert.NoError(t, err)
+		assert.Len(t, again, len(all)+1)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		other := filepath.Join(dir, uuid.New().String()+".bin")
+		marks := map[string]int64{log: Mark(log), other: Mark(other)}
+		assert.Equal(t, int64(-1), marks[other])
+		for i := 50; i < 60; i++ {
+			assert.NoError(t, AppendOp(log, op(i)))
+		}
+		assert.NoError(t, AppendOp(other, op(60)))
+		assert.NoError(t, Restore(marks))
+		assert.Equal(t, marks[log], LogSize(log))
+		assert.NoError(t, VerifyLog(log))
+		_, _, err := LogInfo(other)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		for i := 100; i < 120; i++ {
+			assert.NoError(t, AppendOp(log, op(i)))
+		}
+		assert.NoError(t, VerifyLog(log))
+		seg := filepath.Join(SegmentDir(log), "000001.seg")
+		data, err := os.ReadFile(seg)
+		assert.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		assert.NoError(t, os.WriteFile(seg, data, 0644))
+		assert.Error(t, VerifyLog(log))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		assert.NoError(t, ReplaceLog(log, func(w io.Writer) error {
+			return WriteOp(w, op(1))
+		}))
+		_, err := os.Stat(SegmentDir(log))
+		assert.True(t, os.IsNotExist(err))
+		all, err := LoadAllOps(log)
+		assert.NoError(t, err)
+		assert.Len(t, all, 1)
+
+		assert.NoError(t, RemoveLog(log))
+		logs, err := ListLogs(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, logs)
+	})
+}
+
+func TestSegmentStorage(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	assert.NoError(t, config.SetConfigValue(rp, "ops.segmentSize", "256"))
+	assert.NoError(t, config.SetConfigValue(rp, "ops.storage", "file:shared"))
+	fid, nid := uuid.New(), uuid.New()
+	log := filepath.Join(rp, ".evo", "ops", "main", fid.String()+".bin")
+	stored := filepath.Join(rp, "shared", "ops", "main", fid.String(), "000001.seg")
+
+	op := func(i int) crdt.Operation {
+		return crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i), NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: fmt.Sprintf("line %d", i)}
+	}
+	for i := 1; i <= 20; i++ {
+		assert.NoError(t, AppendOp(log, op(i)))
+	}
+	_, err := os.Stat(stored)
+	assert.NoError(t, err, "a sealed segment is kept in the configured storage")
+	_, err = os.Stat(filepath.Join(SegmentDir(log), "000001.seg"))
+	assert.True(t, os.IsNotExist(err), "and its file removed")
+	paths, err := SealedSegments(filepath.Join(rp, ".evo", "ops"))
+	assert.NoError(t, err)
+	assert.Empty(t, paths, "only local files are listed for linking")
+
+	all, err := LoadAllOps(log)
+	assert.NoError(t, err)
+	assert.Len(t, all, 20)
+	size := LogSize(log)
+	more, _, err := ReadOpsFrom(log, size)
+	assert.NoError(t, err)
+	assert.Empty(t, more)
+	assert.NoError(t, VerifyLog(log))
+
+	// cutting into the first segment brings it back as a file
+	assert.NoError(t, TruncateLog(log, headerSize))
+	_, err = os.Stat(stored)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(SegmentDir(log), "000001.seg"))
+	assert.NoError(t, err)
+
+	for i := 21; i <= 40; i++ {
+		assert.NoError(t, AppendOp(log, op(i)))
+	}
+	_, err = os.Stat(stored)
+	assert.NoError(t, err)
+	all, err = LoadAllOps(log)
+	assert.NoError(t, err)
+	assert.Len(t, all, 20)
+	assert.NoError(t, RemoveLog(log))
+	left, err := filepath.Glob(filepath.Join(rp, "shared", "ops", "main", "*", "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, left)
+}
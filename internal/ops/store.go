@@ -0,0 +1,326 @@
+package ops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"evo/internal/crdt"
+	"evo/internal/profile"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Every op lives once in the shared store of its file,
+// .evo/opstore/<fileID>.bin, an append-only log in the op log format. A
+// stream's op log .evo/ops/<stream>/<fileID>.bin lists the ops visible in
+// the stream as 9-byte reference records pointing into the store, so merging
+// an op into another stream costs a reference rather than a copy. Logs may
+// still hold inline records, written by older versions or by MigrateLog;
+// ReadOpsFrom returns both kinds alike.
+
+// StorePath returns the shared store of the file whose stream op log is
+// logPath (.evo/ops/<stream>/<fileID>.bin)
+func StorePath(logPath string) string {
+	evo := filepath.Dir(filepath.Dir(filepath.Dir(logPath)))
+	return filepath.Join(evo, "opstore", filepath.Base(logPath))
+}
+
+// storeIndex maps the encoding of each op in a store to its offset
+type storeIndex struct {
+	size    int64
+	version int
+	offsets map[[sha256.Size]byte]int64
+}
+
+var stores = struct {
+	sync.Mutex
+	index map[string]*storeIndex
+}{index: make(map[string]*storeIndex)}
+
+// Put adds an op to a store unless an identical op is already there and
+// returns its offset
+func Put(storePath string, op crdt.Operation) (int64, error) {
+	defer profile.Track(profile.IO)()
+	var rec bytes.Buffer
+	if err := WriteOp(&rec, op); err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(rec.Bytes())
+
+	stores.Lock()
+	defer stores.Unlock()
+	idx, err := loadIndex(storePath)
+	if err != nil {
+		return 0, err
+	}
+	if off, ok := idx.offsets[sum]; ok {
+		return off, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(storePath), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(storePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	off, version := idx.size, idx.version
+	var out []byte
+	if off == 0 {
+		// a new store is written in the current format
+		out, off, version = fileHeader(), int64(headerSize), FormatVersion
+	}
+	out = append(out, frame(version, rec.Bytes())...)
+	if _, err := f.Write(out); err != nil {
+		return 0, err
+	}
+	idx.version = version
+	idx.offsets[sum] = off
+	idx.size += int64(len(out))
+	return off, nil
+}
+
+// loadIndex brings the cached index of a store up to date with the file,
+// indexing records appended by other processes. A store that shrank is
+// indexed again from the start.
+func loadIndex(storePath string) (*storeIndex, error) {
+	key, err := filepath.Abs(storePath)
+	if err != nil {
+		key = storePath
+	}
+	idx := stores.index[key]
+	fi, err := os.Stat(storePath)
+	if os.IsNotExist(err) {
+		idx = &storeIndex{offsets: make(map[[sha256.Size]byte]int64)}
+		stores.index[key] = idx
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil || fi.Size() < idx.size {
+		idx = &storeIndex{offsets: make(map[[sha256.Size]byte]int64)}
+	}
+	if fi.Size() > idx.size {
+		f, err := os.Open(storePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if idx.size == 0 {
+			b := make([]byte, headerSize)
+			n, err := io.ReadFull(f, b)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			if idx.version, idx.size, err = parseHeader(storePath, b[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := f.Seek(idx.size, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br := getReader(f)
+		defer putReader(br)
+		r := newRecordReader(br, storePath, idx.version, idx.size)
+		var op crdt.Operation
+		for {
+			start := r.offset()
+			_, isRef, err := r.next(&op)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if isRef {
+				return nil, &CorruptionError{Path: storePath, Offset: start, Reason: "reference record in op store"}
+			}
+			var rec bytes.Buffer
+			WriteOp(&rec, op)
+			idx.offsets[sha256.Sum256(rec.Bytes())] = start
+			idx.size = r.offset()
+		}
+		if idx.size < fi.Size() {
+			// drop a partial record left by an interrupted Put, so the next
+			// one lands at the offset it is indexed under
+			if err := os.Truncate(storePath, idx.size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	stores.index[key] = idx
+	return idx, nil
+}
+
+// VerifyStore reads every record of a store, returning the first damaged one
+// as a *CorruptionError. A partial record at the end is not an error; the
+// next Put drops it.
+func VerifyStore(storePath string) error {
+	s, err := openStore(storePath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.r.seek(s.f, s.start); err != nil {
+		return err
+	}
+	var op crdt.Operation
+	for {
+		start := s.r.offset()
+		_, isRef, err := s.r.next(&op)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isRef {
+			return &CorruptionError{Path: storePath, Offset: start, Reason: "reference record in op store"}
+		}
+	}
+}
+
+// AppendRef puts an op into the shared store of the log's file and appends a
+// reference to it to the log
+func AppendRef(logPath string, op crdt.Operation) error {
+	off, err := Put(StorePath(logPath), op)
+	if err != nil {
+		return err
+	}
+	var rec bytes.Buffer
+	if err := WriteRef(&rec, off); err != nil {
+		return err
+	}
+	return appendRecord(logPath, rec.Bytes())
+}
+
+// WriteRef writes a reference record to the op at offset off of a store
+func WriteRef(w io.Writer, off int64) error {
+	var rec [9]byte
+	rec[0] = refFlag
+	binary.BigEndian.PutUint64(rec[1:], uint64(off))
+	_, err := w.Write(rec[:])
+	return err
+}
+
+// storeReader reads ops of a store by offset. Reading them in store order,
+// as a log's references mostly are, reads the file sequentially.
+type storeReader struct {
+	f     *os.File
+	r     *recordReader
+	start int64 // size of the header
+}
+
+func openStore(storePath string) (*storeReader, error) {
+	f, err := os.Open(storePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open op store: %w", err)
+	}
+	b := make([]byte, headerSize)
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, err
+	}
+	version, start, err := parseHeader(storePath, b[:n])
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// the reader's position is unknown until the first seek
+	r := newRecordReader(getReader(f), storePath, version, -1)
+	return &storeReader{f: f, r: r, start: start}, nil
+}
+
+// ReadAt decodes the op stored at offset off into op
+func (s *storeReader) ReadAt(off int64, op *crdt.Operation) error {
+	if err := s.r.seek(s.f, off); err != nil {
+		return err
+	}
+	_, isRef, err := s.r.next(op)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if isRef {
+		return &CorruptionError{Path: s.r.path, Offset: off, Reason: "reference record in op store"}
+	}
+	return nil
+}
+
+func (s *storeReader) Close() error {
+	if s == nil {
+		return nil
+	}
+	putReader(s.r.br)
+	return s.f.Close()
+}
+
+// ShareLog moves the inline ops of a stream op log into the shared store,
+// leaving references in their place, rewrites a log in an older format in the
+// current one and drops a partial record at its end.
+// It reports whether the log changed.
+func ShareLog(logPath string) (bool, error) {
+	version, hsize, err := readHeader(logPath)
+	if err != nil {
+		return false, err
+	}
+	f, err := OpenLog(logPath, hsize)
+	if err != nil {
+		return false, err
+	}
+	type record struct {
+		op  *crdt.Operation
+		ref int64
+	}
+	var recs []record
+	// logs in an older format are rewritten even without inline ops
+	rewrite := version < FormatVersion
+	br := getReader(f)
+	r := newRecordReader(br, logPath, version, hsize)
+	for {
+		op := new(crdt.Operation)
+		ref, isRef, err := r.next(op)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			putReader(br)
+			f.Close()
+			return false, err
+		}
+		if isRef {
+			op = nil
+		}
+		recs = append(recs, record{op, ref})
+		rewrite = rewrite || op != nil
+	}
+	putReader(br)
+	f.Close()
+	if !rewrite {
+		return false, nil
+	}
+
+	return true, ReplaceLog(logPath, func(w io.Writer) error {
+		lw := NewLogWriter(w)
+		for _, rec := range recs {
+			off := rec.ref
+			if rec.op != nil {
+				var err error
+				if off, err = Put(StorePath(logPath), *rec.op); err != nil {
+					return err
+				}
+			}
+			if err := lw.WriteRef(off); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
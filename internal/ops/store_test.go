@@ -0,0 +1,94 @@
+package ops
+
+import (
+	"evo/internal/crdt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	evo := filepath.Join(t.TempDir(), ".evo")
+	fid, nid := uuid.New(), uuid.New()
+	main := filepath.Join(evo, "ops", "main", fid.String()+".bin")
+	feature := filepath.Join(evo, "ops", "feature", fid.String()+".bin")
+	store := filepath.Join(evo, "opstore", fid.String()+".bin")
+	assert.Equal(t, store, StorePath(main))
+
+	a := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "one", Timestamp: time.Unix(10, 0)}
+	b := crdt.Operation{Type: crdt.OpInsert, Lamport: 2, NodeID: nid, FileID: fid, LineID: uuid.New(), OriginLineID: a.LineID, Content: "two"}
+
+	t.Run("Shared", func(t *testing.T) {
+		assert.NoError(t, AppendRef(main, a))
+		assert.NoError(t, AppendRef(main, b))
+		assert.NoError(t, AppendRef(feature, a))
+		fi, err := os.Stat(main)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(headerSize+2*(9+crcSize)), fi.Size())
+
+		stored, err := LoadAllOps(store)
+		assert.NoError(t, err)
+		assert.Len(t, stored, 2)
+		got, err := LoadAllOps(main)
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "one", got[0].Content)
+			assert.Equal(t, b.LineID, got[1].LineID)
+		}
+		got, err = LoadAllOps(feature)
+		assert.NoError(t, err)
+		assert.Len(t, got, 1)
+
+		_, err = ReadOp(mustOpen(t, main))
+		assert.Error(t, err)
+	})
+
+	t.Run("Partial_Store_Record", func(t *testing.T) {
+		f, err := os.OpenFile(store, os.O_APPEND|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		f.Write([]byte{0x80, 1, 2})
+		f.Close()
+		// a fresh index, as in another process
+		stores.index = make(map[string]*storeIndex)
+		c := b
+		c.Lamport, c.LineID, c.Content = 3, uuid.New(), "three"
+		assert.NoError(t, AppendRef(feature, c))
+		got, err := LoadAllOps(feature)
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "three", got[1].Content)
+		}
+	})
+
+	t.Run("Share_Inline_Log", func(t *testing.T) {
+		legacy := filepath.Join(evo, "ops", "old", fid.String()+".bin")
+		assert.NoError(t, AppendOp(legacy, a))
+		assert.NoError(t, AppendOp(legacy, b))
+		changed, err := ShareLog(legacy)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		got, err := LoadAllOps(legacy)
+		assert.NoError(t, err)
+		assert.Len(t, got, 2)
+		stored, err := LoadAllOps(store)
+		assert.NoError(t, err)
+		assert.Len(t, stored, 3)
+
+		changed, err = ShareLog(legacy)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+	})
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -0,0 +1,76 @@
+// Package platform hides what differs between operating systems: replacing a
+// file another process may have open, telling whether a process runs and
+// stopping it, terminals, and filesystems that ignore the case of names.
+// Everything else uses the filepath APIs, which keep to the platform's
+// separators; paths stored in .evo are always slash-separated.
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// Rename renames oldpath to newpath, replacing newpath if it exists. On
+// Windows, where a file open elsewhere (by a reader, a virus scanner or the
+// search indexer) can't be replaced, it retries for a while first.
+func Rename(oldpath, newpath string) error {
+	return rename(oldpath, newpath)
+}
+
+// Alive reports whether a process with the pid is running
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return alive(pid)
+}
+
+// Terminate asks the process with the pid to stop: SIGTERM on Unix, which
+// lets it clean up; on Windows, which has no such signal, it is killed.
+func Terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return terminate(p)
+}
+
+// Reflink creates dst as a copy-on-write clone of src, which shares its
+// storage until either is changed: FICLONE on Linux (Btrfs, XFS), clonefile on
+// macOS (APFS). It returns an error wrapping errors.ErrUnsupported where the
+// system has no such call; filesystems without the support fail too, and
+// callers copy instead. dst must not exist.
+func Reflink(src, dst string) error {
+	return reflink(src, dst)
+}
+
+// IsTerminal reports whether f is a terminal, the Windows console included
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// CaseInsensitive reports whether the filesystem holding path, which must
+// exist and have a letter in its name, ignores the case of names, as Windows
+// and macOS do by default. It looks path up under its name in swapped case.
+func CaseInsensitive(path string) bool {
+	name := filepath.Base(path)
+	swapped := strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, name)
+	if swapped == name {
+		return false
+	}
+	a, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(filepath.Join(filepath.Dir(path), swapped))
+	return err == nil && os.SameFile(a, b)
+}
@@ -0,0 +1,50 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRename(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "new"), filepath.Join(dir, "old")
+	require.NoError(t, os.WriteFile(src, []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(dst, []byte("old"), 0644))
+
+	// replacing a file that is open for reading
+	f, err := os.Open(dst)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Rename(src, dst))
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	assert.NoFileExists(t, src)
+
+	assert.Error(t, Rename(src, dst))
+}
+
+func TestAlive(t *testing.T) {
+	assert.True(t, Alive(os.Getpid()))
+	assert.False(t, Alive(0))
+	assert.False(t, Alive(-1))
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Probe")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	_, err := os.Stat(filepath.Join(dir, "pROBE"))
+	assert.Equal(t, err == nil, CaseInsensitive(path))
+
+	// a name without letters can't tell
+	digits := filepath.Join(dir, "123")
+	require.NoError(t, os.WriteFile(digits, nil, 0644))
+	assert.False(t, CaseInsensitive(digits))
+	assert.False(t, CaseInsensitive(filepath.Join(dir, "missing")))
+
+}
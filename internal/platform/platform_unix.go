@@ -0,0 +1,21 @@
+//go:build !windows
+
+package platform
+
+import (
+	"os"
+	"syscall"
+)
+
+func rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	return err == nil && p.Signal(syscall.Signal(0)) == nil
+}
+
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,46 @@
+//go:build windows
+
+package platform
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// renameTimeout is how long Rename retries a file held open elsewhere
+const renameTimeout = 2 * time.Second
+
+func rename(oldpath, newpath string) error {
+	deadline := time.Now().Add(renameTimeout)
+	for delay := time.Millisecond; ; delay *= 2 {
+		err := os.Rename(oldpath, newpath)
+		if err == nil || !errors.Is(err, windows.ERROR_ACCESS_DENIED) && !errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(min(delay, 100*time.Millisecond))
+	}
+}
+
+func alive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	// STILL_ACTIVE
+	return code == 259
+}
+
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
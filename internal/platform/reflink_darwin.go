@@ -0,0 +1,14 @@
+package platform
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func reflink(src, dst string) error {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
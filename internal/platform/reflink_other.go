@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package platform
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(src, dst string) error {
+	return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errors.ErrUnsupported}
+}
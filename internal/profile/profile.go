@@ -0,0 +1,161 @@
+// Package profile records where a command spends its time, for --profile: a
+// CPU and a heap profile from runtime/pprof, and the wall time spent in each
+// phase subsystems mark with Track. Everything is written to a local
+// directory and nothing is sent anywhere; the report names the command run
+// but not its arguments, which may hold messages or paths.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a kind of work timed by Track
+type Phase int
+
+const (
+	Walk Phase = iota // walking the working tree
+	Hash              // hashing file content
+	CRDT              // applying ops to documents
+	IO                // reading and writing op logs and files
+	numPhases
+)
+
+func (p Phase) String() string {
+	return [...]string{"walk", "hash", "crdt", "io"}[p]
+}
+
+var (
+	enabled atomic.Bool
+	elapsed [numPhases]atomic.Int64
+	calls   [numPhases]atomic.Int64
+)
+
+func noop() {}
+
+// Track starts timing phase p and returns the function that ends it. It
+// costs nothing while no session runs. Phases running at once on several
+// goroutines each count their full time.
+func Track(p Phase) func() {
+	if !enabled.Load() {
+		return noop
+	}
+	start := time.Now()
+	return func() {
+		elapsed[p].Add(int64(time.Since(start)))
+		calls[p].Add(1)
+	}
+}
+
+// Session is a running profile
+type Session struct {
+	dir     string
+	command string
+	start   time.Time
+	cpu     *os.File
+}
+
+// Start begins profiling command into dir, which is created if needed
+func Start(dir, command string) (*Session, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cpu, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	for p := range numPhases {
+		elapsed[p].Store(0)
+		calls[p].Store(0)
+	}
+	enabled.Store(true)
+	return &Session{dir: dir, command: command, start: time.Now(), cpu: cpu}, nil
+}
+
+// PhaseTime is the time spent in one phase
+type PhaseTime struct {
+	Phase   string  `json:"phase"`
+	Calls   int64   `json:"calls"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Report is the timing breakdown of a session, written as timings.json
+type Report struct {
+	Command   string      `json:"command"`
+	Start     time.Time   `json:"start"`
+	Seconds   float64     `json:"seconds"`
+	Phases    []PhaseTime `json:"phases"`
+	GoVersion string      `json:"goVersion"`
+	OS        string      `json:"os"`
+	Arch      string      `json:"arch"`
+	CPUs      int         `json:"cpus"`
+	Files     []string    `json:"files"`
+}
+
+// Stop ends the session and writes cpu.pprof, heap.pprof and timings.json
+func (s *Session) Stop() (*Report, error) {
+	enabled.Store(false)
+	total := time.Since(s.start)
+	pprof.StopCPUProfile()
+	if err := s.cpu.Close(); err != nil {
+		return nil, err
+	}
+	r := &Report{
+		Command:   s.command,
+		Start:     s.start,
+		Seconds:   total.Seconds(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Files:     []string{"cpu.pprof", "heap.pprof", "timings.json"},
+	}
+	for p := range numPhases {
+		r.Phases = append(r.Phases, PhaseTime{Phase: p.String(), Calls: calls[p].Load(), Seconds: time.Duration(elapsed[p].Load()).Seconds()})
+	}
+
+	heap, err := os.Create(filepath.Join(s.dir, "heap.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	defer heap.Close()
+	runtime.GC() // up-to-date statistics of live objects
+	if err := pprof.WriteHeapProfile(heap); err != nil {
+		return nil, fmt.Errorf("failed to write heap profile: %w", err)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "timings.json"), append(data, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Dir returns the directory the session writes to
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// Summary returns the report on one line, e.g. "1.20s: walk 10ms, hash 3ms"
+func (r *Report) Summary() string {
+	parts := make([]string, 0, len(r.Phases))
+	for _, p := range r.Phases {
+		d := time.Duration(p.Seconds * float64(time.Second)).Round(time.Microsecond)
+		parts = append(parts, fmt.Sprintf("%s %s", p.Phase, d))
+	}
+	total := time.Duration(r.Seconds * float64(time.Second)).Round(time.Microsecond)
+	return fmt.Sprintf("%s: %s", total, strings.Join(parts, ", "))
+}
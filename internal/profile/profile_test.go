@@ -0,0 +1,48 @@
+package profile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession(t *testing.T) {
+	Track(Hash)() // not recorded without a session
+
+	dir := filepath.Join(t.TempDir(), "prof")
+	s, err := Start(dir, "evo commit")
+	require.NoError(t, err)
+	done := Track(Walk)
+	time.Sleep(2 * time.Millisecond)
+	done()
+	Track(IO)()
+	Track(IO)()
+	r, err := s.Stop()
+	require.NoError(t, err)
+	Track(IO)()
+
+	for _, f := range r.Files {
+		fi, err := os.Stat(filepath.Join(dir, f))
+		require.NoError(t, err, f)
+		assert.NotZero(t, fi.Size(), f)
+	}
+	calls := make(map[string]int64)
+	for _, p := range r.Phases {
+		calls[p.Phase] = p.Calls
+	}
+	assert.Equal(t, map[string]int64{"walk": 1, "hash": 0, "crdt": 0, "io": 2}, calls)
+	assert.GreaterOrEqual(t, r.Phases[Walk].Seconds, 0.002)
+	assert.Contains(t, r.Summary(), "walk 2")
+
+	data, err := os.ReadFile(filepath.Join(dir, "timings.json"))
+	require.NoError(t, err)
+	var saved Report
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, "evo commit", saved.Command)
+	assert.Len(t, saved.Phases, 4)
+}
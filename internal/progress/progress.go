@@ -0,0 +1,125 @@
+// Package progress lets long operations tell how far they have got: ingest,
+// clone, push and pull, garbage collection and repack. They take a Reporter
+// from the context they are given, so the CLI can draw progress bars and
+// embedders can show progress in their own UIs; given none, they report to
+// nobody.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter is told of the phases of an operation and the units of work done
+// in each: files, commits, op logs or chunks. Operations may call Add from
+// several goroutines at once.
+type Reporter interface {
+	// Start begins a phase, such as "Ingesting", of total units, or of an
+	// unknown number if total is negative
+	Start(phase string, total int64)
+	// Add counts n more units of the phase done
+	Add(n int64)
+	// Done ends the phase
+	Done()
+}
+
+type key struct{}
+
+// With returns a context whose operations report to r
+func With(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, key{}, r)
+}
+
+// Discard is a reporter that discards everything
+var Discard Reporter = nop{}
+
+// From returns the reporter of ctx, or Discard
+func From(ctx context.Context) Reporter {
+	if r, ok := ctx.Value(key{}).(Reporter); ok && r != nil {
+		return r
+	}
+	return Discard
+}
+
+type nop struct{}
+
+func (nop) Start(string, int64) {}
+func (nop) Add(int64)           {}
+func (nop) Done()               {}
+
+// redraw is how often a bar is drawn again at most
+const redraw = 100 * time.Millisecond
+
+// barWidth is the width of the bar itself, in cells
+const barWidth = 30
+
+// Bar draws each phase as a bar on one line of a terminal, redrawn in place,
+// or as a count when the phase's total is unknown
+type Bar struct {
+	w     io.Writer
+	mu    sync.Mutex
+	phase string
+	total int64
+	done  int64
+	drawn time.Time
+	width int // of the last line drawn, to blank what a shorter one leaves
+}
+
+// NewBar returns a reporter drawing to w, a terminal
+func NewBar(w io.Writer) *Bar {
+	return &Bar{w: w}
+}
+
+// Start begins a phase, drawing its empty bar
+func (b *Bar) Start(phase string, total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.phase, b.total, b.done, b.width = phase, total, 0, 0
+	b.draw()
+}
+
+// Add counts units done, redrawing the bar now and then
+func (b *Bar) Add(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done += n
+	if time.Since(b.drawn) >= redraw {
+		b.draw()
+	}
+}
+
+// Done draws the phase as it ended and moves to the next line
+func (b *Bar) Done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.phase == "" {
+		return
+	}
+	b.draw()
+	fmt.Fprintln(b.w)
+	b.phase = ""
+}
+
+func (b *Bar) draw() {
+	var line string
+	if b.total < 0 {
+		line = fmt.Sprintf("%s %d", b.phase, b.done)
+	} else {
+		done := min(b.done, b.total)
+		fill, pct := barWidth, 100
+		if b.total > 0 {
+			fill = int(done * barWidth / b.total)
+			pct = int(done * 100 / b.total)
+		}
+		line = fmt.Sprintf("%s [%s%s] %3d%% %d/%d", b.phase,
+			strings.Repeat("=", fill), strings.Repeat(" ", barWidth-fill), pct, done, b.total)
+	}
+	pad := max(b.width-len(line), 0)
+	fmt.Fprintf(b.w, "\r%s%s", line, strings.Repeat(" ", pad))
+	b.width = len(line)
+	b.drawn = time.Now()
+}
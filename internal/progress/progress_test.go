@@ -0,0 +1,77 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder remembers what it was told
+type recorder struct {
+	mu     sync.Mutex
+	phases []string
+	totals []int64
+	added  int64
+	done   int
+}
+
+func (r *recorder) Start(phase string, total int64) {
+	r.phases = append(r.phases, phase)
+	r.totals = append(r.totals, total)
+}
+
+func (r *recorder) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.added += n
+}
+
+func (r *recorder) Done() { r.done++ }
+
+func TestFrom(t *testing.T) {
+	assert.Equal(t, Discard, From(context.Background()))
+
+	rec := &recorder{}
+	r := From(With(context.Background(), rec))
+	r.Start("Ingesting", 2)
+	r.Add(2)
+	r.Done()
+	assert.Equal(t, []string{"Ingesting"}, rec.phases)
+	assert.Equal(t, int64(2), rec.added)
+	assert.Equal(t, 1, rec.done)
+}
+
+func TestBar(t *testing.T) {
+	var buf bytes.Buffer
+	b := NewBar(&buf)
+
+	b.Start("Repacking", 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Add(1)
+		}()
+	}
+	wg.Wait()
+	b.Done()
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "\rRepacking ["+strings.Repeat(" ", barWidth)+"]   0% 0/4"), out)
+	assert.True(t, strings.HasSuffix(out, "\rRepacking ["+strings.Repeat("=", barWidth)+"] 100% 4/4\n"), out)
+
+	buf.Reset()
+	b.Start("Copying", -1)
+	b.Add(12)
+	b.Done()
+	assert.True(t, strings.HasSuffix(buf.String(), "\rCopying 12\n"), buf.String())
+
+	// a phase already done isn't drawn again
+	buf.Reset()
+	b.Done()
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,325 @@
+// Package prompt answers, fast enough to run on every shell prompt, which
+// stream is checked out, how far it is ahead of and behind its upstream and
+// whether the working tree holds uncommitted changes.
+//
+// Both answers are cached under .evo so that a prompt in an unchanged
+// repository reads a few small files and stats the working tree: the counts
+// by package tracking, and the hashes of the working tree in
+//
+//	.evo/stat    "<size> <mtime ns> <sha256> <path>" for each file last hashed
+package prompt
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"evo/internal/ignore"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/merge"
+	"evo/internal/ops"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/tracking"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = log.For("prompt")
+
+// Info is what a prompt shows
+type Info struct {
+	Stream   string `json:"stream"`
+	Upstream string `json:"upstream,omitempty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+	Dirty    bool   `json:"dirty"`
+}
+
+// String formats the info compactly, e.g. "main ↑2 ↓1 *"
+func (i *Info) String() string {
+	var b strings.Builder
+	b.WriteString(i.Stream)
+	if i.Ahead > 0 {
+		fmt.Fprintf(&b, " ↑%d", i.Ahead)
+	}
+	if i.Behind > 0 {
+		fmt.Fprintf(&b, " ↓%d", i.Behind)
+	}
+	if i.Dirty {
+		b.WriteString(" *")
+	}
+	return b.String()
+}
+
+// Get returns the prompt info of a repository. The upstream of a stream is
+// the one named by its upstream config key.
+func Get(repoPath string) (*Info, error) {
+	stream, err := streams.CurrentStream(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current stream: %w", err)
+	}
+	info := &Info{Stream: stream}
+	up, err := tracking.Upstream(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	if up != "" {
+		info.Upstream = up
+		if info.Ahead, info.Behind, err = tracking.Compare(repoPath, stream, up); err != nil {
+			return nil, err
+		}
+	}
+	if repo.IsBare(repoPath) {
+		return info, nil
+	}
+	if info.Dirty, err = Dirty(repoPath, stream); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+const workers = 8
+
+// errDirty stops the walk of the working tree at the first change
+var errDirty = errors.New("dirty")
+
+// Dirty reports whether a commit to stream would record anything: a file
+// that isn't tracked and isn't ignored, a tracked file deleted, or one whose
+// content or op log differs from when it was last in sync with the stream.
+// Content is hashed only for files whose size or mtime changed since the
+// last call.
+func Dirty(repoPath, stream string) (bool, error) {
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load index: %w", err)
+	}
+	hashes, err := index.LoadHashes(repoPath, stream)
+	if err != nil {
+		return false, fmt.Errorf("failed to load file hashes: %w", err)
+	}
+	ignored, err := ignore.LoadIgnoreFile(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load ignore file: %w", err)
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load attributes: %w", err)
+	}
+	untracked, err := index.LoadUntracked(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load untracked paths: %w", err)
+	}
+	cache := loadStatCache(repoPath)
+	defer cache.save()
+
+	seen := make(map[string]bool)
+	var files []string
+	err = filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(repoPath, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, ".evo") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, tracked := p2id[rel]; !tracked {
+			if untracked[rel] || ignored.IsIgnored(rel) {
+				return nil
+			}
+			return errDirty
+		}
+		seen[rel] = true
+		files = append(files, rel)
+		return nil
+	})
+	if err == errDirty {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to scan working tree: %w", err)
+	}
+
+	// the files are stat'ed and hashed in parallel, stopping at the first change
+	work := make(chan string)
+	errs := make(chan error, workers)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a worker keeps draining after a failure so the sender never blocks
+			for rel := range work {
+				if failed.Load() {
+					continue
+				}
+				if err := cache.check(repoPath, stream, rel, p2id[rel], hashes, attrs); err != nil {
+					failed.Store(true)
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, rel := range files {
+		if failed.Load() {
+			break
+		}
+		work <- rel
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err == errDirty {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to scan working tree: %w", err)
+	}
+	cache.keep(seen)
+	for rel, fid := range p2id {
+		// a tracked file the stream still holds was deleted
+		if _, ok := hashes[fid]; ok && !seen[rel] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// check returns errDirty if a tracked file differs from when it was last in
+// sync with the stream
+func (c *statCache) check(repoPath, stream, rel, fid string, hashes map[string]index.Hash, attrs *merge.Attributes) error {
+	last, ok := hashes[fid]
+	if !ok || ops.LogSize(filepath.Join(repo.Dir(repoPath), "ops", stream, fid+".bin")) != last.LogSize {
+		return errDirty
+	}
+	fi, err := os.Stat(filepath.Join(repoPath, rel))
+	if err != nil {
+		return err
+	}
+	sum, err := c.sum(filepath.Join(repoPath, rel), rel, fi)
+	if err != nil {
+		return err
+	}
+	if index.GranularSum(sum, string(attrs.GranularityFor(rel))) != last.Sum {
+		return errDirty
+	}
+	return nil
+}
+
+type statEntry struct {
+	size  int64
+	mtime int64
+	sum   string
+}
+
+// statCache remembers the hash of each file by its size and mtime
+type statCache struct {
+	path    string
+	entries map[string]statEntry
+	mu      sync.Mutex
+	changed bool
+	started time.Time
+}
+
+func loadStatCache(repoPath string) *statCache {
+	c := &statCache{
+		path:    filepath.Join(repo.Dir(repoPath), "stat"),
+		entries: make(map[string]statEntry),
+		started: time.Now(),
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		parts := strings.SplitN(sc.Text(), " ", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		size, err1 := strconv.ParseInt(parts[0], 10, 64)
+		mtime, err2 := strconv.ParseInt(parts[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		c.entries[parts[3]] = statEntry{size: size, mtime: mtime, sum: parts[2]}
+	}
+	return c
+}
+
+// sum returns the content hash of a file, hashing it only if it changed
+func (c *statCache) sum(path, rel string, fi fs.FileInfo) (string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[rel]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.mtime == fi.ModTime().UnixNano() {
+		return e.sum, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	// a file modified within the mtime resolution of this scan could change
+	// again without its mtime moving, so its hash is not trusted later
+	if fi.ModTime().Before(c.started.Add(-time.Second)) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.entries[rel] = statEntry{size: fi.Size(), mtime: fi.ModTime().UnixNano(), sum: sum}
+		c.changed = true
+	}
+	return sum, nil
+}
+
+// keep forgets the files not in paths
+func (c *statCache) keep(paths map[string]bool) {
+	for rel := range c.entries {
+		if !paths[rel] {
+			delete(c.entries, rel)
+			c.changed = true
+		}
+	}
+}
+
+func (c *statCache) save() {
+	if !c.changed {
+		return
+	}
+	var b strings.Builder
+	for rel, e := range c.entries {
+		fmt.Fprintf(&b, "%d %d %s %s\n", e.size, e.mtime, e.sum, rel)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		logger.Warn("failed to save stat cache", "err", err)
+		return
+	}
+	if err := platform.Rename(tmp, c.path); err != nil {
+		logger.Warn("failed to save stat cache", "err", err)
+	}
+}
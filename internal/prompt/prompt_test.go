@@ -0,0 +1,92 @@
+package prompt
+
+import (
+	"context"
+	"evo/internal/config"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// age moves a file's mtime back so the stat cache trusts it
+func age(t *testing.T, path string) {
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+}
+
+func TestGet(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	path := filepath.Join(rp, "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("one\n"), 0644))
+	age(t, path)
+
+	info, err := Get(rp)
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Stream: "main", Dirty: true}, info, "untracked file")
+
+	testutil.Commit(t, rp, "main", "first")
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.False(t, info.Dirty)
+	assert.Equal(t, "main", info.String())
+	_, err = os.Stat(filepath.Join(rp, ".evo", "stat"))
+	assert.NoError(t, err, "hash is cached")
+
+	// same size, new mtime
+	require.NoError(t, os.WriteFile(path, []byte("two\n"), 0644))
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.True(t, info.Dirty)
+
+	require.NoError(t, os.WriteFile(path, []byte("one\n"), 0644))
+	age(t, path)
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.False(t, info.Dirty)
+
+	// ignored files don't count, deleted ones do
+	require.NoError(t, os.WriteFile(filepath.Join(rp, ".evo-ignore"), []byte("*.log\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "x.log"), []byte("x"), 0644))
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.False(t, info.Dirty)
+	require.NoError(t, os.Remove(path))
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.True(t, info.Dirty)
+	require.NoError(t, os.WriteFile(path, []byte("one\n"), 0644))
+
+	// a feature stream one commit ahead of main, which is two ahead of it
+	require.NoError(t, streams.CreateStream(rp, "feature"))
+	testutil.Commit(t, rp, "main", "second")
+	require.NoError(t, os.WriteFile(path, []byte("three\n"), 0644))
+	testutil.Commit(t, rp, "main", "third")
+	require.NoError(t, os.WriteFile(path, []byte("four\n"), 0644))
+	testutil.Commit(t, rp, "feature", "feature")
+	require.NoError(t, streams.SwitchStream(rp, "feature"))
+	require.NoError(t, config.Set(rp, config.ScopeRepo, "stream.feature.upstream", "main"))
+
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.Equal(t, "main", info.Upstream)
+	assert.Equal(t, 1, info.Ahead)
+	assert.Equal(t, 3, info.Behind)
+	assert.Equal(t, "feature ↑1 ↓3", info.String())
+
+	// counts come from the cache until a stream moves
+	data, err := os.ReadFile(filepath.Join(rp, ".evo", "tracking", "counts"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "feature main")
+	require.NoError(t, streams.MergeStreams(context.Background(), rp, "main", "feature"))
+	info, err = Get(rp)
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.Behind)
+}
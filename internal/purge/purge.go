@@ -0,0 +1,518 @@
+// Package purge rewrites history to take content out of it for good, as
+// when a secret was committed: files are dropped from every stream with
+// their ops and large-file content, or text matching a pattern is redacted
+// from every op. Commits whose ops change get new IDs, and the references to
+// them in other commits, streams, tags, notes and remote-tracking records
+// follow. Other clones keep the old history until they are cloned again.
+package purge
+
+import (
+	"context"
+	"evo/internal/blobs"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/journal"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/maintenance"
+	"evo/internal/materialize"
+	"evo/internal/notes"
+	"evo/internal/ops"
+	"evo/internal/prompt"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"evo/internal/tracking"
+	"evo/internal/types"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("purge")
+
+// pauseTimeout is how long a purge waits for a maintenance run to finish
+const pauseTimeout = 10 * time.Minute
+
+// ErrDirty is returned when purging would rewrite files with uncommitted changes
+var ErrDirty = everrors.New(everrors.ErrConflict, "the working tree has uncommitted changes; commit or restore them first")
+
+// Options select what Purge takes out of history
+type Options struct {
+	Paths   []string       // files to drop: tracked paths, directories or file IDs
+	Pattern *regexp.Regexp // text to redact from every line
+	Replace string         // what redacted text becomes
+	DryRun  bool           // only report what would change
+}
+
+// File is a file dropped from history
+type File struct {
+	ID   string `json:"id"`
+	Path string `json:"path,omitempty"` // empty when no longer tracked
+}
+
+// Result reports what a purge changed
+type Result struct {
+	Files    []File            `json:"files"`
+	Redacted int               `json:"redacted"` // ops whose content was redacted
+	Commits  map[string]string `json:"commits"`  // old commit ID => new
+	DryRun   bool              `json:"dryRun,omitempty"`
+}
+
+// purger holds what a purge takes out
+type purger struct {
+	drop    map[string]bool // file IDs
+	pattern *regexp.Regexp
+	replace string
+	ids     map[string]string // rewritten commits, old ID => new
+}
+
+func (p *purger) redact(s string) (string, bool) {
+	if p.pattern == nil || !p.pattern.MatchString(s) {
+		return s, false
+	}
+	return p.pattern.ReplaceAllString(s, p.replace), true
+}
+
+// rewrite drops and redacts the ops of a commit, reporting whether any changed
+func (p *purger) rewrite(c *types.Commit) bool {
+	changed := false
+	kept := make([]types.ExtendedOp, 0, len(c.Operations))
+	for _, eop := range c.Operations {
+		if p.drop[eop.Op.FileID.String()] {
+			changed = true
+			continue
+		}
+		var r1, r2 bool
+		eop.Op.Content, r1 = p.redact(eop.Op.Content)
+		eop.OldContent, r2 = p.redact(eop.OldContent)
+		changed = changed || r1 || r2
+		kept = append(kept, eop)
+	}
+	c.Operations = kept
+	return changed
+}
+
+// rename maps a commit ID to its rewritten one
+func (p *purger) rename(id string) string {
+	if n, ok := p.ids[id]; ok {
+		return n
+	}
+	return id
+}
+
+// Purge drops the files named by opts.Paths and redacts the text matching
+// opts.Pattern from every stream's op logs and commits, and from the working
+// tree, which must have no uncommitted changes. Dropped files that are still
+// in the working tree stay there, untracked. The undo journal, which may
+// hold the removed content, is cleared.
+func Purge(repoPath string, opts Options) (*Result, error) {
+	if len(opts.Paths) == 0 && opts.Pattern == nil {
+		return nil, fmt.Errorf("nothing to purge: give paths or a pattern")
+	}
+	bare := repo.IsBare(repoPath)
+	var head repo.Head
+	if !bare {
+		var err error
+		if head, err = repo.ReadHead(repoPath); err != nil {
+			return nil, err
+		}
+		if head.Detached != "" {
+			return nil, fmt.Errorf("HEAD is detached at %s; run 'evo checkout %s' first", head.Detached, head.Stream)
+		}
+		if !opts.DryRun {
+			dirty, err := prompt.Dirty(repoPath, head.Stream)
+			if err != nil {
+				return nil, err
+			}
+			if dirty {
+				return nil, ErrDirty
+			}
+		}
+	}
+	if !opts.DryRun {
+		resume, err := maintenance.Pause(repoPath, pauseTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer resume()
+	}
+
+	logs, err := ops.AllLogs(filepath.Join(repo.Dir(repoPath), "ops"))
+	if err != nil {
+		return nil, err
+	}
+	byFile := make(map[string][]string)
+	for _, path := range logs {
+		fid := strings.TrimSuffix(filepath.Base(path), ".bin")
+		byFile[fid] = append(byFile[fid], path)
+	}
+	files, err := resolve(repoPath, opts.Paths, byFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &purger{drop: make(map[string]bool), pattern: opts.Pattern, replace: opts.Replace, ids: make(map[string]string)}
+	for _, f := range files {
+		p.drop[f.ID] = true
+	}
+	res := &Result{Files: files, Commits: p.ids, DryRun: opts.DryRun}
+
+	// files holding text to redact, and how many ops have it
+	redacted := make(map[string]bool)
+	if p.pattern != nil {
+		seen := make(map[string]bool)
+		for fid, paths := range byFile {
+			if p.drop[fid] {
+				continue
+			}
+			for _, path := range paths {
+				all, err := ops.LoadAllOps(path)
+				if err != nil {
+					return nil, err
+				}
+				for _, op := range all {
+					key := fmt.Sprintf("%s %d %s", op.NodeID, op.Lamport, op.LineID)
+					if _, ok := p.redact(op.Content); ok && !seen[key] {
+						seen[key] = true
+						redacted[fid] = true
+					}
+				}
+			}
+		}
+		res.Redacted = len(seen)
+	}
+
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := commits.ForEachCommit(repoPath, name, commits.IterOptions{}, func(c *types.Commit) error {
+			if _, ok := p.ids[c.ID]; !ok && p.rewrite(c) {
+				p.ids[c.ID] = uuid.New().String()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commits of %s: %w", name, err)
+		}
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+
+	for fid, paths := range byFile {
+		switch {
+		case p.drop[fid]:
+			for _, path := range paths {
+				if err := ops.RemoveLog(path); err != nil {
+					return nil, err
+				}
+			}
+			if err := removeStore(paths[0]); err != nil {
+				return nil, err
+			}
+		case redacted[fid]:
+			if err := p.redactLogs(paths); err != nil {
+				return nil, fmt.Errorf("failed to redact ops of %s: %w", fid, err)
+			}
+		}
+	}
+	for _, name := range names {
+		if err := p.rewriteCommits(repoPath, name); err != nil {
+			return nil, fmt.Errorf("failed to rewrite commits of %s: %w", name, err)
+		}
+	}
+	if err := p.rewriteRefs(repoPath, names); err != nil {
+		return nil, err
+	}
+
+	store := lfs.NewStore(repoPath)
+	for fid := range p.drop {
+		if _, err := store.Info(fid); err == nil {
+			if err := store.DeleteFile(fid); err != nil {
+				return nil, fmt.Errorf("failed to delete large file %s: %w", fid, err)
+			}
+		}
+	}
+	for _, name := range names {
+		hashes, err := index.LoadHashes(repoPath, name)
+		if err != nil {
+			return nil, err
+		}
+		for fid := range hashes {
+			if p.drop[fid] || redacted[fid] {
+				delete(hashes, fid)
+			}
+		}
+		if err := index.SaveHashes(repoPath, name, hashes); err != nil {
+			return nil, err
+		}
+	}
+	// stored contents of the dropped files go with their hashes
+	if _, err := blobs.Prune(context.Background(), repoPath); err != nil {
+		return nil, err
+	}
+	encs, err := index.LoadEncodings(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for fid := range p.drop {
+		delete(encs, fid)
+	}
+	if err := index.SaveEncodings(repoPath, encs); err != nil {
+		return nil, err
+	}
+	if !bare {
+		if err := p.updateWorkingTree(repoPath, head.Stream, redacted); err != nil {
+			return nil, err
+		}
+	}
+	if err := journal.Clear(repoPath); err != nil {
+		return nil, fmt.Errorf("failed to clear the journal: %w", err)
+	}
+	logger.Info("purged history", "files", len(files), "redacted", res.Redacted, "commits", len(p.ids))
+	return res, nil
+}
+
+// resolve finds the files named by specs: tracked paths, directories of
+// them, or the IDs of files with op logs
+func resolve(repoPath string, specs []string, byFile map[string][]string) ([]File, error) {
+	p2id, id2p, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, spec := range specs {
+		if spec == "." || spec == "" {
+			return nil, fmt.Errorf("refusing to purge the whole repository")
+		}
+		if _, err := uuid.Parse(spec); err == nil {
+			if _, ok := byFile[spec]; ok {
+				found[spec] = id2p[spec]
+				continue
+			}
+		}
+		matched := false
+		for path, fid := range p2id {
+			if path == spec || strings.HasPrefix(path, strings.TrimSuffix(spec, "/")+"/") {
+				found[fid] = path
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%s did not match any tracked file or file ID", spec)
+		}
+	}
+	out := make([]File, 0, len(found))
+	for fid, path := range found {
+		out = append(out, File{ID: fid, Path: path})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// removeStore deletes the shared op store of the file of an op log
+func removeStore(logPath string) error {
+	if err := os.Remove(ops.StorePath(logPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// redactLogs rewrites every op log of one file with redacted content. The
+// logs share the file's op store, which is rebuilt from their ops alone so
+// no copy of the original text stays behind.
+func (p *purger) redactLogs(paths []string) error {
+	loaded := make([][]crdt.Operation, len(paths))
+	for i, path := range paths {
+		all, err := ops.LoadAllOps(path)
+		if err != nil {
+			return err
+		}
+		for j := range all {
+			all[j].Content, _ = p.redact(all[j].Content)
+		}
+		loaded[i] = all
+	}
+	if err := removeStore(paths[0]); err != nil {
+		return err
+	}
+	for i, path := range paths {
+		err := ops.ReplaceLog(path, func(w io.Writer) error {
+			lw := ops.NewLogWriter(w)
+			for _, op := range loaded[i] {
+				off, err := ops.Put(ops.StorePath(path), op)
+				if err != nil {
+					return err
+				}
+				if err := lw.WriteRef(off); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteCommits saves the rewritten commits of a stream under their new
+// IDs, unsigned as their signatures covered the old ones, and updates the
+// picks and baselines of the others
+func (p *purger) rewriteCommits(repoPath, stream string) error {
+	type write struct {
+		old string
+		c   types.Commit
+	}
+	var writes []write
+	err := commits.ForEachCommit(repoPath, stream, commits.IterOptions{}, func(c *types.Commit) error {
+		cc := *c
+		changed := p.rewrite(&cc)
+		if cc.PickedFrom != "" {
+			cc.PickedFrom = p.rename(cc.PickedFrom)
+			changed = changed || cc.PickedFrom != c.PickedFrom
+		}
+		if len(cc.Squashed) > 0 {
+			cc.Squashed = make([]string, len(c.Squashed))
+			for i, id := range c.Squashed {
+				cc.Squashed[i] = p.rename(id)
+				changed = changed || cc.Squashed[i] != id
+			}
+		}
+		if id, ok := p.ids[c.ID]; ok {
+			cc.ID, cc.Signature = id, ""
+			changed = true
+		}
+		if changed {
+			writes = append(writes, write{c.ID, cc})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range writes {
+		if err := commits.SaveCommitTo(repoPath, stream, &w.c); err != nil {
+			return err
+		}
+		if w.c.ID != w.old {
+			if err := commits.RemoveCommit(repoPath, stream, w.old); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteRefs points whatever named a rewritten commit at its new ID
+func (p *purger) rewriteRefs(repoPath string, names []string) error {
+	if len(p.ids) == 0 {
+		return nil
+	}
+	tags, err := revparse.Tags(repoPath)
+	if err != nil {
+		return err
+	}
+	for name, id := range tags {
+		if n, ok := p.ids[id]; ok {
+			if err := revparse.WriteTag(repoPath, name, n); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range names {
+		o, err := streams.StreamOrigin(repoPath, name)
+		if err != nil {
+			return err
+		}
+		if o != nil && o.Commit != "" && p.rename(o.Commit) != o.Commit {
+			o.Commit = p.rename(o.Commit)
+			if err := streams.SetOrigin(repoPath, name, *o); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tracking.RenameCommits(repoPath, p.ids); err != nil {
+		return fmt.Errorf("failed to update remote-tracking records: %w", err)
+	}
+	if err := notes.RenameCommits(repoPath, p.ids); err != nil {
+		return fmt.Errorf("failed to move notes: %w", err)
+	}
+	return nil
+}
+
+// updateWorkingTree stops tracking dropped files, which stay on disk as
+// untracked files, rewrites redacted ones and purges staged ops
+func (p *purger) updateWorkingTree(repoPath, stream string, redacted map[string]bool) error {
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return err
+	}
+	var untrack []string
+	for path, fid := range p2id {
+		if !p.drop[fid] {
+			continue
+		}
+		delete(p2id, path)
+		if _, err := os.Stat(filepath.Join(repoPath, filepath.FromSlash(path))); err == nil {
+			untrack = append(untrack, path)
+		}
+	}
+	if err := index.SaveIndex(repoPath, p2id); err != nil {
+		return err
+	}
+	dropped := make([]string, 0, len(p.drop))
+	for fid := range p.drop {
+		dropped = append(dropped, fid)
+	}
+	if err := index.ForgetPaths(repoPath, dropped...); err != nil {
+		return err
+	}
+	if err := index.Untrack(repoPath, untrack...); err != nil {
+		return err
+	}
+	for _, fid := range p2id {
+		if redacted[fid] {
+			if err := materialize.WriteFile(repoPath, stream, fid); err != nil {
+				return err
+			}
+		}
+	}
+
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		staged, err := commits.LoadStaged(repoPath, name)
+		if err != nil || len(staged) == 0 {
+			return err
+		}
+		c := types.Commit{Operations: staged}
+		if !p.rewrite(&c) {
+			continue
+		}
+		if err := commits.ClearStaged(repoPath, name); err != nil {
+			return err
+		}
+		if len(c.Operations) > 0 {
+			if err := commits.StageOps(repoPath, name, c.Operations); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package purge
+
+import (
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/materialize"
+	"evo/internal/notes"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/revparse"
+	"evo/internal/testutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func write(t *testing.T, rp, rel, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(rp, rel), []byte(content), 0644))
+}
+
+// grepEvo reports whether any file under .evo holds s
+func grepEvo(t *testing.T, rp, s string) bool {
+	found := false
+	err := filepath.Walk(filepath.Join(rp, repo.EvoDir), func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		found = found || strings.Contains(string(data), s)
+		return nil
+	})
+	require.NoError(t, err)
+	return found
+}
+
+func TestPurge(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	write(t, rp, "a.txt", "hello\ntoken=hunter2\n")
+	write(t, rp, "key.pem", "PRIVATE KEY\n")
+	first := testutil.Commit(t, rp, "main", "first")
+	write(t, rp, "b.txt", "plain\n")
+	second := testutil.Commit(t, rp, "main", "second")
+	require.NoError(t, revparse.WriteTag(rp, "v1", first.ID))
+	_, err := notes.Add(rp, notes.DefaultNamespace, first.ID, "ann", "ann@example.com", "reviewed")
+	require.NoError(t, err)
+	p2id, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	keyID := p2id["key.pem"]
+
+	_, err = Purge(rp, Options{})
+	assert.ErrorContains(t, err, "nothing to purge")
+	_, err = Purge(rp, Options{Paths: []string{"nope"}})
+	assert.ErrorContains(t, err, "nope did not match")
+	write(t, rp, "b.txt", "edited\n")
+	_, err = Purge(rp, Options{Paths: []string{"key.pem"}})
+	assert.ErrorIs(t, err, ErrDirty)
+	write(t, rp, "b.txt", "plain\n")
+
+	opts := Options{Paths: []string{"key.pem"}, Pattern: regexp.MustCompile(`hunter\d`), Replace: "***", DryRun: true}
+	res, err := Purge(rp, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []File{{ID: keyID, Path: "key.pem"}}, res.Files)
+	assert.Equal(t, 1, res.Redacted)
+	assert.Contains(t, res.Commits, first.ID)
+	assert.NotContains(t, res.Commits, second.ID)
+	assert.True(t, grepEvo(t, rp, "hunter2"))
+
+	opts.DryRun = false
+	res, err = Purge(rp, opts)
+	require.NoError(t, err)
+	newID := res.Commits[first.ID]
+	require.NotEmpty(t, newID)
+	assert.False(t, grepEvo(t, rp, "hunter2"))
+	assert.False(t, grepEvo(t, rp, "PRIVATE KEY"))
+
+	// the working tree keeps the dropped file, untracked, and is redacted
+	data, err := os.ReadFile(filepath.Join(rp, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\ntoken=***\n", string(data))
+	assert.FileExists(t, filepath.Join(rp, "key.pem"))
+	p2id, _, err = index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.NotContains(t, p2id, "key.pem")
+	untracked, err := index.LoadUntracked(rp)
+	require.NoError(t, err)
+	assert.True(t, untracked["key.pem"])
+	logs, err := ops.AllLogs(filepath.Join(rp, repo.EvoDir, "ops"))
+	require.NoError(t, err)
+	for _, l := range logs {
+		assert.NotContains(t, l, keyID)
+	}
+
+	// commits and what points at them follow the new IDs
+	all, err := commits.ListCommits(rp, "main")
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	ids := []string{all[0].ID, all[1].ID}
+	assert.ElementsMatch(t, []string{newID, second.ID}, ids)
+	for _, c := range all {
+		for _, eop := range c.Operations {
+			assert.NotEqual(t, keyID, eop.Op.FileID.String())
+		}
+	}
+	tags, err := revparse.Tags(rp)
+	require.NoError(t, err)
+	assert.Equal(t, newID, tags["v1"])
+	moved, err := notes.List(rp, notes.DefaultNamespace, newID)
+	require.NoError(t, err)
+	require.Len(t, moved, 1)
+	assert.Equal(t, newID, moved[0].Commit)
+
+	// nothing left to commit
+	require.NoError(t, index.UpdateIndex(rp))
+	changed, err := ingest.IngestLocalChanges(context.Background(), rp, "main")
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	doc, err := materialize.Load(rp, "main", p2id["a.txt"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello", "token=***", ""}, doc.Lines)
+}
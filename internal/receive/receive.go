@@ -0,0 +1,204 @@
+package receive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"evo/internal/config"
+	"evo/internal/guard"
+	"evo/internal/log"
+	"evo/internal/repo"
+	"evo/internal/signing"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var logger = log.For("receive")
+
+// A server checks every push against the receive policy of the target
+// stream before applying it. The policy is read from the config as seen from
+// that stream, so [stream.<name>] sections and includeIf "stream:<glob>"
+// files can protect single streams or groups of them.
+
+// Policy is what a push to one stream must satisfy
+type Policy struct {
+	Stream            string
+	Protected         bool   // reject every push
+	AllowReviewed     bool   // an API merge of an approved review may still reach a protected stream
+	RequireSignatures bool   // every commit needs a trusted signature
+	TrustedKeys       string // directory of .pub files; empty trusts signing.keyPath's key
+	MaxCommitSize     int64  // largest encoded commit in bytes, 0 for no limit
+	Hook              string // extra hook executable
+	Guards            *guard.Guards
+}
+
+// Load reads the receive policy of stream
+func Load(repoPath, stream string) (*Policy, error) {
+	cfg, err := config.LoadForStream(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{Stream: stream, TrustedKeys: cfg.String("receive.trustedKeys"), Hook: cfg.String("receive.hook")}
+	if p.Protected, err = cfg.Bool("receive.protected"); err != nil {
+		return nil, err
+	}
+	if p.AllowReviewed, err = cfg.Bool("receive.allowReviewedMerges"); err != nil {
+		return nil, err
+	}
+	if p.RequireSignatures, err = cfg.Bool("receive.requireSignatures"); err != nil {
+		return nil, err
+	}
+	if p.MaxCommitSize, err = cfg.Size("receive.maxCommitSize"); err != nil {
+		return nil, err
+	}
+	if p.Guards, err = guard.Load(repoPath, stream); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Rejection lists every reason a push was refused
+type Rejection struct {
+	Stream  string   `json:"stream"`
+	Reasons []string `json:"reasons"`
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("push to %s rejected: %s", r.Stream, strings.Join(r.Reasons, "; "))
+}
+
+// HookPath is the pre-receive hook run for every push, if present
+func HookPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "hooks", "pre-receive")
+}
+
+// Check runs the policy of stream and the receive hooks against the commits a
+// push would add. A refused push returns a *Rejection.
+func Check(repoPath, stream string, incoming []types.Commit) error {
+	p, err := Load(repoPath, stream)
+	if err != nil {
+		return err
+	}
+	return p.Check(repoPath, incoming)
+}
+
+// Check runs the policy and the receive hooks against the commits a push
+// would add
+func (p *Policy) Check(repoPath string, incoming []types.Commit) error {
+	rej := &Rejection{Stream: p.Stream}
+	if p.Protected {
+		rej.Reasons = append(rej.Reasons, fmt.Sprintf("stream %s is protected", p.Stream))
+		return rej
+	}
+	var keys []ed25519.PublicKey
+	if p.RequireSignatures {
+		var err error
+		if keys, err = p.trustedKeys(repoPath); err != nil {
+			return err
+		}
+	}
+	for i := range incoming {
+		c := &incoming[i]
+		if p.MaxCommitSize > 0 {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if int64(len(data)) > p.MaxCommitSize {
+				rej.Reasons = append(rej.Reasons, fmt.Sprintf("commit %s is %d bytes (limit %d)", c.ID, len(data), p.MaxCommitSize))
+			}
+		}
+		if p.RequireSignatures && !signing.VerifyCommitKeys(c, keys) {
+			rej.Reasons = append(rej.Reasons, fmt.Sprintf("commit %s has no trusted signature", c.ID))
+		}
+	}
+	if p.Guards != nil {
+		var eops []types.ExtendedOp
+		for _, c := range incoming {
+			eops = append(eops, c.Operations...)
+		}
+		violations, err := p.Guards.Check(repoPath, p.Stream, eops, false)
+		if err != nil {
+			return err
+		}
+		for _, v := range violations {
+			rej.Reasons = append(rej.Reasons, v.String())
+		}
+	}
+	if len(rej.Reasons) > 0 {
+		return rej
+	}
+	var hooks []string
+	if _, err := os.Stat(HookPath(repoPath)); err == nil {
+		hooks = append(hooks, HookPath(repoPath))
+	}
+	if p.Hook != "" {
+		hooks = append(hooks, p.Hook)
+	}
+	for _, hook := range hooks {
+		reason, err := runHook(repoPath, hook, p.Stream, incoming)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			rej.Reasons = append(rej.Reasons, reason)
+			return rej
+		}
+	}
+	return nil
+}
+
+func (p *Policy) trustedKeys(repoPath string) ([]ed25519.PublicKey, error) {
+	if p.TrustedKeys != "" {
+		dir := p.TrustedKeys
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(repoPath, dir)
+		}
+		return signing.LoadPublicKeys(dir)
+	}
+	kp, err := signing.LoadKeyPair(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("receive.requireSignatures needs receive.trustedKeys or a signing key: %w", err)
+	}
+	return []ed25519.PublicKey{kp.PublicKey}, nil
+}
+
+// runHook runs a receive hook with the incoming commits on stdin, one JSON
+// object per line. A non-zero exit refuses the push with the hook's stderr
+// as the reason; an error means the hook could not be run.
+func runHook(repoPath, hook, stream string, incoming []types.Commit) (string, error) {
+	var stdin bytes.Buffer
+	enc := json.NewEncoder(&stdin)
+	for i := range incoming {
+		if err := enc.Encode(&incoming[i]); err != nil {
+			return "", err
+		}
+	}
+	cmd := exec.Command(hook)
+	cmd.Dir = repoPath
+	cmd.Stdin = &stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"EVO_STREAM="+stream,
+		"EVO_COMMITS="+strconv.Itoa(len(incoming)),
+	)
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = fmt.Sprintf("%s hook refused the push", filepath.Base(hook))
+		}
+		logger.Info("hook refused push", "hook", hook, "stream", stream, "reason", reason)
+		return reason, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to run receive hook %s: %w", hook, err)
+	}
+	return "", nil
+}
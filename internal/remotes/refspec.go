@@ -0,0 +1,103 @@
+package remotes
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Refspec selects streams to push or pull. Src is a stream name or a
+// pattern such as feature-*, matched as path.Match matches, so a prefix
+// works as a namespace. Dst, if set, is the stream's name on the other
+// side; a * in it stands for what the * of Src matched.
+type Refspec struct {
+	Src string `json:"src"`
+	Dst string `json:"dst,omitempty"`
+}
+
+// ParseRefspec parses "<src>[:<dst>]"
+func ParseRefspec(s string) (Refspec, error) {
+	src, dst, _ := strings.Cut(strings.TrimSpace(s), ":")
+	rs := Refspec{Src: src, Dst: dst}
+	if src == "" {
+		return rs, fmt.Errorf("invalid refspec %q: no stream", s)
+	}
+	if _, err := path.Match(src, ""); err != nil {
+		return rs, fmt.Errorf("invalid refspec %q: %w", s, err)
+	}
+	if strings.Contains(dst, "*") && (strings.Count(src, "*") != 1 || strings.ContainsAny(src, "?[") || strings.Count(dst, "*") != 1) {
+		return rs, fmt.Errorf("invalid refspec %q: a * in the destination needs exactly one * in the source", s)
+	}
+	return rs, nil
+}
+
+// ParseRefspecs parses refspecs separated by commas or spaces, as the
+// remote.<name>.push and .pull keys hold them
+func ParseRefspecs(s string) ([]Refspec, error) {
+	var out []Refspec
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		rs, err := ParseRefspec(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rs)
+	}
+	return out, nil
+}
+
+// Wildcard reports whether the refspec can select more than one stream
+func (rs Refspec) Wildcard() bool {
+	return strings.ContainsAny(rs.Src, "*?[")
+}
+
+// Map returns the name on the other side of the stream name, if the
+// refspec selects it
+func (rs Refspec) Map(name string) (string, bool) {
+	if ok, _ := path.Match(rs.Src, name); !ok {
+		return "", false
+	}
+	switch {
+	case rs.Dst == "":
+		return name, true
+	case !strings.Contains(rs.Dst, "*"):
+		return rs.Dst, true
+	}
+	prefix, suffix, _ := strings.Cut(rs.Src, "*")
+	matched := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(rs.Dst, "*", matched, 1), true
+}
+
+// Mapping is a stream selected by refspecs and its name on the other side
+type Mapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Select returns the streams of names that specs select, in the order of
+// names, each mapped by the first refspec selecting it. Refspecs without a
+// wildcard select their stream even if names lacks it.
+func Select(specs []Refspec, names []string) []Mapping {
+	var out []Mapping
+	seen := make(map[string]bool)
+	add := func(from, to string) {
+		if !seen[from] {
+			seen[from] = true
+			out = append(out, Mapping{From: from, To: to})
+		}
+	}
+	for _, name := range names {
+		for _, rs := range specs {
+			if to, ok := rs.Map(name); ok {
+				add(name, to)
+				break
+			}
+		}
+	}
+	for _, rs := range specs {
+		if !rs.Wildcard() {
+			to, _ := rs.Map(rs.Src)
+			add(rs.Src, to)
+		}
+	}
+	return out
+}
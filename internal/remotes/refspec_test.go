@@ -0,0 +1,37 @@
+package remotes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRefspec(t *testing.T) {
+	rs, err := ParseRefspec("feature-*:team-*")
+	require.NoError(t, err)
+	assert.Equal(t, Refspec{Src: "feature-*", Dst: "team-*"}, rs)
+	assert.True(t, rs.Wildcard())
+
+	for _, bad := range []string{"", ":main", "feature-[", "*-*:x-*", "f?-*:x-*", "feature-*:x-**"} {
+		_, err := ParseRefspec(bad)
+		assert.Error(t, err, bad)
+	}
+
+	specs, err := ParseRefspecs("main, feature-* release:prod")
+	require.NoError(t, err)
+	assert.Equal(t, []Refspec{{Src: "main"}, {Src: "feature-*"}, {Src: "release", Dst: "prod"}}, specs)
+}
+
+func TestSelect(t *testing.T) {
+	specs := []Refspec{{Src: "feature-*", Dst: "team-*"}, {Src: "feature-x"}, {Src: "main"}, {Src: "hotfix", Dst: "prod"}}
+	names := []string{"dev", "feature-a", "feature-b", "feature-x", "main"}
+	assert.Equal(t, []Mapping{
+		{From: "feature-a", To: "team-a"},
+		{From: "feature-b", To: "team-b"},
+		{From: "feature-x", To: "team-x"}, // the first refspec selecting it wins
+		{From: "main", To: "main"},
+		{From: "hotfix", To: "prod"}, // named outright, so selected though missing
+	}, Select(specs, names))
+	assert.Empty(t, Select([]Refspec{{Src: "nope-*"}}, names))
+}
@@ -0,0 +1,284 @@
+// Package remotes names the evo servers a repository talks to. A remote is
+// configured as remote.<name>.url, the base URL of an `evo serve` instance,
+// with optional settings for reaching it:
+//
+//	remote.<name>.proxy      proxy URL, instead of https_proxy and friends
+//	remote.<name>.caFile     PEM bundle of CAs trusted besides the system's
+//	remote.<name>.certFile   PEM client certificate, with its key unless
+//	remote.<name>.keyFile    names the key
+//	remote.<name>.insecure   skip verifying the server's certificate
+package remotes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"evo/internal/config"
+	"evo/internal/everrors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote is a server a repository talks to
+type Remote struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Proxy    string `json:"proxy,omitempty"`
+	CAFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	// streams push and pull exchange when given none
+	Push []Refspec `json:"push,omitempty"`
+	Pull []Refspec `json:"pull,omitempty"`
+	// Limit, if set, wraps the bodies sent and received, e.g. to hold them
+	// to a rate
+	Limit func(io.Reader) io.Reader `json:"-"`
+}
+
+// List returns the configured remotes sorted by name
+func List(repoPath string) ([]Remote, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var out []Remote
+	for _, e := range cfg.List() {
+		name, ok := strings.CutPrefix(e.Key, "remote.")
+		if !ok {
+			continue
+		}
+		if name, ok = strings.CutSuffix(name, ".url"); !ok || strings.Contains(name, ".") || e.Value == "" {
+			continue
+		}
+		r := Remote{Name: name, URL: e.Value}
+		key := "remote." + name + "."
+		r.Proxy, _ = cfg.Get(key + "proxy")
+		r.CAFile, _ = cfg.Get(key + "caFile")
+		r.CertFile, _ = cfg.Get(key + "certFile")
+		r.KeyFile, _ = cfg.Get(key + "keyFile")
+		if r.Insecure, err = cfg.Bool(key + "insecure"); err != nil {
+			return nil, err
+		}
+		push, _ := cfg.Get(key + "push")
+		if r.Push, err = ParseRefspecs(push); err != nil {
+			return nil, fmt.Errorf("%spush: %w", key, err)
+		}
+		pull, _ := cfg.Get(key + "pull")
+		if r.Pull, err = ParseRefspecs(pull); err != nil {
+			return nil, fmt.Errorf("%spull: %w", key, err)
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Get returns the remote called name
+func Get(repoPath, name string) (*Remote, error) {
+	rs, err := List(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if r.Name == name {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("no remote named %s; set remote.%s.url", name, name)
+}
+
+// Default returns the remote used when none is named: origin, or the only
+// one configured. It returns nil when there is no such remote.
+func Default(repoPath string) (*Remote, error) {
+	rs, err := List(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if r.Name == "origin" {
+			return &r, nil
+		}
+	}
+	if len(rs) == 1 {
+		return &rs[0], nil
+	}
+	return nil, nil
+}
+
+// Error is a request the server refused, with the {"error"} of its body
+type Error struct {
+	Status  int
+	Message string
+	Body    []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server responded %d: %s", e.Status, e.Message)
+}
+
+// Is gives the errors of some statuses the kind of error the server had
+func (e *Error) Is(target error) bool {
+	switch e.Status {
+	case http.StatusNotFound:
+		return target == everrors.ErrNotFound
+	case http.StatusConflict:
+		return target == everrors.ErrConflict
+	}
+	return false
+}
+
+// transport is how a remote is reached
+type transport struct {
+	proxy, caFile, certFile, keyFile string
+	insecure                         bool
+}
+
+// clients holds a client for each transport, so remotes reached the same way
+// share connections
+var clients sync.Map
+
+// client returns the HTTP client of the remote. It gives up on a server that
+// takes over a minute to answer, but not on a body that takes longer, as
+// large files go at a limited rate.
+func (r *Remote) client() (*http.Client, error) {
+	key := transport{r.Proxy, r.CAFile, r.CertFile, r.KeyFile, r.Insecure}
+	if c, ok := clients.Load(key); ok {
+		return c.(*http.Client), nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = time.Minute
+	if r.Proxy != "" {
+		u, err := url.Parse(r.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy of remote %s: %w", r.Name, err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	tc := &tls.Config{InsecureSkipVerify: r.Insecure}
+	if r.CAFile != "" {
+		pem, err := os.ReadFile(expandHome(r.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle of remote %s: %w", r.Name, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s of remote %s", r.CAFile, r.Name)
+		}
+		tc.RootCAs = pool
+	}
+	if r.CertFile != "" {
+		keyFile := r.KeyFile
+		if keyFile == "" {
+			keyFile = r.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(expandHome(r.CertFile), expandHome(keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate of remote %s: %w", r.Name, err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = tc
+	c, _ := clients.LoadOrStore(key, &http.Client{Transport: t})
+	return c.(*http.Client), nil
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// Request sends body to path on the remote and returns the response, whose
+// body the caller closes. A status other than 2xx is an *Error.
+func (r *Remote) Request(method, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(r.URL, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of remote %s: %w", r.Name, err)
+	}
+	if req.Body != nil && req.Body != http.NoBody && r.Limit != nil {
+		req.Body = limitedBody{r.Limit(req.Body), req.Body}
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach remote %s: %w", r.Name, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		if r.Limit != nil {
+			resp.Body = limitedBody{r.Limit(resp.Body), resp.Body}
+		}
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of remote %s: %w", r.Name, err)
+	}
+	var eb struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &eb) != nil || eb.Error == "" {
+		eb.Error = strings.TrimSpace(string(data))
+	}
+	return nil, &Error{Status: resp.StatusCode, Message: eb.Error, Body: data}
+}
+
+// limitedBody reads a request or response body through Limit and closes the
+// body
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Do sends body as JSON to path on the remote and decodes the response into
+// out, either of which may be nil. A status other than 2xx is an *Error.
+func (r *Remote) Do(method, path string, body, out any) error {
+	var rd io.Reader
+	header := http.Header{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.Request(method, path, rd, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of remote %s: %w", r.Name, err)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response of remote %s: %w", r.Name, err)
+	}
+	return nil
+}
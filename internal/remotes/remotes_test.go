@@ -0,0 +1,99 @@
+package remotes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"evo/internal/config"
+	"evo/internal/repo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	for k, v := range map[string]string{
+		"remote.origin.url":      "https://evo.example.com",
+		"remote.origin.caFile":   "/etc/evo/ca.pem",
+		"remote.origin.insecure": "true",
+		"remote.origin.push":     "main,feature-*:team-*",
+		"remote.backup.url":      "http://backup:8080",
+		"remote.broken.proxy":    "http://proxy:3128",
+	} {
+		require.NoError(t, config.SetRepoConfigValue(rp, k, v))
+	}
+	rs, err := List(rp)
+	require.NoError(t, err)
+	assert.Equal(t, []Remote{
+		{Name: "backup", URL: "http://backup:8080"},
+		{Name: "origin", URL: "https://evo.example.com", CAFile: "/etc/evo/ca.pem", Insecure: true,
+			Push: []Refspec{{Src: "main"}, {Src: "feature-*", Dst: "team-*"}}},
+	}, rs)
+	r, err := Default(rp)
+	require.NoError(t, err)
+	assert.Equal(t, "origin", r.Name)
+}
+
+func TestTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer ts.Close()
+	var out struct{ OK bool }
+
+	r := &Remote{Name: "origin", URL: ts.URL}
+	assert.Error(t, r.Do("GET", "/", nil, &out), "the test CA isn't trusted")
+
+	r.Insecure = true
+	require.NoError(t, r.Do("GET", "/", nil, &out))
+	assert.True(t, out.OK)
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+	r = &Remote{Name: "origin", URL: ts.URL, CAFile: ca}
+	require.NoError(t, r.Do("GET", "/", nil, &out))
+
+	r.CAFile = filepath.Join(t.TempDir(), "none.pem")
+	assert.ErrorContains(t, r.Do("GET", "/", nil, &out), "CA bundle")
+}
+
+func TestClientCertificate(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	r := &Remote{Name: "origin", URL: ts.URL, Insecure: true}
+	assert.Error(t, r.Do("GET", "/", nil, nil), "the server wants a certificate")
+
+	// the server's own certificate and key serve as a client certificate
+	cert := ts.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	r.CertFile, r.KeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(r.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644))
+	require.NoError(t, os.WriteFile(r.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+	require.NoError(t, r.Do("GET", "/", nil, nil))
+}
+
+func TestProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{}`))
+	}))
+	defer proxy.Close()
+	r := &Remote{Name: "origin", URL: "http://evo.invalid:8080", Proxy: proxy.URL}
+	require.NoError(t, r.Do("GET", "/api/v1/locks", nil, nil))
+	assert.Equal(t, "http://evo.invalid:8080/api/v1/locks", proxied)
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// .evo/alternates lists, one per line, the data directories (.evo, or a bare
+// repository) of other repositories whose content this one borrows: what
+// never changes once written, blobs, LFS chunks, sealed op log segments and
+// commit files, is read from theirs when missing from its own. Nothing is
+// ever written to an alternate, and its own alternates are not followed.
+// Relative paths are relative to .evo.
+
+func alternatesPath(path string) string {
+	return filepath.Join(Dir(path), "alternates")
+}
+
+// Alternates returns the data directories the repository borrows from
+func Alternates(path string) ([]string, error) {
+	data, err := os.ReadFile(alternatesPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(Dir(path), line)
+		}
+		out = append(out, filepath.Clean(line))
+	}
+	return out, nil
+}
+
+// SetAlternates replaces the data directories the repository borrows from,
+// removing .evo/alternates when there are none
+func SetAlternates(path string, dirs []string) error {
+	if len(dirs) == 0 {
+		if err := os.Remove(alternatesPath(path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(alternatesPath(path), []byte(strings.Join(dirs, "\n")+"\n"), 0644)
+}
+
+// Borrowed returns the files of the repository's alternates at rel, a path
+// relative to .evo, that exist, in the order the alternates are listed
+func Borrowed(path, rel string) []string {
+	alts, err := Alternates(path)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, dir := range alts {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// RootOf returns the repository holding path, a file under its data
+// directory, and path relative to that directory; ok is false when path
+// isn't under one
+func RootOf(path string) (root, rel string, ok bool) {
+	dir := filepath.Dir(path)
+	for {
+		if filepath.Base(dir) == EvoDir {
+			root = filepath.Dir(dir)
+			break
+		}
+		if IsBare(dir) {
+			root = dir
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", "", false
+	}
+	return root, filepath.ToSlash(rel), true
+}
@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Head is what .evo/HEAD records: the current stream on the first line and,
+// while a commit other than the stream's newest is checked out, that commit
+// on the second
+type Head struct {
+	Stream   string `json:"stream"`
+	Detached string `json:"detached,omitempty"`
+}
+
+func headPath(path string) string {
+	return filepath.Join(Dir(path), "HEAD")
+}
+
+// ReadHead returns the HEAD of the repository at path
+func ReadHead(path string) (Head, error) {
+	b, err := os.ReadFile(headPath(path))
+	if err != nil {
+		return Head{}, err
+	}
+	return ParseHead(string(b)), nil
+}
+
+// ParseHead parses the content of a HEAD file
+func ParseHead(s string) Head {
+	stream, detached, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return Head{Stream: strings.TrimSpace(stream), Detached: strings.TrimSpace(detached)}
+}
+
+// WriteHead records h as the HEAD of the repository at path
+func WriteHead(path string, h Head) error {
+	content := h.Stream
+	if h.Detached != "" {
+		content += "\n" + h.Detached + "\n"
+	}
+	if err := os.WriteFile(headPath(path), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write HEAD: %w", err)
+	}
+	return nil
+}
@@ -2,107 +2,152 @@ package repo
 
 import (
 	"errors"
-	"evo/internal/crdt/compact"
-	"evo/internal/lfs"
+	"evo/internal/everrors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
 const EvoDir = ".evo"
 
-var (
-	compactionService *compact.CompactionService
-	garbageCollector  *lfs.GarbageCollector
-	serviceMutex      sync.Mutex
-)
+// ErrBare is returned by operations that need a working tree when run on a
+// bare repository
+var ErrBare = errors.New("this operation needs a working tree, but the repository is bare")
+
+// bare caches IsBare for repositories that exist
+var bare sync.Map
+
+// IsBare reports whether path is a bare repository: one without a working
+// tree, whose directory holds what .evo otherwise does
+func IsBare(path string) bool {
+	if v, ok := bare.Load(path); ok {
+		return v.(bool)
+	}
+	b := filepath.Base(path) != EvoDir && isFile(filepath.Join(path, "HEAD")) &&
+		isFile(filepath.Join(path, "version")) && isDir(filepath.Join(path, "streams"))
+	if b || isDir(filepath.Join(path, EvoDir)) {
+		bare.Store(path, b)
+	}
+	return b
+}
+
+// IsRepo reports whether path is the root of a repository, bare or not
+func IsRepo(path string) bool {
+	return isDir(filepath.Join(path, EvoDir)) || IsBare(path)
+}
+
+// Dir returns the directory holding the repository's data: .evo under the
+// root, or the root itself for a bare repository
+func Dir(path string) string {
+	if IsBare(path) {
+		return path
+	}
+	return filepath.Join(path, EvoDir)
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// DefaultStream is the stream a new repository starts on unless told
+// otherwise
+const DefaultStream = "main"
+
+// InitOptions adjust the repository Init creates
+type InitOptions struct {
+	DefaultStream string   // stream HEAD points at; DefaultStream if empty
+	Streams       []string // further streams to create
+	Bare          bool     // no working tree: the store is the directory itself
+}
+
+// ValidStreamName reports whether name can name a stream: a single path
+// element
+func ValidStreamName(name string) bool {
+	return name != "" && filepath.IsLocal(name) && !strings.ContainsAny(name, `/\`)
+}
 
 // InitRepo creates the .evo folder structure, default stream, config, index, etc.
 func InitRepo(path string) error {
-	serviceMutex.Lock()
-	defer serviceMutex.Unlock()
+	return Init(path, InitOptions{})
+}
 
-	evoPath := filepath.Join(path, EvoDir)
-	if _, err := os.Stat(evoPath); err == nil {
-		return errors.New("Evo repository already exists here")
+// Init is InitRepo with options
+func Init(path string, opts InitOptions) error {
+	head := opts.DefaultStream
+	if head == "" {
+		head = DefaultStream
 	}
-
-	dirs := []string{
-		filepath.Join(path, EvoDir),
-		filepath.Join(path, EvoDir, "ops"),
-		filepath.Join(path, EvoDir, "commits"),
-		filepath.Join(path, EvoDir, "config"),
-		filepath.Join(path, EvoDir, "streams"),
-		filepath.Join(path, EvoDir, "largefiles"),
-		filepath.Join(path, EvoDir, "cache"),
-		filepath.Join(path, EvoDir, "chunks"),
-		filepath.Join(path, EvoDir, "lfs"),
+	for _, s := range append([]string{head}, opts.Streams...) {
+		if !ValidStreamName(s) {
+			return fmt.Errorf("invalid stream name %q", s)
+		}
 	}
-	for _, d := range dirs {
-		if err := os.MkdirAll(d, 0755); err != nil {
-			return err
+	evoPath := filepath.Join(path, EvoDir)
+	if opts.Bare {
+		evoPath = path
+	}
+	// a config put in place beforehand is kept; anything else means the
+	// repository exists, or for a bare one that the directory is in use
+	if entries, err := os.ReadDir(evoPath); err == nil {
+		for _, e := range entries {
+			if e.Name() == "config" {
+				continue
+			}
+			if opts.Bare && !IsBare(path) {
+				return fmt.Errorf("%s is not empty", path)
+			}
+			return errors.New("Evo repository already exists here")
 		}
 	}
 
-	// Start compaction service
-	cs := compact.NewCompactionService(path, compact.DefaultConfig())
-	if err := cs.Start(); err != nil {
-		return err
+	for _, d := range []string{"", "ops", "commits", "config", "streams", "chunks", "lfs"} {
+		if err := os.MkdirAll(filepath.Join(evoPath, d), 0755); err != nil {
+			return err
+		}
 	}
-	compactionService = cs
-
-	// Start LFS garbage collector
-	store := lfs.NewStore(path)
-	gc := lfs.NewGarbageCollector(store)
-	gc.Start()
-	garbageCollector = gc
 
-	// HEAD => "main"
-	if err := os.WriteFile(filepath.Join(evoPath, "HEAD"), []byte("main"), 0644); err != nil {
-		return err
-	}
-	// create stream "main"
-	if err := os.WriteFile(filepath.Join(evoPath, "streams", "main"), []byte{}, 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(evoPath, "HEAD"), []byte(head), 0644); err != nil {
 		return err
 	}
-
-	// create empty .evo/index
-	if err := os.WriteFile(filepath.Join(evoPath, "index"), []byte{}, 0644); err != nil {
-		return err
+	for _, s := range append([]string{head}, opts.Streams...) {
+		if err := os.WriteFile(filepath.Join(evoPath, "streams", s), []byte{}, 0644); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
-
-// Cleanup stops all background services
-func Cleanup() {
-	serviceMutex.Lock()
-	defer serviceMutex.Unlock()
-
-	if compactionService != nil {
-		compactionService.Stop()
-		compactionService = nil
+	if !opts.Bare {
+		// create empty .evo/index
+		if err := os.WriteFile(filepath.Join(evoPath, "index"), []byte{}, 0644); err != nil {
+			return err
+		}
 	}
 
-	if garbageCollector != nil {
-		garbageCollector.Stop()
-		garbageCollector = nil
-	}
+	// written last: it marks a bare repository as complete
+	return writeVersion(filepath.Join(evoPath, "version"), FormatVersion)
 }
 
-// FindRepoRoot searches for .evo directory walking up from start
+// FindRepoRoot searches for .evo directory, or a bare repository, walking up
+// from start
 func FindRepoRoot(start string) (string, error) {
 	cur, err := filepath.Abs(start)
 	if err != nil {
 		return "", err
 	}
 	for {
-		if _, err := os.Stat(filepath.Join(cur, EvoDir)); err == nil {
+		if IsRepo(cur) {
 			return cur, nil
 		}
 		parent := filepath.Dir(cur)
 		if parent == cur {
-			return "", os.ErrNotExist
+			return "", everrors.Errorf(everrors.ErrNotARepo, "not an evo repository (or any parent up to /): %s", start)
 		}
 		cur = parent
 	}
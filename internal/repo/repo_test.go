@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"errors"
+	"evo/internal/everrors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -86,8 +88,8 @@ func TestRepo(t *testing.T) {
 		}
 
 		_, err = FindRepoRoot(nonRepoPath)
-		if err == nil {
-			t.Error("Expected error when finding root in non-repository")
+		if !errors.Is(err, everrors.ErrNotARepo) {
+			t.Errorf("Expected ErrNotARepo when finding root in non-repository, got %v", err)
 		}
 	})
 
@@ -172,4 +174,106 @@ func TestRepo(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Init Options", func(t *testing.T) {
+		repoPath := filepath.Join(tmpDir, "options-test")
+		if err := Init(repoPath, InitOptions{DefaultStream: "trunk", Streams: []string{"dev", "trunk"}}); err != nil {
+			t.Fatal(err)
+		}
+		head, err := os.ReadFile(filepath.Join(repoPath, ".evo", "HEAD"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(head) != "trunk" {
+			t.Errorf("Expected HEAD to be 'trunk', got '%s'", string(head))
+		}
+		for _, s := range []string{"trunk", "dev"} {
+			if _, err := os.Stat(filepath.Join(repoPath, ".evo", "streams", s)); err != nil {
+				t.Errorf("Stream %s not created", s)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, ".evo", "streams", "main")); err == nil {
+			t.Error("Stream main created")
+		}
+
+		// a config written before init is kept
+		preset := filepath.Join(tmpDir, "preset-config-test")
+		if err := os.MkdirAll(filepath.Join(preset, ".evo", "config"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(preset, ".evo", "config", "config.toml"), []byte("[user]\nname = \"x\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := InitRepo(preset); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(filepath.Join(preset, ".evo", "config", "config.toml")); err != nil || len(data) == 0 {
+			t.Error("Preset config lost")
+		}
+
+		bad := filepath.Join(tmpDir, "bad-stream-test")
+		if err := Init(bad, InitOptions{DefaultStream: "../x"}); err == nil {
+			t.Error("Expected error for invalid stream name")
+		}
+		if _, err := os.Stat(bad); err == nil {
+			t.Error("Repository created despite invalid stream name")
+		}
+	})
+
+	t.Run("Bare Repository", func(t *testing.T) {
+		repoPath := filepath.Join(tmpDir, "bare-test")
+		if err := Init(repoPath, InitOptions{Bare: true}); err != nil {
+			t.Fatal(err)
+		}
+		if !IsBare(repoPath) || Dir(repoPath) != repoPath {
+			t.Errorf("Expected %s to be bare, Dir is %s", repoPath, Dir(repoPath))
+		}
+		for _, p := range []string{"HEAD", "streams/main", "ops", "commits", "version"} {
+			if _, err := os.Stat(filepath.Join(repoPath, p)); err != nil {
+				t.Errorf("%s not created", p)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, ".evo")); err == nil {
+			t.Error(".evo created in bare repository")
+		}
+		root, err := FindRepoRoot(filepath.Join(repoPath, "streams"))
+		if err != nil || root != repoPath {
+			t.Errorf("Expected root %s, got %s (%v)", repoPath, root, err)
+		}
+		if err := Init(repoPath, InitOptions{Bare: true}); err == nil {
+			t.Error("Expected error initializing existing bare repository")
+		}
+		if v, err := Version(repoPath); err != nil || v != FormatVersion {
+			t.Errorf("Expected version %d, got %d (%v)", FormatVersion, v, err)
+		}
+
+		// the .evo of a normal repository is not a bare repository
+		normal := filepath.Join(tmpDir, "not-bare-test")
+		if err := InitRepo(normal); err != nil {
+			t.Fatal(err)
+		}
+		if IsBare(filepath.Join(normal, ".evo")) || IsBare(normal) {
+			t.Error("Normal repository taken for bare")
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		repoPath := filepath.Join(tmpDir, "head-test")
+		if err := InitRepo(repoPath); err != nil {
+			t.Fatal(err)
+		}
+		if h, err := ReadHead(repoPath); err != nil || h != (Head{Stream: "main"}) {
+			t.Errorf("Expected attached HEAD on main, got %+v (%v)", h, err)
+		}
+		want := Head{Stream: "main", Detached: "abc123"}
+		if err := WriteHead(repoPath, want); err != nil {
+			t.Fatal(err)
+		}
+		if h, err := ReadHead(repoPath); err != nil || h != want {
+			t.Errorf("Expected %+v, got %+v (%v)", want, h, err)
+		}
+		if h := ParseHead("feature\n"); h != (Head{Stream: "feature"}) {
+			t.Errorf("Expected attached HEAD on feature, got %+v", h)
+		}
+	})
 }
@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"evo/internal/platform"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the repository format this build reads and writes.
+// Repositories from before the format was recorded are version 1.
+const FormatVersion = 2
+
+// TooNewError is returned for a repository in a format newer than this build
+// understands
+type TooNewError struct {
+	Version int
+}
+
+func (e *TooNewError) Error() string {
+	return fmt.Sprintf("repository format version %d is newer than this evo supports (%d); upgrade evo", e.Version, FormatVersion)
+}
+
+func versionPath(path string) string {
+	return filepath.Join(Dir(path), "version")
+}
+
+// Version returns the format version of the repository at path
+func Version(path string) (int, error) {
+	data, err := os.ReadFile(versionPath(path))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read repository format version: %w", err)
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid repository format version %q in %s", strings.TrimSpace(string(data)), versionPath(path))
+	}
+	return v, nil
+}
+
+// SetVersion records the format version of the repository at path
+func SetVersion(path string, v int) error {
+	return writeVersion(versionPath(path), v)
+}
+
+func writeVersion(file string, v int) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(v)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write repository format version: %w", err)
+	}
+	return platform.Rename(tmp, file)
+}
+
+// CheckVersion refuses a repository in a newer format than this build's
+func CheckVersion(path string) error {
+	v, err := Version(path)
+	if err != nil {
+		return err
+	}
+	if v > FormatVersion {
+		return &TooNewError{Version: v}
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package review
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"evo/internal/config"
+	"evo/internal/eventlog"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/repo"
+	"evo/internal/signing"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A review asks to merge a source stream into a target stream. Like issues,
+// each review is an event log in .evo/reviews/<id>.json that merges across
+// replicas by union. Comments can be anchored to a line by its LineID, which
+// follows the line however the file changes around it, and approvals are
+// Ed25519 signatures over the source head they approve.
+
+// Review states
+const (
+	StateOpen   = "open"
+	StateMerged = "merged"
+	StateClosed = "closed"
+)
+
+// Event kinds
+const (
+	KindSet     = "set"     // Field = Value
+	KindComment = "comment" // Value is the text, Attrs the anchor
+	KindApprove = "approve" // Attrs hold the approved head and signature
+)
+
+// Author is who makes a change
+type Author = eventlog.Author
+
+// Anchor ties a comment to a line of the source stream
+type Anchor struct {
+	Path    string `json:"path"`
+	FileID  string `json:"fileId"`
+	LineID  string `json:"lineId"`
+	Line    int    `json:"line"`    // line number when the comment was made
+	Content string `json:"content"` // line content when the comment was made
+}
+
+// Comment is a review comment, optionally anchored to a line
+type Comment struct {
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+	Text        string    `json:"text"`
+	Anchor      *Anchor   `json:"anchor,omitempty"`
+}
+
+// Approval is a signed approval of the source stream at Head
+type Approval struct {
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+	Head        string    `json:"head"`
+	Signature   string    `json:"signature"`
+	PublicKey   string    `json:"publicKey"`
+	Comment     string    `json:"comment,omitempty"`
+}
+
+// Review is the state obtained by replaying a review's events
+type Review struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Source      string     `json:"source"`
+	Target      string     `json:"target"`
+	State       string     `json:"state"`
+	AuthorName  string     `json:"authorName"`
+	AuthorEmail string     `json:"authorEmail"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+	Comments    []Comment  `json:"comments,omitempty"`
+	Approvals   []Approval `json:"approvals,omitempty"`
+}
+
+func store(repoPath string) *eventlog.Store {
+	return eventlog.New(repoPath, "reviews")
+}
+
+func replay(id string, evs []eventlog.Event) *Review {
+	r := &Review{ID: id, State: StateOpen}
+	for i, e := range evs {
+		if i == 0 {
+			r.AuthorName, r.AuthorEmail, r.Created = e.AuthorName, e.AuthorEmail, e.Timestamp
+		}
+		if e.Timestamp.After(r.Updated) {
+			r.Updated = e.Timestamp
+		}
+		switch e.Kind {
+		case KindSet:
+			switch e.Field {
+			case "title":
+				r.Title = e.Value
+			case "source":
+				r.Source = e.Value
+			case "target":
+				r.Target = e.Value
+			case "state":
+				r.State = e.Value
+			}
+		case KindComment:
+			c := Comment{AuthorName: e.AuthorName, AuthorEmail: e.AuthorEmail, Timestamp: e.Timestamp, Text: e.Value}
+			if e.Attrs["lineId"] != "" {
+				line, _ := strconv.Atoi(e.Attrs["line"])
+				c.Anchor = &Anchor{e.Attrs["path"], e.Attrs["fileId"], e.Attrs["lineId"], line, e.Attrs["content"]}
+			}
+			r.Comments = append(r.Comments, c)
+		case KindApprove:
+			r.Approvals = append(r.Approvals, Approval{
+				AuthorName:  e.AuthorName,
+				AuthorEmail: e.AuthorEmail,
+				Timestamp:   e.Timestamp,
+				Head:        e.Attrs["head"],
+				Signature:   e.Attrs["signature"],
+				PublicKey:   e.Attrs["publicKey"],
+				Comment:     e.Value,
+			})
+		}
+	}
+	return r
+}
+
+// Open starts a review of merging source into target
+func Open(repoPath, title, source, target string, who Author) (*Review, error) {
+	if source == target {
+		return nil, fmt.Errorf("source and target are both %s", source)
+	}
+	for _, s := range []string{source, target} {
+		if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", s)); err != nil {
+			return nil, everrors.Errorf(everrors.ErrNotFound, "stream %s does not exist", s)
+		}
+	}
+	if strings.TrimSpace(title) == "" {
+		title = fmt.Sprintf("Merge %s into %s", source, target)
+	}
+	id := uuid.New().String()
+	err := store(repoPath).Append(id, who,
+		eventlog.Event{Kind: KindSet, Field: "title", Value: title},
+		eventlog.Event{Kind: KindSet, Field: "source", Value: source},
+		eventlog.Event{Kind: KindSet, Field: "target", Value: target},
+		eventlog.Event{Kind: KindSet, Field: "state", Value: StateOpen},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return Get(repoPath, id)
+}
+
+// Get loads a review by its full ID
+func Get(repoPath, id string) (*Review, error) {
+	evs, err := store(repoPath).Load(id)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no review %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replay(id, evs), nil
+}
+
+// Resolve expands a unique ID prefix
+func Resolve(repoPath, prefix string) (string, error) {
+	return store(repoPath).Resolve(prefix)
+}
+
+// List returns every review, newest first
+func List(repoPath string) ([]*Review, error) {
+	ids, err := store(repoPath).IDs()
+	if err != nil {
+		return nil, err
+	}
+	var out []*Review
+	for _, id := range ids {
+		r, err := Get(repoPath, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out, nil
+}
+
+// SetState marks a review open, merged or closed
+func SetState(repoPath, id, state string, who Author) error {
+	switch state {
+	case StateOpen, StateMerged, StateClosed:
+	default:
+		return fmt.Errorf("invalid review state %q", state)
+	}
+	if _, err := Get(repoPath, id); err != nil {
+		return err
+	}
+	return store(repoPath).Append(id, who, eventlog.Event{Kind: KindSet, Field: "state", Value: state})
+}
+
+// AddComment comments on a review; anchor may be nil for a general comment
+func AddComment(repoPath, id, text string, anchor *Anchor, who Author) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("comment must not be empty")
+	}
+	if _, err := Get(repoPath, id); err != nil {
+		return err
+	}
+	e := eventlog.Event{Kind: KindComment, Value: text}
+	if anchor != nil {
+		e.Attrs = map[string]string{
+			"path":    anchor.Path,
+			"fileId":  anchor.FileID,
+			"lineId":  anchor.LineID,
+			"line":    strconv.Itoa(anchor.Line),
+			"content": anchor.Content,
+		}
+	}
+	return store(repoPath).Append(id, who, e)
+}
+
+// AnchorAt anchors a comment to line (1-based) of path in stream
+func AnchorAt(repoPath, stream, path string, line int) (*Anchor, error) {
+	fileID, err := index.LookupFileID(repoPath, filepath.ToSlash(path))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := materialize.Load(repoPath, stream, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if line < 1 || line > len(doc.Lines) {
+		return nil, fmt.Errorf("%s has %d lines in stream %s", path, len(doc.Lines), stream)
+	}
+	return &Anchor{
+		Path:    path,
+		FileID:  fileID,
+		LineID:  doc.LineIDs[line-1].String(),
+		Line:    line,
+		Content: doc.Lines[line-1],
+	}, nil
+}
+
+// Locate finds the current line number and content of an anchored line in
+// stream. ok is false if the line has been deleted.
+func Locate(repoPath, stream string, a *Anchor) (line int, content string, ok bool) {
+	doc, err := materialize.Load(repoPath, stream, a.FileID)
+	if err != nil {
+		return 0, "", false
+	}
+	for i, id := range doc.LineIDs {
+		if id.String() == a.LineID {
+			return i + 1, doc.Lines[i], true
+		}
+	}
+	return 0, "", false
+}
+
+// Head returns the ID of the newest commit in stream, or "" if it has none
+func Head(repoPath, stream string) (string, error) {
+	cs, err := streams.ListCommits(repoPath, stream)
+	if err != nil {
+		return "", err
+	}
+	if len(cs) == 0 {
+		return "", nil
+	}
+	return cs[len(cs)-1].ID, nil
+}
+
+// approvalMessage is what an approval signs
+func approvalMessage(r *Review, head string, who Author) []byte {
+	return []byte(fmt.Sprintf("evo review approval\nreview %s\nsource %s@%s\ntarget %s\napprover %s <%s>\n",
+		r.ID, r.Source, head, r.Target, who.Name, who.Email))
+}
+
+// Approve signs an approval of the source stream's current head with the
+// configured signing key
+func Approve(repoPath, id, comment string, who Author) (*Approval, error) {
+	r, err := Get(repoPath, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.State != StateOpen {
+		return nil, fmt.Errorf("review %s is %s", id, r.State)
+	}
+	if strings.EqualFold(who.Email, r.AuthorEmail) {
+		return nil, fmt.Errorf("review %s is yours; someone else has to approve it", id)
+	}
+	head, err := Head(repoPath, r.Source)
+	if err != nil {
+		return nil, err
+	}
+	sig, pub, err := signing.SignBytes(repoPath, approvalMessage(r, head, who))
+	if err != nil {
+		return nil, fmt.Errorf("approvals are signed: %w", err)
+	}
+	e := eventlog.Event{Kind: KindApprove, Value: comment, Attrs: map[string]string{
+		"head":      head,
+		"signature": sig,
+		"publicKey": pub,
+	}}
+	if err := store(repoPath).Append(id, who, e); err != nil {
+		return nil, err
+	}
+	return &Approval{AuthorName: who.Name, AuthorEmail: who.Email, Head: head, Signature: sig, PublicKey: pub, Comment: comment}, nil
+}
+
+// Reviewers maps the hex public keys trusted to approve reviews to the email
+// of the reviewer each belongs to
+type Reviewers map[string]string
+
+// keysDir is where the trusted reviewer keys live: review.trustedKeys,
+// relative to the repository, or .evo/reviews/keys. Sync only carries the
+// review event logs, so the keys stay local to each replica.
+func keysDir(repoPath string) (string, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return "", err
+	}
+	dir := cfg.String("review.trustedKeys")
+	if dir == "" {
+		return filepath.Join(repoPath, repo.EvoDir, "reviews", "keys"), nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoPath, dir)
+	}
+	return dir, nil
+}
+
+// LoadReviewers reads the trusted reviewer keys, one <email>.pub file per
+// reviewer as written by GenerateKeyPair. The file name ties the key to the
+// reviewer, so a key only counts for approvals made under that email.
+func LoadReviewers(repoPath string) (Reviewers, error) {
+	dir, err := keysDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return Reviewers{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reviewer keys: %w", err)
+	}
+	rs := make(Reviewers)
+	for _, e := range entries {
+		email, ok := strings.CutSuffix(e.Name(), ".pub")
+		if e.IsDir() || !ok {
+			continue
+		}
+		pub, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reviewer key: %w", err)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key length in %s: %d", e.Name(), len(pub))
+		}
+		rs[hex.EncodeToString(pub)] = strings.ToLower(email)
+	}
+	return rs, nil
+}
+
+// Trust adds a reviewer's public key to the trusted reviewer keys
+func Trust(repoPath, email string, pub ed25519.PublicKey) error {
+	if !strings.Contains(email, "@") || filepath.Base(email) != email || strings.ContainsAny(email, `/\`) {
+		return fmt.Errorf("invalid reviewer email %q", email)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key length: %d", len(pub))
+	}
+	dir, err := keysDir(repoPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create reviewer key directory: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, strings.ToLower(email)+".pub"), pub, 0644)
+}
+
+// Verify checks an approval: the signature must be good, made with a trusted
+// reviewer's key under that reviewer's email, and the reviewer must be
+// someone other than the review's author
+func (r *Review) Verify(a Approval, reviewers Reviewers) error {
+	if !signing.VerifyBytes(a.PublicKey, a.Signature, approvalMessage(r, a.Head, Author{Name: a.AuthorName, Email: a.AuthorEmail})) {
+		return fmt.Errorf("invalid signature")
+	}
+	email, ok := reviewers[strings.ToLower(a.PublicKey)]
+	switch {
+	case !ok:
+		return fmt.Errorf("key is not a trusted reviewer's")
+	case !strings.EqualFold(email, a.AuthorEmail):
+		return fmt.Errorf("key belongs to %s", email)
+	case strings.EqualFold(email, r.AuthorEmail):
+		return fmt.Errorf("approved by the review's author")
+	}
+	return nil
+}
+
+// ValidApprovals counts the reviewer keys with a valid approval that covers
+// head; an approval of an older head is stale once the source stream has
+// moved on
+func (r *Review) ValidApprovals(head string, reviewers Reviewers) int {
+	keys := make(map[string]bool)
+	for _, a := range r.Approvals {
+		if a.Head == head && r.Verify(a, reviewers) == nil {
+			keys[strings.ToLower(a.PublicKey)] = true
+		}
+	}
+	return len(keys)
+}
+
+// CheckApprovals returns an error unless the review has the
+// review.requiredApprovals valid approvals of its source's current head, by
+// distinct trusted reviewers
+func CheckApprovals(repoPath string, r *Review) error {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return err
+	}
+	need, err := cfg.Int64("review.requiredApprovals")
+	if err != nil {
+		return err
+	}
+	head, err := Head(repoPath, r.Source)
+	if err != nil {
+		return err
+	}
+	reviewers, err := LoadReviewers(repoPath)
+	if err != nil {
+		return err
+	}
+	if have := r.ValidApprovals(head, reviewers); int64(have) < need {
+		return fmt.Errorf("review %s has %d of %d required approvals of the current head", r.ID[:8], have, need)
+	}
+	return nil
+}
+
+// Diff lists the commits the review would merge into the target
+func Diff(repoPath string, r *Review) ([]types.Commit, error) {
+	return streams.MissingCommits(repoPath, r.Source, r.Target)
+}
+
+// Records returns the events of every review by ID, to send to a remote
+func Records(repoPath string) (map[string][]eventlog.Event, error) {
+	return store(repoPath).Records()
+}
+
+// Merge adds the events of a remote's reviews that this repository lacks and
+// returns how many it added
+func Merge(repoPath string, recs map[string][]eventlog.Event) (int, error) {
+	return store(repoPath).Merge(recs)
+}
+
+// Sync merges the reviews of another repository on disk into this one and
+// back. It returns how many events were added here and there.
+func Sync(repoPath, otherPath string) (int, int, error) {
+	return store(repoPath).Sync(otherPath)
+}
@@ -0,0 +1,197 @@
+package review
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/eventlog"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/signing"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var ann = Author{Name: "Ann", Email: "ann@example.com"}
+
+func setupRepo(t *testing.T) string {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	assert.NoError(t, streams.CreateStream(rp, "feature"))
+	return rp
+}
+
+func addCommit(t *testing.T, rp, stream string) string {
+	c := &types.Commit{ID: uuid.New().String(), Stream: stream, Message: "work", AuthorName: "Ann", AuthorEmail: ann.Email, Timestamp: time.Now()}
+	assert.NoError(t, commits.SaveCommitTo(rp, stream, c))
+	return c.ID
+}
+
+func TestLifecycle(t *testing.T) {
+	rp := setupRepo(t)
+	_, err := Open(rp, "", "feature", "feature", ann)
+	assert.Error(t, err)
+	_, err = Open(rp, "", "missing", "main", ann)
+	assert.Error(t, err)
+
+	r, err := Open(rp, "", "feature", "main", ann)
+	assert.NoError(t, err)
+	assert.Equal(t, "Merge feature into main", r.Title)
+	assert.Equal(t, StateOpen, r.State)
+
+	id := addCommit(t, rp, "feature")
+	diff, err := Diff(rp, r)
+	assert.NoError(t, err)
+	if assert.Len(t, diff, 1) {
+		assert.Equal(t, id, diff[0].ID)
+	}
+
+	assert.NoError(t, AddComment(rp, r.ID, "looks good", nil, ann))
+	assert.NoError(t, SetState(rp, r.ID, StateClosed, ann))
+	r, err = Get(rp, r.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StateClosed, r.State)
+	assert.Len(t, r.Comments, 1)
+	assert.Error(t, SetState(rp, r.ID, "pending", ann))
+}
+
+func TestAnchor(t *testing.T) {
+	rp := setupRepo(t)
+	fileID, nodeID := uuid.New(), uuid.New()
+	os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fileID.String()+" a.txt\n"), 0644)
+	logPath := filepath.Join(rp, ".evo", "ops", "feature", fileID.String()+".bin")
+	insert := func(lamport uint64, origin uuid.UUID, content string) uuid.UUID {
+		id := uuid.New()
+		assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{
+			Type: crdt.OpInsert, Lamport: lamport, NodeID: nodeID, FileID: fileID,
+			LineID: id, OriginLineID: origin, Content: content,
+		}))
+		return id
+	}
+	insert(1, crdt.DocumentStart, "one")
+	two := insert(2, crdt.DocumentStart, "two")
+
+	a, err := AnchorAt(rp, "feature", "a.txt", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, two.String(), a.LineID)
+	_, err = AnchorAt(rp, "feature", "a.txt", 3)
+	assert.Error(t, err)
+
+	// the anchor follows its line when lines are inserted above it
+	insert(3, crdt.DocumentStart, "zero")
+	line, content, ok := Locate(rp, "feature", a)
+	assert.True(t, ok)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, "two", content)
+
+	assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{Type: crdt.OpDelete, Lamport: 4, NodeID: nodeID, FileID: fileID, LineID: two}))
+	_, _, ok = Locate(rp, "feature", a)
+	assert.False(t, ok)
+}
+
+func TestApprovals(t *testing.T) {
+	rp := setupRepo(t)
+	r, err := Open(rp, "", "feature", "main", ann)
+	assert.NoError(t, err)
+	addCommit(t, rp, "feature")
+	bo := Author{Name: "Bo", Email: "bo@example.com"}
+
+	_, err = Approve(rp, r.ID, "", bo)
+	assert.Error(t, err, "approving needs a signing key")
+
+	assert.NoError(t, config.SetConfigValue(rp, "signing.keyPath", filepath.Join(rp, "key")))
+	assert.NoError(t, signing.GenerateKeyPair(rp))
+	_, err = Approve(rp, r.ID, "", ann)
+	assert.Error(t, err, "the author can't approve their own review")
+	a, err := Approve(rp, r.ID, "ship it", bo)
+	assert.NoError(t, err)
+	head, _ := Head(rp, "feature")
+	assert.Equal(t, head, a.Head)
+
+	r, _ = Get(rp, r.ID)
+	assert.Error(t, r.Verify(r.Approvals[0], Reviewers{}), "bo's key isn't trusted yet")
+	assert.Error(t, CheckApprovals(rp, r))
+
+	kp, err := signing.LoadKeyPair(rp)
+	assert.NoError(t, err)
+	assert.NoError(t, Trust(rp, bo.Email, kp.PublicKey))
+	reviewers, err := LoadReviewers(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, Reviewers{a.PublicKey: bo.Email}, reviewers)
+	assert.Equal(t, 1, r.ValidApprovals(head, reviewers))
+	assert.NoError(t, r.Verify(r.Approvals[0], reviewers))
+	assert.NoError(t, CheckApprovals(rp, r))
+
+	t.Run("Tampered", func(t *testing.T) {
+		forged := r.Approvals[0]
+		forged.AuthorEmail = "mallory@example.com"
+		assert.Error(t, r.Verify(forged, reviewers))
+	})
+
+	t.Run("Forged", func(t *testing.T) {
+		// a fresh key signs approvals as another reviewer and as the author
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		for _, who := range []Author{bo, ann, {Name: "Cy", Email: "cy@example.com"}} {
+			sig := ed25519.Sign(priv, approvalMessage(r, head, who))
+			assert.NoError(t, store(rp).Append(r.ID, who, eventlog.Event{Kind: KindApprove, Attrs: map[string]string{
+				"head":      head,
+				"signature": hex.EncodeToString(sig),
+				"publicKey": hex.EncodeToString(pub),
+			}}))
+		}
+		r, _ := Get(rp, r.ID)
+		assert.Len(t, r.Approvals, 4)
+		for _, a := range r.Approvals[1:] {
+			assert.Error(t, r.Verify(a, reviewers), a.AuthorEmail)
+		}
+		assert.NoError(t, config.SetConfigValue(rp, "review.requiredApprovals", "2"))
+		defer config.SetConfigValue(rp, "review.requiredApprovals", "1")
+		assert.Equal(t, 1, r.ValidApprovals(head, reviewers))
+		assert.Error(t, CheckApprovals(rp, r))
+
+		// trusting the author's key doesn't let them approve their own review
+		assert.NoError(t, Trust(rp, ann.Email, pub))
+		reviewers, err := LoadReviewers(rp)
+		assert.NoError(t, err)
+		assert.ErrorContains(t, r.Verify(r.Approvals[2], reviewers), "author")
+		assert.Equal(t, 1, r.ValidApprovals(head, reviewers))
+		assert.NoError(t, os.Remove(filepath.Join(rp, ".evo", "reviews", "keys", ann.Email+".pub")))
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		addCommit(t, rp, "feature")
+		head, _ := Head(rp, "feature")
+		assert.Equal(t, 0, r.ValidApprovals(head, reviewers))
+	})
+}
+
+func TestSync(t *testing.T) {
+	a, b := setupRepo(t), setupRepo(t)
+	r, err := Open(a, "", "feature", "main", ann)
+	assert.NoError(t, err)
+	pulled, pushed, err := Sync(b, a)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, pulled)
+	assert.Equal(t, 0, pushed)
+	assert.NoError(t, AddComment(b, r.ID, "from b", nil, ann))
+	assert.NoError(t, AddComment(a, r.ID, "from a", nil, ann))
+	_, _, err = Sync(a, b)
+	assert.NoError(t, err)
+
+	ra, _ := Get(a, r.ID)
+	rb, _ := Get(b, r.ID)
+	assert.Equal(t, ra, rb)
+	assert.Len(t, ra.Comments, 2)
+}
@@ -0,0 +1,73 @@
+package revparse
+
+import (
+	"fmt"
+	"path"
+)
+
+// Description names a commit by the nearest tag at or before it in its stream
+type Description struct {
+	Tag      string `json:"tag,omitempty"` // empty if no tag precedes the commit
+	Distance int    `json:"distance"`      // commits after the tag
+	ID       string `json:"id"`
+	Abbrev   string `json:"abbrev"`
+}
+
+// Format returns "<tag>-<distance>-g<abbrev>", just the tag for a tagged
+// commit unless long is set, or the abbreviated ID if there is no tag
+func (d *Description) Format(long bool) string {
+	switch {
+	case d.Tag == "":
+		return d.Abbrev
+	case d.Distance == 0 && !long:
+		return d.Tag
+	}
+	return fmt.Sprintf("%s-%d-g%s", d.Tag, d.Distance, d.Abbrev)
+}
+
+// Describe walks back from the commit a commit-ish names through its stream
+// to the nearest tagged commit. Only tags whose names match the glob pattern
+// match count, if it is set; of several tags on one commit the last by name
+// is used. A stream holds the commits merged into it, so tags on those are
+// found too.
+func (r *Resolver) Describe(spec, match string) (*Description, error) {
+	if match != "" {
+		if _, err := path.Match(match, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", match, err)
+		}
+	}
+	tags, err := Tags(r.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]string)
+	for name, id := range tags {
+		if ok, _ := path.Match(match, name); match != "" && !ok {
+			continue
+		}
+		if name > byID[id] {
+			byID[id] = name
+		}
+	}
+
+	e, err := r.entry(spec)
+	if err != nil {
+		return nil, err
+	}
+	d := &Description{ID: e.ID, Abbrev: r.Abbrev(e.ID)}
+	es, err := r.list(e.Stream)
+	if err != nil {
+		return nil, err
+	}
+	pos := len(es) - 1
+	for pos >= 0 && es[pos].ID != e.ID {
+		pos--
+	}
+	for i := pos; i >= 0; i-- {
+		if tag, ok := byID[es[i].ID]; ok {
+			d.Tag, d.Distance = tag, pos-i
+			break
+		}
+	}
+	return d, nil
+}
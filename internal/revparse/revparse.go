@@ -0,0 +1,329 @@
+// Package revparse turns commit-ish strings into commits. A commit-ish is
+//
+//	HEAD              the newest commit of the current stream, or the detached commit
+//	<stream>          the newest commit of a stream
+//	<tag>             the commit a tag in .evo/tags points to
+//	<id>              a full commit ID or a unique prefix of at least MinPrefix characters
+//	<commit-ish>~N    the commit N before it in its stream (~ alone means ~1)
+package revparse
+
+import (
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/everrors"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MinPrefix is the shortest commit ID prefix accepted
+const MinPrefix = 4
+
+// DefaultAbbrev is the length Abbrev starts from
+const DefaultAbbrev = 8
+
+var (
+	// ErrNotFound means nothing matches a commit-ish
+	ErrNotFound = everrors.New(everrors.ErrNotFound, "unknown revision")
+	// ErrAmbiguous means an ID prefix matches more than one commit
+	ErrAmbiguous = errors.New("ambiguous revision")
+)
+
+// Resolver resolves commit-ishes in one repository from the commit index,
+// loading only the commits it resolves
+type Resolver struct {
+	repoPath string
+	current  string
+	detached string
+	streams  []string
+	index    *commits.Index
+}
+
+// New creates a resolver; HEAD refers to the checked-out stream, or to the
+// checked-out commit while HEAD is detached
+func New(repoPath string) (*Resolver, error) {
+	head, err := repo.ReadHead(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current stream: %w", err)
+	}
+	r, err := NewInStream(repoPath, head.Stream)
+	if err != nil {
+		return nil, err
+	}
+	r.detached = head.Detached
+	return r, nil
+}
+
+// NewInStream creates a resolver for which HEAD refers to stream
+func NewInStream(repoPath, stream string) (*Resolver, error) {
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list streams: %w", err)
+	}
+	sort.Strings(names)
+	idx, err := commits.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		repoPath: repoPath,
+		current:  stream,
+		streams:  names,
+		index:    idx,
+	}, nil
+}
+
+// Resolve returns the commit a commit-ish names, and the stream it was found in
+func Resolve(repoPath, spec string) (*types.Commit, string, error) {
+	r, err := New(repoPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.Resolve(spec)
+}
+
+func (r *Resolver) list(stream string) ([]commits.IndexEntry, error) {
+	es, err := r.index.Stream(stream)
+	if err != nil {
+		return nil, err
+	}
+	// the index is a cache; a failed update only costs the next lookup time
+	r.index.Save()
+	return es, nil
+}
+
+// Resolve returns the commit a commit-ish names, and the stream it was found in
+func (r *Resolver) Resolve(spec string) (*types.Commit, string, error) {
+	e, err := r.entry(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := commits.LoadCommit(r.repoPath, e.Stream, e.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return c, e.Stream, nil
+}
+
+// ResolveID returns the full ID of the commit a commit-ish names
+func (r *Resolver) ResolveID(spec string) (string, error) {
+	e, err := r.entry(spec)
+	if err != nil {
+		return "", err
+	}
+	return e.ID, nil
+}
+
+// entry returns the index entry of the commit a commit-ish names
+func (r *Resolver) entry(spec string) (commits.IndexEntry, error) {
+	base, back, err := splitAncestry(spec)
+	if err != nil {
+		return commits.IndexEntry{}, err
+	}
+	stream, pos, err := r.base(base)
+	if err != nil {
+		return commits.IndexEntry{}, err
+	}
+	es, err := r.list(stream)
+	if err != nil {
+		return commits.IndexEntry{}, err
+	}
+	if pos-back < 0 {
+		return commits.IndexEntry{}, fmt.Errorf("%w: %s goes back past the first commit of %s", ErrNotFound, spec, stream)
+	}
+	return es[pos-back], nil
+}
+
+// splitAncestry splits "x~N" into x and N; "x~" is "x~1" and "x~2~3" is "x~5"
+func splitAncestry(spec string) (string, int, error) {
+	parts := strings.Split(spec, "~")
+	back := 0
+	for _, p := range parts[1:] {
+		n := 1
+		if p != "" {
+			var err error
+			n, err = strconv.Atoi(p)
+			if err != nil || n < 0 {
+				return "", 0, fmt.Errorf("%w: bad ancestry in %s", ErrNotFound, spec)
+			}
+		}
+		back += n
+	}
+	if parts[0] == "" {
+		return "", 0, fmt.Errorf("%w: empty revision", ErrNotFound)
+	}
+	return parts[0], back, nil
+}
+
+// base finds the stream and position of a commit-ish without ancestry
+func (r *Resolver) base(name string) (string, int, error) {
+	if name == "HEAD" {
+		if r.detached != "" {
+			return r.find(r.detached, true)
+		}
+		return r.tip(r.current)
+	}
+	if id, err := ReadTag(r.repoPath, name); err == nil {
+		return r.find(id, true)
+	} else if !os.IsNotExist(err) {
+		return "", 0, err
+	}
+	for _, s := range r.streams {
+		if s == name {
+			return r.tip(s)
+		}
+	}
+	return r.find(name, false)
+}
+
+func (r *Resolver) tip(stream string) (string, int, error) {
+	cs, err := r.list(stream)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(cs) == 0 {
+		return "", 0, fmt.Errorf("%w: stream %s has no commits", ErrNotFound, stream)
+	}
+	return stream, len(cs) - 1, nil
+}
+
+// find locates a commit by ID or unique ID prefix. A commit copied into
+// several streams by merges counts once and is preferably found in the
+// current stream.
+func (r *Resolver) find(prefix string, exact bool) (string, int, error) {
+	if !exact && len(prefix) < MinPrefix {
+		return "", 0, fmt.Errorf("%w: %s (IDs need at least %d characters)", ErrNotFound, prefix, MinPrefix)
+	}
+	type hit struct {
+		stream string
+		pos    int
+	}
+	matches := make(map[string]hit)
+	order := append([]string{r.current}, r.streams...)
+	for _, s := range order {
+		cs, err := r.list(s)
+		if err != nil {
+			return "", 0, err
+		}
+		for i, c := range cs {
+			if c.ID == prefix {
+				return s, i, nil
+			}
+			if exact || !strings.HasPrefix(c.ID, prefix) {
+				continue
+			}
+			if _, ok := matches[c.ID]; !ok {
+				matches[c.ID] = hit{s, i}
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", 0, fmt.Errorf("%w: %s", ErrNotFound, prefix)
+	case 1:
+		for _, h := range matches {
+			return h.stream, h.pos, nil
+		}
+	}
+	ids := make([]string, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return "", 0, fmt.Errorf("%w: %s matches %s", ErrAmbiguous, prefix, strings.Join(ids, ", "))
+}
+
+// Abbrev returns the shortest prefix of id, at least DefaultAbbrev long, that
+// no other commit in the repository shares
+func (r *Resolver) Abbrev(id string) string {
+	n := DefaultAbbrev
+	for _, s := range r.streams {
+		cs, err := r.list(s)
+		if err != nil {
+			continue
+		}
+		for _, c := range cs {
+			if c.ID == id {
+				continue
+			}
+			for n < len(id) && strings.HasPrefix(c.ID, id[:n]) {
+				n++
+			}
+		}
+	}
+	if n > len(id) {
+		n = len(id)
+	}
+	return id[:n]
+}
+
+func tagsDir(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "tags")
+}
+
+// ReadTag returns the commit ID stored in .evo/tags/<name>
+func ReadTag(repoPath, name string) (string, error) {
+	if !validTagName(name) {
+		return "", os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(tagsDir(repoPath), name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteTag points tag name at a commit ID
+func WriteTag(repoPath, name, id string) error {
+	if !validTagName(name) {
+		return fmt.Errorf("invalid tag name: %q", name)
+	}
+	if err := os.MkdirAll(tagsDir(repoPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tagsDir(repoPath), name), []byte(id+"\n"), 0644)
+}
+
+// DeleteTag removes a tag
+func DeleteTag(repoPath, name string) error {
+	if !validTagName(name) {
+		return fmt.Errorf("invalid tag name: %q", name)
+	}
+	return os.Remove(filepath.Join(tagsDir(repoPath), name))
+}
+
+// Tags returns every tag name and the commit ID it points to
+func Tags(repoPath string) (map[string]string, error) {
+	entries, err := os.ReadDir(tagsDir(repoPath))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		id, err := ReadTag(repoPath, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		out[e.Name()] = id
+	}
+	return out, nil
+}
+
+// validTagName rejects names that would escape .evo/tags or clash with the
+// commit-ish syntax
+func validTagName(name string) bool {
+	return name != "" && name != "HEAD" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\~ \t\n") && !strings.Contains(name, "..")
+}
@@ -0,0 +1,109 @@
+package revparse
+
+import (
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	assert.NoError(t, streams.CreateStream(rp, "feature"))
+
+	base := time.Now().Add(-time.Hour)
+	save := func(stream, id string, i int) {
+		c := &types.Commit{ID: id, Stream: stream, Message: id, Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		assert.NoError(t, commits.SaveCommitTo(rp, stream, c))
+	}
+	save("main", "aaaa1111-0000", 1)
+	save("main", "aaaa2222-0000", 2)
+	save("main", "bbbb3333-0000", 3)
+	save("feature", "cccc4444-0000", 4)
+	// a merged copy of a main commit must not make its prefix ambiguous
+	save("feature", "bbbb3333-0000", 3)
+	assert.NoError(t, WriteTag(rp, "v1", "aaaa2222-0000"))
+
+	r, err := New(rp)
+	assert.NoError(t, err)
+	cases := map[string]string{
+		"HEAD":          "bbbb3333-0000",
+		"HEAD~":         "aaaa2222-0000",
+		"HEAD~2":        "aaaa1111-0000",
+		"HEAD~1~1":      "aaaa1111-0000",
+		"main~1":        "aaaa2222-0000",
+		"feature":       "cccc4444-0000",
+		"v1":            "aaaa2222-0000",
+		"v1~1":          "aaaa1111-0000",
+		"bbbb":          "bbbb3333-0000",
+		"aaaa2":         "aaaa2222-0000",
+		"cccc4444-0000": "cccc4444-0000",
+	}
+	for spec, want := range cases {
+		got, err := r.ResolveID(spec)
+		if assert.NoError(t, err, spec) {
+			assert.Equal(t, want, got, spec)
+		}
+	}
+
+	_, err = r.ResolveID("aaaa")
+	assert.True(t, errors.Is(err, ErrAmbiguous))
+	_, err = r.ResolveID("dddd")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = r.ResolveID("aa")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = r.ResolveID("HEAD~3")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Equal(t, "aaaa1111", r.Abbrev("aaaa1111-0000"))
+	assert.Error(t, WriteTag(rp, "../x", "aaaa1111-0000"))
+	tags, err := Tags(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1": "aaaa2222-0000"}, tags)
+}
+
+func TestDescribe(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	base := time.Now().Add(-time.Hour)
+	for i, id := range []string{"aaaa0000-0000", "bbbb0000-0000", "cccc0000-0000", "dddd0000-0000"} {
+		c := &types.Commit{ID: id, Stream: "main", Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		assert.NoError(t, commits.SaveCommitTo(rp, "main", c))
+	}
+	r, err := New(rp)
+	assert.NoError(t, err)
+	d, err := r.Describe("HEAD", "")
+	assert.NoError(t, err)
+	assert.Empty(t, d.Tag)
+	assert.Equal(t, "dddd0000", d.Format(false))
+
+	assert.NoError(t, WriteTag(rp, "v1.0", "aaaa0000-0000"))
+	assert.NoError(t, WriteTag(rp, "v1.1", "bbbb0000-0000"))
+	assert.NoError(t, WriteTag(rp, "nightly", "bbbb0000-0000"))
+	cases := map[string]string{
+		"HEAD":   "v1.1-2-gdddd0000",
+		"HEAD~1": "v1.1-1-gcccc0000",
+		"bbbb":   "v1.1",
+		"aaaa":   "v1.0",
+	}
+	for spec, want := range cases {
+		d, err := r.Describe(spec, "v*")
+		if assert.NoError(t, err, spec) {
+			assert.Equal(t, want, d.Format(false), spec)
+		}
+	}
+	d, err = r.Describe("bbbb", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.1-0-gbbbb0000", d.Format(true))
+	d, err = r.Describe("HEAD", "v1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0-3-gdddd0000", d.Format(false))
+	_, err = r.Describe("HEAD", "[")
+	assert.Error(t, err)
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/history"
+	"evo/internal/identity"
+	"evo/internal/locks"
+	"evo/internal/merge"
+	"evo/internal/receive"
+	"evo/internal/review"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"evo/internal/webhook"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API lets CI systems and bots use the server without the CLI.
+// Every response is JSON; failures are {"error": "...", "reasons": [...]}
+// with a 4xx or 5xx status.
+//
+//	GET  /api/v1/streams                                   []Stream
+//	GET  /api/v1/streams/<stream>/commits?limit=N          []CommitInfo, newest first
+//	GET  /api/v1/streams/<stream>/commits/<rev>            CommitDetail
+//	GET  /api/v1/streams/<stream>/files/<rev>              []FileInfo
+//	GET  /api/v1/streams/<stream>/files/<rev>/<path>       FileContent (?raw=1 for text/plain)
+//	POST /api/v1/merges                                    MergeRequest => MergeResult
+//	GET  /api/v1/locks                                     []locks.Lock
+//	POST /api/v1/locks                                     locks.Lock => locks.Lock, 409 if held
+//	DELETE /api/v1/locks/<path>?email=E[&force=1]          locks.Lock
+//	GET  /api/v1/lfs/files                                 []lfs.FileInfo
+//	GET  /api/v1/lfs/files/<id>                            lfs.FileInfo
+//	PUT  /api/v1/lfs/files/<id>                            lfs.FileInfo => lfs.FileInfo, 409 if chunks are missing
+//	POST /api/v1/lfs/chunks/missing                        ChunkList => ChunkList of those not stored
+//	GET  /api/v1/lfs/chunks/<hash>                         chunk content, honouring Range
+//	PUT  /api/v1/lfs/chunks/<hash>                         chunk content
+//
+// <rev> is any commit-ish, with HEAD meaning the newest commit of <stream>.
+
+// Stream describes a stream
+type Stream struct {
+	Name    string      `json:"name"`
+	Current bool        `json:"current"`
+	Commits int         `json:"commits"`
+	Head    *CommitInfo `json:"head,omitempty"`
+}
+
+// CommitInfo is the metadata of a commit
+type CommitInfo struct {
+	ID          string          `json:"id"`
+	Stream      string          `json:"stream"`
+	Message     string          `json:"message"`
+	AuthorName  string          `json:"authorName"`
+	AuthorEmail string          `json:"authorEmail"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Ops         int             `json:"ops"`
+	Signed      bool            `json:"signed"`
+	PickedFrom  string          `json:"pickedFrom,omitempty"`
+	Trailers    []types.Trailer `json:"trailers,omitempty"`
+}
+
+// CommitDetail is a commit with its line changes
+type CommitDetail struct {
+	CommitInfo
+	Changes []history.FileChanges `json:"changes"`
+}
+
+// FileInfo describes a file as of a commit
+type FileInfo struct {
+	Path   string `json:"path"`
+	FileID string `json:"fileId"`
+	Lines  int    `json:"lines"`
+}
+
+// FileContent is a file as of a commit
+type FileContent struct {
+	Path    string `json:"path"`
+	FileID  string `json:"fileId"`
+	Commit  string `json:"commit"`
+	Content string `json:"content"`
+}
+
+// MergeRequest asks to merge source into target. Naming an open review
+// merges its streams instead, and requires its approvals; a merge through an
+// approved review may go into a protected stream.
+type MergeRequest struct {
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Strategy string `json:"strategy,omitempty"` // crdt (default), ours, theirs or union
+	Review   string `json:"review,omitempty"`
+}
+
+// MergeResult reports the commits a merge brought into its target
+type MergeResult struct {
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Review string   `json:"review,omitempty"`
+	Merged []string `json:"merged"`
+}
+
+func newCommitInfo(c *types.Commit) CommitInfo {
+	return CommitInfo{c.ID, c.Stream, c.Message, c.AuthorName, c.AuthorEmail, c.Timestamp, len(c.Operations), c.Signature != "", c.PickedFrom, c.Trailers}
+}
+
+// errBadRequest marks errors in what the client sent
+var errBadRequest = errors.New("bad request")
+
+func apiError(w http.ResponseWriter, err error) {
+	var rej *receive.Rejection
+	var held *locks.HeldError
+	switch {
+	case errors.As(err, &rej):
+		writeJSON(w, http.StatusForbidden, errorBody{Error: rej.Error(), Reasons: rej.Reasons})
+		return
+	case errors.Is(err, errBadRequest):
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: err.Error()})
+		return
+	case errors.As(err, &held):
+		writeJSON(w, http.StatusConflict, struct {
+			errorBody
+			Lock locks.Lock `json:"lock"`
+		}{errorBody{Error: held.Error()}, held.Lock})
+		return
+	case errors.Is(err, revparse.ErrNotFound), errors.Is(err, revparse.ErrAmbiguous), errors.Is(err, errNoStream), errors.Is(err, locks.ErrNotLocked):
+		writeJSON(w, http.StatusNotFound, errorBody{Error: err.Error()})
+		return
+	}
+	logger.Error("api request failed", "err", err)
+	writeJSON(w, http.StatusInternalServerError, errorBody{Error: err.Error()})
+}
+
+func (s *Server) routesAPI() {
+	s.mux.HandleFunc("GET /api/v1/streams", s.apiStreams)
+	s.mux.HandleFunc("GET /api/v1/streams/{stream}/commits", s.apiCommits)
+	s.mux.HandleFunc("GET /api/v1/streams/{stream}/commits/{rev}", s.apiCommit)
+	s.mux.HandleFunc("GET /api/v1/streams/{stream}/files/{rev}", s.apiFiles)
+	s.mux.HandleFunc("GET /api/v1/streams/{stream}/files/{rev}/{path...}", s.apiFile)
+	s.mux.HandleFunc("POST /api/v1/merges", s.apiMerge)
+	s.mux.HandleFunc("GET /api/v1/locks", s.apiLocks)
+	s.mux.HandleFunc("POST /api/v1/locks", s.apiLock)
+	s.mux.HandleFunc("DELETE /api/v1/locks/{path...}", s.apiUnlock)
+}
+
+func (s *Server) apiStreams(w http.ResponseWriter, r *http.Request) {
+	names, err := streams.ListStreams(s.repoPath)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	cur, _ := streams.CurrentStream(s.repoPath)
+	out := []Stream{}
+	for _, name := range names {
+		cs, err := commits.ListCommits(s.repoPath, name)
+		if err != nil {
+			apiError(w, err)
+			return
+		}
+		st := Stream{Name: name, Current: name == cur, Commits: len(cs)}
+		if len(cs) > 0 {
+			head := newCommitInfo(&cs[len(cs)-1])
+			st.Head = &head
+		}
+		out = append(out, st)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) apiCommits(w http.ResponseWriter, r *http.Request) {
+	stream, err := s.stream(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			apiError(w, fmt.Errorf("%w: invalid limit %q", errBadRequest, v))
+			return
+		}
+	}
+	cs, err := commits.ListCommits(s.repoPath, stream)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	out := []CommitInfo{}
+	for i := len(cs) - 1; i >= 0 && (limit == 0 || len(out) < limit); i-- {
+		out = append(out, newCommitInfo(&cs[i]))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) apiCommit(w http.ResponseWriter, r *http.Request) {
+	_, c, err := s.resolve(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	changes, err := history.Changes(s.repoPath, c)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CommitDetail{newCommitInfo(c), changes})
+}
+
+func (s *Server) snapshot(r *http.Request) (*history.Snapshot, error) {
+	stream, c, err := s.resolve(r)
+	if err != nil {
+		return nil, err
+	}
+	return history.At(s.repoPath, stream, c.ID)
+}
+
+func (s *Server) apiFiles(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.snapshot(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	out := []FileInfo{}
+	for _, p := range snap.Paths() {
+		f := snap.Files[p]
+		out = append(out, FileInfo{f.Path, f.FileID, len(f.Lines)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) apiFile(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.snapshot(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	f, ok := snap.Files[r.PathValue("path")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: fmt.Sprintf("no file %s at %s", r.PathValue("path"), snap.Commit.ID)})
+		return
+	}
+	var b strings.Builder
+	for _, l := range f.Lines {
+		b.WriteString(l.Content)
+		b.WriteByte('\n')
+	}
+	if r.URL.Query().Get("raw") != "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(b.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, FileContent{f.Path, f.FileID, snap.Commit.ID, b.String()})
+}
+
+func (s *Server) apiMerge(w http.ResponseWriter, r *http.Request) {
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, fmt.Errorf("%w: invalid merge request: %v", errBadRequest, err))
+		return
+	}
+	res, err := s.Merge(req)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Merge merges one stream into another, checking the commits it brings in
+// against the target's receive policy like a push
+func (s *Server) Merge(req MergeRequest) (*MergeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Strategy == "" {
+		req.Strategy = "crdt"
+	}
+	st, err := merge.ParseStrategy(req.Strategy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	var rv *review.Review
+	if req.Review != "" {
+		id, err := review.Resolve(s.repoPath, req.Review)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		if rv, err = review.Get(s.repoPath, id); err != nil {
+			return nil, err
+		}
+		if rv.State != review.StateOpen {
+			return nil, fmt.Errorf("%w: review %s is %s", errBadRequest, rv.ID[:8], rv.State)
+		}
+		if (req.Source != "" && req.Source != rv.Source) || (req.Target != "" && req.Target != rv.Target) {
+			return nil, fmt.Errorf("%w: review %s merges %s into %s", errBadRequest, rv.ID[:8], rv.Source, rv.Target)
+		}
+		req.Source, req.Target = rv.Source, rv.Target
+		if err := review.CheckApprovals(s.repoPath, rv); err != nil {
+			return nil, &receive.Rejection{Stream: rv.Target, Reasons: []string{err.Error()}}
+		}
+	}
+	if req.Source == "" || req.Target == "" {
+		return nil, fmt.Errorf("%w: source and target are required", errBadRequest)
+	}
+	for _, name := range []string{req.Source, req.Target} {
+		if err := s.checkStream(name); err != nil {
+			return nil, err
+		}
+	}
+	if req.Source == req.Target {
+		return nil, fmt.Errorf("%w: source and target are both %s", errBadRequest, req.Source)
+	}
+	missing, err := streams.MissingCommits(s.repoPath, req.Source, req.Target)
+	if err != nil {
+		return nil, err
+	}
+	p, err := receive.Load(s.repoPath, req.Target)
+	if err != nil {
+		return nil, err
+	}
+	// an approved review reaches a protected stream only where
+	// receive.allowReviewedMerges says so; CheckApprovals has already
+	// checked its approvals against the trusted reviewer keys
+	p.Protected = p.Protected && !(rv != nil && p.AllowReviewed)
+	if err := p.Check(s.repoPath, missing); err != nil {
+		return nil, err
+	}
+	if err := streams.MergeStreamsWithStrategy(context.Background(), s.repoPath, req.Source, req.Target, st); err != nil {
+		return nil, err
+	}
+	res := &MergeResult{Source: req.Source, Target: req.Target, Merged: []string{}}
+	for _, c := range missing {
+		res.Merged = append(res.Merged, c.ID)
+	}
+	if rv != nil {
+		who, err := identity.Current(s.repoPath)
+		if err != nil {
+			who = identity.Fallback
+		}
+		if err := review.SetState(s.repoPath, rv.ID, review.StateMerged, review.Author{Name: who.Name, Email: who.Email}); err != nil {
+			return nil, err
+		}
+		res.Review = rv.ID
+	}
+	ev := webhook.NewEvent(s.repoPath, webhook.EventMerge, req.Target, missing)
+	ev.Source, ev.Review = req.Source, res.Review
+	s.hooks.Emit(ev)
+	return res, nil
+}
+
+func (s *Server) apiLocks(w http.ResponseWriter, r *http.Request) {
+	ls, err := locks.List(s.repoPath)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	if ls == nil {
+		ls = []locks.Lock{}
+	}
+	writeJSON(w, http.StatusOK, ls)
+}
+
+func (s *Server) apiLock(w http.ResponseWriter, r *http.Request) {
+	var l locks.Lock
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		apiError(w, fmt.Errorf("%w: invalid lock: %v", errBadRequest, err))
+		return
+	}
+	if !filepath.IsLocal(l.Path) || l.Email == "" {
+		apiError(w, fmt.Errorf("%w: a lock needs a path in the repository and an email", errBadRequest))
+		return
+	}
+	l.Remote = ""
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held, err := locks.Acquire(s.repoPath, l)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, held)
+}
+
+func (s *Server) apiUnlock(w http.ResponseWriter, r *http.Request) {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, err := locks.Release(s.repoPath, r.PathValue("path"), r.URL.Query().Get("email"), force)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
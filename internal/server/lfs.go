@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"evo/internal/lfs"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The LFS endpoints move large files chunk by chunk, so an interrupted
+// transfer resumes with the chunks the other side still lacks: a pusher asks
+// which chunks are missing, puts them and then links the file; a puller gets
+// the file's info and then each chunk it lacks, with a Range header to
+// continue a chunk cut off halfway. The content of a file can also be read
+// whole or, with a Range header, in part, as a viewer needing the header of
+// a media file does.
+
+// ChunkList is a list of chunk hashes
+type ChunkList struct {
+	Chunks []string `json:"chunks"`
+}
+
+func (s *Server) routesLFS() {
+	s.mux.HandleFunc("GET /api/v1/lfs/files", s.apiLFSFiles)
+	s.mux.HandleFunc("GET /api/v1/lfs/files/{id}", s.apiLFSFile)
+	s.mux.HandleFunc("PUT /api/v1/lfs/files/{id}", s.apiLFSLink)
+	s.mux.HandleFunc("GET /api/v1/lfs/files/{id}/content", s.apiLFSContent)
+	s.mux.HandleFunc("POST /api/v1/lfs/chunks/missing", s.apiLFSMissing)
+	s.mux.HandleFunc("GET /api/v1/lfs/chunks/{hash}", s.apiLFSChunk)
+	s.mux.HandleFunc("PUT /api/v1/lfs/chunks/{hash}", s.apiLFSPutChunk)
+}
+
+// lfsID returns the file ID of a request
+func lfsID(r *http.Request) (string, error) {
+	id := r.PathValue("id")
+	if !filepath.IsLocal(id) || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("%w: invalid file ID %q", errBadRequest, id)
+	}
+	return id, nil
+}
+
+func (s *Server) apiLFSFiles(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.store.Files()
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	if infos == nil {
+		infos = []*lfs.FileInfo{}
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) apiLFSFile(w http.ResponseWriter, r *http.Request) {
+	id, err := lfsID(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	info, err := s.store.Info(id)
+	if os.IsNotExist(err) {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: fmt.Sprintf("no large file %s", id)})
+		return
+	}
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) apiLFSContent(w http.ResponseWriter, r *http.Request) {
+	id, err := lfsID(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	info, err := s.store.Info(id)
+	if os.IsNotExist(err) {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: fmt.Sprintf("no large file %s", id)})
+		return
+	}
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	off, n, ok := parseRange(r.Header.Get("Range"), info.Size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		writeJSON(w, http.StatusRequestedRangeNotSatisfiable, errorBody{Error: "invalid range " + r.Header.Get("Range")})
+		return
+	}
+	var head bytes.Buffer
+	if err := s.store.ReadFileRange(id, 0, 512, &head); err != nil {
+		apiError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head.Bytes()))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", fmt.Sprint(n))
+	status := http.StatusOK
+	if n < info.Size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, off+n-1, info.Size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		if err := s.store.ReadFileRange(id, off, n, w); err != nil {
+			logger.Warn("failed to send large file content", "id", id, "error", err)
+		}
+	}
+}
+
+// parseRange returns the offset and length of the bytes a Range header asks
+// for out of size: one range, as bytes=a-b, bytes=a- or bytes=-n. No header,
+// or several ranges, asks for every byte.
+func parseRange(header string, size int64) (off, n int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if header == "" || found && strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last, dash := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found || !dash {
+		return 0, 0, false
+	}
+	end := size - 1
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		off = max(size-suffix, 0)
+	} else {
+		var err error
+		if off, err = strconv.ParseInt(first, 10, 64); err != nil || off < 0 || off >= size {
+			return 0, 0, false
+		}
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < off {
+				return 0, 0, false
+			}
+			end = min(end, size-1)
+		}
+	}
+	return off, end - off + 1, true
+}
+
+func (s *Server) apiLFSLink(w http.ResponseWriter, r *http.Request) {
+	id, err := lfsID(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	var info lfs.FileInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		apiError(w, fmt.Errorf("%w: invalid file info: %v", errBadRequest, err))
+		return
+	}
+	linked, err := s.store.Link(id, &info)
+	var missing *lfs.MissingChunksError
+	switch {
+	case errors.As(err, &missing):
+		writeJSON(w, http.StatusConflict, struct {
+			errorBody
+			ChunkList
+		}{errorBody{Error: err.Error()}, ChunkList{missing.Chunks}})
+	case errors.Is(err, lfs.ErrCorrupt):
+		apiError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+	case err != nil:
+		apiError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, linked)
+	}
+}
+
+func (s *Server) apiLFSMissing(w http.ResponseWriter, r *http.Request) {
+	var req ChunkList
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, fmt.Errorf("%w: invalid chunk list: %v", errBadRequest, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ChunkList{s.store.Missing(req.Chunks)})
+}
+
+func (s *Server) apiLFSChunk(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if !lfs.IsHash(hash) {
+		apiError(w, fmt.Errorf("%w: invalid chunk hash %q", errBadRequest, hash))
+		return
+	}
+	f, err := s.store.OpenChunk(hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: fmt.Sprintf("no chunk %s", hash)})
+		return
+	}
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	defer f.Close()
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// a chunk from a remote backend; chunks are small enough to hold
+		data, err := io.ReadAll(f)
+		if err != nil {
+			apiError(w, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	// ServeContent answers Range requests with 206 and just the bytes asked for
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, hash, time.Time{}, content)
+}
+
+func (s *Server) apiLFSPutChunk(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if !lfs.IsHash(hash) {
+		apiError(w, fmt.Errorf("%w: invalid chunk hash %q", errBadRequest, hash))
+		return
+	}
+	if err := s.store.PutChunk(hash, r.Body); errors.Is(err, lfs.ErrCorrupt) {
+		apiError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	} else if err != nil {
+		apiError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"evo/internal/commits"
+	"evo/internal/eventlog"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/receive"
+	"evo/internal/repo"
+	"evo/internal/review"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"evo/internal/webhook"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+var logger = log.For("server")
+
+// Server serves one repository over HTTP: a read-only web UI, a JSON API,
+// and pushes, which are checked against the receive policy of their stream
+// and applied one at a time. Pulls fetch a stream's whole history.
+type Server struct {
+	repoPath string
+	mux      *http.ServeMux
+	mu       sync.Mutex // serializes writes to the repository
+	hooks    *webhook.Dispatcher
+	store    *lfs.Store
+}
+
+// PushRequest is the body of a push: the pusher's history of the stream,
+// oldest first, and the paths of the files it changes by file ID. Commits
+// the server already has are skipped.
+type PushRequest struct {
+	Commits []types.Commit    `json:"commits"`
+	Paths   map[string]string `json:"paths,omitempty"`
+}
+
+// PullResponse is the body of a pull: the server's history of the stream,
+// oldest first, and the paths of the files it changes by file ID
+type PullResponse struct {
+	Commits []types.Commit    `json:"commits"`
+	Paths   map[string]string `json:"paths,omitempty"`
+}
+
+// ReviewSync is the body of a review sync both ways: the sender's review
+// event logs by review ID and, in the response, how many events the server
+// lacked
+type ReviewSync struct {
+	Reviews map[string][]eventlog.Event `json:"reviews"`
+	Added   int                         `json:"added"`
+}
+
+// PushResult reports the commits a push added
+type PushResult struct {
+	Stream   string   `json:"stream"`
+	Received []string `json:"received"`
+}
+
+// New returns a server for the repository at repoPath
+func New(repoPath string) *Server {
+	s := &Server{repoPath: repoPath, mux: http.NewServeMux(), hooks: webhook.NewDispatcher(repoPath), store: lfs.NewStore(repoPath)}
+	s.mux.HandleFunc("POST /push/{stream}", s.handlePush)
+	s.mux.HandleFunc("GET /pull/{stream}", s.handlePull)
+	s.mux.HandleFunc("POST /reviews", s.handleReviews)
+	s.routesWeb()
+	s.routesAPI()
+	s.routesLFS()
+	return s
+}
+
+// Lock takes the lock that serializes writes to the repository, for writers
+// besides the server such as a mirror
+func (s *Server) Lock() { s.mu.Lock() }
+
+// Unlock releases the lock Lock takes
+func (s *Server) Unlock() { s.mu.Unlock() }
+
+// Wait blocks until pending webhook deliveries have finished
+func (s *Server) Wait() {
+	s.hooks.Wait()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	s.mux.ServeHTTP(w, r)
+}
+
+// errorBody is the JSON body of a failed request
+type errorBody struct {
+	Error   string   `json:"error"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	stream := r.PathValue("stream")
+	if !repo.ValidStreamName(stream) {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: fmt.Sprintf("invalid stream name %q", stream)})
+		return
+	}
+	var req PushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: fmt.Sprintf("invalid push: %v", err)})
+		return
+	}
+	res, err := s.Push(stream, req.Commits, req.Paths)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
+	stream, err := s.stream(r)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	history, err := streams.ListCommits(s.repoPath, stream)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	paths, err := streams.Paths(s.repoPath, history)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PullResponse{Commits: history, Paths: paths})
+}
+
+// Push checks and applies a pushed history of stream, remembering the paths
+// it gives files the server has none for
+func (s *Server) Push(stream string, history []types.Commit, paths map[string]string) (*PushResult, error) {
+	for _, c := range history {
+		if !commits.ValidID(c.ID) {
+			return nil, fmt.Errorf("%w: invalid commit ID %q", errBadRequest, c.ID)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	incoming, err := streams.Unreceived(s.repoPath, stream, history)
+	if err != nil {
+		return nil, err
+	}
+	res := &PushResult{Stream: stream, Received: []string{}}
+	if len(incoming) == 0 {
+		s.learnPaths(paths)
+		return res, nil
+	}
+	if err := receive.Check(s.repoPath, stream, incoming); err != nil {
+		logger.Info("push rejected", "stream", stream, "commits", len(incoming), "err", err)
+		return nil, err
+	}
+	applied, err := streams.Receive(s.repoPath, stream, incoming)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range applied {
+		res.Received = append(res.Received, c.ID)
+	}
+	s.learnPaths(paths)
+	s.hooks.Emit(webhook.NewEvent(s.repoPath, webhook.EventPush, stream, applied))
+	return res, nil
+}
+
+func (s *Server) handleReviews(w http.ResponseWriter, r *http.Request) {
+	var req ReviewSync
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: fmt.Sprintf("invalid review sync: %v", err)})
+		return
+	}
+	res, err := s.SyncReviews(req.Reviews)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// SyncReviews merges the review events a client sent and returns every
+// review the server has, for the client to merge in turn. Approvals travel
+// as they are; whether one counts is up to the trusted reviewer keys of the
+// repository checking it.
+func (s *Server) SyncReviews(recs map[string][]eventlog.Event) (*ReviewSync, error) {
+	for id := range recs {
+		if !eventlog.ValidID(id) {
+			return nil, fmt.Errorf("%w: invalid review ID %q", errBadRequest, id)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added, err := review.Merge(s.repoPath, recs)
+	if err != nil {
+		return nil, err
+	}
+	all, err := review.Records(s.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("reviews synced", "added", added)
+	return &ReviewSync{Reviews: all, Added: added}, nil
+}
+
+// learnPaths remembers pushed paths, so the API and web views name the
+// files pushed by their paths. Failing to costs only the names.
+func (s *Server) learnPaths(paths map[string]string) {
+	if err := index.LearnPaths(s.repoPath, paths); err != nil {
+		logger.Warn("failed to remember pushed paths", "err", err)
+	}
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/history"
+	"evo/internal/locks"
+	"evo/internal/remotes"
+	"evo/internal/repo"
+	"evo/internal/review"
+	"evo/internal/signing"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"evo/internal/webhook"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func push(t *testing.T, srv http.Handler, stream string, cs ...types.Commit) (*httptest.ResponseRecorder, map[string]any) {
+	body, _ := json.Marshal(PushRequest{Commits: cs})
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/push/"+stream, bytes.NewReader(body)))
+	var out map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	return w, out
+}
+
+func TestPush(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	srv := New(rp)
+
+	c1 := types.Commit{ID: "c1", Stream: "main", Message: "one", Timestamp: time.Now()}
+	c2 := types.Commit{ID: "c2", Stream: "main", Message: "two", Timestamp: time.Now().Add(time.Second)}
+	w, out := push(t, srv, "main", c1)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []any{"c1"}, out["received"])
+
+	// the whole history is pushed; only new commits are applied
+	w, out = push(t, srv, "main", c1, c2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []any{"c2"}, out["received"])
+	cs, err := streams.ListCommits(rp, "main")
+	assert.NoError(t, err)
+	assert.Len(t, cs, 2)
+
+	t.Run("New Stream", func(t *testing.T) {
+		w, _ := push(t, srv, "feature", c1)
+		assert.Equal(t, http.StatusOK, w.Code)
+		cs, _ := streams.ListCommits(rp, "feature")
+		assert.Len(t, cs, 1)
+	})
+
+	t.Run("Protected", func(t *testing.T) {
+		assert.NoError(t, config.SetConfigValue(rp, "stream.main.receive.protected", "true"))
+		c3 := types.Commit{ID: "c3", Stream: "main", Timestamp: time.Now().Add(2 * time.Second)}
+		w, out := push(t, srv, "main", c1, c2, c3)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, []any{"stream main is protected"}, out["reasons"])
+		cs, _ := streams.ListCommits(rp, "main")
+		assert.Len(t, cs, 2)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		w, _ := push(t, srv, "a%2F..%2Fb", c1)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Hostile ID", func(t *testing.T) {
+		for _, id := range []string{"../../../escaped", "..", ".hidden", ""} {
+			bad := types.Commit{ID: id, Stream: "main", Timestamp: time.Now().Add(2 * time.Second)}
+			w, _ := push(t, srv, "escape", c1, bad)
+			assert.Equal(t, http.StatusBadRequest, w.Code, id)
+		}
+		_, err := os.Stat(filepath.Join(rp, "escaped.bin"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(rp, ".evo", "commits", "escape"))
+		assert.True(t, os.IsNotExist(err), "nothing of a refused push is written")
+	})
+
+	t.Run("Webhook", func(t *testing.T) {
+		events := make(chan webhook.Event, 1)
+		hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ev webhook.Event
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+			events <- ev
+		}))
+		defer hook.Close()
+		assert.NoError(t, config.SetConfigValue(rp, "webhook.ci.url", hook.URL))
+		c4 := types.Commit{ID: "c4", Stream: "feature", Timestamp: time.Now().Add(3 * time.Second)}
+		w, _ := push(t, srv, "feature", c1, c4)
+		assert.Equal(t, http.StatusOK, w.Code)
+		srv.hooks.Wait()
+		ev := <-events
+		assert.Equal(t, webhook.EventPush, ev.Type)
+		assert.Equal(t, "feature", ev.Stream)
+		if assert.Len(t, ev.Commits, 1) {
+			assert.Equal(t, "c4", ev.Commits[0].ID)
+		}
+	})
+}
+
+func TestWeb(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	fid, line := uuid.New(), uuid.New()
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" src/a.txt\n"), 0644))
+	c := &types.Commit{ID: uuid.New().String(), Stream: "main", Message: "Add <a>\n\nbody", AuthorName: "Ann", Timestamp: time.Now(),
+		Operations: []types.ExtendedOp{{Op: crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: uuid.New(), FileID: fid, LineID: line, OriginLineID: crdt.DocumentStart, Content: "hello"}}}}
+	assert.NoError(t, commits.SaveCommitTo(rp, "main", c))
+	srv := New(rp)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+	for path, want := range map[string]string{
+		"/":                                  `href="/log/main"`,
+		"/log/main":                          "Add &lt;a&gt;",
+		"/commit/main/" + c.ID[:8]:           "hello",
+		"/tree/main/HEAD":                    `href="/file/main/` + c.ID + `/src/a.txt"`,
+		"/file/main/HEAD/src/a.txt":          "hello",
+		"/blame/main/" + c.ID + "/src/a.txt": "Ann",
+	} {
+		code, body := get(path)
+		assert.Equal(t, http.StatusOK, code, path)
+		assert.Contains(t, body, want, path)
+	}
+	for _, path := range []string{"/log/nope", "/commit/main/zzzzzz", "/file/main/HEAD/missing.txt"} {
+		code, _ := get(path)
+		assert.Equal(t, http.StatusNotFound, code, path)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	assert.NoError(t, streams.CreateStream(rp, "feature"))
+	fid := uuid.New()
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" a.txt\n"), 0644))
+	var ids []string
+	for i, line := range []string{"one", "two"} {
+		c := &types.Commit{ID: uuid.New().String(), Stream: "feature", Message: line, AuthorName: "Ann", AuthorEmail: "ann@example.com", Timestamp: time.Now().Add(time.Duration(i) * time.Second),
+			Operations: []types.ExtendedOp{{Op: crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i + 1), NodeID: uuid.New(), FileID: fid, LineID: uuid.New(), Content: line}}}}
+		assert.NoError(t, commits.SaveCommitTo(rp, "feature", c))
+		ids = append(ids, c.ID)
+	}
+	srv := New(rp)
+	call := func(method, path string, body any, out any) int {
+		var rd *bytes.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			rd = bytes.NewReader(data)
+		} else {
+			rd = bytes.NewReader(nil)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, rd))
+		if out != nil {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), out), path)
+		}
+		return w.Code
+	}
+
+	var sts []Stream
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/streams", nil, &sts))
+	assert.Len(t, sts, 2)
+
+	var cs []CommitInfo
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/streams/feature/commits?limit=1", nil, &cs))
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, ids[1], cs[0].ID)
+		assert.Equal(t, 1, cs[0].Ops)
+	}
+	assert.Equal(t, http.StatusBadRequest, call("GET", "/api/v1/streams/feature/commits?limit=x", nil, nil))
+
+	var cd CommitDetail
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/streams/feature/commits/HEAD~1", nil, &cd))
+	assert.Equal(t, ids[0], cd.ID)
+	assert.Equal(t, []history.FileChanges{{Path: "a.txt", Changes: []history.Change{{Kind: "+", Content: "one"}}}}, cd.Changes)
+
+	var fc FileContent
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/streams/feature/files/HEAD/a.txt", nil, &fc))
+	assert.Equal(t, "one\ntwo\n", fc.Content)
+	assert.Equal(t, http.StatusNotFound, call("GET", "/api/v1/streams/nope/commits", nil, nil))
+	assert.Equal(t, http.StatusNotFound, call("GET", "/api/v1/streams/feature/files/HEAD/b.txt", nil, nil))
+
+	t.Run("Merge", func(t *testing.T) {
+		assert.NoError(t, config.SetConfigValue(rp, "stream.main.receive.protected", "true"))
+		var res MergeResult
+		var eb errorBody
+		assert.Equal(t, http.StatusForbidden, call("POST", "/api/v1/merges", MergeRequest{Source: "feature", Target: "main"}, &eb))
+		assert.Equal(t, []string{"stream main is protected"}, eb.Reasons)
+		assert.Equal(t, http.StatusBadRequest, call("POST", "/api/v1/merges", MergeRequest{Source: "feature", Target: "main", Strategy: "bogus"}, nil))
+
+		// a review reaches the protected stream only when approved by a
+		// trusted reviewer and allowed by receive.allowReviewedMerges
+		rv, err := review.Open(rp, "", "feature", "main", review.Author{Name: "Ann", Email: "ann@example.com"})
+		assert.NoError(t, err)
+		assert.NoError(t, config.SetConfigValue(rp, "stream.main.receive.allowReviewedMerges", "true"))
+		assert.Equal(t, http.StatusForbidden, call("POST", "/api/v1/merges", MergeRequest{Review: rv.ID[:8]}, nil), "unapproved")
+
+		// a key nobody trusts signs an approval in Bo's name
+		bo := review.Author{Name: "Bo", Email: "bo@example.com"}
+		assert.NoError(t, config.SetConfigValue(rp, "signing.keyPath", filepath.Join(rp, "forged")))
+		assert.NoError(t, signing.GenerateKeyPair(rp))
+		_, err = review.Approve(rp, rv.ID, "", bo)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, call("POST", "/api/v1/merges", MergeRequest{Review: rv.ID[:8]}, &eb), "forged")
+		assert.Contains(t, eb.Reasons[0], "0 of 1 required approvals")
+
+		assert.NoError(t, config.SetConfigValue(rp, "signing.keyPath", filepath.Join(rp, "key")))
+		assert.NoError(t, signing.GenerateKeyPair(rp))
+		kp, err := signing.LoadKeyPair(rp)
+		assert.NoError(t, err)
+		assert.NoError(t, review.Trust(rp, bo.Email, kp.PublicKey))
+		_, err = review.Approve(rp, rv.ID, "", bo)
+		assert.NoError(t, err)
+		assert.NoError(t, config.SetConfigValue(rp, "stream.main.receive.allowReviewedMerges", "false"))
+		assert.Equal(t, http.StatusForbidden, call("POST", "/api/v1/merges", MergeRequest{Review: rv.ID[:8]}, &eb), "not allowed")
+		assert.Equal(t, []string{"stream main is protected"}, eb.Reasons)
+		assert.NoError(t, config.SetConfigValue(rp, "stream.main.receive.allowReviewedMerges", "true"))
+		assert.Equal(t, http.StatusOK, call("POST", "/api/v1/merges", MergeRequest{Review: rv.ID[:8]}, &res))
+		assert.Equal(t, ids, res.Merged)
+		rv, _ = review.Get(rp, rv.ID)
+		assert.Equal(t, review.StateMerged, rv.State)
+	})
+}
+
+func TestLocks(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	ts := httptest.NewServer(New(rp))
+	defer ts.Close()
+	origin := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	ann := locks.Lock{Path: "art/logo.psd", Name: "Ann", Email: "ann@example.com"}
+	l, err := locks.AcquireRemote(origin, ann)
+	assert.NoError(t, err)
+	assert.Equal(t, "origin", l.Remote)
+
+	_, err = locks.AcquireRemote(origin, locks.Lock{Path: ann.Path, Name: "Bob", Email: "bob@example.com"})
+	var held *locks.HeldError
+	assert.ErrorAs(t, err, &held)
+	assert.Equal(t, "Ann", held.Lock.Name)
+
+	ls, err := locks.ListRemote(origin)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 1)
+
+	_, err = locks.ReleaseRemote(origin, ann.Path, "bob@example.com", false)
+	assert.ErrorAs(t, err, &held)
+	_, err = locks.ReleaseRemote(origin, ann.Path, ann.Email, false)
+	assert.NoError(t, err)
+	_, err = locks.ReleaseRemote(origin, ann.Path, ann.Email, false)
+	assert.ErrorIs(t, err, locks.ErrNotLocked)
+
+	_, err = locks.AcquireRemote(origin, locks.Lock{Path: "../x", Email: ann.Email})
+	var re *remotes.Error
+	assert.ErrorAs(t, err, &re)
+	assert.Equal(t, http.StatusBadRequest, re.Status)
+}
+
+func TestLFSContent(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+	srv := New(rp)
+	fid := uuid.New()
+	content := []byte("%PDF-1.7 " + strings.Repeat("page ", 1000))
+	_, err := srv.store.StoreFile(fid.String(), bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+
+	get := func(rng string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/lfs/files/"+fid.String()+"/content", nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Equal(t, content, w.Body.Bytes())
+	for rng, want := range map[string][2]int{"bytes=0-7": {0, 8}, "bytes=9-": {9, len(content)}, "bytes=-5": {len(content) - 5, len(content)}} {
+		w := get(rng)
+		assert.Equal(t, http.StatusPartialContent, w.Code, rng)
+		assert.Equal(t, content[want[0]:want[1]], w.Body.Bytes(), rng)
+		assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", want[0], want[1]-1, len(content)), w.Header().Get("Content-Range"), rng)
+	}
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get(fmt.Sprintf("bytes=%d-", len(content))).Code)
+	assert.Equal(t, http.StatusNotFound, httpGet(srv, "/api/v1/lfs/files/"+uuid.NewString()+"/content").Code)
+
+	// the file page shows the start of the content instead of the stub
+	assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" doc.pdf\n"), 0644))
+	stub := fmt.Sprintf("EVO-LFS:%s:%d", fid, len(content))
+	c := &types.Commit{ID: uuid.New().String(), Stream: "main", Message: "Add doc", Timestamp: time.Now(),
+		Operations: []types.ExtendedOp{{Op: crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: uuid.New(), FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: stub}}}}
+	assert.NoError(t, commits.SaveCommitTo(rp, "main", c))
+	w = httpGet(srv, "/file/main/HEAD/doc.pdf")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), fmt.Sprintf("Large file, %d bytes", len(content)))
+	assert.Contains(t, w.Body.String(), "%PDF-1.7 page")
+	assert.Contains(t, w.Body.String(), "Only the start of the file is shown")
+	assert.NotContains(t, w.Body.String(), stub)
+}
+
+func httpGet(srv http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
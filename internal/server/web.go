@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"evo/internal/commits"
+	"evo/internal/history"
+	"evo/internal/revparse"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The web UI is a read-only view of the repository rendered from templates
+// embedded in the binary:
+//
+//	/                             streams
+//	/log/<stream>                 commits of a stream, newest first
+//	/commit/<stream>/<rev>        a commit and its line changes
+//	/tree/<stream>/<rev>          files as of a commit
+//	/file/<stream>/<rev>/<path>   a file as of a commit
+//	/blame/<stream>/<rev>/<path>  the same, with the commit that wrote each line
+//
+// <rev> is any commit-ish, with HEAD meaning the newest commit of <stream>.
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var funcs = template.FuncMap{
+	"abbrev": func(id string) string {
+		if len(id) > revparse.DefaultAbbrev {
+			return id[:revparse.DefaultAbbrev]
+		}
+		return id
+	},
+	"subject": func(msg string) string {
+		s, _, _ := strings.Cut(msg, "\n")
+		return s
+	},
+	"date": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"inc":  func(i int) int { return i + 1 },
+}
+
+var pages = make(map[string]*template.Template)
+
+func init() {
+	for _, name := range []string{"streams", "log", "commit", "tree", "file"} {
+		pages[name] = template.Must(template.New(name).Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+	}
+}
+
+// page is the data every page gets
+type page struct {
+	Title  string
+	Repo   string
+	Stream string
+}
+
+func (s *Server) routesWeb() {
+	s.mux.HandleFunc("GET /{$}", s.webStreams)
+	s.mux.HandleFunc("GET /log/{stream}", s.webLog)
+	s.mux.HandleFunc("GET /commit/{stream}/{rev}", s.webCommit)
+	s.mux.HandleFunc("GET /tree/{stream}/{rev}", s.webTree)
+	s.mux.HandleFunc("GET /file/{stream}/{rev}/{path...}", s.webFile(false))
+	s.mux.HandleFunc("GET /blame/{stream}/{rev}/{path...}", s.webFile(true))
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := pages[name].ExecuteTemplate(&buf, "layout", data); err != nil {
+		logger.Error("rendering page", "page", name, "err", err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+func (s *Server) page(title, stream string) page {
+	return page{Title: title, Repo: filepath.Base(s.repoPath), Stream: stream}
+}
+
+// webError reports a failed lookup; unknown revisions are 404s
+func webError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, revparse.ErrNotFound) || errors.Is(err, revparse.ErrAmbiguous) || errors.Is(err, errNoStream) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+var errNoStream = errors.New("no such stream")
+
+// checkStream reports a stream that doesn't exist as errNoStream
+func (s *Server) checkStream(name string) error {
+	all, err := streams.ListStreams(s.repoPath)
+	if err != nil {
+		return err
+	}
+	for _, st := range all {
+		if st == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errNoStream, name)
+}
+
+// stream returns the stream named in the request if it exists
+func (s *Server) stream(r *http.Request) (string, error) {
+	name := r.PathValue("stream")
+	return name, s.checkStream(name)
+}
+
+// resolve returns the commit the request's rev names, with HEAD meaning the
+// newest commit of the request's stream
+func (s *Server) resolve(r *http.Request) (string, *types.Commit, error) {
+	stream, err := s.stream(r)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := revparse.NewInStream(s.repoPath, stream)
+	if err != nil {
+		return "", nil, err
+	}
+	c, found, err := res.Resolve(r.PathValue("rev"))
+	if err != nil {
+		return "", nil, err
+	}
+	return found, c, nil
+}
+
+func (s *Server) webStreams(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		Name    string
+		Current bool
+		Commits int
+		Head    *types.Commit
+	}
+	names, err := streams.ListStreams(s.repoPath)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	cur, _ := streams.CurrentStream(s.repoPath)
+	var rows []row
+	for _, name := range names {
+		cs, err := commits.ListCommits(s.repoPath, name)
+		if err != nil {
+			webError(w, err)
+			return
+		}
+		rw := row{Name: name, Current: name == cur, Commits: len(cs)}
+		if len(cs) > 0 {
+			rw.Head = &cs[len(cs)-1]
+		}
+		rows = append(rows, rw)
+	}
+	s.render(w, "streams", struct {
+		page
+		Streams []row
+	}{s.page("Streams", ""), rows})
+}
+
+func (s *Server) webLog(w http.ResponseWriter, r *http.Request) {
+	stream, err := s.stream(r)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	cs, err := commits.ListCommits(s.repoPath, stream)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+		cs[i], cs[j] = cs[j], cs[i]
+	}
+	s.render(w, "log", struct {
+		page
+		Commits []types.Commit
+	}{s.page("Log of "+stream, stream), cs})
+}
+
+func (s *Server) webCommit(w http.ResponseWriter, r *http.Request) {
+	stream, c, err := s.resolve(r)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	changes, err := history.Changes(s.repoPath, c)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	s.render(w, "commit", struct {
+		page
+		Commit  *types.Commit
+		Changes []history.FileChanges
+	}{s.page("Commit "+c.ID, stream), c, changes})
+}
+
+func (s *Server) webTree(w http.ResponseWriter, r *http.Request) {
+	stream, c, err := s.resolve(r)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	snap, err := history.At(s.repoPath, stream, c.ID)
+	if err != nil {
+		webError(w, err)
+		return
+	}
+	var files []*history.File
+	for _, p := range snap.Paths() {
+		files = append(files, snap.Files[p])
+	}
+	s.render(w, "tree", struct {
+		page
+		Snapshot *history.Snapshot
+		Files    []*history.File
+	}{s.page("Files at "+c.ID, stream), snap, files})
+}
+
+func (s *Server) webFile(blame bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, c, err := s.resolve(r)
+		if err != nil {
+			webError(w, err)
+			return
+		}
+		snap, err := history.At(s.repoPath, stream, c.ID)
+		if err != nil {
+			webError(w, err)
+			return
+		}
+		f, ok := snap.Files[r.PathValue("path")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("no file %s at %s", r.PathValue("path"), c.ID), http.StatusNotFound)
+			return
+		}
+		// blame names the commit at the start of each run of lines it wrote
+		var authors []*types.Commit
+		if blame {
+			authors = make([]*types.Commit, len(f.Lines))
+			for i, l := range f.Lines {
+				if i > 0 && f.Lines[i-1].Commit == l.Commit {
+					continue
+				}
+				if bc, ok := snap.CommitOf(l.Commit); ok {
+					authors[i] = &bc
+				}
+			}
+		}
+		s.render(w, "file", struct {
+			page
+			Snapshot *history.Snapshot
+			File     *history.File
+			Blame    bool
+			Authors  []*types.Commit
+			Large    *largeFile
+		}{s.page(f.Path, stream), snap, f, blame, authors, s.largeFile(f)})
+	}
+}
+
+// previewSize is how much of a large file its page shows
+const previewSize = 4096
+
+// largeFile is the page of a file kept in the LFS store
+type largeFile struct {
+	ID      string
+	Size    int64
+	Stored  bool   // whether the store holds this version of the content
+	Text    string // the start of the content, if it is text
+	Hex     string // or else a hex dump of it
+	Partial bool   // whether the content goes on past the preview
+}
+
+// largeFile returns what to show of f instead of its single line, the stub
+// EVO-LFS:<fileID>:<size>, when it is a large file. The store holds only the
+// newest content of each file, so older versions are shown by size alone.
+func (s *Server) largeFile(f *history.File) *largeFile {
+	if len(f.Lines) != 1 {
+		return nil
+	}
+	stub, ok := strings.CutPrefix(f.Lines[0].Content, "EVO-LFS:")
+	if !ok {
+		return nil
+	}
+	id, size, _ := strings.Cut(stub, ":")
+	lf := &largeFile{ID: id}
+	lf.Size, _ = strconv.ParseInt(size, 10, 64)
+	info, err := s.store.Info(id)
+	if err != nil || size != "" && info.Size != lf.Size {
+		return lf
+	}
+	lf.Size = info.Size
+	var head bytes.Buffer
+	if err := s.store.ReadFileRange(id, 0, previewSize, &head); err != nil {
+		return lf
+	}
+	lf.Stored, lf.Partial = true, info.Size > previewSize
+	text := head.Bytes()
+	// the preview may end inside a character
+	for i := 0; lf.Partial && i < utf8.UTFMax-1 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if utf8.Valid(text) && !bytes.ContainsRune(text, 0) {
+		lf.Text = string(text)
+	} else {
+		lf.Hex = hex.Dump(head.Bytes()[:min(head.Len(), 512)])
+		lf.Partial = info.Size > 512
+	}
+	return lf
+}
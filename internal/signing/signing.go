@@ -127,6 +127,67 @@ func VerifyCommit(c *types.Commit, repoPath string) (bool, error) {
 	return true, nil
 }
 
+// SignBytes signs msg with the configured key and returns the signature and
+// public key, hex encoded, so the signature can be checked without the repo
+func SignBytes(repoPath string, msg []byte) (sig, pub string, err error) {
+	kp, err := LoadKeyPair(repoPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load signing key: %w", err)
+	}
+	return hex.EncodeToString(ed25519.Sign(kp.PrivateKey, msg)), hex.EncodeToString(kp.PublicKey), nil
+}
+
+// VerifyBytes checks a hex signature made by SignBytes against a hex public key
+func VerifyBytes(pub, sig string, msg []byte) bool {
+	pk, err := hex.DecodeString(pub)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		return false
+	}
+	sb, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pk, msg, sb)
+}
+
+// LoadPublicKeys reads every .pub file in dir, as written by GenerateKeyPair
+func LoadPublicKeys(dir string) ([]ed25519.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+	var keys []ed25519.PublicKey
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".pub" {
+			continue
+		}
+		pub, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key length in %s: %d", e.Name(), len(pub))
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+// VerifyCommitKeys reports whether a commit is signed by one of keys
+func VerifyCommitKeys(c *types.Commit, keys []ed25519.PublicKey) bool {
+	sig, err := hex.DecodeString(c.Signature)
+	if c.Signature == "" || err != nil {
+		return false
+	}
+	msg := []byte(types.CommitHashString(c))
+	for _, k := range keys {
+		if ed25519.Verify(k, msg, sig) {
+			return true
+		}
+	}
+	return false
+}
+
 func getKeyPath(repoPath string) (string, error) {
 	keyPath, err := config.GetConfigValue(repoPath, "signing.keyPath")
 	if err != nil {
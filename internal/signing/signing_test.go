@@ -74,6 +74,22 @@ func TestSigningKeyPair(t *testing.T) {
 		}
 	})
 
+	t.Run("Trailers_Are_Signed", func(t *testing.T) {
+		commit := &types.Commit{
+			Message:  "Test commit",
+			Trailers: []types.Trailer{{Key: "Reviewed-by", Value: "Ann <ann@example.com>"}},
+		}
+		sig, err := SignCommit(commit, tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to sign commit: %v", err)
+		}
+		commit.Signature = sig
+		commit.Trailers[0].Value = "Mallory <m@example.com>"
+		if valid, _ := VerifyCommit(commit, tmpDir); valid {
+			t.Error("Signature still valid after changing a trailer")
+		}
+	})
+
 	t.Run("Invalid_Signature", func(t *testing.T) {
 		commit := &types.Commit{
 			Message:   "Test commit",
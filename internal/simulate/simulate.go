@@ -0,0 +1,359 @@
+// Package simulate runs several replicas of one repository side by side:
+// each makes random line edits and commits them, replicas sync with their
+// neighbours in a topology in random order, and once every replica has seen
+// every commit their working trees must be identical. Replicas are ordinary
+// repositories in a temporary directory, synced the way a push is received
+// (streams.Receive) and checked out with checkout.Attach, so the simulation
+// exercises the real merge path.
+package simulate
+
+import (
+	"context"
+	"evo/internal/checkout"
+	"evo/internal/commits"
+	"evo/internal/fsck"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/log"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var logger = log.For("simulate")
+
+// stream is the stream every replica edits and syncs
+const stream = "main"
+
+// Topology returns the pairs of replicas, out of n, that sync with each
+// other; a pair syncs both ways
+type Topology func(n int) [][2]int
+
+// Mesh lets every replica sync with every other
+func Mesh(n int) [][2]int {
+	var out [][2]int
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			out = append(out, [2]int{i, j})
+		}
+	}
+	return out
+}
+
+// Ring lets each replica sync with the next, the last with the first
+func Ring(n int) [][2]int {
+	if n < 3 {
+		return Line(n)
+	}
+	out := Line(n)
+	return append(out, [2]int{n - 1, 0})
+}
+
+// Line lets each replica sync with the next only
+func Line(n int) [][2]int {
+	var out [][2]int
+	for i := 1; i < n; i++ {
+		out = append(out, [2]int{i - 1, i})
+	}
+	return out
+}
+
+// Star lets every replica sync with the first only, like clients of a server
+func Star(n int) [][2]int {
+	var out [][2]int
+	for i := 1; i < n; i++ {
+		out = append(out, [2]int{0, i})
+	}
+	return out
+}
+
+// Options describes a simulation
+type Options struct {
+	Replicas int
+	Files    int     // files of the shared starting commit
+	Lines    int     // lines per file
+	Steps    int     // edits and syncs Run makes
+	Sync     float64 // share of steps that sync two replicas
+	Topology Topology
+	Seed     int64 // the same seed runs the same script
+}
+
+// DefaultOptions is a small simulation of four replicas in a mesh
+var DefaultOptions = Options{Replicas: 4, Files: 3, Lines: 10, Steps: 60, Sync: 0.3, Topology: Mesh, Seed: 1}
+
+// Sim is a running simulation
+type Sim struct {
+	Dir      string   // holds the replicas
+	Replicas []string // repository paths
+	o        Options
+	rnd      *rand.Rand
+	edges    [][2]int
+	files    []string // tracked paths, the same in every replica
+	edits    int
+}
+
+// New creates o.Replicas replicas in a temporary directory, each a copy of a
+// repository holding one commit of o.Files files with its own node identity
+func New(o Options) (*Sim, error) {
+	if o.Replicas < 1 || o.Files < 1 || o.Lines < 1 {
+		return nil, fmt.Errorf("replicas, files and lines must be at least 1")
+	}
+	if o.Topology == nil {
+		o.Topology = Mesh
+	}
+	dir, err := os.MkdirTemp("", "evo-simulate-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Sim{Dir: dir, o: o, rnd: rand.New(rand.NewSource(o.Seed)), edges: o.Topology(o.Replicas)}
+	if err := s.init(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Sim) init() error {
+	first := filepath.Join(s.Dir, "r0")
+	if err := repo.InitRepo(first); err != nil {
+		return err
+	}
+	for i := range s.o.Files {
+		rel := fmt.Sprintf("f%03d.txt", i)
+		lines := make([]string, s.o.Lines)
+		for j := range lines {
+			lines[j] = fmt.Sprintf("%s line %d", rel, j)
+		}
+		if err := os.WriteFile(filepath.Join(first, rel), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		s.files = append(s.files, rel)
+	}
+	if err := commit(first, "start", "r0"); err != nil {
+		return err
+	}
+	s.Replicas = append(s.Replicas, first)
+	for i := 1; i < s.o.Replicas; i++ {
+		rp := filepath.Join(s.Dir, fmt.Sprintf("r%d", i))
+		if err := copyDir(first, rp); err != nil {
+			return fmt.Errorf("failed to copy replica: %w", err)
+		}
+		// a fresh node identity is created on first use
+		if err := os.Remove(filepath.Join(repo.Dir(rp), "node")); err != nil {
+			return err
+		}
+		s.Replicas = append(s.Replicas, rp)
+	}
+	return nil
+}
+
+// Close removes the replicas
+func (s *Sim) Close() error {
+	return os.RemoveAll(s.Dir)
+}
+
+// commit records the working tree as evo commit does
+func commit(rp, msg, author string) error {
+	if err := index.UpdateIndex(rp); err != nil {
+		return err
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), rp, stream); err != nil {
+		return err
+	}
+	eops, err := commits.GatherNewOps(rp, stream)
+	if err != nil {
+		return err
+	}
+	if len(eops) == 0 {
+		return nil
+	}
+	_, err = commits.CreateCommit(rp, stream, msg, author, author+"@example.com", eops, false)
+	return err
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// Edit makes one to three random line edits to a file of replica i and
+// commits them. A file keeps at least one line: paths don't travel with
+// commits, so a file removed from one working tree would stay untracked
+// there when another replica brings its lines back.
+func (s *Sim) Edit(i int) error {
+	rp := s.Replicas[i]
+	rel := s.files[s.rnd.Intn(len(s.files))]
+	abs := filepath.Join(rp, rel)
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for range 1 + s.rnd.Intn(3) {
+		s.edits++
+		text := fmt.Sprintf("r%d edit %d", i, s.edits)
+		at := s.rnd.Intn(len(lines))
+		switch k := s.rnd.Intn(3); {
+		case k == 0 && len(lines) > 1:
+			lines = append(lines[:at], lines[at+1:]...)
+		case k == 1:
+			lines[at] = text
+		default:
+			lines = append(lines[:at+1], append([]string{text}, lines[at+1:]...)...)
+		}
+	}
+	if err := os.WriteFile(abs, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := commit(rp, fmt.Sprintf("edit %d", s.edits), fmt.Sprintf("r%d", i)); err != nil {
+		return fmt.Errorf("replica %d: failed to commit: %w", i, err)
+	}
+	return nil
+}
+
+// Sync receives the commits of replica from that replica to lacks, as a push
+// from it would, and rewrites to's working tree
+func (s *Sim) Sync(from, to int) error {
+	cs, err := streams.ListCommits(s.Replicas[from], stream)
+	if err != nil {
+		return err
+	}
+	applied, err := streams.Receive(s.Replicas[to], stream, cs)
+	if err != nil {
+		return fmt.Errorf("replica %d: failed to receive from %d: %w", to, from, err)
+	}
+	if _, err := checkout.Attach(s.Replicas[to], stream, true); err != nil {
+		return fmt.Errorf("replica %d: failed to check out: %w", to, err)
+	}
+	logger.Debug("synced", "from", from, "to", to, "commits", len(applied))
+	return nil
+}
+
+// Run makes o.Steps random steps: an edit by any replica, or with chance
+// o.Sync a sync in either direction between a pair of the topology
+func (s *Sim) Run() error {
+	for range s.o.Steps {
+		if len(s.edges) > 0 && s.rnd.Float64() < s.o.Sync {
+			e := s.edges[s.rnd.Intn(len(s.edges))]
+			if s.rnd.Intn(2) == 0 {
+				e[0], e[1] = e[1], e[0]
+			}
+			if err := s.Sync(e[0], e[1]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.Edit(s.rnd.Intn(len(s.Replicas))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converge syncs every pair of the topology both ways, in random order, as
+// many rounds as there are replicas, which carries every commit across any
+// connected topology, then checks the replicas with Verify
+func (s *Sim) Converge() error {
+	for range len(s.Replicas) {
+		for _, i := range s.rnd.Perm(len(s.edges)) {
+			e := s.edges[i]
+			if err := s.Sync(e[0], e[1]); err != nil {
+				return err
+			}
+			if err := s.Sync(e[1], e[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Verify()
+}
+
+// Tree returns the tracked files of replica i's working tree by path
+func (s *Sim) Tree(i int) (map[string]string, error) {
+	p2id, _, err := index.LoadIndex(s.Replicas[i])
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(p2id))
+	for rel := range p2id {
+		data, err := os.ReadFile(filepath.Join(s.Replicas[i], rel))
+		if err != nil {
+			return nil, err
+		}
+		out[rel] = string(data)
+	}
+	return out, nil
+}
+
+// Verify checks that every replica passes fsck and has the working tree of
+// the first
+func (s *Sim) Verify() error {
+	var want map[string]string
+	for i, rp := range s.Replicas {
+		r, err := fsck.Check(rp)
+		if err != nil {
+			return err
+		}
+		if len(r.Problems) > 0 {
+			return fmt.Errorf("replica %d: %s", i, r.Problems[0])
+		}
+		tree, err := s.Tree(i)
+		if err != nil {
+			return fmt.Errorf("replica %d: %w", i, err)
+		}
+		if i == 0 {
+			want = tree
+			continue
+		}
+		if path, ok := differ(want, tree); ok {
+			return fmt.Errorf("replica %d differs from replica 0 in %s:\n%s\nvs\n%s", i, path, tree[path], want[path])
+		}
+	}
+	return nil
+}
+
+// differ returns the first path, in order, whose content differs
+func differ(a, b map[string]string) (string, bool) {
+	paths := make(map[string]bool)
+	for p := range a {
+		paths[p] = true
+	}
+	for p := range b {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, p := range sorted {
+		ca, oka := a[p]
+		cb, okb := b[p]
+		if oka != okb || ca != cb {
+			return p, true
+		}
+	}
+	return "", false
+}
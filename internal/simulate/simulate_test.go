@@ -0,0 +1,49 @@
+package simulate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopologies(t *testing.T) {
+	assert.Equal(t, [][2]int{{0, 1}, {0, 2}, {1, 2}}, Mesh(3))
+	assert.Equal(t, [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 0}}, Ring(4))
+	assert.Equal(t, [][2]int{{0, 1}, {0, 2}}, Star(3))
+	assert.Equal(t, [][2]int{{0, 1}}, Ring(2))
+}
+
+func TestConverge(t *testing.T) {
+	seeds := int64(3)
+	if testing.Short() {
+		seeds = 1
+	}
+	for name, topo := range map[string]Topology{"Mesh": Mesh, "Ring": Ring, "Line": Line, "Star": Star} {
+		t.Run(name, func(t *testing.T) {
+			for seed := range seeds {
+				o := DefaultOptions
+				o.Topology, o.Seed = topo, seed
+				s, err := New(o)
+				require.NoError(t, err)
+				require.NoError(t, s.Run(), "seed %d", seed)
+				require.NoError(t, s.Converge(), "seed %d", seed)
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	s, err := New(Options{Replicas: 3, Files: 1, Lines: 3, Seed: 1})
+	require.NoError(t, err)
+	defer s.Close()
+	// every replica edits before any syncs
+	for i := range s.Replicas {
+		for range 3 {
+			require.NoError(t, s.Edit(i))
+		}
+	}
+	assert.Error(t, s.Verify())
+	require.NoError(t, s.Converge())
+}
@@ -0,0 +1,372 @@
+package stats
+
+import (
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/util"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Thresholds behind the suggestions of a size report
+const (
+	// a file whose history takes more than this is worth a look even when
+	// it's no longer tracked
+	purgeMinBytes = 1 << 20
+	// logs with fewer lines aren't worth compacting, whatever their ratio
+	compactMinLines = 100
+	// the share of deleted lines above which compaction pays off
+	compactMinRatio = 0.5
+)
+
+// SizeReport breaks down what the repository takes on disk
+type SizeReport struct {
+	Total       int64          `json:"total"`
+	Areas       []AreaSize     `json:"areas"` // by directory under .evo
+	Files       []FileSize     `json:"files"`
+	LFS         []LFSObject    `json:"lfs"`
+	Streams     []StreamSize   `json:"streams"`
+	Tombstones  TombstoneStats `json:"tombstones"`
+	Suggestions []Suggestion   `json:"suggestions"`
+}
+
+// AreaSize is what one directory of the repository takes
+type AreaSize struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// FileSize is what the history of one file takes across all streams: its op
+// logs and its shared op store
+type FileSize struct {
+	FileID  string `json:"fileId"`
+	Path    string `json:"path,omitempty"` // empty when the file is no longer indexed
+	Bytes   int64  `json:"bytes"`
+	Ops     int    `json:"ops"`
+	Lines   int    `json:"lines"`   // lines ever inserted, in the stream logs holding the most
+	Deleted int    `json:"deleted"` // of those, lines since deleted
+}
+
+// TombstoneRatio is the share of a file's lines that are deleted
+func (f FileSize) TombstoneRatio() float64 {
+	if f.Lines == 0 {
+		return 0
+	}
+	return float64(f.Deleted) / float64(f.Lines)
+}
+
+// LFSObject is one file in large file storage
+type LFSObject struct {
+	FileID string `json:"fileId"`
+	Path   string `json:"path,omitempty"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// StreamSize is what a stream's op logs and commits take. Op stores are
+// shared between streams and counted with the files instead.
+type StreamSize struct {
+	Name        string `json:"name"`
+	OpBytes     int64  `json:"opBytes"`
+	CommitBytes int64  `json:"commitBytes"`
+}
+
+// Bytes is the total of a stream's op logs and commits
+func (s StreamSize) Bytes() int64 { return s.OpBytes + s.CommitBytes }
+
+// TombstoneStats counts deleted lines across every op log
+type TombstoneStats struct {
+	Lines   int     `json:"lines"`
+	Deleted int     `json:"deleted"`
+	Ratio   float64 `json:"ratio"`
+}
+
+// Suggestion is a way to reclaim space, with the command that does it
+type Suggestion struct {
+	Kind    string `json:"kind"` // "lfs", "compact" or "purge"
+	Target  string `json:"target,omitempty"`
+	Reason  string `json:"reason"`
+	Command string `json:"command"`
+}
+
+// Size measures what each part of the repository at repoPath takes on disk
+// and suggests how to reclaim space: storing big text-tracked files as large
+// files, compacting logs that are mostly deleted lines, and purging the
+// history of big files that are no longer tracked.
+func Size(repoPath string) (*SizeReport, error) {
+	r := &SizeReport{}
+	evoDir := repo.Dir(repoPath)
+	entries, err := os.ReadDir(evoDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		n, err := diskUsage(filepath.Join(evoDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		r.Areas = append(r.Areas, AreaSize{Name: e.Name(), Bytes: n})
+		r.Total += n
+	}
+	sort.Slice(r.Areas, func(i, j int) bool { return r.Areas[i].Bytes > r.Areas[j].Bytes })
+
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list streams: %w", err)
+	}
+	sort.Strings(names)
+	stored, err := commits.Stored(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	commitBytes := make(map[string]int64)
+	for key, size := range stored {
+		commitBytes[strings.Split(key, "/")[1]] += size
+	}
+	files := make(map[string]*FileSize)
+	for _, name := range names {
+		ss := StreamSize{Name: name, CommitBytes: commitBytes[name]}
+		logs, err := ops.ListLogs(filepath.Join(evoDir, "ops", name))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range logs {
+			all, err := ops.LoadAllOps(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", path, err)
+			}
+			fid := strings.TrimSuffix(filepath.Base(path), ".bin")
+			f, ok := files[fid]
+			if !ok {
+				f = &FileSize{FileID: fid, Path: id2path[fid]}
+				if fi, err := os.Stat(ops.StorePath(path)); err == nil {
+					f.Bytes = fi.Size()
+				}
+				files[fid] = f
+			}
+			size := ops.LogSize(path)
+			f.Bytes += size
+			f.Ops += len(all)
+			if lines, deleted := tombstones(all); lines > f.Lines {
+				f.Lines, f.Deleted = lines, deleted
+			}
+			ss.OpBytes += size
+		}
+		r.Streams = append(r.Streams, ss)
+	}
+	sort.Slice(r.Streams, func(i, j int) bool {
+		if r.Streams[i].Bytes() != r.Streams[j].Bytes() {
+			return r.Streams[i].Bytes() > r.Streams[j].Bytes()
+		}
+		return r.Streams[i].Name < r.Streams[j].Name
+	})
+	for _, f := range files {
+		r.Files = append(r.Files, *f)
+		r.Tombstones.Lines += f.Lines
+		r.Tombstones.Deleted += f.Deleted
+	}
+	if r.Tombstones.Lines > 0 {
+		r.Tombstones.Ratio = float64(r.Tombstones.Deleted) / float64(r.Tombstones.Lines)
+	}
+	sort.Slice(r.Files, func(i, j int) bool {
+		if r.Files[i].Bytes != r.Files[j].Bytes {
+			return r.Files[i].Bytes > r.Files[j].Bytes
+		}
+		return r.Files[i].FileID < r.Files[j].FileID
+	})
+
+	infos, err := lfs.NewStore(repoPath).Files()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS store: %w", err)
+	}
+	for _, info := range infos {
+		r.LFS = append(r.LFS, LFSObject{FileID: info.ID, Path: id2path[info.ID], Bytes: info.Size})
+	}
+	sort.Slice(r.LFS, func(i, j int) bool {
+		if r.LFS[i].Bytes != r.LFS[j].Bytes {
+			return r.LFS[i].Bytes > r.LFS[j].Bytes
+		}
+		return r.LFS[i].FileID < r.LFS[j].FileID
+	})
+
+	r.suggest(largeThreshold(repoPath))
+	return r, nil
+}
+
+// tombstones counts the lines a log inserts and how many of them it deletes
+func tombstones(all []crdt.Operation) (lines, deleted int) {
+	inserted := make(map[uuid.UUID]bool)
+	gone := make(map[uuid.UUID]bool)
+	for _, op := range all {
+		switch op.Type {
+		case crdt.OpInsert:
+			inserted[op.LineID] = true
+		case crdt.OpDelete:
+			gone[op.LineID] = true
+		}
+	}
+	for id := range gone {
+		if inserted[id] {
+			deleted++
+		}
+	}
+	return len(inserted), deleted
+}
+
+// largeThreshold is the size above which ingest stores a file as a large file
+func largeThreshold(repoPath string) int64 {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return 1_000_000
+	}
+	n, err := cfg.Size("files.largeThreshold")
+	if err != nil || n <= 0 {
+		return 1_000_000
+	}
+	return n
+}
+
+func (r *SizeReport) suggest(threshold int64) {
+	r.Suggestions = []Suggestion{}
+	for _, f := range r.Files {
+		if f.Path != "" && f.Bytes > threshold {
+			r.Suggestions = append(r.Suggestions, Suggestion{
+				Kind:    "lfs",
+				Target:  f.Path,
+				Reason:  fmt.Sprintf("its history takes %s, more than files.largeThreshold (%s)", util.HumanBytes(f.Bytes), util.HumanBytes(threshold)),
+				Command: "evo config set files.largeThreshold <smaller size>",
+			})
+		}
+	}
+	sparse := 0
+	for _, f := range r.Files {
+		if f.Lines >= compactMinLines && f.TombstoneRatio() >= compactMinRatio {
+			sparse++
+		}
+	}
+	if sparse > 0 {
+		r.Suggestions = append(r.Suggestions, Suggestion{
+			Kind:    "compact",
+			Reason:  fmt.Sprintf("%d file(s) are mostly deleted lines", sparse),
+			Command: "evo maintenance run",
+		})
+	}
+	for _, f := range r.Files {
+		if f.Path == "" && f.Bytes >= purgeMinBytes {
+			r.Suggestions = append(r.Suggestions, Suggestion{
+				Kind:    "purge",
+				Target:  f.FileID,
+				Reason:  fmt.Sprintf("no longer tracked, but its history takes %s", util.HumanBytes(f.Bytes)),
+				Command: "evo purge -f --path " + f.FileID,
+			})
+		}
+	}
+	for _, o := range r.LFS {
+		if o.Path == "" && o.Bytes >= purgeMinBytes {
+			r.Suggestions = append(r.Suggestions, Suggestion{
+				Kind:    "purge",
+				Target:  o.FileID,
+				Reason:  fmt.Sprintf("large file no longer tracked, taking %s", util.HumanBytes(o.Bytes)),
+				Command: "evo purge -f --path " + o.FileID,
+			})
+		}
+	}
+}
+
+// diskUsage adds up the sizes of the files at or under path
+func diskUsage(path string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Format renders the size report as text, listing at most top entries of
+// each kind
+func (r *SizeReport) Format(top int) string {
+	var sb strings.Builder
+	limit := func(n int) int {
+		if top > 0 && n > top {
+			return top
+		}
+		return n
+	}
+	name := func(path, fid string) string {
+		if path == "" {
+			return fid + " (not indexed)"
+		}
+		return path
+	}
+
+	sb.WriteString(fmt.Sprintf("Total: %s\n", util.HumanBytes(r.Total)))
+	for _, a := range r.Areas {
+		sb.WriteString(fmt.Sprintf("  %-14s %10s\n", a.Name, util.HumanBytes(a.Bytes)))
+	}
+	sb.WriteString("\n")
+
+	if len(r.Files) > 0 {
+		sb.WriteString("Largest files by history:\n")
+		for _, f := range r.Files[:limit(len(r.Files))] {
+			sb.WriteString(fmt.Sprintf("  %10s %8d ops %4.0f%% deleted  %s\n",
+				util.HumanBytes(f.Bytes), f.Ops, 100*f.TombstoneRatio(), name(f.Path, f.FileID)))
+		}
+		sb.WriteString("\n")
+	}
+	if len(r.LFS) > 0 {
+		sb.WriteString("Largest large files:\n")
+		for _, o := range r.LFS[:limit(len(r.LFS))] {
+			sb.WriteString(fmt.Sprintf("  %10s  %s\n", util.HumanBytes(o.Bytes), name(o.Path, o.FileID)))
+		}
+		sb.WriteString("\n")
+	}
+	if len(r.Streams) > 0 {
+		sb.WriteString("Streams:\n")
+		for _, s := range r.Streams[:limit(len(r.Streams))] {
+			sb.WriteString(fmt.Sprintf("  %-20s %10s (ops %s, commits %s)\n",
+				s.Name, util.HumanBytes(s.Bytes()), util.HumanBytes(s.OpBytes), util.HumanBytes(s.CommitBytes)))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("Deleted lines: %d of %d (%.0f%%)\n", r.Tombstones.Deleted, r.Tombstones.Lines, 100*r.Tombstones.Ratio))
+
+	if len(r.Suggestions) > 0 {
+		sb.WriteString("\nSuggestions:\n")
+		for _, s := range r.Suggestions {
+			target := ""
+			if s.Target != "" {
+				target = s.Target + ": "
+			}
+			sb.WriteString(fmt.Sprintf("  %s%s\n    %s\n", target, s.Reason, s.Command))
+		}
+	}
+	return sb.String()
+}
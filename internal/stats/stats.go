@@ -0,0 +1,249 @@
+package stats
+
+import (
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/maintenance"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/util"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report holds repository metrics
+type Report struct {
+	Commits    int              `json:"commits"` // distinct commits across all streams
+	Ops        int              `json:"ops"`
+	Streams    []StreamStats    `json:"streams"`
+	Authors    []AuthorStats    `json:"authors"`
+	Files      []FileStats      `json:"files"`
+	LFS        LFSStats         `json:"lfs"`
+	Compaction *CompactionStats `json:"compaction,omitempty"`
+}
+
+// StreamStats counts a stream's commits and ops
+type StreamStats struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+	Ops     int    `json:"ops"`
+}
+
+// AuthorStats counts the distinct commits of one author
+type AuthorStats struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+}
+
+// FileStats counts the ops of one file across all streams
+type FileStats struct {
+	FileID string `json:"fileId"`
+	Path   string `json:"path,omitempty"` // empty when the file is no longer indexed
+	Ops    int    `json:"ops"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// LFSStats describes large file storage. LogicalBytes is what the stored
+// files add up to, StoredBytes what their chunks take on disk.
+type LFSStats struct {
+	Files        int     `json:"files"`
+	Chunks       int     `json:"chunks"`
+	LogicalBytes int64   `json:"logicalBytes"`
+	StoredBytes  int64   `json:"storedBytes"`
+	DedupRatio   float64 `json:"dedupRatio"`
+}
+
+// CompactionStats is what the last maintenance run saved
+type CompactionStats struct {
+	LastRun     time.Time `json:"lastRun"`
+	OpsBefore   int       `json:"opsBefore"`
+	OpsAfter    int       `json:"opsAfter"`
+	BytesBefore int64     `json:"bytesBefore"`
+	BytesAfter  int64     `json:"bytesAfter"`
+	BytesSaved  int64     `json:"bytesSaved"`
+}
+
+// Compute gathers the metrics of the repository at repoPath
+func Compute(repoPath string) (*Report, error) {
+	r := &Report{}
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list streams: %w", err)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	authors := make(map[string]*AuthorStats)
+	files := make(map[string]*FileStats)
+	for _, name := range names {
+		cs, err := commits.ListCommits(repoPath, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits of %s: %w", name, err)
+		}
+		ss := StreamStats{Name: name, Commits: len(cs)}
+		for _, c := range cs {
+			if seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+			key := c.AuthorName + " <" + c.AuthorEmail + ">"
+			a, ok := authors[key]
+			if !ok {
+				a = &AuthorStats{Name: c.AuthorName, Email: c.AuthorEmail}
+				authors[key] = a
+			}
+			a.Commits++
+		}
+
+		logs, err := ops.ListLogs(filepath.Join(repo.Dir(repoPath), "ops", name))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range logs {
+			all, err := ops.LoadAllOps(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", path, err)
+			}
+			fid := strings.TrimSuffix(filepath.Base(path), ".bin")
+			fs, ok := files[fid]
+			if !ok {
+				fs = &FileStats{FileID: fid}
+				files[fid] = fs
+			}
+			fs.Ops += len(all)
+			fs.Bytes += ops.LogSize(path)
+			ss.Ops += len(all)
+		}
+		r.Ops += ss.Ops
+		r.Streams = append(r.Streams, ss)
+	}
+	r.Commits = len(seen)
+
+	for _, a := range authors {
+		r.Authors = append(r.Authors, *a)
+	}
+	sort.Slice(r.Authors, func(i, j int) bool {
+		if r.Authors[i].Commits != r.Authors[j].Commits {
+			return r.Authors[i].Commits > r.Authors[j].Commits
+		}
+		return r.Authors[i].Name < r.Authors[j].Name
+	})
+
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	for fid, fs := range files {
+		fs.Path = id2path[fid]
+		r.Files = append(r.Files, *fs)
+	}
+	sort.Slice(r.Files, func(i, j int) bool {
+		if r.Files[i].Ops != r.Files[j].Ops {
+			return r.Files[i].Ops > r.Files[j].Ops
+		}
+		return r.Files[i].FileID < r.Files[j].FileID
+	})
+
+	if err := r.computeLFS(repoPath); err != nil {
+		return nil, err
+	}
+
+	st, err := maintenance.LoadState(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if lr := st.LastRun; lr != nil && lr.Before != nil && lr.After != nil {
+		r.Compaction = &CompactionStats{
+			LastRun:     lr.Started,
+			OpsBefore:   lr.Before.Ops,
+			OpsAfter:    lr.After.Ops,
+			BytesBefore: lr.Before.OpBytes,
+			BytesAfter:  lr.After.OpBytes,
+			BytesSaved:  lr.Before.OpBytes - lr.After.OpBytes,
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) computeLFS(repoPath string) error {
+	store := lfs.NewStore(repoPath)
+	infos, err := store.Files()
+	if err != nil {
+		return fmt.Errorf("failed to read LFS store: %w", err)
+	}
+	for _, info := range infos {
+		r.LFS.Files++
+		r.LFS.LogicalBytes += info.Size
+	}
+	err = store.Chunks(func(_ string, size int64) error {
+		r.LFS.Chunks++
+		r.LFS.StoredBytes += size
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read LFS chunks: %w", err)
+	}
+	if r.LFS.StoredBytes > 0 {
+		r.LFS.DedupRatio = float64(r.LFS.LogicalBytes) / float64(r.LFS.StoredBytes)
+	}
+	return nil
+}
+
+// Format renders the report as text, listing at most topFiles files
+func (r *Report) Format(topFiles int) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("Commits: %d\nOps:     %d\n\n", r.Commits, r.Ops))
+
+	sb.WriteString("Streams:\n")
+	for _, s := range r.Streams {
+		sb.WriteString(fmt.Sprintf("  %-20s %6d commits %8d ops\n", s.Name, s.Commits, s.Ops))
+	}
+	sb.WriteString("\n")
+
+	if len(r.Authors) > 0 {
+		sb.WriteString("Authors:\n")
+		for _, a := range r.Authors {
+			sb.WriteString(fmt.Sprintf("  %6d  %s <%s>\n", a.Commits, a.Name, a.Email))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(r.Files) > 0 {
+		sb.WriteString("Files by op count:\n")
+		for i, f := range r.Files {
+			if topFiles > 0 && i == topFiles {
+				sb.WriteString(fmt.Sprintf("  ... and %d more\n", len(r.Files)-topFiles))
+				break
+			}
+			name := f.Path
+			if name == "" {
+				name = f.FileID + " (not indexed)"
+			}
+			sb.WriteString(fmt.Sprintf("  %8d ops %10s  %s\n", f.Ops, util.HumanBytes(f.Bytes), name))
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("Large files: %d (%s), stored in %d chunks (%s)",
+		r.LFS.Files, util.HumanBytes(r.LFS.LogicalBytes), r.LFS.Chunks, util.HumanBytes(r.LFS.StoredBytes)))
+	if r.LFS.DedupRatio > 0 {
+		sb.WriteString(fmt.Sprintf(", dedup ratio %.2f", r.LFS.DedupRatio))
+	}
+	sb.WriteString("\n")
+
+	if c := r.Compaction; c != nil {
+		sb.WriteString(fmt.Sprintf("Last maintenance: %s, ops %d -> %d, op logs %s -> %s (saved %s)\n",
+			c.LastRun.Local().Format(time.RFC1123), c.OpsBefore, c.OpsAfter,
+			util.HumanBytes(c.BytesBefore), util.HumanBytes(c.BytesAfter), util.HumanBytes(c.BytesSaved)))
+	} else {
+		sb.WriteString("Last maintenance: never\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"bytes"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/lfs"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompute(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+
+	fid := uuid.New()
+	os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" a.txt\n"), 0644)
+	logPath := filepath.Join(rp, ".evo", "ops", "main", fid.String()+".bin")
+	os.MkdirAll(filepath.Dir(logPath), 0755)
+	for i := 1; i <= 3; i++ {
+		op := crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(i), NodeID: uuid.New(), FileID: fid, LineID: uuid.New(), Content: "x"}
+		assert.NoError(t, ops.AppendOp(logPath, op))
+	}
+
+	for i, who := range []string{"ann", "ann", "bob"} {
+		c := &types.Commit{ID: uuid.New().String(), Stream: "main", AuthorName: who, AuthorEmail: who + "@evo", Timestamp: time.Now().Add(time.Duration(i) * time.Second)}
+		assert.NoError(t, commits.SaveCommitTo(rp, "main", c))
+	}
+
+	// two large files with the same content share their chunks
+	store := lfs.NewStore(rp)
+	data := bytes.Repeat([]byte("z"), 100)
+	_, err := store.StoreFile("one", bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	_, err = store.StoreFile("two", bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	r, err := Compute(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.Commits)
+	assert.Equal(t, 3, r.Ops)
+	assert.Equal(t, []StreamStats{{Name: "main", Commits: 3, Ops: 3}}, r.Streams)
+	if assert.Len(t, r.Authors, 2) {
+		assert.Equal(t, "ann", r.Authors[0].Name)
+		assert.Equal(t, 2, r.Authors[0].Commits)
+	}
+	if assert.Len(t, r.Files, 1) {
+		assert.Equal(t, "a.txt", r.Files[0].Path)
+		assert.Equal(t, 3, r.Files[0].Ops)
+	}
+	assert.Equal(t, 2, r.LFS.Files)
+	assert.Equal(t, int64(200), r.LFS.LogicalBytes)
+	assert.Equal(t, int64(100), r.LFS.StoredBytes)
+	assert.InDelta(t, 2.0, r.LFS.DedupRatio, 0.001)
+	assert.Nil(t, r.Compaction)
+	assert.Contains(t, r.Format(10), "a.txt")
+}
+
+func TestSize(t *testing.T) {
+	rp := t.TempDir()
+	assert.NoError(t, repo.InitRepo(rp))
+
+	// a tracked file with a big history, mostly deleted, and one no longer
+	// tracked
+	fid, gone := uuid.New(), uuid.New()
+	os.WriteFile(filepath.Join(rp, ".evo", "index"), []byte(fid.String()+" big.txt\n"), 0644)
+	node := uuid.New()
+	content := string(bytes.Repeat([]byte("y"), 10000))
+	for i := 0; i < 120; i++ {
+		line := uuid.New()
+		logPath := filepath.Join(rp, ".evo", "ops", "main", fid.String()+".bin")
+		assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{Type: crdt.OpInsert, Lamport: uint64(2 * i), NodeID: node, FileID: fid, LineID: line, Content: content + string(rune('a'+i%26))}))
+		if i%4 != 0 {
+			assert.NoError(t, ops.AppendOp(logPath, crdt.Operation{Type: crdt.OpDelete, Lamport: uint64(2*i + 1), NodeID: node, FileID: fid, LineID: line}))
+		}
+	}
+	goneLog := filepath.Join(rp, ".evo", "ops", "feature", gone.String()+".bin")
+	assert.NoError(t, ops.AppendOp(goneLog, crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: node, FileID: gone, LineID: uuid.New(), Content: "x"}))
+	for _, s := range []string{"main", "feature"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(rp, ".evo", "streams", s), nil, 0644))
+	}
+
+	r, err := Size(rp)
+	assert.NoError(t, err)
+	assert.Positive(t, r.Total)
+	if assert.Len(t, r.Files, 2) {
+		big := r.Files[0]
+		assert.Equal(t, "big.txt", big.Path)
+		assert.Equal(t, 210, big.Ops)
+		assert.Equal(t, 120, big.Lines)
+		assert.Equal(t, 90, big.Deleted)
+		assert.InDelta(t, 0.75, big.TombstoneRatio(), 0.001)
+		assert.Greater(t, big.Bytes, int64(1_000_000))
+		assert.Empty(t, r.Files[1].Path)
+	}
+	if assert.Len(t, r.Streams, 2) {
+		assert.Equal(t, "main", r.Streams[0].Name)
+	}
+	assert.Equal(t, TombstoneStats{Lines: 121, Deleted: 90, Ratio: 90.0 / 121}, r.Tombstones)
+
+	kinds := make(map[string]string)
+	for _, s := range r.Suggestions {
+		kinds[s.Kind] = s.Target
+	}
+	assert.Equal(t, map[string]string{"lfs": "big.txt", "compact": ""}, kinds)
+	assert.Contains(t, r.Format(10), "Suggestions:")
+}
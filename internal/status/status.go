@@ -1,9 +1,19 @@
 package status
 
 import (
-	"bufio"
+	"evo/internal/commits"
+	"evo/internal/everrors"
+	"evo/internal/identity"
 	"evo/internal/ignore"
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/locks"
+	"evo/internal/materialize"
+	"evo/internal/ops"
+	"evo/internal/repo"
 	"evo/internal/streams"
+	"evo/internal/textenc"
+	"evo/internal/tracking"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,40 +22,33 @@ import (
 )
 
 type FileStatus struct {
-	Path    string
-	Status  string // "modified", "new", "deleted", "renamed"
-	OldPath string // only set for renamed files
+	Path    string `json:"path"`
+	Status  string `json:"status"`            // "modified", "new", "deleted", "renamed"
+	OldPath string `json:"oldPath,omitempty"` // only set for renamed files
 }
 
-type RepoStatus struct {
-	CurrentStream string
-	Files         []FileStatus
+// EncodingChange is a tracked file whose text encoding differs from the one
+// recorded when it was last ingested
+type EncodingChange struct {
+	Path string           `json:"path"`
+	From textenc.Encoding `json:"from"`
+	To   textenc.Encoding `json:"to"`
 }
 
-// loadIndex loads the index file directly to avoid dependency cycles
-func loadIndex(repoPath string) (map[string]string, error) {
-	indexPath := filepath.Join(repoPath, ".evo", "index")
-	file, err := os.Open(indexPath)
-	if os.IsNotExist(err) {
-		return make(map[string]string), nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	idx := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), ":")
-		if len(parts) == 2 {
-			idx[parts[0]] = parts[1]
-		}
-	}
-	return idx, scanner.Err()
+type RepoStatus struct {
+	CurrentStream string           `json:"stream"`
+	Detached      string           `json:"detached,omitempty"` // commit checked out instead of the stream's newest
+	Files         []FileStatus     `json:"files"`
+	Encodings     []EncodingChange `json:"encodings,omitempty"`
+	StagedOps     int              `json:"stagedOps"`          // ops staged by e.g. revert --no-commit
+	Locked        []locks.Lock     `json:"locked,omitempty"`   // changed files someone else has locked
+	Upstream      *tracking.Status `json:"upstream,omitempty"` // set when the stream has an upstream
 }
 
 func GetStatus(repoPath string) (*RepoStatus, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
 	// Get current stream
 	stream, err := streams.CurrentStream(repoPath)
 	if err != nil {
@@ -53,9 +56,9 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 	}
 
 	// Verify stream exists
-	streamPath := filepath.Join(repoPath, ".evo", "streams", stream)
+	streamPath := filepath.Join(repo.Dir(repoPath), "streams", stream)
 	if _, err := os.Stat(streamPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("stream %s does not exist", stream)
+		return nil, everrors.Errorf(everrors.ErrNotFound, "stream %s does not exist", stream)
 	}
 
 	// Load ignore patterns
@@ -65,17 +68,44 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 	}
 
 	// Get current index state
-	idx, err := loadIndex(repoPath)
+	idx, _, err := index.LoadIndex(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load index: %w", err)
 	}
 
+	staged, err := commits.LoadStaged(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+
 	status := &RepoStatus{
 		CurrentStream: stream,
+		StagedOps:     len(staged),
+	}
+	if head, err := repo.ReadHead(repoPath); err == nil {
+		status.Detached = head.Detached
+	}
+	if up, err := tracking.Get(repoPath, stream); err != nil {
+		return nil, err
+	} else if up.Upstream != "" {
+		status.Upstream = up
 	}
 
-	// Track processed files and their content hashes
-	processedFiles := make(map[string]string) // path -> content hash
+	// Files are compared with the content last in sync with the stream,
+	// whose hash ingest and checkout record and keep in the blob store, or
+	// while detached with what the checkout wrote. A file whose hash is
+	// missing, or stale because its op log changed since (a merge, an undo),
+	// is compared with its op log instead.
+	synced := stream
+	if status.Detached != "" {
+		synced = index.Detached
+	}
+	hashes, err := index.LoadHashes(repoPath, synced)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file hashes: %w", err)
+	}
+	present := make(map[string]bool)
+	untracked := make(map[string]string) // path -> content hash
 
 	// Walk the repository to find new and modified files
 	err = filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
@@ -88,6 +118,7 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 		if err != nil {
 			return err
 		}
+		relPath = filepath.ToSlash(relPath)
 
 		// Skip the .evo directory
 		if strings.HasPrefix(relPath, ".evo") {
@@ -112,44 +143,26 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 		if err != nil {
 			return err
 		}
+		sum := index.HashContent(currentContent)
+		present[relPath] = true
 
-		// Store content hash for rename detection
-		processedFiles[relPath] = string(currentContent)
-
-		// Check if file is in index
 		fileID, exists := idx[relPath]
 		if !exists {
-			// Check if this might be a renamed file
-			var foundRename bool
-			for oldPath, oldID := range idx {
-				if oldPath == relPath {
-					continue
-				}
-				storedContent, err := os.ReadFile(filepath.Join(repoPath, ".evo", "objects", oldID))
-				if err == nil && string(currentContent) == string(storedContent) {
-					// Found a rename
-					status.Files = append(status.Files, FileStatus{
-						Path:    relPath,
-						Status:  "renamed",
-						OldPath: oldPath,
-					})
-					foundRename = true
-					break
-				}
-			}
-			if !foundRename {
-				// New file
-				status.Files = append(status.Files, FileStatus{
-					Path:   relPath,
-					Status: "new",
-				})
-			}
+			untracked[relPath] = sum
 			return nil
 		}
 
 		// Check if file has been modified
-		storedContent, err := os.ReadFile(filepath.Join(repoPath, ".evo", "objects", fileID))
-		if err != nil || string(currentContent) != string(storedContent) {
+		h, ok := hashes[fileID]
+		modified := !ok || h.Content() != sum
+		if status.Detached == "" && (!ok || h.LogSize != ops.LogSize(filepath.Join(repo.Dir(repoPath), "ops", stream, fileID+".bin"))) {
+			inSync, err := materialize.InSync(repoPath, stream, fileID, currentContent)
+			if err != nil {
+				return err
+			}
+			modified = !inSync
+		}
+		if modified {
 			status.Files = append(status.Files, FileStatus{
 				Path:   relPath,
 				Status: "modified",
@@ -163,29 +176,36 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 		return nil, fmt.Errorf("failed to walk repository: %w", err)
 	}
 
-	// Check for deleted files
-	for path, id := range idx {
-		// Skip if file was already processed
-		if _, exists := processedFiles[path]; exists {
-			continue
+	// A tracked file gone from the working tree was renamed if an untracked
+	// file has its content, and deleted otherwise
+	missing := make([]string, 0)
+	for path := range idx {
+		if !present[path] {
+			missing = append(missing, path)
 		}
-
-		// Check if file was renamed by looking for matching content
-		var renamed bool
-		for newPath, content := range processedFiles {
-			storedContent, err := os.ReadFile(filepath.Join(repoPath, ".evo", "objects", id))
-			if err == nil && content == string(storedContent) {
-				// Found a rename
-				status.Files = append(status.Files, FileStatus{
-					Path:    newPath,
-					Status:  "renamed",
-					OldPath: path,
-				})
-				renamed = true
-				break
+	}
+	sort.Strings(missing)
+	newPaths := make([]string, 0, len(untracked))
+	for path := range untracked {
+		newPaths = append(newPaths, path)
+	}
+	sort.Strings(newPaths)
+	for _, path := range missing {
+		renamed := false
+		if h, ok := hashes[idx[path]]; ok {
+			for _, newPath := range newPaths {
+				if sum, free := untracked[newPath]; free && sum == h.Content() {
+					status.Files = append(status.Files, FileStatus{
+						Path:    newPath,
+						Status:  "renamed",
+						OldPath: path,
+					})
+					delete(untracked, newPath)
+					renamed = true
+					break
+				}
 			}
 		}
-
 		if !renamed {
 			status.Files = append(status.Files, FileStatus{
 				Path:   path,
@@ -193,6 +213,14 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 			})
 		}
 	}
+	for _, path := range newPaths {
+		if _, ok := untracked[path]; ok {
+			status.Files = append(status.Files, FileStatus{
+				Path:   path,
+				Status: "new",
+			})
+		}
+	}
 
 	// Sort files by status and path
 	sort.Slice(status.Files, func(i, j int) bool {
@@ -202,16 +230,82 @@ func GetStatus(repoPath string) (*RepoStatus, error) {
 		return status.Files[i].Path < status.Files[j].Path
 	})
 
+	if status.Encodings, err = encodingChanges(repoPath); err != nil {
+		return nil, fmt.Errorf("failed to check encodings: %w", err)
+	}
+
+	if id, err := identity.Current(repoPath); err == nil {
+		if status.Locked, err = locks.Modified(repoPath, stream, id.Email); err != nil {
+			return nil, fmt.Errorf("failed to check locks: %w", err)
+		}
+	}
+
 	return status, nil
 }
 
+// encodingChanges detects the encoding of each tracked text file and lists
+// those differing from the recorded one
+func encodingChanges(repoPath string) ([]EncodingChange, error) {
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	encs, err := index.LoadEncodings(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	store := lfs.NewStore(repoPath)
+	var out []EncodingChange
+	for p, fid := range p2id {
+		if _, err := store.Info(fid); err == nil {
+			// large files are stored as they are
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(p)))
+		if err != nil {
+			continue
+		}
+		from, ok := encs[fid]
+		if !ok {
+			from = textenc.UTF8
+		}
+		if to := textenc.Detect(data); to != from {
+			out = append(out, EncodingChange{Path: p, From: from, To: to})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
 // FormatStatus returns a formatted string representation of the repository status
 func FormatStatus(status *RepoStatus) string {
 	var sb strings.Builder
 
-	sb.WriteString(fmt.Sprintf("On stream %s\n\n", status.CurrentStream))
+	if status.Detached != "" {
+		sb.WriteString(fmt.Sprintf("HEAD detached at %s on stream %s\n", status.Detached, status.CurrentStream))
+		sb.WriteString(fmt.Sprintf("  (use \"evo checkout %s\" to return, \"evo stream create <name> --from %s\" to branch here)\n\n", status.CurrentStream, status.Detached))
+	} else {
+		sb.WriteString(fmt.Sprintf("On stream %s\n", status.CurrentStream))
+		if status.Upstream != nil {
+			sb.WriteString(fmt.Sprintf("Your stream is %s\n", status.Upstream.Summary()))
+		}
+		sb.WriteString("\n")
+	}
 
-	if len(status.Files) == 0 {
+	if status.StagedOps > 0 {
+		sb.WriteString(fmt.Sprintf("Operations staged for commit: %d\n", status.StagedOps))
+		sb.WriteString("  (use \"evo commit\" to record them, \"evo revert --abort\" to discard)\n\n")
+	}
+
+	if len(status.Locked) > 0 {
+		sb.WriteString("Changed files locked by someone else:\n")
+		for _, l := range status.Locked {
+			sb.WriteString(fmt.Sprintf("  %s (%s, since %s)\n", index.QuotePath(l.Path), l.Owner(), l.Created.Local().Format("2006-01-02 15:04")))
+		}
+		sb.WriteString("  (coordinate with the owner before committing; see \"evo locks\")\n\n")
+	}
+
+	if len(status.Files) == 0 && len(status.Encodings) == 0 && status.StagedOps == 0 {
 		sb.WriteString("nothing to commit, working tree clean\n")
 		return sb.String()
 	}
@@ -234,7 +328,7 @@ func FormatStatus(status *RepoStatus) string {
 	if len(modified) > 0 {
 		sb.WriteString("Changes not staged for commit:\n")
 		for _, f := range modified {
-			sb.WriteString(fmt.Sprintf("  modified: %s\n", f.Path))
+			sb.WriteString(fmt.Sprintf("  modified: %s\n", index.QuotePath(f.Path)))
 		}
 		sb.WriteString("\n")
 	}
@@ -242,7 +336,7 @@ func FormatStatus(status *RepoStatus) string {
 	if len(new) > 0 {
 		sb.WriteString("Untracked files:\n")
 		for _, f := range new {
-			sb.WriteString(fmt.Sprintf("  %s\n", f.Path))
+			sb.WriteString(fmt.Sprintf("  %s\n", index.QuotePath(f.Path)))
 		}
 		sb.WriteString("\n")
 	}
@@ -250,7 +344,7 @@ func FormatStatus(status *RepoStatus) string {
 	if len(deleted) > 0 {
 		sb.WriteString("Deleted files:\n")
 		for _, f := range deleted {
-			sb.WriteString(fmt.Sprintf("  %s\n", f.Path))
+			sb.WriteString(fmt.Sprintf("  %s\n", index.QuotePath(f.Path)))
 		}
 		sb.WriteString("\n")
 	}
@@ -258,7 +352,15 @@ func FormatStatus(status *RepoStatus) string {
 	if len(renamed) > 0 {
 		sb.WriteString("Renamed files:\n")
 		for _, f := range renamed {
-			sb.WriteString(fmt.Sprintf("  %s -> %s\n", f.OldPath, f.Path))
+			sb.WriteString(fmt.Sprintf("  %s -> %s\n", index.QuotePath(f.OldPath), index.QuotePath(f.Path)))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(status.Encodings) > 0 {
+		sb.WriteString("Encoding changes:\n")
+		for _, e := range status.Encodings {
+			sb.WriteString(fmt.Sprintf("  %s: %s -> %s\n", index.QuotePath(e.Path), e.From, e.To))
 		}
 		sb.WriteString("\n")
 	}
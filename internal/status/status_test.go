@@ -1,8 +1,15 @@
 package status
 
 import (
+	"context"
+	"evo/internal/blobs"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/ops"
+	"evo/internal/repo"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -17,7 +24,6 @@ func setupTestRepo(t *testing.T) string {
 	// Create .evo directory structure
 	evoDir := filepath.Join(tmpDir, ".evo")
 	for _, dir := range []string{
-		"objects",
 		"streams",
 		"commits",
 	} {
@@ -116,30 +122,29 @@ build/
 		}
 	}
 
-	// Create object files first
+	// Record the content last in sync with the stream, as ingest does
 	objects := map[string]string{
 		"id1": "content1",
 		"id2": "content2",
 	}
 
 	for id, content := range objects {
-		objPath := filepath.Join(repoPath, ".evo", "objects", id)
-		if err := os.WriteFile(objPath, []byte(content), 0644); err != nil {
+		sum, err := blobs.Put(repoPath, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := index.SetHash(repoPath, "main", id, index.Hash{Sum: sum}); err != nil {
 			t.Fatal(err)
 		}
 	}
 
-	// Create index file after objects
+	// Create index file after the hashes
 	indexContent := map[string]string{
 		"file1.txt": "id1",
 		"file2.txt": "id2",
 	}
 
-	var indexLines []string
-	for path, id := range indexContent {
-		indexLines = append(indexLines, path+":"+id)
-	}
-	if err := os.WriteFile(filepath.Join(repoPath, ".evo", "index"), []byte(strings.Join(indexLines, "\n")+"\n"), 0644); err != nil {
+	if err := index.SaveIndex(repoPath, indexContent); err != nil {
 		t.Fatal(err)
 	}
 
@@ -349,57 +354,155 @@ func TestFormatStatus(t *testing.T) {
 	}
 }
 
-func TestLoadIndex(t *testing.T) {
-	repoPath := setupTestRepo(t)
-	defer os.RemoveAll(repoPath)
+// kinds lists the divergences of a verification, leaving out those of kind
+// "status"
+func kinds(v *Verification) []string {
+	var out []string
+	for _, d := range v.Divergences {
+		if d.Kind != "status" {
+			out = append(out, d.Kind+" "+d.Path)
+		}
+	}
+	return out
+}
 
-	// Test loading non-existent index
-	idx, err := loadIndex(repoPath)
+func TestStatusAfterIngest(t *testing.T) {
+	repoPath := t.TempDir()
+	if err := repo.InitRepo(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("one\ntwo\n")
+	if err := os.WriteFile(filepath.Join(repoPath, "a.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.UpdateIndex(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), repoPath, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if !blobs.Has(repoPath, blobs.Sum(content)) {
+		t.Error("Expected ingest to store the content as a blob")
+	}
+	status, err := GetStatus(repoPath)
 	if err != nil {
-		t.Errorf("Expected no error when index doesn't exist, got %v", err)
+		t.Fatal(err)
 	}
-	if len(idx) != 0 {
-		t.Errorf("Expected empty index, got %v", idx)
+	if len(status.Files) != 0 {
+		t.Errorf("Expected a clean tree after ingest, got %+v", status.Files)
 	}
 
-	// Test loading valid index
-	indexContent := "file1.txt:id1\nfile2.txt:id2\n"
-	if err := os.WriteFile(filepath.Join(repoPath, ".evo", "index"), []byte(indexContent), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(repoPath, "a.txt"), []byte("one\n"), 0644); err != nil {
 		t.Fatal(err)
 	}
-
-	idx, err = loadIndex(repoPath)
+	status, err = GetStatus(repoPath)
 	if err != nil {
-		t.Errorf("Failed to load index: %v", err)
+		t.Fatal(err)
 	}
+	if !reflect.DeepEqual(status.Files, []FileStatus{{Path: "a.txt", Status: "modified"}}) {
+		t.Errorf("Expected a.txt modified, got %+v", status.Files)
+	}
+}
 
-	expected := map[string]string{
-		"file1.txt": "id1",
-		"file2.txt": "id2",
+func TestVerify(t *testing.T) {
+	repoPath := t.TempDir()
+	if err := repo.InitRepo(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "one\ntwo", "b.txt": "bee"} {
+		if err := os.WriteFile(filepath.Join(repoPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.UpdateIndex(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), repoPath, "main"); err != nil {
+		t.Fatal(err)
 	}
 
-	if len(idx) != len(expected) {
-		t.Errorf("Expected %d entries, got %d", len(expected), len(idx))
+	v, err := Verify(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Checked != 2 || len(v.Modified) != 0 || len(kinds(v)) != 0 {
+		t.Errorf("Expected 2 files in sync, got %+v", v)
 	}
 
-	for path, id := range expected {
-		if idx[path] != id {
-			t.Errorf("Expected %s -> %s, got %s -> %s", path, id, path, idx[path])
-		}
+	// a change is listed, not a divergence
+	changed := []byte("one\nchanged")
+	if err := os.WriteFile(filepath.Join(repoPath, "a.txt"), changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err = Verify(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Modified, []string{"a.txt"}) || len(kinds(v)) != 0 {
+		t.Errorf("Expected a.txt modified only, got %+v", v)
 	}
 
-	// Test loading malformed index
-	malformedContent := "file1.txt:id1\nmalformed-line\nfile2.txt:id2\n"
-	if err := os.WriteFile(filepath.Join(repoPath, ".evo", "index"), []byte(malformedContent), 0644); err != nil {
+	// a hash claiming the change is in sync would hide it from ingest, and a
+	// deleted file leaves its lines in the op log
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(repoPath, ".evo", "ops", "main", p2id["a.txt"]+".bin")
+	if err := index.SetHash(repoPath, "main", p2id["a.txt"], index.Hash{LogSize: ops.LogSize(logPath), Sum: index.HashContent(changed)}); err != nil {
 		t.Fatal(err)
 	}
+	if err := os.Remove(filepath.Join(repoPath, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	v, err = Verify(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := kinds(v), []string{"hash a.txt", "missing b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected divergences %v, got %v", want, got)
+	}
+	if !strings.Contains(FormatVerification(v), "ingest would skip a changed file") {
+		t.Errorf("Expected the hash divergence in the report, got %q", FormatVerification(v))
+	}
+}
 
-	idx, err = loadIndex(repoPath)
+func TestStatusHostilePaths(t *testing.T) {
+	repoPath := t.TempDir()
+	if err := repo.InitRepo(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"with space.txt", "colon:id.txt", "new\nline.txt", "latin1-\xe9.txt", "\"quoted\".txt"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(repoPath, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.UpdateIndex(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingest.IngestLocalChanges(context.Background(), repoPath, "main"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := GetStatus(repoPath)
 	if err != nil {
-		t.Errorf("Failed to load index with malformed line: %v", err)
+		t.Fatal(err)
+	}
+	if len(status.Files) != 0 {
+		t.Fatalf("Expected a clean tree after ingest, got %+v", status.Files)
 	}
 
-	if len(idx) != 2 {
-		t.Errorf("Expected 2 valid entries, got %d", len(idx))
+	if err := os.WriteFile(filepath.Join(repoPath, "new\nline.txt"), []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err = GetStatus(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(status.Files, []FileStatus{{Path: "new\nline.txt", Status: "modified"}}) {
+		t.Fatalf("Expected the file with a line break modified, got %+v", status.Files)
+	}
+	if out := FormatStatus(status); !strings.Contains(out, "  modified: \"new\\nline.txt\"\n") {
+		t.Errorf("Expected the path quoted, got:\n%s", out)
 	}
 }
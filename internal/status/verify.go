@@ -0,0 +1,178 @@
+package status
+
+import (
+	"evo/internal/index"
+	"evo/internal/lfs"
+	"evo/internal/materialize"
+	"evo/internal/merge"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/textenc"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Divergence is a disagreement between the index, the remembered file
+// hashes, the op log, the working tree or GetStatus
+type Divergence struct {
+	Path   string `json:"path"`
+	FileID string `json:"fileId,omitempty"`
+	Kind   string `json:"kind"` // "index", "missing", "hash", "lfs" or "status"
+	Detail string `json:"detail"`
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Kind, d.Path, d.Detail)
+}
+
+// Verification is the result of Verify
+type Verification struct {
+	Stream      string       `json:"stream"`
+	Checked     int          `json:"checked"`
+	Modified    []string     `json:"modified"` // tracked files differing from their op log
+	Divergences []Divergence `json:"divergences"`
+}
+
+// Verify re-reads every tracked file and compares it with the stream's op
+// log as materialized, and with the hash ingest remembers for it. Changes
+// not yet committed are listed as modified; what can't be explained by them
+// is a divergence: an index mapping two paths to one file, a tracked file
+// missing from disk while its log still holds lines, a remembered hash
+// claiming a changed file is in sync (ingest would skip it), a large file
+// whose stored content is missing, and GetStatus reporting a file otherwise
+// than ingest would treat it. Large files are compared with the stored
+// content their stub names.
+func Verify(repoPath string) (*Verification, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	head, err := repo.ReadHead(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if head.Detached != "" {
+		return nil, fmt.Errorf("HEAD is detached at %s; the working tree can only be verified against a stream, run 'evo checkout %s' first", head.Detached, head.Stream)
+	}
+	stream := head.Stream
+	p2id, id2p, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	hashes, err := index.LoadHashes(repoPath, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file hashes: %w", err)
+	}
+	attrs, err := merge.LoadAttributes(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attributes: %w", err)
+	}
+	v := &Verification{Stream: stream, Modified: []string{}, Divergences: []Divergence{}}
+	diverge := func(path, fid, kind, format string, args ...any) {
+		v.Divergences = append(v.Divergences, Divergence{Path: path, FileID: fid, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	paths := make([]string, 0, len(p2id))
+	for p := range p2id {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	changed := make(map[string]bool) // modified or missing, as ingest sees it
+	missing := make(map[string]bool)
+	for _, p := range paths {
+		fid := p2id[p]
+		if id2p[fid] != p {
+			diverge(p, fid, "index", "file ID %s is also indexed as %s", fid, id2p[fid])
+		}
+		v.Checked++
+		doc, err := materialize.Load(repoPath, stream, fid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", p, err)
+		}
+		abs := filepath.Join(repoPath, filepath.FromSlash(p))
+		data, err := os.ReadFile(abs)
+		if os.IsNotExist(err) {
+			missing[p] = true
+			if len(doc.Lines) > 0 {
+				changed[p] = true
+				diverge(p, fid, "missing", "tracked but not in the working tree; its op log still holds %d line(s)", len(doc.Lines))
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sum := index.HashContent(data)
+		inSync := false
+		if len(doc.Lines) == 1 && strings.HasPrefix(doc.Lines[0], "EVO-LFS:") {
+			info, err := lfs.NewStore(repoPath).Info(fid)
+			if err != nil {
+				diverge(p, fid, "lfs", "large file content is not in the local store; can't compare")
+				continue
+			}
+			inSync = info.ContentHash == sum && info.Size == int64(len(data))
+		} else {
+			text, _ := textenc.Text(data)
+			inSync = strings.ReplaceAll(text, "\r\n", "\n") == strings.Join(doc.Lines, "\n")
+		}
+		if !inSync {
+			changed[p] = true
+			v.Modified = append(v.Modified, p)
+		}
+
+		h, ok := hashes[fid]
+		sum = index.GranularSum(sum, string(attrs.GranularityFor(p)))
+		if ok && !inSync && h.Sum == sum && h.LogSize == ops.LogSize(filepath.Join(repo.Dir(repoPath), "ops", stream, fid+".bin")) {
+			diverge(p, fid, "hash", "remembered as in sync with the op log, so ingest would skip a changed file")
+		}
+	}
+
+	st, err := GetStatus(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]string)
+	for _, f := range st.Files {
+		listed[f.Path] = f.Status
+		if f.OldPath != "" {
+			listed[f.OldPath] = f.Status
+		}
+	}
+	for _, p := range paths {
+		fid := p2id[p]
+		s, ok := listed[p]
+		switch {
+		case s == "new":
+			diverge(p, fid, "status", "status reports a tracked file as untracked")
+		case s == "deleted" && !missing[p]:
+			diverge(p, fid, "status", "status reports a file in the working tree as deleted")
+		case s == "modified" && !changed[p]:
+			diverge(p, fid, "status", "status reports changes, but the file matches its op log")
+		case !ok && changed[p]:
+			diverge(p, fid, "status", "status misses changes ingest would record")
+		}
+	}
+	return v, nil
+}
+
+// FormatVerification returns a text rendering of a verification
+func FormatVerification(v *Verification) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Verified %d tracked files against the op logs of stream %s\n", v.Checked, v.Stream))
+	if len(v.Modified) > 0 {
+		sb.WriteString("\nModified since their op log:\n")
+		for _, p := range v.Modified {
+			sb.WriteString(fmt.Sprintf("  %s\n", p))
+		}
+	}
+	if len(v.Divergences) > 0 {
+		sb.WriteString("\nDivergences:\n")
+		for _, d := range v.Divergences {
+			sb.WriteString(fmt.Sprintf("  %s\n", d))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"evo/internal/platform"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS keeps each object as a file under Dir. Put writes to a temporary file
+// in Dir/tmp, syncs it and renames it into place, so a crash never leaves a
+// truncated object behind; keys under tmp/ are reserved for this.
+type FS struct {
+	Dir string
+}
+
+// NewFS returns the backend of a directory
+func NewFS(dir string) *FS {
+	return &FS{Dir: dir}
+}
+
+func openFS(u *url.URL, base string) (Backend, error) {
+	dir, err := urlPath(u, base)
+	if err != nil {
+		return nil, err
+	}
+	return NewFS(dir), nil
+}
+
+func (b *FS) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.Dir, filepath.FromSlash(key)), nil
+}
+
+// Get opens the file of key
+func (b *FS) Get(key string) (io.ReadCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Put writes r to a temporary file and renames it to the file of key
+func (b *FS) Put(key string, r io.Reader) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	tmpDir := filepath.Join(b.Dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(tmpDir, "put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := platform.Rename(f.Name(), p); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(p))
+	return nil
+}
+
+// Stat returns the size of the file of key
+func (b *FS) Stat(key string) (int64, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// List walks the directories prefix can be in
+func (b *FS) List(prefix string, fn func(key string, size int64) error) error {
+	start := b.Dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		if err := checkKey(prefix[:i]); err != nil {
+			return err
+		}
+		start = filepath.Join(b.Dir, filepath.FromSlash(prefix[:i]))
+	}
+	tmp := filepath.Join(b.Dir, "tmp")
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == tmp {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(b.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(key, info.Size())
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete removes the file of key. Emptied directories are left for the next
+// Put to use.
+func (b *FS) Delete(key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failing to is not an error.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+var _ Backend = (*FS)(nil)
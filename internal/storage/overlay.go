@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// Overlay is a backend that reads through to lower backends for the keys it
+// doesn't hold itself, as a repository borrowing another's objects does.
+// Put, List and Delete only reach the upper backend, so the lower ones are
+// never changed.
+type Overlay struct {
+	Backend
+	Lower []Backend
+}
+
+// NewOverlay layers upper over lower, returning upper alone without lower
+// backends
+func NewOverlay(upper Backend, lower ...Backend) Backend {
+	if len(lower) == 0 {
+		return upper
+	}
+	return &Overlay{Backend: upper, Lower: lower}
+}
+
+// Get opens the object at key in the first backend holding it
+func (o *Overlay) Get(key string) (io.ReadCloser, error) {
+	r, err := o.Backend.Get(key)
+	for _, b := range o.Lower {
+		if !errors.Is(err, ErrNotFound) {
+			break
+		}
+		r, err = b.Get(key)
+	}
+	return r, err
+}
+
+// Stat returns the size of the object at key in the first backend holding it
+func (o *Overlay) Stat(key string) (int64, error) {
+	size, err := o.Backend.Stat(key)
+	for _, b := range o.Lower {
+		if !errors.Is(err, ErrNotFound) {
+			break
+		}
+		size, err = b.Stat(key)
+	}
+	return size, err
+}
+
+var _ Backend = (*Overlay)(nil)
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 keeps objects in a bucket of an S3-compatible service, such as AWS S3 or
+// MinIO, under Prefix. Objects are addressed path-style, as
+// <endpoint>/<bucket>/<prefix><key>, and requests signed with AWS Signature
+// Version 4. A PUT replaces an object whole, which makes Put atomic; it reads
+// the object into memory first to sign its hash.
+type S3 struct {
+	Endpoint  string // such as https://s3.eu-west-1.amazonaws.com
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Token     string // session token of temporary credentials
+	Client    *http.Client
+}
+
+func openS3(u *url.URL, _ string) (Backend, error) {
+	q := u.Query()
+	b := &S3{
+		Endpoint:  strings.TrimSuffix(q.Get("endpoint"), "/"),
+		Bucket:    u.Host,
+		Prefix:    strings.TrimPrefix(u.Path, "/"),
+		Region:    q.Get("region"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:     os.Getenv("AWS_SESSION_TOKEN"),
+		Client:    http.DefaultClient,
+	}
+	if b.Bucket == "" {
+		return nil, errors.New("no bucket")
+	}
+	if b.Prefix != "" && !strings.HasSuffix(b.Prefix, "/") {
+		b.Prefix += "/"
+	}
+	if b.Region == "" {
+		b.Region = "us-east-1"
+	}
+	if b.Endpoint == "" {
+		b.Endpoint = "https://s3." + b.Region + ".amazonaws.com"
+	}
+	if b.AccessKey == "" || b.SecretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return b, nil
+}
+
+// Get downloads an object
+func (b *S3) Get(key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := b.do(http.MethodGet, b.Prefix+key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads an object
+func (b *S3) Put(key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(http.MethodPut, b.Prefix+key, nil, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Stat asks for the headers of an object
+func (b *S3) Stat(key string) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	resp, err := b.do(http.MethodHead, b.Prefix+key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// Delete deletes an object
+func (b *S3) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := b.do(http.MethodDelete, b.Prefix+key, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// listResult is a page of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List lists the bucket a page at a time
+func (b *S3) List(prefix string, fn func(key string, size int64) error) error {
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {b.Prefix + prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+		for _, c := range page.Contents {
+			if err := fn(strings.TrimPrefix(c.Key, b.Prefix), c.Size); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object, or for the bucket when name is
+// empty, and returns the response if it succeeded
+func (b *S3) do(method, name string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(b.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", b.Endpoint, err)
+	}
+	path := strings.TrimSuffix(u.Path, "/") + "/" + b.Bucket
+	if name != "" {
+		path += "/" + name
+	}
+	u.Path, u.RawPath = path, uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format(amzDate))
+	if b.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", b.Token)
+	}
+	req.Header.Set("Authorization", b.authorization(method, u.Host, u.RawPath, u.RawQuery, req.Header))
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && name != "" {
+		return nil, ErrNotFound
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("S3 %s %s failed: %s %s", method, path, e.Code, e.Message)
+}
+
+const amzDate = "20060102T150405Z"
+
+// authorization returns the Signature Version 4 Authorization header of a
+// request, signing host and every header in h, whose X-Amz-Date and
+// X-Amz-Content-Sha256 must be set. path and query must be encoded as
+// uriEncode and canonicalQuery encode them.
+func (b *S3) authorization(method, host, path, query string, h http.Header) string {
+	names := []string{"host"}
+	values := map[string]string{"host": host}
+	for k, vs := range h {
+		k = strings.ToLower(k)
+		if k == "authorization" {
+			continue
+		}
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		names = append(names, k)
+		values[k] = strings.Join(trimmed, ",")
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		headers.WriteString(k + ":" + values[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{method, path, query, headers.String(), signed, h.Get("X-Amz-Content-Sha256")}, "\n")
+	stamp := h.Get("X-Amz-Date")
+	day := stamp[:min(8, len(stamp))]
+	scope := day + "/" + b.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + b.SecretKey)
+	for _, part := range []string{day, b.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// uriEncode percent-encodes every byte of s but the unreserved characters
+// and, unless encodeSlash, slashes, as Signature Version 4 does
+func uriEncode(s string, encodeSlash bool) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+	return out.String()
+}
+
+// canonicalQuery encodes a query with its keys and values sorted
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+var _ Backend = (*S3)(nil)
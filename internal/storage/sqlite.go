@@ -0,0 +1,143 @@
+//go:build sqlite
+
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	openers["sqlite"] = openSQLite
+}
+
+// SQLite keeps objects as rows of one table, which holds millions of small
+// objects better than as many files. Each Put is one statement, so it is
+// atomic; the database runs in WAL mode so readers don't wait for it.
+type SQLite struct {
+	db *sql.DB
+}
+
+func openSQLite(u *url.URL, base string) (Backend, error) {
+	path, err := urlPath(u, base)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLite(path)
+}
+
+// NewSQLite opens the database at path, creating it if needed
+func NewSQLite(path string) (*SQLite, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS objects (key TEXT PRIMARY KEY, data BLOB NOT NULL) WITHOUT ROWID`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Close closes the database
+func (b *SQLite) Close() error {
+	return b.db.Close()
+}
+
+// Get reads the row of key
+func (b *SQLite) Get(key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	var data []byte
+	err := b.db.QueryRow(`SELECT data FROM objects WHERE key = ?`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Put inserts or replaces the row of key
+func (b *SQLite) Put(key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(`INSERT INTO objects (key, data) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET data = excluded.data`, key, data)
+	return err
+}
+
+// Stat returns the length of the row of key
+func (b *SQLite) Stat(key string) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	var size int64
+	err := b.db.QueryRow(`SELECT length(data) FROM objects WHERE key = ?`, key).Scan(&size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return size, err
+}
+
+// List reads the keys first, so fn is free to change the table
+func (b *SQLite) List(prefix string, fn func(key string, size int64) error) error {
+	rows, err := b.db.Query(`SELECT key, length(data) FROM objects WHERE key >= ? ORDER BY key`, prefix)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		key  string
+		size int64
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.key, &e.size); err != nil {
+			rows.Close()
+			return err
+		}
+		if !strings.HasPrefix(e.key, prefix) {
+			break
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := fn(e.key, e.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the row of key
+func (b *SQLite) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, err := b.db.Exec(`DELETE FROM objects WHERE key = ?`, key)
+	return err
+}
+
+var _ Backend = (*SQLite)(nil)
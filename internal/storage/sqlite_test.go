@@ -0,0 +1,17 @@
+//go:build sqlite
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite(t *testing.T) {
+	b, err := NewSQLite(filepath.Join(t.TempDir(), "objects.db"))
+	require.NoError(t, err)
+	defer b.Close()
+	testBackend(t, b)
+}
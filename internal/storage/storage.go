@@ -0,0 +1,117 @@
+// Package storage keeps whole objects under slash-separated keys such as
+// ab/cd/<sha256> in a backend: a directory, the default, an S3-compatible
+// bucket for server deployments, or a SQLite database for stores of millions
+// of small objects (in builds with -tags sqlite).
+//
+// Backends are for content written once and replaced whole: LFS chunks,
+// commit files, and the segments of op logs once they are sealed. The
+// segment an op log is appended to stays a file in .evo.
+package storage
+
+import (
+	"errors"
+	"evo/internal/log"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"sync"
+)
+
+var logger = log.For("storage")
+
+// ErrNotFound is returned for a key holding no object. It is an
+// fs.ErrNotExist, so errors.Is(err, fs.ErrNotExist) holds for it too.
+var ErrNotFound = fmt.Errorf("object %w", fs.ErrNotExist)
+
+// Backend stores objects by key
+type Backend interface {
+	// Get opens the object at key
+	Get(key string) (io.ReadCloser, error)
+	// Put stores the content of r at key. It is atomic: until Put returns,
+	// readers see the old object or none, never part of the new one.
+	Put(key string, r io.Reader) error
+	// Stat returns the size of the object at key
+	Stat(key string) (int64, error)
+	// List calls fn with the key and size of every object whose key starts
+	// with prefix. fn may delete the object it is called with.
+	List(prefix string, fn func(key string, size int64) error) error
+	// Delete removes the object at key; a missing object is not an error
+	Delete(key string) error
+}
+
+// opener opens a backend from its URL; relative paths are relative to base
+type opener func(u *url.URL, base string) (Backend, error)
+
+var openers = map[string]opener{
+	"file": openFS,
+	"s3":   openS3,
+}
+
+var (
+	mu     sync.Mutex
+	opened = map[string]Backend{}
+)
+
+// Open returns the backend a URL names:
+//
+//	file:<dir> or a plain path          a directory
+//	s3://<bucket>/<prefix>?endpoint=<url>&region=<region>
+//	sqlite:<file>                       a SQLite database
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. Relative paths are relative to base. Opening the same
+// URL again returns the same backend.
+func Open(spec, base string) (Backend, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	key := spec + "\x00" + base
+	if b, ok := opened[key]; ok {
+		return b, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" || filepath.VolumeName(spec) != "" {
+		// a path, C:\ included
+		u = &url.URL{Scheme: "file", Opaque: spec}
+	}
+	open, ok := openers[u.Scheme]
+	if !ok {
+		if u.Scheme == "sqlite" {
+			return nil, fmt.Errorf("storage %s needs evo built with -tags sqlite", spec)
+		}
+		return nil, fmt.Errorf("unknown storage scheme %q", u.Scheme)
+	}
+	b, err := open(u, base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage %s: %w", spec, err)
+	}
+	opened[key] = b
+	logger.Debug("opened storage", "url", spec)
+	return b, nil
+}
+
+// urlPath returns the path of a file: or sqlite: URL, made absolute against
+// base
+func urlPath(u *url.URL, base string) (string, error) {
+	p := u.Opaque
+	if p == "" {
+		p = u.Path
+	}
+	if p == "" {
+		return "", errors.New("no path")
+	}
+	p = filepath.FromSlash(p)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(base, p)
+	}
+	return p, nil
+}
+
+// checkKey rejects keys that are not clean relative paths
+func checkKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package streams
+
+import (
+	"bufio"
+	"encoding/json"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/node"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/validate"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OpRecord is an op as a line of JSON, for tools that don't read the binary
+// log format. Origin is "start" for a line inserted at the top and empty for
+// legacy inserts without one.
+type OpRecord struct {
+	FileID    uuid.UUID        `json:"fileId"`
+	Path      string           `json:"path,omitempty"` // from the index, on export only
+	Type      string           `json:"type"`           // insert, update or delete
+	Lamport   uint64           `json:"lamport"`
+	NodeID    uuid.UUID        `json:"nodeId"`
+	LineID    uuid.UUID        `json:"lineId"`
+	Origin    string           `json:"origin,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Fragment  bool             `json:"fragment,omitempty"`
+	Stream    string           `json:"stream,omitempty"` // the stream the op was made in
+	Timestamp time.Time        `json:"timestamp"`
+	Vector    crdt.VectorClock `json:"vector,omitempty"`
+}
+
+var opTypes = []string{crdt.OpInsert: "insert", crdt.OpUpdate: "update", crdt.OpDelete: "delete"}
+
+// Record returns the record of an op
+func Record(op crdt.Operation) OpRecord {
+	r := OpRecord{
+		FileID:    op.FileID,
+		Lamport:   op.Lamport,
+		NodeID:    op.NodeID,
+		LineID:    op.LineID,
+		Content:   op.Content,
+		Fragment:  op.Fragment,
+		Stream:    op.Stream,
+		Timestamp: op.Timestamp,
+		Vector:    op.Vector,
+	}
+	if int(op.Type) < len(opTypes) {
+		r.Type = opTypes[op.Type]
+	}
+	switch op.OriginLineID {
+	case uuid.Nil:
+	case crdt.DocumentStart:
+		r.Origin = "start"
+	default:
+		r.Origin = op.OriginLineID.String()
+	}
+	return r
+}
+
+// Operation returns the op a record describes
+func (r OpRecord) Operation() (crdt.Operation, error) {
+	op := crdt.Operation{
+		FileID:    r.FileID,
+		Lamport:   r.Lamport,
+		NodeID:    r.NodeID,
+		LineID:    r.LineID,
+		Content:   r.Content,
+		Fragment:  r.Fragment,
+		Stream:    r.Stream,
+		Timestamp: r.Timestamp,
+		Vector:    r.Vector,
+	}
+	found := false
+	for t, name := range opTypes {
+		if r.Type == name {
+			op.Type, found = crdt.OpType(t), true
+		}
+	}
+	if !found {
+		return op, fmt.Errorf("unknown op type %q", r.Type)
+	}
+	if r.FileID == uuid.Nil || r.LineID == uuid.Nil {
+		return op, fmt.Errorf("fileId and lineId are required")
+	}
+	switch r.Origin {
+	case "":
+	case "start":
+		op.OriginLineID = crdt.DocumentStart
+	default:
+		id, err := uuid.Parse(r.Origin)
+		if err != nil {
+			return op, fmt.Errorf("invalid origin %q", r.Origin)
+		}
+		op.OriginLineID = id
+	}
+	return op, nil
+}
+
+// ExportOps writes the ops of a stream's logs to w as JSON Lines, one op per
+// line in log order, log after log. With fileID set only that file's log is
+// written.
+func ExportOps(repoPath, stream, fileID string, w io.Writer) (int, error) {
+	if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", stream)); err != nil {
+		return 0, everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", stream)
+	}
+	dir := filepath.Join(repo.Dir(repoPath), "ops", stream)
+	var logs []string
+	if fileID != "" {
+		if _, err := uuid.Parse(fileID); err != nil {
+			return 0, fmt.Errorf("invalid file ID %q", fileID)
+		}
+		logs = []string{filepath.Join(dir, fileID+".bin")}
+	} else {
+		var err error
+		if logs, err = ops.ListLogs(dir); err != nil {
+			return 0, err
+		}
+	}
+	_, id2path, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	for _, path := range logs {
+		logOps, _, err := ops.ReadOpsFrom(path, 0)
+		if err != nil {
+			return n, err
+		}
+		fid := strings.TrimSuffix(filepath.Base(path), ".bin")
+		for _, op := range logOps {
+			r := Record(op)
+			r.Path = id2path[fid]
+			if err := enc.Encode(r); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, bw.Flush()
+}
+
+// ImportResult counts the ops an import read
+type ImportResult struct {
+	Stream  string `json:"stream"`
+	Added   int    `json:"added"`
+	Skipped int    `json:"skipped"` // already in their log
+}
+
+// ImportOps appends the ops of JSON Lines read from r, as ExportOps writes
+// them, to the logs of stream, skipping ops a log already has. Paths are
+// ignored. Imported ops are not part of any commit until one is made; when
+// stream is checked out, the files they touch are rewritten so ingest
+// doesn't take the old content for an edit.
+func ImportOps(repoPath, stream string, r io.Reader) (*ImportResult, error) {
+	if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", stream)); err != nil {
+		return nil, everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", stream)
+	}
+	var eops []commits.ExtendedOp
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var rec OpRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		op, err := rec.Operation()
+		if err == nil {
+			err = validate.Op(op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		eops = append(eops, commits.ExtendedOp{Op: op})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	rep := newReplicator(repoPath, stream)
+	if err := rep.add(eops); err != nil {
+		return nil, err
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, eop := range eops {
+		self.Observe(eop.Op)
+	}
+	if err := self.Save(); err != nil {
+		return nil, err
+	}
+	if head, err := repo.ReadHead(repoPath); err == nil && head.Stream == stream && head.Detached == "" {
+		for fid := range rep.known {
+			if err := materialize.WriteFile(repoPath, stream, fid.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	logger.Info("imported ops", "stream", stream, "added", rep.added, "skipped", len(eops)-rep.added)
+	return &ImportResult{Stream: stream, Added: rep.added, Skipped: len(eops) - rep.added}, nil
+}
@@ -0,0 +1,87 @@
+package streams
+
+import (
+	"bytes"
+	"encoding/json"
+	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/materialize"
+	"evo/internal/repo"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpRecord(t *testing.T) {
+	nodeID := uuid.New()
+	for _, op := range []crdt.Operation{
+		{Type: crdt.OpInsert, FileID: uuid.New(), LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "top", NodeID: nodeID, Lamport: 1, Vector: crdt.VectorClock{nodeID: 1}},
+		{Type: crdt.OpInsert, FileID: uuid.New(), LineID: uuid.New(), OriginLineID: uuid.New(), Fragment: true, Content: "x", Stream: "main", Timestamp: time.Now().UTC()},
+		{Type: crdt.OpInsert, FileID: uuid.New(), LineID: uuid.New(), Content: "legacy"},
+		{Type: crdt.OpDelete, FileID: uuid.New(), LineID: uuid.New()},
+	} {
+		got, err := Record(op).Operation()
+		require.NoError(t, err)
+		assert.Equal(t, op, got)
+	}
+	_, err := OpRecord{Type: "move", FileID: uuid.New(), LineID: uuid.New()}.Operation()
+	assert.ErrorContains(t, err, "unknown op type")
+	_, err = OpRecord{Type: "insert", FileID: uuid.New(), LineID: uuid.New(), Origin: "top"}.Operation()
+	assert.ErrorContains(t, err, "invalid origin")
+}
+
+func TestExportImportOps(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	require.NoError(t, os.WriteFile(filepath.Join(rp, "a.txt"), []byte("one\ntwo\n"), 0644))
+	require.NoError(t, index.UpdateIndex(rp))
+	fid, err := index.LookupFileID(rp, "a.txt")
+	require.NoError(t, err)
+	nodeID, fileID := uuid.New(), uuid.MustParse(fid)
+	one := crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "one", NodeID: nodeID, Lamport: 1}
+	two := crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: uuid.New(), OriginLineID: one.LineID, Content: "two", NodeID: nodeID, Lamport: 2}
+	require.NoError(t, replicateOps(rp, "main", []commits.ExtendedOp{{Op: one}, {Op: two}}))
+
+	var out bytes.Buffer
+	n, err := ExportOps(rp, "main", "", &out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"path":"a.txt"`)
+	assert.Contains(t, lines[0], `"origin":"start"`)
+
+	// into a new stream, then again: nothing is added twice
+	require.NoError(t, CreateStream(rp, "copy"))
+	res, err := ImportOps(rp, "copy", bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Stream: "copy", Added: 2}, *res)
+	res, err = ImportOps(rp, "copy", bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Skipped)
+	doc, err := materialize.Load(rp, "copy", fid)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, doc.Lines)
+
+	// an edit imported into the checked out stream reaches the working tree
+	three := crdt.Operation{Type: crdt.OpUpdate, FileID: fileID, LineID: two.LineID, Content: "three", NodeID: nodeID, Lamport: 3}
+	var edit bytes.Buffer
+	require.NoError(t, json.NewEncoder(&edit).Encode(Record(three)))
+	_, err = ImportOps(rp, "main", &edit)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(rp, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\nthree", string(data))
+
+	_, err = ImportOps(rp, "copy", strings.NewReader("{}\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = ExportOps(rp, "nope", "", &out)
+	assert.ErrorContains(t, err, "does not exist")
+}
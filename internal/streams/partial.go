@@ -3,10 +3,9 @@ package streams
 import (
 	"evo/internal/commits"
 	"evo/internal/crdt"
-	"evo/internal/repo"
 	"evo/internal/types"
+	"evo/internal/validate"
 	"fmt"
-	"path/filepath"
 )
 
 // MergeFilter defines criteria for selecting operations during a partial merge
@@ -27,6 +26,14 @@ func PartialMerge(repoPath, source, target string, filter MergeFilter) error {
 		return err
 	}
 
+	for _, sc := range srcCommits {
+		for _, eop := range sc.Operations {
+			if err := validate.Op(eop.Op); err != nil {
+				return fmt.Errorf("commit %s: %w", sc.ID, err)
+			}
+		}
+	}
+
 	// Build map of target commits for quick lookup
 	tgtMap := make(map[string]bool)
 	for _, c := range tgtCommits {
@@ -58,8 +65,7 @@ func PartialMerge(repoPath, source, target string, filter MergeFilter) error {
 			}
 
 			// Save the commit
-			commitPath := filepath.Join(repoPath, repo.EvoDir, "commits", target)
-			if err := commits.SaveCommitFile(commitPath, &newCommit); err != nil {
+			if err := commits.SaveCommitTo(repoPath, target, &newCommit); err != nil {
 				return err
 			}
 
@@ -99,8 +105,7 @@ func PartialMerge(repoPath, source, target string, filter MergeFilter) error {
 		}
 
 		// Save the commit
-		commitPath := filepath.Join(repoPath, repo.EvoDir, "commits", target)
-		if err := commits.SaveCommitFile(commitPath, &newCommit); err != nil {
+		if err := commits.SaveCommitTo(repoPath, target, &newCommit); err != nil {
 			return err
 		}
 
@@ -45,7 +45,6 @@ func TestPartialMerge(t *testing.T) {
 						Timestamp: time.Now(),
 						NodeID:    uuid.New(),
 						Lamport:   1,
-						Vector:    []int64{1},
 					},
 				},
 				{
@@ -58,7 +57,6 @@ func TestPartialMerge(t *testing.T) {
 						Timestamp: time.Now(),
 						NodeID:    uuid.New(),
 						Lamport:   2,
-						Vector:    []int64{2},
 					},
 				},
 			},
@@ -67,7 +65,7 @@ func TestPartialMerge(t *testing.T) {
 	}
 
 	for _, c := range testCommits {
-		assert.NoError(t, commits.SaveCommitFile(filepath.Join(repoPath, repo.EvoDir, "commits", "feature"), &c))
+		assert.NoError(t, commits.SaveCommitTo(repoPath, "feature", &c))
 	}
 
 	// Test partial merge with file ID filter
@@ -1,12 +1,20 @@
 package streams
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"context"
+	"errors"
 	"evo/internal/commits"
+	"evo/internal/crdt"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/merge"
+	"evo/internal/node"
 	"evo/internal/ops"
+	"evo/internal/progress"
 	"evo/internal/repo"
 	"evo/internal/types"
+	"evo/internal/validate"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,28 +25,114 @@ import (
 )
 
 func CreateStream(repoPath, name string) error {
-	sdir := filepath.Join(repoPath, repo.EvoDir, "streams")
+	return createStream(repoPath, name, nil)
+}
+
+func createStream(repoPath, name string, content []byte) error {
+	if !repo.ValidStreamName(name) {
+		return fmt.Errorf("invalid stream name %q", name)
+	}
+	sdir := filepath.Join(repo.Dir(repoPath), "streams")
 	if err := os.MkdirAll(sdir, 0755); err != nil {
 		return err
 	}
 	fpath := filepath.Join(sdir, name)
 	if _, err := os.Stat(fpath); err == nil {
-		return fmt.Errorf("stream '%s' already exists", name)
+		return everrors.Errorf(everrors.ErrStreamExists, "stream '%s' already exists", name)
+	}
+	return os.WriteFile(fpath, content, 0644)
+}
+
+// Origin is where a stream created with CreateStreamFrom started
+type Origin struct {
+	Stream string `json:"stream"`
+	Commit string `json:"commit,omitempty"` // empty when the source had no commits
+}
+
+// CreateStreamFrom creates a stream holding the commits of source up to and
+// including commitID, or all of them when commitID is empty, with their ops,
+// so it starts from that commit's content. The origin is recorded in the
+// stream file.
+func CreateStreamFrom(repoPath, name, source, commitID string) error {
+	if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", source)); err != nil {
+		return everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", source)
+	}
+	var upTo []types.Commit
+	found := commitID == ""
+	err := commits.ForEachCommit(repoPath, source, commits.IterOptions{}, func(c *types.Commit) error {
+		if commitID == "" || !found {
+			upTo = append(upTo, *c)
+			found = found || c.ID == commitID
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return everrors.Errorf(everrors.ErrNotFound, "commit %s is not in stream %s", commitID, source)
+	}
+	origin := Origin{Stream: source}
+	if len(upTo) > 0 {
+		origin.Commit = upTo[len(upTo)-1].ID
+	}
+	if err := createStream(repoPath, name, []byte(origin.Stream+" "+origin.Commit+"\n")); err != nil {
+		return err
+	}
+	if _, err := applyCommits(context.Background(), repoPath, upTo, name, merge.StrategyCRDT); err != nil {
+		// leave no half-copied stream behind
+		logs, _ := ops.ListLogs(filepath.Join(repo.Dir(repoPath), "ops", name))
+		for _, l := range logs {
+			ops.RemoveLog(l)
+		}
+		for _, d := range []string{"streams", "ops"} {
+			os.RemoveAll(filepath.Join(repo.Dir(repoPath), d, name))
+		}
+		commits.RemoveStream(repoPath, name)
+		return fmt.Errorf("failed to copy history into %s: %w", name, err)
+	}
+	logger.Info("created stream", "stream", name, "source", source, "commit", origin.Commit, "commits", len(upTo))
+	return nil
+}
+
+// StreamOrigin returns where a stream was created from, or nil for a stream
+// created empty
+func StreamOrigin(repoPath, name string) (*Origin, error) {
+	b, err := os.ReadFile(filepath.Join(repo.Dir(repoPath), "streams", name))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	o := &Origin{Stream: fields[0]}
+	if len(fields) > 1 {
+		o.Commit = fields[1]
+	}
+	return o, nil
+}
+
+// SetOrigin rewrites where a stream started, as when the commit it started
+// from was rewritten
+func SetOrigin(repoPath, name string, o Origin) error {
+	fpath := filepath.Join(repo.Dir(repoPath), "streams", name)
+	if _, err := os.Stat(fpath); err != nil {
+		return everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", name)
 	}
-	return os.WriteFile(fpath, []byte{}, 0644)
+	return os.WriteFile(fpath, []byte(o.Stream+" "+o.Commit+"\n"), 0644)
 }
 
 func SwitchStream(repoPath, name string) error {
-	fpath := filepath.Join(repoPath, repo.EvoDir, "streams", name)
+	fpath := filepath.Join(repo.Dir(repoPath), "streams", name)
 	if _, err := os.Stat(fpath); os.IsNotExist(err) {
-		return fmt.Errorf("stream '%s' does not exist", name)
+		return everrors.Errorf(everrors.ErrNotFound, "stream '%s' does not exist", name)
 	}
-	head := filepath.Join(repoPath, repo.EvoDir, "HEAD")
-	return os.WriteFile(head, []byte(name), 0644)
+	return repo.WriteHead(repoPath, repo.Head{Stream: name})
 }
 
 func ListStreams(repoPath string) ([]string, error) {
-	dir := filepath.Join(repoPath, repo.EvoDir, "streams")
+	dir := filepath.Join(repo.Dir(repoPath), "streams")
 	entries, err := os.ReadDir(dir)
 	if os.IsNotExist(err) {
 		return []string{}, nil
@@ -56,138 +150,574 @@ func ListStreams(repoPath string) ([]string, error) {
 }
 
 func CurrentStream(repoPath string) (string, error) {
-	head := filepath.Join(repoPath, repo.EvoDir, "HEAD")
-	b, err := os.ReadFile(head)
+	head, err := repo.ReadHead(repoPath)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(b)), nil
+	return head.Stream, nil
 }
 
+var logger = log.For("streams")
+
 // MergeStreams => merges all missing commits from source => target
-func MergeStreams(repoPath, source, target string) error {
+func MergeStreams(ctx context.Context, repoPath, source, target string) error {
+	return MergeStreamsWithStrategy(ctx, repoPath, source, target, merge.StrategyCRDT)
+}
+
+// MergeStreamsWithStrategy merges all missing commits from source into target,
+// resolving conflicting line edits with the given strategy and per-path drivers.
+// Cancelling ctx stops the merge and takes back what it wrote to target.
+func MergeStreamsWithStrategy(ctx context.Context, repoPath, source, target string, strategy merge.Strategy) error {
+	_, err := MergeReport(ctx, repoPath, source, target, strategy)
+	return err
+}
+
+// MergeReport is MergeStreamsWithStrategy, also reporting the files the
+// merged commits change and the conflicting line edits among them
+func MergeReport(ctx context.Context, repoPath, source, target string, strategy merge.Strategy) (*Applied, error) {
 	srcCommits, err := ListCommits(repoPath, source)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tgtCommits, err := ListCommits(repoPath, target)
+	merged, err := applyCommits(ctx, repoPath, srcCommits, target, strategy)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tgtMap := make(map[string]bool)
-	for _, c := range tgtCommits {
-		tgtMap[c.ID] = true
+	if len(merged.Commits) > 0 {
+		logger.Info("merged streams", "source", source, "target", target, "commits", len(merged.Commits))
 	}
-	var missing []types.Commit
-	for _, sc := range srcCommits {
-		if !tgtMap[sc.ID] {
-			missing = append(missing, sc)
+	return merged, nil
+}
+
+// Receive applies a pushed history of stream, given oldest first, creating the
+// stream if needed. Commits the stream already has are skipped; the rest are
+// merged like commits of another stream. It returns the applied commits.
+func Receive(repoPath, stream string, incoming []types.Commit) ([]types.Commit, error) {
+	applied, err := ReceiveReport(context.Background(), repoPath, stream, incoming)
+	if err != nil {
+		return nil, err
+	}
+	return applied.Commits, nil
+}
+
+// Applied is what applying commits to a stream did
+type Applied struct {
+	Commits   []types.Commit
+	Files     []string         // IDs of the files the applied ops change, sorted
+	Conflicts []merge.Conflict // lines both sides edited, as they were resolved
+}
+
+// ReceiveReport is Receive, also reporting the files the applied commits
+// change and the conflicting line edits among them. Cancelling ctx takes
+// back what it wrote, as for a merge.
+func ReceiveReport(ctx context.Context, repoPath, stream string, incoming []types.Commit) (*Applied, error) {
+	if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", stream)); os.IsNotExist(err) {
+		if err := CreateStream(repoPath, stream); err != nil {
+			return nil, err
 		}
 	}
+	applied, err := applyCommits(ctx, repoPath, incoming, stream, merge.StrategyCRDT)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("received commits", "stream", stream, "commits", len(applied.Commits), "conflicts", len(applied.Conflicts))
+	return applied, nil
+}
+
+// Paths returns the paths this repository knows for the files cs change, by
+// file ID, to send along with the commits: ops name files by ID alone
+func Paths(repoPath string, cs []types.Commit) (map[string]string, error) {
+	known, err := index.KnownPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, c := range cs {
+		for _, eop := range c.Operations {
+			fid := eop.Op.FileID.String()
+			if p, ok := known[fid]; ok {
+				out[fid] = p
+			}
+		}
+	}
+	return out, nil
+}
+
+// Unreceived lists the commits of a pushed history that stream lacks
+func Unreceived(repoPath, stream string, incoming []types.Commit) ([]types.Commit, error) {
+	return missingCommits(repoPath, incoming, stream)
+}
+
+// applyCommits copies the commits of srcCommits that target lacks into
+// target and reports what that did. If ctx is cancelled or a commit fails,
+// the ops and commit files written are taken back, leaving target as it was.
+// The commits applied are reported to the progress reporter of ctx.
+func applyCommits(ctx context.Context, repoPath string, srcCommits []types.Commit, target string, strategy merge.Strategy) (*Applied, error) {
+	missing, err := missingCommits(repoPath, srcCommits, target)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("applying commits", "target", target, "missing", len(missing), "strategy", strategy)
+	if len(missing) == 0 {
+		return &Applied{}, nil
+	}
+	// a commit with a malformed ID or op is refused before anything is
+	// written; lines may come with ops still to arrive, so only the ops are
+	// checked
 	for _, mc := range missing {
-		// replicate each op into .evo/ops/<target>/<fileID>.bin
-		if err := replicateOps(repoPath, target, mc.Operations); err != nil {
-			return err
+		if !commits.ValidID(mc.ID) {
+			return nil, fmt.Errorf("invalid commit ID %q", mc.ID)
+		}
+		for _, eop := range mc.Operations {
+			if err := validate.Op(eop.Op); err != nil {
+				return nil, fmt.Errorf("commit %s: %w", mc.ID, err)
+			}
+		}
+	}
+	resolver, err := merge.NewResolver(repoPath, strategy)
+	if err != nil {
+		return nil, err
+	}
+	local, err := localOnlyOps(repoPath, target, srcCommits)
+	if err != nil {
+		return nil, err
+	}
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	queue := newCausalQueue(repoPath, target)
+	rep := newReplicator(repoPath, target)
+	files := make(map[string]bool)
+	var saved []string // IDs of the commit files written
+	fail := func(err error) (*Applied, error) {
+		errs := []error{rep.undo()}
+		for _, id := range saved {
+			if err := commits.RemoveCommit(repoPath, target, id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if rerr := errors.Join(errs...); rerr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to roll back %s: %w", target, rerr))
+		}
+		logger.Debug("rolled back applying commits", "target", target, "commits", len(saved), "err", err)
+		return nil, err
+	}
+
+	pr := progress.From(ctx)
+	pr.Start("Applying commits", int64(len(missing)))
+	defer pr.Done()
+	for _, mc := range missing {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		resolved, err := resolver.Resolve(local, mc.Operations)
+		if err != nil {
+			return fail(err)
+		}
+		// replicate each op into .evo/ops/<target>/<fileID>.bin once the ops
+		// it depends on are there
+		ready, err := queue.add(resolved)
+		if err != nil {
+			return fail(err)
+		}
+		if err := rep.add(ready); err != nil {
+			return fail(err)
+		}
+		for _, eop := range resolved {
+			self.Observe(eop.Op)
+			files[eop.Op.FileID.String()] = true
 		}
 		// store a commit copy in target
 		c2 := mc
 		c2.Stream = target
-		if err := commits.SaveCommitFile(filepath.Join(repoPath, repo.EvoDir, "commits", target), &c2); err != nil {
-			return err
+		c2.Operations = resolved
+		held, err := commits.HasCommit(repoPath, target, c2.ID)
+		if err != nil {
+			return fail(err)
+		}
+		if !held {
+			saved = append(saved, c2.ID)
+		}
+		if err := commits.SaveCommitTo(repoPath, target, &c2); err != nil {
+			return fail(err)
 		}
+		logger.Trace("merged commit", "id", mc.ID, "target", target, "ops", len(resolved), "ready", len(ready))
+		pr.Add(1)
 	}
-	return nil
+	// whatever is still waiting depends on ops neither stream has (e.g. pruned
+	// by compaction), so it will never become ready
+	stuck := queue.flush()
+	if len(stuck) > 0 {
+		logger.Warn("replicating ops whose causal dependencies are missing", "target", target, "ops", len(stuck))
+	}
+	if err := rep.add(stuck); err != nil {
+		return fail(err)
+	}
+	if err := self.Save(); err != nil {
+		return fail(err)
+	}
+	applied := &Applied{Commits: missing, Conflicts: resolver.Conflicts()}
+	for id := range files {
+		applied.Files = append(applied.Files, id)
+	}
+	sort.Strings(applied.Files)
+	return applied, nil
+}
+
+// MissingCommits lists the commits of source that a merge would bring into
+// target, oldest first. Source commits are read one at a time and only the
+// missing ones kept.
+func MissingCommits(repoPath, source, target string) ([]types.Commit, error) {
+	known, err := KnownCommits(repoPath, target)
+	if err != nil {
+		return nil, err
+	}
+	var missing []types.Commit
+	err = commits.ForEachCommit(repoPath, source, commits.IterOptions{}, func(c *types.Commit) error {
+		if Lacks(known, c) {
+			missing = append(missing, *c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+func missingCommits(repoPath string, srcCommits []types.Commit, target string) ([]types.Commit, error) {
+	known, err := KnownCommits(repoPath, target)
+	if err != nil {
+		return nil, err
+	}
+	var missing []types.Commit
+	for i := range srcCommits {
+		if Lacks(known, &srcCommits[i]) {
+			missing = append(missing, srcCommits[i])
+		}
+	}
+	return missing, nil
+}
+
+// KnownCommits returns the IDs of a stream's commits, of the commits they
+// were picked from and of those squashed into its baselines
+func KnownCommits(repoPath, stream string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	err := commits.ForEachCommit(repoPath, stream, commits.IterOptions{}, func(c *types.Commit) error {
+		known[c.ID] = true
+		if c.PickedFrom != "" {
+			known[c.PickedFrom] = true
+		}
+		for _, id := range c.Squashed {
+			known[id] = true
+		}
+		return nil
+	})
+	return known, err
+}
+
+// Lacks reports whether a stream knowing the given commits lacks c. Commits
+// already picked into it, and picks of its commits, are not missing.
+func Lacks(known map[string]bool, c *types.Commit) bool {
+	return !known[c.ID] && (c.PickedFrom == "" || !known[c.PickedFrom]) && !allKnown(known, c.Squashed)
+}
+
+// causalQueue holds incoming ops back until the ops they were made on top of
+// are in the target's op log, with one causal buffer per file
+type causalQueue struct {
+	dir     string
+	buffers map[uuid.UUID]*crdt.CausalBuffer
+}
+
+func newCausalQueue(repoPath, stream string) *causalQueue {
+	return &causalQueue{
+		dir:     filepath.Join(repo.Dir(repoPath), "ops", stream),
+		buffers: make(map[uuid.UUID]*crdt.CausalBuffer),
+	}
+}
+
+// add queues ops and returns those that can be appended now, in causal order
+func (q *causalQueue) add(eops []commits.ExtendedOp) ([]commits.ExtendedOp, error) {
+	byFile := make(map[uuid.UUID][]crdt.Operation)
+	var files []uuid.UUID
+	for _, eop := range eops {
+		fid := eop.Op.FileID
+		if _, ok := byFile[fid]; !ok {
+			files = append(files, fid)
+		}
+		byFile[fid] = append(byFile[fid], eop.Op)
+	}
+	var out []commits.ExtendedOp
+	for _, fid := range files {
+		buf, ok := q.buffers[fid]
+		if !ok {
+			existing, err := ops.LoadAllOps(filepath.Join(q.dir, fid.String()+".bin"))
+			if err != nil {
+				return nil, err
+			}
+			buf = crdt.NewCausalBuffer(crdt.Knowledge(existing))
+			q.buffers[fid] = buf
+		}
+		for _, op := range buf.Add(byFile[fid]...) {
+			out = append(out, commits.ExtendedOp{Op: op})
+		}
+	}
+	return out, nil
+}
+
+// flush returns every op still waiting
+func (q *causalQueue) flush() []commits.ExtendedOp {
+	var out []commits.ExtendedOp
+	for _, buf := range q.buffers {
+		for _, op := range buf.Flush() {
+			out = append(out, commits.ExtendedOp{Op: op})
+		}
+	}
+	return out
 }
 
+// allKnown reports whether a baseline only squashes commits the target has
+func allKnown(known map[string]bool, squashed []string) bool {
+	if len(squashed) == 0 {
+		return false
+	}
+	for _, id := range squashed {
+		if !known[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// localOnlyOps returns the target's ops on files touched by srcCommits that
+// none of srcCommits contain, i.e. edits made concurrently in the target
+func localOnlyOps(repoPath, target string, srcCommits []types.Commit) ([]crdt.Operation, error) {
+	known := make(map[string]bool)
+	files := make(map[uuid.UUID]bool)
+	for _, c := range srcCommits {
+		for _, eop := range c.Operations {
+			known[opKey(eop.Op)] = true
+			files[eop.Op.FileID] = true
+		}
+	}
+	var local []crdt.Operation
+	for fid := range files {
+		binPath := filepath.Join(repo.Dir(repoPath), "ops", target, fid.String()+".bin")
+		all, err := ops.LoadAllOps(binPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, op := range all {
+			if !known[opKey(op)] {
+				local = append(local, op)
+			}
+		}
+	}
+	sort.Slice(local, func(i, j int) bool {
+		return local[i].LessThan(&local[j])
+	})
+	return local, nil
+}
+
+func opKey(op crdt.Operation) string {
+	return fmt.Sprintf("%d_%s_%s", op.Lamport, op.NodeID.String(), op.LineID.String())
+}
+
+// replicateOps appends ops to the stream's op logs, skipping those already
+// there
 func replicateOps(repoPath, stream string, eops []commits.ExtendedOp) error {
+	return newReplicator(repoPath, stream).add(eops)
+}
+
+// replicator appends ops to a stream's op logs, skipping ops a log already
+// has (same Lamport, NodeID and LineID) so repeated merges and picks never
+// insert a line twice
+type replicator struct {
+	dir   string
+	known map[uuid.UUID]map[string]bool
+	marks map[string]int64 // logs appended to, as they were before
+	added int
+}
+
+func newReplicator(repoPath, stream string) *replicator {
+	return &replicator{
+		dir:   filepath.Join(repo.Dir(repoPath), "ops", stream),
+		known: make(map[uuid.UUID]map[string]bool),
+		marks: make(map[string]int64),
+	}
+}
+
+func (r *replicator) add(eops []commits.ExtendedOp) error {
+	skipped := 0
 	for _, eop := range eops {
-		fileID := eop.Op.FileID.String()
-		binPath := filepath.Join(repoPath, repo.EvoDir, "ops", stream, fileID+".bin")
-		if err := ops.AppendOp(binPath, eop.Op); err != nil {
+		fid := eop.Op.FileID
+		binPath := filepath.Join(r.dir, fid.String()+".bin")
+		known, ok := r.known[fid]
+		if !ok {
+			all, err := ops.LoadAllOps(binPath)
+			if err != nil {
+				return err
+			}
+			known = make(map[string]bool, len(all))
+			for _, op := range all {
+				known[opKey(op)] = true
+			}
+			r.known[fid] = known
+			r.marks[binPath] = ops.Mark(binPath)
+		}
+		k := opKey(eop.Op)
+		if known[k] {
+			skipped++
+			continue
+		}
+		if err := ops.AppendRef(binPath, eop.Op); err != nil {
 			return err
 		}
+		known[k] = true
+		r.added++
+	}
+	if skipped > 0 {
+		logger.Debug("skipped ops already replicated", "dir", r.dir, "ops", skipped)
 	}
 	return nil
 }
 
-// CherryPick => replicate a single commit into the target
+// undo takes back the ops the replicator appended
+func (r *replicator) undo() error {
+	return ops.Restore(r.marks)
+}
+
+// ErrAlreadyPicked is returned when the commit is already present in the target
+var ErrAlreadyPicked = errors.New("commit already present in target stream")
+
+// CherryPick => replicate a single commit into the target under fresh op identity
 func CherryPick(repoPath, commitID, target string) error {
-	allStreams, err := ListStreams(repoPath)
+	idx, err := commits.LoadIndex(repoPath)
 	if err != nil {
 		return err
 	}
-	var found *types.Commit
-OUTER:
-	for _, s := range allStreams {
-		cc, _ := ListCommits(repoPath, s)
-		for _, c := range cc {
-			if c.ID == commitID {
-				found = &c
-				break OUTER
-			}
+	where, err := idx.Find(commitID)
+	if err != nil {
+		return err
+	}
+	if len(where) == 0 {
+		return everrors.Errorf(everrors.ErrNotFound, "commit %s not found in any stream", commitID)
+	}
+	idx.Save()
+	found, err := commits.ReadCommit(repoPath, where[0].Stream, where[0].ID)
+	if err != nil {
+		return err
+	}
+	origin := found.ID
+	if found.PickedFrom != "" {
+		origin = found.PickedFrom
+	}
+	picked := false
+	err = commits.ForEachCommit(repoPath, target, commits.IterOptions{}, func(c *types.Commit) error {
+		if c.ID == origin || c.PickedFrom == origin {
+			picked = true
+			return commits.SkipAll
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if picked {
+		return fmt.Errorf("%w: %s", ErrAlreadyPicked, commitID)
 	}
-	if found == nil {
-		return fmt.Errorf("commit %s not found in any stream", commitID)
+	self, err := node.Load(repoPath)
+	if err != nil {
+		return err
+	}
+	lamport, err := maxLamport(repoPath, target)
+	if err != nil {
+		return err
+	}
+	self.Clock.Observe(lamport)
+	remapped, err := remapOps(found.Operations, target, self, ops.NewStamper(repoPath, target))
+	if err != nil {
+		return err
+	}
+	// a picked op on a line the target never had would change nothing
+	if err := validate.Ops(repoPath, target, validate.Options{Stream: target, Lines: true}, remapped); err != nil {
+		return fmt.Errorf("can't pick %s into %s: %w", commitID, target, err)
+	}
+	if err := self.Save(); err != nil {
+		return err
 	}
 	// replicate ops
-	if err := replicateOps(repoPath, target, found.Operations); err != nil {
+	if err := replicateOps(repoPath, target, remapped); err != nil {
 		return err
 	}
-	// store new commit with new ID
-	newID := uuid.New().String()
+	// store new commit with new ID, remembering where it came from
 	nc := *found
-	nc.ID = newID
+	nc.ID = uuid.New().String()
 	nc.Stream = target
 	nc.Message = "[cherry-pick] " + found.Message
-	return commits.SaveCommitFile(filepath.Join(repoPath, repo.EvoDir, "commits", target), &nc)
+	nc.Operations = remapped
+	nc.PickedFrom = origin
+	nc.Signature = ""
+	return commits.SaveCommitTo(repoPath, target, &nc)
 }
 
-func ListCommits(repoPath, stream string) ([]types.Commit, error) {
-	dir := filepath.Join(repoPath, repo.EvoDir, "commits", stream)
-	entries, err := os.ReadDir(dir)
-	if os.IsNotExist(err) {
-		return []types.Commit{}, nil
+// remapOps gives picked ops a new stamp so they never collide with the
+// originals: this node's ID and fresh Lamport times from its clock. Lines
+// keep their LineIDs, so the source's later edits of a picked line apply to
+// it when the source is merged, and the original insert arriving then
+// revives the same line rather than adding a copy. Vectors are rebuilt from
+// the target's logs since the picked ops now follow the target's history
+// rather than the source's.
+func remapOps(eops []commits.ExtendedOp, target string, self *node.Node, stamper *ops.Stamper) ([]commits.ExtendedOp, error) {
+	out := make([]commits.ExtendedOp, 0, len(eops))
+	for _, eop := range eops {
+		op := eop.Op
+		op.Lamport = self.Tick()
+		op.NodeID = self.ID
+		op.Stream = target
+		if err := stamper.Stamp(&op); err != nil {
+			return nil, err
+		}
+		out = append(out, commits.ExtendedOp{Op: op, OldContent: eop.OldContent})
 	}
+	return out, nil
+}
+
+// maxLamport returns the highest Lamport value in the stream's op logs
+func maxLamport(repoPath, stream string) (uint64, error) {
+	dir := filepath.Join(repo.Dir(repoPath), "ops", stream)
+	logs, err := ops.ListLogs(dir)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	var out []types.Commit
-	for _, e := range entries {
-		if !e.IsDir() && filepath.Ext(e.Name()) == ".bin" {
-			c, err := loadCommit(filepath.Join(dir, e.Name()))
-			if err != nil {
-				return nil, err
+	var max uint64
+	for _, path := range logs {
+		all, err := ops.LoadAllOps(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, op := range all {
+			if op.Lamport > max {
+				max = op.Lamport
 			}
-			out = append(out, *c)
 		}
 	}
-	sort.Slice(out, func(i, j int) bool {
-		return out[i].Timestamp.Before(out[j].Timestamp)
-	})
-	return out, nil
+	return max, nil
 }
 
-func loadCommit(fp string) (*types.Commit, error) {
-	f, err := os.Open(fp)
+// ListCommits returns the commits of a stream, oldest first, without
+// checking signatures
+func ListCommits(repoPath, stream string) ([]types.Commit, error) {
+	out := []types.Commit{}
+	err := commits.ForEachCommit(repoPath, stream, commits.IterOptions{}, func(c *types.Commit) error {
+		out = append(out, *c)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	szBuf := make([]byte, 4)
-	if _, err := f.Read(szBuf); err != nil {
-		return nil, err
-	}
-	size := binary.BigEndian.Uint32(szBuf)
-	data := make([]byte, size)
-	if _, err := f.Read(data); err != nil {
-		return nil, err
-	}
-	var c types.Commit
-	if err := json.Unmarshal(data, &c); err != nil {
-		return nil, err
-	}
-	return &c, nil
+	return out, nil
 }
 
 func getCommit(repoPath, stream, commitID string) (*types.Commit, error) {
@@ -200,5 +730,5 @@ func getCommit(repoPath, stream, commitID string) (*types.Commit, error) {
 			return &c, nil
 		}
 	}
-	return nil, fmt.Errorf("commit %s not found in stream %s", commitID, stream)
+	return nil, everrors.Errorf(everrors.ErrNotFound, "commit %s not found in stream %s", commitID, stream)
 }
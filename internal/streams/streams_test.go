@@ -1,10 +1,16 @@
 package streams
 
 import (
+	"context"
 	"evo/internal/commits"
 	"evo/internal/crdt"
+	"evo/internal/everrors"
+	"evo/internal/merge"
+	"evo/internal/node"
+	"evo/internal/ops"
 	"evo/internal/repo"
 	"evo/internal/types"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -38,7 +44,6 @@ func TestCherryPick(t *testing.T) {
 			Timestamp: time.Now(),
 			NodeID:    uuid.New(),
 			Lamport:   1,
-			Vector:    []int64{1},
 		},
 	}
 	testCommit := types.Commit{
@@ -48,7 +53,7 @@ func TestCherryPick(t *testing.T) {
 		Timestamp:  time.Now(),
 		Operations: []commits.ExtendedOp{testOp},
 	}
-	assert.NoError(t, commits.SaveCommitFile(filepath.Join(repoPath, repo.EvoDir, "commits", "feature"), &testCommit))
+	assert.NoError(t, commits.SaveCommitTo(repoPath, "feature", &testCommit))
 
 	// Test cherry-pick to main stream
 	err := CherryPick(repoPath, testCommit.ID, "main")
@@ -94,7 +99,6 @@ func TestMergeStreams(t *testing.T) {
 					Timestamp: time.Now(),
 					NodeID:    uuid.New(),
 					Lamport:   1,
-					Vector:    []int64{1},
 				},
 			}},
 			Timestamp: time.Now(),
@@ -113,7 +117,6 @@ func TestMergeStreams(t *testing.T) {
 					Timestamp: time.Now(),
 					NodeID:    uuid.New(),
 					Lamport:   2,
-					Vector:    []int64{2},
 				},
 			}},
 			Timestamp: time.Now().Add(time.Second),
@@ -121,15 +124,30 @@ func TestMergeStreams(t *testing.T) {
 	}
 
 	for _, c := range testCommits {
-		assert.NoError(t, commits.SaveCommitFile(filepath.Join(repoPath, repo.EvoDir, "commits", "feature"), &c))
+		assert.NoError(t, commits.SaveCommitTo(repoPath, "feature", &c))
 	}
 
+	// a merge cancelled or failing partway leaves main as it was
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, MergeStreams(ctx, repoPath, "feature", "main"), context.Canceled)
+	blocker := filepath.Join(repoPath, repo.EvoDir, "commits", "main", testCommits[1].ID+".bin")
+	assert.NoError(t, os.MkdirAll(blocker, 0755))
+	assert.Error(t, MergeStreams(context.Background(), repoPath, "feature", "main"))
+	assert.NoError(t, os.Remove(blocker))
+	mainCommits, err := ListCommits(repoPath, "main")
+	assert.NoError(t, err)
+	assert.Empty(t, mainCommits)
+	logged, err := ops.LoadAllOps(filepath.Join(repoPath, repo.EvoDir, "ops", "main", fileID.String()+".bin"))
+	assert.NoError(t, err)
+	assert.Empty(t, logged)
+
 	// Test merge streams
-	err := MergeStreams(repoPath, "feature", "main")
+	err = MergeStreams(context.Background(), repoPath, "feature", "main")
 	assert.NoError(t, err)
 
 	// Verify all commits were replicated
-	mainCommits, err := ListCommits(repoPath, "main")
+	mainCommits, err = ListCommits(repoPath, "main")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(mainCommits))
 	assert.Equal(t, "main", mainCommits[0].Stream)
@@ -137,3 +155,234 @@ func TestMergeStreams(t *testing.T) {
 	assert.Equal(t, "line 1", mainCommits[0].Operations[0].Op.Content)
 	assert.Equal(t, "line 2", mainCommits[1].Operations[0].Op.Content)
 }
+
+func TestCherryPickProvenance(t *testing.T) {
+	tmpDir := t.TempDir()
+	repoPath := filepath.Join(tmpDir, "test-repo")
+	assert.NoError(t, CreateStream(repoPath, "main"))
+	assert.NoError(t, CreateStream(repoPath, "feature"))
+
+	fileID := uuid.New()
+	lineID := uuid.New()
+	nodeID := uuid.New()
+	testCommit := types.Commit{
+		ID:      uuid.New().String(),
+		Stream:  "feature",
+		Message: "feature work",
+		Operations: []commits.ExtendedOp{
+			{Op: crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: lineID, NodeID: nodeID, Lamport: 1, Content: "a"}},
+			{Op: crdt.Operation{Type: crdt.OpUpdate, FileID: fileID, LineID: lineID, NodeID: nodeID, Lamport: 2, Content: "b"}, OldContent: "a"},
+		},
+		Timestamp: time.Now(),
+	}
+	assert.NoError(t, commits.SaveCommitTo(repoPath, "feature", &testCommit))
+
+	assert.NoError(t, CherryPick(repoPath, testCommit.ID, "main"))
+
+	mainCommits, err := ListCommits(repoPath, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mainCommits))
+	picked := mainCommits[0]
+	assert.Equal(t, testCommit.ID, picked.PickedFrom)
+
+	// ops get a new stamp but keep their line
+	ins, upd := picked.Operations[0].Op, picked.Operations[1].Op
+	assert.NotEqual(t, nodeID, ins.NodeID)
+	assert.Equal(t, lineID, ins.LineID)
+	assert.Equal(t, lineID, upd.LineID)
+	assert.True(t, ins.Lamport < upd.Lamport)
+
+	// picking again is skipped
+	err = CherryPick(repoPath, testCommit.ID, "main")
+	assert.ErrorIs(t, err, ErrAlreadyPicked)
+
+	// merging the source later does not duplicate the picked commit
+	assert.NoError(t, MergeStreams(context.Background(), repoPath, "feature", "main"))
+	mainCommits, err = ListCommits(repoPath, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mainCommits))
+}
+
+func TestReplicateSkipsKnownOps(t *testing.T) {
+	repoPath := t.TempDir()
+	fileID, nodeID := uuid.New(), uuid.New()
+	one := crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "one", NodeID: nodeID, Lamport: 1}
+	two := crdt.Operation{Type: crdt.OpInsert, FileID: fileID, LineID: uuid.New(), OriginLineID: one.LineID, Content: "two", NodeID: nodeID, Lamport: 2}
+
+	assert.NoError(t, replicateOps(repoPath, "main", []commits.ExtendedOp{{Op: one}}))
+	// a later partial merge or pick brings the same op again, with a new one
+	assert.NoError(t, replicateOps(repoPath, "main", []commits.ExtendedOp{{Op: one}, {Op: two}, {Op: two}}))
+
+	all, err := ops.LoadAllOps(filepath.Join(repoPath, repo.EvoDir, "ops", "main", fileID.String()+".bin"))
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "one", all[0].Content)
+		assert.Equal(t, "two", all[1].Content)
+	}
+}
+
+func TestCreateStreamFrom(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "test-repo")
+	assert.NoError(t, repo.InitRepo(repoPath))
+
+	fileID, nodeID := uuid.New(), uuid.New()
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		c := types.Commit{
+			ID:      uuid.New().String(),
+			Stream:  "main",
+			Message: fmt.Sprintf("commit %d", i),
+			Operations: []commits.ExtendedOp{{
+				Op: crdt.Operation{
+					Type:      crdt.OpInsert,
+					FileID:    fileID,
+					LineID:    uuid.New(),
+					Content:   fmt.Sprintf("line %d", i),
+					Stream:    "main",
+					Timestamp: time.Now(),
+					NodeID:    nodeID,
+					Lamport:   uint64(i),
+				},
+			}},
+			Timestamp: time.Now().Add(time.Duration(i) * time.Second),
+		}
+		assert.NoError(t, commits.SaveCommitTo(repoPath, "main", &c))
+		ids = append(ids, c.ID)
+	}
+
+	assert.NoError(t, CreateStreamFrom(repoPath, "old", "main", ids[1]))
+	cs, err := ListCommits(repoPath, "old")
+	assert.NoError(t, err)
+	if assert.Len(t, cs, 2) {
+		assert.Equal(t, ids[:2], []string{cs[0].ID, cs[1].ID})
+		assert.Equal(t, "old", cs[1].Stream)
+	}
+	_, err = os.Stat(filepath.Join(repoPath, repo.EvoDir, "ops", "old", fileID.String()+".bin"))
+	assert.NoError(t, err, "ops are copied")
+	origin, err := StreamOrigin(repoPath, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, &Origin{Stream: "main", Commit: ids[1]}, origin)
+
+	// all of a stream
+	assert.NoError(t, CreateStreamFrom(repoPath, "all", "main", ""))
+	cs, err = ListCommits(repoPath, "all")
+	assert.NoError(t, err)
+	assert.Len(t, cs, 3)
+	origin, err = StreamOrigin(repoPath, "all")
+	assert.NoError(t, err)
+	assert.Equal(t, ids[2], origin.Commit)
+
+	// an empty source, and a stream created empty
+	assert.NoError(t, CreateStream(repoPath, "empty"))
+	assert.NoError(t, CreateStreamFrom(repoPath, "empty2", "empty", ""))
+	origin, err = StreamOrigin(repoPath, "empty2")
+	assert.NoError(t, err)
+	assert.Equal(t, &Origin{Stream: "empty"}, origin)
+	origin, err = StreamOrigin(repoPath, "empty")
+	assert.NoError(t, err)
+	assert.Nil(t, origin)
+
+	assert.ErrorContains(t, CreateStreamFrom(repoPath, "x", "main", "nope"), "is not in stream main")
+	assert.ErrorContains(t, CreateStreamFrom(repoPath, "x", "missing", ""), "does not exist")
+	assert.ErrorIs(t, CreateStreamFrom(repoPath, "old", "main", ""), everrors.ErrStreamExists)
+	assert.ErrorContains(t, CreateStream(repoPath, "a/b"), "invalid stream name")
+	_, err = os.Stat(filepath.Join(repoPath, repo.EvoDir, "streams", "x"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReceiveRejectsMalformedOps(t *testing.T) {
+	repoPath := t.TempDir()
+	assert.NoError(t, repo.InitRepo(repoPath))
+	fid := uuid.New()
+	good := crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: uuid.New(), FileID: fid, LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "ok"}
+	bad := good
+	bad.Lamport, bad.LineID = 2, uuid.Nil
+	incoming := []types.Commit{
+		{ID: "c1", Stream: "main", Timestamp: time.Now(), Operations: []types.ExtendedOp{{Op: good}}},
+		{ID: "c2", Stream: "main", Timestamp: time.Now(), Operations: []types.ExtendedOp{{Op: bad}}},
+	}
+	_, err := Receive(repoPath, "main", incoming)
+	assert.ErrorContains(t, err, "commit c2: invalid op")
+
+	// nothing of the push was written
+	cs, err := ListCommits(repoPath, "main")
+	assert.NoError(t, err)
+	assert.Empty(t, cs)
+	all, err := ops.LoadAllOps(filepath.Join(repo.Dir(repoPath), "ops", "main", fid.String()+".bin"))
+	assert.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestUnionMergeStamps(t *testing.T) {
+	repoPath := t.TempDir()
+	assert.NoError(t, CreateStream(repoPath, "main"))
+	assert.NoError(t, CreateStream(repoPath, "feature"))
+	fileID, lineID := uuid.New(), uuid.New()
+	ours, theirs := uuid.New(), uuid.New()
+	commit := func(stream string, op crdt.Operation) types.Commit {
+		op.FileID, op.LineID, op.Stream, op.Timestamp = fileID, lineID, stream, time.Now()
+		return types.Commit{ID: uuid.New().String(), Stream: stream, Message: "edit", Timestamp: time.Now(),
+			Operations: []commits.ExtendedOp{{Op: op}}}
+	}
+	base := commit("main", crdt.Operation{Type: crdt.OpInsert, Lamport: 1, NodeID: ours, OriginLineID: crdt.DocumentStart, Content: "base", Vector: crdt.VectorClock{ours: 1}})
+	for _, s := range []string{"main", "feature"} {
+		_, err := Receive(repoPath, s, []types.Commit{base})
+		assert.NoError(t, err)
+	}
+	_, err := Receive(repoPath, "main", []types.Commit{commit("main", crdt.Operation{Type: crdt.OpUpdate, Lamport: 5, NodeID: ours, Content: "ours", Vector: crdt.VectorClock{ours: 5}})})
+	assert.NoError(t, err)
+
+	// the feature edits the line twice, merged with union after each edit
+	for i, lamport := range []uint64{3, 4} {
+		_, err := Receive(repoPath, "feature", []types.Commit{commit("feature", crdt.Operation{Type: crdt.OpUpdate, Lamport: lamport, NodeID: theirs, Content: fmt.Sprint("theirs ", i), Vector: crdt.VectorClock{ours: 1, theirs: lamport}})})
+		assert.NoError(t, err)
+		assert.NoError(t, MergeStreamsWithStrategy(context.Background(), repoPath, "feature", "main", merge.StrategyUnion))
+	}
+
+	logged, err := ops.LoadAllOps(filepath.Join(repoPath, repo.EvoDir, "ops", "main", fileID.String()+".bin"))
+	assert.NoError(t, err)
+	stamps := make(map[string]bool)
+	siblings := 0
+	for _, op := range logged {
+		key := fmt.Sprint(op.Lamport, op.NodeID)
+		assert.False(t, stamps[key], "two ops stamped %s", key)
+		stamps[key] = true
+		if op.OriginLineID == lineID {
+			siblings++
+		}
+	}
+	assert.Equal(t, 2, siblings)
+}
+
+func TestCherryPickThenEdit(t *testing.T) {
+	repoPath := t.TempDir()
+	assert.NoError(t, CreateStream(repoPath, "main"))
+	assert.NoError(t, CreateStream(repoPath, "feature"))
+	fileID, lineID := uuid.New(), uuid.New()
+	commit := func(op crdt.Operation) types.Commit {
+		self, err := node.Load(repoPath)
+		assert.NoError(t, err)
+		lamport := self.Tick()
+		assert.NoError(t, self.Save())
+		op.FileID, op.LineID, op.NodeID, op.Lamport, op.Stream, op.Timestamp = fileID, lineID, self.ID, lamport, "feature", time.Now()
+		op.Vector = crdt.VectorClock{self.ID: lamport}
+		c := types.Commit{ID: uuid.New().String(), Stream: "feature", Message: "edit", Timestamp: time.Now(), Operations: []commits.ExtendedOp{{Op: op}}}
+		_, err = Receive(repoPath, "feature", []types.Commit{c})
+		assert.NoError(t, err)
+		return c
+	}
+	added := commit(crdt.Operation{Type: crdt.OpInsert, OriginLineID: crdt.DocumentStart, Content: "draft"})
+	assert.NoError(t, CherryPick(repoPath, added.ID, "main"))
+
+	// the source edits the picked line, then is merged
+	commit(crdt.Operation{Type: crdt.OpUpdate, Content: "final"})
+	assert.NoError(t, MergeStreams(context.Background(), repoPath, "feature", "main"))
+
+	logged, err := ops.LoadAllOps(filepath.Join(repoPath, repo.EvoDir, "ops", "main", fileID.String()+".bin"))
+	assert.NoError(t, err)
+	doc := crdt.NewRGA()
+	for _, op := range logged {
+		assert.NoError(t, doc.Apply(op))
+	}
+	assert.Equal(t, []string{"final"}, doc.Materialize())
+}
@@ -0,0 +1,239 @@
+// Package template seeds new repositories. A template is a directory, or a
+// .tar.gz of one given as a path or an http(s) URL, holding any of:
+//
+//	.evo-ignore       copied into the working tree
+//	.evo-attributes   copied into the working tree
+//	config.toml       the repository config
+//	hooks/            copied into .evo/hooks, keeping file modes
+//	streams           streams to create, one per line; the first is checked
+//	                  out unless a default stream is given
+//
+// An archive whose entries all sit in one top-level directory is read from
+// inside it.
+package template
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Template is an opened template
+type Template struct {
+	Dir string
+	tmp string // extracted archive, removed by Close
+}
+
+// Open opens the template at src
+func Open(src string) (*Template, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return fetch(src)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open template: %w", err)
+	}
+	if fi.IsDir() {
+		return &Template{Dir: src}, nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open template: %w", err)
+	}
+	defer f.Close()
+	return extract(f)
+}
+
+func fetch(url string) (*Template, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch template: %s", resp.Status)
+	}
+	return extract(resp.Body)
+}
+
+// extract unpacks a .tar.gz into a temporary directory
+func extract(r io.Reader) (*Template, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("template is neither a directory nor a .tar.gz: %w", err)
+	}
+	tmp, err := os.MkdirTemp("", "evo-template-")
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{Dir: tmp, tmp: tmp}
+	if err := untar(tar.NewReader(gz), tmp); err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to extract template: %w", err)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	if len(entries) == 1 && entries[0].IsDir() && entries[0].Name() != "hooks" {
+		t.Dir = filepath.Join(tmp, entries[0].Name())
+	}
+	return t, nil
+}
+
+func untar(tr *tar.Reader, dest string) error {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(strings.TrimPrefix(h.Name, "./"))
+		if name == "" || name == "." {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("unsafe path %q in archive", h.Name)
+		}
+		path := filepath.Join(dest, name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(path, tr, fs.FileMode(h.Mode).Perm()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry %q in archive", h.Name)
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close removes what Open extracted
+func (t *Template) Close() error {
+	if t.tmp == "" {
+		return nil
+	}
+	return os.RemoveAll(t.tmp)
+}
+
+// Streams returns the streams the template asks for, the one to check out
+// first
+func (t *Template) Streams() ([]string, error) {
+	f, err := os.Open(filepath.Join(t.Dir, "streams"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name := strings.TrimSpace(sc.Text())
+		if name == "" || strings.HasPrefix(name, "#") || seen[name] {
+			continue
+		}
+		if !repo.ValidStreamName(name) {
+			return nil, fmt.Errorf("template lists invalid stream name %q", name)
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	return out, sc.Err()
+}
+
+// Apply copies the template's files into the repository at repoPath. Files
+// already in the working tree are kept; a bare repository gets only the config
+// and hooks. It returns the files it wrote, relative to repoPath.
+func (t *Template) Apply(repoPath string) ([]string, error) {
+	var written []string
+	cp := func(src, dst string) error {
+		in, err := os.Open(filepath.Join(t.Dir, src))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		fi, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repoPath, dst)), 0755); err != nil {
+			return err
+		}
+		err = writeFile(filepath.Join(repoPath, dst), in, fi.Mode().Perm())
+		if os.IsExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy template file %s: %w", src, err)
+		}
+		written = append(written, filepath.ToSlash(dst))
+		return nil
+	}
+	// a bare repository has no working tree and keeps .evo's content at its
+	// root
+	store := repo.EvoDir
+	if repo.IsBare(repoPath) {
+		store = "."
+	} else {
+		for _, name := range []string{".evo-ignore", ".evo-attributes"} {
+			if err := cp(name, name); err != nil {
+				return written, err
+			}
+		}
+	}
+	if err := cp("config.toml", filepath.Join(store, "config", "config.toml")); err != nil {
+		return written, err
+	}
+	hooks := filepath.Join(t.Dir, "hooks")
+	err := filepath.WalkDir(hooks, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(t.Dir, path)
+		if err != nil {
+			return err
+		}
+		return cp(rel, filepath.Join(store, rel))
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return written, err
+	}
+	return written, nil
+}
@@ -0,0 +1,115 @@
+package template
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"evo/internal/repo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, dir string) {
+	files := map[string]string{
+		".evo-ignore":       "*.log\n",
+		".evo-attributes":   "*.png merge=binary\n",
+		"config.toml":       "[review]\nrequiredApprovals = 2\n",
+		"hooks/pre-receive": "#!/bin/sh\nexit 0\n",
+		"streams":           "# initial streams\ndevelop\n\nrelease\ndevelop\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(dir, "hooks", "pre-receive"), 0755))
+}
+
+func tarball(t *testing.T, entries map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestDirectory(t *testing.T) {
+	src, rp := t.TempDir(), t.TempDir()
+	writeTemplate(t, src)
+	require.NoError(t, os.WriteFile(filepath.Join(rp, ".evo-ignore"), []byte("mine\n"), 0644))
+
+	tmpl, err := Open(src)
+	require.NoError(t, err)
+	defer tmpl.Close()
+	streams, err := tmpl.Streams()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"develop", "release"}, streams)
+
+	require.NoError(t, repo.Init(rp, repo.InitOptions{DefaultStream: streams[0], Streams: streams}))
+	files, err := tmpl.Apply(rp)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{".evo-attributes", ".evo/config/config.toml", ".evo/hooks/pre-receive"}, files)
+
+	// files already in the working tree are kept
+	data, err := os.ReadFile(filepath.Join(rp, ".evo-ignore"))
+	require.NoError(t, err)
+	assert.Equal(t, "mine\n", string(data))
+	fi, err := os.Stat(filepath.Join(rp, ".evo", "hooks", "pre-receive"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+}
+
+func TestArchive(t *testing.T) {
+	data := tarball(t, map[string]string{
+		"tmpl/.evo-ignore": "build/\n",
+		"tmpl/streams":     "trunk\n",
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tmpl.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	tmpl, err := Open(ts.URL + "/tmpl.tar.gz")
+	require.NoError(t, err)
+	streams, err := tmpl.Streams()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"trunk"}, streams)
+	rp := t.TempDir()
+	files, err := tmpl.Apply(rp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{".evo-ignore"}, files)
+	extracted := tmpl.tmp
+	require.NoError(t, tmpl.Close())
+	assert.NoDirExists(t, extracted)
+
+	_, err = Open(ts.URL + "/missing.tar.gz")
+	assert.ErrorContains(t, err, "404")
+
+	bad := filepath.Join(t.TempDir(), "bad.tar.gz")
+	require.NoError(t, os.WriteFile(bad, tarball(t, map[string]string{"../escape": "x"}), 0644))
+	_, err = Open(bad)
+	assert.ErrorContains(t, err, "unsafe path")
+
+	require.NoError(t, os.WriteFile(bad, tarball(t, map[string]string{"streams": "a/b\n"}), 0644))
+	tmpl, err = Open(bad)
+	require.NoError(t, err)
+	defer tmpl.Close()
+	_, err = tmpl.Streams()
+	assert.ErrorContains(t, err, "invalid stream name")
+}
@@ -0,0 +1,33 @@
+// Package testutil holds fixtures shared by the tests of several packages
+package testutil
+
+import (
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Commit indexes the working tree, ingests its changes into stream and
+// commits them as ann
+func Commit(t testing.TB, rp, stream, msg string) *types.Commit {
+	t.Helper()
+	return CommitAs(t, rp, stream, "ann", msg)
+}
+
+// CommitAs is Commit by author, whose email is author@example.com
+func CommitAs(t testing.TB, rp, stream, author, msg string) *types.Commit {
+	t.Helper()
+	require.NoError(t, index.UpdateIndex(rp))
+	_, err := ingest.IngestLocalChanges(context.Background(), rp, stream)
+	require.NoError(t, err)
+	eops, err := commits.GatherNewOps(rp, stream)
+	require.NoError(t, err)
+	c, err := commits.CreateCommit(rp, stream, msg, author, author+"@example.com", eops, false)
+	require.NoError(t, err)
+	return c
+}
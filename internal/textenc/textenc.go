@@ -0,0 +1,180 @@
+// Package textenc detects the text encoding of files and converts between
+// it and UTF-8. Op logs hold text as UTF-8 lines; a file in UTF-16, or with
+// a byte order mark, is decoded on ingest and encoded back as it was when
+// written out, so its lines aren't split through multi-byte characters and
+// its BOM doesn't end up in the first line.
+package textenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding names the encoding of a file
+type Encoding string
+
+const (
+	UTF8       Encoding = "utf-8"
+	UTF8BOM    Encoding = "utf-8-bom"
+	UTF16LE    Encoding = "utf-16le"
+	UTF16BE    Encoding = "utf-16be"
+	UTF16LEBOM Encoding = "utf-16le-bom"
+	UTF16BEBOM Encoding = "utf-16be-bom"
+)
+
+var encodings = []Encoding{UTF8, UTF8BOM, UTF16LE, UTF16BE, UTF16LEBOM, UTF16BEBOM}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// sniffSize is how much of a file without BOM is looked at for UTF-16
+const sniffSize = 4096
+
+// Parse returns the encoding called name
+func Parse(name string) (Encoding, error) {
+	for _, e := range encodings {
+		if string(e) == name {
+			return e, nil
+		}
+	}
+	return "", fmt.Errorf("unknown encoding %q", name)
+}
+
+// bom returns the byte order mark of an encoding, nil if it has none
+func (e Encoding) bom() []byte {
+	switch e {
+	case UTF8BOM:
+		return bomUTF8
+	case UTF16LEBOM:
+		return bomUTF16LE
+	case UTF16BEBOM:
+		return bomUTF16BE
+	}
+	return nil
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// order returns the byte order of a UTF-16 encoding, nil for UTF-8
+func (e Encoding) order() byteOrder {
+	switch e {
+	case UTF16LE, UTF16LEBOM:
+		return binary.LittleEndian
+	case UTF16BE, UTF16BEBOM:
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// Detect guesses the encoding of data: by its byte order mark, or as UTF-16
+// without one when most of its characters have a zero byte on one side and
+// none on the other, as ASCII text in UTF-16 does. Only an encoding data
+// decodes in and encodes back to exactly is returned; anything else, binary
+// files included, is UTF8, which keeps the bytes as they are.
+func Detect(data []byte) Encoding {
+	e := sniff(data)
+	if e == UTF8 {
+		return UTF8
+	}
+	text, err := Decode(data, e)
+	if err != nil || !bytes.Equal(Encode(text, e), data) {
+		return UTF8
+	}
+	return e
+}
+
+func sniff(data []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return UTF8BOM
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return UTF16LEBOM
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return UTF16BEBOM
+	}
+	if len(data) < 2 || len(data)%2 != 0 {
+		return UTF8
+	}
+	sample := data[:min(len(data), sniffSize)]
+	var even, odd int // zero bytes at even and odd offsets
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	units := len(sample) / 2
+	switch {
+	case odd*2 > units && even == 0:
+		return UTF16LE
+	case even*2 > units && odd == 0:
+		return UTF16BE
+	}
+	return UTF8
+}
+
+// Decode returns data, in encoding e, as UTF-8 text without byte order mark
+func Decode(data []byte, e Encoding) (string, error) {
+	if bom := e.bom(); bom != nil {
+		if !bytes.HasPrefix(data, bom) {
+			return "", fmt.Errorf("%s text without byte order mark", e)
+		}
+		data = data[len(bom):]
+	}
+	order := e.order()
+	if order == nil {
+		return string(data), nil
+	}
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("%s text of odd length", e)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// Encode returns UTF-8 text in encoding e, with its byte order mark
+func Encode(text string, e Encoding) []byte {
+	order := e.order()
+	if order == nil {
+		return append(append([]byte(nil), e.bom()...), text...)
+	}
+	units := utf16.Encode([]rune(text))
+	out := make([]byte, 0, len(e.bom())+2*len(units))
+	out = append(out, e.bom()...)
+	for _, u := range units {
+		out = order.AppendUint16(out, u)
+	}
+	return out
+}
+
+// Text detects the encoding of data and returns it decoded
+func Text(data []byte) (string, Encoding) {
+	e := Detect(data)
+	if e == UTF8 {
+		return string(data), UTF8
+	}
+	text, _ := Decode(data, e)
+	return text, e
+}
+
+// Valid reports whether text decoded from e can be written back in it: UTF-8
+// text converts to UTF-16 without loss only when it is valid UTF-8
+func (e Encoding) Valid(text string) bool {
+	return e.order() == nil || utf8.ValidString(text)
+}
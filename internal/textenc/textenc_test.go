@@ -0,0 +1,32 @@
+package textenc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	for _, e := range encodings {
+		data := Encode("línea uno\r\nline two\n", e)
+		assert.Equal(t, e, Detect(data), e)
+		text, err := Decode(data, e)
+		assert.NoError(t, err)
+		assert.Equal(t, "línea uno\r\nline two\n", text)
+	}
+
+	// anything that doesn't round-trip is kept as bytes
+	assert.Equal(t, UTF8, Detect(nil))
+	assert.Equal(t, UTF8, Detect([]byte("plain")))
+	assert.Equal(t, UTF8, Detect([]byte{0x00, 0x01, 0x00, 0x00, 0x89, 0x50}))
+	assert.Equal(t, UTF8, Detect([]byte{0xFF, 0xFE, 0x00, 0xD8}))
+
+	text, e := Text([]byte("\xef\xbb\xbfhi"))
+	assert.Equal(t, "hi", text)
+	assert.Equal(t, UTF8BOM, e)
+
+	_, err := Parse("latin-1")
+	assert.Error(t, err)
+	assert.False(t, UTF16LE.Valid("\xff"))
+	assert.True(t, UTF8BOM.Valid("\xff"))
+}
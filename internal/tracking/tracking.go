@@ -0,0 +1,386 @@
+// Package tracking compares streams with their upstreams: how many commits
+// a stream has that its upstream lacks (ahead) and how many it lacks
+// (behind). Commits have no parent links, so two streams are compared as
+// sets of commit IDs, with picks and squashes counting as the commits they
+// stand for, as stream merges do.
+//
+// The upstream of a stream is the upstream key of its [stream.<name>] config
+// section: another stream, or <remote>/<stream> for a stream of a remote as
+// push, pull and fetch last saw it, recorded one commit per line as
+//
+//	.evo/tracking/remotes/<remote>/<stream>  "<id> <picked from or -> <squashed>..."
+//
+// Counts are cached until either side moves, one line per stream:
+//
+//	.evo/tracking/counts  "<stream> <upstream> <head>:<n> <upstream head>:<n> <ahead> <behind>"
+package tracking
+
+import (
+	"bufio"
+	"evo/internal/commits"
+	"evo/internal/config"
+	"evo/internal/everrors"
+	"evo/internal/log"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var logger = log.For("tracking")
+
+// Status is how a stream compares with its upstream
+type Status struct {
+	Stream   string `json:"stream"`
+	Upstream string `json:"upstream,omitempty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+	Error    string `json:"error,omitempty"` // why the upstream couldn't be compared
+}
+
+// Summary describes the status in a sentence, e.g. "ahead of main by 2
+// commit(s)"; it is empty for a stream without upstream
+func (s *Status) Summary() string {
+	switch {
+	case s.Upstream == "":
+		return ""
+	case s.Error != "":
+		return fmt.Sprintf("tracking %s, which can't be compared: %s", s.Upstream, s.Error)
+	case s.Ahead > 0 && s.Behind > 0:
+		return fmt.Sprintf("diverged from %s: %d commit(s) ahead, %d behind", s.Upstream, s.Ahead, s.Behind)
+	case s.Ahead > 0:
+		return fmt.Sprintf("ahead of %s by %d commit(s)", s.Upstream, s.Ahead)
+	case s.Behind > 0:
+		return fmt.Sprintf("behind %s by %d commit(s)", s.Upstream, s.Behind)
+	}
+	return "up to date with " + s.Upstream
+}
+
+// Upstream returns the upstream configured for stream, or "" for none
+func Upstream(repoPath, stream string) (string, error) {
+	cfg, err := config.LoadForStream(repoPath, stream)
+	if err != nil {
+		return "", err
+	}
+	up, _ := cfg.Get("upstream")
+	if up == stream {
+		return "", nil
+	}
+	return up, nil
+}
+
+// SplitRemote splits a <remote>/<stream> upstream; stream names hold no
+// slash, so any upstream with one names a remote stream
+func SplitRemote(upstream string) (remote, stream string, ok bool) {
+	return strings.Cut(upstream, "/")
+}
+
+// Get compares stream with its configured upstream. A failed comparison is
+// reported in the status rather than as an error.
+func Get(repoPath, stream string) (*Status, error) {
+	up, err := Upstream(repoPath, stream)
+	if err != nil {
+		return nil, err
+	}
+	st := &Status{Stream: stream, Upstream: up}
+	if up == "" {
+		return st, nil
+	}
+	if st.Ahead, st.Behind, err = Compare(repoPath, stream, up); err != nil {
+		st.Error = err.Error()
+	}
+	return st, nil
+}
+
+// All compares every stream with its upstream, by stream name
+func All(repoPath string) ([]Status, error) {
+	names, err := streams.ListStreams(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	out := []Status{}
+	for _, name := range names {
+		st, err := Get(repoPath, name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *st)
+	}
+	return out, nil
+}
+
+// Compare counts the commits of stream that upstream lacks and those of
+// upstream that stream lacks, reusing the cached counts while neither moved
+func Compare(repoPath, stream, upstream string) (int, int, error) {
+	idx, err := commits.LoadIndex(repoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	a, err := tip(idx, stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	var b string
+	var remoteCommits []types.Commit
+	remote, rs, isRemote := SplitRemote(upstream)
+	if isRemote {
+		if remoteCommits, err = Recorded(repoPath, remote, rs); os.IsNotExist(err) {
+			return 0, 0, fmt.Errorf("%s has not been fetched; run 'evo pull' or 'evo stream status --fetch'", upstream)
+		} else if err != nil {
+			return 0, 0, err
+		}
+		b = "-:0"
+		if n := len(remoteCommits); n > 0 {
+			b = fmt.Sprintf("%s:%d", remoteCommits[n-1].ID, n)
+		}
+	} else {
+		if _, err := os.Stat(filepath.Join(repo.Dir(repoPath), "streams", upstream)); err != nil {
+			return 0, 0, everrors.Errorf(everrors.ErrNotFound, "upstream %s of %s does not exist", upstream, stream)
+		}
+		if b, err = tip(idx, upstream); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := idx.Save(); err != nil {
+		logger.Warn("failed to save commit index", "err", err)
+	}
+
+	key := strings.Join([]string{stream, upstream, a, b}, " ")
+	cache := loadCounts(repoPath)
+	if rest, ok := strings.CutPrefix(cache[stream], key+" "); ok {
+		if ahead, behind, found := strings.Cut(rest, " "); found {
+			na, err1 := strconv.Atoi(ahead)
+			nb, err2 := strconv.Atoi(behind)
+			if err1 == nil && err2 == nil {
+				return na, nb, nil
+			}
+		}
+	}
+
+	var ahead, behind int
+	if isRemote {
+		ahead, behind, err = compareRemote(repoPath, stream, remoteCommits)
+	} else {
+		ahead, behind, err = compareLocal(repoPath, stream, upstream)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	cache[stream] = fmt.Sprintf("%s %d %d", key, ahead, behind)
+	saveCounts(repoPath, cache)
+	return ahead, behind, nil
+}
+
+// tip names the newest commit of a stream and its number of commits
+func tip(idx *commits.Index, stream string) (string, error) {
+	es, err := idx.Stream(stream)
+	if err != nil || len(es) == 0 {
+		return "-:0", err
+	}
+	return fmt.Sprintf("%s:%d", es[len(es)-1].ID, len(es)), nil
+}
+
+func compareLocal(repoPath, stream, upstream string) (int, int, error) {
+	ahead, err := streams.MissingCommits(repoPath, stream, upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := streams.MissingCommits(repoPath, upstream, stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(ahead), len(behind), nil
+}
+
+func compareRemote(repoPath, stream string, remote []types.Commit) (int, int, error) {
+	theirs := make(map[string]bool)
+	for _, c := range remote {
+		theirs[c.ID] = true
+		if c.PickedFrom != "" {
+			theirs[c.PickedFrom] = true
+		}
+		for _, id := range c.Squashed {
+			theirs[id] = true
+		}
+	}
+	ahead := 0
+	err := commits.ForEachCommit(repoPath, stream, commits.IterOptions{}, func(c *types.Commit) error {
+		if streams.Lacks(theirs, c) {
+			ahead++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	ours, err := streams.KnownCommits(repoPath, stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	behind := 0
+	for i := range remote {
+		if streams.Lacks(ours, &remote[i]) {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func countsPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "tracking", "counts")
+}
+
+func loadCounts(repoPath string) map[string]string {
+	out := make(map[string]string)
+	data, err := os.ReadFile(countsPath(repoPath))
+	if err != nil {
+		return out
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if stream, _, ok := strings.Cut(line, " "); ok {
+			out[stream] = line
+		}
+	}
+	return out
+}
+
+// saveCounts writes the cache; failing to only costs the next lookup time
+func saveCounts(repoPath string, cache map[string]string) {
+	lines := make([]string, 0, len(cache))
+	for _, line := range cache {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	path := countsPath(repoPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Warn("failed to cache ahead/behind counts", "err", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		logger.Warn("failed to cache ahead/behind counts", "err", err)
+		return
+	}
+	if err := platform.Rename(tmp, path); err != nil {
+		logger.Warn("failed to cache ahead/behind counts", "err", err)
+	}
+}
+
+func remotePath(repoPath, remote, stream string) string {
+	return filepath.Join(repo.Dir(repoPath), "tracking", "remotes", remote, stream)
+}
+
+// Recorded returns the commits last seen in a remote's stream, oldest first,
+// with only their IDs, PickedFrom and Squashed set
+func Recorded(repoPath, remote, stream string) ([]types.Commit, error) {
+	f, err := os.Open(remotePath(repoPath, remote, stream))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := []types.Commit{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		c := types.Commit{ID: fields[0]}
+		if len(fields) > 2 {
+			c.Squashed = fields[2:]
+		}
+		if fields[1] != "-" {
+			c.PickedFrom = fields[1]
+		}
+		out = append(out, c)
+	}
+	return out, sc.Err()
+}
+
+// Record stores cs as the commits of a remote's stream
+func Record(repoPath, remote, stream string, cs []types.Commit) error {
+	if !repo.ValidStreamName(remote) || !repo.ValidStreamName(stream) {
+		return fmt.Errorf("invalid remote stream %s/%s", remote, stream)
+	}
+	var b strings.Builder
+	for _, c := range cs {
+		picked := c.PickedFrom
+		if picked == "" {
+			picked = "-"
+		}
+		fmt.Fprintf(&b, "%s %s", c.ID, picked)
+		for _, id := range c.Squashed {
+			fmt.Fprintf(&b, " %s", id)
+		}
+		b.WriteString("\n")
+	}
+	path := remotePath(repoPath, remote, stream)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to record %s/%s: %w", remote, stream, err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to record %s/%s: %w", remote, stream, err)
+	}
+	return nil
+}
+
+// RecordPushed adds the commits pushed to a remote's stream to those it was
+// last seen with
+func RecordPushed(repoPath, remote, stream string, pushed []types.Commit) error {
+	prev, err := Recorded(repoPath, remote, stream)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, c := range prev {
+		seen[c.ID] = true
+	}
+	for _, c := range pushed {
+		if !seen[c.ID] {
+			prev = append(prev, c)
+		}
+	}
+	return Record(repoPath, remote, stream, prev)
+}
+
+// RenameCommits replaces commit IDs that changed, as when history is
+// rewritten, in every recorded remote history (old => new)
+func RenameCommits(repoPath string, ids map[string]string) error {
+	paths, err := filepath.Glob(filepath.Join(repo.Dir(repoPath), "tracking", "remotes", "*", "*"))
+	if err != nil {
+		return err
+	}
+	rename := func(id string) string {
+		if n, ok := ids[id]; ok {
+			return n
+		}
+		return id
+	}
+	for _, path := range paths {
+		remote, stream := filepath.Base(filepath.Dir(path)), filepath.Base(path)
+		cs, err := Recorded(repoPath, remote, stream)
+		if err != nil {
+			return err
+		}
+		for i := range cs {
+			cs[i].ID = rename(cs[i].ID)
+			if cs[i].PickedFrom != "" {
+				cs[i].PickedFrom = rename(cs[i].PickedFrom)
+			}
+			for j, id := range cs[i].Squashed {
+				cs[i].Squashed[j] = rename(id)
+			}
+		}
+		if err := Record(repoPath, remote, stream, cs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
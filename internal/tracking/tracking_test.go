@@ -0,0 +1,97 @@
+package tracking
+
+import (
+	"context"
+	"evo/internal/config"
+	"evo/internal/repo"
+	"evo/internal/streams"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, rp, stream string, ids ...string) []types.Commit {
+	var cs []types.Commit
+	for i, id := range ids {
+		cs = append(cs, types.Commit{ID: id, Stream: stream, Message: id, Timestamp: time.Now().Add(time.Duration(i) * time.Second)})
+	}
+	_, err := streams.Receive(rp, stream, cs)
+	require.NoError(t, err)
+	return cs
+}
+
+func TestLocalUpstream(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	receive(t, rp, "main", "c1", "c2", "c3")
+	receive(t, rp, "feature", "c1", "f1")
+
+	st, err := Get(rp, "feature")
+	require.NoError(t, err)
+	assert.Equal(t, &Status{Stream: "feature"}, st)
+	assert.Equal(t, "", st.Summary())
+
+	require.NoError(t, config.Set(rp, config.ScopeRepo, "stream.feature.upstream", "main"))
+	st, err = Get(rp, "feature")
+	require.NoError(t, err)
+	assert.Equal(t, &Status{Stream: "feature", Upstream: "main", Ahead: 1, Behind: 2}, st)
+	assert.Equal(t, "diverged from main: 1 commit(s) ahead, 2 behind", st.Summary())
+
+	// counts come from the cache until a stream moves
+	data, err := os.ReadFile(filepath.Join(rp, ".evo", "tracking", "counts"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "feature main ")
+	require.NoError(t, streams.MergeStreams(context.Background(), rp, "main", "feature"))
+	st, err = Get(rp, "feature")
+	require.NoError(t, err)
+	assert.Equal(t, "ahead of main by 1 commit(s)", st.Summary())
+
+	require.NoError(t, config.Set(rp, config.ScopeRepo, "stream.feature.upstream", "gone"))
+	st, err = Get(rp, "feature")
+	require.NoError(t, err)
+	assert.Contains(t, st.Error, "upstream gone of feature does not exist")
+
+	all, err := All(rp)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "feature", all[0].Stream)
+	assert.Equal(t, Status{Stream: "main"}, all[1])
+}
+
+func TestRemoteUpstream(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	local := receive(t, rp, "main", "c1", "c2")
+	require.NoError(t, config.Set(rp, config.ScopeRepo, "stream.main.upstream", "origin/main"))
+
+	st, err := Get(rp, "main")
+	require.NoError(t, err)
+	assert.Contains(t, st.Error, "origin/main has not been fetched")
+
+	require.NoError(t, Record(rp, "origin", "main", []types.Commit{{ID: "c1"}, {ID: "r1", PickedFrom: "x"}, {ID: "r2", Squashed: []string{"a", "b"}}}))
+	cs, err := Recorded(rp, "origin", "main")
+	require.NoError(t, err)
+	assert.Equal(t, []types.Commit{{ID: "c1"}, {ID: "r1", PickedFrom: "x"}, {ID: "r2", Squashed: []string{"a", "b"}}}, cs)
+
+	st, err = Get(rp, "main")
+	require.NoError(t, err)
+	assert.Equal(t, &Status{Stream: "main", Upstream: "origin/main", Ahead: 1, Behind: 2}, st)
+
+	// a push adds to what the remote was seen with
+	require.NoError(t, RecordPushed(rp, "origin", "main", local))
+	st, err = Get(rp, "main")
+	require.NoError(t, err)
+	assert.Equal(t, 0, st.Ahead)
+	assert.Equal(t, 2, st.Behind)
+
+	assert.Error(t, Record(rp, "..", "main", nil))
+}
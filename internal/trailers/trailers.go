@@ -0,0 +1,155 @@
+package trailers
+
+import (
+	"bufio"
+	"bytes"
+	"evo/internal/identity"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Well-known trailer keys
+const (
+	CoAuthoredBy = "Co-authored-by"
+	ReviewedBy   = "Reviewed-by"
+	Refs         = "Refs"
+	Closes       = "Closes"
+	Renamed      = "Renamed"  // "<from> -> <to>", from evo mv
+	Encoding     = "Encoding" // "<path> <from> -> <to>", when ingest finds a file's encoding changed
+)
+
+// NormalizeKey capitalizes the first letter of a key and lowercases the rest,
+// so "CO-AUTHORED-BY" and "co-authored-by" are the same trailer
+func NormalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ""
+	}
+	return strings.ToUpper(key[:1]) + strings.ToLower(key[1:])
+}
+
+// New builds a trailer, checking that the value suits the key: people for
+// Co-authored-by and Reviewed-by must be "Name <email>"
+func New(key, value string) (types.Trailer, error) {
+	key, value = NormalizeKey(key), strings.TrimSpace(value)
+	if key == "" || strings.ContainsAny(key, " :\t") {
+		return types.Trailer{}, fmt.Errorf("invalid trailer key %q", key)
+	}
+	if value == "" || strings.Contains(value, "\n") {
+		return types.Trailer{}, fmt.Errorf("invalid value for trailer %s", key)
+	}
+	if key == CoAuthoredBy || key == ReviewedBy {
+		id, err := identity.Parse(value)
+		if err != nil {
+			return types.Trailer{}, fmt.Errorf("%s: %w", key, err)
+		}
+		value = id.String()
+	}
+	return types.Trailer{Key: key, Value: value}, nil
+}
+
+// Parse reads "Key: value"
+func Parse(s string) (types.Trailer, error) {
+	key, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return types.Trailer{}, fmt.Errorf("invalid trailer %q: expected \"Key: value\"", s)
+	}
+	return New(key, value)
+}
+
+// Split separates trailers written at the end of a commit message: if the
+// last paragraph consists only of "Key: value" lines, it is removed from the
+// message and returned as trailers
+func Split(message string) (string, []types.Trailer) {
+	msg := strings.TrimRight(message, "\n ")
+	i := strings.LastIndex(msg, "\n\n")
+	if i < 0 {
+		return message, nil
+	}
+	var ts []types.Trailer
+	for _, line := range strings.Split(msg[i+2:], "\n") {
+		t, err := Parse(line)
+		if err != nil {
+			return message, nil
+		}
+		ts = append(ts, t)
+	}
+	return strings.TrimRight(msg[:i], "\n "), ts
+}
+
+// Merge appends add to ts, skipping exact duplicates
+func Merge(ts []types.Trailer, add ...types.Trailer) []types.Trailer {
+	for _, t := range add {
+		dup := false
+		for _, have := range ts {
+			if have == t {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// Values returns the values of every trailer with key
+func Values(ts []types.Trailer, key string) []string {
+	key = NormalizeKey(key)
+	var out []string
+	for _, t := range ts {
+		if t.Key == key {
+			out = append(out, t.Value)
+		}
+	}
+	return out
+}
+
+// HookPath is the executable run by RunHook
+func HookPath(repoPath string) string {
+	return filepath.Join(repo.Dir(repoPath), "hooks", "commit-trailers")
+}
+
+// RunHook runs .evo/hooks/commit-trailers, if present, with the commit message
+// on stdin and EVO_STREAM, EVO_AUTHOR_NAME and EVO_AUTHOR_EMAIL set. Each
+// non-empty line it prints is a "Key: value" trailer to add. A failing hook
+// aborts the commit.
+func RunHook(repoPath, stream, message string, author identity.Identity) ([]types.Trailer, error) {
+	hook := HookPath(repoPath)
+	if _, err := os.Stat(hook); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cmd := exec.Command(hook)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(message)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"EVO_STREAM="+stream,
+		"EVO_AUTHOR_NAME="+author.Name,
+		"EVO_AUTHOR_EMAIL="+author.Email,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("commit-trailers hook failed: %w", err)
+	}
+	var ts []types.Trailer
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		t, err := Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("commit-trailers hook: %w", err)
+		}
+		ts = append(ts, t)
+	}
+	return ts, sc.Err()
+}
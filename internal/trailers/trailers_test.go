@@ -0,0 +1,62 @@
+package trailers
+
+import (
+	"evo/internal/identity"
+	"evo/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tr, err := Parse("co-authored-by: Ann <ann@example.com>")
+	assert.NoError(t, err)
+	assert.Equal(t, types.Trailer{Key: CoAuthoredBy, Value: "Ann <ann@example.com>"}, tr)
+
+	_, err = Parse("Reviewed-by: somebody")
+	assert.Error(t, err)
+	_, err = Parse("no colon here")
+	assert.Error(t, err)
+	tr, err = New("refs", "#12")
+	assert.NoError(t, err)
+	assert.Equal(t, Refs, tr.Key)
+}
+
+func TestSplit(t *testing.T) {
+	msg, ts := Split("Fix parser\n\nLonger text: with a colon\nand more\n\nRefs: #4\nCo-authored-by: Bo <bo@example.com>\n")
+	assert.Equal(t, "Fix parser\n\nLonger text: with a colon\nand more", msg)
+	assert.Equal(t, []string{"#4"}, Values(ts, "refs"))
+	assert.Len(t, ts, 2)
+
+	msg, ts = Split("Subject only")
+	assert.Equal(t, "Subject only", msg)
+	assert.Nil(t, ts)
+
+	msg, ts = Split("Subject\n\nnot: a trailer\njust text")
+	assert.Equal(t, "Subject\n\nnot: a trailer\njust text", msg)
+	assert.Nil(t, ts)
+
+	merged := Merge(ts, types.Trailer{Key: Refs, Value: "#1"}, types.Trailer{Key: Refs, Value: "#1"})
+	assert.Len(t, merged, 1)
+}
+
+func TestHook(t *testing.T) {
+	repo := t.TempDir()
+	ts, err := RunHook(repo, "main", "msg", identity.Identity{})
+	assert.NoError(t, err)
+	assert.Nil(t, ts)
+
+	hook := HookPath(repo)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(hook), 0755))
+	script := "#!/bin/sh\necho \"Refs: $EVO_STREAM\"\necho\necho \"Reviewed-by: $EVO_AUTHOR_NAME <$EVO_AUTHOR_EMAIL>\"\n"
+	assert.NoError(t, os.WriteFile(hook, []byte(script), 0755))
+	ts, err = RunHook(repo, "main", "msg", identity.Identity{Name: "Rae", Email: "rae@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Trailer{{Key: Refs, Value: "main"}, {Key: ReviewedBy, Value: "Rae <rae@example.com>"}}, ts)
+
+	assert.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0755))
+	_, err = RunHook(repo, "main", "msg", identity.Identity{})
+	assert.Error(t, err)
+}
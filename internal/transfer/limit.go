@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"evo/internal/config"
+	"evo/internal/remotes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options control how a transfer uses the network
+type Options struct {
+	Jobs    int      // chunks, streams or files moved at once, at least 1
+	Limiter *Limiter // shared by every transfer using the options, nil for no limit
+}
+
+// LoadOptions returns the options configured as transfer.jobs and
+// transfer.limitRate
+func LoadOptions(repoPath string) (*Options, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := cfg.Int64("transfer.jobs")
+	if err != nil {
+		return nil, err
+	}
+	rate, err := cfg.Size("transfer.limitRate")
+	if err != nil {
+		return nil, err
+	}
+	return &Options{Jobs: int(jobs), Limiter: NewLimiter(rate)}, nil
+}
+
+func (o *Options) jobs() int {
+	if o == nil || o.Jobs < 1 {
+		return 1
+	}
+	return o.Jobs
+}
+
+// Reader returns r read no faster than the options' rate
+func (o *Options) Reader(r io.Reader) io.Reader {
+	if o == nil {
+		return r
+	}
+	return o.Limiter.Reader(r)
+}
+
+// Throttle holds what r sends and receives to the options' rate, for
+// exchanges with the remote other than chunk transfers
+func (o *Options) Throttle(r *remotes.Remote) {
+	if o != nil && o.Limiter != nil {
+		r.Limit = o.Limiter.Reader
+	}
+}
+
+// Limiter is a token bucket holding up to a quarter second of bytes at its
+// rate, which readers draw from as they read
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of rate bytes per second, or nil, which
+// limits nothing, for a rate of 0
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(float64(rate)/4, 512)
+	return &Limiter{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens, sleeping as long as the bucket is in debt
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate) - float64(n)
+	l.last = now
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+}
+
+// Reader returns r read no faster than the limiter allows
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r, l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > int(lr.l.burst) {
+		p = p[:int(lr.l.burst)]
+	}
+	n, err := lr.r.Read(p)
+	lr.l.wait(n)
+	return n, err
+}
+
+// Each calls fn for every item, as many at once as the options' jobs, and
+// returns the first error, after which no more calls start
+func Each[T any](o *Options, items []T, fn func(T) error) error {
+	return each(o.jobs(), items, fn)
+}
+
+// each calls fn for every item from at most jobs goroutines at once, and
+// returns the first error, after which no more calls start
+func each[T any](jobs int, items []T, fn func(T) error) error {
+	work := make(chan T)
+	errs := make(chan error, jobs)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for range min(jobs, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range work {
+				if failed.Load() {
+					continue
+				}
+				if err := fn(it); err != nil {
+					failed.Store(true)
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, it := range items {
+		if failed.Load() {
+			break
+		}
+		work <- it
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,387 @@
+// Package transfer moves large files between the LFS store of a repository
+// and a remote, chunk by chunk. A transfer in progress is recorded until it
+// completes, so one cut off by a dropped connection or a crash resumes with
+// the chunks the other side still lacks, and a chunk cut off halfway while
+// pulling continues where it stopped:
+//
+//	.evo/transfers/<push|pull>/<id>.json   State of the transfer of a file
+//	.evo/transfers/pull/<hash>.part        chunk being downloaded
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"evo/internal/lfs"
+	"evo/internal/log"
+	"evo/internal/platform"
+	"evo/internal/remotes"
+	"evo/internal/repo"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = log.For("transfer")
+
+// Direction is which way a transfer goes
+type Direction string
+
+const (
+	Push Direction = "push"
+	Pull Direction = "pull"
+)
+
+// State is the progress of the transfer of one file
+type State struct {
+	ID        string    `json:"id"`
+	Direction Direction `json:"direction"`
+	Remote    string    `json:"remote"`
+	Size      int64     `json:"size"`
+	Chunks    int       `json:"chunks"`
+	Done      int       `json:"done"` // chunks the receiving side has
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+// chunkList is the body of the missing-chunks request of the server API
+type chunkList struct {
+	Chunks []string `json:"chunks"`
+}
+
+func stateDir(repoPath string, d Direction) string {
+	return filepath.Join(repo.Dir(repoPath), "transfers", string(d))
+}
+
+func checkID(id string) error {
+	if !filepath.IsLocal(id) || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid file ID %q", id)
+	}
+	return nil
+}
+
+// begin returns the recorded state of a transfer, or a new one
+func begin(repoPath string, d Direction, remote string, info *lfs.FileInfo) *State {
+	st := &State{ID: info.ID, Direction: d, Started: time.Now().UTC()}
+	if data, err := os.ReadFile(filepath.Join(stateDir(repoPath, d), info.ID+".json")); err == nil {
+		json.Unmarshal(data, st)
+	}
+	st.Remote, st.Size, st.Chunks, st.Done = remote, info.Size, len(info.Chunks), 0
+	return st
+}
+
+func (st *State) save(repoPath string) error {
+	st.Updated = time.Now().UTC()
+	dir := stateDir(repoPath, st.Direction)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, st.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to record transfer of %s: %w", st.ID, err)
+	}
+	return platform.Rename(path+".tmp", path)
+}
+
+// progress counts the chunks of info with hash as done and records it. The
+// chunks moved in parallel report under mu.
+func (st *State) progress(repoPath string, info *lfs.FileInfo, hash string, mu *sync.Mutex) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, chunk := range info.Chunks {
+		if chunk.Hash == hash {
+			st.Done++
+		}
+	}
+	return st.save(repoPath)
+}
+
+func (st *State) finish(repoPath string) error {
+	err := os.Remove(filepath.Join(stateDir(repoPath, st.Direction), st.ID+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the transfers that haven't completed, oldest first
+func List(repoPath string) ([]State, error) {
+	var out []State
+	for _, d := range []Direction{Push, Pull} {
+		entries, err := os.ReadDir(stateDir(repoPath, d))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transfers: %w", err)
+		}
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(stateDir(repoPath, d), e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read transfers: %w", err)
+			}
+			var st State
+			if err := json.Unmarshal(data, &st); err != nil {
+				return nil, fmt.Errorf("failed to parse transfer %s: %w", e.Name(), err)
+			}
+			out = append(out, st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out, nil
+}
+
+// Resume continues every transfer that hasn't completed, against the remote
+// it was started with as looked up by remote (remotes.Get if nil), and
+// returns those it completed
+func Resume(repoPath string, opts *Options, remote func(name string) (*remotes.Remote, error)) ([]State, error) {
+	if remote == nil {
+		remote = func(name string) (*remotes.Remote, error) { return remotes.Get(repoPath, name) }
+	}
+	pending, err := List(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var done []State
+	for _, st := range pending {
+		r, err := remote(st.Remote)
+		if err != nil {
+			return done, err
+		}
+		var res *State
+		if st.Direction == Push {
+			res, err = PushFile(repoPath, r, st.ID, opts)
+		} else {
+			res, err = PullFile(repoPath, r, st.ID, opts)
+		}
+		if err != nil {
+			return done, err
+		}
+		done = append(done, *res)
+	}
+	return done, nil
+}
+
+// PushFile sends a large file to a remote, putting only the chunks it lacks
+func PushFile(repoPath string, r *remotes.Remote, id string, opts *Options) (*State, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	store := lfs.NewStore(repoPath)
+	info, err := store.Info(id)
+	if os.IsNotExist(err) {
+		// deleted since the push started
+		(&State{ID: id, Direction: Push}).finish(repoPath)
+		return nil, fmt.Errorf("no large file %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := begin(repoPath, Push, r.Name, info)
+	if err := st.save(repoPath); err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, chunk := range info.Chunks {
+		if !seen[chunk.Hash] {
+			seen[chunk.Hash] = true
+			hashes = append(hashes, chunk.Hash)
+		}
+	}
+	var missing chunkList
+	if err := r.Do(http.MethodPost, "/api/v1/lfs/chunks/missing", chunkList{hashes}, &missing); err != nil {
+		return nil, fmt.Errorf("failed to push %s: %w", id, err)
+	}
+	lacking := make(map[string]bool)
+	for _, h := range missing.Chunks {
+		lacking[h] = true
+	}
+	for _, chunk := range info.Chunks {
+		if !lacking[chunk.Hash] {
+			st.Done++
+		}
+	}
+	var mu sync.Mutex
+	err = each(opts.jobs(), missing.Chunks, func(h string) error {
+		if err := putChunk(store, r, h, opts); err != nil {
+			return err
+		}
+		return st.progress(repoPath, info, h, &mu)
+	})
+	if err != nil {
+		st.save(repoPath)
+		return nil, fmt.Errorf("failed to push %s: %w", id, err)
+	}
+	if err := r.Do(http.MethodPut, "/api/v1/lfs/files/"+url.PathEscape(id), info, nil); err != nil {
+		return nil, fmt.Errorf("failed to push %s: %w", id, err)
+	}
+	logger.Info("pushed large file", "id", id, "remote", r.Name, "chunks", len(missing.Chunks))
+	return st, st.finish(repoPath)
+}
+
+func putChunk(store *lfs.Store, r *remotes.Remote, hash string, opts *Options) error {
+	f, err := store.OpenChunk(hash)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := r.Request(http.MethodPut, "/api/v1/lfs/chunks/"+hash, opts.Reader(f), http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PullFile fetches a large file from a remote into the store, getting only
+// the chunks the store lacks
+func PullFile(repoPath string, r *remotes.Remote, id string, opts *Options) (*State, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	store := lfs.NewStore(repoPath)
+	var info lfs.FileInfo
+	if err := r.Do(http.MethodGet, "/api/v1/lfs/files/"+url.PathEscape(id), nil, &info); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %w", id, err)
+	}
+	info.ID = id
+	st := begin(repoPath, Pull, r.Name, &info)
+	var lacking []string
+	seen := make(map[string]bool)
+	for _, chunk := range info.Chunks {
+		switch {
+		case store.HasChunk(chunk.Hash):
+			st.Done++
+		case !seen[chunk.Hash]:
+			seen[chunk.Hash] = true
+			lacking = append(lacking, chunk.Hash)
+		}
+	}
+	if err := st.save(repoPath); err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	err := each(opts.jobs(), lacking, func(h string) error {
+		if err := fetchChunk(repoPath, store, r, chunkInfo(&info, h), opts); err != nil {
+			return err
+		}
+		return st.progress(repoPath, &info, h, &mu)
+	})
+	if err != nil {
+		st.save(repoPath)
+		return nil, fmt.Errorf("failed to pull %s: %w", id, err)
+	}
+	if _, err := store.Link(id, &info); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %w", id, err)
+	}
+	logger.Info("pulled large file", "id", id, "remote", r.Name, "chunks", len(lacking))
+	return st, st.finish(repoPath)
+}
+
+// FetchChunks fetches chunks from a remote into the store, as those an LFS
+// check found missing or corrupt, and returns the hashes it got. A chunk the
+// remote doesn't have doesn't stop the others; the error names each that
+// failed. A corrupt chunk must be removed from the store first.
+func FetchChunks(repoPath string, r *remotes.Remote, chunks []lfs.ChunkInfo, opts *Options) ([]string, error) {
+	store := lfs.NewStore(repoPath)
+	if err := os.MkdirAll(stateDir(repoPath, Pull), 0755); err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(chunks))
+	hashes := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		if _, ok := sizes[c.Hash]; !ok {
+			hashes = append(hashes, c.Hash)
+		}
+		sizes[c.Hash] = c.Size
+	}
+	var mu sync.Mutex
+	var got []string
+	var errs []error
+	each(opts.jobs(), hashes, func(h string) error {
+		err := fetchChunk(repoPath, store, r, lfs.ChunkInfo{Hash: h, Size: sizes[h]}, opts)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chunk %s: %w", h, err))
+		} else {
+			got = append(got, h)
+		}
+		return nil
+	})
+	sort.Strings(got)
+	logger.Info("fetched chunks", "remote", r.Name, "chunks", len(got), "failed", len(errs))
+	return got, errors.Join(errs...)
+}
+
+func chunkInfo(info *lfs.FileInfo, hash string) lfs.ChunkInfo {
+	for _, chunk := range info.Chunks {
+		if chunk.Hash == hash {
+			return chunk
+		}
+	}
+	return lfs.ChunkInfo{Hash: hash}
+}
+
+// fetchChunk downloads a chunk into a part file, continuing one an earlier
+// pull left, and stores it once complete
+func fetchChunk(repoPath string, store *lfs.Store, r *remotes.Remote, chunk lfs.ChunkInfo, opts *Options) error {
+	part := filepath.Join(stateDir(repoPath, Pull), chunk.Hash+".part")
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	have := fi.Size()
+	if have < chunk.Size {
+		header := http.Header{}
+		if have > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+		}
+		resp, err := r.Request(http.MethodGet, "/api/v1/lfs/chunks/"+chunk.Hash, nil, header)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent {
+			// the whole chunk came back
+			have = 0
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+		}
+		if _, err := f.Seek(have, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, opts.Reader(resp.Body)); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = store.PutChunk(chunk.Hash, f)
+	if err == nil || errors.Is(err, lfs.ErrCorrupt) {
+		// a corrupt part is downloaded again from the start
+		os.Remove(part)
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"evo/internal/config"
+	"evo/internal/lfs"
+	"evo/internal/remotes"
+	"evo/internal/repo"
+	"evo/internal/server"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPullResume(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(local))
+	require.NoError(t, repo.InitRepo(remote))
+
+	// three chunks; the connection fails after the first chunk is fetched
+	var failing atomic.Bool
+	var ranges []string
+	srv := server.New(remote)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/lfs/chunks/") {
+			if failing.Load() {
+				http.Error(w, "connection lost", http.StatusBadGateway)
+				return
+			}
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	content := make([]byte, 2*lfs.ChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(content)
+	_, err := lfs.NewStore(local).StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	st, err := PushFile(local, r, "big", &Options{Jobs: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3, st.Done)
+	var buf bytes.Buffer
+	require.NoError(t, lfs.NewStore(remote).ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+	pending, err := List(local)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// pushing again sends no chunks
+	st, err = PushFile(local, r, "big", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, st.Done)
+
+	// a pull cut off after the first chunk, with half the second downloaded
+	clone := t.TempDir()
+	require.NoError(t, repo.InitRepo(clone))
+	info, err := lfs.NewStore(remote).Info("big")
+	require.NoError(t, err)
+	first := info.Chunks[0]
+	require.NoError(t, lfs.NewStore(clone).PutChunk(first.Hash, bytes.NewReader(content[:lfs.ChunkSize])))
+	part := filepath.Join(clone, ".evo", "transfers", "pull", info.Chunks[1].Hash+".part")
+	require.NoError(t, os.MkdirAll(filepath.Dir(part), 0755))
+	require.NoError(t, os.WriteFile(part, content[lfs.ChunkSize:lfs.ChunkSize+1000], 0644))
+	failing.Store(true)
+	_, err = PullFile(clone, r, "big", nil)
+	require.Error(t, err)
+	pending, err = List(clone)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, Pull, pending[0].Direction)
+	assert.Equal(t, 1, pending[0].Done)
+
+	// resuming remembers the remote, and continues the cut off chunk
+	failing.Store(false)
+	done, err := Resume(clone, nil, nil)
+	require.Error(t, err, "origin isn't configured in the clone")
+	assert.Empty(t, done)
+	require.NoError(t, config.SetRepoConfigValue(clone, "remote.origin.url", ts.URL))
+	done, err = Resume(clone, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, []string{"bytes=1000-", ""}, ranges)
+	buf.Reset()
+	require.NoError(t, lfs.NewStore(clone).ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+	pending, err = List(clone)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	_, err = os.Stat(part)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLimiter(t *testing.T) {
+	assert.Nil(t, NewLimiter(0))
+	data := make([]byte, 512<<10)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, NewLimiter(1<<20).Reader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	// a quarter second of burst, then a quarter second at the rate
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestEach(t *testing.T) {
+	var running, most atomic.Int32
+	hashes := []string{"a", "b", "c", "d", "e", "f"}
+	err := each(2, hashes, func(h string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > most.Load() {
+			most.Store(n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, most.Load(), int32(2))
+
+	var calls atomic.Int32
+	err = each(3, hashes, func(h string) error {
+		calls.Add(1)
+		return errors.New("boom " + h)
+	})
+	assert.ErrorContains(t, err, "boom")
+	assert.Less(t, calls.Load(), int32(len(hashes)))
+}
+
+func TestFetchChunks(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(local))
+	require.NoError(t, repo.InitRepo(remote))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	content := make([]byte, 2*lfs.ChunkSize+100)
+	rand.New(rand.NewSource(2)).Read(content)
+	store := lfs.NewStore(local)
+	info, err := store.StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	_, err = PushFile(local, r, "big", nil)
+	require.NoError(t, err)
+
+	// one chunk lost, one damaged
+	lost, damaged := info.Chunks[0], info.Chunks[2]
+	require.NoError(t, store.RemoveChunk(lost.Hash))
+	path := filepath.Join(local, ".evo", "chunks", damaged.Hash[:2], damaged.Hash[2:4], damaged.Hash)
+	require.NoError(t, os.WriteFile(path, []byte("junk"), 0644))
+	report, err := store.Check()
+	require.NoError(t, err)
+	require.Len(t, report.Lost(), 2)
+
+	require.NoError(t, store.RemoveChunk(damaged.Hash))
+	unknown := lfs.ChunkInfo{Hash: lfs.HashBytes([]byte("on no remote")), Size: 12}
+	got, err := FetchChunks(local, r, append(report.Lost(), unknown), nil)
+	assert.ErrorContains(t, err, unknown.Hash)
+	assert.ElementsMatch(t, []string{lost.Hash, damaged.Hash}, got)
+
+	report, err = store.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Damage)
+	var buf bytes.Buffer
+	require.NoError(t, store.ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+}
+
+func TestPushFiltered(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	require.NoError(t, repo.InitRepo(local))
+	require.NoError(t, repo.InitRepo(remote))
+	require.NoError(t, config.SetRepoConfigValue(local, "lfs.filters", "zstd"))
+	ts := httptest.NewServer(server.New(remote))
+	defer ts.Close()
+	r := &remotes.Remote{Name: "origin", URL: ts.URL}
+
+	// chunks travel as content: the remote stores them unfiltered
+	content := bytes.Repeat([]byte("filtered "), lfs.ChunkSize/4)
+	info, err := lfs.NewStore(local).StoreFile("big", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zstd"}, info.Chunks[0].Filters)
+	_, err = PushFile(local, r, "big", nil)
+	require.NoError(t, err)
+	pushed, err := lfs.NewStore(remote).Info("big")
+	require.NoError(t, err)
+	assert.Equal(t, info.ContentHash, pushed.ContentHash)
+	assert.Empty(t, pushed.Chunks[0].Filters)
+	var buf bytes.Buffer
+	require.NoError(t, lfs.NewStore(remote).ReadFile("big", &buf))
+	assert.Equal(t, content, buf.Bytes())
+}
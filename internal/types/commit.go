@@ -22,6 +22,15 @@ type Commit struct {
 	Timestamp   time.Time    // When the commit was created
 	Operations  []ExtendedOp // Operations included in this commit
 	Signature   string       // Optional Ed25519 signature
+	PickedFrom  string       // Source commit ID when created by cherry-pick
+	Squashed    []string     `json:",omitempty"` // Commits folded into this baseline by compaction
+	Trailers    []Trailer    `json:",omitempty"` // Structured "Key: value" lines such as Co-authored-by
+}
+
+// Trailer is one "Key: value" line attached to a commit
+type Trailer struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
 
 // CommitHashString generates a stable string representation of a commit for signing
@@ -34,5 +43,9 @@ func CommitHashString(c *Commit) string {
 	h.Write([]byte(c.AuthorName))
 	h.Write([]byte(c.AuthorEmail))
 	h.Write([]byte(c.Timestamp.UTC().Format(time.RFC3339)))
+	// trailers are only hashed when present so older signatures stay valid
+	for _, t := range c.Trailers {
+		h.Write([]byte(t.Key + ": " + t.Value + "\n"))
+	}
 	return string(h.Sum(nil))
 }
@@ -1,11 +1,15 @@
 package util
 
 import (
+	"evo/internal/profile"
+	"fmt"
 	"os"
 	"path/filepath"
 )
 
+// ListAllFiles returns the slash-separated paths of the files under repoPath
 func ListAllFiles(repoPath string) ([]string, error) {
+	defer profile.Track(profile.Walk)()
 	var out []string
 	filepath.Walk(repoPath, func(path string, info os.FileInfo, e error) error {
 		if e != nil {
@@ -13,9 +17,23 @@ func ListAllFiles(repoPath string) ([]string, error) {
 		}
 		if !info.IsDir() {
 			rel, _ := filepath.Rel(repoPath, path)
-			out = append(out, rel)
+			out = append(out, filepath.ToSlash(rel))
 		}
 		return nil
 	})
 	return out, nil
 }
+
+// HumanBytes formats a byte count with binary units, e.g. "1.5 MiB"
+func HumanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
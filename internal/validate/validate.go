@@ -0,0 +1,212 @@
+// Package validate checks ops before they are written to a stream's op
+// logs, so a log never holds an op that can't mean anything: one without a
+// file or line, of an unknown type, or made for another stream. Ops made
+// here (ingest, commits, cherry-picks) are also checked against the file
+// they change: it must be tracked, and have had the line an update or
+// delete changes or an insert goes after. Ops merged or received from elsewhere are only checked on their
+// own, as their lines may come with ops still to arrive.
+package validate
+
+import (
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/types"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("validate")
+
+// Error is an op that failed a check
+type Error struct {
+	Op     crdt.Operation
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid op on line %s of file %s: %s", e.Op.LineID, e.Op.FileID, e.Reason)
+}
+
+// Options say what ops are checked against besides themselves
+type Options struct {
+	Stream string // the stream ops must belong to, if set
+	Index  bool   // files must be tracked in the index
+	Lines  bool   // the lines ops change, and inserts go after, must be the file's
+}
+
+// Local are the checks for ops made in stream
+func Local(stream string) Options {
+	return Options{Stream: stream, Index: true, Lines: true}
+}
+
+// Op checks an op on its own
+func Op(op crdt.Operation) error {
+	fail := func(format string, args ...any) error {
+		return &Error{Op: op, Reason: fmt.Sprintf(format, args...)}
+	}
+	switch op.Type {
+	case crdt.OpInsert, crdt.OpUpdate, crdt.OpDelete:
+	default:
+		return fail("unknown type %d", op.Type)
+	}
+	switch {
+	case op.FileID == uuid.Nil:
+		return fail("no file ID")
+	case op.LineID == uuid.Nil || op.LineID == crdt.DocumentStart:
+		return fail("no line ID")
+	case op.NodeID == uuid.Nil:
+		return fail("no node ID")
+	case op.Type == crdt.OpInsert && op.OriginLineID == op.LineID:
+		return fail("inserted after itself")
+	case op.Type != crdt.OpDelete && !op.Fragment && strings.Contains(op.Content, "\n"):
+		return fail("content holds a line break")
+	}
+	return nil
+}
+
+// Validator checks ops to be written to one stream's logs, in order,
+// remembering what the ones it passed do to each file
+type Validator struct {
+	repoPath string
+	stream   string
+	opts     Options
+	id2path  map[string]string
+	files    map[uuid.UUID]*file
+}
+
+// file is what the validator knows of a file's lines
+type file struct {
+	live  map[uuid.UUID]bool // lines in the file
+	added map[uuid.UUID]bool // lines the ops passed insert
+	known map[uuid.UUID]bool // lines the log ever inserted; nil until needed
+	doc   *materialize.Document
+}
+
+// has reports whether the file ever had the line. Deleted lines count: a
+// revert may update or delete one again, which changes nothing.
+func (f *file) has(id uuid.UUID) (bool, error) {
+	if f.live[id] || f.added[id] {
+		return true, nil
+	}
+	known, err := f.inserted()
+	return known[id], err
+}
+
+// New returns a validator of ops to be written to stream
+func New(repoPath, stream string, opts Options) (*Validator, error) {
+	v := &Validator{repoPath: repoPath, stream: stream, opts: opts, files: make(map[uuid.UUID]*file)}
+	if opts.Index {
+		_, id2path, err := index.LoadIndex(repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the index: %w", err)
+		}
+		v.id2path = id2path
+	}
+	return v, nil
+}
+
+// Ops checks ops with the given options, stopping at the first that fails
+func Ops(repoPath, stream string, opts Options, eops []types.ExtendedOp) error {
+	v, err := New(repoPath, stream, opts)
+	if err != nil {
+		return err
+	}
+	for _, eop := range eops {
+		if err := v.Check(eop.Op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks the next op
+func (v *Validator) Check(op crdt.Operation) error {
+	if err := Op(op); err != nil {
+		logger.Debug("rejected op", "stream", v.stream, "err", err)
+		return err
+	}
+	fail := func(format string, args ...any) error {
+		err := &Error{Op: op, Reason: fmt.Sprintf(format, args...)}
+		logger.Debug("rejected op", "stream", v.stream, "err", err)
+		return err
+	}
+	if v.opts.Stream != "" && op.Stream != v.opts.Stream {
+		return fail("made for stream %q, not %q", op.Stream, v.opts.Stream)
+	}
+	if v.opts.Index {
+		if _, ok := v.id2path[op.FileID.String()]; !ok {
+			return fail("file isn't tracked")
+		}
+	}
+	if !v.opts.Lines {
+		return nil
+	}
+	f, err := v.file(op.FileID)
+	if err != nil {
+		return err
+	}
+	switch op.Type {
+	case crdt.OpInsert:
+		if origin := op.OriginLineID; origin != crdt.DocumentStart && origin != uuid.Nil {
+			if ok, err := f.has(origin); err != nil {
+				return err
+			} else if !ok {
+				return fail("inserted after unknown line %s", origin)
+			}
+		}
+		f.added[op.LineID] = true
+	case crdt.OpUpdate, crdt.OpDelete:
+		if ok, err := f.has(op.LineID); err != nil {
+			return err
+		} else if !ok {
+			return fail("the file never had the line")
+		}
+	}
+	return nil
+}
+
+// file returns what is known of a file, from its log the first time
+func (v *Validator) file(id uuid.UUID) (*file, error) {
+	if f, ok := v.files[id]; ok {
+		return f, nil
+	}
+	doc, err := materialize.Load(v.repoPath, v.stream, id.String())
+	if err != nil {
+		return nil, err
+	}
+	f := &file{live: make(map[uuid.UUID]bool), added: make(map[uuid.UUID]bool), doc: doc}
+	if doc.Elements != nil {
+		for _, e := range doc.Elements {
+			f.live[e.ID] = true
+		}
+	} else {
+		for _, lid := range doc.LineIDs {
+			f.live[lid] = true
+		}
+	}
+	v.files[id] = f
+	return f, nil
+}
+
+// inserted returns the lines the log ever inserted, deleted ones too,
+// reading it the first time; ops mostly refer to lines still there
+func (f *file) inserted() (map[uuid.UUID]bool, error) {
+	if f.known != nil {
+		return f.known, nil
+	}
+	logged, err := f.doc.Ops()
+	if err != nil {
+		return nil, err
+	}
+	f.known = make(map[uuid.UUID]bool, len(logged))
+	for _, op := range logged {
+		if op.Type == crdt.OpInsert {
+			f.known[op.LineID] = true
+		}
+	}
+	return f.known, nil
+}
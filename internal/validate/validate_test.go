@@ -0,0 +1,80 @@
+package validate
+
+import (
+	"errors"
+	"evo/internal/crdt"
+	"evo/internal/index"
+	"evo/internal/ops"
+	"evo/internal/repo"
+	"evo/internal/types"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOp(t *testing.T) {
+	good := crdt.Operation{Type: crdt.OpInsert, NodeID: uuid.New(), FileID: uuid.New(), LineID: uuid.New(), OriginLineID: crdt.DocumentStart, Content: "x"}
+	require.NoError(t, Op(good))
+
+	for name, change := range map[string]func(*crdt.Operation){
+		"type":       func(op *crdt.Operation) { op.Type = 7 },
+		"file":       func(op *crdt.Operation) { op.FileID = uuid.Nil },
+		"line":       func(op *crdt.Operation) { op.LineID = uuid.Nil },
+		"start":      func(op *crdt.Operation) { op.LineID = crdt.DocumentStart },
+		"node":       func(op *crdt.Operation) { op.NodeID = uuid.Nil },
+		"self":       func(op *crdt.Operation) { op.OriginLineID = op.LineID },
+		"line break": func(op *crdt.Operation) { op.Content = "a\nb" },
+	} {
+		op := good
+		change(&op)
+		var verr *Error
+		assert.True(t, errors.As(Op(op), &verr), name)
+	}
+	frag := good
+	frag.Content, frag.Fragment = "\n", true
+	assert.NoError(t, Op(frag), "a fragment may be a line break")
+}
+
+func TestLocal(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	fid, nid := uuid.New(), uuid.New()
+	require.NoError(t, index.SaveIndex(rp, map[string]string{"a.txt": fid.String()}))
+	lamport := uint64(0)
+	op := func(typ crdt.OpType, line, origin uuid.UUID) crdt.Operation {
+		lamport++
+		return crdt.Operation{Type: typ, Lamport: lamport, NodeID: nid, FileID: fid, LineID: line, OriginLineID: origin, Stream: "main"}
+	}
+	kept, gone := uuid.New(), uuid.New()
+	log := filepath.Join(repo.Dir(rp), "ops", "main", fid.String()+".bin")
+	for _, o := range []crdt.Operation{
+		op(crdt.OpInsert, kept, crdt.DocumentStart),
+		op(crdt.OpInsert, gone, kept),
+		op(crdt.OpDelete, gone, uuid.Nil),
+	} {
+		require.NoError(t, ops.AppendOp(log, o))
+	}
+
+	v, err := New(rp, "main", Local("main"))
+	require.NoError(t, err)
+	added := uuid.New()
+	assert.NoError(t, v.Check(op(crdt.OpInsert, added, kept)))
+	assert.NoError(t, v.Check(op(crdt.OpUpdate, added, uuid.Nil)), "a line inserted before")
+	assert.NoError(t, v.Check(op(crdt.OpInsert, uuid.New(), gone)), "after a deleted line")
+	assert.NoError(t, v.Check(op(crdt.OpDelete, gone, uuid.Nil)), "a deleted line again")
+	assert.Error(t, v.Check(op(crdt.OpUpdate, uuid.New(), uuid.Nil)), "a line never inserted")
+	assert.Error(t, v.Check(op(crdt.OpInsert, uuid.New(), uuid.New())), "after a line never inserted")
+
+	other := op(crdt.OpUpdate, kept, uuid.Nil)
+	other.Stream = "dev"
+	assert.ErrorContains(t, v.Check(other), `made for stream "dev"`)
+	untracked := op(crdt.OpInsert, uuid.New(), crdt.DocumentStart)
+	untracked.FileID = uuid.New()
+	assert.ErrorContains(t, v.Check(untracked), "isn't tracked")
+
+	err = Ops(rp, "main", Options{}, []types.ExtendedOp{{Op: untracked}})
+	assert.NoError(t, err, "ops from elsewhere are only checked on their own")
+}
@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"evo/internal/config"
+	"evo/internal/log"
+	"evo/internal/types"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = log.For("webhook")
+
+// Webhooks are configured per name:
+//
+//	[webhook.ci]
+//	url = "https://ci.example.com/evo"
+//	secret = "s3cret"          # signs deliveries; optional
+//	events = "push,merge"      # default: every event
+//
+// Each delivery is a POST of an Event as JSON with the headers
+//
+//	X-Evo-Event:     push or merge
+//	X-Evo-Delivery:  the event ID, the same for every attempt
+//	X-Evo-Signature: sha256=<hex HMAC-SHA256 of the body keyed by the secret>
+//
+// A delivery that fails with a network error, a 429 or a 5xx is retried with
+// exponential backoff; other responses are final.
+
+// Event types
+const (
+	EventPush  = "push"
+	EventMerge = "merge"
+)
+
+// Commit is a commit in an event payload
+type Commit struct {
+	ID          string    `json:"id"`
+	Message     string    `json:"message"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Event is the payload of a delivery
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Repo      string    `json:"repo"`
+	Stream    string    `json:"stream"`           // stream that received the commits
+	Source    string    `json:"source,omitempty"` // merged stream, for merges
+	Review    string    `json:"review,omitempty"` // review merged, if any
+	Commits   []Commit  `json:"commits"`
+}
+
+// NewEvent builds an event about commits that reached stream
+func NewEvent(repoPath, typ, stream string, cs []types.Commit) Event {
+	ev := Event{
+		ID:        uuid.New().String(),
+		Type:      typ,
+		Timestamp: time.Now().UTC(),
+		Repo:      filepath.Base(repoPath),
+		Stream:    stream,
+		Commits:   []Commit{},
+	}
+	for _, c := range cs {
+		ev.Commits = append(ev.Commits, Commit{c.ID, c.Message, c.AuthorName, c.AuthorEmail, c.Timestamp})
+	}
+	return ev
+}
+
+// Hook is a configured webhook
+type Hook struct {
+	Name   string
+	URL    string
+	Secret string
+	Events []string // empty means every event
+}
+
+// Wants reports whether the hook subscribes to an event type
+func (h Hook) Wants(typ string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, typ)
+}
+
+// Load returns the webhooks configured for a repository
+func Load(repoPath string) ([]Hook, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []Hook
+	for _, e := range cfg.List() {
+		name, ok := strings.CutPrefix(e.Key, "webhook.")
+		if !ok {
+			continue
+		}
+		if name, ok = strings.CutSuffix(name, ".url"); !ok || strings.Contains(name, ".") {
+			continue
+		}
+		h := Hook{Name: name, URL: e.Value, Secret: cfg.String("webhook." + name + ".secret")}
+		for _, ev := range strings.Split(cfg.String("webhook."+name+".events"), ",") {
+			if ev = strings.TrimSpace(ev); ev != "" {
+				h.Events = append(h.Events, ev)
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Sign returns the X-Evo-Signature header value of a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events in the background
+type Dispatcher struct {
+	repoPath string
+	Client   *http.Client
+	Attempts int           // deliveries tried per hook
+	Backoff  time.Duration // wait before the first retry, doubled after each
+	wg       sync.WaitGroup
+}
+
+// NewDispatcher returns a dispatcher for the webhooks of a repository
+func NewDispatcher(repoPath string) *Dispatcher {
+	return &Dispatcher{
+		repoPath: repoPath,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Attempts: 5,
+		Backoff:  time.Second,
+	}
+}
+
+// Emit sends an event to every hook that wants it without waiting for the
+// deliveries. Hooks are read from the config on every event.
+func (d *Dispatcher) Emit(ev Event) {
+	hooks, err := Load(d.repoPath)
+	if err != nil {
+		logger.Error("failed to load webhooks", "err", err)
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error("failed to encode event", "event", ev.ID, "err", err)
+		return
+	}
+	for _, h := range hooks {
+		if !h.Wants(ev.Type) {
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(h, ev, body)
+		}()
+	}
+}
+
+// Wait blocks until every pending delivery has finished
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(h Hook, ev Event, body []byte) {
+	wait := d.Backoff
+	for attempt := 1; attempt <= d.Attempts; attempt++ {
+		retry, err := d.post(h, ev, body)
+		if err == nil {
+			logger.Debug("delivered event", "hook", h.Name, "event", ev.ID, "type", ev.Type, "attempt", attempt)
+			return
+		}
+		if !retry || attempt == d.Attempts {
+			logger.Warn("webhook delivery failed", "hook", h.Name, "event", ev.ID, "attempts", attempt, "err", err)
+			return
+		}
+		logger.Info("retrying webhook delivery", "hook", h.Name, "event", ev.ID, "attempt", attempt, "in", wait, "err", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying
+func (d *Dispatcher) post(h Hook, ev Event, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "evo-webhook")
+	req.Header.Set("X-Evo-Event", ev.Type)
+	req.Header.Set("X-Evo-Delivery", ev.ID)
+	if h.Secret != "" {
+		req.Header.Set("X-Evo-Signature", Sign(h.Secret, body))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("%s responded %s", h.URL, resp.Status)
+	}
+	return false, fmt.Errorf("%s responded %s", h.URL, resp.Status)
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"encoding/json"
+	"evo/internal/config"
+	"evo/internal/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliver(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+
+	var mu sync.Mutex
+	var got []*http.Request
+	var bodies [][]byte
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		got, bodies = append(got, r), append(bodies, body)
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	assert.NoError(t, config.SetConfigValue(rp, "webhook.ci.url", srv.URL))
+	assert.NoError(t, config.SetConfigValue(rp, "webhook.ci.secret", "s3cret"))
+	assert.NoError(t, config.SetConfigValue(rp, "webhook.merges.url", srv.URL+"/merges"))
+	assert.NoError(t, config.SetConfigValue(rp, "webhook.merges.events", "merge"))
+	hooks, err := Load(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, []Hook{{Name: "ci", URL: srv.URL, Secret: "s3cret"}, {Name: "merges", URL: srv.URL + "/merges", Events: []string{"merge"}}}, hooks)
+
+	d := NewDispatcher(rp)
+	d.Backoff = time.Millisecond
+	ev := NewEvent(rp, EventPush, "main", []types.Commit{{ID: "c1", Message: "work"}})
+	d.Emit(ev)
+	d.Wait()
+
+	// the first attempt fails and is retried; the merge-only hook is skipped
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, got, 2) {
+		r := got[1]
+		assert.Equal(t, "/", r.URL.Path)
+		assert.Equal(t, EventPush, r.Header.Get("X-Evo-Event"))
+		assert.Equal(t, ev.ID, r.Header.Get("X-Evo-Delivery"))
+		assert.Equal(t, Sign("s3cret", bodies[1]), r.Header.Get("X-Evo-Signature"))
+		var payload Event
+		assert.NoError(t, json.Unmarshal(bodies[1], &payload))
+		assert.Equal(t, "main", payload.Stream)
+		assert.Equal(t, "c1", payload.Commits[0].ID)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	t.Setenv("EVO_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("EVO_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	rp := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(rp, ".evo", "config"), 0755))
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	assert.NoError(t, config.SetConfigValue(rp, "webhook.ci.url", srv.URL))
+
+	d := NewDispatcher(rp)
+	d.Backoff = time.Millisecond
+	d.Emit(NewEvent(rp, EventMerge, "main", nil))
+	d.Wait()
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,140 @@
+package worktree
+
+import (
+	"evo/internal/ignore"
+	"evo/internal/index"
+	"evo/internal/repo"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CleanOptions control Clean
+type CleanOptions struct {
+	DryRun  bool // only list what would be removed
+	Dirs    bool // also remove untracked directories
+	Ignored bool // also remove files matched by .evo-ignore
+}
+
+// Clean removes the files of the working tree that aren't in the index,
+// leaving those matched by .evo-ignore unless opts.Ignored is set. Without
+// opts.Dirs, directories holding no tracked file are left alone; with it,
+// those whose every file may go are removed whole, listed with a trailing
+// slash. Directories holding another repository are never removed. It
+// returns the removed paths, relative to the repository root.
+func Clean(repoPath string, opts CleanOptions) ([]string, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := ignore.LoadIgnoreFile(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore file: %w", err)
+	}
+	// directories holding tracked files are walked into, never removed
+	trackedDirs := make(map[string]bool)
+	for p := range p2id {
+		for d := path.Dir(p); d != "." && !trackedDirs[d]; d = path.Dir(d) {
+			trackedDirs[d] = true
+		}
+	}
+	removable := func(rel string) bool {
+		_, tracked := p2id[rel]
+		return !tracked && (opts.Ignored || !ignored.IsIgnored(rel))
+	}
+
+	var removed []string
+	err = filepath.WalkDir(repoPath, func(abs string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(repoPath, abs)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, ".evo") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if removable(rel) {
+				removed = append(removed, rel)
+			}
+			return nil
+		}
+		if trackedDirs[rel] {
+			return nil
+		}
+		if !opts.Dirs || isRepo(abs) {
+			return filepath.SkipDir
+		}
+		whole, err := allRemovable(repoPath, abs, removable)
+		if err != nil {
+			return err
+		}
+		if whole {
+			removed = append(removed, rel+"/")
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(removed)
+	if opts.DryRun {
+		return removed, nil
+	}
+	for _, rel := range removed {
+		abs := filepath.Join(repoPath, filepath.FromSlash(strings.TrimSuffix(rel, "/")))
+		if err := os.RemoveAll(abs); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", rel, err)
+		}
+	}
+	logger.Info("cleaned", "removed", len(removed), "dirs", opts.Dirs, "ignored", opts.Ignored)
+	return removed, nil
+}
+
+// isRepo reports whether dir is the root of another evo repository
+func isRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, repo.EvoDir))
+	return err == nil
+}
+
+// allRemovable reports whether every file under an untracked directory may
+// be removed, and no repository is nested in it
+func allRemovable(repoPath, dir string, removable func(string) bool) (bool, error) {
+	all := true
+	err := filepath.WalkDir(dir, func(abs string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if abs != dir && isRepo(abs) {
+				all = false
+				return filepath.SkipAll
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(repoPath, abs)
+		if err != nil {
+			return err
+		}
+		if !removable(filepath.ToSlash(rel)) {
+			all = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return all, err
+}
@@ -0,0 +1,247 @@
+// Package worktree removes and moves tracked files, and cleans out untracked
+// ones. Unlike deleting or renaming files by hand, which ingest can't tell
+// apart from files that appeared and disappeared, Remove appends delete ops
+// for every line of a file and Move keeps its file ID, staging the rename
+// for the next commit.
+package worktree
+
+import (
+	"evo/internal/commits"
+	"evo/internal/everrors"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/log"
+	"evo/internal/materialize"
+	"evo/internal/platform"
+	"evo/internal/repo"
+	"evo/internal/textenc"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var logger = log.For("worktree")
+
+// ErrModified is returned when removing a file would lose uncommitted changes
+var ErrModified = everrors.New(everrors.ErrConflict, "file has uncommitted changes")
+
+// RemoveOptions control Remove
+type RemoveOptions struct {
+	Cached    bool // keep the file in the working tree, untracked
+	Recursive bool // allow directories
+	Force     bool // remove files with uncommitted changes
+}
+
+// under reports whether a tracked path is dir or inside it; "." is the root
+func under(p, dir string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// Remove stops tracking the files at or under paths, recording the deletion
+// of their content in the stream's op log, and deletes them from the working
+// tree unless opts.Cached is set, in which case they stay as untracked files.
+// Paths are relative to the repository root with forward slashes. Nothing is
+// removed if any path fails to match. It returns the removed paths.
+func Remove(repoPath, stream string, paths []string, opts RemoveOptions) ([]string, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	matched := make(map[string]bool)
+	for _, dir := range paths {
+		if _, ok := p2id[dir]; ok {
+			matched[dir] = true
+			continue
+		}
+		found := false
+		for p := range p2id {
+			if under(p, dir) {
+				found = true
+				matched[p] = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s did not match any tracked file", dir)
+		}
+		if !opts.Recursive {
+			return nil, fmt.Errorf("not removing directory %s without -r", dir)
+		}
+	}
+	removed := make([]string, 0, len(matched))
+	for p := range matched {
+		removed = append(removed, p)
+	}
+	sort.Strings(removed)
+
+	if !opts.Force {
+		for _, p := range removed {
+			modified, err := isModified(repoPath, stream, p, p2id[p])
+			if err != nil {
+				return nil, err
+			}
+			if modified {
+				return nil, fmt.Errorf("%w: %s; use -f to remove it anyway", ErrModified, p)
+			}
+		}
+	}
+
+	for _, p := range removed {
+		if _, err := ingest.RemoveFile(repoPath, stream, p2id[p]); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		delete(p2id, p)
+		if opts.Cached {
+			continue
+		}
+		abs := filepath.Join(repoPath, filepath.FromSlash(p))
+		if err := os.Remove(abs); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		pruneDirs(repoPath, filepath.Dir(abs))
+	}
+	if err := index.SaveIndex(repoPath, p2id); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+	if opts.Cached {
+		if err := index.Untrack(repoPath, removed...); err != nil {
+			return nil, fmt.Errorf("failed to record untracked files: %w", err)
+		}
+	}
+	logger.Info("removed", "stream", stream, "files", len(removed), "cached", opts.Cached)
+	return removed, nil
+}
+
+// isModified reports whether a file's content differs from its op log.
+// Large files are only compared by their stub, so they count as unmodified.
+func isModified(repoPath, stream, rel, fileID string) (bool, error) {
+	doc, err := materialize.Load(repoPath, stream, fileID)
+	if err != nil {
+		return false, err
+	}
+	if len(doc.Lines) == 1 && strings.HasPrefix(doc.Lines[0], "EVO-LFS:") {
+		return false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	text, _ := textenc.Text(data)
+	return strings.ReplaceAll(text, "\r\n", "\n") != strings.Join(doc.Lines, "\n"), nil
+}
+
+// pruneDirs removes dir and its parents while they are empty, stopping at
+// the repository root
+func pruneDirs(repoPath, dir string) {
+	root := filepath.Clean(repoPath)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Move renames the files or directories srcs to dst in the working tree
+// and the index, keeping their file IDs, and stages the renames for the
+// next commit. With several sources, or when dst is an existing directory,
+// each source moves into dst under its own name. A source already moved by
+// hand, missing with its destination present, only has the index updated.
+// Paths are relative to the repository root with forward slashes.
+func Move(repoPath, stream string, srcs []string, dst string) ([]commits.Rename, error) {
+	if repo.IsBare(repoPath) {
+		return nil, repo.ErrBare
+	}
+	p2id, _, err := index.LoadIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	abs := func(p string) string {
+		return filepath.Join(repoPath, filepath.FromSlash(p))
+	}
+	fi, err := os.Stat(abs(dst))
+	into := err == nil && fi.IsDir()
+	if len(srcs) > 1 && !into {
+		return nil, fmt.Errorf("destination %s is not a directory", dst)
+	}
+
+	type move struct{ from, to string }
+	var moves []move
+	var renames []commits.Rename
+	targets := make(map[string]bool)
+	for _, src := range srcs {
+		if src == "." {
+			return nil, fmt.Errorf("can't move the repository root")
+		}
+		to := dst
+		if into {
+			to = path.Join(dst, path.Base(src))
+		}
+		if to == src {
+			return nil, fmt.Errorf("can't move %s onto itself", src)
+		}
+		if under(to, src) {
+			return nil, fmt.Errorf("can't move %s into itself", src)
+		}
+		n := len(renames)
+		for p, fid := range p2id {
+			if !under(p, src) {
+				continue
+			}
+			target := to + strings.TrimPrefix(p, src)
+			if _, tracked := p2id[target]; tracked || targets[target] {
+				return nil, fmt.Errorf("destination %s is already tracked", target)
+			}
+			targets[target] = true
+			renames = append(renames, commits.Rename{FileID: fid, From: p, To: target})
+		}
+		if len(renames) == n {
+			return nil, fmt.Errorf("%s is not tracked", src)
+		}
+		_, errFrom := os.Lstat(abs(src))
+		_, errTo := os.Lstat(abs(to))
+		switch {
+		case errFrom == nil && errTo == nil:
+			return nil, fmt.Errorf("destination %s already exists", to)
+		case errFrom == nil:
+			moves = append(moves, move{src, to})
+		case errTo != nil:
+			return nil, fmt.Errorf("%s does not exist", src)
+		}
+	}
+
+	for _, m := range moves {
+		if err := os.MkdirAll(filepath.Dir(abs(m.to)), 0755); err != nil {
+			return nil, err
+		}
+		if err := platform.Rename(abs(m.from), abs(m.to)); err != nil {
+			return nil, fmt.Errorf("failed to move %s: %w", m.from, err)
+		}
+		pruneDirs(repoPath, filepath.Dir(abs(m.from)))
+	}
+	for _, r := range renames {
+		delete(p2id, r.From)
+	}
+	for _, r := range renames {
+		p2id[r.To] = r.FileID
+	}
+	if err := index.SaveIndex(repoPath, p2id); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+	sort.Slice(renames, func(i, j int) bool { return renames[i].From < renames[j].From })
+	for _, r := range renames {
+		if err := commits.StageRename(repoPath, stream, r); err != nil {
+			return nil, err
+		}
+	}
+	logger.Info("moved", "stream", stream, "files", len(renames))
+	return renames, nil
+}
@@ -0,0 +1,192 @@
+package worktree
+
+import (
+	"context"
+	"evo/internal/commits"
+	"evo/internal/index"
+	"evo/internal/ingest"
+	"evo/internal/materialize"
+	"evo/internal/repo"
+	"evo/internal/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func write(t *testing.T, rp, rel, content string) {
+	abs := filepath.Join(rp, rel)
+	require.NoError(t, os.MkdirAll(filepath.Dir(abs), 0755))
+	require.NoError(t, os.WriteFile(abs, []byte(content), 0644))
+}
+
+func TestRemove(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	write(t, rp, "a.txt", "one\ntwo")
+	write(t, rp, "d/x.txt", "x")
+	write(t, rp, "d/y.txt", "y")
+	testutil.Commit(t, rp, "main", "first")
+	p2id, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	aID := p2id["a.txt"]
+
+	_, err = Remove(rp, "main", []string{"d"}, RemoveOptions{})
+	assert.ErrorContains(t, err, "without -r")
+	_, err = Remove(rp, "main", []string{"nope"}, RemoveOptions{})
+	assert.ErrorContains(t, err, "nope did not match any tracked file")
+
+	write(t, rp, "a.txt", "edited")
+	_, err = Remove(rp, "main", []string{"a.txt"}, RemoveOptions{})
+	assert.ErrorIs(t, err, ErrModified)
+	assert.FileExists(t, filepath.Join(rp, "a.txt"))
+
+	removed, err := Remove(rp, "main", []string{"a.txt", "d"}, RemoveOptions{Recursive: true, Force: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "d/x.txt", "d/y.txt"}, removed)
+	assert.NoFileExists(t, filepath.Join(rp, "a.txt"))
+	assert.NoDirExists(t, filepath.Join(rp, "d"))
+	p2id, _, err = index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Empty(t, p2id)
+
+	// the deletion is ops for the next commit
+	doc, err := materialize.Load(rp, "main", aID)
+	require.NoError(t, err)
+	assert.Empty(t, doc.Lines)
+	eops, err := commits.GatherNewOps(rp, "main")
+	require.NoError(t, err)
+	assert.Len(t, eops, 4)
+}
+
+func TestRemoveCached(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	write(t, rp, "a.txt", "one")
+	testutil.Commit(t, rp, "main", "first")
+
+	_, err := Remove(rp, "main", []string{"a.txt"}, RemoveOptions{Cached: true})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(rp, "a.txt"))
+	untracked, err := index.LoadUntracked(rp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a.txt": true}, untracked)
+
+	// the file stays out of the index while it exists
+	require.NoError(t, index.UpdateIndex(rp))
+	p2id, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Empty(t, p2id)
+
+	// once gone, the path may be tracked again
+	require.NoError(t, os.Remove(filepath.Join(rp, "a.txt")))
+	require.NoError(t, index.UpdateIndex(rp))
+	untracked, err = index.LoadUntracked(rp)
+	require.NoError(t, err)
+	assert.Empty(t, untracked)
+	write(t, rp, "a.txt", "again")
+	require.NoError(t, index.UpdateIndex(rp))
+	p2id, _, err = index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Contains(t, p2id, "a.txt")
+}
+
+func TestMove(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	write(t, rp, "a.txt", "one")
+	write(t, rp, "b.txt", "bee")
+	write(t, rp, "d/x.txt", "x")
+	write(t, rp, "e/keep", "")
+	testutil.Commit(t, rp, "main", "first")
+	before, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+
+	renames, err := Move(rp, "main", []string{"a.txt"}, "c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []commits.Rename{{FileID: before["a.txt"], From: "a.txt", To: "c.txt"}}, renames)
+	assert.NoFileExists(t, filepath.Join(rp, "a.txt"))
+	assert.FileExists(t, filepath.Join(rp, "c.txt"))
+
+	// into an existing directory, and a directory elsewhere
+	_, err = Move(rp, "main", []string{"c.txt", "d"}, "e")
+	require.NoError(t, err)
+	after, _, err := index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Equal(t, before["a.txt"], after["e/c.txt"])
+	assert.Equal(t, before["d/x.txt"], after["e/d/x.txt"])
+	assert.FileExists(t, filepath.Join(rp, "e", "d", "x.txt"))
+	assert.NoDirExists(t, filepath.Join(rp, "d"))
+
+	// renames of the same file collapse, and nothing changed the content
+	staged, err := commits.LoadStagedRenames(rp, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []commits.Rename{
+		{FileID: before["a.txt"], From: "a.txt", To: "e/c.txt"},
+		{FileID: before["d/x.txt"], From: "d/x.txt", To: "e/d/x.txt"},
+	}, staged)
+	require.NoError(t, index.UpdateIndex(rp))
+	changed, err := ingest.IngestLocalChanges(context.Background(), rp, "main")
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	_, err = Move(rp, "main", []string{"b.txt"}, "e/c.txt")
+	assert.ErrorContains(t, err, "already tracked")
+	_, err = Move(rp, "main", []string{"b.txt", "e/c.txt"}, "f")
+	assert.ErrorContains(t, err, "not a directory")
+	_, err = Move(rp, "main", []string{"e"}, "e/sub")
+	assert.ErrorContains(t, err, "into itself")
+
+	// moved by hand, then recorded
+	require.NoError(t, os.Rename(filepath.Join(rp, "b.txt"), filepath.Join(rp, "bee.txt")))
+	_, err = Move(rp, "main", []string{"b.txt"}, "bee.txt")
+	require.NoError(t, err)
+	after, _, err = index.LoadIndex(rp)
+	require.NoError(t, err)
+	assert.Equal(t, before["b.txt"], after["bee.txt"])
+
+	// moving back drops the staged rename
+	_, err = Move(rp, "main", []string{"bee.txt"}, "b.txt")
+	require.NoError(t, err)
+	staged, err = commits.LoadStagedRenames(rp, "main")
+	require.NoError(t, err)
+	assert.Len(t, staged, 2)
+}
+
+func TestClean(t *testing.T) {
+	rp := t.TempDir()
+	require.NoError(t, repo.InitRepo(rp))
+	write(t, rp, ".evo-ignore", "*.log\n")
+	write(t, rp, "a.txt", "one")
+	write(t, rp, "d/x.txt", "x")
+	testutil.Commit(t, rp, "main", "first")
+	write(t, rp, "new.txt", "new")
+	write(t, rp, "app.log", "log")
+	write(t, rp, "d/stray.txt", "stray")
+	write(t, rp, "tmp/a", "a")
+	write(t, rp, "tmp/b.log", "b")
+	write(t, rp, "out/c", "c")
+	require.NoError(t, repo.InitRepo(filepath.Join(rp, "nested")))
+
+	removed, err := Clean(rp, CleanOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d/stray.txt", "new.txt"}, removed)
+	assert.FileExists(t, filepath.Join(rp, "new.txt"))
+
+	removed, err = Clean(rp, CleanOptions{DryRun: true, Dirs: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d/stray.txt", "new.txt", "out/", "tmp/a"}, removed)
+
+	removed, err = Clean(rp, CleanOptions{Dirs: true, Ignored: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.log", "d/stray.txt", "new.txt", "out/", "tmp/"}, removed)
+	for _, p := range removed {
+		assert.NoFileExists(t, filepath.Join(rp, p))
+	}
+	assert.FileExists(t, filepath.Join(rp, "a.txt"))
+	assert.FileExists(t, filepath.Join(rp, "d", "x.txt"))
+	assert.FileExists(t, filepath.Join(rp, ".evo-ignore"))
+	assert.DirExists(t, filepath.Join(rp, "nested", ".evo"))
+}